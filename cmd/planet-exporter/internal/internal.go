@@ -16,20 +16,34 @@ package internal
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
 	"net/http"
 	"net/http/pprof"
 	"os"
 	"os/signal"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	"planet-exporter/collector"
 	taskdarkstat "planet-exporter/collector/task/darkstat"
+	taskdnssnoop "planet-exporter/collector/task/dnssnoop"
 	taskebpf "planet-exporter/collector/task/ebpf"
 	taskinventory "planet-exporter/collector/task/inventory"
 	tasksocketstat "planet-exporter/collector/task/socketstat"
+	"planet-exporter/pkg/clock"
+	"planet-exporter/pkg/httpheader"
+	"planet-exporter/pkg/httpproxy"
+	"planet-exporter/pkg/network"
+	pkgprometheus "planet-exporter/pkg/prometheus"
+	"planet-exporter/pkg/ratelog"
+	"planet-exporter/pkg/shutdown"
 	"planet-exporter/server"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -45,22 +59,130 @@ type Config struct { // nolint:maligned
 	LogLevel            string
 	LogDisableTimestamp bool
 	LogDisableColors    bool
+	// DebugCollectorsEndpoint serves the registered collectors on /debug/collectors
+	DebugCollectorsEndpoint bool
+	// DebugTopTalkersEndpoint serves the darkstat top talkers on /debug/top-talkers
+	DebugTopTalkersEndpoint bool
+
+	// HTTPHeaders are set on inventory requests and darkstat/ebpf scrapes (e.g. gateway routing headers)
+	HTTPHeaders http.Header
+	// MaxResponseBytes of inventory and darkstat/ebpf scrape response bodies, unlimited if zero
+	MaxResponseBytes int64
+	// MaxScrapeMetrics processed per darkstat/ebpf scrape, the rest of the scrape is skipped, unlimited if zero
+	MaxScrapeMetrics int
+	// ScrapeMaxRetries of a darkstat/ebpf scrape failing with a network-level error, after ScrapeRetryBackoff doubled
+	// every retry
+	ScrapeMaxRetries   int
+	ScrapeRetryBackoff time.Duration
+
+	// ScrapeTLS configures darkstat/ebpf scrapes over HTTPS, server certificates are verified unless InsecureSkipVerify
+	ScrapeTLS pkgprometheus.TLSOptions
+	// ScrapeProxyURL of darkstat/ebpf scrapes and InventoryProxyURL of inventory requests: 'direct' disables the proxy,
+	// and empty uses the HTTP_PROXY, HTTPS_PROXY, and NO_PROXY environment variables
+	ScrapeProxyURL    string
+	InventoryProxyURL string
+
+	// MetricLabels are constant labels added to all planet metrics (e.g. datacenter or region)
+	MetricLabels prometheus.Labels
+
+	// LocalHostgroup and LocalDomain override the local inventory entry when it's missing from the inventory,
+	// or always when LocalHostgroupForce is set.
+	LocalHostgroup      string
+	LocalDomain         string
+	LocalHostgroupForce bool
+
+	// SkipUnlabeledDependencies drops dependency and traffic metrics without a remote hostgroup
+	SkipUnlabeledDependencies bool
+
+	// NormalizeHostgroupCase applies 'lower' or 'upper' case to hostgroup label values, disabled if empty
+	NormalizeHostgroupCase string
+
+	// InternalCIDRs are comma-separated networks whose upstreams/downstreams are internal edges, the rest are external
+	InternalCIDRs string
+
+	// KubernetesPodCIDRs and KubernetesServiceCIDRs are comma-separated networks whose remote addresses are collapsed
+	// to KubernetesPodHostgroup and KubernetesServiceHostgroup, unless the inventory has a more specific entry
+	KubernetesPodCIDRs         string
+	KubernetesPodHostgroup     string
+	KubernetesServiceCIDRs     string
+	KubernetesServiceHostgroup string
 
 	// TaskInterval between each collection of some expensive data computation
 	// in Duration format (e.g. "7s").
 	TaskInterval string
-
-	TaskDarkstatEnabled bool
-	TaskDarkstatAddr    string // DarkstatAddr url for darkstat metrics scrape
+	// TaskCollectConcurrency maximum collector tasks collecting at once within a collection tick
+	TaskCollectConcurrency int
+	// TaskJitter maximum random delay of the first collection, as a fraction of TaskInterval
+	TaskJitter float64
+
+	// ClockStepThreshold is the minimum wall clock step between collections that's logged and counted
+	ClockStepThreshold time.Duration
+
+	TaskDarkstatEnabled       bool
+	TaskDarkstatAddr          string        // DarkstatAddr url for darkstat metrics scrape
+	TaskDarkstatScrapeTimeout time.Duration // TaskDarkstatScrapeTimeout of a scrape, see scrapeTimeout if zero
+	// TaskDarkstatInterfaceLabels labels darkstat traffic with the network interface and its local IP address
+	TaskDarkstatInterfaceLabels bool
+	// TaskDarkstatClientCert and TaskDarkstatClientKey present a client certificate to darkstat instead of ScrapeTLS's
+	TaskDarkstatClientCert string
+	TaskDarkstatClientKey  string
 
 	TaskInventoryEnabled bool
 	TaskInventoryAddr    string // InventoryAddr url for inventory hostgroup mapping table data
 	TaskInventoryFormat  string // InventoryFormat returned by inventory address [jsonarray,ndjson]
-
-	TaskEbpfEnabled bool
-	TaskEbpfAddr    string // TaskEbpfAddr url for scraping the ebpf data
-
-	TaskSocketstatEnabled bool
+	TaskInventoryStrict  bool   // TaskInventoryStrict fails the inventory fetch on entries with unknown or missing required fields
+
+	// TaskInventoryTransformRules rewrite the domain or hostgroup of every inventory host, in order
+	TaskInventoryTransformRules taskinventory.TransformRulesFlag
+
+	// TaskInventoryCacheFile caches the latest fetched inventory, loaded at startup unless it's older than TaskInventoryCacheMaxAge
+	TaskInventoryCacheFile   string
+	TaskInventoryCacheMaxAge time.Duration
+
+	// TaskInventoryNATMappingFile rewrites remote addresses before the inventory lookup, reloaded on SIGHUP
+	TaskInventoryNATMappingFile string
+
+	TaskEbpfEnabled       bool
+	TaskEbpfAddr          string        // TaskEbpfAddr url for scraping the ebpf data
+	TaskEbpfScrapeTimeout time.Duration // TaskEbpfScrapeTimeout of a scrape, see scrapeTimeout if zero
+	// TaskEbpfClientCert and TaskEbpfClientKey present a client certificate to ebpf instead of ScrapeTLS's
+	TaskEbpfClientCert string
+	TaskEbpfClientKey  string
+
+	TaskSocketstatEnabled            bool
+	TaskSocketstatSampleRate         float64       // TaskSocketstatSampleRate fraction of dependency connections to export
+	TaskSocketstatDownstreamExpiry   time.Duration // TaskSocketstatDownstreamExpiry suppresses downstreams without a new connection within this duration
+	TaskSocketstatHistoryTTL         time.Duration // TaskSocketstatHistoryTTL how long dependency first/last seen time is remembered since last seen
+	TaskSocketstatHistoryMaxEntries  int           // TaskSocketstatHistoryMaxEntries maximum dependencies whose first/last seen time is remembered
+	TaskSocketstatProcessScanTimeout time.Duration // TaskSocketstatProcessScanTimeout of the process table scan naming the process of each connection
+
+	// TaskSocketstatDropTimeWaitOnly drops dependencies whose sockets are all in TIME_WAIT
+	TaskSocketstatDropTimeWaitOnly bool
+
+	// TaskSocketstatUnixSocketListeners exports the processes listening on Unix sockets
+	TaskSocketstatUnixSocketListeners bool
+
+	// TaskSocketstatUpstreamConnectionsHistogram exports a histogram of the concurrent sockets per upstream,
+	// with TaskSocketstatUpstreamConnectionsBuckets as comma-separated bucket upper bounds
+	TaskSocketstatUpstreamConnectionsHistogram bool
+	TaskSocketstatUpstreamConnectionsBuckets   string
+
+	// TaskSocketstatEphemeralInterval between polls for short-lived connections, disabled if zero
+	TaskSocketstatEphemeralInterval time.Duration
+	// TaskSocketstatEphemeralMaxEntries maximum short-lived dependencies remembered
+	TaskSocketstatEphemeralMaxEntries int
+	// TaskSocketstatLookupWorkers goroutines looking up connection addresses in the inventory
+	TaskSocketstatLookupWorkers int
+	// TaskSocketstatStaticDependenciesFile declares dependencies exported alongside the live ones, reloaded on SIGHUP
+	TaskSocketstatStaticDependenciesFile string
+	// TaskSocketstatTrafficPorts are comma-separated ports whose TCP socket byte counters are exported as traffic,
+	// disabled if empty
+	TaskSocketstatTrafficPorts string
+
+	TaskDnssnoopEnabled    bool
+	TaskDnssnoopSource     string // TaskDnssnoopSource of DNS query logs [dnsmasq]
+	TaskDnssnoopLogPath    string // TaskDnssnoopLogPath of the DNS query log file read by the source
+	TaskDnssnoopTopDomains int    // TaskDnssnoopTopDomains most queried domains exported
 }
 
 // Service contains main service dependency.
@@ -84,17 +206,141 @@ func (s Service) Run(ctx context.Context) error {
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
+	// An enabled task without an address would only log an error on every collection, producing no data
+	if err := validateTaskAddrs(s.Config); err != nil {
+		return err
+	}
+
 	// Run collector tasks in background
 	log.Infof("Set task ticker duration to %v", s.Config.TaskInterval)
 	interval, err := time.ParseDuration(s.Config.TaskInterval)
 	if err != nil {
 		return fmt.Errorf("error parsing interval duration: %w", err)
 	}
-	go s.collect(ctx, interval)
+	if math.IsNaN(s.Config.TaskJitter) || s.Config.TaskJitter < 0 || s.Config.TaskJitter > 1 {
+		return fmt.Errorf("task jitter %v isn't between 0.0 and 1.0", s.Config.TaskJitter)
+	}
+	hostgroupCase, err := taskinventory.ParseHostgroupCase(s.Config.NormalizeHostgroupCase)
+	if err != nil {
+		return fmt.Errorf("error parsing hostgroup case: %w", err)
+	}
+	taskinventory.SetHostgroupCase(hostgroupCase)
+	taskinventory.SetTransformRules(s.Config.TaskInventoryTransformRules)
+	if s.Config.TaskInventoryEnabled && s.Config.TaskInventoryCacheFile != "" {
+		taskinventory.SetCacheFile(s.Config.TaskInventoryCacheFile, s.Config.TaskInventoryCacheMaxAge)
+		// An unreadable cache only leaves the inventory empty until the first collection, as without a cache
+		if err := taskinventory.LoadCacheFile(); err != nil {
+			log.Warnf("Failed to load inventory cache: %v", err)
+		}
+	}
+	internalCIDRs, err := network.ParseCIDRs(s.Config.InternalCIDRs)
+	if err != nil {
+		return fmt.Errorf("error parsing internal CIDRs: %w", err)
+	}
+	tasksocketstat.SetInternalCIDRs(internalCIDRs)
+	if s.Config.TaskSocketstatUpstreamConnectionsHistogram {
+		buckets, err := tasksocketstat.ParseBuckets(s.Config.TaskSocketstatUpstreamConnectionsBuckets)
+		if err != nil {
+			return fmt.Errorf("error parsing upstream connections histogram buckets: %w", err)
+		}
+		tasksocketstat.SetUpstreamConnectionsHistogram(buckets)
+	}
+	if s.Config.TaskSocketstatTrafficPorts != "" {
+		ports, err := tasksocketstat.ParsePorts(s.Config.TaskSocketstatTrafficPorts)
+		if err != nil {
+			return fmt.Errorf("error parsing socketstat traffic ports: %w", err)
+		}
+		log.Infof("Task Socketstat traffic ports: %v", ports)
+		tasksocketstat.SetTrafficPorts(ports)
+	}
+	kubernetesPodCIDRs, err := network.ParseCIDRs(s.Config.KubernetesPodCIDRs)
+	if err != nil {
+		return fmt.Errorf("error parsing Kubernetes pod CIDRs: %w", err)
+	}
+	kubernetesServiceCIDRs, err := network.ParseCIDRs(s.Config.KubernetesServiceCIDRs)
+	if err != nil {
+		return fmt.Errorf("error parsing Kubernetes service CIDRs: %w", err)
+	}
+	taskinventory.SetKubernetesNetworks(kubernetesPodCIDRs, s.Config.KubernetesPodHostgroup,
+		kubernetesServiceCIDRs, s.Config.KubernetesServiceHostgroup)
+	if err := taskinventory.LoadNATMapping(s.Config.TaskInventoryNATMappingFile); err != nil {
+		return fmt.Errorf("error loading NAT mapping: %w", err)
+	}
+	if err := tasksocketstat.LoadStaticDependencies(s.Config.TaskSocketstatStaticDependenciesFile); err != nil {
+		return fmt.Errorf("error loading static dependencies: %w", err)
+	}
+	go s.reloadOnSIGHUP(ctx)
+	scrapeTLSConfig, err := pkgprometheus.NewTLSConfig(s.Config.ScrapeTLS)
+	if err != nil {
+		return fmt.Errorf("error loading scrape TLS config: %w", err)
+	}
+	if s.Config.ScrapeTLS.InsecureSkipVerify {
+		log.Warn("Darkstat/ebpf scrapes over HTTPS skip server certificate verification")
+	}
+
+	if s.Config.ScrapeMaxRetries < 0 || s.Config.ScrapeRetryBackoff < 0 {
+		return fmt.Errorf("scrape max retries %v and retry backoff %v can't be negative", s.Config.ScrapeMaxRetries, s.Config.ScrapeRetryBackoff)
+	}
+	scrapeProxy, err := httpproxy.Func(s.Config.ScrapeProxyURL)
+	if err != nil {
+		return fmt.Errorf("error parsing scrape proxy URL: %w", err)
+	}
+	// Darkstat and ebpf are initialized before collecting, so an invalid client certificate fails right away
+	log.Infof("Task Darkstat: %v", s.Config.TaskDarkstatEnabled)
+	if err := taskdarkstat.InitTask(ctx, s.Config.TaskDarkstatEnabled, s.Config.TaskDarkstatAddr, s.Config.HTTPHeaders, s.Config.MaxResponseBytes,
+		s.Config.MaxScrapeMetrics, scrapeTLSConfig, s.Config.TaskDarkstatClientCert, s.Config.TaskDarkstatClientKey, scrapeProxy,
+		scrapeTimeout(s.Config.TaskDarkstatScrapeTimeout, interval), s.Config.ScrapeMaxRetries, s.Config.ScrapeRetryBackoff); err != nil {
+		return fmt.Errorf("error initializing darkstat task: %w", err)
+	}
+	log.Infof("Task EBPF: %v", s.Config.TaskEbpfEnabled)
+	if err := taskebpf.InitTask(ctx, s.Config.TaskEbpfEnabled, s.Config.TaskEbpfAddr, s.Config.HTTPHeaders, s.Config.MaxResponseBytes,
+		s.Config.MaxScrapeMetrics, scrapeTLSConfig, s.Config.TaskEbpfClientCert, s.Config.TaskEbpfClientKey, scrapeProxy,
+		scrapeTimeout(s.Config.TaskEbpfScrapeTimeout, interval), s.Config.ScrapeMaxRetries, s.Config.ScrapeRetryBackoff); err != nil {
+		return fmt.Errorf("error initializing ebpf task: %w", err)
+	}
+	inventoryProxy, err := httpproxy.Func(s.Config.InventoryProxyURL)
+	if err != nil {
+		return fmt.Errorf("error parsing inventory proxy URL: %w", err)
+	}
+	taskinventory.SetProxy(inventoryProxy)
+
+	var dnsSource taskdnssnoop.Source
+	if s.Config.TaskDnssnoopEnabled {
+		dnsSource, err = taskdnssnoop.NewSource(s.Config.TaskDnssnoopSource, s.Config.TaskDnssnoopLogPath)
+		if err != nil {
+			return fmt.Errorf("error initializing DNS query log source: %w", err)
+		}
+	}
+
+	clockSteps := clock.NewStepDetector(s.Config.ClockStepThreshold)
+	tickDrift := clock.NewTickDrift(interval)
+	go s.collect(ctx, interval, dnsSource, clockSteps, tickDrift)
 
 	promRegistry := prometheus.NewRegistry()
 	promRegistry.MustRegister(version.NewCollector("planet_exporter"))
-	if err := promRegistry.Register(s.Collector); err != nil {
+	promRegistry.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{ // nolint:exhaustivestruct
+		Name: "planet_clock_steps_total",
+		Help: "Wall clock steps (e.g. NTP corrections) detected between task collections",
+	}, func() float64 {
+		return float64(clockSteps.Steps())
+	}))
+	promRegistry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{ // nolint:exhaustivestruct
+		Name: "planet_task_tick_drift_seconds",
+		Help: "Delay between the scheduled and the actual start of the latest task collection tick",
+	}, func() float64 {
+		return tickDrift.Drift().Seconds()
+	}))
+	promRegistry.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{ // nolint:exhaustivestruct
+		Name: "planet_task_ticks_skipped_total",
+		Help: "Task collection ticks skipped because the previous tick ran longer than the task interval",
+	}, func() float64 {
+		return float64(tickDrift.Skipped())
+	}))
+	promRegistry.MustRegister(taskPanicsTotal)
+	if len(s.Config.MetricLabels) > 0 {
+		log.Infof("Add constant labels to planet metrics: %v", collector.ConstLabelsFlag(s.Config.MetricLabels))
+	}
+	if err := prometheus.WrapRegistererWith(s.Config.MetricLabels, promRegistry).Register(s.Collector); err != nil {
 		return fmt.Errorf("failed to register planet collector: %w", err)
 	}
 
@@ -105,6 +351,7 @@ func (s Service) Run(ctx context.Context) error {
 				<body>
 				<h1>Planet Exporter</h1>
 				<p><a href="/metrics">Metrics</a></p>
+				<p><a href="/api/v1/dependencies">Dependencies</a></p>
 				</body>
 			</html>
 		`))
@@ -118,14 +365,21 @@ func (s Service) Run(ctx context.Context) error {
 			ErrorHandling: promhttp.ContinueOnError,
 		},
 	))
+	handler.HandleFunc("/api/v1/dependencies", dependenciesHandler)
 	handler.HandleFunc("/debug/pprof/", pprof.Index)
+	if s.Config.DebugCollectorsEndpoint {
+		handler.HandleFunc("/debug/collectors", collectorsHandler)
+	}
+	if s.Config.DebugTopTalkersEndpoint {
+		handler.HandleFunc("/debug/top-talkers", topTalkersHandler)
+	}
 	httpServer := server.New(handler)
 
 	// Capture signals and graceful exit mechanism
 	stopChan := make(chan struct{})
 	go func() {
 		signals := make(chan os.Signal, 2)
-		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		shutdown.Notify(signals)
 		select {
 		case <-signals:
 			log.Info("Gracefully stop HTTP server")
@@ -148,53 +402,208 @@ func (s Service) Run(ctx context.Context) error {
 	return nil
 }
 
-// collect periodically runs all collector tasks that are expensive to compute on-the-fly.
-func (s Service) collect(ctx context.Context, interval time.Duration) {
-	const inventoryTickerIntervalSeconds = 25
+// validateTaskAddrs returns an error if an enabled task that scrapes or fetches an address has none.
+func validateTaskAddrs(config Config) error {
+	switch {
+	case config.TaskDarkstatEnabled && config.TaskDarkstatAddr == "":
+		return fmt.Errorf("%w: -task-darkstat-enabled requires -task-darkstat-addr", taskdarkstat.ErrEmptyDarkstatAddr)
+	case config.TaskEbpfEnabled && config.TaskEbpfAddr == "":
+		return fmt.Errorf("%w: -task-ebpf-enabled requires -task-ebpf-addr", taskebpf.ErrEmptyEBPFAddr)
+	case config.TaskInventoryEnabled && config.TaskInventoryAddr == "":
+		return fmt.Errorf("%w: -task-inventory-enabled requires -task-inventory-addr", taskinventory.ErrEmptyInventoryAddr)
+	}
 
-	inventoryTicker := time.NewTicker(interval * inventoryTickerIntervalSeconds)
-	defaultTicker := time.NewTicker(interval)
-	defer inventoryTicker.Stop()
-	defer defaultTicker.Stop()
+	return nil
+}
 
-	log.Info("Initialize collector tasks")
+// dependenciesResponse is the response of the dependencies API.
+type dependenciesResponse struct {
+	Dependencies []tasksocketstat.Dependency `json:"dependencies"`
+}
 
-	log.Infof("Task Darkstat: %v", s.Config.TaskDarkstatEnabled)
-	taskdarkstat.InitTask(ctx, s.Config.TaskDarkstatEnabled, s.Config.TaskDarkstatAddr)
+// dependenciesHandler serves the latest socketstat dependencies, along with when they were first and last seen.
+func dependenciesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(dependenciesResponse{
+		Dependencies: tasksocketstat.GetDependencies(),
+	}); err != nil {
+		log.Errorf("Error writing response: %v", err)
+	}
+}
 
-	log.Infof("Task EBPF: %v", s.Config.TaskEbpfEnabled)
-	taskebpf.InitTask(ctx, s.Config.TaskEbpfEnabled, s.Config.TaskEbpfAddr)
+// collectorsResponse is the response of the collectors debug endpoint.
+type collectorsResponse struct {
+	Collectors []string `json:"collectors"`
+}
+
+// collectorsHandler serves the names of the registered collectors.
+func collectorsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(collectorsResponse{
+		Collectors: collector.RegisteredCollectorNames(),
+	}); err != nil {
+		log.Errorf("Error writing response: %v", err)
+	}
+}
+
+// defaultTopTalkers is the number of top talkers served if the request doesn't set n.
+const defaultTopTalkers = 10
+
+// topTalkersResponse is the response of the top talkers debug endpoint.
+type topTalkersResponse struct {
+	TopTalkers []taskdarkstat.TopTalker `json:"top_talkers"`
+}
+
+// topTalkersHandler serves the n (query param, 10 by default) remote hostgroups with the most bytes in the latest
+// darkstat metrics, ordered by the bytes of the direction query param (ingress or egress), or of both directions.
+func topTalkersHandler(w http.ResponseWriter, r *http.Request) {
+	n := defaultTopTalkers
+	if param := r.URL.Query().Get("n"); param != "" {
+		var err error
+		if n, err = strconv.Atoi(param); err != nil || n < 0 {
+			http.Error(w, fmt.Sprintf("invalid n %q, expected a non-negative integer", param), http.StatusBadRequest)
+
+			return
+		}
+	}
+	topTalkers, err := taskdarkstat.TopTalkers(taskdarkstat.Get(), n, r.URL.Query().Get("direction"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(topTalkersResponse{
+		TopTalkers: topTalkers,
+	}); err != nil {
+		log.Errorf("Error writing response: %v", err)
+	}
+}
+
+// reloadOnSIGHUP reloads the NAT mapping and static dependencies files on SIGHUP, keeping the previous ones if
+// they're invalid.
+func (s Service) reloadOnSIGHUP(ctx context.Context) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	defer signal.Stop(signals)
+
+	for {
+		select {
+		case <-signals:
+			log.Info("Reload NAT mapping")
+			if err := taskinventory.LoadNATMapping(s.Config.TaskInventoryNATMappingFile); err != nil {
+				log.Errorf("Failed to reload NAT mapping: %v", err)
+			}
+			log.Info("Reload static dependencies")
+			if err := tasksocketstat.LoadStaticDependencies(s.Config.TaskSocketstatStaticDependenciesFile); err != nil {
+				log.Errorf("Failed to reload static dependencies: %v", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// collect periodically runs all collector tasks that are expensive to compute on-the-fly.
+// The darkstat and ebpf tasks are initialized by Run.
+// The dnssnoop task reads DNS queries from dnsSource, nil if it's disabled.
+// Wall clock steps between default collections are detected by clockSteps. The tasks measure durations
+// and expiries with the monotonic clock, so a step only shifts the timestamps they report.
+// The drift and skipped ticks of default collections are tracked by tickDrift.
+func (s Service) collect(ctx context.Context, interval time.Duration, dnsSource taskdnssnoop.Source, clockSteps *clock.StepDetector,
+	tickDrift *clock.TickDrift) {
+	const inventoryTickerIntervalSeconds = 25
+
+	log.Info("Initialize collector tasks")
+	if len(s.Config.HTTPHeaders) > 0 {
+		log.Infof("Custom HTTP headers: %v", httpheader.Redacted(s.Config.HTTPHeaders))
+	}
 
 	log.Infof("Task Inventory: %v", s.Config.TaskInventoryEnabled)
-	taskinventory.InitTask(ctx, s.Config.TaskInventoryEnabled, s.Config.TaskInventoryAddr, s.Config.TaskInventoryFormat)
+	taskinventory.SetLocalOverride(s.Config.LocalHostgroup, s.Config.LocalDomain, s.Config.LocalHostgroupForce)
+	taskinventory.InitTask(ctx, s.Config.TaskInventoryEnabled, s.Config.TaskInventoryAddr, s.Config.TaskInventoryFormat, s.Config.TaskInventoryStrict,
+		s.Config.HTTPHeaders, s.Config.MaxResponseBytes)
+
+	if !s.Config.TaskInventoryEnabled && (s.Config.TaskSocketstatEnabled || s.Config.TaskDarkstatEnabled || s.Config.TaskEbpfEnabled) {
+		if s.Config.SkipUnlabeledDependencies {
+			log.Warn("Dependency metrics will be dropped unless the NAT mapping labels them, because inventory is disabled (see -task-inventory-enabled)")
+		} else {
+			log.Warn("Dependency metrics will lack hostgroup labels because inventory is disabled (see -task-inventory-enabled)")
+		}
+	}
+	collector.SetSkipUnlabeledDependencies(s.Config.SkipUnlabeledDependencies)
+	collector.SetTrafficInterfaceLabels(s.Config.TaskDarkstatInterfaceLabels)
 
 	log.Infof("Task Socketstat: %v", s.Config.TaskSocketstatEnabled)
-	tasksocketstat.InitTask(ctx, s.Config.TaskSocketstatEnabled)
+	tasksocketstat.InitTask(ctx, s.Config.TaskSocketstatEnabled, s.Config.TaskSocketstatSampleRate, s.Config.TaskSocketstatDownstreamExpiry,
+		s.Config.TaskSocketstatHistoryTTL, s.Config.TaskSocketstatHistoryMaxEntries, s.Config.TaskSocketstatProcessScanTimeout)
+	tasksocketstat.SetLookupWorkers(s.Config.TaskSocketstatLookupWorkers)
+	tasksocketstat.SetUnixSocketListeners(s.Config.TaskSocketstatUnixSocketListeners)
+	tasksocketstat.SetDropTimeWaitOnly(s.Config.TaskSocketstatDropTimeWaitOnly)
+
+	log.Infof("Task Dnssnoop: %v", s.Config.TaskDnssnoopEnabled)
+	taskdnssnoop.InitTask(ctx, s.Config.TaskDnssnoopEnabled, dnsSource, s.Config.TaskDnssnoopTopDomains)
+
+	// Polls for short-lived connections are only ticking when enabled
+	var ephemeralTickerC <-chan time.Time
+	if s.Config.TaskSocketstatEnabled && s.Config.TaskSocketstatEphemeralInterval > 0 {
+		log.Infof("Task Socketstat ephemeral connections poll interval: %v", s.Config.TaskSocketstatEphemeralInterval)
+		tasksocketstat.SetEphemeralEdges(s.Config.TaskSocketstatEphemeralMaxEntries)
+		ephemeralTicker := time.NewTicker(s.Config.TaskSocketstatEphemeralInterval)
+		defer ephemeralTicker.Stop()
+		ephemeralTickerC = ephemeralTicker.C
+	}
+
+	inventoryErrLog := ratelog.New(ratelog.DefaultInterval)
+	darkstatErrLog := ratelog.New(ratelog.DefaultInterval)
+	ebpfErrLog := ratelog.New(ratelog.DefaultInterval)
+	socketstatErrLog := ratelog.New(ratelog.DefaultInterval)
+	socketstatEphemeralErrLog := ratelog.New(ratelog.DefaultInterval)
+	dnssnoopErrLog := ratelog.New(ratelog.DefaultInterval)
+
+	inventoryTask := collectorTask{name: "Inventory", collect: taskinventory.Collect, errLog: inventoryErrLog}
+	socketstatEphemeralTask := collectorTask{name: "Socketstat ephemeral", collect: tasksocketstat.CollectEphemeral, errLog: socketstatEphemeralErrLog}
+	defaultTasks := []collectorTask{
+		{name: "Darkstat", collect: taskdarkstat.Collect, errLog: darkstatErrLog},
+		{name: "EBPF", collect: taskebpf.Collect, errLog: ebpfErrLog},
+		{name: "Socketstat", collect: tasksocketstat.Collect, errLog: socketstatErrLog},
+		{name: "Dnssnoop", collect: taskdnssnoop.Collect, errLog: dnssnoopErrLog},
+	}
+	log.Infof("Run up to %v collector tasks at once", s.Config.TaskCollectConcurrency)
 
 	fInventory := func() {
-		err := taskinventory.Collect(ctx)
-		if err != nil {
-			log.Errorf("Inventory collect failed: %v", err)
-		}
+		collectTasks(ctx, []collectorTask{inventoryTask}, 1)
 	}
-	fDefault := func() {
-		err := taskdarkstat.Collect(ctx)
-		if err != nil {
-			log.Errorf("Darkstat collect failed: %v", err)
+	fDefault := func(scheduled time.Time) {
+		started := time.Now()
+		if step := clockSteps.Observe(started); step != 0 {
+			log.Warnf("Wall clock stepped by %v since the previous collection, reported timestamps may jump", step)
 		}
-		err = taskebpf.Collect(ctx)
-		if err != nil {
-			log.Errorf("EBPF collect failed: %v", err)
+		if drift, skipped := tickDrift.Observe(scheduled, started); skipped > 0 {
+			log.Warnf("Skipped %v collection ticks, the previous collection ran longer than the task interval (drift: %v)", skipped, drift)
 		}
-		err = tasksocketstat.Collect(ctx)
-		if err != nil {
-			log.Errorf("Socketstat collect failed: %v", err)
+		collectTasks(ctx, defaultTasks, s.Config.TaskCollectConcurrency)
+	}
+
+	// Exporters started at once don't tick in sync against the shared inventory/darkstat backends
+	if jitter := clock.Jitter(interval, s.Config.TaskJitter); jitter > 0 {
+		log.Infof("Delay the first collection by %v of jitter", jitter)
+		select {
+		case <-time.After(jitter):
+		case <-ctx.Done():
+			return
 		}
 	}
 
+	inventoryTicker := time.NewTicker(interval * inventoryTickerIntervalSeconds)
+	defaultTicker := time.NewTicker(interval)
+	defer inventoryTicker.Stop()
+	defer defaultTicker.Stop()
+
 	// Trigger once
 	fInventory()
-	fDefault()
+	fDefault(time.Now())
 
 	for {
 		select {
@@ -202,12 +611,89 @@ func (s Service) collect(ctx context.Context, interval time.Duration) {
 			log.Debugf("Start inventory collect tick")
 			fInventory()
 
-		case <-defaultTicker.C:
+		case scheduled := <-defaultTicker.C:
 			log.Debugf("Start default collect tick")
-			fDefault()
+			fDefault(scheduled)
+
+		case <-ephemeralTickerC:
+			collectTasks(ctx, []collectorTask{socketstatEphemeralTask}, 1)
 
 		case <-ctx.Done():
 			return
 		}
 	}
 }
+
+// scrapeTimeout returns the configured darkstat/ebpf scrape timeout, or if it's zero, the task interval or
+// the default scrape timeout, whichever is smaller, so a scrape doesn't overlap the next collection.
+func scrapeTimeout(configured, interval time.Duration) time.Duration {
+	if configured > 0 {
+		return configured
+	}
+	if interval < taskdarkstat.DefaultScrapeTimeout {
+		return interval
+	}
+
+	return taskdarkstat.DefaultScrapeTimeout
+}
+
+// taskPanicsTotal counts the panics recovered from collector tasks.
+var taskPanicsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{ // nolint:exhaustivestruct
+	Name: "planet_task_panics_total",
+	Help: "Panics recovered from collector task collections",
+}, []string{"task"})
+
+// errTaskPanicked a collector task panicked during its collection.
+var errTaskPanicked = errors.New("task panicked")
+
+// collectorTask is a collector task's collect function, with the limiter of its failure logs.
+type collectorTask struct {
+	name    string
+	collect func(context.Context) error
+	errLog  *ratelog.Limiter
+}
+
+// collectTasks runs the collection of the tasks concurrently, at most concurrency at once, and returns once they're
+// all done. A slow task doesn't delay the others, and a panicking task is recovered so it doesn't stop the collections.
+func collectTasks(ctx context.Context, tasks []collectorTask, concurrency int) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	slots := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for _, task := range tasks {
+		slots <- struct{}{}
+		wg.Add(1)
+		go func(task collectorTask) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			collectTask(task.name, runTask(ctx, task), task.errLog)
+		}(task)
+	}
+	wg.Wait()
+}
+
+// runTask runs a task's collection, returning a recovered panic as an error.
+func runTask(ctx context.Context, task collectorTask) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			taskPanicsTotal.WithLabelValues(strings.ToLower(strings.ReplaceAll(task.name, " ", "_"))).Inc()
+			err = fmt.Errorf("%w: %v\n%s", errTaskPanicked, r, debug.Stack())
+		}
+	}()
+
+	return task.collect(ctx)
+}
+
+// collectTask logs the result of a collector task, rate-limiting repeated failures until the task recovers.
+func collectTask(name string, err error, errLog *ratelog.Limiter) {
+	if err != nil {
+		errLog.Errorf("%v collect failed: %v", name, err)
+
+		return
+	}
+	if errLog.Reset() {
+		log.Infof("%v collect recovered", name)
+	}
+}
@@ -0,0 +1,210 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"planet-exporter/pkg/ratelog"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// errMockTask is returned by a mock collector task that fails.
+var errMockTask = errors.New("mock task error")
+
+// newMockTask returns a collector task named name that collects with collect.
+func newMockTask(name string, collect func(context.Context) error) collectorTask {
+	return collectorTask{name: name, collect: collect, errLog: ratelog.New(ratelog.DefaultInterval)}
+}
+
+// waitFor polls until condition is true, failing the test if it isn't within a few seconds.
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %v", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestRunTask(t *testing.T) {
+	tests := []struct {
+		name       string
+		collect    func(context.Context) error
+		wantErr    error
+		wantPanics float64
+	}{
+		{name: "Success", collect: func(context.Context) error { return nil }},
+		{name: "Failure", collect: func(context.Context) error { return errMockTask }, wantErr: errMockTask},
+		{name: "Panic", collect: func(context.Context) error { panic("mock task panic") }, wantErr: errTaskPanicked, wantPanics: 1},
+	}
+	for _, testcase := range tests {
+		t.Run(testcase.name, func(t *testing.T) {
+			// The panics counter is labeled with the task name in lowercase and with underscores
+			task := newMockTask("Run task "+testcase.name, testcase.collect)
+			panics := taskPanicsTotal.WithLabelValues("run_task_" + strings.ToLower(testcase.name))
+			before := testutil.ToFloat64(panics)
+
+			err := runTask(context.Background(), task)
+			if !errors.Is(err, testcase.wantErr) || (testcase.wantErr == nil && err != nil) {
+				t.Errorf("runTask() error = %v, want %v", err, testcase.wantErr)
+			}
+			if got := testutil.ToFloat64(panics) - before; got != testcase.wantPanics {
+				t.Errorf("planet_task_panics_total increase = %v, want %v", got, testcase.wantPanics)
+			}
+		})
+	}
+}
+
+func TestCollectTasks_panic(t *testing.T) {
+	panics := taskPanicsTotal.WithLabelValues("panicking")
+	before := testutil.ToFloat64(panics)
+
+	var collected atomic.Int32
+	collect := func(context.Context) error {
+		collected.Add(1)
+
+		return nil
+	}
+	tasks := []collectorTask{
+		newMockTask("First", collect),
+		newMockTask("Panicking", func(context.Context) error { panic("mock task panic") }),
+		newMockTask("Second", collect),
+		newMockTask("Third", collect),
+	}
+
+	// A panicking task is recovered, so it neither crashes the exporter nor stops the other tasks
+	collectTasks(context.Background(), tasks, 2)
+
+	if got := collected.Load(); got != 3 {
+		t.Errorf("collectTasks() collected %v tasks, want the 3 tasks that didn't panic", got)
+	}
+	if got := testutil.ToFloat64(panics) - before; got != 1 {
+		t.Errorf("planet_task_panics_total{task=\"panicking\"} increase = %v, want 1", got)
+	}
+}
+
+func TestCollectTasks_blockingTask(t *testing.T) {
+	release := make(chan struct{})
+	var collected atomic.Int32
+	tasks := []collectorTask{
+		newMockTask("Blocking", func(context.Context) error {
+			<-release
+
+			return nil
+		}),
+	}
+	for _, name := range []string{"First", "Second", "Third"} {
+		tasks = append(tasks, newMockTask(name, func(context.Context) error {
+			collected.Add(1)
+
+			return nil
+		}))
+	}
+
+	done := make(chan struct{})
+	go func() {
+		collectTasks(context.Background(), tasks, 2)
+		close(done)
+	}()
+
+	// The other tasks complete in the remaining slot while the blocking task holds its own
+	waitFor(t, "the tasks that don't block", func() bool { return collected.Load() == 3 })
+	select {
+	case <-done:
+		t.Fatalf("collectTasks() returned before the blocking task is done")
+	default:
+	}
+
+	close(release)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("collectTasks() didn't return once every task is done")
+	}
+}
+
+func TestCollectTasks_concurrency(t *testing.T) {
+	const concurrency = 2
+
+	release := make(chan struct{})
+	var mu sync.Mutex
+	running, maxRunning, collected := 0, 0, 0
+	blocking := func(context.Context) error {
+		mu.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mu.Unlock()
+
+		<-release
+
+		mu.Lock()
+		running--
+		collected++
+		mu.Unlock()
+
+		return nil
+	}
+	tasks := []collectorTask{}
+	for _, name := range []string{"First", "Second", "Third", "Fourth", "Fifth"} {
+		tasks = append(tasks, newMockTask(name, blocking))
+	}
+
+	done := make(chan struct{})
+	go func() {
+		collectTasks(context.Background(), tasks, concurrency)
+		close(done)
+	}()
+
+	waitFor(t, "the tasks to fill the concurrency slots", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return running == concurrency
+	})
+	// No other task starts while the slots are taken
+	time.Sleep(50 * time.Millisecond)
+	mu.Lock()
+	if running != concurrency {
+		t.Errorf("collectTasks() runs %v tasks at once, want %v", running, concurrency)
+	}
+	mu.Unlock()
+
+	close(release)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("collectTasks() didn't return once every task is done")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if maxRunning != concurrency || collected != len(tasks) {
+		t.Errorf("collectTasks() ran at most %v tasks at once and collected %v, want %v and %v",
+			maxRunning, collected, concurrency, len(tasks))
+	}
+}
@@ -18,11 +18,23 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"net/http"
 	"os"
+	"time"
 
 	"planet-exporter/cmd/planet-exporter/internal"
 	"planet-exporter/collector"
-
+	taskdnssnoop "planet-exporter/collector/task/dnssnoop"
+	taskinventory "planet-exporter/collector/task/inventory"
+	tasksocketstat "planet-exporter/collector/task/socketstat"
+	"planet-exporter/pkg/bodylimit"
+	"planet-exporter/pkg/clock"
+	"planet-exporter/pkg/httpheader"
+	"planet-exporter/pkg/httpproxy"
+	"planet-exporter/pkg/printconfig"
+	pkgprometheus "planet-exporter/pkg/prometheus"
+
+	"github.com/prometheus/client_golang/prometheus"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -30,30 +42,110 @@ var version string
 
 func main() {
 	var config internal.Config
+	config.HTTPHeaders = http.Header{}
+	config.MetricLabels = prometheus.Labels{}
 
 	var showVersionAndExit bool
 
+	// printConfigAndExit prints the effective config with secrets redacted, then exits (e.g. to debug a deployment)
+	var printConfigAndExit bool
+	var printConfigFormat string
+
+	// validateInventoryAndExit fetches and parses the inventory, then exits (e.g. as a CI preflight check)
+	var validateInventoryAndExit bool
+
+	const (
+		defaultSocketstatHistoryTTL        = 24 * time.Hour
+		defaultSocketstatHistoryMaxEntries = 10000
+
+		defaultSocketstatEphemeralMaxEntries = 1000
+
+		defaultTaskCollectConcurrency = 4
+		defaultTaskJitter             = 0.1
+	)
+
 	// Main
 	flag.StringVar(&config.ListenAddress, "listen-address", "0.0.0.0:19100", "Address to which exporter will bind its HTTP interface")
 	flag.StringVar(&config.LogLevel, "log-level", "info", "Log level")
 	flag.BoolVar(&config.LogDisableTimestamp, "log-disable-timestamp", false, "Disable timestamp on logger")
 	flag.BoolVar(&config.LogDisableColors, "log-disable-colors", false, "Disable colors on logger")
 	flag.BoolVar(&showVersionAndExit, "version", false, "Show version and exit")
+	flag.BoolVar(&printConfigAndExit, "print-config", false, "Print the effective configuration (after parsing every flag) in -print-config-format with secrets redacted, and exit")
+	flag.StringVar(&printConfigFormat, "print-config-format", printconfig.FormatYAML, "Format of -print-config, 'yaml' or 'json'")
+	flag.BoolVar(&config.DebugCollectorsEndpoint, "debug-collectors-endpoint", false, "Serve the names of the registered collectors as JSON on /debug/collectors")
+	flag.BoolVar(&config.DebugTopTalkersEndpoint, "debug-top-talkers-endpoint", false, "Serve the top talkers of the latest darkstat metrics (remote hostgroups, or IP addresses without hostgroup) as JSON on /debug/top-talkers")
+	flag.BoolVar(&validateInventoryAndExit, "validate-inventory", false, "Fetch and parse the inventory from -task-inventory-addr with -task-inventory-format, print a summary, and exit non-zero if there are no usable hosts")
+	flag.StringVar(&config.LocalHostgroup, "local-hostgroup", "", "Hostgroup of this machine when it's missing from the inventory")
+	flag.StringVar(&config.LocalDomain, "local-domain", "", "Domain of this machine when it's missing from the inventory")
+	flag.BoolVar(&config.LocalHostgroupForce, "local-hostgroup-force", false, "Use -local-hostgroup and -local-domain even when this machine exists in the inventory")
+	flag.BoolVar(&config.SkipUnlabeledDependencies, "skip-unlabeled-dependencies", false, "Drop dependency and traffic metrics whose remote address has no hostgroup (e.g. missing from the inventory)")
+	flag.StringVar(&config.NormalizeHostgroupCase, "normalize-hostgroup-case", "", "Normalize hostgroup label values to 'lower' or 'upper' case, disabled if empty")
+	flag.StringVar(&config.InternalCIDRs, "internal-cidrs", "", "Comma-separated CIDRs of internal networks, tagging upstreams/downstreams with edge_scope 'internal' or 'external'")
+	flag.StringVar(&config.KubernetesPodCIDRs, "kubernetes-pod-cidrs", "", "Comma-separated Kubernetes pod CIDRs, remote addresses in them are collapsed to -kubernetes-pod-hostgroup unless the inventory has a more specific entry")
+	flag.StringVar(&config.KubernetesPodHostgroup, "kubernetes-pod-hostgroup", taskinventory.DefaultKubernetesPodHostgroup, "Hostgroup of remote addresses in -kubernetes-pod-cidrs")
+	flag.StringVar(&config.KubernetesServiceCIDRs, "kubernetes-service-cidrs", "", "Comma-separated Kubernetes service CIDRs, remote addresses in them are collapsed to -kubernetes-service-hostgroup unless the inventory has a more specific entry")
+	flag.StringVar(&config.KubernetesServiceHostgroup, "kubernetes-service-hostgroup", taskinventory.DefaultKubernetesServiceHostgroup, "Hostgroup of remote addresses in -kubernetes-service-cidrs")
+	flag.Var(collector.ConstLabelsFlag(config.MetricLabels), "metric-label", "Constant 'key=value' label added to all planet metrics (e.g. 'datacenter=dc1'), can be repeated")
+	flag.Var(httpheader.Flag(config.HTTPHeaders), "http-header", "Custom 'key=value' HTTP header set on inventory requests and darkstat/ebpf scrapes, can be repeated")
+	flag.Int64Var(&config.MaxResponseBytes, "max-response-bytes", bodylimit.DefaultMaxBytes, "Maximum size in bytes of inventory and darkstat/ebpf scrape responses, unlimited if zero")
+	flag.IntVar(&config.MaxScrapeMetrics, "max-scrape-metrics", pkgprometheus.DefaultMaxMetrics, "Maximum metrics processed per darkstat/ebpf scrape, the rest of the scrape is skipped with a warning, unlimited if zero")
+	flag.IntVar(&config.ScrapeMaxRetries, "scrape-max-retries", pkgprometheus.DefaultScrapeMaxRetries, "Maximum retries of a darkstat/ebpf scrape failing with a network-level error (e.g. connection reset), disabled if zero")
+	flag.DurationVar(&config.ScrapeRetryBackoff, "scrape-retry-backoff", pkgprometheus.DefaultScrapeRetryBackoff, "Backoff before the first retry of a darkstat/ebpf scrape, doubled on every retry")
+	flag.StringVar(&config.ScrapeTLS.CAFile, "scrape-tls-ca-file", "", "PEM CA bundle trusted (in addition to system CAs) to verify darkstat/ebpf HTTPS certificates")
+	flag.StringVar(&config.ScrapeTLS.CertFile, "scrape-tls-cert-file", "", "PEM client certificate for darkstat/ebpf HTTPS scrapes (requires -scrape-tls-key-file)")
+	flag.StringVar(&config.ScrapeTLS.KeyFile, "scrape-tls-key-file", "", "PEM client key for darkstat/ebpf HTTPS scrapes (requires -scrape-tls-cert-file)")
+	flag.StringVar(&config.ScrapeProxyURL, "scrape-proxy-url", "", "Proxy URL of darkstat/ebpf scrapes, 'direct' to disable the proxy, or HTTP_PROXY/HTTPS_PROXY/NO_PROXY if empty")
+	flag.StringVar(&config.InventoryProxyURL, "inventory-proxy-url", "", "Proxy URL of inventory requests, 'direct' to disable the proxy, or HTTP_PROXY/HTTPS_PROXY/NO_PROXY if empty")
+	flag.BoolVar(&config.ScrapeTLS.InsecureSkipVerify, "scrape-tls-insecure-skip-verify", false, "Skip verifying darkstat/ebpf HTTPS certificates (insecure, previous default behavior)")
 
 	// Collector tasks
 	flag.StringVar(&config.TaskInterval, "task-interval", "7s", "Interval between collection of expensive data into memory")
+	flag.Float64Var(&config.TaskJitter, "task-jitter", defaultTaskJitter, "Maximum random delay of the first collection as a fraction (0.0-1.0) of -task-interval, spreading the collections of exporters started at once")
+	flag.IntVar(&config.TaskCollectConcurrency, "task-collect-concurrency", defaultTaskCollectConcurrency, "Maximum collector tasks (darkstat, ebpf, socketstat, dnssnoop) collecting at once within a collection tick, 1 collects them one after another")
+	flag.DurationVar(&config.ClockStepThreshold, "clock-step-threshold", clock.DefaultStepThreshold, "Minimum wall clock step (e.g. an NTP correction) between task collections that's logged and counted in planet_clock_steps_total")
 
 	flag.BoolVar(&config.TaskSocketstatEnabled, "task-socketstat-enabled", true, "Enable socketstat collector task")
+	flag.DurationVar(&config.TaskSocketstatProcessScanTimeout, "task-socketstat-process-scan-timeout", tasksocketstat.DefaultProcessScanTimeout, "Timeout of the socketstat process table scan naming the process of each connection, the connections of the processes that weren't scanned have no process name when it's exceeded (e.g. on hosts with many processes)")
+	flag.BoolVar(&config.TaskSocketstatUpstreamConnectionsHistogram, "task-socketstat-upstream-connections-histogram", false, "Export planet_upstream_connections, a histogram of the concurrent sockets per upstream observed once per socketstat collection")
+	flag.StringVar(&config.TaskSocketstatUpstreamConnectionsBuckets, "task-socketstat-upstream-connections-buckets", tasksocketstat.DefaultUpstreamConnectionsBuckets, "Comma-separated bucket upper bounds of the planet_upstream_connections histogram")
+	flag.BoolVar(&config.TaskSocketstatUnixSocketListeners, "task-socketstat-unix-socket-listeners", false, "Export the processes listening on Unix sockets in planet_unix_socket_listener")
+	flag.BoolVar(&config.TaskSocketstatDropTimeWaitOnly, "task-socketstat-drop-time-wait-only", false, "Drop socketstat dependencies whose sockets are all in TIME_WAIT, which linger after a one-off connection is closed")
+	flag.DurationVar(&config.TaskSocketstatDownstreamExpiry, "task-socketstat-downstream-expiry", 0, "Suppress socketstat downstreams without a new connection within this duration (e.g. '30m'), disabled if zero")
+	flag.DurationVar(&config.TaskSocketstatHistoryTTL, "task-socketstat-history-ttl", defaultSocketstatHistoryTTL, "Duration to remember when a dependency was first seen since it was last seen")
+	flag.DurationVar(&config.TaskSocketstatEphemeralInterval, "task-socketstat-ephemeral-interval", 0, "Interval between polls for short-lived connections missed by socketstat collections (e.g. '1s'), disabled if zero")
+	flag.IntVar(&config.TaskSocketstatEphemeralMaxEntries, "task-socketstat-ephemeral-max-entries", defaultSocketstatEphemeralMaxEntries, "Maximum short-lived dependencies remembered in between socketstat collections")
+	flag.IntVar(&config.TaskSocketstatHistoryMaxEntries, "task-socketstat-history-max-entries", defaultSocketstatHistoryMaxEntries, "Maximum dependencies whose first/last seen time is remembered")
+	flag.IntVar(&config.TaskSocketstatLookupWorkers, "task-socketstat-lookup-workers", 1, "Goroutines looking up socketstat connection addresses in the inventory, for many connections against a large CIDR inventory")
+	flag.StringVar(&config.TaskSocketstatStaticDependenciesFile, "task-socketstat-static-dependencies-file", "", "YAML/JSON file of upstreams/downstreams exported alongside the live socketstat ones with a static=\"true\" label, reloaded on SIGHUP")
+	flag.StringVar(&config.TaskSocketstatTrafficPorts, "task-socketstat-traffic-ports", "", "Comma-separated TCP ports (e.g. '5432,6379') whose kernel socket byte counters are summed per remote hostgroup into planet_traffic_bytes_total{source=\"socketstat\"}, for upstreams on these remote ports and downstreams on these listening ports (Linux 4.1+, approximate), disabled if empty")
+	flag.Float64Var(&config.TaskSocketstatSampleRate, "task-socketstat-sample-rate", 1, "Fraction (0.0-1.0) of socketstat dependency connections to export, sampled deterministically per connection")
+
+	flag.BoolVar(&config.TaskDnssnoopEnabled, "task-dnssnoop-enabled", false, "Enable dnssnoop collector task, counting DNS queries per queried domain from a DNS query log")
+	flag.StringVar(&config.TaskDnssnoopSource, "task-dnssnoop-source", taskdnssnoop.SourceDnsmasq, "Source of DNS query logs [dnsmasq]")
+	flag.StringVar(&config.TaskDnssnoopLogPath, "task-dnssnoop-log-path", "/var/log/dnsmasq.log", "DNS query log file tailed by the dnssnoop source (e.g. dnsmasq --log-queries --log-facility)")
+	flag.IntVar(&config.TaskDnssnoopTopDomains, "task-dnssnoop-top-domains", taskdnssnoop.DefaultTopDomains, "Most queried domains exported by the dnssnoop task, capping the query_domain cardinality")
 
 	flag.BoolVar(&config.TaskDarkstatEnabled, "task-darkstat-enabled", false, "Enable darkstat collector task")
 	flag.StringVar(&config.TaskDarkstatAddr, "task-darkstat-addr", "", "Darkstat target address")
+	flag.BoolVar(&config.TaskDarkstatInterfaceLabels, "task-darkstat-interface-labels", false, "Label darkstat traffic metrics with the network interface and its local IP address ('interface' and 'local_address'), when darkstat exposes the interface")
+	flag.StringVar(&config.TaskDarkstatClientCert, "task-darkstat-client-cert", "", "PEM client certificate for darkstat HTTPS scrapes instead of -scrape-tls-cert-file (requires -task-darkstat-client-key)")
+	flag.StringVar(&config.TaskDarkstatClientKey, "task-darkstat-client-key", "", "PEM client key for darkstat HTTPS scrapes instead of -scrape-tls-key-file (requires -task-darkstat-client-cert)")
+	flag.DurationVar(&config.TaskDarkstatScrapeTimeout, "task-darkstat-scrape-timeout", 0, "Timeout of a darkstat scrape, the task interval or 5s (whichever is smaller) if zero")
 
 	flag.BoolVar(&config.TaskEbpfEnabled, "task-ebpf-enabled", false, "Enable Ebpf collector task")
 	flag.StringVar(&config.TaskEbpfAddr, "task-ebpf-addr", "http://localhost:9435/metrics", "Ebpf target address")
+	flag.StringVar(&config.TaskEbpfClientCert, "task-ebpf-client-cert", "", "PEM client certificate for ebpf HTTPS scrapes instead of -scrape-tls-cert-file (requires -task-ebpf-client-key)")
+	flag.StringVar(&config.TaskEbpfClientKey, "task-ebpf-client-key", "", "PEM client key for ebpf HTTPS scrapes instead of -scrape-tls-key-file (requires -task-ebpf-client-cert)")
+	flag.DurationVar(&config.TaskEbpfScrapeTimeout, "task-ebpf-scrape-timeout", 0, "Timeout of an ebpf scrape, the task interval or 5s (whichever is smaller) if zero")
 
 	flag.BoolVar(&config.TaskInventoryEnabled, "task-inventory-enabled", false, "Enable inventory collector task")
-	flag.StringVar(&config.TaskInventoryAddr, "task-inventory-addr", "", "HTTP endpoint that returns the inventory data")
+	flag.StringVar(&config.TaskInventoryAddr, "task-inventory-addr", "", "HTTP endpoint that returns the inventory data, or 'srv+http://<SRV record>/path' to discover it via DNS SRV")
+	flag.StringVar(&config.TaskInventoryCacheFile, "task-inventory-cache-file", "", "File caching the latest fetched inventory, loaded at startup until the first successful inventory collection, disabled if empty")
+	flag.DurationVar(&config.TaskInventoryCacheMaxAge, "task-inventory-cache-max-age", taskinventory.DefaultCacheMaxAge, "Maximum age of the inventory cache file loaded at startup, an older cache is skipped, and a loaded cache is dropped once it reaches it before an inventory collection succeeds")
 	flag.StringVar(&config.TaskInventoryFormat, "task-inventory-format", "arrayjson", "Inventory format to parse the returned inventory data")
+	flag.StringVar(&config.TaskInventoryNATMappingFile, "task-inventory-nat-mapping-file", "", "File mapping remote IPs/CIDRs (e.g. NAT gateways) to a hostgroup and domain, taking precedence over the inventory and reloaded on SIGHUP")
+	flag.Var(&config.TaskInventoryTransformRules, "task-inventory-transform", "Inventory host rewrite '<field>=<regex>=<replacement>' with field 'domain' or 'hostgroup' (e.g. 'domain=\\.corp\\.example\\.com$='), applied in order, can be repeated")
+	flag.BoolVar(&config.TaskInventoryStrict, "task-inventory-strict", false, "Fail the whole inventory fetch on entries with unknown fields or missing ip_address, hostgroup and domain, instead of skipping them")
 
 	flag.Parse()
 
@@ -62,6 +154,13 @@ func main() {
 		os.Exit(0)
 	}
 
+	if printConfigAndExit {
+		if err := printconfig.Print(os.Stdout, printConfigFormat, config); err != nil {
+			log.Fatalf("Failed to print config: %v", err)
+		}
+		os.Exit(0)
+	}
+
 	log.SetFormatter(&log.TextFormatter{ // nolint:exhaustivestruct
 		DisableColors:    config.LogDisableColors,
 		DisableTimestamp: config.LogDisableTimestamp,
@@ -78,7 +177,11 @@ func main() {
 
 	ctx := context.Background()
 
-	log.Info("Initialize prometheus collector")
+	if validateInventoryAndExit {
+		os.Exit(validateInventory(ctx, config))
+	}
+
+	log.Infof("Initialize prometheus collector, registered collectors: %v", collector.RegisteredCollectorNames())
 	collector, err := collector.NewPlanetCollector()
 	if err != nil {
 		log.Fatalf("Failed to initialize planet collector: %v", err)
@@ -93,3 +196,37 @@ func main() {
 
 	log.Info("Main service exit successfully")
 }
+
+// validateInventory prints how the configured inventory parses and returns the process exit code.
+func validateInventory(ctx context.Context, config internal.Config) int {
+	inventoryProxy, err := httpproxy.Func(config.InventoryProxyURL)
+	if err != nil {
+		log.Errorf("Failed to parse inventory proxy URL: %v", err)
+
+		return 1
+	}
+	taskinventory.SetProxy(inventoryProxy)
+
+	report, err := taskinventory.Validate(ctx, config.TaskInventoryAddr, config.HTTPHeaders, config.MaxResponseBytes,
+		config.TaskInventoryFormat, config.TaskInventoryStrict)
+	if err != nil {
+		log.Errorf("Failed to validate inventory: %v", err)
+
+		return 1
+	}
+
+	fmt.Printf("IP hosts: %v\n", report.IPHosts)     // nolint:forbidigo
+	fmt.Printf("CIDR hosts: %v\n", report.CIDRHosts) // nolint:forbidigo
+	fmt.Printf("Skipped: %v\n", len(report.Skipped)) // nolint:forbidigo
+	for _, skipErr := range report.Skipped {
+		fmt.Printf("  - %v\n", skipErr) // nolint:forbidigo
+	}
+
+	if report.UsableHosts() == 0 {
+		log.Errorf("Inventory has no usable hosts")
+
+		return 1
+	}
+
+	return 0
+}
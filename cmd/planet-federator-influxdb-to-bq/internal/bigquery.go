@@ -16,7 +16,11 @@ package internal
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"time"
+
+	federatorbigquery "planet-exporter/federator/bigquery"
 
 	"cloud.google.com/go/bigquery"
 	"cloud.google.com/go/civil"
@@ -29,6 +33,13 @@ type backend struct {
 
 	trafficTable    *bigquery.Table
 	dependencyTable *bigquery.Table
+
+	// chunker splits inserts into requests under the streaming insert request size limit
+	chunker federatorbigquery.Chunker
+	// omitNullValues leaves NULL columns out of the inserted rows to shrink the streaming insert requests
+	omitNullValues bool
+	// deadLetter records rows rejected by BigQuery, which then doesn't fail the insert. Disabled if nil.
+	deadLetter *federatorbigquery.DeadLetter
 }
 
 // TableMetadata represents a BigQuery Table Metadata.
@@ -37,15 +48,93 @@ type TableMetadata struct {
 	TableID   string
 }
 
+// newTableMetadata returns a table in datasetID, or in the shared defaultDatasetID if datasetID is empty.
+func newTableMetadata(datasetID, defaultDatasetID, tableID string) TableMetadata {
+	if datasetID == "" {
+		datasetID = defaultDatasetID
+	}
+
+	return TableMetadata{
+		DatasetID: datasetID,
+		TableID:   tableID,
+	}
+}
+
 // newBackend returns new BigQuery storage client.
-func newBackend(config Config, bqClient *bigquery.Client) backend {
-	trafficTable := bqClient.Dataset(config.BigqueryDatasetID).Table(config.BigqueryTrafficTableID)
-	dependencyTable := bqClient.Dataset(config.BigqueryDatasetID).Table(config.BigqueryDependencyTableID)
+// The traffic and dependency tables may live in different datasets (e.g. with different ACLs).
+func newBackend(bqClient *bigquery.Client, trafficTableMetadata, dependencyTableMetadata TableMetadata,
+	chunker federatorbigquery.Chunker, omitNullValues bool) backend {
+	trafficTable := bqClient.Dataset(trafficTableMetadata.DatasetID).Table(trafficTableMetadata.TableID)
+	dependencyTable := bqClient.Dataset(dependencyTableMetadata.DatasetID).Table(dependencyTableMetadata.TableID)
 
 	return backend{
 		client:          bqClient,
 		trafficTable:    trafficTable,
 		dependencyTable: dependencyTable,
+		chunker:         chunker,
+		omitNullValues:  omitNullValues,
+		deadLetter:      nil,
+	}
+}
+
+// validate checks that both tables exist and their metadata is readable with the client's credentials.
+func (b backend) validate(ctx context.Context) error {
+	for _, table := range []*bigquery.Table{b.trafficTable, b.dependencyTable} {
+		if _, err := table.Metadata(ctx); err != nil {
+			return fmt.Errorf("error accessing BigQuery table %v: %w", table.FullyQualifiedName(), err)
+		}
+	}
+
+	return nil
+}
+
+// inserter returns a streaming inserter of the table. With a dead letter, BigQuery skips the rejected rows and still
+// inserts the valid rows of a request.
+func (b backend) inserter(table *bigquery.Table) *bigquery.Inserter {
+	inserter := table.Inserter()
+	inserter.SkipInvalidRows = b.deadLetter != nil
+
+	return inserter
+}
+
+// rows returns the n rows to insert, where row returns the i-th row, without their NULL values if omitNullValues.
+// Chunk sizes are estimated and rejected rows are recorded from the returned rows, so they match the request.
+func (b backend) rows(n int, row func(i int) interface{}) []interface{} {
+	rows := make([]interface{}, n)
+	for i := range rows {
+		rows[i] = row(i)
+		if b.omitNullValues {
+			rows[i] = federatorbigquery.OmitNullValues(rows[i])
+		}
+	}
+
+	return rows
+}
+
+// writeDeadLetter records the rows of a request rejected by the table, where row returns the i-th row of the request.
+func (b backend) writeDeadLetter(table *bigquery.Table, multiErr bigquery.PutMultiError, row func(i int) interface{}) error {
+	written, err := b.deadLetter.Write(table.FullyQualifiedName(), multiErr, row, time.Now())
+	if err != nil {
+		return fmt.Errorf("error writing rows rejected by %v to the dead letter: %w", table.FullyQualifiedName(), err)
+	}
+	log.Warnf("%v rows rejected by %v are written to the dead letter", written, table.FullyQualifiedName())
+
+	return nil
+}
+
+// logInsertProgress logs how much of a failed or interrupted insert into the table was written, and the row offset
+// to resume from.
+func logInsertProgress(table *bigquery.Table, progress federatorbigquery.Progress, err error) {
+	if errors.Is(err, federatorbigquery.ErrInsertInterrupted) {
+		log.Warnf("Insert into %v interrupted: %v/%v chunks (%v/%v rows) written, %v chunks remaining, resumable from row offset %v",
+			table.FullyQualifiedName(), progress.ChunksWritten, progress.Chunks, progress.RowsWritten, progress.Rows,
+			progress.ChunksRemaining(), progress.RowOffset())
+
+		return
+	}
+	if progress.ChunksWritten > 0 {
+		log.Warnf("Insert into %v failed after %v/%v chunks (%v/%v rows) written, resumable from row offset %v",
+			table.FullyQualifiedName(), progress.ChunksWritten, progress.Chunks, progress.RowsWritten, progress.Rows, progress.RowOffset())
 	}
 }
 
@@ -112,6 +201,30 @@ const (
 //         "type": "INTEGER",
 //         "mode": "REQUIRED",
 //         "description": "The 1h avg traffic bandwidth consumed in bit per second."
+//     },
+//     {
+//         "name": "traffic_bandwidth_bits_p95_1h",
+//         "type": "INTEGER",
+//         "mode": "NULLABLE",
+//         "description": "The 1h p95 traffic bandwidth consumed in bit per second. Optional (-traffic-percentiles)."
+//     },
+//     {
+//         "name": "traffic_bandwidth_bits_p99_1h",
+//         "type": "INTEGER",
+//         "mode": "NULLABLE",
+//         "description": "The 1h p99 traffic bandwidth consumed in bit per second. Optional (-traffic-percentiles)."
+//     },
+//     {
+//         "name": "local_instance",
+//         "type": "STRING",
+//         "mode": "NULLABLE",
+//         "description": "The planet-exporter instance of per-instance traffic. Null for the traffic of the whole local hostgroup."
+//     },
+//     {
+//         "name": "schema_version",
+//         "type": "INTEGER",
+//         "mode": "NULLABLE",
+//         "description": "The federator schema version the data was written with. Null if it was written before schema versions."
 //     }
 // ]
 
@@ -126,35 +239,62 @@ type TrafficTableData struct {
 	TrafficBandwidthBitsMin1h int64               `bigquery:"traffic_bandwidth_bits_min_1h"`
 	TrafficBandwidthBitsMax1h int64               `bigquery:"traffic_bandwidth_bits_max_1h"`
 	TrafficBandwidthBitsAvg1h int64               `bigquery:"traffic_bandwidth_bits_avg_1h"`
+	// TrafficBandwidthBitsP95 and TrafficBandwidthBitsP99 are only inserted when valid,
+	// so tables without the percentile columns keep working.
+	TrafficBandwidthBitsP95 bigquery.NullInt64 `bigquery:"traffic_bandwidth_bits_p95_1h"`
+	TrafficBandwidthBitsP99 bigquery.NullInt64 `bigquery:"traffic_bandwidth_bits_p99_1h"`
+	// LocalInstance of per-instance traffic is only inserted when valid, as the percentile columns
+	LocalInstance bigquery.NullString `bigquery:"local_instance"`
+	// SchemaVersion is only inserted when valid, as the percentile columns
+	SchemaVersion bigquery.NullInt64 `bigquery:"schema_version"`
 }
 
-func chunkTrafficTableData(slice []TrafficTableData, chunkSize int) [][]TrafficTableData {
-	var chunks [][]TrafficTableData
-	for {
-		if len(slice) == 0 {
-			break
-		}
-		if len(slice) < chunkSize {
-			chunkSize = len(slice)
-		}
-
-		chunks = append(chunks, slice[0:chunkSize])
-		slice = slice[chunkSize:]
+// Save implements bigquery.ValueSaver, omitting the optional percentile, local instance, and schema version columns
+// when they're not set.
+func (d TrafficTableData) Save() (map[string]bigquery.Value, string, error) {
+	row := map[string]bigquery.Value{
+		"inventory_date":                bigquery.CivilDateTimeString(d.InventoryDate),
+		"traffic_direction":             d.TrafficDirection,
+		"local_hostgroup":               d.LocalHostgroup,
+		"local_hostgroup_address":       d.LocalHostgroupAddress,
+		"remote_hostgroup":              d.RemoteHostgroup,
+		"remote_hostgroup_address":      d.RemoteHostgroupAddress,
+		"traffic_bandwidth_bits_min_1h": d.TrafficBandwidthBitsMin1h,
+		"traffic_bandwidth_bits_max_1h": d.TrafficBandwidthBitsMax1h,
+		"traffic_bandwidth_bits_avg_1h": d.TrafficBandwidthBitsAvg1h,
+	}
+	if d.TrafficBandwidthBitsP95.Valid {
+		row["traffic_bandwidth_bits_p95_1h"] = d.TrafficBandwidthBitsP95.Int64
+	}
+	if d.TrafficBandwidthBitsP99.Valid {
+		row["traffic_bandwidth_bits_p99_1h"] = d.TrafficBandwidthBitsP99.Int64
+	}
+	if d.LocalInstance.Valid {
+		row["local_instance"] = d.LocalInstance.StringVal
+	}
+	if d.SchemaVersion.Valid {
+		row["schema_version"] = d.SchemaVersion.Int64
 	}
 
-	return chunks
+	// An empty insertID lets the client generate one for best-effort de-duplication
+	return row, "", nil
 }
 
 // InsertTrafficBandwidthData inserts traffic data.
 func (b backend) InsertTrafficBandwidthData(ctx context.Context, data []TrafficTableData) error {
-	dataChunks := chunkTrafficTableData(data, 2000)
+	rows := b.rows(len(data), func(i int) interface{} { return data[i] })
+	dataChunks := b.chunker.Chunks(len(rows), func(i int) int { return federatorbigquery.EstimateRowSize(rows[i]) })
 	log.Debugf("InsertTrafficBandwidthData len(data)=%v len(dataCunks)=%v", len(data), len(dataChunks))
 
 	// Chunking to avoid HTTP 413 error due to request payload size limit
-	inserter := b.trafficTable.Inserter()
-	for _, dataChunk := range dataChunks {
-		err := inserter.Put(ctx, dataChunk)
+	inserter := b.inserter(b.trafficTable)
+	progress, err := federatorbigquery.InsertChunks(ctx, dataChunks, func(ctx context.Context, dataChunk federatorbigquery.Chunk) error {
+		chunkData := rows[dataChunk.Start:dataChunk.End]
+		err := inserter.Put(ctx, chunkData)
 		if err != nil {
+			if multiErr, ok := err.(bigquery.PutMultiError); ok && b.deadLetter != nil {
+				return b.writeDeadLetter(b.trafficTable, multiErr, func(i int) interface{} { return chunkData[i] })
+			}
 			if multiErr, ok := err.(bigquery.PutMultiError); ok {
 				for _, putErr := range multiErr {
 					return fmt.Errorf("failed to insert traffic table, sample row %d, with err: %v", putErr.RowIndex, putErr.Error())
@@ -164,7 +304,14 @@ func (b backend) InsertTrafficBandwidthData(ctx context.Context, data []TrafficT
 			}
 			return err
 		}
+		log.Debugf("Inserted traffic rows %v-%v of %v", dataChunk.Start, dataChunk.End, len(data))
+
+		return nil
+	})
+	if err != nil {
+		logInsertProgress(b.trafficTable, progress, err)
 
+		return err
 	}
 
 	return nil
@@ -230,6 +377,18 @@ func (b backend) InsertTrafficBandwidthData(ctx context.Context, data []TrafficT
 //         "type": "STRING",
 //         "mode": "NULLABLE",
 //         "description": "The upstream port. May be null for a downstream data."
+//     },
+//     {
+//         "name": "schema_version",
+//         "type": "INTEGER",
+//         "mode": "NULLABLE",
+//         "description": "The federator schema version the data was written with. Null if it was written before schema versions."
+//     },
+//     {
+//         "name": "first_seen",
+//         "type": "TIMESTAMP",
+//         "mode": "NULLABLE",
+//         "description": "The earliest time the federator observed the dependency at. Null without first-seen tracking (-federator-first-seen)."
 //     }
 // ]
 
@@ -255,35 +414,66 @@ type DependencyData struct {
 	// RemoteHostgroupPort is only relevant for dependencyDirection=upstream
 	// This signifies the upstream port.
 	RemoteHostgroupAddressPort bigquery.NullString `bigquery:"remote_hostgroup_address_port"`
+
+	// SchemaVersion the data was written with by the federator, null if it was written before schema versions
+	SchemaVersion bigquery.NullInt64 `bigquery:"schema_version"`
+
+	// FirstSeen is only inserted when valid, so tables without the first_seen column keep working
+	FirstSeen bigquery.NullTimestamp `bigquery:"first_seen"`
 }
 
-func chunkDependencyTableData(slice []DependencyData, chunkSize int) [][]DependencyData {
-	var chunks [][]DependencyData
-	for {
-		if len(slice) == 0 {
-			break
-		}
-		if len(slice) < chunkSize {
-			chunkSize = len(slice)
-		}
+// Save implements bigquery.ValueSaver, omitting the optional first seen column when it's not set.
+func (d DependencyData) Save() (map[string]bigquery.Value, string, error) {
+	row := map[string]bigquery.Value{
+		"inventory_date":                bigquery.CivilDateTimeString(d.InventoryDate),
+		"dependency_direction":          d.DependencyDirection,
+		"protocol":                      d.Protocol,
+		"local_hostgroup_process_name":  d.LocalHostgroupProcessName,
+		"local_hostgroup":               d.LocalHostgroup,
+		"local_hostgroup_address":       d.LocalHostgroupAddress,
+		"local_hostgroup_address_port":  d.LocalHostgroupAddressPort,
+		"remote_hostgroup":              d.RemoteHostgroup,
+		"remote_hostgroup_address":      d.RemoteHostgroupAddress,
+		"remote_hostgroup_address_port": d.RemoteHostgroupAddressPort,
+		"schema_version":                d.SchemaVersion,
+	}
+	if d.FirstSeen.Valid {
+		row["first_seen"] = d.FirstSeen.Timestamp
+	}
 
-		chunks = append(chunks, slice[0:chunkSize])
-		slice = slice[chunkSize:]
+	// An empty insertID lets the client generate one for best-effort de-duplication
+	return row, "", nil
+}
+
+// nullSchemaVersion returns the schema version column of a federator schema version, null if it's unknown (zero).
+func nullSchemaVersion(version int64) bigquery.NullInt64 {
+	return bigquery.NullInt64{Int64: version, Valid: version > 0}
+}
+
+// nullFirstSeen returns the first seen column of a dependency, null if it has no first-seen time.
+func nullFirstSeen(firstSeen *time.Time) bigquery.NullTimestamp {
+	if firstSeen == nil {
+		return bigquery.NullTimestamp{} // nolint:exhaustivestruct
 	}
 
-	return chunks
+	return bigquery.NullTimestamp{Timestamp: *firstSeen, Valid: true}
 }
 
 // InsertDependencyData inserts dependency data.
 func (b backend) InsertDependencyData(ctx context.Context, data []DependencyData) error {
-	dataChunks := chunkDependencyTableData(data, 2000)
+	rows := b.rows(len(data), func(i int) interface{} { return data[i] })
+	dataChunks := b.chunker.Chunks(len(rows), func(i int) int { return federatorbigquery.EstimateRowSize(rows[i]) })
 	log.Debugf("InsertDependencyData len(data)=%v len(dataCunks)=%v", len(data), len(dataChunks))
 
 	// Chunking to avoid HTTP 413 error due to request payload size limit
-	inserter := b.dependencyTable.Inserter()
-	for _, dataChunk := range dataChunks {
-		err := inserter.Put(ctx, dataChunk)
+	inserter := b.inserter(b.dependencyTable)
+	progress, err := federatorbigquery.InsertChunks(ctx, dataChunks, func(ctx context.Context, dataChunk federatorbigquery.Chunk) error {
+		chunkData := rows[dataChunk.Start:dataChunk.End]
+		err := inserter.Put(ctx, chunkData)
 		if err != nil {
+			if multiErr, ok := err.(bigquery.PutMultiError); ok && b.deadLetter != nil {
+				return b.writeDeadLetter(b.dependencyTable, multiErr, func(i int) interface{} { return chunkData[i] })
+			}
 			if multiErr, ok := err.(bigquery.PutMultiError); ok {
 				for _, putErr := range multiErr {
 					return fmt.Errorf("failed to insert multiple rows to the dependency table, sample row %d, with err: %v", putErr.RowIndex, putErr.Error())
@@ -293,6 +483,14 @@ func (b backend) InsertDependencyData(ctx context.Context, data []DependencyData
 			}
 			return err
 		}
+		log.Debugf("Inserted dependency rows %v-%v of %v", dataChunk.Start, dataChunk.End, len(data))
+
+		return nil
+	})
+	if err != nil {
+		logInsertProgress(b.dependencyTable, progress, err)
+
+		return err
 	}
 
 	return nil
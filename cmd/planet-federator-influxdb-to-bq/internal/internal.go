@@ -18,16 +18,16 @@ import (
 	"context"
 	"fmt"
 	"os"
-	"os/signal"
-	"syscall"
 	"time"
 
+	"planet-exporter/federator"
+	federatorbigquery "planet-exporter/federator/bigquery"
 	federatorquery "planet-exporter/federator/influxdb/query"
+	"planet-exporter/pkg/shutdown"
 
 	"cloud.google.com/go/bigquery"
 	"cloud.google.com/go/civil"
 
-	influxdb1 "github.com/influxdata/influxdb1-client/v2"
 	cron "github.com/robfig/cron/v3"
 	log "github.com/sirupsen/logrus"
 )
@@ -52,15 +52,50 @@ type Config struct {
 	LogDisableTimestamp bool
 	LogDisableColors    bool
 
-	InfluxdbAddr     string
-	InfluxdbUsername string
-	InfluxdbPassword string
-	InfluxdbDatabase string
-
-	BigqueryProjectID         string
-	BigqueryDatasetID         string
-	BigqueryTrafficTableID    string
-	BigqueryDependencyTableID string
+	// InfluxdbQueryLanguage queries the federator data with InfluxQL from the database, or Flux from the bucket
+	InfluxdbQueryLanguage string
+	InfluxdbAddr          string
+	InfluxdbUsername      string
+	InfluxdbPassword      string
+	InfluxdbDatabase      string
+	// InfluxdbToken, InfluxdbOrg, and InfluxdbBucket of the InfluxDB 2.x Flux queries
+	InfluxdbToken  string
+	InfluxdbOrg    string
+	InfluxdbBucket string
+	// InfluxdbRetentionPolicy queried, the database default if empty
+	InfluxdbRetentionPolicy string
+	// InfluxdbRetention warns about a CronJobTimeOffset querying data older than it, unknown if zero
+	InfluxdbRetention time.Duration
+	// InfluxdbNullValue is the bandwidth of a null traffic statistic (e.g. a group without data in the window)
+	InfluxdbNullValue int64
+	// FilterHostgroups limits exported data to these local hostgroups (empty exports all hostgroups)
+	FilterHostgroups []string
+	// HostgroupFilter skips rows whose local or remote hostgroup isn't included, or is excluded
+	HostgroupFilter federator.HostgroupFilter
+	// StrictTrafficDirection rejects traffic rows with a direction other than ingress/egress
+	StrictTrafficDirection bool
+	// TrafficDirections limits queried and exported traffic to these directions (ingress/egress)
+	TrafficDirections []string
+	// TrafficPercentiles exports p95/p99 traffic bandwidth, requires the percentile columns in the traffic table
+	TrafficPercentiles bool
+	// TimestampAlignment stamps records with the query window end, start, or midpoint
+	TimestampAlignment federator.TimestampAlignment
+
+	BigqueryProjectID string
+	// BigqueryDatasetID is the shared dataset of both tables, unless overridden per table
+	BigqueryDatasetID           string
+	BigqueryTrafficDatasetID    string
+	BigqueryTrafficTableID      string
+	BigqueryDependencyDatasetID string
+	BigqueryDependencyTableID   string
+	// BigqueryMaxRequestBytes estimated size budget of a streaming insert request, unlimited if zero
+	BigqueryMaxRequestBytes int
+	// BigqueryOmitNullFields leaves NULL columns out of inserted rows instead of sending them as JSON nulls
+	BigqueryOmitNullFields bool
+	// BigqueryTimestampTruncation truncates the inventory_date of inserted rows to a minute, hour, or day
+	BigqueryTimestampTruncation federatorbigquery.TimestampTruncation
+	// BigqueryDeadLetterPath NDJSON file recording the rows rejected by BigQuery, rejected rows fail the insert if empty
+	BigqueryDeadLetterPath string
 }
 
 // Service contains main service dependency.
@@ -70,15 +105,22 @@ type Service struct {
 	queryInfluxDB *federatorquery.Client
 	// Destination backend storage
 	storeBackend backend
+	// jobsCtx is canceled on shutdown, so running jobs stop between insert chunks. Background if nil.
+	jobsCtx context.Context
 }
 
-// New service.
-func New(config Config, influxdbClient influxdb1.Client, bqClient *bigquery.Client) Service {
-	backend := newBackend(config, bqClient)
+// New service querying the federator data with queryInfluxDB (InfluxQL or Flux).
+func New(config Config, queryInfluxDB *federatorquery.Client, bqClient *bigquery.Client) Service {
+	backend := newBackend(bqClient,
+		newTableMetadata(config.BigqueryTrafficDatasetID, config.BigqueryDatasetID, config.BigqueryTrafficTableID),
+		newTableMetadata(config.BigqueryDependencyDatasetID, config.BigqueryDatasetID, config.BigqueryDependencyTableID),
+		federatorbigquery.Chunker{MaxRows: federatorbigquery.DefaultMaxChunkRows, MaxBytes: config.BigqueryMaxRequestBytes},
+		config.BigqueryOmitNullFields)
 	return Service{
 		Config:        config,
-		queryInfluxDB: federatorquery.New(influxdbClient, config.InfluxdbDatabase),
+		queryInfluxDB: queryInfluxDB,
 		storeBackend:  backend,
+		jobsCtx:       nil,
 	}
 }
 
@@ -87,6 +129,33 @@ func (s Service) Run(ctx context.Context) error {
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
+	log.Info("Validate access to BigQuery tables")
+	if err := s.storeBackend.validate(ctx); err != nil {
+		return fmt.Errorf("error validating BigQuery tables: %w", err)
+	}
+
+	// Cron jobs are bound to a copy of s that carries the dead letter
+	if s.Config.BigqueryDeadLetterPath != "" {
+		deadLetter, deadLetterFile, err := federatorbigquery.OpenDeadLetterFile(s.Config.BigqueryDeadLetterPath)
+		if err != nil {
+			return fmt.Errorf("error opening BigQuery dead letter: %w", err)
+		}
+		defer deadLetterFile.Close()
+		log.Infof("Write rows rejected by BigQuery to dead letter %v", s.Config.BigqueryDeadLetterPath)
+		s.storeBackend.deadLetter = deadLetter
+	}
+
+	// The dependency job has the longest query window
+	if federator.TimeOffsetExceedsRetention(s.Config.CronJobTimeOffset, dependencyQueryWindow, s.Config.InfluxdbRetention) {
+		log.Warnf("Cron job time offset %v and the %v dependency query window reach data older than the InfluxDB retention %v, jobs may find no data",
+			s.Config.CronJobTimeOffset, dependencyQueryWindow, s.Config.InfluxdbRetention)
+	}
+
+	// Cron jobs are bound to a copy of s that carries the context of running jobs
+	jobsCtx, cancelJobs := context.WithCancel(context.Background())
+	defer cancelJobs()
+	s.jobsCtx = jobsCtx
+
 	log.Info("Start Cron scheduler")
 	cronScheduler := cron.New(cron.WithSeconds())
 	_, err := cronScheduler.AddFunc(s.Config.CronJobScheduleTrafficJob, s.TrafficBandwidthJobFunc)
@@ -103,7 +172,7 @@ func (s Service) Run(ctx context.Context) error {
 	stopChan := make(chan struct{})
 	go func() {
 		signals := make(chan os.Signal, 2)
-		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		shutdown.Notify(signals)
 		select {
 		case <-signals:
 			log.Info("Detected stop signal!")
@@ -112,6 +181,8 @@ func (s Service) Run(ctx context.Context) error {
 
 			log.Info("Stop Cron scheduler")
 			cronStopCtx := cronScheduler.Stop()
+			// Running jobs stop inserting the remaining chunks
+			cancelJobs()
 			cronStopTimeoutTimer := time.NewTimer(time.Duration(s.Config.CronJobTimeoutSecond) * time.Second)
 			select {
 			case <-cronStopCtx.Done():
@@ -132,6 +203,15 @@ func (s Service) Run(ctx context.Context) error {
 	return nil
 }
 
+// jobContext returns the parent context of jobs, canceled on shutdown.
+func (s Service) jobContext() context.Context {
+	if s.jobsCtx == nil {
+		return context.Background()
+	}
+
+	return s.jobsCtx
+}
+
 // getCronJobStartTime returns the time for cron job starting point.
 func (s Service) getCronJobStartTime() time.Time {
 	// We want to offset the query time by the specified offset
@@ -144,22 +224,51 @@ func (s Service) getCronJobDuration(startTime time.Time) time.Duration {
 	return time.Now().Add(s.Config.CronJobTimeOffset).Sub(startTime)
 }
 
+// queryFilter returns the InfluxDB query filter based on service config.
+func (s Service) queryFilter() federatorquery.Filter {
+	return federatorquery.Filter{
+		Hostgroups: s.Config.FilterHostgroups,
+		Directions: s.Config.TrafficDirections,
+	}
+}
+
+// Time windows of the InfluxDB data processed by the jobs.
+const (
+	trafficQueryWindow    = time.Hour
+	dependencyQueryWindow = 7 * 24 * time.Hour
+)
+
 // TrafficBandwidthJobFunc queries traffic bandwidth (planet-federator) data from InfluxDB and stores
 // them in Backend (i.e. BigQuery).
 func (s Service) TrafficBandwidthJobFunc() {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(s.Config.CronJobTimeoutSecond)*time.Second)
+	ctx, cancel := context.WithTimeout(s.jobContext(), time.Duration(s.Config.CronJobTimeoutSecond)*time.Second)
 	defer cancel()
 
 	jobStartTime := s.getCronJobStartTime()
 	log.Debugf("A job started: %v", jobStartTime)
+	windowStart := jobStartTime.Add(-trafficQueryWindow)
+	dataPointTime := s.Config.TimestampAlignment.Align(windowStart, jobStartTime)
+	inventoryDate := civil.DateTimeOf(s.Config.BigqueryTimestampTruncation.Truncate(dataPointTime))
 
-	trafficPeers, err := s.queryInfluxDB.QueryFederatorTraffic(ctx)
+	trafficPeers, err := s.queryInfluxDB.QueryFederatorTraffic(ctx, s.queryFilter(), s.Config.TrafficPercentiles)
 	if err != nil {
 		log.Errorf("error querying traffic data from influxdb: %v", err)
 	}
 
 	trafficTableData := []TrafficTableData{}
+	var filtered int
 	for _, trafficPeer := range trafficPeers {
+		if !s.Config.HostgroupFilter.AllowRow(trafficPeer.LocalHostgroup, trafficPeer.RemoteHostgroup) {
+			filtered++
+			continue
+		}
+		direction, err := federator.NormalizeTrafficDirection(trafficPeer.TrafficDirection,
+			trafficPeer.LocalHostgroup, trafficPeer.RemoteHostgroup, s.Config.StrictTrafficDirection)
+		if err != nil {
+			log.Errorf("error on traffic row: %v", err)
+			continue
+		}
+
 		localAddress := bigquery.NullString{}
 		if trafficPeer.LocalHostgroupAddress != "" {
 			localAddress.StringVal = trafficPeer.LocalHostgroupAddress
@@ -170,9 +279,15 @@ func (s Service) TrafficBandwidthJobFunc() {
 			remoteAddress.StringVal = trafficPeer.RemoteHostgroupAddress
 			remoteAddress.Valid = true
 		}
+		p95 := bigquery.NullInt64{}
+		p99 := bigquery.NullInt64{}
+		if s.Config.TrafficPercentiles {
+			p95 = bigquery.NullInt64{Int64: trafficPeer.TrafficBandwidthBitsP95, Valid: true}
+			p99 = bigquery.NullInt64{Int64: trafficPeer.TrafficBandwidthBitsP99, Valid: true}
+		}
 		trafficTableData = append(trafficTableData, TrafficTableData{
-			InventoryDate:             civil.DateTimeOf(jobStartTime),
-			TrafficDirection:          trafficPeer.TrafficDirection,
+			InventoryDate:             inventoryDate,
+			TrafficDirection:          direction,
 			LocalHostgroup:            trafficPeer.LocalHostgroup,
 			LocalHostgroupAddress:     localAddress,
 			RemoteHostgroup:           trafficPeer.RemoteHostgroup,
@@ -180,33 +295,50 @@ func (s Service) TrafficBandwidthJobFunc() {
 			TrafficBandwidthBitsMin1h: trafficPeer.TrafficBandwidthBitsMin1h,
 			TrafficBandwidthBitsMax1h: trafficPeer.TrafficBandwidthBitsMax1h,
 			TrafficBandwidthBitsAvg1h: trafficPeer.TrafficBandwidthBitsAvg1h,
+			TrafficBandwidthBitsP95:   p95,
+			TrafficBandwidthBitsP99:   p99,
+			LocalInstance:             bigquery.NullString{StringVal: trafficPeer.LocalInstance, Valid: trafficPeer.LocalInstance != ""},
+			SchemaVersion:             nullSchemaVersion(trafficPeer.SchemaVersion),
 		})
 	}
 
+	if filtered > 0 {
+		log.Debugf("Traffic Bandwidth Job filtered out %v rows by hostgroup", filtered)
+	}
+
 	err = s.storeBackend.InsertTrafficBandwidthData(ctx, trafficTableData)
 	if err != nil {
 		log.Errorf("error InsertTrafficBandwidthData: %v", err)
 	}
 
-	log.Infof("Traffic Bandwidth Job took: %v", s.getCronJobDuration(jobStartTime))
+	log.Infof("Traffic Bandwidth Job took: %v, query window: %v to %v", s.getCronJobDuration(jobStartTime),
+		windowStart.Format(time.RFC3339), jobStartTime.Format(time.RFC3339))
 }
 
 // DependencyDataJobFunc queries upstream & downstream dependencies (planet-federator) data from InfluxDB and stores
 // them in Backend (i.e. BigQuery).
 func (s Service) DependencyDataJobFunc() {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(s.Config.CronJobTimeoutSecond)*time.Second)
+	ctx, cancel := context.WithTimeout(s.jobContext(), time.Duration(s.Config.CronJobTimeoutSecond)*time.Second)
 	defer cancel()
 
 	jobStartTime := s.getCronJobStartTime()
 	log.Debugf("A job started: %v", jobStartTime)
+	windowStart := jobStartTime.Add(-dependencyQueryWindow)
+	dataPointTime := s.Config.TimestampAlignment.Align(windowStart, jobStartTime)
+	inventoryDate := civil.DateTimeOf(s.Config.BigqueryTimestampTruncation.Truncate(dataPointTime))
 
-	dependencies, err := s.queryInfluxDB.QueryFederatorDependencyLast7d(ctx)
+	dependencies, err := s.queryInfluxDB.QueryFederatorDependencyLast7d(ctx, s.queryFilter())
 	if err != nil {
 		log.Errorf("error querying dependency data from influxdb: %v", err)
 	}
 
 	dependencyTableData := []DependencyData{}
+	var filtered int
 	for _, dependency := range dependencies {
+		if !s.Config.HostgroupFilter.AllowRow(dependency.LocalHostgroup, dependency.RemoteHostgroup) {
+			filtered++
+			continue
+		}
 		localProcessName := bigquery.NullString{}
 		if dependency.LocalHostgroupProcessName != "" {
 			localProcessName.StringVal = dependency.LocalHostgroupProcessName
@@ -235,7 +367,7 @@ func (s Service) DependencyDataJobFunc() {
 		}
 
 		dependencyTableData = append(dependencyTableData, DependencyData{
-			InventoryDate: civil.DateTimeOf(jobStartTime),
+			InventoryDate: inventoryDate,
 
 			DependencyDirection:       dependency.Direction,
 			Protocol:                  dependency.Protocol,
@@ -251,13 +383,21 @@ func (s Service) DependencyDataJobFunc() {
 			RemoteHostgroupAddress: remoteAddress,
 
 			RemoteHostgroupAddressPort: remotePort,
+
+			SchemaVersion: nullSchemaVersion(dependency.SchemaVersion),
+			FirstSeen:     nullFirstSeen(dependency.FirstSeen),
 		})
 	}
 
+	if filtered > 0 {
+		log.Debugf("Dependency Job filtered out %v rows by hostgroup", filtered)
+	}
+
 	err = s.storeBackend.InsertDependencyData(ctx, dependencyTableData)
 	if err != nil {
 		log.Errorf("error InsertDependencyData: %v", err)
 	}
 
-	log.Infof("Dependency Job took: %v", s.getCronJobDuration(jobStartTime))
+	log.Infof("Dependency Job took: %v, query window: %v to %v", s.getCronJobDuration(jobStartTime),
+		windowStart.Format(time.RFC3339), jobStartTime.Format(time.RFC3339))
 }
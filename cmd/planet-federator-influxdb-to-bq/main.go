@@ -19,11 +19,17 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"strings"
 	"time"
 
 	"planet-exporter/cmd/planet-federator-influxdb-to-bq/internal"
+	"planet-exporter/federator"
+	federatorbigquery "planet-exporter/federator/bigquery"
+	federatorquery "planet-exporter/federator/influxdb/query"
+	"planet-exporter/pkg/printconfig"
 
 	"cloud.google.com/go/bigquery"
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
 	influxdb1 "github.com/influxdata/influxdb1-client/v2"
 	log "github.com/sirupsen/logrus"
 )
@@ -40,9 +46,30 @@ func main() {
 	// This is useful when we want to integrate federator to existing Prometheus setup.
 	// TODO: Allows running multiple jobs for federator to catch up faster.
 	var cronJobTimeOffsetDuration string
+	// allowFutureOffset allows a positive cronJobTimeOffsetDuration, which queries data from the future.
+	var allowFutureOffset bool
+
+	// filterHostgroups is a comma-separated list of local hostgroups to export.
+	var filterHostgroups string
+
+	// includeHostgroups and excludeHostgroups are comma-separated hostgroup names or globs to export, or not.
+	var includeHostgroups, excludeHostgroups string
+
+	// trafficDirections is a comma-separated list of traffic directions to export.
+	var trafficDirections string
+
+	// timestampAlignment is the point of the query window that data points are stamped with.
+	var timestampAlignment string
+
+	// bqTimestampTruncation is the granularity of the inventory_date of inserted rows.
+	var bqTimestampTruncation string
 
 	var showVersionAndExit bool
 
+	// printConfigAndExit prints the effective config with secrets redacted, then exits (e.g. to debug a deployment)
+	var printConfigAndExit bool
+	var printConfigFormat string
+
 	const (
 		defaultInfluxBatchSize      = 20
 		defaultCronJobTimeoutSecond = 300
@@ -53,34 +80,97 @@ func main() {
 	flag.StringVar(&config.CronJobScheduleDependencyJob, "cron-job-schedule-dependency", "30 0 11 * * *", "Cron jobs schedule (Quartz: s m h dom mo dow y) to process federator dependency data")
 	flag.IntVar(&config.CronJobTimeoutSecond, "cron-job-timeout-second", defaultCronJobTimeoutSecond, "Timeout per federator job in second")
 	flag.StringVar(&cronJobTimeOffsetDuration, "cron-job-time-offset", "0s", "Cron jobs time offset. (e.g. '-1h5m' to query data from 1 hour 5 minutes ago)")
+	flag.BoolVar(&allowFutureOffset, "allow-future-offset", false, "Allow a positive -cron-job-time-offset, which queries data from the future")
 	flag.StringVar(&config.LogLevel, "log-level", "info", "Log level")
 	flag.BoolVar(&config.LogDisableTimestamp, "log-disable-timestamp", false, "Disable timestamp on logger")
 	flag.BoolVar(&config.LogDisableColors, "log-disable-colors", false, "Disable colors on logger")
 	flag.BoolVar(&showVersionAndExit, "version", false, "Show version and exit")
+	flag.BoolVar(&printConfigAndExit, "print-config", false, "Print the effective configuration (after parsing every flag) in -print-config-format with secrets redacted, and exit")
+	flag.StringVar(&printConfigFormat, "print-config-format", printconfig.FormatYAML, "Format of -print-config, 'yaml' or 'json'")
 
 	// Source InfluxDB
+	flag.StringVar(&config.InfluxdbQueryLanguage, "influxdb-query-language", federatorquery.InfluxQL, "Query the federator data with 'influxql' from -influxdb-database, or 'flux' from the InfluxDB 2.x -influxdb-bucket")
 	flag.StringVar(&config.InfluxdbAddr, "influxdb-addr", "http://127.0.0.1:8086", "Target InfluxDB HTTP Address that stores the pre-processed planet-exporter data")
 	flag.StringVar(&config.InfluxdbUsername, "influxdb-username", "", "Target InfluxDB username")
 	flag.StringVar(&config.InfluxdbPassword, "influxdb-password", "", "Target InfluxDB password")
 	flag.StringVar(&config.InfluxdbDatabase, "influxdb-database", "mothership", "InfluxDB organization")
+	flag.StringVar(&config.InfluxdbToken, "influxdb-token", "", "InfluxDB 2.x token of Flux queries")
+	flag.StringVar(&config.InfluxdbOrg, "influxdb-org", "mothership", "InfluxDB 2.x organization of Flux queries")
+	flag.StringVar(&config.InfluxdbBucket, "influxdb-bucket", "", "InfluxDB 2.x bucket of Flux queries, -influxdb-database if empty")
+	flag.StringVar(&config.InfluxdbRetentionPolicy, "influxdb-retention-policy", "", "InfluxDB retention policy of the pre-processed planet-exporter data, the database default if empty")
+	flag.Int64Var(&config.InfluxdbNullValue, "influxdb-null-value", 0, "Bandwidth of a null InfluxDB traffic statistic (e.g. a group without data in the window), e.g. -1 to tell it apart from zero traffic")
+	flag.DurationVar(&config.InfluxdbRetention, "influxdb-retention", 0, "InfluxDB data retention, warn when -cron-job-time-offset queries older data, unknown if zero")
+	flag.BoolVar(&config.StrictTrafficDirection, "strict-traffic-direction", false, "Reject traffic rows with a direction other than ingress/egress instead of storing them as unknown")
+	flag.BoolVar(&config.TrafficPercentiles, "traffic-percentiles", false, "Export p95/p99 traffic bandwidth, requires the traffic_bandwidth_bits_p95_1h/p99_1h columns in the traffic table")
+	flag.StringVar(&timestampAlignment, "timestamp-alignment", "end", "Stamp data points with the query window 'end' (job start time), 'start', or 'midpoint'")
+	flag.StringVar(&trafficDirections, "traffic-directions", "ingress,egress", "Comma-separated traffic directions (ingress, egress) to query and export")
+	flag.StringVar(&filterHostgroups, "filter-hostgroups", "", "Comma-separated list of local hostgroups to export (e.g. 'svc-a,svc-b'). Empty exports all hostgroups")
+	flag.StringVar(&includeHostgroups, "include-hostgroups", "", "Comma-separated hostgroup names or globs (e.g. 'payment-*') to export, rows with another local or remote hostgroup are skipped, all hostgroups if empty")
+	flag.StringVar(&excludeHostgroups, "exclude-hostgroups", "", "Comma-separated hostgroup names or globs not to export, rows with such a local or remote hostgroup are skipped, taking precedence over -include-hostgroups")
 
 	// Destination BigQuery
-	// We assume the tables live in the same GCP Project and same Dataset
+	// We assume the tables live in the same GCP Project, and in the same Dataset unless overridden per table
 	flag.StringVar(&config.BigqueryProjectID, "bq-project-id", "", "BQ Project ID for target dataset")
-	flag.StringVar(&config.BigqueryDatasetID, "bq-dataset-id", "", "BQ Dataset ID for traffic table")
+	flag.StringVar(&config.BigqueryDatasetID, "bq-dataset-id", "", "BQ Dataset ID for traffic and dependency tables")
+	flag.StringVar(&config.BigqueryTrafficDatasetID, "bq-traffic-dataset-id", "", "BQ Dataset ID for traffic table, -bq-dataset-id if empty")
 	flag.StringVar(&config.BigqueryTrafficTableID, "bq-traffic-table-id", "planet_exporter_traffic", "BQ Table ID for traffic table")
+	flag.StringVar(&config.BigqueryDependencyDatasetID, "bq-dependency-dataset-id", "", "BQ Dataset ID for dependency table, -bq-dataset-id if empty")
 	flag.StringVar(&config.BigqueryDependencyTableID, "bq-dependency-table-id", "planet_exporter_dependency", "BQ Table ID for dependency table")
+	flag.IntVar(&config.BigqueryMaxRequestBytes, "bq-max-request-bytes", federatorbigquery.DefaultMaxChunkBytes, "Estimated size budget in bytes of a BQ streaming insert request, unlimited if zero")
+	flag.BoolVar(&config.BigqueryOmitNullFields, "bq-omit-null-fields", false, "Leave NULL columns out of BQ streaming insert rows instead of sending them as JSON nulls, to shrink the requests")
+	flag.StringVar(&bqTimestampTruncation, "bq-timestamp-truncate", string(federatorbigquery.TruncateNone), "Truncate the inventory_date of BQ rows to the 'minute', 'hour', or 'day' (in the local time zone), or 'none'")
+	flag.StringVar(&config.BigqueryDeadLetterPath, "bq-dead-letter-path", "", "File appended with the rows rejected by BQ as NDJSON, while the valid rows are still inserted. Rejected rows fail the insert if empty")
 
 	flag.Parse()
 
 	if showVersionAndExit {
-		fmt.Println("planet-federator-influxdb-to-bq", version) // nolint:forbidigo
+		fmt.Println("planet-federator-influxdb-to-bq", version, "schema version", federator.SchemaVersion) // nolint:forbidigo
 		os.Exit(0)
 	}
 
-	config.CronJobTimeOffset, err = time.ParseDuration(cronJobTimeOffsetDuration)
+	config.CronJobTimeOffset, err = federator.ParseTimeOffset(cronJobTimeOffsetDuration, allowFutureOffset)
+	if err != nil {
+		log.Fatalf("Error parsing cron-job-time-offset: %v", err)
+	}
+
+	config.TrafficDirections, err = federator.ParseTrafficDirections(trafficDirections)
+	if err != nil {
+		log.Fatalf("Error parsing traffic-directions: %v", err)
+	}
+
+	config.TimestampAlignment, err = federator.ParseTimestampAlignment(timestampAlignment)
+	if err != nil {
+		log.Fatalf("Error parsing timestamp-alignment: %v", err)
+	}
+
+	config.HostgroupFilter, err = federator.ParseHostgroupFilter(includeHostgroups, excludeHostgroups)
+	if err != nil {
+		log.Fatalf("Error parsing include-hostgroups/exclude-hostgroups: %v", err)
+	}
+
+	config.BigqueryTimestampTruncation, err = federatorbigquery.ParseTimestampTruncation(bqTimestampTruncation)
 	if err != nil {
-		log.Fatalf("Error parsing cron-job-time-offset-minute: %v", err)
+		log.Fatalf("Error parsing bq-timestamp-truncate: %v", err)
+	}
+
+	if config.InfluxdbQueryLanguage != federatorquery.InfluxQL && config.InfluxdbQueryLanguage != federatorquery.Flux {
+		log.Fatalf("Error parsing influxdb-query-language: %q isn't %v or %v", config.InfluxdbQueryLanguage, federatorquery.InfluxQL, federatorquery.Flux)
+	}
+	if config.InfluxdbBucket == "" {
+		config.InfluxdbBucket = config.InfluxdbDatabase
+	}
+
+	for _, hostgroup := range strings.Split(filterHostgroups, ",") {
+		if hostgroup = strings.TrimSpace(hostgroup); hostgroup != "" {
+			config.FilterHostgroups = append(config.FilterHostgroups, hostgroup)
+		}
+	}
+
+	if printConfigAndExit {
+		if err := printconfig.Print(os.Stdout, printConfigFormat, config); err != nil {
+			log.Fatalf("Failed to print config: %v", err)
+		}
+		os.Exit(0)
 	}
 
 	log.SetFormatter(&log.TextFormatter{ // nolint:exhaustivestruct
@@ -94,7 +184,7 @@ func main() {
 	}
 	log.SetLevel(logLevel)
 
-	log.Infof("Planet Federator InfluxDB to BQ %v", version)
+	log.Infof("Planet Federator InfluxDB to BQ %v (schema version %v)", version, federator.SchemaVersion)
 	log.Infof("Initialize log with level %v", config.LogLevel)
 
 	ctx := context.Background()
@@ -102,16 +192,26 @@ func main() {
 	log.Info("Initialize InfluxDB to BQ service")
 
 	log.Info("Initialize Influxdb client")
-	influxdbClient, err := influxdb1.NewHTTPClient(influxdb1.HTTPConfig{
-		Addr:     config.InfluxdbAddr,
-		Username: config.InfluxdbUsername,
-		Password: config.InfluxdbPassword,
-		Timeout:  time.Second * time.Duration(config.CronJobTimeoutSecond),
-	})
-	if err != nil {
-		fmt.Println("Error creating InfluxDB Client: ", err.Error())
+	var queryInfluxDB *federatorquery.Client
+	if config.InfluxdbQueryLanguage == federatorquery.Flux {
+		influxdbClient := influxdb2.NewClientWithOptions(config.InfluxdbAddr, config.InfluxdbToken,
+			influxdb2.DefaultOptions().SetHTTPRequestTimeout(uint(config.CronJobTimeoutSecond)))
+		defer influxdbClient.Close()
+		queryInfluxDB = federatorquery.NewFlux(influxdbClient.QueryAPI(config.InfluxdbOrg), config.InfluxdbBucket)
+	} else {
+		influxdbClient, err := influxdb1.NewHTTPClient(influxdb1.HTTPConfig{
+			Addr:     config.InfluxdbAddr,
+			Username: config.InfluxdbUsername,
+			Password: config.InfluxdbPassword,
+			Timeout:  time.Second * time.Duration(config.CronJobTimeoutSecond),
+		})
+		if err != nil {
+			fmt.Println("Error creating InfluxDB Client: ", err.Error())
+		}
+		defer influxdbClient.Close()
+		queryInfluxDB = federatorquery.New(influxdbClient, config.InfluxdbDatabase, config.InfluxdbRetentionPolicy)
 	}
-	defer influxdbClient.Close()
+	queryInfluxDB.SetNullValue(config.InfluxdbNullValue)
 
 	log.Info("Initialize Bigquery client")
 	bqClient, err := bigquery.NewClient(ctx, config.BigqueryProjectID)
@@ -120,7 +220,7 @@ func main() {
 	}
 
 	log.Info("Initialize main service")
-	svc := internal.New(config, influxdbClient, bqClient)
+	svc := internal.New(config, queryInfluxDB, bqClient)
 	if err := svc.Run(ctx); err != nil {
 		log.Errorf("Main service exit with error: %v", err)
 		os.Exit(1) // nolint:gocritic
@@ -16,15 +16,24 @@ package internal
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
 	"os"
-	"os/signal"
-	"syscall"
 	"time"
 
 	"planet-exporter/federator"
+	"planet-exporter/federator/influxdb"
+	"planet-exporter/federator/influxdb/query"
+	kustoFederator "planet-exporter/federator/kusto"
+	pkgprometheus "planet-exporter/pkg/prometheus"
+	"planet-exporter/pkg/shutdown"
 	"planet-exporter/prometheus"
+	"planet-exporter/server"
 
+	promclient "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/common/version"
 	cron "github.com/robfig/cron/v3"
 	log "github.com/sirupsen/logrus"
 )
@@ -47,14 +56,111 @@ type Config struct {
 	LogLevel            string
 	LogDisableTimestamp bool
 	LogDisableColors    bool
+	// ListenAddress for the HTTP interface serving federator metrics, disabled if empty
+	ListenAddress string
+
+	// FederatorBackends the backends (influxdb, influxdb1) pre-processed data is written to
+	FederatorBackends []string
+	// FederatorWriteRateLimit maximum backend writes per second, unlimited if zero
+	FederatorWriteRateLimit      float64
+	FederatorWriteRateLimitBurst int
+	// FederatorCircuitBreakerThreshold consecutive backend write failures before failing fast, disabled if zero
+	FederatorCircuitBreakerThreshold int
+	FederatorCircuitBreakerCooldown  time.Duration
+	// FederatorMaxRowsPerHostgroup maximum rows written per local hostgroup per job run, unlimited if zero
+	FederatorMaxRowsPerHostgroup int
+	// FederatorStrictTrafficDirection rejects traffic rows with a direction other than ingress/egress
+	FederatorStrictTrafficDirection bool
+	// DefaultProtocol of upstream/downstream edges without one (darkstat/ebpf-derived), left empty if empty
+	DefaultProtocol string
+	// TrafficDirections limits queried and written traffic to these directions (ingress/egress)
+	TrafficDirections []string
+	// TrafficAggregation reduces the bandwidth samples of a query window to their 'max' or 'ema',
+	// TrafficAggregationEMAAlpha is the smoothing factor of 'ema'
+	TrafficAggregation         string
+	TrafficAggregationEMAAlpha float64
+	// TrafficMinBitsPerSecond filters out traffic with a lower bandwidth as noise, disabled if zero
+	TrafficMinBitsPerSecond float64
+	// HostgroupFilter skips rows whose local or remote hostgroup isn't included, or is excluded
+	HostgroupFilter federator.HostgroupFilter
+	// TrafficPerInstanceHostgroups traffic is also federated per planet-exporter instance
+	TrafficPerInstanceHostgroups []string
+	// TrafficSocketstat includes the socketstat traffic, for hosts without darkstat/ebpf
+	TrafficSocketstat bool
+	// TimestampAlignment stamps data points with the query window end, start, or midpoint
+	TimestampAlignment federator.TimestampAlignment
+	// FederatorDeltaMode only writes upstream/downstream edges that are new or changed since the previous job run
+	FederatorDeltaMode bool
+	// FederatorDeltaResyncInterval between job runs writing every edge in delta mode
+	FederatorDeltaResyncInterval time.Duration
+	// FederatorFirstSeen writes the time each upstream/downstream edge was first seen at
+	FederatorFirstSeen bool
+	// FederatorFirstSeenMaxIdleRuns forgets the first-seen time of edges missing from that many job runs, never if zero
+	FederatorFirstSeenMaxIdleRuns int
+	// ClockStepThreshold is the minimum wall clock step between job runs that's logged and counted
+	ClockStepThreshold time.Duration
 
 	InfluxdbAddr      string
 	InfluxdbToken     string
 	InfluxdbOrg       string
 	InfluxdbBucket    string
 	InfluxdbBatchSize int
-
-	PrometheusAddr string
+	// InfluxdbFlushInterval writes a batch that isn't full yet
+	InfluxdbFlushInterval time.Duration
+	// InfluxdbRequestTimeout of HTTP requests to Influxdb
+	InfluxdbRequestTimeout time.Duration
+	InfluxdbUseGZip        bool
+	// InfluxdbPrecision of written point timestamps
+	InfluxdbPrecision time.Duration
+	// InfluxdbTLS of Influxdb over HTTPS, server certificates are verified unless InsecureSkipVerify
+	InfluxdbTLS pkgprometheus.TLSOptions
+	// InfluxdbProxyURL of Influxdb requests: 'direct' disables the proxy,
+	// and empty uses the HTTP_PROXY, HTTPS_PROXY, and NO_PROXY environment variables
+	InfluxdbProxyURL string
+	// InfluxdbHTTPHeaders are set on every Influxdb request (e.g. gateway routing headers)
+	InfluxdbHTTPHeaders http.Header
+	// InfluxdbMaxRetries of a failed batch write, after InfluxdbRetryInterval exponentially backed off up to
+	// InfluxdbMaxRetryInterval. Up to InfluxdbRetryBufferLimit points are kept for retries, the oldest are dropped.
+	InfluxdbMaxRetries       int
+	InfluxdbRetryInterval    time.Duration
+	InfluxdbMaxRetryInterval time.Duration
+	InfluxdbRetryBufferLimit int
+
+	Influxdb1Addr     string
+	Influxdb1Username string
+	Influxdb1Password string
+	Influxdb1Database string
+	// Influxdb1RetentionPolicy written to, the database default if empty
+	Influxdb1RetentionPolicy string
+
+	KustoClusterURL      string
+	KustoDatabase        string
+	KustoTrafficTable    string
+	KustoDependencyTable string
+	// KustoBatchSize records of a table are ingested at once, or every KustoFlushInterval if the batch isn't full yet
+	KustoBatchSize      int
+	KustoFlushInterval  time.Duration
+	KustoRequestTimeout time.Duration
+	// KustoAuth authenticates ingestion requests with a managed identity or an app registration client secret
+	KustoAuth kustoFederator.AuthConfig
+
+	// PrometheusAddr comma-separated Prometheus addresses
+	PrometheusAddr                  string
+	PrometheusDialTimeout           time.Duration
+	PrometheusTLSHandshakeTimeout   time.Duration
+	PrometheusIdleConnTimeout       time.Duration
+	PrometheusResponseHeaderTimeout time.Duration
+	PrometheusProxyFromEnv          bool
+	// PrometheusRetention warns about a CronJobTimeOffset querying data older than it, unknown if zero
+	PrometheusRetention time.Duration
+	// PrometheusProxyURL overrides PrometheusProxyFromEnv when set, 'direct' disables the proxy
+	PrometheusProxyURL string
+	// PrometheusQueryCacheMaxEntries query results cached for a cron schedule interval, disabled if zero
+	PrometheusQueryCacheMaxEntries int
+	// PrometheusQueryConcurrency maximum Prometheus queries running at once across the jobs, unlimited if zero
+	PrometheusQueryConcurrency int
+	// PrometheusSource of the traffic and dependency series: 'query' (range queries) or 'vm-export' (VictoriaMetrics export)
+	PrometheusSource string
 }
 
 // Service contains main service dependency.
@@ -62,15 +168,83 @@ type Service struct {
 	Config        Config
 	FederatorSvc  federator.Service
 	PrometheusSvc prometheus.Service
+
+	// jobCtx is the parent context of every cron job, it's cancelled on shutdown
+	jobCtx context.Context // nolint:containedctx
+
+	// upstreamDelta and downstreamDelta remember the edges written by the previous job run, nil unless in delta mode
+	upstreamDelta   *federator.DependencyDelta
+	downstreamDelta *federator.DependencyDelta
+	// firstSeen remembers when every edge was first seen at, nil unless first-seen tracking is enabled
+	firstSeen *federator.EdgeFirstSeen
+
+	// Query windows of the jobs, clamped when the wall clock steps back between runs
+	trafficBandwidthWindow   *federator.JobWindow
+	upstreamServicesWindow   *federator.JobWindow
+	downstreamServicesWindow *federator.JobWindow
 }
 
 // New service.
 func New(config Config, federatorSvc federator.Service, prometheusSvc prometheus.Service) Service {
-	return Service{
-		Config:        config,
-		FederatorSvc:  federatorSvc,
-		PrometheusSvc: prometheusSvc,
+	s := Service{
+		Config:                   config,
+		FederatorSvc:             federatorSvc,
+		PrometheusSvc:            prometheusSvc,
+		trafficBandwidthWindow:   federator.NewJobWindow("traffic_bandwidth", jobQueryWindow, config.ClockStepThreshold),
+		upstreamServicesWindow:   federator.NewJobWindow("upstream_services", jobQueryWindow, config.ClockStepThreshold),
+		downstreamServicesWindow: federator.NewJobWindow("downstream_services", jobQueryWindow, config.ClockStepThreshold),
+	}
+	if config.FederatorDeltaMode {
+		s.upstreamDelta = federator.NewDependencyDelta(config.FederatorDeltaResyncInterval)
+		s.downstreamDelta = federator.NewDependencyDelta(config.FederatorDeltaResyncInterval)
+	}
+	if config.FederatorFirstSeen {
+		s.firstSeen = federator.NewEdgeFirstSeen(config.FederatorFirstSeenMaxIdleRuns)
+	}
+
+	return s
+}
+
+// DependencyQuerier queries the dependencies written to a backend in the last 7d (e.g. a query.Client of InfluxDB).
+type DependencyQuerier interface {
+	QueryFederatorDependencyLast7d(ctx context.Context, filter query.Filter) ([]query.Dependency, error)
+}
+
+// SeedFirstSeen seeds the first-seen tracking with the earliest first_seen of every edge written to the backend in the
+// last 7d, so a restart doesn't first see every edge again. It returns the number of seeded dependencies.
+func (s Service) SeedFirstSeen(ctx context.Context, querier DependencyQuerier) (int, error) {
+	if s.firstSeen == nil {
+		return 0, nil
+	}
+
+	dependencies, err := querier.QueryFederatorDependencyLast7d(ctx, query.Filter{}) // nolint:exhaustivestruct
+	if err != nil {
+		return 0, fmt.Errorf("error querying the dependencies of the last 7d: %w", err)
 	}
+
+	upstreams, downstreams := []federator.UpstreamService{}, []federator.DownstreamService{}
+	for _, dependency := range dependencies {
+		switch dependency.Direction {
+		case influxdb.UpstreamServiceMeasurement:
+			upstreams = append(upstreams, federator.UpstreamService{ // nolint:exhaustivestruct
+				LocalHostgroup:    dependency.LocalHostgroup,
+				UpstreamHostgroup: dependency.RemoteHostgroup,
+				UpstreamPort:      dependency.RemoteHostgroupAddressPort,
+				Protocol:          dependency.Protocol,
+				FirstSeen:         dependency.FirstSeen,
+			})
+		case influxdb.DownstreamServiceMeasurement:
+			downstreams = append(downstreams, federator.DownstreamService{ // nolint:exhaustivestruct
+				LocalHostgroup:      dependency.LocalHostgroup,
+				DownstreamHostgroup: dependency.RemoteHostgroup,
+				LocalPort:           dependency.LocalHostgroupAddressPort,
+				Protocol:            dependency.Protocol,
+				FirstSeen:           dependency.FirstSeen,
+			})
+		}
+	}
+
+	return s.firstSeen.Seed(upstreams, downstreams), nil
 }
 
 // Run main service.
@@ -78,6 +252,27 @@ func (s Service) Run(ctx context.Context) error {
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
+	// Cron jobs are bound to a copy of s that carries the cancellable job context
+	jobCtx, jobCancel := context.WithCancel(ctx)
+	defer jobCancel()
+	s.jobCtx = jobCtx
+
+	var httpServer *server.Server
+	if s.Config.ListenAddress != "" {
+		httpServer = s.newHTTPServer()
+		go func() {
+			log.Infof("Start HTTP server on %v", s.Config.ListenAddress)
+			if err := httpServer.Serve(s.Config.ListenAddress); !errors.Is(err, http.ErrServerClosed) {
+				log.Errorf("Error on HTTP server: %v", err)
+			}
+		}()
+	}
+
+	if federator.TimeOffsetExceedsRetention(s.Config.CronJobTimeOffset, jobQueryWindow, s.Config.PrometheusRetention) {
+		log.Warnf("Cron job time offset %v queries data older than the Prometheus retention %v, jobs may find no data",
+			s.Config.CronJobTimeOffset, s.Config.PrometheusRetention)
+	}
+
 	log.Info("Start Cron scheduler")
 	cronScheduler := cron.New(cron.WithSeconds())
 	_, err := cronScheduler.AddFunc(s.Config.CronJobSchedule, s.TrafficBandwidthJobFunc)
@@ -92,22 +287,24 @@ func (s Service) Run(ctx context.Context) error {
 	if err != nil {
 		return fmt.Errorf("error adding DownstreamServicesJobFunc function to Cron scheduler: %w", err)
 	}
+	_, err = cronScheduler.AddFunc(s.Config.CronJobSchedule, s.CollectorHealthJobFunc)
+	if err != nil {
+		return fmt.Errorf("error adding CollectorHealthJobFunc function to Cron scheduler: %w", err)
+	}
 	cronScheduler.Start()
 
 	// Capture signals and graceful exit mechanism
 	stopChan := make(chan struct{})
 	go func() {
 		signals := make(chan os.Signal, 2)
-		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		shutdown.Notify(signals)
 		select {
 		case <-signals:
 			log.Info("Detected stop signal!")
 
-			log.Info("Flush any pending federator backend writes")
-			s.FederatorSvc.Flush()
-
 			log.Info("Stop Cron scheduler")
 			cronStopCtx := cronScheduler.Stop()
+			jobCancel()
 			cronStopTimeoutTimer := time.NewTimer(time.Duration(s.Config.CronJobTimeoutSecond) * time.Second)
 			select {
 			case <-cronStopCtx.Done():
@@ -115,6 +312,16 @@ func (s Service) Run(ctx context.Context) error {
 				log.Warn("Timeout waiting for running Cron jobs to stop!")
 			}
 
+			log.Info("Flush any pending federator backend writes")
+			s.FederatorSvc.Flush()
+
+			if httpServer != nil {
+				log.Info("Gracefully stop HTTP server")
+				if err := httpServer.Shutdown(ctx); err != nil {
+					log.Errorf("Failed to stop http server: %v", err)
+				}
+			}
+
 			log.Info("Graceful stop completed")
 
 		case <-ctx.Done():
@@ -128,6 +335,33 @@ func (s Service) Run(ctx context.Context) error {
 	return nil
 }
 
+// newHTTPServer returns an HTTP server exposing federator metrics.
+func (s Service) newHTTPServer() *server.Server {
+	promRegistry := promclient.NewRegistry()
+	promRegistry.MustRegister(version.NewCollector("planet_federator"))
+	promRegistry.MustRegister(federator.Collectors()...)
+	promRegistry.MustRegister(prometheus.Collectors()...)
+
+	handler := http.NewServeMux()
+	handler.Handle("/metrics", promhttp.HandlerFor(
+		promclient.Gatherers{promRegistry},
+		promhttp.HandlerOpts{ // nolint:exhaustivestruct
+			ErrorHandling: promhttp.ContinueOnError,
+		},
+	))
+
+	return server.New(handler)
+}
+
+// jobContext returns the parent context for a cron job.
+func (s Service) jobContext() context.Context {
+	if s.jobCtx == nil {
+		return context.Background()
+	}
+
+	return s.jobCtx
+}
+
 // getCronJobStartTime returns the time for cron job starting point.
 func (s Service) getCronJobStartTime() time.Time {
 	// We want to offset the query time by the specified offset
@@ -135,93 +369,315 @@ func (s Service) getCronJobStartTime() time.Time {
 }
 
 // getCronJobDuration returns the duration since the cron job was started.
+// Both times carry a monotonic clock reading, so the duration isn't affected by wall clock steps.
 func (s Service) getCronJobDuration(startTime time.Time) time.Duration {
 	// We want to offset the query time by the specified offset
 	return time.Now().Add(s.Config.CronJobTimeOffset).Sub(startTime)
 }
 
+// jobQueryWindow is the time window of Prometheus data processed by a job run.
+const jobQueryWindow = 15 * time.Second
+
 // TrafficBandwidthJobFunc queries traffic bandwidth (planet-exporter) data from Prometheus and store
 // them in federator backend.
 func (s Service) TrafficBandwidthJobFunc() {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(s.Config.CronJobTimeoutSecond)*time.Second)
+	ctx, cancel := context.WithTimeout(s.jobContext(), time.Duration(s.Config.CronJobTimeoutSecond)*time.Second)
 	defer cancel()
+	ctx, queryStats := prometheus.WithQueryStats(ctx)
 
-	jobStartTime := s.getCronJobStartTime()
+	windowStart, jobStartTime := s.trafficBandwidthWindow.Next(time.Now(), s.Config.CronJobTimeOffset)
 	log.Debugf("A job started: %v", jobStartTime)
 
-	trafficPeers, err := s.PrometheusSvc.QueryPlanetExporterTrafficBandwidth(ctx, jobStartTime.Add(-15*time.Second), jobStartTime)
+	dataPointTime := s.Config.TimestampAlignment.Align(windowStart, jobStartTime)
+	federator.ObserveJobQueryWindow("traffic_bandwidth", windowStart, jobStartTime)
+
+	trafficPeers, err := s.PrometheusSvc.QueryPlanetExporterTrafficBandwidth(ctx, windowStart, jobStartTime, s.Config.TrafficDirections)
 	if err != nil {
 		log.Errorf("Error querying traffic peers from prometheus: %v", err)
 	}
 
+	// Rows are buffered and sorted, so every run writes them in the same order
+	var filtered int
+	rows := make([]federator.TrafficBandwidth, 0, len(trafficPeers))
 	for _, trafficPeer := range trafficPeers {
-		_ = s.FederatorSvc.AddTrafficBandwidthData(ctx, federator.TrafficBandwidth{
+		if !s.Config.HostgroupFilter.AllowRow(trafficPeer.LocalHostgroup, trafficPeer.RemoteHostgroup) {
+			filtered++
+
+			continue
+		}
+		rows = append(rows, federator.TrafficBandwidth{
 			LocalHostgroup:  trafficPeer.LocalHostgroup,
 			LocalAddress:    trafficPeer.LocalDomain,
 			RemoteHostgroup: trafficPeer.RemoteHostgroup,
 			RemoteDomain:    trafficPeer.RemoteDomain,
 			BitsPerSecond:   trafficPeer.BandwidthBitsPerSecond,
 			Direction:       trafficPeer.Direction,
-		}, jobStartTime)
+			LocalInstance:   trafficPeer.LocalInstance,
+			WindowStart:     &windowStart,
+		})
+	}
+	federator.SortTrafficBandwidth(rows)
+
+	rowLimit := federator.NewHostgroupRowLimit(s.Config.FederatorMaxRowsPerHostgroup)
+	var writeErrors int
+	var lastWriteErr error
+	for _, row := range rows {
+		if !rowLimit.Allow(row.LocalHostgroup) {
+			continue
+		}
+		if err := s.FederatorSvc.AddTrafficBandwidthData(ctx, row, dataPointTime); err != nil {
+			writeErrors++
+			lastWriteErr = err
+		}
+	}
+
+	rowLimit.WarnDropped("Traffic Bandwidth Job")
+	if filtered > 0 {
+		log.Debugf("Traffic Bandwidth Job filtered out %v rows by hostgroup", filtered)
+	}
+	if writeErrors > 0 {
+		log.Errorf("Traffic Bandwidth Job failed to write %v rows, last error: %v", writeErrors, lastWriteErr)
 	}
 
-	log.Infof("Traffic Bandwidth Job took: %v", s.getCronJobDuration(jobStartTime))
+	stats := queryStats.Stats()
+	prometheus.ObserveJobQueryStats("traffic_bandwidth", stats)
+	log.Infof("Traffic Bandwidth Job took: %v, query window: %v to %v, queries: %v in %v, series: %v, warnings: %v", s.getCronJobDuration(jobStartTime),
+		windowStart.Format(time.RFC3339), jobStartTime.Format(time.RFC3339), stats.Queries, stats.Duration, stats.Series, stats.Warnings)
 }
 
 // UpstreamServicesJobFunc queries upstream services (planet-exporter) data from Prometheus and store
 // them in federator backend.
 func (s Service) UpstreamServicesJobFunc() {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(s.Config.CronJobTimeoutSecond)*time.Second)
+	ctx, cancel := context.WithTimeout(s.jobContext(), time.Duration(s.Config.CronJobTimeoutSecond)*time.Second)
 	defer cancel()
+	ctx, queryStats := prometheus.WithQueryStats(ctx)
 
-	jobStartTime := s.getCronJobStartTime()
+	windowStart, jobStartTime := s.upstreamServicesWindow.Next(time.Now(), s.Config.CronJobTimeOffset)
 	log.Debugf("A job started: %v", jobStartTime)
 
-	upstreamServices, err := s.PrometheusSvc.QueryPlanetExporterUpstreamServices(ctx, jobStartTime.Add(-15*time.Second), jobStartTime)
-	if err != nil {
-		log.Errorf("Error querying upstream services from prometheus: %v", err)
+	dataPointTime := s.Config.TimestampAlignment.Align(windowStart, jobStartTime)
+	federator.ObserveJobQueryWindow("upstream_services", windowStart, jobStartTime)
+
+	upstreamServices, queryErr := s.PrometheusSvc.QueryPlanetExporterUpstreamServices(ctx, windowStart, jobStartTime)
+	if queryErr != nil {
+		log.Errorf("Error querying upstream services from prometheus: %v", queryErr)
 	}
 
+	// Edges are buffered and sorted, so every run writes them in the same order
+	var filtered int
+	edges := make([]federator.UpstreamService, 0, len(upstreamServices))
 	for _, svc := range upstreamServices {
-		_ = s.FederatorSvc.AddUpstreamService(ctx, federator.UpstreamService{
+		// Filtered edges aren't present in the run, as if they were gone
+		if !s.Config.HostgroupFilter.AllowRow(svc.LocalHostgroup, svc.RemoteHostgroup) {
+			filtered++
+
+			continue
+		}
+		edges = append(edges, federator.UpstreamService{
 			LocalProcessName:  svc.LocalProcessName,
 			LocalHostgroup:    svc.LocalHostgroup,
 			LocalAddress:      svc.LocalAddress,
 			UpstreamHostgroup: svc.RemoteHostgroup,
 			UpstreamAddress:   svc.RemoteAddress,
 			UpstreamPort:      svc.Port,
-			Protocol:          svc.Protocol,
-		}, jobStartTime)
+			Protocol:          federator.ProtocolOrDefault(svc.Protocol, s.Config.DefaultProtocol),
+		})
+	}
+	federator.SortUpstreamServices(edges)
+
+	delta := s.upstreamDelta.Begin(jobStartTime)
+	rowLimit := federator.NewHostgroupRowLimit(s.Config.FederatorMaxRowsPerHostgroup)
+	var writeErrors, unchanged int
+	var lastWriteErr error
+	for _, edge := range edges {
+		// Every present edge is observed, the delta compares edges without their first-seen time and window start
+		row := s.firstSeen.Upstream(edge, dataPointTime)
+		row.WindowStart = &windowStart
+		if !delta.ShouldWrite(edge) {
+			unchanged++
+
+			continue
+		}
+		if !rowLimit.Allow(edge.LocalHostgroup) {
+			delta.Unwritten(edge)
+
+			continue
+		}
+		if err := s.FederatorSvc.AddUpstreamService(ctx, row, dataPointTime); err != nil {
+			delta.Unwritten(edge)
+			writeErrors++
+			lastWriteErr = err
+		}
+	}
+
+	rowLimit.WarnDropped("Upstream Service Job")
+	if filtered > 0 {
+		log.Debugf("Upstream Service Job filtered out %v rows by hostgroup", filtered)
+	}
+	if writeErrors > 0 {
+		log.Errorf("Upstream Service Job failed to write %v rows, last error: %v", writeErrors, lastWriteErr)
+	}
+	// A failed query returns no edges, which must not be mistaken for every edge being removed
+	if queryErr == nil && s.upstreamDelta != nil {
+		removed := delta.Done()
+		log.Debugf("Upstream Service Job delta: resync %v, %v unchanged and %v removed edges", delta.Resync(), unchanged, removed)
+	}
+	if queryErr == nil && s.firstSeen != nil {
+		if forgotten := s.firstSeen.EndUpstreamRun(); forgotten > 0 {
+			log.Debugf("Upstream Service Job forgot the first-seen time of %v idle edges", forgotten)
+		}
 	}
 
-	log.Infof("Upstream Service Job took: %v", s.getCronJobDuration(jobStartTime))
+	stats := queryStats.Stats()
+	prometheus.ObserveJobQueryStats("upstream_services", stats)
+	log.Infof("Upstream Service Job took: %v, query window: %v to %v, queries: %v in %v, series: %v, warnings: %v", s.getCronJobDuration(jobStartTime),
+		windowStart.Format(time.RFC3339), jobStartTime.Format(time.RFC3339), stats.Queries, stats.Duration, stats.Series, stats.Warnings)
 }
 
 // DownstreamServicesJobFunc queries downstream services (planet-exporter) data from Prometheus and store
 // them in federator backend.
 func (s Service) DownstreamServicesJobFunc() {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(s.Config.CronJobTimeoutSecond)*time.Second)
+	ctx, cancel := context.WithTimeout(s.jobContext(), time.Duration(s.Config.CronJobTimeoutSecond)*time.Second)
 	defer cancel()
+	ctx, queryStats := prometheus.WithQueryStats(ctx)
 
-	jobStartTime := s.getCronJobStartTime()
+	windowStart, jobStartTime := s.downstreamServicesWindow.Next(time.Now(), s.Config.CronJobTimeOffset)
 	log.Debugf("A job started: %v", jobStartTime)
 
-	downstreamServices, err := s.PrometheusSvc.QueryPlanetExporterDownstreamServices(ctx, jobStartTime.Add(-15*time.Second), jobStartTime)
-	if err != nil {
-		log.Errorf("Error querying downstream services from prometheus: %v", err)
+	dataPointTime := s.Config.TimestampAlignment.Align(windowStart, jobStartTime)
+	federator.ObserveJobQueryWindow("downstream_services", windowStart, jobStartTime)
+
+	downstreamServices, queryErr := s.PrometheusSvc.QueryPlanetExporterDownstreamServices(ctx, windowStart, jobStartTime)
+	if queryErr != nil {
+		log.Errorf("Error querying downstream services from prometheus: %v", queryErr)
 	}
 
+	// Edges are buffered and sorted, so every run writes them in the same order
+	var filtered int
+	edges := make([]federator.DownstreamService, 0, len(downstreamServices))
 	for _, svc := range downstreamServices {
-		_ = s.FederatorSvc.AddDownstreamService(ctx, federator.DownstreamService{
+		// Filtered edges aren't present in the run, as if they were gone
+		if !s.Config.HostgroupFilter.AllowRow(svc.LocalHostgroup, svc.RemoteHostgroup) {
+			filtered++
+
+			continue
+		}
+		edges = append(edges, federator.DownstreamService{
 			LocalProcessName:    svc.LocalProcessName,
 			LocalHostgroup:      svc.LocalHostgroup,
 			LocalAddress:        svc.LocalAddress,
 			DownstreamHostgroup: svc.RemoteHostgroup,
 			DownstreamAddress:   svc.RemoteAddress,
 			LocalPort:           svc.Port,
-			Protocol:            svc.Protocol,
-		}, jobStartTime)
+			Protocol:            federator.ProtocolOrDefault(svc.Protocol, s.Config.DefaultProtocol),
+		})
+	}
+	federator.SortDownstreamServices(edges)
+
+	delta := s.downstreamDelta.Begin(jobStartTime)
+	rowLimit := federator.NewHostgroupRowLimit(s.Config.FederatorMaxRowsPerHostgroup)
+	var writeErrors, unchanged int
+	var lastWriteErr error
+	for _, edge := range edges {
+		// Every present edge is observed, the delta compares edges without their first-seen time and window start
+		row := s.firstSeen.Downstream(edge, dataPointTime)
+		row.WindowStart = &windowStart
+		if !delta.ShouldWrite(edge) {
+			unchanged++
+
+			continue
+		}
+		if !rowLimit.Allow(edge.LocalHostgroup) {
+			delta.Unwritten(edge)
+
+			continue
+		}
+		if err := s.FederatorSvc.AddDownstreamService(ctx, row, dataPointTime); err != nil {
+			delta.Unwritten(edge)
+			writeErrors++
+			lastWriteErr = err
+		}
+	}
+
+	rowLimit.WarnDropped("Downstream Service Job")
+	if filtered > 0 {
+		log.Debugf("Downstream Service Job filtered out %v rows by hostgroup", filtered)
+	}
+	if writeErrors > 0 {
+		log.Errorf("Downstream Service Job failed to write %v rows, last error: %v", writeErrors, lastWriteErr)
+	}
+	// A failed query returns no edges, which must not be mistaken for every edge being removed
+	if queryErr == nil && s.downstreamDelta != nil {
+		removed := delta.Done()
+		log.Debugf("Downstream Service Job delta: resync %v, %v unchanged and %v removed edges", delta.Resync(), unchanged, removed)
+	}
+	if queryErr == nil && s.firstSeen != nil {
+		if forgotten := s.firstSeen.EndDownstreamRun(); forgotten > 0 {
+			log.Debugf("Downstream Service Job forgot the first-seen time of %v idle edges", forgotten)
+		}
+	}
+
+	stats := queryStats.Stats()
+	prometheus.ObserveJobQueryStats("downstream_services", stats)
+	log.Infof("Downstream Service Job took: %v, query window: %v to %v, queries: %v in %v, series: %v, warnings: %v", s.getCronJobDuration(jobStartTime),
+		windowStart.Format(time.RFC3339), jobStartTime.Format(time.RFC3339), stats.Queries, stats.Duration, stats.Series, stats.Warnings)
+}
+
+// CollectorHealthJobFunc queries planet-exporter's own collector scrape metrics from Prometheus and store
+// a health summary per hostgroup in federator backend.
+func (s Service) CollectorHealthJobFunc() {
+	ctx, cancel := context.WithTimeout(s.jobContext(), time.Duration(s.Config.CronJobTimeoutSecond)*time.Second)
+	defer cancel()
+	ctx, queryStats := prometheus.WithQueryStats(ctx)
+
+	jobStartTime := s.getCronJobStartTime()
+	log.Debugf("A job started: %v", jobStartTime)
+
+	// Collector health is an instant query, its window starts and ends at the job start time
+	federator.ObserveJobQueryWindow("collector_health", jobStartTime, jobStartTime)
+	collectorHealth, err := s.PrometheusSvc.QueryPlanetExporterCollectorHealth(ctx, jobStartTime)
+	if err != nil {
+		log.Errorf("Error querying collector health from prometheus: %v", err)
+	}
+
+	// Rows are buffered and sorted, so every run writes them in the same order
+	var filtered int
+	rows := make([]federator.CollectorHealth, 0, len(collectorHealth))
+	for _, health := range collectorHealth {
+		if !s.Config.HostgroupFilter.AllowRow(health.LocalHostgroup) {
+			filtered++
+
+			continue
+		}
+		rows = append(rows, federator.CollectorHealth{
+			LocalHostgroup:     health.LocalHostgroup,
+			Collector:          health.Collector,
+			Instances:          health.Instances,
+			FailingInstances:   health.FailingInstances,
+			AvgDurationSeconds: health.AvgDurationSeconds,
+			WindowStart:        &jobStartTime,
+		})
+	}
+	federator.SortCollectorHealth(rows)
+
+	var writeErrors int
+	var lastWriteErr error
+	for _, row := range rows {
+		if err := s.FederatorSvc.AddCollectorHealth(ctx, row, jobStartTime); err != nil {
+			writeErrors++
+			lastWriteErr = err
+		}
+	}
+
+	if filtered > 0 {
+		log.Debugf("Collector Health Job filtered out %v rows by hostgroup", filtered)
+	}
+	if writeErrors > 0 {
+		log.Errorf("Collector Health Job failed to write %v rows, last error: %v", writeErrors, lastWriteErr)
 	}
 
-	log.Infof("Downstream Service Job took: %v", s.getCronJobDuration(jobStartTime))
+	stats := queryStats.Stats()
+	prometheus.ObserveJobQueryStats("collector_health", stats)
+	log.Infof("Collector Health Job took: %v, query time: %v, queries: %v in %v, series: %v, warnings: %v", s.getCronJobDuration(jobStartTime),
+		jobStartTime.Format(time.RFC3339), stats.Queries, stats.Duration, stats.Series, stats.Warnings)
 }
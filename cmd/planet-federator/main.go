@@ -18,26 +18,48 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
 	"planet-exporter/cmd/planet-federator/internal"
 	federator "planet-exporter/federator"
 	influxdbFederator "planet-exporter/federator/influxdb"
+	"planet-exporter/federator/influxdb/query"
+	influxdb1Federator "planet-exporter/federator/influxdb1"
+	kustoFederator "planet-exporter/federator/kusto"
+	"planet-exporter/federator/ndjson"
+	"planet-exporter/pkg/clock"
+	"planet-exporter/pkg/httpheader"
+	"planet-exporter/pkg/httpproxy"
+	"planet-exporter/pkg/printconfig"
+	pkgprometheus "planet-exporter/pkg/prometheus"
 	"planet-exporter/prometheus"
 
 	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
 	influxdb2domain "github.com/influxdata/influxdb-client-go/v2/domain"
+	influxdb1 "github.com/influxdata/influxdb1-client/v2"
 	promapi "github.com/prometheus/client_golang/api"
+	cron "github.com/robfig/cron/v3"
 	log "github.com/sirupsen/logrus"
 )
 
 var version string
 
+// Federator backends selectable with -federator-backends.
+const (
+	influxdbBackend  = "influxdb"
+	influxdb1Backend = "influxdb1"
+	stdoutBackend    = "stdout"
+	kustoBackend     = "kusto"
+)
+
 func main() {
 	var err error
 	var config internal.Config
+	config.InfluxdbHTTPHeaders = http.Header{}
 
 	// cronJobTimeOffsetDuration allows federator to go back in time. For example,
 	// set '-10h30m' to tell federator to offset query time to 10 hours 30 minutes ago.
@@ -45,43 +67,199 @@ func main() {
 	// This is useful when we want to integrate federator to existing Prometheus setup.
 	// TODO: Allows running multiple jobs for federator to catch up faster.
 	var cronJobTimeOffsetDuration string
+	// allowFutureOffset allows a positive cronJobTimeOffsetDuration, which queries data from the future.
+	var allowFutureOffset bool
+
+	// trafficDirections is a comma-separated list of traffic directions to query and write.
+	var trafficDirections string
+
+	// federatorBackends is a comma-separated list of backends to write pre-processed data to.
+	var federatorBackends string
+
+	// includeHostgroups and excludeHostgroups are comma-separated hostgroup names or globs to federate, or not.
+	var includeHostgroups, excludeHostgroups string
+	// trafficPerInstanceHostgroups is a comma-separated list of hostgroups whose traffic is also federated per instance.
+	var trafficPerInstanceHostgroups string
+
+	// importFile of NDJSON records (e.g. written by the stdout backend) to write to the backends instead of running
+	// the jobs, "-" for stdin, and importRateLimit of its records per second.
+	var importFile string
+	var importRateLimit float64
+
+	// timestampAlignment is the point of the query window that data points are stamped with.
+	var timestampAlignment string
+	var influxdbPrecision string
+
+	// influxdbBatchSizeSet is whether -influxdb-batch-size is set, otherwise the Influxdb v2 client keeps its own default
+	var influxdbBatchSizeSet bool
 
 	var showVersionAndExit bool
 
+	// printConfigAndExit prints the effective config with secrets redacted, then exits (e.g. to debug a deployment)
+	var printConfigAndExit bool
+	var printConfigFormat string
+
 	const (
-		defaultInfluxBatchSize      = 20
-		defaultCronJobTimeoutSecond = 30
+		defaultInfluxBatchSize        = 20
+		defaultInfluxFlushInterval    = time.Second
+		defaultInfluxRequestTimeout   = 20 * time.Second
+		defaultInfluxMaxRetries       = 3
+		defaultInfluxRetryInterval    = 5 * time.Second
+		defaultInfluxMaxRetryInterval = 5 * time.Minute
+		defaultInfluxRetryBufferLimit = 50000
+		defaultKustoBatchSize         = 500
+		defaultKustoFlushInterval     = 10 * time.Second
+		defaultKustoRequestTimeout    = 30 * time.Second
+		defaultCronJobTimeoutSecond   = 30
+		defaultWriteRateLimitBurst    = 100
+		defaultCircuitBreakerCooldown = time.Minute
+		defaultDeltaResyncInterval    = time.Hour
+		defaultPrometheusRetention    = 15 * 24 * time.Hour
 	)
 
 	// Main
 	flag.StringVar(&config.CronJobSchedule, "cron-job-schedule", "*/30 * * * * *", "Cron jobs schedule (Quartz: s m h dom mo dow y) to pre-process planet-exporter metrics")
 	flag.IntVar(&config.CronJobTimeoutSecond, "cron-job-timeout-second", defaultCronJobTimeoutSecond, "Timeout per federator job in second")
 	flag.StringVar(&cronJobTimeOffsetDuration, "cron-job-time-offset", "0s", "Cron jobs time offset. (e.g. '-1h5m' to query data from 1 hour 5 minutes ago)")
+	flag.BoolVar(&allowFutureOffset, "allow-future-offset", false, "Allow a positive -cron-job-time-offset, which queries data from the future")
 	flag.StringVar(&config.LogLevel, "log-level", "info", "Log level")
 	flag.BoolVar(&config.LogDisableTimestamp, "log-disable-timestamp", false, "Disable timestamp on logger")
 	flag.BoolVar(&config.LogDisableColors, "log-disable-colors", false, "Disable colors on logger")
 	flag.BoolVar(&showVersionAndExit, "version", false, "Show version and exit")
+	flag.BoolVar(&printConfigAndExit, "print-config", false, "Print the effective configuration (after parsing every flag) in -print-config-format with secrets redacted, and exit")
+	flag.StringVar(&printConfigFormat, "print-config-format", printconfig.FormatYAML, "Format of -print-config, 'yaml' or 'json'")
+	flag.StringVar(&config.ListenAddress, "listen-address", "", "Address to which federator will bind its HTTP interface for metrics (e.g. '0.0.0.0:19101'), disabled if empty")
+
+	// Federator
+	flag.StringVar(&federatorBackends, "federator-backends", influxdbBackend, "Comma-separated backends (influxdb, influxdb1, kusto, stdout) to write pre-processed planet-exporter data to, stdout writes NDJSON records")
+	flag.StringVar(&importFile, "import-file", "", "NDJSON records (as written by the stdout backend) to write to -federator-backends with their time and exit, '-' for stdin, instead of running the jobs")
+	flag.Float64Var(&importRateLimit, "import-rate-limit", 0, "Maximum records per second written by -import-file, unlimited if zero")
+	flag.Float64Var(&config.FederatorWriteRateLimit, "federator-write-rate-limit", 0, "Maximum backend writes (data points) per second shared by all jobs, unlimited if zero")
+	flag.IntVar(&config.FederatorWriteRateLimitBurst, "federator-write-rate-limit-burst", defaultWriteRateLimitBurst, "Maximum backend writes (data points) allowed at once when rate limited")
+	flag.IntVar(&config.FederatorCircuitBreakerThreshold, "federator-circuit-breaker-threshold", 0, "Consecutive backend write failures before failing fast, disabled if zero")
+	flag.DurationVar(&config.FederatorCircuitBreakerCooldown, "federator-circuit-breaker-cooldown", defaultCircuitBreakerCooldown, "Duration to fail backend writes fast before probing the backend again")
+	flag.IntVar(&config.FederatorMaxRowsPerHostgroup, "federator-max-rows-per-hostgroup", 0, "Maximum rows written per local hostgroup per job run, overflow is dropped, unlimited if zero")
+	flag.BoolVar(&config.FederatorDeltaMode, "federator-delta-mode", false, "Only write upstream/downstream edges that are new or changed since the previous job run")
+	flag.DurationVar(&config.FederatorDeltaResyncInterval, "federator-delta-resync-interval", defaultDeltaResyncInterval, "Interval between job runs writing every upstream/downstream edge in delta mode")
+	flag.BoolVar(&config.FederatorFirstSeen, "federator-first-seen", false, "Write the time each upstream/downstream edge was first seen at (first_seen field/column), seeded at startup from the first_seen written to the influxdb/influxdb1 backend in the last 7d")
+	flag.IntVar(&config.FederatorFirstSeenMaxIdleRuns, "federator-first-seen-max-idle-runs", federator.DefaultFirstSeenMaxIdleRuns, "Forget the first-seen time of an edge missing from that many job runs, it's first seen again if it comes back. Never forgotten if zero")
+	flag.DurationVar(&config.ClockStepThreshold, "clock-step-threshold", clock.DefaultStepThreshold, "Minimum wall clock step (e.g. an NTP correction) between job runs that's logged and counted, a backward step clamps the query window to the previous one")
+	flag.BoolVar(&config.FederatorStrictTrafficDirection, "federator-strict-traffic-direction", false, "Reject traffic rows with a direction other than ingress/egress instead of storing them as unknown")
+	flag.StringVar(&config.DefaultProtocol, "default-protocol", "", "Protocol (e.g. 'ip') of upstream/downstream rows without an L4 protocol (darkstat/ebpf-derived), left empty if empty")
+	flag.StringVar(&timestampAlignment, "timestamp-alignment", "end", "Stamp data points with the query window 'end' (job start time), 'start', or 'midpoint'")
+	flag.StringVar(&config.TrafficAggregation, "traffic-aggregation", prometheus.TrafficAggregationMax, "Reduce the traffic bandwidth samples of a query window to their 'max', or 'ema' (exponential moving average) to smooth transient spikes")
+	flag.Float64Var(&config.TrafficAggregationEMAAlpha, "traffic-aggregation-ema-alpha", prometheus.DefaultEMAAlpha, "Smoothing factor (0.0-1.0] of -traffic-aggregation=ema, higher values follow recent samples more closely")
+	flag.Float64Var(&config.TrafficMinBitsPerSecond, "traffic-min-bps", prometheus.DefaultTrafficMinBitsPerSecond, "Minimum bandwidth in bits per second of queried traffic, lower traffic is filtered out as noise, disabled if zero")
+	flag.StringVar(&includeHostgroups, "include-hostgroups", "", "Comma-separated hostgroup names or globs (e.g. 'payment-*') to federate, rows with another local or remote hostgroup are skipped, all hostgroups if empty")
+	flag.StringVar(&excludeHostgroups, "exclude-hostgroups", "", "Comma-separated hostgroup names or globs not to federate, rows with such a local or remote hostgroup are skipped, taking precedence over -include-hostgroups")
+	flag.StringVar(&trafficPerInstanceHostgroups, "traffic-per-instance-hostgroups", "", "Comma-separated hostgroup names whose traffic is also federated per planet-exporter instance (local_instance), with an additional Prometheus query")
+	flag.BoolVar(&config.TrafficSocketstat, "traffic-socketstat", false, "Include the socketstat traffic (planet_traffic_bytes_total{source=\"socketstat\"}) in the traffic bandwidth, only when no host runs it alongside darkstat/ebpf, which would count its traffic twice")
+	flag.StringVar(&trafficDirections, "traffic-directions", "ingress,egress", "Comma-separated traffic directions (ingress, egress) to query and write")
 
 	// Influxdb
 	flag.StringVar(&config.InfluxdbAddr, "influxdb-addr", "http://127.0.0.1:8086", "Target Influxdb HTTP Address to store pre-processed planet-exporter data")
 	flag.StringVar(&config.InfluxdbToken, "influxdb-token", "", "Target Influxdb token")
 	flag.StringVar(&config.InfluxdbOrg, "influxdb-org", "mothership", "Influxdb organization")
 	flag.StringVar(&config.InfluxdbBucket, "influxdb-bucket", "mothership", "Influxdb bucket")
-	flag.IntVar(&config.InfluxdbBatchSize, "influxdb-batch-size", defaultInfluxBatchSize, "Influxdb batch size")
+	flag.IntVar(&config.InfluxdbBatchSize, "influxdb-batch-size", defaultInfluxBatchSize, "Influxdb batch size (the influxdb backend keeps its client default of 5000 unless set)")
+	flag.DurationVar(&config.InfluxdbFlushInterval, "influxdb-flush-interval", defaultInfluxFlushInterval, "Interval to write an Influxdb batch that isn't full yet")
+	flag.DurationVar(&config.InfluxdbRequestTimeout, "influxdb-request-timeout", defaultInfluxRequestTimeout, "Influxdb HTTP request timeout")
+	flag.BoolVar(&config.InfluxdbUseGZip, "influxdb-gzip", false, "Compress Influxdb writes with gzip")
+	flag.StringVar(&influxdbPrecision, "influxdb-precision", influxdbFederator.DefaultPrecision, "Timestamp precision (s, ms, us, ns) of Influxdb writes")
+	flag.StringVar(&config.InfluxdbTLS.CAFile, "influxdb-tls-ca-file", "", "PEM CA bundle trusted (in addition to system CAs) to verify Influxdb HTTPS certificates")
+	flag.StringVar(&config.InfluxdbTLS.CertFile, "influxdb-tls-cert-file", "", "PEM client certificate for Influxdb HTTPS requests (requires -influxdb-tls-key-file)")
+	flag.StringVar(&config.InfluxdbTLS.KeyFile, "influxdb-tls-key-file", "", "PEM client key for Influxdb HTTPS requests (requires -influxdb-tls-cert-file)")
+	flag.BoolVar(&config.InfluxdbTLS.InsecureSkipVerify, "influxdb-tls-insecure-skip-verify", false, "Skip verifying Influxdb HTTPS certificates (insecure)")
+	flag.StringVar(&config.InfluxdbProxyURL, "influxdb-proxy-url", "", "Proxy URL of Influxdb requests, 'direct' to disable the proxy, or HTTP_PROXY/HTTPS_PROXY/NO_PROXY if empty")
+	flag.Var(httpheader.Flag(config.InfluxdbHTTPHeaders), "influxdb-http-header", "Custom 'key=value' HTTP header set on Influxdb requests, can be repeated")
+	flag.IntVar(&config.InfluxdbMaxRetries, "influxdb-max-retries", defaultInfluxMaxRetries, "Maximum retries of a failed Influxdb batch write (e.g. 5xx), not retried if zero")
+	flag.DurationVar(&config.InfluxdbRetryInterval, "influxdb-retry-interval", defaultInfluxRetryInterval, "Delay before retrying a failed Influxdb batch write, exponentially backed off on further failures")
+	flag.DurationVar(&config.InfluxdbMaxRetryInterval, "influxdb-max-retry-interval", defaultInfluxMaxRetryInterval, "Maximum delay before retrying a failed Influxdb batch write")
+	flag.IntVar(&config.InfluxdbRetryBufferLimit, "influxdb-retry-buffer-limit", defaultInfluxRetryBufferLimit, "Maximum data points kept for Influxdb write retries, the oldest batches are dropped over it")
+
+	// Influxdb 1.x
+	flag.StringVar(&config.Influxdb1Addr, "influxdb1-addr", "http://127.0.0.1:8086", "Target Influxdb 1.x HTTP Address to store pre-processed planet-exporter data")
+	flag.StringVar(&config.Influxdb1Username, "influxdb1-username", "", "Target Influxdb 1.x username")
+	flag.StringVar(&config.Influxdb1Password, "influxdb1-password", "", "Target Influxdb 1.x password")
+	flag.StringVar(&config.Influxdb1Database, "influxdb1-database", "mothership", "Influxdb 1.x database")
+	flag.StringVar(&config.Influxdb1RetentionPolicy, "influxdb1-retention-policy", "", "Influxdb 1.x retention policy, the database default if empty")
+
+	// Kusto
+	flag.StringVar(&config.KustoClusterURL, "kusto-cluster-url", "", "Azure Data Explorer (Kusto) cluster URL (e.g. 'https://mycluster.westeurope.kusto.windows.net')")
+	flag.StringVar(&config.KustoDatabase, "kusto-database", "mothership", "Kusto database")
+	flag.StringVar(&config.KustoTrafficTable, "kusto-traffic-table", kustoFederator.DefaultTrafficTable, "Kusto table of traffic records")
+	flag.StringVar(&config.KustoDependencyTable, "kusto-dependency-table", kustoFederator.DefaultDependencyTable, "Kusto table of upstream/downstream dependency records")
+	flag.IntVar(&config.KustoBatchSize, "kusto-batch-size", defaultKustoBatchSize, "Maximum records of a table ingested into Kusto at once")
+	flag.DurationVar(&config.KustoFlushInterval, "kusto-flush-interval", defaultKustoFlushInterval, "Interval to ingest a Kusto batch that isn't full yet, only after every job run if zero")
+	flag.DurationVar(&config.KustoRequestTimeout, "kusto-request-timeout", defaultKustoRequestTimeout, "Timeout of a Kusto batch ingestion (its management commands, blob upload, and queue message), and of token requests")
+	flag.StringVar(&config.KustoAuth.Method, "kusto-auth", kustoFederator.AuthManagedIdentity, "Kusto authentication: 'managed-identity' or 'client-secret' (an Azure AD app registration)")
+	flag.StringVar(&config.KustoAuth.ClientID, "kusto-client-id", "", "Client ID of the user-assigned managed identity (the system-assigned one if empty), or of the app registration")
+	flag.StringVar(&config.KustoAuth.TenantID, "kusto-tenant-id", "", "Azure AD tenant ID of the app registration (-kusto-auth=client-secret)")
+	flag.StringVar(&config.KustoAuth.ClientSecret, "kusto-client-secret", "", "Client secret of the app registration (-kusto-auth=client-secret)")
 
 	// Prometheus
-	flag.StringVar(&config.PrometheusAddr, "prometheus-addr", "http://127.0.0.1:9090/", "Prometheus address containing planet-exporter metrics")
+	flag.StringVar(&config.PrometheusAddr, "prometheus-addr", "http://127.0.0.1:9090/", "Comma-separated Prometheus addresses containing planet-exporter metrics, queries fail over across them")
+	flag.DurationVar(&config.PrometheusDialTimeout, "prometheus-dial-timeout", 30*time.Second, "Prometheus API client connection dial timeout")
+	flag.DurationVar(&config.PrometheusTLSHandshakeTimeout, "prometheus-tls-handshake-timeout", 10*time.Second, "Prometheus API client TLS handshake timeout")
+	flag.DurationVar(&config.PrometheusIdleConnTimeout, "prometheus-idle-conn-timeout", 90*time.Second, "Prometheus API client idle keep-alive connection timeout")
+	flag.DurationVar(&config.PrometheusResponseHeaderTimeout, "prometheus-response-header-timeout", 0, "Prometheus API client timeout waiting for response headers, no timeout if zero")
+	flag.DurationVar(&config.PrometheusRetention, "prometheus-retention", defaultPrometheusRetention, "Prometheus data retention, warn when -cron-job-time-offset queries older data, unknown if zero")
+	flag.IntVar(&config.PrometheusQueryCacheMaxEntries, "prometheus-query-cache-max-entries", 0, "Maximum Prometheus query results cached for one cron schedule interval and shared by the jobs, disabled if zero")
+	flag.IntVar(&config.PrometheusQueryConcurrency, "prometheus-query-concurrency", 0, "Maximum Prometheus queries running at once across the jobs firing on the same schedule, the others wait, unlimited if zero")
+	flag.StringVar(&config.PrometheusSource, "prometheus-source", prometheus.SourceQuery, "Source of the traffic and upstream/downstream series: 'query' (PromQL range queries), or 'vm-export' (VictoriaMetrics /api/v1/export, evaluated locally)")
+	flag.BoolVar(&config.PrometheusProxyFromEnv, "prometheus-proxy-from-env", true, "Use HTTP_PROXY, HTTPS_PROXY, and NO_PROXY environment variables for the Prometheus API client")
+	flag.StringVar(&config.PrometheusProxyURL, "prometheus-proxy-url", "", "Proxy URL of the Prometheus API client overriding -prometheus-proxy-from-env, 'direct' to disable the proxy")
 
 	flag.Parse()
 
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "influxdb-batch-size" {
+			influxdbBatchSizeSet = true
+		}
+	})
+
 	if showVersionAndExit {
-		fmt.Println("planet-federator", version) // nolint:forbidigo
+		fmt.Println("planet-federator", version, "schema version", federator.SchemaVersion) // nolint:forbidigo
 		os.Exit(0)
 	}
 
-	config.CronJobTimeOffset, err = time.ParseDuration(cronJobTimeOffsetDuration)
+	config.CronJobTimeOffset, err = federator.ParseTimeOffset(cronJobTimeOffsetDuration, allowFutureOffset)
+	if err != nil {
+		log.Fatalf("Error parsing cron-job-time-offset: %v", err)
+	}
+
+	config.TrafficDirections, err = federator.ParseTrafficDirections(trafficDirections)
+	if err != nil {
+		log.Fatalf("Error parsing traffic-directions: %v", err)
+	}
+
+	config.HostgroupFilter, err = federator.ParseHostgroupFilter(includeHostgroups, excludeHostgroups)
+	if err != nil {
+		log.Fatalf("Error parsing include-hostgroups/exclude-hostgroups: %v", err)
+	}
+
+	config.TrafficPerInstanceHostgroups = parseHostgroups(trafficPerInstanceHostgroups)
+
+	config.TimestampAlignment, err = federator.ParseTimestampAlignment(timestampAlignment)
+	if err != nil {
+		log.Fatalf("Error parsing timestamp-alignment: %v", err)
+	}
+
+	config.InfluxdbPrecision, err = influxdbFederator.ParsePrecision(influxdbPrecision)
 	if err != nil {
-		log.Fatalf("Error parsing cron-job-time-offset-minute: %v", err)
+		log.Fatalf("Error parsing influxdb-precision: %v", err)
+	}
+
+	config.FederatorBackends, err = parseFederatorBackends(federatorBackends)
+	if err != nil {
+		log.Fatalf("Error parsing federator-backends: %v", err)
+	}
+
+	if printConfigAndExit {
+		if err := printconfig.Print(os.Stdout, printConfigFormat, config); err != nil {
+			log.Fatalf("Failed to print config: %v", err)
+		}
+		os.Exit(0)
 	}
 
 	log.SetFormatter(&log.TextFormatter{ // nolint:exhaustivestruct
@@ -95,40 +273,202 @@ func main() {
 	}
 	log.SetLevel(logLevel)
 
-	log.Infof("Planet Federator %v", version)
+	log.Infof("Planet Federator %v (schema version %v)", version, federator.SchemaVersion)
 	log.Infof("Initialize log with level %v", config.LogLevel)
 
 	ctx := context.Background()
 
-	log.Info("Initialize Prometheus API client")
-	promapiClient, err := promapi.NewClient(promapi.Config{
-		Address:      config.PrometheusAddr,
-		RoundTripper: http.DefaultTransport,
+	log.Info("Initialize Prometheus API clients")
+	promapiTransport := prometheus.NewTransport(prometheus.TransportConfig{
+		DialTimeout:           config.PrometheusDialTimeout,
+		TLSHandshakeTimeout:   config.PrometheusTLSHandshakeTimeout,
+		IdleConnTimeout:       config.PrometheusIdleConnTimeout,
+		ResponseHeaderTimeout: config.PrometheusResponseHeaderTimeout,
+		ProxyFromEnvironment:  config.PrometheusProxyFromEnv,
 	})
-	if err != nil {
-		log.Fatalf("Error initializing Prometheus client for addr %v: %v", config.PrometheusAddr, err)
+	if config.PrometheusProxyURL != "" {
+		promapiTransport.Proxy, err = httpproxy.Func(config.PrometheusProxyURL)
+		if err != nil {
+			log.Fatalf("Error parsing Prometheus proxy URL: %v", err)
+		}
+	}
+	prometheusEndpoints := []prometheus.Endpoint{}
+	for _, prometheusAddr := range strings.Split(config.PrometheusAddr, ",") {
+		prometheusAddr = strings.TrimSpace(prometheusAddr)
+		if prometheusAddr == "" {
+			continue
+		}
+		promapiClient, err := promapi.NewClient(promapi.Config{
+			Address:      prometheusAddr,
+			RoundTripper: promapiTransport,
+		})
+		if err != nil {
+			log.Fatalf("Error initializing Prometheus client for addr %v: %v", prometheusAddr, err)
+		}
+		prometheusEndpoints = append(prometheusEndpoints, prometheus.Endpoint{
+			Address: prometheusAddr,
+			Client:  promapiClient,
+		})
+	}
+	if len(prometheusEndpoints) == 0 {
+		log.Fatalf("No Prometheus address is configured")
 	}
 
-	log.Info("Initialize Influxdb client")
-	influxdbClient := influxdb2.NewClient(config.InfluxdbAddr, config.InfluxdbToken)
-	influxdbHealth, err := influxdbClient.Health(ctx)
+	log.Info("Initialize Prometheus service")
+	prometheusSvc := prometheus.New(prometheusEndpoints...)
+	if config.PrometheusQueryCacheMaxEntries > 0 {
+		queryCacheTTL, err := cronScheduleInterval(config.CronJobSchedule)
+		if err != nil {
+			log.Fatalf("Error parsing cron-job-schedule: %v", err)
+		}
+		log.Infof("Enable Prometheus query cache (max entries: %v, ttl: %v)", config.PrometheusQueryCacheMaxEntries, queryCacheTTL)
+		prometheusSvc = prometheusSvc.WithQueryCache(queryCacheTTL, config.PrometheusQueryCacheMaxEntries)
+	}
+	if config.PrometheusQueryConcurrency > 0 {
+		log.Infof("Limit Prometheus queries running at once to %v", config.PrometheusQueryConcurrency)
+		prometheusSvc = prometheusSvc.WithQueryConcurrency(config.PrometheusQueryConcurrency)
+	}
+	prometheusSvc, err = prometheusSvc.WithSource(config.PrometheusSource)
+	if err != nil {
+		log.Fatalf("Error parsing prometheus-source: %v", err)
+	}
+	if config.PrometheusSource == prometheus.SourceVMExport {
+		log.Info("Export traffic and upstream/downstream series from VictoriaMetrics")
+	}
+	prometheusSvc, err = prometheusSvc.WithTrafficAggregation(config.TrafficAggregation, config.TrafficAggregationEMAAlpha)
 	if err != nil {
-		log.Fatalf("Target Influxdb (%v) health-check error: %v", config.InfluxdbAddr, err)
+		log.Fatalf("Error parsing traffic-aggregation: %v", err)
 	}
-	if influxdbHealth.Status != influxdb2domain.HealthCheckStatusPass {
-		log.Fatalf("Target Influxdb (%v) is unhealthy: %v", config.InfluxdbAddr, err)
+	log.Infof("Traffic aggregation: %v", config.TrafficAggregation)
+	prometheusSvc, err = prometheusSvc.WithTrafficMinBitsPerSecond(config.TrafficMinBitsPerSecond)
+	if err != nil {
+		log.Fatalf("Error parsing traffic-min-bps: %v", err)
 	}
-	defer influxdbClient.Close()
 
-	log.Info("Initialize Prometheus service")
-	prometheusSvc := prometheus.New(promapiClient)
+	if len(config.TrafficPerInstanceHostgroups) > 0 {
+		log.Infof("Federate traffic per instance of hostgroups: %v", config.TrafficPerInstanceHostgroups)
+		prometheusSvc = prometheusSvc.WithTrafficPerInstanceHostgroups(config.TrafficPerInstanceHostgroups)
+	}
+	if config.TrafficSocketstat {
+		log.Info("Federate socketstat traffic")
+		prometheusSvc = prometheusSvc.WithSocketstatTraffic()
+	}
 
 	log.Info("Initialize Federator service")
-	federatorBackend := influxdbFederator.New(influxdbClient, config.InfluxdbOrg, config.InfluxdbBucket)
-	federatorSvc := federator.New(federatorBackend)
+	federatorBackendList := []federator.Backend{}
+	// firstSeenQuerier queries the first InfluxDB backend for the first-seen times to seed at startup
+	var firstSeenQuerier internal.DependencyQuerier
+	for _, backend := range config.FederatorBackends {
+		var federatorBackend federator.Backend
+		switch backend {
+		case influxdbBackend:
+			log.Info("Initialize Influxdb client")
+			if len(config.InfluxdbHTTPHeaders) > 0 {
+				log.Infof("Custom Influxdb HTTP headers: %v", httpheader.Redacted(config.InfluxdbHTTPHeaders))
+			}
+			if config.InfluxdbTLS.InsecureSkipVerify {
+				log.Warn("Influxdb requests over HTTPS skip server certificate verification")
+			}
+			influxdbOptions, err := newInfluxdbOptions(config, influxdbBatchSizeSet)
+			if err != nil {
+				log.Fatalf("Error initializing Influxdb client options: %v", err)
+			}
+			influxdbClient := influxdb2.NewClientWithOptions(config.InfluxdbAddr, config.InfluxdbToken, influxdbOptions)
+			influxdbHealth, err := influxdbClient.Health(ctx)
+			if err != nil {
+				log.Fatalf("Target Influxdb (%v) health-check error: %v", config.InfluxdbAddr, err)
+			}
+			if influxdbHealth.Status != influxdb2domain.HealthCheckStatusPass {
+				log.Fatalf("Target Influxdb (%v) is unhealthy: %v", config.InfluxdbAddr, err)
+			}
+			defer influxdbClient.Close()
+
+			federatorBackend = influxdbFederator.New(influxdbClient, config.InfluxdbOrg, config.InfluxdbBucket, config.FederatorStrictTrafficDirection)
+			if firstSeenQuerier == nil {
+				firstSeenQuerier = query.NewFlux(influxdbClient.QueryAPI(config.InfluxdbOrg), config.InfluxdbBucket)
+			}
+		case influxdb1Backend:
+			log.Info("Initialize Influxdb 1.x client")
+			influxdb1Client, err := influxdb1.NewHTTPClient(influxdb1.HTTPConfig{ // nolint:exhaustivestruct
+				Addr:     config.Influxdb1Addr,
+				Username: config.Influxdb1Username,
+				Password: config.Influxdb1Password,
+			})
+			if err != nil {
+				log.Fatalf("Error initializing Influxdb 1.x client for addr %v: %v", config.Influxdb1Addr, err)
+			}
+			if _, _, err := influxdb1Client.Ping(0); err != nil {
+				log.Fatalf("Target Influxdb 1.x (%v) health-check error: %v", config.Influxdb1Addr, err)
+			}
+			defer influxdb1Client.Close()
+
+			federatorBackend = influxdb1Federator.New(influxdb1Client, config.Influxdb1Database, config.Influxdb1RetentionPolicy,
+				config.InfluxdbBatchSize, config.FederatorStrictTrafficDirection)
+			if firstSeenQuerier == nil {
+				firstSeenQuerier = query.New(influxdb1Client, config.Influxdb1Database, config.Influxdb1RetentionPolicy)
+			}
+		case stdoutBackend:
+			log.Info("Write NDJSON records to stdout")
+			federatorBackend = ndjson.New(os.Stdout)
+		case kustoBackend:
+			log.Info("Initialize Kusto ingestion client")
+			if config.KustoClusterURL == "" {
+				log.Fatalf("Kusto backend requires -kusto-cluster-url")
+			}
+			kustoHTTPClient := &http.Client{Timeout: config.KustoRequestTimeout} // nolint:exhaustivestruct
+			kustoTokenSource, err := kustoFederator.NewTokenSource(kustoHTTPClient, config.KustoClusterURL, config.KustoAuth)
+			if err != nil {
+				log.Fatalf("Error initializing Kusto authentication: %v", err)
+			}
+			if _, err := kustoTokenSource.Token(ctx); err != nil {
+				log.Fatalf("Target Kusto (%v) authentication error: %v", config.KustoClusterURL, err)
+			}
+			kustoIngestionURL, err := kustoFederator.IngestionURL(config.KustoClusterURL)
+			if err != nil {
+				log.Fatalf("Error initializing Kusto ingestion: %v", err)
+			}
+			kustoFederatorBackend := kustoFederator.New(
+				kustoFederator.NewQueuedIngestor(kustoHTTPClient, kustoIngestionURL, config.KustoDatabase, kustoTokenSource),
+				kustoFederator.Config{
+					TrafficTable:           config.KustoTrafficTable,
+					DependencyTable:        config.KustoDependencyTable,
+					BatchSize:              config.KustoBatchSize,
+					FlushInterval:          config.KustoFlushInterval,
+					IngestTimeout:          config.KustoRequestTimeout,
+					StrictTrafficDirection: config.FederatorStrictTrafficDirection,
+				})
+			defer kustoFederatorBackend.Close()
+
+			federatorBackend = kustoFederatorBackend
+		}
+		if config.FederatorCircuitBreakerThreshold > 0 {
+			log.Infof("Enable %v backend circuit breaker (threshold: %v, cooldown: %v)", backend, config.FederatorCircuitBreakerThreshold, config.FederatorCircuitBreakerCooldown)
+			federatorBackend = federator.NewCircuitBreakerBackend(federatorBackend, config.FederatorCircuitBreakerThreshold, config.FederatorCircuitBreakerCooldown)
+		}
+		federatorBackendList = append(federatorBackendList, federatorBackend)
+	}
+	var federatorBackend federator.Backend = federator.NewMultiBackend(federatorBackendList...)
+	if len(federatorBackendList) == 1 {
+		federatorBackend = federatorBackendList[0]
+	}
+	if importFile != "" {
+		if err := importRecords(ctx, importFile, importRateLimit, federatorBackend); err != nil {
+			log.Errorf("Import exit with error: %v", err)
+			os.Exit(1)
+		}
+
+		return
+	}
+	federatorSvc := federator.New(federator.Config{
+		WriteRateLimit:      config.FederatorWriteRateLimit,
+		WriteRateLimitBurst: config.FederatorWriteRateLimitBurst,
+	}, federatorBackend)
 
 	log.Info("Initialize main service")
 	svc := internal.New(config, federatorSvc, prometheusSvc)
+	if config.FederatorFirstSeen {
+		seedFirstSeen(ctx, svc, firstSeenQuerier)
+	}
 	if err := svc.Run(ctx); err != nil {
 		log.Errorf("Main service exit with error: %v", err)
 		os.Exit(1) // nolint:gocritic
@@ -136,3 +476,165 @@ func main() {
 
 	log.Info("Main service exit successfully")
 }
+
+// firstSeenSeedTimeout bounds the backend query seeding the first-seen times at startup.
+const firstSeenSeedTimeout = time.Minute
+
+// seedFirstSeen seeds the first-seen tracking of svc from the first_seen written to an InfluxDB backend, if any.
+// Without it, every edge is first seen again at the first job run.
+func seedFirstSeen(ctx context.Context, svc internal.Service, querier internal.DependencyQuerier) {
+	if querier == nil {
+		log.Warn("First-seen times are only seeded from an influxdb or influxdb1 backend, every edge is first seen again at the first job run")
+
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, firstSeenSeedTimeout)
+	defer cancel()
+	seeded, err := svc.SeedFirstSeen(ctx, querier)
+	if err != nil {
+		log.Warnf("Error seeding first-seen times, every edge is first seen again at the first job run: %v", err)
+
+		return
+	}
+	log.Infof("Seeded the first-seen times of %v dependencies", seeded)
+}
+
+// importRecords writes the NDJSON records of a file, or stdin if it's "-", to the backend with their time,
+// up to rateLimit records per second (unlimited if zero).
+func importRecords(ctx context.Context, path string, rateLimit float64, backend federator.Backend) error {
+	input := os.Stdin
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("error opening import file: %w", err)
+		}
+		defer file.Close()
+		input = file
+	}
+
+	log.Infof("Import records from %v", path)
+	federatorSvc := federator.New(federator.Config{
+		WriteRateLimit:      rateLimit,
+		WriteRateLimitBurst: 1,
+	}, backend)
+	stats, err := ndjson.Import(ctx, input, federatorSvc)
+	federatorSvc.Flush()
+	log.Infof("Imported %v records (skipped: %v, failed: %v)", stats.Imported, stats.Skipped, stats.Failed)
+	if err != nil {
+		return err
+	}
+	if stats.Failed > 0 {
+		return fmt.Errorf("failed to import %v records", stats.Failed)
+	}
+
+	return nil
+}
+
+// newInfluxdbOptions returns the Influxdb client options of the config, modified from the client defaults.
+// The batch size is only set if batchSizeSet, so the client default (5000) applies otherwise.
+func newInfluxdbOptions(config internal.Config, batchSizeSet bool) (*influxdb2.Options, error) {
+	if config.InfluxdbBatchSize <= 0 {
+		return nil, fmt.Errorf("invalid Influxdb batch size %v, expected a positive number", config.InfluxdbBatchSize)
+	}
+	if config.InfluxdbMaxRetries < 0 || config.InfluxdbRetryBufferLimit < 0 {
+		return nil, fmt.Errorf("invalid Influxdb max retries %v or retry buffer limit %v, expected zero or a positive number",
+			config.InfluxdbMaxRetries, config.InfluxdbRetryBufferLimit)
+	}
+
+	tlsConfig, err := pkgprometheus.NewTLSConfig(config.InfluxdbTLS)
+	if err != nil {
+		return nil, fmt.Errorf("error loading Influxdb TLS config: %w", err)
+	}
+	proxy, err := httpproxy.Func(config.InfluxdbProxyURL)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing Influxdb proxy URL: %w", err)
+	}
+
+	// The client's own HTTP client can't set a proxy or headers, so this one replaces it with the same transport defaults
+	var transport http.RoundTripper = &http.Transport{ // nolint:exhaustivestruct
+		Proxy: proxy,
+		DialContext: (&net.Dialer{ // nolint:exhaustivestruct
+			Timeout: 5 * time.Second,
+		}).DialContext,
+		TLSHandshakeTimeout: 5 * time.Second,
+		TLSClientConfig:     tlsConfig,
+		MaxIdleConns:        100,
+		MaxIdleConnsPerHost: 100,
+		IdleConnTimeout:     90 * time.Second,
+	}
+	if len(config.InfluxdbHTTPHeaders) > 0 {
+		transport = httpheader.Transport{Next: transport, Headers: config.InfluxdbHTTPHeaders}
+	}
+
+	options := influxdb2.DefaultOptions()
+	if batchSizeSet {
+		options.SetBatchSize(uint(config.InfluxdbBatchSize))
+	}
+
+	return options.
+		SetHTTPClient(&http.Client{ // nolint:exhaustivestruct
+			Timeout:   config.InfluxdbRequestTimeout,
+			Transport: transport,
+		}).
+		SetFlushInterval(uint(config.InfluxdbFlushInterval.Milliseconds())).
+		SetUseGZip(config.InfluxdbUseGZip).
+		SetPrecision(config.InfluxdbPrecision).
+		SetMaxRetries(uint(config.InfluxdbMaxRetries)).
+		SetRetryInterval(uint(config.InfluxdbRetryInterval.Milliseconds())).
+		SetMaxRetryInterval(uint(config.InfluxdbMaxRetryInterval.Milliseconds())).
+		SetRetryBufferLimit(uint(config.InfluxdbRetryBufferLimit)), nil
+}
+
+// cronScheduleInterval returns the interval between two consecutive runs of a cron schedule (with seconds).
+func cronScheduleInterval(spec string) (time.Duration, error) {
+	schedule, err := cron.NewParser(cron.Second | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor).Parse(spec)
+	if err != nil {
+		return 0, fmt.Errorf("error parsing cron schedule: %w", err)
+	}
+	next := schedule.Next(time.Now())
+
+	return schedule.Next(next).Sub(next), nil
+}
+
+// parseHostgroups parses a comma-separated list of hostgroup names, duplicates are ignored.
+func parseHostgroups(hostgroups string) []string {
+	parsed := []string{}
+	seen := make(map[string]bool)
+	for _, hostgroup := range strings.Split(hostgroups, ",") {
+		hostgroup = strings.TrimSpace(hostgroup)
+		if hostgroup == "" || seen[hostgroup] {
+			continue
+		}
+		seen[hostgroup] = true
+		parsed = append(parsed, hostgroup)
+	}
+
+	return parsed
+}
+
+// parseFederatorBackends parses a comma-separated list of federator backends (e.g. "influxdb,influxdb1").
+// Duplicates are ignored and at least one backend is required.
+func parseFederatorBackends(backends string) ([]string, error) {
+	parsed := []string{}
+	seen := make(map[string]bool)
+	for _, backend := range strings.Split(backends, ",") {
+		backend = strings.TrimSpace(backend)
+		if backend == "" {
+			continue
+		}
+		if backend != influxdbBackend && backend != influxdb1Backend && backend != kustoBackend && backend != stdoutBackend {
+			return nil, fmt.Errorf("invalid federator backend %q, expected %v, %v, %v, or %v", backend, influxdbBackend, influxdb1Backend, kustoBackend, stdoutBackend)
+		}
+		if seen[backend] {
+			continue
+		}
+		seen[backend] = true
+		parsed = append(parsed, backend)
+	}
+	if len(parsed) == 0 {
+		return nil, fmt.Errorf("no federator backend in %q, expected %v and/or %v", backends, influxdbBackend, influxdb1Backend)
+	}
+
+	return parsed, nil
+}
@@ -16,6 +16,7 @@ package collector
 
 import (
 	"errors"
+	"sort"
 	"sync"
 	"time"
 
@@ -30,17 +31,20 @@ var (
 	// All registered collectors will then be used by the PlanetCollector service.
 	collectorFactories = make(map[string]func() (Collector, error))
 
-	scrapeDurationDesc = prometheus.NewDesc(
+	scrapeDurationDesc = newDesc(
 		prometheus.BuildFQName(namespace, "scrape", "collector_duration_seconds"),
 		"planet_exporter: Duration of a collector scrape.",
 		[]string{"collector"},
-		nil,
 	)
-	scrapeSuccessDesc = prometheus.NewDesc(
+	scrapeSuccessDesc = newDesc(
 		prometheus.BuildFQName(namespace, "scrape", "collector_success"),
 		"planet_exporter: Whether a collector succeeded.",
 		[]string{"collector"},
-		nil,
+	)
+	scrapeInflightDesc = newDesc(
+		prometheus.BuildFQName(namespace, "scrape", "collector_inflight"),
+		"planet_exporter: Whether a collector was still updating for an earlier scrape, so this scrape skipped it.",
+		[]string{"collector"},
 	)
 )
 
@@ -55,6 +59,17 @@ func registerCollector(name string, factory func() (Collector, error)) {
 	collectorFactories[name] = factory
 }
 
+// RegisteredCollectorNames returns the sorted names of the registered collectors, which NewPlanetCollector instantiates.
+func RegisteredCollectorNames() []string {
+	names := make([]string, 0, len(collectorFactories))
+	for name := range collectorFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
 // ErrNoData returned when collector found no data.
 var ErrNoData = errors.New("a collector did not find any data")
 
@@ -79,12 +94,63 @@ func collectorExec(name string, c Collector, prometheusMetricsCh chan<- promethe
 
 	prometheusMetricsCh <- prometheus.MustNewConstMetric(scrapeDurationDesc, prometheus.GaugeValue, duration.Seconds(), name)
 	prometheusMetricsCh <- prometheus.MustNewConstMetric(scrapeSuccessDesc, prometheus.GaugeValue, success, name)
+	prometheusMetricsCh <- prometheus.MustNewConstMetric(scrapeInflightDesc, prometheus.GaugeValue, 0, name)
+}
+
+// collectorStalled reports a collector whose Update of an earlier scrape, started at started, is still running.
+// It fails the scrape of the collector instead of starting another Update.
+func collectorStalled(name string, started time.Time, prometheusMetricsCh chan<- prometheus.Metric) {
+	duration := time.Since(started)
+	log.Warnf("collector failed (name: %v, duration_seconds: %v): stalled, its previous update is still running", name, duration.Seconds())
+
+	prometheusMetricsCh <- prometheus.MustNewConstMetric(scrapeDurationDesc, prometheus.GaugeValue, duration.Seconds(), name)
+	prometheusMetricsCh <- prometheus.MustNewConstMetric(scrapeSuccessDesc, prometheus.GaugeValue, 0, name)
+	prometheusMetricsCh <- prometheus.MustNewConstMetric(scrapeInflightDesc, prometheus.GaugeValue, 1, name)
+}
+
+// inflightUpdates tracks the collectors whose Update is running, so concurrent scrapes don't start another Update
+// of a collector that's stalled, piling up a goroutine per scrape. It's safe for concurrent use.
+type inflightUpdates struct {
+	mu      sync.Mutex
+	started map[string]time.Time
+}
+
+// newInflightUpdates returns inflightUpdates without any running Update.
+func newInflightUpdates() *inflightUpdates {
+	return &inflightUpdates{
+		mu:      sync.Mutex{},
+		started: make(map[string]time.Time),
+	}
+}
+
+// start marks an Update of the collector as running since now. If one is already running, it returns false and the
+// time the running one started at instead.
+func (u *inflightUpdates) start(name string, now time.Time) (time.Time, bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if started, found := u.started[name]; found {
+		return started, false
+	}
+	u.started[name] = now
+
+	return now, true
+}
+
+// done marks the running Update of the collector as finished.
+func (u *inflightUpdates) done(name string) {
+	u.mu.Lock()
+	delete(u.started, name)
+	u.mu.Unlock()
 }
 
 // PlanetCollector is the service running our planetary collections
 // It retrieves all the collectors registered by registerCollector function.
 type PlanetCollector struct {
 	Collectors map[string]Collector
+
+	// inflight Updates, a collector is updated by one scrape at a time
+	inflight *inflightUpdates
 }
 
 // NewPlanetCollector service
@@ -99,28 +165,44 @@ func NewPlanetCollector() (*PlanetCollector, error) {
 		collectors[collectorName] = col
 	}
 
+	return newPlanetCollector(collectors), nil
+}
+
+// newPlanetCollector returns a PlanetCollector of the collectors.
+func newPlanetCollector(collectors map[string]Collector) *PlanetCollector {
 	return &PlanetCollector{
 		Collectors: collectors,
-	}, nil
+		inflight:   newInflightUpdates(),
+	}
 }
 
 // Describe implements prometheus.Collector interface.
 func (p PlanetCollector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- scrapeDurationDesc
 	ch <- scrapeSuccessDesc
+	ch <- scrapeInflightDesc
 }
 
 // Collect impelements prometheus.Collector interface
 // It collects metrics from saved Collectors by executing all of them together in their own goroutine.
+// A collector still updating for an earlier scrape is reported as stalled instead of updated again.
 func (p PlanetCollector) Collect(prometheusMetricsCh chan<- prometheus.Metric) {
 	waitGroup := sync.WaitGroup{}
-	waitGroup.Add(len(p.Collectors))
 
 	for name, collector := range p.Collectors {
+		started, ok := p.inflight.start(name, time.Now())
+		if !ok {
+			collectorStalled(name, started, prometheusMetricsCh)
+
+			continue
+		}
+
+		waitGroup.Add(1)
 		go func(name string, collector Collector) {
-			collectorExec(name, collector, prometheusMetricsCh)
+			defer waitGroup.Done()
+			defer p.inflight.done(name)
 
-			waitGroup.Done()
+			collectorExec(name, collector, prometheusMetricsCh)
 		}(name, collector)
 	}
 
@@ -0,0 +1,73 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"planet-exporter/collector/task/dnssnoop"
+	"planet-exporter/collector/task/inventory"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// dnsCollector on DNS query metrics of the dnssnoop task.
+type dnsCollector struct {
+	queries        *prometheus.Desc
+	domains        *prometheus.Desc
+	droppedQueries *prometheus.Desc
+}
+
+func init() {
+	registerCollector("dns", NewDNSCollector)
+}
+
+// NewDNSCollector service.
+func NewDNSCollector() (Collector, error) {
+	return &dnsCollector{
+		queries: newDesc(
+			prometheus.BuildFQName(namespace, "dns", "queries_total"),
+			"Total DNS queries of this machine per queried domain, only the most queried domains",
+			[]string{"local_hostgroup", "query_domain"},
+		),
+		domains: newDesc(
+			prometheus.BuildFQName(namespace, "dns", "query_domains"),
+			"Distinct queried domains counted, including the ones that aren't exported",
+			[]string{"local_hostgroup"},
+		),
+		droppedQueries: newDesc(
+			prometheus.BuildFQName(namespace, "dns", "dropped_queries_total"),
+			"Total DNS queries of the least queried domains no longer counted, evicted to count new domains when there were too many distinct domains",
+			[]string{"local_hostgroup"},
+		),
+	}, nil
+}
+
+// Update implements the Collector interface.
+func (c dnsCollector) Update(prometheusMetricsCh chan<- prometheus.Metric) error {
+	if !dnssnoop.Enabled() {
+		return nil
+	}
+
+	queries, stats := dnssnoop.Get()
+	localHostgroup := inventory.GetLocalInventory().Hostgroup
+
+	for _, m := range queries {
+		prometheusMetricsCh <- prometheus.MustNewConstMetric(c.queries, prometheus.CounterValue, float64(m.Queries),
+			localHostgroup, m.QueryDomain)
+	}
+	prometheusMetricsCh <- prometheus.MustNewConstMetric(c.domains, prometheus.GaugeValue, float64(stats.Domains), localHostgroup)
+	prometheusMetricsCh <- prometheus.MustNewConstMetric(c.droppedQueries, prometheus.CounterValue, float64(stats.DroppedQueries), localHostgroup)
+
+	return nil
+}
@@ -19,10 +19,14 @@ import (
 	"os"
 
 	"planet-exporter/collector/task/inventory"
+	"planet-exporter/pkg/network"
 
 	"github.com/prometheus/client_golang/prometheus"
 )
 
+// localIP returns the local IP address, replaced in tests.
+var localIP = network.LocalIP
+
 // hostmetaCollector on host related metadata.
 type hostmetaCollector struct {
 	hostname *prometheus.Desc
@@ -35,10 +39,10 @@ func init() {
 // NewHostmetaCollector service.
 func NewHostmetaCollector() (Collector, error) {
 	return &hostmetaCollector{
-		hostname: prometheus.NewDesc(
+		hostname: newDesc(
 			prometheus.BuildFQName(namespace, "", "hostname"),
 			"Hostname of the collected machine",
-			[]string{"local_hostgroup", "hostname", "domain", "ip"}, nil,
+			[]string{"local_hostgroup", "hostname", "domain", "ip"},
 		),
 	}, nil
 }
@@ -50,10 +54,36 @@ func (c hostmetaCollector) Update(prometheusMetricsCh chan<- prometheus.Metric)
 		// Kernel is probably drunk
 		return fmt.Errorf("error getting hostname: %w", err)
 	}
-	localInventory := inventory.GetLocalInventory()
+	hostgroup, domain, ip := localIdentity(hostname, inventory.GetLocalInventory())
 
 	prometheusMetricsCh <- prometheus.MustNewConstMetric(c.hostname, prometheus.GaugeValue, 1,
-		localInventory.Hostgroup, hostname, localInventory.Domain, localInventory.IPAddress)
+		hostgroup, hostname, domain, ip)
 
 	return nil
 }
+
+// localIdentity fills in the local inventory entry fields that are missing (e.g. the host isn't in the inventory,
+// or has no default route) with whatever identity is available: the local IP address, or else the hostname.
+// Like the collector tasks, the hostgroup and domain fall back to the local IP address.
+func localIdentity(hostname string, localInventory inventory.Host) (string, string, string) {
+	ip := localInventory.IPAddress
+	if ip == "" {
+		if addr, err := localIP(); err == nil {
+			ip = addr.String()
+		}
+	}
+
+	fallback := ip
+	if fallback == "" {
+		fallback = hostname
+	}
+	hostgroup, domain := localInventory.Hostgroup, localInventory.Domain
+	if hostgroup == "" {
+		hostgroup = fallback
+	}
+	if domain == "" {
+		domain = fallback
+	}
+
+	return hostgroup, domain, ip
+}
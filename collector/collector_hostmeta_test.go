@@ -0,0 +1,83 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"net"
+	"testing"
+
+	"planet-exporter/collector/task/inventory"
+	"planet-exporter/pkg/network"
+)
+
+func Test_localIdentity(t *testing.T) {
+	defer func(f func() (net.IP, error)) { localIP = f }(localIP)
+
+	failingLocalIP := func() (net.IP, error) { return nil, network.ErrLocalIPNotFound }
+	workingLocalIP := func() (net.IP, error) { return net.ParseIP("10.0.0.1"), nil }
+
+	tests := []struct {
+		name           string
+		localIP        func() (net.IP, error)
+		localInventory inventory.Host
+		wantHostgroup  string
+		wantDomain     string
+		wantIP         string
+	}{
+		{
+			name:           "Inventory host",
+			localIP:        failingLocalIP,
+			localInventory: inventory.Host{IPAddress: "10.0.0.1", Hostgroup: "myapp", Domain: "myapp.local"},
+			wantHostgroup:  "myapp",
+			wantDomain:     "myapp.local",
+			wantIP:         "10.0.0.1",
+		},
+		{
+			name:           "Not in inventory",
+			localIP:        workingLocalIP,
+			localInventory: inventory.Host{IPAddress: "", Hostgroup: "", Domain: ""},
+			wantHostgroup:  "10.0.0.1",
+			wantDomain:     "10.0.0.1",
+			wantIP:         "10.0.0.1",
+		},
+		{
+			name:           "Failing local IP with local hostgroup override",
+			localIP:        failingLocalIP,
+			localInventory: inventory.Host{IPAddress: "", Hostgroup: "myapp", Domain: ""},
+			wantHostgroup:  "myapp",
+			wantDomain:     "myhost",
+			wantIP:         "",
+		},
+		{
+			name:           "Failing local IP",
+			localIP:        failingLocalIP,
+			localInventory: inventory.Host{IPAddress: "", Hostgroup: "", Domain: ""},
+			wantHostgroup:  "myhost",
+			wantDomain:     "myhost",
+			wantIP:         "",
+		},
+	}
+	for _, testcase := range tests {
+		t.Run(testcase.name, func(t *testing.T) {
+			localIP = testcase.localIP
+
+			hostgroup, domain, ip := localIdentity("myhost", testcase.localInventory)
+			if hostgroup != testcase.wantHostgroup || domain != testcase.wantDomain || ip != testcase.wantIP {
+				t.Errorf("localIdentity() = %v, %v, %v, want %v, %v, %v", hostgroup, domain, ip,
+					testcase.wantHostgroup, testcase.wantDomain, testcase.wantIP)
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"planet-exporter/collector/task/inventory"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// inventoryCollector on inventory task related metrics.
+type inventoryCollector struct {
+	parseErrors *prometheus.Desc
+	srvErrors   *prometheus.Desc
+}
+
+func init() {
+	registerCollector("inventory", NewInventoryCollector)
+}
+
+// NewInventoryCollector service.
+func NewInventoryCollector() (Collector, error) {
+	return &inventoryCollector{
+		parseErrors: newDesc(
+			prometheus.BuildFQName(namespace, "inventory", "parse_errors_total"),
+			"Total inventory entries that were skipped or failed to parse",
+			nil,
+		),
+		srvErrors: newDesc(
+			prometheus.BuildFQName(namespace, "inventory", "srv_errors_total"),
+			"Total inventory SRV address resolution failures",
+			nil,
+		),
+	}, nil
+}
+
+// Update implements Collector interface.
+func (c inventoryCollector) Update(prometheusMetricsCh chan<- prometheus.Metric) error {
+	prometheusMetricsCh <- prometheus.MustNewConstMetric(c.parseErrors, prometheus.CounterValue, float64(inventory.GetParseErrorsTotal()))
+	prometheusMetricsCh <- prometheus.MustNewConstMetric(c.srvErrors, prometheus.CounterValue, float64(inventory.GetSRVErrorsTotal()))
+
+	return nil
+}
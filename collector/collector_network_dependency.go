@@ -15,6 +15,9 @@
 package collector
 
 import (
+	"sync/atomic"
+	"time"
+
 	"planet-exporter/collector/task/darkstat"
 	"planet-exporter/collector/task/ebpf"
 	"planet-exporter/collector/task/inventory"
@@ -23,13 +26,67 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 )
 
+// Traffic sources in the 'source' label of traffic metrics, one per collector task.
+const (
+	trafficSourceDarkstat   = "darkstat"
+	trafficSourceEbpf       = "ebpf"
+	trafficSourceSocketstat = "socketstat"
+)
+
+// skipUnlabeledDependencies drops dependency and traffic metrics without a remote hostgroup.
+var skipUnlabeledDependencies atomic.Bool
+
+// SetSkipUnlabeledDependencies sets whether dependency and traffic metrics without a remote hostgroup are dropped,
+// e.g. to avoid exporting raw address edges when the inventory is disabled.
+func SetSkipUnlabeledDependencies(skip bool) {
+	skipUnlabeledDependencies.Store(skip)
+}
+
+// keepDependency returns whether a dependency or traffic metric with the remote hostgroup is exported.
+func keepDependency(remoteHostgroup string) bool {
+	return remoteHostgroup != "" || !skipUnlabeledDependencies.Load()
+}
+
+// trafficInterfaceLabels fills the 'interface' and 'local_address' labels of darkstat traffic metrics.
+var trafficInterfaceLabels atomic.Bool
+
+// SetTrafficInterfaceLabels sets whether darkstat traffic metrics are labeled with the network interface and local
+// IP address the traffic traversed (e.g. to attribute egress cost per NIC on multi-homed hosts). The labels are empty
+// otherwise, as they multiply the traffic series by the number of interfaces.
+func SetTrafficInterfaceLabels(enabled bool) {
+	trafficInterfaceLabels.Store(enabled)
+}
+
+// ephemeralLabel returns the 'ephemeral' label value of a dependency, empty unless it's a short-lived connection.
+func ephemeralLabel(ephemeral bool) string {
+	if ephemeral {
+		return "true"
+	}
+
+	return ""
+}
+
+// staticLabel returns the 'static' label value of a dependency, empty unless it's declared in the static
+// dependencies file.
+func staticLabel(static bool) string {
+	if static {
+		return "true"
+	}
+
+	return ""
+}
+
 // networkDependencyCollector on network dependency metrics.
 type networkDependencyCollector struct {
-	serverProcesses *prometheus.Desc
-	upstream        *prometheus.Desc
-	downstream      *prometheus.Desc
-	traffic         *prometheus.Desc
-	ebpfTraffic     *prometheus.Desc
+	serverProcesses                *prometheus.Desc
+	unixSocketListener             *prometheus.Desc
+	upstream                       *prometheus.Desc
+	downstream                     *prometheus.Desc
+	traffic                        *prometheus.Desc
+	trafficBitsPerSec              *prometheus.Desc
+	ebpfTraffic                    *prometheus.Desc
+	trafficSnapshotAge             *prometheus.Desc
+	socketstatProcessScanTruncated *prometheus.Desc
 }
 
 func init() {
@@ -40,61 +97,168 @@ func init() {
 // All metrics have current host's Hostgroup identified in the 'local_hostgroup' label.
 func NewNetworkDependencyCollector() (Collector, error) {
 	return &networkDependencyCollector{
-		serverProcesses: prometheus.NewDesc(
+		serverProcesses: newDesc(
 			prometheus.BuildFQName(namespace, "", "server_process"),
 			"Server process that are listening on network interfaces",
-			[]string{"local_hostgroup", "bind", "process_name", "port"}, nil,
+			[]string{"local_hostgroup", "bind", "process_name", "port"},
+		),
+		unixSocketListener: newDesc(
+			prometheus.BuildFQName(namespace, "", "unix_socket_listener"),
+			"Server process that is listening on a Unix socket (opt-in)",
+			[]string{"local_hostgroup", "path", "process_name"},
 		),
-		traffic: prometheus.NewDesc(
+		traffic: newDesc(
 			prometheus.BuildFQName(namespace, "", "traffic_bytes_total"),
-			"Total network traffic with peers",
-			[]string{"local_hostgroup", "direction", "remote_hostgroup", "remote_ip", "local_domain", "remote_domain"}, nil,
+			"Total network traffic with peers. The socketstat source (opt-in) is approximate: it sums the TCP payload bytes "+
+				"acked and received on the sockets of the traffic ports per remote hostgroup and port, since the exporter started, "+
+				"without UDP, headers, and retransmissions, and misses the bytes of a socket after the previous collection if it "+
+				"closes before the next one",
+			[]string{"local_hostgroup", "direction", "remote_hostgroup", "remote_ip", "local_domain", "remote_domain", "source",
+				"interface", "local_address", "local_port", "remote_port"},
+		),
+		trafficBitsPerSec: newDesc(
+			prometheus.BuildFQName(namespace, "", "traffic_bits_per_second"),
+			"Network traffic rate with peers since the previous collection",
+			[]string{"local_hostgroup", "direction", "remote_hostgroup", "remote_ip", "local_domain", "remote_domain", "source",
+				"interface", "local_address"},
 		),
-		ebpfTraffic: prometheus.NewDesc(
+		ebpfTraffic: newDesc(
 			prometheus.BuildFQName(namespace, "", "ebpf_traffic_bytes_total"),
 			"Total network traffic with peers from ebpf_exporter",
-			[]string{"local_hostgroup", "direction", "remote_hostgroup", "remote_ip", "local_domain", "remote_domain"}, nil,
+			[]string{"local_hostgroup", "direction", "remote_hostgroup", "remote_ip", "local_domain", "remote_domain", "source"},
 		),
-		upstream: prometheus.NewDesc(
+		trafficSnapshotAge: newDesc(
+			prometheus.BuildFQName(namespace, "", "traffic_snapshot_age_seconds"),
+			"Age of the traffic data collected by a source task at scrape time",
+			[]string{"source"},
+		),
+		socketstatProcessScanTruncated: newDesc(
+			prometheus.BuildFQName(namespace, "socketstat", "process_scan_truncated"),
+			"Whether the process table scan of the latest socketstat collection exceeded its timeout, so the connections of "+
+				"the processes that weren't scanned have no process name",
+			nil,
+		),
+		upstream: newDesc(
 			prometheus.BuildFQName(namespace, "", "upstream"),
-			"Upstream dependency of this machine",
-			[]string{"local_hostgroup", "remote_hostgroup", "local_address", "remote_address", "port", "protocol", "process_name"}, nil,
+			"Upstream dependency of this machine, port is the remote port (deprecated: use remote_port)",
+			[]string{"local_hostgroup", "remote_hostgroup", "local_address", "remote_address", "port", "protocol", "process_name", "edge_scope", "ephemeral", "remote_service_name",
+				"local_port", "remote_port", "static"},
 		),
-		downstream: prometheus.NewDesc(
+		downstream: newDesc(
 			prometheus.BuildFQName(namespace, "", "downstream"),
-			"Downstream dependency of this machine",
-			[]string{"local_hostgroup", "remote_hostgroup", "local_address", "remote_address", "port", "protocol", "process_name"}, nil,
+			"Downstream dependency of this machine, port is the local port (deprecated: use local_port)",
+			[]string{"local_hostgroup", "remote_hostgroup", "local_address", "remote_address", "port", "protocol", "process_name", "edge_scope", "ephemeral",
+				"local_port", "remote_port", "static"},
 		),
 	}, nil
 }
 
 // Update implements the Collector interface.
 func (c networkDependencyCollector) Update(prometheusMetricsCh chan<- prometheus.Metric) error {
+	darkstatCollectedAt, ebpfCollectedAt := darkstat.LastCollectTime(), ebpf.LastCollectTime()
 	traffic := darkstat.Get()
 	ebpf := ebpf.Get()
 	serverProcesses, upstreams, downstreams := socketstat.Get()
 	localInventory := inventory.GetLocalInventory()
 
-	for _, m := range traffic {
-		prometheusMetricsCh <- prometheus.MustNewConstMetric(c.traffic, prometheus.GaugeValue, m.Bandwidth,
-			m.LocalHostgroup, m.Direction, m.RemoteHostgroup, m.RemoteIPAddr, m.LocalDomain, m.RemoteDomain)
+	c.updateDarkstatTraffic(prometheusMetricsCh, traffic)
+	c.updateEbpfTraffic(prometheusMetricsCh, ebpf)
+	c.updateSocketstatTraffic(prometheusMetricsCh, socketstat.GetTraffic(), localInventory.Domain)
+	now := time.Now()
+	for source, collectedAt := range map[string]time.Time{
+		trafficSourceDarkstat: darkstatCollectedAt,
+		trafficSourceEbpf:     ebpfCollectedAt,
+	} {
+		// Tasks that are disabled or haven't collected yet have no snapshot
+		if collectedAt.IsZero() {
+			continue
+		}
+		prometheusMetricsCh <- prometheus.MustNewConstMetric(c.trafficSnapshotAge, prometheus.GaugeValue, now.Sub(collectedAt).Seconds(), source)
 	}
-	for _, m := range ebpf {
-		prometheusMetricsCh <- prometheus.MustNewConstMetric(c.ebpfTraffic, prometheus.GaugeValue, m.Bandwidth,
-			m.LocalHostgroup, m.Direction, m.RemoteHostgroup, m.RemoteIPAddr, m.LocalDomain, m.RemoteDomain)
+	staticUpstreams, staticDownstreams := socketstat.GetStaticDependencies(upstreams, downstreams)
+	c.updateDependencies(prometheusMetricsCh, upstreams, downstreams, false)
+	c.updateDependencies(prometheusMetricsCh, staticUpstreams, staticDownstreams, true)
+	var truncated float64
+	if socketstat.GetStats().ProcessScanTruncated {
+		truncated = 1
 	}
+	prometheusMetricsCh <- prometheus.MustNewConstMetric(c.socketstatProcessScanTruncated, prometheus.GaugeValue, truncated)
+	for _, m := range serverProcesses {
+		prometheusMetricsCh <- prometheus.MustNewConstMetric(c.serverProcesses, prometheus.GaugeValue, 1,
+			localInventory.Hostgroup, m.Bind, m.Name, m.Port)
+	}
+	for _, m := range socketstat.GetUnixSocketListeners() {
+		prometheusMetricsCh <- prometheus.MustNewConstMetric(c.unixSocketListener, prometheus.GaugeValue, 1,
+			localInventory.Hostgroup, m.Path, m.ProcessName)
+	}
+
+	return nil
+}
+
+// updateDependencies sends upstream and downstream metrics, static ones are declared in the static dependencies file.
+func (c networkDependencyCollector) updateDependencies(prometheusMetricsCh chan<- prometheus.Metric, upstreams, downstreams []socketstat.Connections, static bool) {
 	for _, m := range upstreams {
+		if !keepDependency(m.RemoteHostgroup) {
+			continue
+		}
 		prometheusMetricsCh <- prometheus.MustNewConstMetric(c.upstream, prometheus.GaugeValue, 1,
-			m.LocalHostgroup, m.RemoteHostgroup, m.LocalAddress, m.RemoteAddress, m.Port, m.Protocol, m.ProcessName)
+			m.LocalHostgroup, m.RemoteHostgroup, m.LocalAddress, m.RemoteAddress, m.Port, m.Protocol, m.ProcessName, m.EdgeScope, ephemeralLabel(m.Ephemeral),
+			m.RemoteServiceName, "", m.Port, staticLabel(static))
 	}
 	for _, m := range downstreams {
+		if !keepDependency(m.RemoteHostgroup) {
+			continue
+		}
 		prometheusMetricsCh <- prometheus.MustNewConstMetric(c.downstream, prometheus.GaugeValue, 1,
-			m.LocalHostgroup, m.RemoteHostgroup, m.LocalAddress, m.RemoteAddress, m.Port, m.Protocol, m.ProcessName)
+			m.LocalHostgroup, m.RemoteHostgroup, m.LocalAddress, m.RemoteAddress, m.Port, m.Protocol, m.ProcessName, m.EdgeScope, ephemeralLabel(m.Ephemeral),
+			m.Port, "", staticLabel(static))
 	}
-	for _, m := range serverProcesses {
-		prometheusMetricsCh <- prometheus.MustNewConstMetric(c.serverProcesses, prometheus.GaugeValue, 1,
-			localInventory.Hostgroup, m.Bind, m.Name, m.Port)
+}
+
+// updateDarkstatTraffic sends darkstat traffic metrics.
+// Bandwidth is darkstat's host_bytes_total, a monotonically increasing byte count, so it's a counter.
+func (c networkDependencyCollector) updateDarkstatTraffic(prometheusMetricsCh chan<- prometheus.Metric, traffic []darkstat.Metric) {
+	withInterfaceLabels := trafficInterfaceLabels.Load()
+	for _, m := range traffic {
+		if !keepDependency(m.RemoteHostgroup) {
+			continue
+		}
+		iface, localAddress := "", ""
+		if withInterfaceLabels {
+			iface, localAddress = m.Interface, m.LocalIPAddr
+		}
+		prometheusMetricsCh <- prometheus.MustNewConstMetric(c.traffic, prometheus.CounterValue, m.Bandwidth,
+			m.LocalHostgroup, m.Direction, m.RemoteHostgroup, m.RemoteIPAddr, m.LocalDomain, m.RemoteDomain, trafficSourceDarkstat,
+			iface, localAddress, "", "")
+		if m.HasBitsPerSecond {
+			prometheusMetricsCh <- prometheus.MustNewConstMetric(c.trafficBitsPerSec, prometheus.GaugeValue, m.BitsPerSecond,
+				m.LocalHostgroup, m.Direction, m.RemoteHostgroup, m.RemoteIPAddr, m.LocalDomain, m.RemoteDomain, trafficSourceDarkstat,
+				iface, localAddress)
+		}
 	}
+}
 
-	return nil
+// updateEbpfTraffic sends ebpf traffic metrics.
+// Bandwidth is ebpf_exporter's tcptop byte count, a monotonically increasing byte count, so it's a counter.
+func (c networkDependencyCollector) updateEbpfTraffic(prometheusMetricsCh chan<- prometheus.Metric, traffic []ebpf.Metric) {
+	for _, m := range traffic {
+		if !keepDependency(m.RemoteHostgroup) {
+			continue
+		}
+		prometheusMetricsCh <- prometheus.MustNewConstMetric(c.ebpfTraffic, prometheus.CounterValue, m.Bandwidth,
+			m.LocalHostgroup, m.Direction, m.RemoteHostgroup, m.RemoteIPAddr, m.LocalDomain, m.RemoteDomain, trafficSourceEbpf)
+	}
+}
+
+// updateSocketstatTraffic sends socketstat traffic metrics, summed from the kernel per-socket byte counters per remote
+// hostgroup and port, so they have neither a remote IP nor a remote domain.
+func (c networkDependencyCollector) updateSocketstatTraffic(prometheusMetricsCh chan<- prometheus.Metric, traffic []socketstat.Traffic, localDomain string) {
+	for _, m := range traffic {
+		if !keepDependency(m.RemoteHostgroup) {
+			continue
+		}
+		prometheusMetricsCh <- prometheus.MustNewConstMetric(c.traffic, prometheus.CounterValue, m.Bytes,
+			m.LocalHostgroup, m.Direction, m.RemoteHostgroup, "", localDomain, "", trafficSourceSocketstat,
+			"", "", m.LocalPort, m.RemotePort)
+	}
 }
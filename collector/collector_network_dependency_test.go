@@ -0,0 +1,204 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"testing"
+
+	"planet-exporter/collector/task/darkstat"
+	"planet-exporter/collector/task/ebpf"
+	"planet-exporter/collector/task/socketstat"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestNetworkDependencyCollector_trafficValueType(t *testing.T) {
+	c, err := NewNetworkDependencyCollector()
+	if err != nil {
+		t.Fatalf("NewNetworkDependencyCollector() error = %v", err)
+	}
+	collector, ok := c.(*networkDependencyCollector)
+	if !ok {
+		t.Fatalf("NewNetworkDependencyCollector() = %T, want *networkDependencyCollector", c)
+	}
+
+	metricsCh := make(chan prometheus.Metric, 10)
+	collector.updateDarkstatTraffic(metricsCh, []darkstat.Metric{
+		{Direction: "egress", RemoteIPAddr: "10.0.0.1", Bandwidth: 2000, BitsPerSecond: 800, HasBitsPerSecond: true},
+	})
+	collector.updateEbpfTraffic(metricsCh, []ebpf.Metric{
+		{Direction: "egress", RemoteIPAddr: "10.0.0.1", Bandwidth: 3000},
+	})
+	close(metricsCh)
+
+	tests := map[*prometheus.Desc]struct {
+		wantCounter bool
+		wantValue   float64
+	}{
+		collector.traffic:           {wantCounter: true, wantValue: 2000},
+		collector.trafficBitsPerSec: {wantCounter: false, wantValue: 800},
+		collector.ebpfTraffic:       {wantCounter: true, wantValue: 3000},
+	}
+	got := 0
+	for metric := range metricsCh {
+		got++
+		var m dto.Metric
+		if err := metric.Write(&m); err != nil {
+			t.Fatalf("Metric.Write() error = %v", err)
+		}
+		name := metric.Desc().String()
+		testcase, ok := tests[metric.Desc()]
+		if !ok {
+			t.Errorf("unexpected metric %v", name)
+
+			continue
+		}
+		if testcase.wantCounter {
+			if m.GetCounter() == nil || m.GetCounter().GetValue() != testcase.wantValue {
+				t.Errorf("%v = %v, want counter %v", name, m.String(), testcase.wantValue)
+			}
+		} else if m.GetGauge() == nil || m.GetGauge().GetValue() != testcase.wantValue {
+			t.Errorf("%v = %v, want gauge %v", name, m.String(), testcase.wantValue)
+		}
+	}
+	if got != len(tests) {
+		t.Errorf("got %v metrics, want %v", got, len(tests))
+	}
+}
+
+func TestNetworkDependencyCollector_skipUnlabeledDependencies(t *testing.T) {
+	defer SetSkipUnlabeledDependencies(false)
+
+	c, err := NewNetworkDependencyCollector()
+	if err != nil {
+		t.Fatalf("NewNetworkDependencyCollector() error = %v", err)
+	}
+	collector, ok := c.(*networkDependencyCollector)
+	if !ok {
+		t.Fatalf("NewNetworkDependencyCollector() = %T, want *networkDependencyCollector", c)
+	}
+	traffic := []darkstat.Metric{
+		{Direction: "egress", RemoteIPAddr: "10.0.0.1", RemoteHostgroup: "myapp", Bandwidth: 2000},
+		{Direction: "egress", RemoteIPAddr: "10.0.0.2", RemoteHostgroup: "", Bandwidth: 3000},
+	}
+
+	tests := []struct {
+		name string
+		skip bool
+		want int
+	}{
+		{name: "Keep unlabeled dependencies", skip: false, want: 2},
+		{name: "Skip unlabeled dependencies", skip: true, want: 1},
+	}
+	for _, testcase := range tests {
+		t.Run(testcase.name, func(t *testing.T) {
+			SetSkipUnlabeledDependencies(testcase.skip)
+
+			metricsCh := make(chan prometheus.Metric, 10)
+			collector.updateDarkstatTraffic(metricsCh, traffic)
+			close(metricsCh)
+			if got := len(metricsCh); got != testcase.want {
+				t.Errorf("updateDarkstatTraffic() sent %v metrics, want %v", got, testcase.want)
+			}
+		})
+	}
+}
+
+func TestNetworkDependencyCollector_trafficInterfaceLabels(t *testing.T) {
+	defer SetTrafficInterfaceLabels(false)
+
+	c, err := NewNetworkDependencyCollector()
+	if err != nil {
+		t.Fatalf("NewNetworkDependencyCollector() error = %v", err)
+	}
+	collector, ok := c.(*networkDependencyCollector)
+	if !ok {
+		t.Fatalf("NewNetworkDependencyCollector() = %T, want *networkDependencyCollector", c)
+	}
+	traffic := []darkstat.Metric{
+		{Direction: "egress", RemoteIPAddr: "10.0.0.1", Interface: "eth1", LocalIPAddr: "10.20.0.5", Bandwidth: 2000},
+	}
+
+	tests := []struct {
+		name             string
+		enabled          bool
+		wantInterface    string
+		wantLocalAddress string
+	}{
+		{name: "Disabled", enabled: false, wantInterface: "", wantLocalAddress: ""},
+		{name: "Enabled", enabled: true, wantInterface: "eth1", wantLocalAddress: "10.20.0.5"},
+	}
+	for _, testcase := range tests {
+		t.Run(testcase.name, func(t *testing.T) {
+			SetTrafficInterfaceLabels(testcase.enabled)
+
+			metricsCh := make(chan prometheus.Metric, 10)
+			collector.updateDarkstatTraffic(metricsCh, traffic)
+			close(metricsCh)
+
+			var m dto.Metric
+			if err := (<-metricsCh).Write(&m); err != nil {
+				t.Fatalf("Metric.Write() error = %v", err)
+			}
+			labels := map[string]string{}
+			for _, label := range m.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+			if labels["interface"] != testcase.wantInterface || labels["local_address"] != testcase.wantLocalAddress {
+				t.Errorf("updateDarkstatTraffic() labels = %v, want interface %q and local_address %q",
+					labels, testcase.wantInterface, testcase.wantLocalAddress)
+			}
+		})
+	}
+}
+
+func TestNetworkDependencyCollector_socketstatTraffic(t *testing.T) {
+	c, err := NewNetworkDependencyCollector()
+	if err != nil {
+		t.Fatalf("NewNetworkDependencyCollector() error = %v", err)
+	}
+	collector, ok := c.(*networkDependencyCollector)
+	if !ok {
+		t.Fatalf("NewNetworkDependencyCollector() = %T, want *networkDependencyCollector", c)
+	}
+
+	metricsCh := make(chan prometheus.Metric, 10)
+	collector.updateSocketstatTraffic(metricsCh, []socketstat.Traffic{
+		{LocalHostgroup: "app", Direction: "egress", RemoteHostgroup: "db", RemotePort: "5432", Bytes: 4096},
+	}, "app.service.consul")
+	close(metricsCh)
+
+	var m dto.Metric
+	if err := (<-metricsCh).Write(&m); err != nil {
+		t.Fatalf("Metric.Write() error = %v", err)
+	}
+	labels := map[string]string{}
+	for _, label := range m.GetLabel() {
+		labels[label.GetName()] = label.GetValue()
+	}
+	wantLabels := map[string]string{
+		"local_hostgroup": "app", "direction": "egress", "remote_hostgroup": "db", "remote_ip": "", "local_domain": "app.service.consul",
+		"remote_domain": "", "source": "socketstat", "interface": "", "local_address": "", "local_port": "", "remote_port": "5432",
+	}
+	for name, want := range wantLabels {
+		if labels[name] != want {
+			t.Errorf("updateSocketstatTraffic() label %v = %q, want %q", name, labels[name], want)
+		}
+	}
+	if m.GetCounter().GetValue() != 4096 {
+		t.Errorf("updateSocketstatTraffic() = %v, want counter 4096", m.String())
+	}
+}
@@ -0,0 +1,61 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	pkgprometheus "planet-exporter/pkg/prometheus"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// scrapeTargetCollector on the health of the darkstat/ebpf scrape targets.
+type scrapeTargetCollector struct {
+	duration *prometheus.Desc
+	up       *prometheus.Desc
+}
+
+func init() {
+	registerCollector("scrape_target", NewScrapeTargetCollector)
+}
+
+// NewScrapeTargetCollector service.
+func NewScrapeTargetCollector() (Collector, error) {
+	return &scrapeTargetCollector{
+		duration: newDesc(
+			prometheus.BuildFQName(namespace, "scrape", "target_duration_seconds"),
+			"Duration of the latest scrape of a Prometheus endpoint (e.g. darkstat or ebpf), including its retries",
+			[]string{"target"},
+		),
+		up: newDesc(
+			prometheus.BuildFQName(namespace, "scrape", "target_up"),
+			"Whether the latest scrape of a Prometheus endpoint (e.g. darkstat or ebpf) succeeded",
+			[]string{"target"},
+		),
+	}, nil
+}
+
+// Update implements Collector interface.
+func (c scrapeTargetCollector) Update(prometheusMetricsCh chan<- prometheus.Metric) error {
+	for _, target := range pkgprometheus.GetTargetHealth() {
+		var up float64
+		if target.Up {
+			up = 1
+		}
+		prometheusMetricsCh <- prometheus.MustNewConstMetric(c.duration, prometheus.GaugeValue, target.Duration.Seconds(), target.Target)
+		prometheusMetricsCh <- prometheus.MustNewConstMetric(c.up, prometheus.GaugeValue, up, target.Target)
+	}
+
+	return nil
+}
@@ -0,0 +1,113 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRegisteredCollectorNames(t *testing.T) {
+	names := RegisteredCollectorNames()
+	if len(names) != len(collectorFactories) {
+		t.Fatalf("RegisteredCollectorNames() = %v, want %v names", names, len(collectorFactories))
+	}
+	if !sort.StringsAreSorted(names) {
+		t.Errorf("RegisteredCollectorNames() = %v, want sorted names", names)
+	}
+	for _, name := range names {
+		if _, ok := collectorFactories[name]; !ok {
+			t.Errorf("RegisteredCollectorNames() = %v, %v isn't registered", names, name)
+		}
+	}
+}
+
+// blockingCollector blocks its updates until unblock is closed, and counts them.
+type blockingCollector struct {
+	unblock <-chan struct{}
+	updates atomic.Int32
+}
+
+func (c *blockingCollector) Update(ch chan<- prometheus.Metric) error {
+	c.updates.Add(1)
+	<-c.unblock
+
+	return nil
+}
+
+func TestPlanetCollector_Collect_stalled(t *testing.T) {
+	unblock := make(chan struct{})
+	blocking := &blockingCollector{unblock: unblock} // nolint:exhaustivestruct
+	planetCollector := newPlanetCollector(map[string]Collector{"blocking": blocking})
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(planetCollector)
+
+	// The first scrape is stuck on the blocking update
+	firstScrape := sync.WaitGroup{}
+	firstScrape.Add(1)
+	go func() {
+		defer firstScrape.Done()
+		if _, err := registry.Gather(); err != nil {
+			t.Errorf("Gather() error = %v", err)
+		}
+	}()
+	for blocking.updates.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	stalled := `
+# HELP planet_scrape_collector_inflight planet_exporter: Whether a collector was still updating for an earlier scrape, so this scrape skipped it.
+# TYPE planet_scrape_collector_inflight gauge
+planet_scrape_collector_inflight{collector="blocking"} 1
+# HELP planet_scrape_collector_success planet_exporter: Whether a collector succeeded.
+# TYPE planet_scrape_collector_success gauge
+planet_scrape_collector_success{collector="blocking"} 0
+`
+	for i := 0; i < 3; i++ {
+		if err := testutil.GatherAndCompare(registry, strings.NewReader(stalled),
+			"planet_scrape_collector_inflight", "planet_scrape_collector_success"); err != nil {
+			t.Errorf("GatherAndCompare() of a stalled collector error = %v", err)
+		}
+	}
+	if updates := blocking.updates.Load(); updates != 1 {
+		t.Errorf("Collect() started %v updates of the stalled collector, want 1", updates)
+	}
+
+	close(unblock)
+	firstScrape.Wait()
+
+	recovered := `
+# HELP planet_scrape_collector_inflight planet_exporter: Whether a collector was still updating for an earlier scrape, so this scrape skipped it.
+# TYPE planet_scrape_collector_inflight gauge
+planet_scrape_collector_inflight{collector="blocking"} 0
+# HELP planet_scrape_collector_success planet_exporter: Whether a collector succeeded.
+# TYPE planet_scrape_collector_success gauge
+planet_scrape_collector_success{collector="blocking"} 1
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(recovered),
+		"planet_scrape_collector_inflight", "planet_scrape_collector_success"); err != nil {
+		t.Errorf("GatherAndCompare() after the collector recovered error = %v", err)
+	}
+	if updates := blocking.updates.Load(); updates != 2 {
+		t.Errorf("Collect() started %v updates, want 2", updates)
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"planet-exporter/collector/task/socketstat"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// upstreamConnectionsCollector on the histograms of concurrent sockets per upstream.
+type upstreamConnectionsCollector struct {
+	upstreamConnections *prometheus.Desc
+}
+
+func init() {
+	registerCollector("upstream_connections", NewUpstreamConnectionsCollector)
+}
+
+// NewUpstreamConnectionsCollector service.
+func NewUpstreamConnectionsCollector() (Collector, error) {
+	return &upstreamConnectionsCollector{
+		upstreamConnections: newDesc(
+			prometheus.BuildFQName(namespace, "", "upstream_connections"),
+			"Concurrent sockets of an upstream dependency, observed once per socketstat collection",
+			[]string{"local_hostgroup", "remote_hostgroup", "local_address", "remote_address", "port", "protocol", "process_name", "edge_scope"},
+		),
+	}, nil
+}
+
+// Update implements Collector interface.
+// There are no histograms unless they're enabled with tasksocketstat.SetUpstreamConnectionsHistogram.
+func (c upstreamConnectionsCollector) Update(prometheusMetricsCh chan<- prometheus.Metric) error {
+	for _, h := range socketstat.GetUpstreamConnectionsHistograms() {
+		m := h.Upstream
+		if !keepDependency(m.RemoteHostgroup) {
+			continue
+		}
+		prometheusMetricsCh <- prometheus.MustNewConstHistogram(c.upstreamConnections, h.Count, h.Sum, h.Buckets,
+			m.LocalHostgroup, m.RemoteHostgroup, m.LocalAddress, m.RemoteAddress, m.Port, m.Protocol, m.ProcessName, m.EdgeScope)
+	}
+
+	return nil
+}
@@ -0,0 +1,122 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+)
+
+// descLabelNames are the variable label names of the planet collector metric descs built with newDesc.
+var descLabelNames = struct {
+	mu    sync.Mutex
+	names map[string]bool
+}{
+	mu:    sync.Mutex{},
+	names: make(map[string]bool),
+}
+
+// bucketLabelNames are the label names Prometheus adds to the series of histogram buckets and summary quantiles
+// (e.g. the upstream connections histogram), which aren't variable labels of their desc.
+var bucketLabelNames = []string{model.BucketLabel, model.QuantileLabel}
+
+// newDesc returns the desc of a planet collector metric, and records its variable label names so constant labels
+// can't reuse them.
+func newDesc(fqName, help string, variableLabels []string) *prometheus.Desc {
+	descLabelNames.mu.Lock()
+	for _, name := range variableLabels {
+		descLabelNames.names[name] = true
+	}
+	descLabelNames.mu.Unlock()
+
+	return prometheus.NewDesc(fqName, help, variableLabels, nil)
+}
+
+// metricLabelNames returns the variable label names of the planet collector metrics, from the descs of every
+// registered collector. Constant labels can't reuse them, otherwise the exported series would have duplicate labels.
+func metricLabelNames() map[string]bool {
+	for _, factory := range collectorFactories {
+		// A failing factory fails NewPlanetCollector anyway, its descs are only needed for their label names here
+		_, _ = factory()
+	}
+
+	descLabelNames.mu.Lock()
+	defer descLabelNames.mu.Unlock()
+
+	names := make(map[string]bool, len(descLabelNames.names)+len(bucketLabelNames))
+	for name := range descLabelNames.names {
+		names[name] = true
+	}
+	for _, name := range bucketLabelNames {
+		names[name] = true
+	}
+
+	return names
+}
+
+// ValidateConstLabelName returns an error if name can't be a constant label of the planet collector metrics.
+func ValidateConstLabelName(name string) error {
+	if !model.LabelName(name).IsValid() || strings.HasPrefix(name, model.ReservedLabelPrefix) {
+		return fmt.Errorf("invalid metric label name %q", name)
+	}
+	if metricLabelNames()[name] {
+		return fmt.Errorf("metric label name %q is already used by planet metrics", name)
+	}
+
+	return nil
+}
+
+// ConstLabelsFlag is a repeatable 'key=value' command-line flag that collects constant labels
+// added to all planet collector metrics (e.g. datacenter or region).
+type ConstLabelsFlag prometheus.Labels
+
+// Set implements flag.Value.
+func (f ConstLabelsFlag) Set(value string) error {
+	name, labelValue, ok := strings.Cut(value, "=")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" {
+		return fmt.Errorf("error parsing metric label %q: expected 'key=value'", value)
+	}
+	if err := ValidateConstLabelName(name); err != nil {
+		return err
+	}
+	if _, exists := f[name]; exists {
+		return fmt.Errorf("duplicate metric label name %q", name)
+	}
+	f[name] = strings.TrimSpace(labelValue)
+
+	return nil
+}
+
+// String implements flag.Value.
+func (f ConstLabelsFlag) String() string {
+	names := make([]string, 0, len(f))
+	for name := range f {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	pairs := make([]string, 0, len(names))
+	for _, name := range names {
+		pairs = append(pairs, name+"="+f[name])
+	}
+
+	return strings.Join(pairs, ",")
+}
@@ -0,0 +1,83 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestConstLabelsFlag_Set(t *testing.T) {
+	tests := []struct {
+		name    string
+		values  []string
+		want    prometheus.Labels
+		wantErr bool
+	}{
+		{name: "Labels", values: []string{"datacenter=dc1", " region = us-east "}, want: prometheus.Labels{"datacenter": "dc1", "region": "us-east"}, wantErr: false},
+		{name: "Empty value", values: []string{"datacenter="}, want: prometheus.Labels{"datacenter": ""}, wantErr: false},
+		{name: "Missing value", values: []string{"datacenter"}, want: prometheus.Labels{}, wantErr: true},
+		{name: "Invalid name", values: []string{"data-center=dc1"}, want: prometheus.Labels{}, wantErr: true},
+		{name: "Reserved name", values: []string{"__name__=dc1"}, want: prometheus.Labels{}, wantErr: true},
+		{name: "Planet metric label name", values: []string{"local_hostgroup=dc1"}, want: prometheus.Labels{}, wantErr: true},
+		{name: "Scrape target label name", values: []string{"target=x"}, want: prometheus.Labels{}, wantErr: true},
+		{name: "Scrape collector label name", values: []string{"collector=x"}, want: prometheus.Labels{}, wantErr: true},
+		{name: "Unix socket label name", values: []string{"path=/run/x.sock"}, want: prometheus.Labels{}, wantErr: true},
+		{name: "Histogram bucket label name", values: []string{"le=x"}, want: prometheus.Labels{}, wantErr: true},
+		{name: "Duplicate name", values: []string{"datacenter=dc1", "datacenter=dc2"}, want: prometheus.Labels{"datacenter": "dc1"}, wantErr: true},
+	}
+	for _, testcase := range tests {
+		t.Run(testcase.name, func(t *testing.T) {
+			got := prometheus.Labels{}
+			var err error
+			for _, value := range testcase.values {
+				if err = ConstLabelsFlag(got).Set(value); err != nil {
+					break
+				}
+			}
+			if (err != nil) != testcase.wantErr {
+				t.Fatalf("ConstLabelsFlag.Set() error = %v, wantErr %v", err, testcase.wantErr)
+			}
+			if !reflect.DeepEqual(got, testcase.want) {
+				t.Errorf("ConstLabelsFlag.Set() = %v, want %v", got, testcase.want)
+			}
+		})
+	}
+}
+
+func TestPlanetCollector_constLabels(t *testing.T) {
+	hostmeta, err := NewHostmetaCollector()
+	if err != nil {
+		t.Fatalf("NewHostmetaCollector() error = %v", err)
+	}
+	planetCollector := newPlanetCollector(map[string]Collector{"hostmeta": hostmeta})
+	registry := prometheus.NewRegistry()
+	if err := prometheus.WrapRegistererWith(prometheus.Labels{"datacenter": "dc1"}, registry).Register(planetCollector); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+
+	want := `
+# HELP planet_scrape_collector_success planet_exporter: Whether a collector succeeded.
+# TYPE planet_scrape_collector_success gauge
+planet_scrape_collector_success{collector="hostmeta",datacenter="dc1"} 1
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(want), "planet_scrape_collector_success"); err != nil {
+		t.Errorf("GatherAndCompare() error = %v", err)
+	}
+}
@@ -20,6 +20,7 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"net/url"
 	"strconv"
 	"sync"
 	"time"
@@ -27,28 +28,54 @@ import (
 	"planet-exporter/collector/task/inventory"
 	"planet-exporter/pkg/network"
 	"planet-exporter/pkg/prometheus"
+	"planet-exporter/pkg/ratelog"
 
 	"github.com/prometheus/prom2json"
 	log "github.com/sirupsen/logrus"
 )
 
+// DefaultScrapeTimeout is the default timeout of a darkstat scrape.
+const DefaultScrapeTimeout = 5 * time.Second
+
 // task that queries darkstat metrics and aggregates them into usable planet metrics.
 type task struct {
 	enabled          bool
 	darkstatAddr     string
 	prometheusClient *prometheus.Client
+	// scrapeTimeout of a scrape, so a slow but connected darkstat can't hang the collection
+	scrapeTimeout time.Duration
 
 	hosts []Metric
-	mu    sync.Mutex
+	// collectedAt is the time hosts were last collected, zero if they never were
+	collectedAt time.Time
+	mu          sync.Mutex
 }
 
 var (
 	once      sync.Once
 	singleton task
+	// httpTransport of scrapes, its TLS config and proxy are set by InitTask
+	httpTransport *http.Transport
+
+	// Rate-limited logs of warnings that repeat on every collect
+	localAddrLog   = ratelog.New(ratelog.DefaultInterval)
+	parseMetricLog = ratelog.New(ratelog.DefaultInterval)
+	interfaceLog   = ratelog.New(ratelog.DefaultInterval)
+
+	// interfaceIP returns the address of a network interface, replaced in tests
+	interfaceIP = network.InterfaceIP
+)
+
+// host_bytes_total labels of the interface and local IP address the traffic traversed, when darkstat exposes them
+// (e.g. "iface" on darkstat versions running on multiple interfaces, or "local_ip" added by relabeling).
+var (
+	interfaceLabels   = []string{"interface", "iface"}
+	localIPAddrLabels = []string{"local_ip", "local_address"}
 )
 
 func init() {
-	httpTransport := &http.Transport{ // nolint:exhaustivestruct
+	httpTransport = &http.Transport{ // nolint:exhaustivestruct
+		Proxy: http.ProxyFromEnvironment,
 		DialContext: (&net.Dialer{ // nolint:exhaustivestruct
 			Timeout:   30 * time.Second,
 			KeepAlive: 30 * time.Second,
@@ -57,25 +84,52 @@ func init() {
 		MaxIdleConns:          100,
 		IdleConnTimeout:       90 * time.Second,
 		TLSHandshakeTimeout:   10 * time.Second,
-		TLSClientConfig:       &tls.Config{InsecureSkipVerify: true}, // nolint:gosec,exhaustivestruct
 		ExpectContinueTimeout: 1 * time.Second,
 	}
 
 	singleton = task{
 		enabled:          false,
 		hosts:            []Metric{},
+		collectedAt:      time.Time{},
 		mu:               sync.Mutex{},
-		prometheusClient: prometheus.New(httpTransport),
+		prometheusClient: prometheus.New(httpTransport, nil),
+		scrapeTimeout:    DefaultScrapeTimeout,
 		darkstatAddr:     "",
 	}
 }
 
 // InitTask initial states.
-func InitTask(ctx context.Context, enabled bool, darkstatAddr string) {
+// httpHeaders are set on every scrape request, and responses larger than maxResponseBytes (if positive) fail.
+// Scrapes stop reading after maxMetrics metrics (if positive), and keep the metrics read so far.
+// Scrapes over HTTPS verify the server certificate unless tlsConfig (if set) skips verification, and present the PEM
+// client certificate and key (if set) instead of the tlsConfig's (e.g. for a darkstat behind an mTLS proxy).
+// Scrapes go through proxy (see httpproxy.Func), or directly if it's nil, and time out after scrapeTimeout.
+// Scrapes failing with a network-level error are retried up to maxRetries times, after retryBackoff doubled every retry.
+func InitTask(ctx context.Context, enabled bool, darkstatAddr string, httpHeaders http.Header, maxResponseBytes int64,
+	maxMetrics int, tlsConfig *tls.Config, clientCertFile, clientKeyFile string, proxy func(*http.Request) (*url.URL, error),
+	scrapeTimeout time.Duration, maxRetries int, retryBackoff time.Duration) error {
+	tlsConfig, err := prometheus.WithClientCert(tlsConfig, clientCertFile, clientKeyFile)
+	if err != nil {
+		return fmt.Errorf("error loading darkstat client certificate: %w", err)
+	}
+	if scrapeTimeout <= 0 {
+		log.Warningf("Invalid darkstat scrape timeout '%v', fallback to %v", scrapeTimeout, DefaultScrapeTimeout)
+		scrapeTimeout = DefaultScrapeTimeout
+	}
+
 	once.Do(func() {
+		singleton.prometheusClient = prometheus.New(httpTransport, tlsConfig)
+		singleton.prometheusClient.SetProxy(proxy)
+		singleton.prometheusClient.SetRetry(maxRetries, retryBackoff)
 		singleton.enabled = enabled
 		singleton.darkstatAddr = darkstatAddr
+		singleton.prometheusClient.SetHeaders(httpHeaders)
+		singleton.prometheusClient.SetMaxResponseBytes(maxResponseBytes)
+		singleton.prometheusClient.SetMaxMetrics(maxMetrics)
+		singleton.scrapeTimeout = scrapeTimeout
 	})
+
+	return nil
 }
 
 // Metric contains values needed for planet metrics.
@@ -84,9 +138,18 @@ type Metric struct {
 	LocalHostgroup  string // e.g. hostgroup
 	RemoteHostgroup string
 	RemoteIPAddr    string
-	LocalDomain     string // e.g. consul domain
-	RemoteDomain    string
-	Bandwidth       float64
+	// Interface is the network interface the traffic traversed, and LocalIPAddr its local IP address,
+	// empty if darkstat doesn't expose them
+	Interface    string
+	LocalIPAddr  string
+	LocalDomain  string // e.g. consul domain
+	RemoteDomain string
+	Bandwidth    float64 // running byte count reported by darkstat
+
+	// BitsPerSecond is computed from the Bandwidth delta since the previous collection.
+	// It's only set when HasBitsPerSecond, i.e. the remote host was in the previous collection without a counter reset.
+	BitsPerSecond    float64
+	HasBitsPerSecond bool
 }
 
 // Get returns latest metrics from singleton.
@@ -98,6 +161,15 @@ func Get() []Metric {
 	return hosts
 }
 
+// LastCollectTime returns the time of the latest metrics from singleton, zero if there were none collected yet.
+func LastCollectTime() time.Time {
+	singleton.mu.Lock()
+	collectedAt := singleton.collectedAt
+	singleton.mu.Unlock()
+
+	return collectedAt
+}
+
 var (
 	// ErrHostBytesTotalMetricsNotFound metrics host_bytes_total not found.
 	ErrHostBytesTotalMetricsNotFound = fmt.Errorf("metric host_bytes_total not found")
@@ -117,12 +189,12 @@ func Collect(ctx context.Context) error {
 
 	startTime := time.Now()
 
-	ctxCollect, ctxCollectCancel := context.WithCancel(ctx)
+	ctxCollect, ctxCollectCancel := context.WithTimeout(ctx, singleton.scrapeTimeout)
 	defer ctxCollectCancel()
 
 	// Scrape darkstat prometheus endpoint for host_bytes_total
 	var darkstatHostBytesTotalMetric *prom2json.Family
-	darkstatScrape, err := singleton.prometheusClient.Scrape(ctxCollect, singleton.darkstatAddr)
+	darkstatScrape, err := singleton.prometheusClient.ScrapeMetricFamilies(ctxCollect, singleton.darkstatAddr, "host_bytes_total")
 	if err != nil {
 		return fmt.Errorf("error on darkstat metrics scrape: %w", err)
 	}
@@ -144,7 +216,10 @@ func Collect(ctx context.Context) error {
 	}
 
 	singleton.mu.Lock()
+	collectedAt := time.Now()
+	withBitsPerSecond(hosts, singleton.hosts, collectedAt.Sub(singleton.collectedAt))
 	singleton.hosts = hosts
+	singleton.collectedAt = collectedAt
 	singleton.mu.Unlock()
 
 	log.Debugf("taskdarkstat.Collect retrieved %v downstreams metrics", len(hosts))
@@ -166,18 +241,20 @@ func toHostMetrics(darkstatHostBytesTotal *prom2json.Family) ([]Metric, error) {
 	// To label source traffic that we need to build dependency graph
 	localHostgroup := localAddr.String()
 	localDomain := localAddr.String()
-	localInventory, ok := inventoryHosts.GetHost(localAddr.String())
+	localInventory, ok := inventoryHosts.GetLocalHost(localAddr.String())
 	if ok {
 		localHostgroup = localInventory.Hostgroup
 		localDomain = localInventory.Domain
+		localAddrLog.Reset()
 	} else {
-		log.Warnf("Local address don't exist in inventory: %v", localAddr.String())
+		localAddrLog.Warnf("Local address don't exist in inventory: %v", localAddr.String())
 	}
 
+	interfaceAddrs := interfaceAddrCache{}
 	for _, m := range darkstatHostBytesTotal.Metrics {
 		metric, ok := m.(prom2json.Metric)
 		if !ok {
-			log.Warnf("Failed to parse darkstat host_bytes_total metrics: %v", m)
+			parseMetricLog.Warnf("Failed to parse darkstat host_bytes_total metrics: %v", m)
 
 			continue
 		}
@@ -190,10 +267,15 @@ func toHostMetrics(darkstatHostBytesTotal *prom2json.Family) ([]Metric, error) {
 		}
 
 		remoteInventoryHost, _ := inventoryHosts.GetHost(metric.Labels["ip"])
+		iface := firstLabel(metric.Labels, interfaceLabels)
+		localIPAddr := firstLabel(metric.Labels, localIPAddrLabels)
+		if localIPAddr == "" && iface != "" {
+			localIPAddr = interfaceAddrs.get(iface)
+		}
 
 		bandwidth, err := strconv.ParseFloat(metric.Value, 64)
 		if err != nil {
-			log.Errorf("Failed to parse 'host_bytes_total' value: %v", err)
+			parseMetricLog.Errorf("Failed to parse 'host_bytes_total' value: %v", err)
 
 			continue
 		}
@@ -210,7 +292,9 @@ func toHostMetrics(darkstatHostBytesTotal *prom2json.Family) ([]Metric, error) {
 		hosts = append(hosts, Metric{
 			LocalHostgroup:  localHostgroup,
 			RemoteHostgroup: remoteInventoryHost.Hostgroup,
-			RemoteIPAddr:    metric.Labels["ip"],
+			RemoteIPAddr:    remoteInventoryHost.RemoteIPAddr(metric.Labels["ip"]),
+			Interface:       iface,
+			LocalIPAddr:     localIPAddr,
 			LocalDomain:     localDomain,
 			RemoteDomain:    remoteInventoryHost.Domain,
 			Direction:       direction,
@@ -218,5 +302,87 @@ func toHostMetrics(darkstatHostBytesTotal *prom2json.Family) ([]Metric, error) {
 		})
 	}
 
-	return hosts, nil
+	return mergeHosts(hosts), nil
+}
+
+// mergeHosts sums the byte counts of hosts with the same labels, e.g. the remote IP addresses collapsed to the same
+// Kubernetes network, so each labels set is a single metric.
+func mergeHosts(hosts []Metric) []Metric {
+	merged := make([]Metric, 0, len(hosts))
+	indexes := make(map[Metric]int, len(hosts))
+	for _, host := range hosts {
+		key := host
+		key.Bandwidth = 0
+		if i, found := indexes[key]; found {
+			merged[i].Bandwidth += host.Bandwidth
+
+			continue
+		}
+		indexes[key] = len(merged)
+		merged = append(merged, host)
+	}
+
+	return merged
+}
+
+// firstLabel returns the value of the first non-empty label of names.
+func firstLabel(labels map[string]string, names []string) string {
+	for _, name := range names {
+		if value := labels[name]; value != "" {
+			return value
+		}
+	}
+
+	return ""
+}
+
+// interfaceAddrCache caches the IP address of network interfaces within a collection, empty if it's unknown.
+type interfaceAddrCache map[string]string
+
+// get returns the IP address of a network interface.
+func (c interfaceAddrCache) get(iface string) string {
+	addr, ok := c[iface]
+	if ok {
+		return addr
+	}
+
+	ip, err := interfaceIP(iface)
+	if err != nil {
+		interfaceLog.Warnf("Failed to get the address of darkstat interface %v: %v", iface, err)
+	} else {
+		addr = ip.String()
+	}
+	c[iface] = addr
+
+	return addr
+}
+
+// withBitsPerSecond sets the BitsPerSecond of hosts from their Bandwidth delta since the previous hosts,
+// collected elapsed ago.
+func withBitsPerSecond(hosts []Metric, previousHosts []Metric, elapsed time.Duration) {
+	const bitsPerByte = 8
+
+	if elapsed <= 0 {
+		return
+	}
+
+	type hostKey struct {
+		direction    string
+		remoteIPAddr string
+		iface        string
+	}
+	previousBandwidth := make(map[hostKey]float64, len(previousHosts))
+	for _, previous := range previousHosts {
+		previousBandwidth[hostKey{direction: previous.Direction, remoteIPAddr: previous.RemoteIPAddr, iface: previous.Interface}] = previous.Bandwidth
+	}
+
+	for i := range hosts {
+		previous, ok := previousBandwidth[hostKey{direction: hosts[i].Direction, remoteIPAddr: hosts[i].RemoteIPAddr, iface: hosts[i].Interface}]
+		// A lower byte count means darkstat was restarted, so there's no delta to compute
+		if !ok || hosts[i].Bandwidth < previous {
+			continue
+		}
+		hosts[i].BitsPerSecond = (hosts[i].Bandwidth - previous) * bitsPerByte / elapsed.Seconds()
+		hosts[i].HasBitsPerSecond = true
+	}
 }
@@ -0,0 +1,255 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package darkstat
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"planet-exporter/collector/task/inventory"
+	"planet-exporter/pkg/network"
+	"planet-exporter/pkg/prometheus"
+
+	"github.com/prometheus/prom2json"
+)
+
+func Test_withBitsPerSecond(t *testing.T) {
+	previousHosts := []Metric{
+		{Direction: "egress", RemoteIPAddr: "10.0.0.1", Bandwidth: 1000},
+		{Direction: "ingress", RemoteIPAddr: "10.0.0.1", Bandwidth: 5000},
+	}
+	hosts := []Metric{
+		// Same host and direction
+		{Direction: "egress", RemoteIPAddr: "10.0.0.1", Bandwidth: 2000},
+		// Counter reset
+		{Direction: "ingress", RemoteIPAddr: "10.0.0.1", Bandwidth: 10},
+		// New host
+		{Direction: "egress", RemoteIPAddr: "10.0.0.2", Bandwidth: 3000},
+	}
+	withBitsPerSecond(hosts, previousHosts, 10*time.Second)
+
+	want := []Metric{
+		{Direction: "egress", RemoteIPAddr: "10.0.0.1", Bandwidth: 2000, BitsPerSecond: 800, HasBitsPerSecond: true},
+		{Direction: "ingress", RemoteIPAddr: "10.0.0.1", Bandwidth: 10},
+		{Direction: "egress", RemoteIPAddr: "10.0.0.2", Bandwidth: 3000},
+	}
+	if !reflect.DeepEqual(hosts, want) {
+		t.Errorf("withBitsPerSecond() = %v, want %v", hosts, want)
+	}
+
+	// Without a previous collection, no rate is set
+	hosts = []Metric{{Direction: "egress", RemoteIPAddr: "10.0.0.1", Bandwidth: 2000}}
+	withBitsPerSecond(hosts, nil, time.Since(time.Time{}))
+	if hosts[0].HasBitsPerSecond {
+		t.Errorf("withBitsPerSecond() = %v, want no rate", hosts)
+	}
+}
+
+func Test_withBitsPerSecond_interfaces(t *testing.T) {
+	previousHosts := []Metric{
+		{Direction: "egress", RemoteIPAddr: "10.0.0.1", Interface: "eth0", Bandwidth: 1000},
+		{Direction: "egress", RemoteIPAddr: "10.0.0.1", Interface: "eth1", Bandwidth: 5000},
+	}
+	hosts := []Metric{
+		{Direction: "egress", RemoteIPAddr: "10.0.0.1", Interface: "eth0", Bandwidth: 2000},
+		{Direction: "egress", RemoteIPAddr: "10.0.0.1", Interface: "eth1", Bandwidth: 5500},
+	}
+	withBitsPerSecond(hosts, previousHosts, 10*time.Second)
+
+	if hosts[0].BitsPerSecond != 800 || hosts[1].BitsPerSecond != 400 {
+		t.Errorf("withBitsPerSecond() = %v, want the rate of each interface", hosts)
+	}
+}
+
+func Test_interfaceAddrCache(t *testing.T) {
+	defer func(f func(string) (net.IP, error)) { interfaceIP = f }(interfaceIP)
+
+	lookups := 0
+	interfaceIP = func(name string) (net.IP, error) {
+		lookups++
+		if name == "eth1" {
+			return net.ParseIP("10.20.0.5"), nil
+		}
+
+		return nil, errors.New("no such network interface")
+	}
+
+	cache := interfaceAddrCache{}
+	for i := 0; i < 2; i++ {
+		if got := cache.get("eth1"); got != "10.20.0.5" {
+			t.Errorf("interfaceAddrCache.get(eth1) = %v, want 10.20.0.5", got)
+		}
+		if got := cache.get("eth9"); got != "" {
+			t.Errorf("interfaceAddrCache.get(eth9) = %v, want empty", got)
+		}
+	}
+	if lookups != 2 {
+		t.Errorf("interfaceAddrCache looked up %v interfaces, want 2", lookups)
+	}
+}
+
+func Test_toHostMetrics_kubernetesNetworks(t *testing.T) {
+	if _, err := network.LocalIP(); err != nil {
+		t.Skipf("network.LocalIP() error = %v", err)
+	}
+	podCIDRs, err := network.ParseCIDRs("10.244.0.0/16")
+	if err != nil {
+		t.Fatalf("network.ParseCIDRs() error = %v", err)
+	}
+	inventory.SetKubernetesNetworks(podCIDRs, inventory.DefaultKubernetesPodHostgroup, nil, "")
+	defer inventory.SetKubernetesNetworks(nil, "", nil, "")
+
+	family := &prom2json.Family{ // nolint:exhaustivestruct
+		Metrics: []interface{}{
+			prom2json.Metric{Labels: map[string]string{"ip": "10.244.1.2", "dir": "in"}, Value: "100"}, // nolint:exhaustivestruct
+			prom2json.Metric{Labels: map[string]string{"ip": "10.244.3.4", "dir": "in"}, Value: "200"}, // nolint:exhaustivestruct
+			prom2json.Metric{Labels: map[string]string{"ip": "192.0.2.1", "dir": "out"}, Value: "300"}, // nolint:exhaustivestruct
+		},
+	}
+	hosts, err := toHostMetrics(family)
+	if err != nil {
+		t.Fatalf("toHostMetrics() error = %v", err)
+	}
+
+	// Pod IPs are exported as their network, so rescheduled pods don't create new series, and their bytes are summed
+	want := map[string]float64{"10.244.0.0/16": 300, "192.0.2.1": 300}
+	got := map[string]float64{}
+	for _, host := range hosts {
+		got[host.RemoteIPAddr] += host.Bandwidth
+	}
+	if len(hosts) != len(want) || !reflect.DeepEqual(got, want) {
+		t.Errorf("toHostMetrics() = %+v, want the bytes by remote IP address %v", hosts, want)
+	}
+	if hosts[0].RemoteHostgroup != inventory.DefaultKubernetesPodHostgroup {
+		t.Errorf("toHostMetrics() remote hostgroup = %v, want %v", hosts[0].RemoteHostgroup, inventory.DefaultKubernetesPodHostgroup)
+	}
+}
+
+func TestCollect_scrapeTimeout(t *testing.T) {
+	// darkstat accepts the connection but never responds
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	enabled, darkstatAddr, scrapeTimeout := singleton.enabled, singleton.darkstatAddr, singleton.scrapeTimeout
+	defer func() {
+		singleton.enabled, singleton.darkstatAddr, singleton.scrapeTimeout = enabled, darkstatAddr, scrapeTimeout
+	}()
+	singleton.enabled, singleton.darkstatAddr, singleton.scrapeTimeout = true, server.URL, 50*time.Millisecond
+
+	startTime := time.Now()
+	err := Collect(context.Background())
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Collect() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(startTime); elapsed > 5*time.Second {
+		t.Errorf("Collect() took %v, want it to time out after %v", elapsed, 50*time.Millisecond)
+	}
+}
+
+// writeClientCert writes a self-signed PEM client certificate and key of commonName into dir, and returns their paths.
+func writeClientCert(t *testing.T, dir, commonName string) (string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("ecdsa.GenerateKey() error = %v", err)
+	}
+	template := &x509.Certificate{ // nolint:exhaustivestruct
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName}, // nolint:exhaustivestruct
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("x509.CreateCertificate() error = %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("x509.MarshalECPrivateKey() error = %v", err)
+	}
+
+	certFile := filepath.Join(dir, commonName+".pem")
+	keyFile := filepath.Join(dir, commonName+"-key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil { // nolint:exhaustivestruct
+		t.Fatalf("os.WriteFile() error = %v", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil { // nolint:exhaustivestruct
+		t.Fatalf("os.WriteFile() error = %v", err)
+	}
+
+	return certFile, keyFile
+}
+
+func TestInitTask_clientCert(t *testing.T) {
+	var peerName atomic.Value
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		peerName.Store(r.TLS.PeerCertificates[0].Subject.CommonName)
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert} // nolint:exhaustivestruct,gosec
+	server.StartTLS()
+	defer server.Close()
+
+	enabled, addr, scrapeTimeout, prometheusClient := singleton.enabled, singleton.darkstatAddr, singleton.scrapeTimeout, singleton.prometheusClient
+	defer func() {
+		singleton.enabled, singleton.darkstatAddr, singleton.scrapeTimeout, singleton.prometheusClient = enabled, addr, scrapeTimeout, prometheusClient
+		once = sync.Once{}
+	}()
+
+	certFile, keyFile := writeClientCert(t, t.TempDir(), "darkstat")
+	tlsConfig := &tls.Config{InsecureSkipVerify: true} // nolint:exhaustivestruct,gosec
+
+	// An incomplete certificate fails before the task is initialized
+	if err := InitTask(context.Background(), true, server.URL, nil, 0, 0, tlsConfig, certFile, "", nil, time.Second, 0, 0); !errors.Is(err, prometheus.ErrIncompleteClientCert) {
+		t.Fatalf("InitTask() error = %v, want %v", err, prometheus.ErrIncompleteClientCert)
+	}
+
+	once = sync.Once{}
+	if err := InitTask(context.Background(), true, server.URL, nil, 0, 0, tlsConfig, certFile, keyFile, nil, time.Second, 0, 0); err != nil {
+		t.Fatalf("InitTask() error = %v", err)
+	}
+	if _, err := singleton.prometheusClient.Scrape(context.Background(), server.URL); err != nil {
+		t.Fatalf("Client.Scrape() error = %v", err)
+	}
+	if got := peerName.Load(); got != "darkstat" {
+		t.Errorf("darkstat scrape client certificate = %v, want %v", got, "darkstat")
+	}
+}
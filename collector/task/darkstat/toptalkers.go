@@ -0,0 +1,100 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package darkstat
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// Traffic directions of darkstat metrics, the top talkers are ordered by either or both of them.
+const (
+	IngressDirection = "ingress"
+	EgressDirection  = "egress"
+)
+
+// ErrInvalidTopTalkersDirection top talkers direction isn't ingress, egress, or empty.
+var ErrInvalidTopTalkersDirection = errors.New("invalid top talkers direction, expected 'ingress', 'egress', or empty")
+
+// TopTalker is the traffic with a remote hostgroup, in bytes reported by darkstat.
+type TopTalker struct {
+	RemoteHostgroup string `json:"remote_hostgroup"`
+	// RemoteIPAddr is only set for a remote host without hostgroup, whose traffic isn't grouped
+	RemoteIPAddr string  `json:"remote_ip_addr,omitempty"`
+	IngressBytes float64 `json:"ingress_bytes"`
+	EgressBytes  float64 `json:"egress_bytes"`
+	TotalBytes   float64 `json:"total_bytes"`
+}
+
+// TopTalkers returns the n remote hostgroups of the hosts with the most bytes in direction, or in both directions
+// if direction is empty.
+func TopTalkers(hosts []Metric, n int, direction string) ([]TopTalker, error) {
+	var bytesOf func(TopTalker) float64
+	switch direction {
+	case IngressDirection:
+		bytesOf = func(t TopTalker) float64 { return t.IngressBytes }
+	case EgressDirection:
+		bytesOf = func(t TopTalker) float64 { return t.EgressBytes }
+	case "":
+		bytesOf = func(t TopTalker) float64 { return t.TotalBytes }
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrInvalidTopTalkersDirection, direction)
+	}
+
+	type talkerKey struct {
+		remoteHostgroup string
+		remoteIPAddr    string
+	}
+	talkers := make(map[talkerKey]*TopTalker)
+	for _, host := range hosts {
+		key := talkerKey{remoteHostgroup: host.RemoteHostgroup, remoteIPAddr: ""}
+		if host.RemoteHostgroup == "" {
+			key.remoteIPAddr = host.RemoteIPAddr
+		}
+		talker, ok := talkers[key]
+		if !ok {
+			talker = &TopTalker{RemoteHostgroup: key.remoteHostgroup, RemoteIPAddr: key.remoteIPAddr} // nolint:exhaustivestruct
+			talkers[key] = talker
+		}
+		switch host.Direction {
+		case IngressDirection:
+			talker.IngressBytes += host.Bandwidth
+		case EgressDirection:
+			talker.EgressBytes += host.Bandwidth
+		}
+		talker.TotalBytes += host.Bandwidth
+	}
+
+	topTalkers := make([]TopTalker, 0, len(talkers))
+	for _, talker := range talkers {
+		topTalkers = append(topTalkers, *talker)
+	}
+	sort.Slice(topTalkers, func(i, j int) bool {
+		if bytesOf(topTalkers[i]) != bytesOf(topTalkers[j]) {
+			return bytesOf(topTalkers[i]) > bytesOf(topTalkers[j])
+		}
+		if topTalkers[i].RemoteHostgroup != topTalkers[j].RemoteHostgroup {
+			return topTalkers[i].RemoteHostgroup < topTalkers[j].RemoteHostgroup
+		}
+
+		return topTalkers[i].RemoteIPAddr < topTalkers[j].RemoteIPAddr
+	})
+	if n >= 0 && len(topTalkers) > n {
+		topTalkers = topTalkers[:n]
+	}
+
+	return topTalkers, nil
+}
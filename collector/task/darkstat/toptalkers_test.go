@@ -0,0 +1,59 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package darkstat
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestTopTalkers(t *testing.T) {
+	hosts := []Metric{
+		{Direction: "egress", RemoteHostgroup: "db", RemoteIPAddr: "10.0.0.1", Bandwidth: 3000},
+		{Direction: "egress", RemoteHostgroup: "db", RemoteIPAddr: "10.0.0.2", Bandwidth: 2000},
+		{Direction: "ingress", RemoteHostgroup: "db", RemoteIPAddr: "10.0.0.1", Bandwidth: 100},
+		{Direction: "ingress", RemoteHostgroup: "web", RemoteIPAddr: "10.0.1.1", Bandwidth: 4000},
+		{Direction: "egress", RemoteHostgroup: "", RemoteIPAddr: "8.8.8.8", Bandwidth: 4500},
+	}
+	db := TopTalker{RemoteHostgroup: "db", IngressBytes: 100, EgressBytes: 5000, TotalBytes: 5100} // nolint:exhaustivestruct
+	web := TopTalker{RemoteHostgroup: "web", IngressBytes: 4000, TotalBytes: 4000}                 // nolint:exhaustivestruct
+	unknown := TopTalker{RemoteIPAddr: "8.8.8.8", EgressBytes: 4500, TotalBytes: 4500}             // nolint:exhaustivestruct
+
+	tests := []struct {
+		name      string
+		n         int
+		direction string
+		want      []TopTalker
+		wantErr   error
+	}{
+		{name: "Both directions", n: 10, direction: "", want: []TopTalker{db, unknown, web}, wantErr: nil},
+		{name: "Ingress", n: 10, direction: "ingress", want: []TopTalker{web, db, unknown}, wantErr: nil},
+		{name: "Egress top 2", n: 2, direction: "egress", want: []TopTalker{db, unknown}, wantErr: nil},
+		{name: "None", n: 0, direction: "", want: []TopTalker{}, wantErr: nil},
+		{name: "Unknown direction", n: 10, direction: "sideways", want: nil, wantErr: ErrInvalidTopTalkersDirection},
+	}
+	for _, testcase := range tests {
+		t.Run(testcase.name, func(t *testing.T) {
+			got, err := TopTalkers(hosts, testcase.n, testcase.direction)
+			if !errors.Is(err, testcase.wantErr) {
+				t.Fatalf("TopTalkers() error = %v, want %v", err, testcase.wantErr)
+			}
+			if !reflect.DeepEqual(got, testcase.want) {
+				t.Errorf("TopTalkers() = %+v, want %+v", got, testcase.want)
+			}
+		})
+	}
+}
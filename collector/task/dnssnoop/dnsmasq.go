@@ -0,0 +1,103 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dnssnoop
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+)
+
+// dnsmasqMaxReadBytes read per collection, the rest of the log is read by the next collections.
+const dnsmasqMaxReadBytes = 16 << 20
+
+// dnsmasqQueryRegexp matches a dnsmasq query log line, e.g. 'dnsmasq[123]: query[A] example.com from 10.0.0.1',
+// or with --log-queries=extra 'dnsmasq[123]: 7 10.0.0.1/41234 query[A] example.com from 10.0.0.1'.
+var dnsmasqQueryRegexp = regexp.MustCompile(`\bquery\[[^\]]+\] (\S+) from `)
+
+// dnsmasqLog tails a dnsmasq query log. Queries logged before the first read are skipped.
+// When the log is rotated or truncated, the new log is read from its start, and the queries logged
+// to the previous log since the previous read are lost.
+type dnsmasqLog struct {
+	path string
+
+	// fileInfo of the log at the previous read, nil before the first read
+	fileInfo os.FileInfo
+	// offset of the first line that wasn't read yet
+	offset int64
+}
+
+// newDnsmasqLog returns a dnsmasqLog tailing the log at path.
+func newDnsmasqLog(path string) *dnsmasqLog {
+	return &dnsmasqLog{
+		path:     path,
+		fileInfo: nil,
+		offset:   0,
+	}
+}
+
+// ReadQueries implements Source. A line that isn't fully written yet is read by the next read.
+func (l *dnsmasqLog) ReadQueries(ctx context.Context) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("error reading dnsmasq log: %w", err)
+	}
+
+	file, err := os.Open(l.path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening dnsmasq log: %w", err)
+	}
+	defer file.Close()
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("error reading dnsmasq log file info: %w", err)
+	}
+	switch {
+	case l.fileInfo == nil:
+		l.fileInfo, l.offset = fileInfo, fileInfo.Size()
+
+		return nil, nil
+	case !os.SameFile(l.fileInfo, fileInfo) || fileInfo.Size() < l.offset:
+		l.offset = 0
+	}
+	l.fileInfo = fileInfo
+
+	if _, err := file.Seek(l.offset, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("error seeking dnsmasq log: %w", err)
+	}
+
+	domains := []string{}
+	reader := bufio.NewReader(io.LimitReader(file, dnsmasqMaxReadBytes))
+	for {
+		line, err := reader.ReadString('\n')
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return domains, fmt.Errorf("error reading dnsmasq log: %w", err)
+		}
+		l.offset += int64(len(line))
+
+		if match := dnsmasqQueryRegexp.FindStringSubmatch(line); match != nil {
+			domains = append(domains, match[1])
+		}
+	}
+
+	return domains, nil
+}
@@ -0,0 +1,109 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dnssnoop
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func Test_dnsmasqLog_ReadQueries(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dnsmasq.log")
+	appendLog := func(content string) {
+		file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+		if _, err := file.WriteString(content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dnsmasq := newDnsmasqLog(path)
+
+	tests := []struct {
+		name    string
+		prepare func()
+		want    []string
+	}{
+		{
+			name:    "First read skips the queries logged before",
+			prepare: func() { appendLog("Jun  1 10:00:00 dnsmasq[123]: query[A] old.example.com from 10.0.0.1\n") },
+			want:    nil,
+		},
+		{
+			name: "Queries and extra format queries, other lines are skipped",
+			prepare: func() {
+				appendLog("Jun  1 10:00:01 dnsmasq[123]: query[A] api.example.com from 10.0.0.1\n" +
+					"Jun  1 10:00:01 dnsmasq[123]: forwarded api.example.com to 1.1.1.1\n" +
+					"Jun  1 10:00:01 dnsmasq[123]: reply api.example.com is 93.184.216.34\n" +
+					"Jun  1 10:00:02 dnsmasq[123]: 7 10.0.0.1/41234 query[AAAA] cdn.example.net from 10.0.0.1\n")
+			},
+			want: []string{"api.example.com", "cdn.example.net"},
+		},
+		{
+			name:    "Partially written line is read by the next read",
+			prepare: func() { appendLog("Jun  1 10:00:03 dnsmasq[123]: query[A] saas.exa") },
+			want:    []string{},
+		},
+		{
+			name:    "Rest of the partially written line",
+			prepare: func() { appendLog("mple.org from 10.0.0.1\n") },
+			want:    []string{"saas.example.org"},
+		},
+		{
+			name: "Truncated log is read from its start",
+			prepare: func() {
+				if err := os.Truncate(path, 0); err != nil {
+					t.Fatal(err)
+				}
+				appendLog("Jun  1 10:00:04 dnsmasq[123]: query[A] new.example.com from 10.0.0.1\n")
+			},
+			want: []string{"new.example.com"},
+		},
+		{
+			name: "Rotated log is read from its start",
+			prepare: func() {
+				if err := os.Rename(path, path+".1"); err != nil {
+					t.Fatal(err)
+				}
+				appendLog("Jun  1 10:00:05 dnsmasq[123]: query[A] rotated.example.com from 10.0.0.1\n" +
+					"Jun  1 10:00:05 dnsmasq[123]: query[A] rotated.example.com from 10.0.0.1\n")
+			},
+			want: []string{"rotated.example.com", "rotated.example.com"},
+		},
+	}
+	for _, testcase := range tests {
+		// Reads depend on the previous ones, so they aren't subtests
+		testcase.prepare()
+		got, err := dnsmasq.ReadQueries(context.Background())
+		if err != nil {
+			t.Errorf("%v: dnsmasqLog.ReadQueries() error = %v", testcase.name, err)
+		}
+		if !reflect.DeepEqual(got, testcase.want) {
+			t.Errorf("%v: dnsmasqLog.ReadQueries() = %v, want %v", testcase.name, got, testcase.want)
+		}
+	}
+}
+
+func Test_dnsmasqLog_ReadQueries_missingLog(t *testing.T) {
+	dnsmasq := newDnsmasqLog(filepath.Join(t.TempDir(), "missing.log"))
+	if _, err := dnsmasq.ReadQueries(context.Background()); err == nil {
+		t.Errorf("dnsmasqLog.ReadQueries() error = nil, want error")
+	}
+}
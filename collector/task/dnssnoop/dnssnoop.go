@@ -0,0 +1,227 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dnssnoop
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// SourceDnsmasq tails a dnsmasq query log (dnsmasq --log-queries --log-facility=<file>).
+	SourceDnsmasq = "dnsmasq"
+
+	// DefaultTopDomains is the default number of most queried domains exported.
+	DefaultTopDomains = 100
+	// countedDomainsPerTopDomain bounds the counted domains to a multiple of the exported top domains,
+	// so a domain that becomes popular later can still make it to the top. A full count evicts as many domains
+	// as the top domains at once.
+	countedDomainsPerTopDomain = 10
+)
+
+// ErrUnsupportedSource DNS query log source isn't supported.
+var ErrUnsupportedSource = errors.New("unsupported DNS query log source")
+
+// Source reads the DNS query log.
+type Source interface {
+	// ReadQueries returns the domains queried since the previous read.
+	ReadQueries(ctx context.Context) ([]string, error)
+}
+
+// NewSource returns the DNS query log source reading from path.
+func NewSource(source string, path string) (Source, error) {
+	switch source {
+	case SourceDnsmasq:
+		return newDnsmasqLog(path), nil
+	default:
+		return nil, fmt.Errorf("%w %q (supported: %v)", ErrUnsupportedSource, source, SourceDnsmasq)
+	}
+}
+
+// task that reads DNS query logs and counts the queries per queried domain.
+type task struct {
+	enabled    bool
+	source     Source
+	topDomains int
+
+	// queries counts the queries per domain since the task started, up to countedDomainsPerTopDomain*topDomains domains
+	queries map[string]uint64
+	// droppedQueries of the least queried domains evicted from queries to count new domains
+	droppedQueries uint64
+	mu             sync.Mutex
+}
+
+var singleton task
+
+func init() {
+	singleton = task{
+		enabled:        false,
+		source:         nil,
+		topDomains:     DefaultTopDomains,
+		queries:        make(map[string]uint64),
+		droppedQueries: 0,
+		mu:             sync.Mutex{},
+	}
+}
+
+// InitTask initial states.
+// The topDomains most queried domains are exported, the other domains are counted but not exported.
+func InitTask(ctx context.Context, enabled bool, source Source, topDomains int) {
+	if topDomains <= 0 {
+		log.Warningf("Invalid dnssnoop top domains '%v', fallback to %v", topDomains, DefaultTopDomains)
+		topDomains = DefaultTopDomains
+	}
+
+	singleton.mu.Lock()
+	singleton.enabled = enabled
+	singleton.source = source
+	singleton.topDomains = topDomains
+	singleton.mu.Unlock()
+}
+
+// Enabled returns whether the task is enabled.
+func Enabled() bool {
+	singleton.mu.Lock()
+	defer singleton.mu.Unlock()
+
+	return singleton.enabled
+}
+
+// Metric contains the queries of a queried domain.
+type Metric struct {
+	QueryDomain string
+	Queries     uint64
+}
+
+// Stats of the counted queries.
+type Stats struct {
+	// Domains is the number of distinct counted domains, including the ones that aren't exported
+	Domains int
+	// DroppedQueries of the least queried domains evicted to count new domains, when there were too many distinct domains
+	DroppedQueries uint64
+}
+
+// Get returns the most queried domains from singleton, the most queried first.
+func Get() ([]Metric, Stats) {
+	singleton.mu.Lock()
+	defer singleton.mu.Unlock()
+
+	return topDomains(singleton.queries, singleton.topDomains), Stats{
+		Domains:        len(singleton.queries),
+		DroppedQueries: singleton.droppedQueries,
+	}
+}
+
+// topDomains returns the n most queried domains, the most queried first, ties broken by domain name.
+func topDomains(queries map[string]uint64, n int) []Metric {
+	metrics := make([]Metric, 0, len(queries))
+	for domain, count := range queries {
+		metrics = append(metrics, Metric{QueryDomain: domain, Queries: count})
+	}
+	sort.Slice(metrics, func(i, j int) bool {
+		if metrics[i].Queries != metrics[j].Queries {
+			return metrics[i].Queries > metrics[j].Queries
+		}
+
+		return metrics[i].QueryDomain < metrics[j].QueryDomain
+	})
+	if len(metrics) > n {
+		metrics = metrics[:n]
+	}
+
+	return metrics
+}
+
+// normalizeDomain returns the domain in lower case without the trailing root dot, empty if it's not a domain.
+func normalizeDomain(domain string) string {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	if domain == "" || strings.ContainsAny(domain, " /") {
+		return ""
+	}
+
+	return domain
+}
+
+// countQueries adds the queried domains to the queries. A new domain when there are maxDomains already evicts the
+// least queried tenth of them first (see evictLeastQueried), so a domain that becomes popular later is still counted.
+// It returns the number of queries of the evicted domains.
+func countQueries(queries map[string]uint64, domains []string, maxDomains int) uint64 {
+	var dropped uint64
+	for _, domain := range domains {
+		domain = normalizeDomain(domain)
+		if domain == "" {
+			continue
+		}
+		if _, counted := queries[domain]; !counted && len(queries) >= maxDomains {
+			dropped += evictLeastQueried(queries, maxDomains/countedDomainsPerTopDomain)
+		}
+		queries[domain]++
+	}
+
+	return dropped
+}
+
+// evictLeastQueried removes the n (at least one) least queried domains from the queries, ties broken by domain name
+// in the reverse order of topDomains, so the exported domains are the last to go. It returns their number of queries.
+func evictLeastQueried(queries map[string]uint64, n int) uint64 {
+	if n < 1 {
+		n = 1
+	}
+	counted := topDomains(queries, len(queries))
+	if n > len(counted) {
+		n = len(counted)
+	}
+
+	var evicted uint64
+	for _, metric := range counted[len(counted)-n:] {
+		evicted += metric.Queries
+		delete(queries, metric.QueryDomain)
+	}
+
+	return evicted
+}
+
+// Collect reads the DNS queries since the previous collection and fill singleton with latest data.
+func Collect(ctx context.Context) error {
+	singleton.mu.Lock()
+	enabled, source := singleton.enabled, singleton.source
+	singleton.mu.Unlock()
+	if !enabled || source == nil {
+		return nil
+	}
+
+	startTime := time.Now()
+
+	domains, err := source.ReadQueries(ctx)
+	if err != nil {
+		return fmt.Errorf("error reading DNS queries: %w", err)
+	}
+
+	singleton.mu.Lock()
+	singleton.droppedQueries += countQueries(singleton.queries, domains, countedDomainsPerTopDomain*singleton.topDomains)
+	singleton.mu.Unlock()
+
+	log.Debugf("taskdnssnoop.Collect read %v DNS queries", len(domains))
+	log.Debugf("taskdnssnoop.Collect process took %v", time.Since(startTime))
+
+	return nil
+}
@@ -0,0 +1,96 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dnssnoop
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func Test_countQueries(t *testing.T) {
+	queries := map[string]uint64{}
+
+	dropped := countQueries(queries, []string{"API.Example.com.", "api.example.com", "cdn.example.net", "", "not a domain"}, 3)
+	if want := map[string]uint64{"api.example.com": 2, "cdn.example.net": 1}; !reflect.DeepEqual(queries, want) {
+		t.Errorf("countQueries() queries = %v, want %v", queries, want)
+	}
+	if dropped != 0 {
+		t.Errorf("countQueries() = %v, want %v", dropped, 0)
+	}
+
+	// New domains over the maximum evict the least queried domain, ties broken by domain name
+	dropped = countQueries(queries, []string{"saas.example.org", "new.example.org", "new.example.org", "cdn.example.net"}, 3)
+	if want := map[string]uint64{"api.example.com": 2, "cdn.example.net": 2, "new.example.org": 2}; !reflect.DeepEqual(queries, want) {
+		t.Errorf("countQueries() queries = %v, want %v", queries, want)
+	}
+	if dropped != 1 {
+		t.Errorf("countQueries() = %v, want %v", dropped, 1)
+	}
+}
+
+func Test_evictLeastQueried(t *testing.T) {
+	queries := map[string]uint64{"a.example": 1, "b.example": 5, "c.example": 3, "d.example": 1}
+
+	// Evicting more domains than counted evicts them all
+	if evicted := evictLeastQueried(queries, 4); evicted != 10 || len(queries) != 0 {
+		t.Errorf("evictLeastQueried() = %v leaving %v, want %v leaving none", evicted, queries, 10)
+	}
+
+	queries = map[string]uint64{"a.example": 1, "b.example": 5, "c.example": 3, "d.example": 1}
+	if evicted := evictLeastQueried(queries, 1); evicted != 1 {
+		t.Errorf("evictLeastQueried() = %v, want %v", evicted, 1)
+	}
+	if want := map[string]uint64{"a.example": 1, "b.example": 5, "c.example": 3}; !reflect.DeepEqual(queries, want) {
+		t.Errorf("evictLeastQueried() queries = %v, want %v", queries, want)
+	}
+}
+
+func Test_topDomains(t *testing.T) {
+	queries := map[string]uint64{"a.example": 1, "b.example": 5, "c.example": 3, "d.example": 3}
+
+	tests := []struct {
+		name string
+		n    int
+		want []Metric
+	}{
+		{
+			name: "Most queried first, ties by domain",
+			n:    10,
+			want: []Metric{{"b.example", 5}, {"c.example", 3}, {"d.example", 3}, {"a.example", 1}},
+		},
+		{
+			name: "Capped to the top domains",
+			n:    2,
+			want: []Metric{{"b.example", 5}, {"c.example", 3}},
+		},
+	}
+	for _, testcase := range tests {
+		t.Run(testcase.name, func(t *testing.T) {
+			if got := topDomains(queries, testcase.n); !reflect.DeepEqual(got, testcase.want) {
+				t.Errorf("topDomains() = %v, want %v", got, testcase.want)
+			}
+		})
+	}
+}
+
+func TestNewSource(t *testing.T) {
+	if _, err := NewSource(SourceDnsmasq, "/var/log/dnsmasq.log"); err != nil {
+		t.Errorf("NewSource() error = %v, want nil", err)
+	}
+	if _, err := NewSource("pcap", ""); !errors.Is(err, ErrUnsupportedSource) {
+		t.Errorf("NewSource() error = %v, want %v", err, ErrUnsupportedSource)
+	}
+}
@@ -22,6 +22,7 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"net/url"
 	"strconv"
 	"sync"
 	"time"
@@ -29,24 +30,39 @@ import (
 	"planet-exporter/collector/task/inventory"
 	"planet-exporter/pkg/network"
 	"planet-exporter/pkg/prometheus"
+	"planet-exporter/pkg/ratelog"
 
 	"github.com/prometheus/prom2json"
 	log "github.com/sirupsen/logrus"
 )
 
+// DefaultScrapeTimeout is the default timeout of a ebpf scrape.
+const DefaultScrapeTimeout = 5 * time.Second
+
 // task that queries ebpf metrics and aggregates them into usable planet metrics.
 type task struct {
 	enabled          bool
 	ebpfAddr         string
 	prometheusClient *prometheus.Client
+	// scrapeTimeout of a scrape, so a slow but connected ebpf can't hang the collection
+	scrapeTimeout time.Duration
 
 	hosts []Metric
-	mu    sync.Mutex
+	// collectedAt is the time hosts were last collected, zero if they never were
+	collectedAt time.Time
+	mu          sync.Mutex
 }
 
 var (
 	once      sync.Once
 	singleton task
+	// httpTransport of scrapes, its TLS config and proxy are set by InitTask
+	httpTransport *http.Transport
+
+	// Rate-limited logs of warnings that repeat on every collect
+	localAddrLog     = ratelog.New(ratelog.DefaultInterval)
+	parseMetricLog   = ratelog.New(ratelog.DefaultInterval)
+	convertMetricLog = ratelog.New(ratelog.DefaultInterval)
 )
 
 const (
@@ -59,7 +75,8 @@ const (
 )
 
 func init() {
-	httpTransport := &http.Transport{ // nolint:exhaustivestruct
+	httpTransport = &http.Transport{ // nolint:exhaustivestruct
+		Proxy: http.ProxyFromEnvironment,
 		DialContext: (&net.Dialer{ // nolint:exhaustivestruct
 			Timeout:   30 * time.Second,
 			KeepAlive: 30 * time.Second,
@@ -68,25 +85,52 @@ func init() {
 		MaxIdleConns:          100,
 		IdleConnTimeout:       90 * time.Second,
 		TLSHandshakeTimeout:   10 * time.Second,
-		TLSClientConfig:       &tls.Config{InsecureSkipVerify: true}, // nolint:gosec,exhaustivestruct
 		ExpectContinueTimeout: 1 * time.Second,
 	}
 
 	singleton = task{
 		enabled:          false,
 		hosts:            []Metric{},
+		collectedAt:      time.Time{},
 		mu:               sync.Mutex{},
-		prometheusClient: prometheus.New(httpTransport),
+		prometheusClient: prometheus.New(httpTransport, nil),
+		scrapeTimeout:    DefaultScrapeTimeout,
 		ebpfAddr:         "",
 	}
 }
 
 // InitTask initial states.
-func InitTask(ctx context.Context, enabled bool, ebpfAddr string) {
+// httpHeaders are set on every scrape request, and responses larger than maxResponseBytes (if positive) fail.
+// Scrapes stop reading after maxMetrics metrics (if positive), and keep the metrics read so far.
+// Scrapes over HTTPS verify the server certificate unless tlsConfig (if set) skips verification, and present the PEM
+// client certificate and key (if set) instead of the tlsConfig's (e.g. for a ebpf behind an mTLS proxy).
+// Scrapes go through proxy (see httpproxy.Func), or directly if it's nil, and time out after scrapeTimeout.
+// Scrapes failing with a network-level error are retried up to maxRetries times, after retryBackoff doubled every retry.
+func InitTask(ctx context.Context, enabled bool, ebpfAddr string, httpHeaders http.Header, maxResponseBytes int64,
+	maxMetrics int, tlsConfig *tls.Config, clientCertFile, clientKeyFile string, proxy func(*http.Request) (*url.URL, error),
+	scrapeTimeout time.Duration, maxRetries int, retryBackoff time.Duration) error {
+	tlsConfig, err := prometheus.WithClientCert(tlsConfig, clientCertFile, clientKeyFile)
+	if err != nil {
+		return fmt.Errorf("error loading ebpf client certificate: %w", err)
+	}
+	if scrapeTimeout <= 0 {
+		log.Warningf("Invalid ebpf scrape timeout '%v', fallback to %v", scrapeTimeout, DefaultScrapeTimeout)
+		scrapeTimeout = DefaultScrapeTimeout
+	}
+
 	once.Do(func() {
+		singleton.prometheusClient = prometheus.New(httpTransport, tlsConfig)
+		singleton.prometheusClient.SetProxy(proxy)
+		singleton.prometheusClient.SetRetry(maxRetries, retryBackoff)
 		singleton.enabled = enabled
 		singleton.ebpfAddr = ebpfAddr
+		singleton.prometheusClient.SetHeaders(httpHeaders)
+		singleton.prometheusClient.SetMaxResponseBytes(maxResponseBytes)
+		singleton.prometheusClient.SetMaxMetrics(maxMetrics)
+		singleton.scrapeTimeout = scrapeTimeout
 	})
+
+	return nil
 }
 
 // Metric contains values needed for planet metrics.
@@ -109,6 +153,15 @@ func Get() []Metric {
 	return hosts
 }
 
+// LastCollectTime returns the time of the latest metrics from singleton, zero if there were none collected yet.
+func LastCollectTime() time.Time {
+	singleton.mu.Lock()
+	collectedAt := singleton.collectedAt
+	singleton.mu.Unlock()
+
+	return collectedAt
+}
+
 var (
 	// ErrMetricsNotFound metrics does not exists.
 	ErrMetricsNotFound = fmt.Errorf("metrics does not exists")
@@ -129,11 +182,12 @@ func Collect(ctx context.Context) error {
 
 	startTime := time.Now()
 
-	ctxCollect, ctxCollectCancel := context.WithCancel(ctx)
+	ctxCollect, ctxCollectCancel := context.WithTimeout(ctx, singleton.scrapeTimeout)
 	defer ctxCollectCancel()
 
 	// Scrape ebpf prometheus endpoint for send_bytes_metricipv4, send_bytes_metricipv6,recv_bytes_metricipv4 and recv_bytes_metricipv6.
-	ebpfScrape, err := singleton.prometheusClient.Scrape(ctxCollect, singleton.ebpfAddr)
+	ebpfScrape, err := singleton.prometheusClient.ScrapeMetricFamilies(ctxCollect, singleton.ebpfAddr,
+		sendBytesIPV4, recvBytesIPV4, sendBytesIPv6, recvBytesIPv6)
 	if err != nil {
 		return fmt.Errorf("error on ebpf metrics scrape: %w", err)
 	}
@@ -173,24 +227,25 @@ func Collect(ctx context.Context) error {
 
 	sendHostBytesIPV4, err := toHostMetrics(sendBytesMetricIPV4, egress)
 	if err != nil {
-		log.Errorf("Conversion to host metric failed for %v, err: %v", sendBytesIPV4, err)
+		convertMetricLog.Errorf("Conversion to host metric failed for %v, err: %v", sendBytesIPV4, err)
 	}
 	recvHostBytesIPV4, err := toHostMetrics(recvBytesMetricIPV4, ingress)
 	if err != nil {
-		log.Errorf("Conversion to host metric failed for %v, err: %v", recvBytesIPV4, err)
+		convertMetricLog.Errorf("Conversion to host metric failed for %v, err: %v", recvBytesIPV4, err)
 	}
 
 	sendHostBytesIPV6, err := toHostMetrics(sendBytesMetricIPV6, egress)
 	if err != nil {
-		log.Errorf("Conversion to host metric failed for %v, err: %v", sendBytesIPv6, err)
+		convertMetricLog.Errorf("Conversion to host metric failed for %v, err: %v", sendBytesIPv6, err)
 	}
 	recvHostBytesIPV6, err := toHostMetrics(recvBytesMetricIPV6, ingress)
 	if err != nil {
-		log.Errorf("Conversion to host metric failed for %v, err: %v", recvBytesIPv6, err)
+		convertMetricLog.Errorf("Conversion to host metric failed for %v, err: %v", recvBytesIPv6, err)
 	}
 
 	singleton.mu.Lock()
-	singleton.hosts = append(append(append(sendHostBytesIPV4, recvHostBytesIPV4...), sendHostBytesIPV6...), recvHostBytesIPV6...)
+	singleton.hosts = mergeHosts(append(append(append(sendHostBytesIPV4, recvHostBytesIPV4...), sendHostBytesIPV6...), recvHostBytesIPV6...))
+	singleton.collectedAt = time.Now()
 	singleton.mu.Unlock()
 
 	log.Debugf("taskebpf.Collect retrieved %v metrics for IPV4", len(sendHostBytesIPV4)+len(recvHostBytesIPV4))
@@ -213,18 +268,19 @@ func toHostMetrics(bytesMetric *prom2json.Family, direction string) ([]Metric, e
 	// To label source traffic that we need to build dependency graph.
 	localHostgroup := currentIP.String()
 	localDomain := currentIP.String()
-	localInventory, ok := inventoryHosts.GetHost(currentIP.String())
+	localInventory, ok := inventoryHosts.GetLocalHost(currentIP.String())
 	if ok {
 		localHostgroup = localInventory.Hostgroup
 		localDomain = localInventory.Domain
+		localAddrLog.Reset()
 	} else {
-		log.Warnf("Local address doesn't exist in the inventory: %v", currentIP.String())
+		localAddrLog.Warnf("Local address doesn't exist in the inventory: %v", currentIP.String())
 	}
 
 	for _, m := range bytesMetric.Metrics {
 		metric, ok := m.(prom2json.Metric)
 		if !ok {
-			log.Warnf("Failed to parse ebpf metrics: %v", m)
+			parseMetricLog.Warnf("Failed to parse ebpf metrics: %v", m)
 
 			continue
 		}
@@ -240,7 +296,7 @@ func toHostMetrics(bytesMetric *prom2json.Family, direction string) ([]Metric, e
 
 		bandwidth, err := strconv.ParseFloat(metric.Value, 64)
 		if err != nil {
-			log.Errorf("Failed to parse 'bytes_metric' value: %v", err)
+			parseMetricLog.Errorf("Failed to parse 'bytes_metric' value: %v", err)
 
 			continue
 		}
@@ -248,7 +304,7 @@ func toHostMetrics(bytesMetric *prom2json.Family, direction string) ([]Metric, e
 		hosts = append(hosts, Metric{
 			LocalHostgroup:  localHostgroup,
 			RemoteHostgroup: remoteInventoryHost.Hostgroup,
-			RemoteIPAddr:    metric.Labels["daddr"],
+			RemoteIPAddr:    remoteInventoryHost.RemoteIPAddr(metric.Labels["daddr"]),
 			LocalDomain:     localDomain,
 			RemoteDomain:    remoteInventoryHost.Domain,
 			Direction:       direction,
@@ -258,3 +314,23 @@ func toHostMetrics(bytesMetric *prom2json.Family, direction string) ([]Metric, e
 
 	return hosts, nil
 }
+
+// mergeHosts sums the byte counts of hosts with the same labels, e.g. the remote IP addresses collapsed to the same
+// Kubernetes network, so each labels set is a single metric.
+func mergeHosts(hosts []Metric) []Metric {
+	merged := make([]Metric, 0, len(hosts))
+	indexes := make(map[Metric]int, len(hosts))
+	for _, host := range hosts {
+		key := host
+		key.Bandwidth = 0
+		if i, found := indexes[key]; found {
+			merged[i].Bandwidth += host.Bandwidth
+
+			continue
+		}
+		indexes[key] = len(merged)
+		merged = append(merged, host)
+	}
+
+	return merged
+}
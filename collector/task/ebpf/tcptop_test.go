@@ -0,0 +1,111 @@
+/**
+ * Copyright 2021
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ebpf
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"planet-exporter/pkg/prometheus"
+)
+
+// writeClientCert writes a self-signed PEM client certificate and key of commonName into dir, and returns their paths.
+func writeClientCert(t *testing.T, dir, commonName string) (string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("ecdsa.GenerateKey() error = %v", err)
+	}
+	template := &x509.Certificate{ // nolint:exhaustivestruct
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName}, // nolint:exhaustivestruct
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("x509.CreateCertificate() error = %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("x509.MarshalECPrivateKey() error = %v", err)
+	}
+
+	certFile := filepath.Join(dir, commonName+".pem")
+	keyFile := filepath.Join(dir, commonName+"-key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil { // nolint:exhaustivestruct
+		t.Fatalf("os.WriteFile() error = %v", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil { // nolint:exhaustivestruct
+		t.Fatalf("os.WriteFile() error = %v", err)
+	}
+
+	return certFile, keyFile
+}
+
+func TestInitTask_clientCert(t *testing.T) {
+	var peerName atomic.Value
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		peerName.Store(r.TLS.PeerCertificates[0].Subject.CommonName)
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert} // nolint:exhaustivestruct,gosec
+	server.StartTLS()
+	defer server.Close()
+
+	enabled, addr, scrapeTimeout, prometheusClient := singleton.enabled, singleton.ebpfAddr, singleton.scrapeTimeout, singleton.prometheusClient
+	defer func() {
+		singleton.enabled, singleton.ebpfAddr, singleton.scrapeTimeout, singleton.prometheusClient = enabled, addr, scrapeTimeout, prometheusClient
+		once = sync.Once{}
+	}()
+
+	certFile, keyFile := writeClientCert(t, t.TempDir(), "ebpf")
+	tlsConfig := &tls.Config{InsecureSkipVerify: true} // nolint:exhaustivestruct,gosec
+
+	// An incomplete certificate fails before the task is initialized
+	if err := InitTask(context.Background(), true, server.URL, nil, 0, 0, tlsConfig, certFile, "", nil, time.Second, 0, 0); !errors.Is(err, prometheus.ErrIncompleteClientCert) {
+		t.Fatalf("InitTask() error = %v, want %v", err, prometheus.ErrIncompleteClientCert)
+	}
+
+	once = sync.Once{}
+	if err := InitTask(context.Background(), true, server.URL, nil, 0, 0, tlsConfig, certFile, keyFile, nil, time.Second, 0, 0); err != nil {
+		t.Fatalf("InitTask() error = %v", err)
+	}
+	if _, err := singleton.prometheusClient.Scrape(context.Background(), server.URL); err != nil {
+		t.Fatalf("Client.Scrape() error = %v", err)
+	}
+	if got := peerName.Load(); got != "ebpf" {
+		t.Errorf("ebpf scrape client certificate = %v, want %v", got, "ebpf")
+	}
+}
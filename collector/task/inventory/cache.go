@@ -0,0 +1,144 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inventory
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultCacheMaxAge of the inventory cache file, an older cache isn't loaded.
+const DefaultCacheMaxAge = 24 * time.Hour
+
+// cacheFile is the on-disk cache of the latest fetched inventory hosts, before they're transformed.
+type cacheFile struct {
+	SavedAt time.Time `json:"saved_at"`
+	Hosts   []Host    `json:"hosts"`
+}
+
+// SetCacheFile sets the file caching the inventory hosts of the latest successful collection, so a restart during
+// an inventory outage still has the last-known inventory (see LoadCacheFile). The cache is disabled if path is empty.
+func SetCacheFile(path string, maxAge time.Duration) {
+	if maxAge <= 0 {
+		log.Warningf("Invalid inventory cache max age '%v', fallback to %v", maxAge, DefaultCacheMaxAge)
+		maxAge = DefaultCacheMaxAge
+	}
+
+	singleton.mu.Lock()
+	singleton.cacheFile = path
+	singleton.cacheMaxAge = maxAge
+	singleton.mu.Unlock()
+}
+
+// LoadCacheFile loads the inventory from the cache file, until the next successful collection replaces it.
+// A missing cache file, or one older than the cache max age, is skipped. The loaded inventory is dropped by the
+// collections once it gets older than the cache max age (see expireCachedInventory).
+func LoadCacheFile() error {
+	singleton.mu.Lock()
+	path, maxAge, transformRules := singleton.cacheFile, singleton.cacheMaxAge, singleton.transformRules
+	singleton.mu.Unlock()
+	if path == "" {
+		return nil
+	}
+
+	cache, err := readCacheFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		log.Infof("No inventory cache file '%v' yet", path)
+
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if age := time.Since(cache.SavedAt); age > maxAge {
+		log.Warnf("Skip inventory cache file '%v' saved %v ago, older than its max age of %v", path, age.Round(time.Second), maxAge)
+
+		return nil
+	}
+
+	inventory := buildHostsInventory(cache.Hosts, transformRules)
+	singleton.mu.Lock()
+	singleton.values = inventory
+	singleton.cacheExpiresAt = cache.SavedAt.Add(maxAge)
+	singleton.mu.Unlock()
+
+	log.Infof("Loaded %v inventory hosts from cache file '%v' saved at %v", len(cache.Hosts), path, cache.SavedAt.Format(time.RFC3339))
+
+	return nil
+}
+
+// expireCachedInventory drops the inventory loaded from the cache file once it's older than the cache max age,
+// so an inventory outage outlasting it leaves the inventory empty, as without a cache, rather than stale.
+func expireCachedInventory(now time.Time) {
+	singleton.mu.Lock()
+	defer singleton.mu.Unlock()
+
+	if singleton.cacheExpiresAt.IsZero() || now.Before(singleton.cacheExpiresAt) {
+		return
+	}
+	log.Warnf("Drop the inventory loaded from cache file '%v', no collection replaced it within its max age of %v",
+		singleton.cacheFile, singleton.cacheMaxAge)
+	singleton.values = parseInventory(nil)
+	singleton.cacheExpiresAt = time.Time{}
+}
+
+// readCacheFile reads an inventory cache file.
+func readCacheFile(path string) (cacheFile, error) {
+	var cache cacheFile
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cache, fmt.Errorf("error reading inventory cache file: %w", err)
+	}
+	if err := json.Unmarshal(data, &cache); err != nil {
+		return cache, fmt.Errorf("error decoding inventory cache file '%v': %w", path, err)
+	}
+
+	return cache, nil
+}
+
+// writeCacheFile replaces the inventory cache file with the hosts. The cache is written to a temporary file
+// that's renamed over it, so a crash while writing doesn't leave a partial cache.
+func writeCacheFile(path string, hosts []Host, savedAt time.Time) error {
+	data, err := json.Marshal(cacheFile{SavedAt: savedAt, Hosts: hosts})
+	if err != nil {
+		return fmt.Errorf("error encoding inventory cache: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("error creating inventory cache file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+
+		return fmt.Errorf("error writing inventory cache file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error writing inventory cache file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("error replacing inventory cache file: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,121 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inventory
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLoadCacheFile(t *testing.T) {
+	defer func(values Inventory, path string, maxAge time.Duration, expiresAt time.Time) {
+		singleton.mu.Lock()
+		singleton.values, singleton.cacheFile, singleton.cacheMaxAge, singleton.cacheExpiresAt = values, path, maxAge, expiresAt
+		singleton.mu.Unlock()
+	}(singleton.values, singleton.cacheFile, singleton.cacheMaxAge, singleton.cacheExpiresAt)
+
+	hosts := []Host{
+		{IPAddress: "10.0.0.1", Domain: "xyz.service.consul", Hostgroup: "xyz"},
+		{IPAddress: "10.0.0.2", Domain: "abc.service.consul", Hostgroup: "abc", Services: map[string]string{"3306": "mysql"}},
+	}
+	path := filepath.Join(t.TempDir(), "inventory.json")
+
+	tests := []struct {
+		name      string
+		savedAt   time.Time
+		wantHosts bool
+	}{
+		{name: "Fresh cache is loaded", savedAt: time.Now().Add(-time.Hour), wantHosts: true},
+		{name: "Cache older than its max age is skipped", savedAt: time.Now().Add(-3 * time.Hour), wantHosts: false},
+	}
+	for _, testcase := range tests {
+		t.Run(testcase.name, func(t *testing.T) {
+			if err := writeCacheFile(path, hosts, testcase.savedAt); err != nil {
+				t.Fatalf("writeCacheFile() error = %v", err)
+			}
+
+			singleton.mu.Lock()
+			singleton.values = parseInventory(nil)
+			singleton.mu.Unlock()
+			SetCacheFile(path, 2*time.Hour)
+			if err := LoadCacheFile(); err != nil {
+				t.Fatalf("LoadCacheFile() error = %v", err)
+			}
+
+			host, found := Get().GetHost("10.0.0.2")
+			if found != testcase.wantHosts {
+				t.Fatalf("LoadCacheFile() loaded host = %v, want %v", found, testcase.wantHosts)
+			}
+			if found && !reflect.DeepEqual(host, hosts[1]) {
+				t.Errorf("LoadCacheFile() loaded %+v, want %+v", host, hosts[1])
+			}
+
+			// Still failing collections drop the loaded inventory once it's older than the max age
+			expireCachedInventory(testcase.savedAt.Add(2*time.Hour - time.Minute))
+			if _, found := Get().GetHost("10.0.0.2"); found != testcase.wantHosts {
+				t.Errorf("expireCachedInventory() before the max age kept host = %v, want %v", found, testcase.wantHosts)
+			}
+			expireCachedInventory(testcase.savedAt.Add(2 * time.Hour))
+			if _, found := Get().GetHost("10.0.0.2"); found {
+				t.Errorf("expireCachedInventory() after the max age kept the cached inventory")
+			}
+		})
+	}
+
+	// Missing cache files are skipped, corrupt ones fail
+	SetCacheFile(filepath.Join(t.TempDir(), "missing.json"), time.Hour)
+	if err := LoadCacheFile(); err != nil {
+		t.Errorf("LoadCacheFile() of a missing file error = %v, want nil", err)
+	}
+	if err := os.WriteFile(path, []byte("{"), 0o600); err != nil {
+		t.Fatalf("os.WriteFile() error = %v", err)
+	}
+	SetCacheFile(path, time.Hour)
+	if err := LoadCacheFile(); err == nil {
+		t.Errorf("LoadCacheFile() of a corrupt file error = nil, want an error")
+	}
+}
+
+func Test_writeCacheFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "inventory.json")
+	hosts := []Host{{IPAddress: "10.0.0.1", Domain: "xyz.service.consul", Hostgroup: "xyz"}} // nolint:exhaustivestruct
+	savedAt := time.Date(2021, 6, 1, 10, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 2; i++ {
+		if err := writeCacheFile(path, hosts, savedAt); err != nil {
+			t.Fatalf("writeCacheFile() error = %v", err)
+		}
+	}
+	cache, err := readCacheFile(path)
+	if err != nil {
+		t.Fatalf("readCacheFile() error = %v", err)
+	}
+	if !cache.SavedAt.Equal(savedAt) || !reflect.DeepEqual(cache.Hosts, hosts) {
+		t.Errorf("readCacheFile() = %+v, want hosts %+v saved at %v", cache, hosts, savedAt)
+	}
+
+	// The temporary files are renamed over the cache file
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("os.ReadDir() error = %v", err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("writeCacheFile() left %v files, want only the cache file", len(entries))
+	}
+}
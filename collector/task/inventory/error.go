@@ -20,3 +20,15 @@ import (
 
 // ErrInvalidInventoryFormat invalid inventory format.
 var ErrInvalidInventoryFormat = fmt.Errorf("invalid inventory format")
+
+// ErrEmptyHostgroupAndDomain inventory entry has neither hostgroup nor domain.
+var ErrEmptyHostgroupAndDomain = fmt.Errorf("inventory entry has empty hostgroup and domain")
+
+// ErrMissingInventoryField inventory entry is missing a required field.
+var ErrMissingInventoryField = fmt.Errorf("inventory entry is missing a required field")
+
+// ErrStrictInventory strict inventory has an invalid entry.
+var ErrStrictInventory = fmt.Errorf("strict inventory has an invalid entry")
+
+// ErrInvalidServicePort inventory entry has a service whose port isn't between 1 and 65535.
+var ErrInvalidServicePort = fmt.Errorf("inventory entry has an invalid service port")
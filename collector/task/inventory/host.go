@@ -1,12 +1,18 @@
 package inventory
 
 import (
+	"bufio"
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"strconv"
+
+	"planet-exporter/pkg/bodylimit"
+	"planet-exporter/pkg/httpheader"
 
 	log "github.com/sirupsen/logrus"
 )
@@ -16,17 +22,38 @@ type Host struct {
 	Domain    string `json:"domain"`
 	Hostgroup string `json:"hostgroup"`
 	IPAddress string `json:"ip_address"`
+	// Services are optional names of the services listening on the host's ports (e.g. {"3306": "mysql"})
+	Services map[string]string `json:"services,omitempty"`
+	// kubernetesNetwork is set on the synthetic host of a Kubernetes network, whose IPAddress is the network
+	kubernetesNetwork bool
 }
 
+// RemoteIPAddr returns the IP address to export for a remote address of the host. It's the network of a Kubernetes
+// network host, so the churning pod and service IPs don't create new series, or the address itself otherwise.
+func (h Host) RemoteIPAddr(address string) string {
+	if h.kubernetesNetwork {
+		return h.IPAddress
+	}
+
+	return address
+}
+
+// maxNDJSONLineBytes is the maximum size of a single ndjson inventory entry.
+const maxNDJSONLineBytes = 1024 * 1024
+
 // requestHosts requests a new inventory host entries from upstream inventoryAddr.
-func requestHosts(ctx context.Context, httpClient *http.Client, inventoryFormat, inventoryAddr string) ([]Host, error) {
+// It returns the parsed hosts along with the number of inventory entries that were skipped due to parser errors.
+// A response body larger than maxResponseBytes (if positive) fails with bodylimit.ErrTooLarge.
+func requestHosts(ctx context.Context, httpClient *http.Client, httpHeaders http.Header, maxResponseBytes int64,
+	inventoryFormat string, strict bool, inventoryAddr string) ([]Host, int, error) {
 	request, err := http.NewRequestWithContext(ctx, http.MethodGet, inventoryAddr, nil)
 	if err != nil {
-		return nil, fmt.Errorf("error creating inventory request: %w", err)
+		return nil, 0, fmt.Errorf("error creating inventory request: %w", err)
 	}
+	httpheader.Apply(request, httpHeaders)
 	response, err := httpClient.Do(request)
 	if err != nil {
-		return nil, fmt.Errorf("error requesting inventory: %w", err)
+		return nil, 0, fmt.Errorf("error requesting inventory: %w", err)
 	}
 	defer func() {
 		if err := response.Body.Close(); err != nil {
@@ -34,33 +61,86 @@ func requestHosts(ctx context.Context, httpClient *http.Client, inventoryFormat,
 		}
 	}()
 
-	return parseHosts(inventoryFormat, response.Body)
+	return parseHosts(inventoryFormat, strict, bodylimit.NewReader(response.Body, maxResponseBytes))
 }
 
 // parseHosts parses inventory data as a list of Host.
-func parseHosts(format string, data io.Reader) ([]Host, error) {
-	var result []Host
+// Malformed entries are skipped and counted, so a single bad entry doesn't discard the rest of the inventory.
+// When strict is true, an entry containing unknown fields or missing a required field fails the whole inventory instead.
+func parseHosts(format string, strict bool, data io.Reader) ([]Host, int, error) {
+	result, skipErrs, err := decodeHosts(format, strict, data)
+	for _, skipErr := range skipErrs {
+		log.Errorf("Skip an inventory host entry due to parser error: %v", skipErr)
+	}
+	if err != nil {
+		return nil, len(skipErrs), err
+	}
+	log.Debugf("Parsed %v inventory hosts (skipped %v)", len(result), len(skipErrs))
 
-	decoder := json.NewDecoder(data)
-	decoder.DisallowUnknownFields()
+	return result, len(skipErrs), nil
+}
+
+// decodeHosts decodes inventory data as a list of Host, returning the reason of every skipped entry.
+// A read error of the whole data also counts as a skipped entry. Both formats handle entries the same way:
+// unknown fields are ignored and malformed entries are skipped, unless strict fails on the first invalid entry.
+func decodeHosts(format string, strict bool, data io.Reader) ([]Host, []error, error) {
+	var result []Host
+	var skipErrs []error
 
 	switch format {
 	case fmtNDJSON:
-		var inventoryEntry Host
-		for decoder.More() {
-			err := decoder.Decode(&inventoryEntry)
+		scanner := bufio.NewScanner(data)
+		scanner.Buffer(make([]byte, 0, bufio.MaxScanTokenSize), maxNDJSONLineBytes)
+		for scanner.Scan() {
+			line := bytes.TrimSpace(scanner.Bytes())
+			if len(line) == 0 {
+				continue
+			}
+
+			inventoryEntry, err := decodeHost(line, strict)
+			if err != nil && strict {
+				err = fmt.Errorf("%w: %w", ErrStrictInventory, err)
+
+				return nil, []error{err}, err
+			}
 			if err != nil {
-				log.Errorf("Skip an inventory host entry due to parser error: %v", err)
+				skipErrs = append(skipErrs, err)
 
 				continue
 			}
 			result = append(result, inventoryEntry)
 		}
+		if err := scanner.Err(); err != nil {
+			err = fmt.Errorf("error reading ndjson inventory data: %w", err)
+
+			return nil, append(skipErrs, err), err
+		}
 
 	case fmtArrayJSON:
-		err := decoder.Decode(&result)
+		var rawEntries []json.RawMessage
+		decoder := json.NewDecoder(data)
+		err := decoder.Decode(&rawEntries)
 		if err != nil {
-			return nil, fmt.Errorf("error decoding arrayjson inventory data: %w", err)
+			// The whole payload failed to parse, count it as a single parse error
+			err = fmt.Errorf("error decoding arrayjson inventory data: %w", err)
+
+			return nil, []error{err}, err
+		}
+
+		// Decode each element individually so that one bad element doesn't discard the rest
+		for _, rawEntry := range rawEntries {
+			inventoryEntry, err := decodeHost(rawEntry, strict)
+			if err != nil && strict {
+				err = fmt.Errorf("%w: %w", ErrStrictInventory, err)
+
+				return nil, []error{err}, err
+			}
+			if err != nil {
+				skipErrs = append(skipErrs, err)
+
+				continue
+			}
+			result = append(result, inventoryEntry)
 		}
 
 		// Because we only expect a single JSON array object, we discard unexpected additional data.
@@ -70,9 +150,63 @@ func parseHosts(format string, data io.Reader) ([]Host, error) {
 		}
 
 	default:
-		return nil, ErrInvalidInventoryFormat
+		return nil, skipErrs, ErrInvalidInventoryFormat
+	}
+
+	return result, skipErrs, nil
+}
+
+// decodeHost decodes a single JSON inventory entry.
+// When strict is true, the entry must not contain unknown fields, must have an ip_address along with a hostgroup or domain,
+// and its services must be keyed by valid ports. Otherwise, services with invalid ports are dropped.
+func decodeHost(raw []byte, strict bool) (Host, error) {
+	var host Host
+
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	if strict {
+		decoder.DisallowUnknownFields()
+	}
+	if err := decoder.Decode(&host); err != nil {
+		return Host{}, fmt.Errorf("error decoding inventory host entry: %w", err)
+	}
+	services, err := parseServices(host.Services)
+	if err != nil && strict {
+		return Host{}, fmt.Errorf("%w (address=%v)", err, host.IPAddress)
+	}
+	host.Services = services
+	if !strict {
+		return host, nil
+	}
+	if host.IPAddress == "" {
+		return Host{}, fmt.Errorf("%w 'ip_address' (hostgroup=%v, domain=%v)", ErrMissingInventoryField, host.Hostgroup, host.Domain)
+	}
+	if host.Hostgroup == "" && host.Domain == "" {
+		return Host{}, fmt.Errorf("%w (address=%v)", ErrEmptyHostgroupAndDomain, host.IPAddress)
+	}
+
+	return host, nil
+}
+
+// parseServices returns the services keyed by their canonical port (e.g. "03306" becomes "3306"), nil if there are none.
+// Services with an invalid port are dropped, and the first invalid port is returned as an error.
+func parseServices(services map[string]string) (map[string]string, error) {
+	if len(services) == 0 {
+		return nil, nil
+	}
+
+	var firstErr error
+	parsed := make(map[string]string, len(services))
+	for port, name := range services {
+		portNumber, err := strconv.ParseUint(port, 10, 16)
+		if err != nil || portNumber == 0 {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("%w %q", ErrInvalidServicePort, port)
+			}
+
+			continue
+		}
+		parsed[strconv.FormatUint(portNumber, 10)] = name
 	}
-	log.Debugf("Parsed %v inventory hosts", len(result))
 
-	return result, nil
+	return parsed, firstErr
 }
@@ -0,0 +1,67 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inventory
+
+import (
+	"fmt"
+	"strings"
+)
+
+// HostgroupCase is the casing applied to hostgroups returned by the inventory lookups,
+// so hostgroups that only differ in casing (e.g. "MyApp" and "myapp") become the same label value.
+type HostgroupCase string
+
+const (
+	// HostgroupCaseNone keeps hostgroups as they are.
+	HostgroupCaseNone HostgroupCase = ""
+	// HostgroupCaseLower lowercases hostgroups.
+	HostgroupCaseLower HostgroupCase = "lower"
+	// HostgroupCaseUpper uppercases hostgroups.
+	HostgroupCaseUpper HostgroupCase = "upper"
+)
+
+// ErrInvalidHostgroupCase hostgroup case is not supported.
+var ErrInvalidHostgroupCase = fmt.Errorf("invalid hostgroup case")
+
+// ParseHostgroupCase parses a hostgroup case: "" (disabled), "lower", or "upper".
+func ParseHostgroupCase(s string) (HostgroupCase, error) {
+	switch c := HostgroupCase(strings.ToLower(strings.TrimSpace(s))); c {
+	case HostgroupCaseNone, HostgroupCaseLower, HostgroupCaseUpper:
+		return c, nil
+	}
+
+	return HostgroupCaseNone, fmt.Errorf("%w %q, expected 'lower' or 'upper'", ErrInvalidHostgroupCase, s)
+}
+
+// apply returns the hostgroup in this case.
+func (c HostgroupCase) apply(hostgroup string) string {
+	switch c {
+	case HostgroupCaseLower:
+		return strings.ToLower(hostgroup)
+	case HostgroupCaseUpper:
+		return strings.ToUpper(hostgroup)
+	case HostgroupCaseNone:
+	}
+
+	return hostgroup
+}
+
+// SetHostgroupCase sets the casing applied to every hostgroup returned by the inventory lookups,
+// including hostgroups from the NAT mapping and the local override.
+func SetHostgroupCase(c HostgroupCase) {
+	singleton.mu.Lock()
+	singleton.hostgroupCase = c
+	singleton.mu.Unlock()
+}
@@ -0,0 +1,106 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inventory
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseHostgroupCase(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    HostgroupCase
+		wantErr bool
+	}{
+		{name: "Disabled", input: "", want: HostgroupCaseNone, wantErr: false},
+		{name: "Lower", input: "lower", want: HostgroupCaseLower, wantErr: false},
+		{name: "Upper with different casing", input: " UPPER ", want: HostgroupCaseUpper, wantErr: false},
+		{name: "Unsupported", input: "title", want: HostgroupCaseNone, wantErr: true},
+	}
+	for _, testcase := range tests {
+		t.Run(testcase.name, func(t *testing.T) {
+			got, err := ParseHostgroupCase(testcase.input)
+			if (err != nil) != testcase.wantErr {
+				t.Fatalf("ParseHostgroupCase() error = %v, wantErr %v", err, testcase.wantErr)
+			}
+			if testcase.wantErr && !errors.Is(err, ErrInvalidHostgroupCase) {
+				t.Errorf("ParseHostgroupCase() error = %v, want %v", err, ErrInvalidHostgroupCase)
+			}
+			if got != testcase.want {
+				t.Errorf("ParseHostgroupCase() = %v, want %v", got, testcase.want)
+			}
+		})
+	}
+}
+
+func TestInventory_hostgroupCase(t *testing.T) {
+	mapping, err := parseNATMapping(strings.NewReader("10.255.0.0/24 External-NAT nat.example"))
+	if err != nil {
+		t.Fatalf("parseNATMapping() error = %v", err)
+	}
+	inventory := parseInventory([]Host{
+		{IPAddress: "10.0.0.1", Hostgroup: "MyApp", Domain: "myapp.local"},
+		{IPAddress: "10.0.0.2", Hostgroup: "myapp", Domain: "myapp.local"},
+	})
+	inventory.natMapping = mapping
+	inventory.hostgroupCase = HostgroupCaseLower
+
+	tests := []struct {
+		name     string
+		lookup   func(string) (Host, bool)
+		address  string
+		wantHost Host
+	}{
+		{
+			name:     "Remote inventory host",
+			lookup:   inventory.GetHost,
+			address:  "10.0.0.1",
+			wantHost: Host{IPAddress: "10.0.0.1", Hostgroup: "myapp", Domain: "myapp.local"},
+		},
+		{
+			name:     "Remote NAT mapping host",
+			lookup:   inventory.GetHost,
+			address:  "10.255.0.1",
+			wantHost: Host{IPAddress: "10.255.0.0/24", Hostgroup: "external-nat", Domain: "nat.example"},
+		},
+		{
+			name:     "Local inventory host",
+			lookup:   inventory.GetLocalHost,
+			address:  "10.0.0.1",
+			wantHost: Host{IPAddress: "10.0.0.1", Hostgroup: "myapp", Domain: "myapp.local"},
+		},
+	}
+	for _, testcase := range tests {
+		t.Run(testcase.name, func(t *testing.T) {
+			got, found := testcase.lookup(testcase.address)
+			if !found {
+				t.Fatalf("lookup(%v) not found", testcase.address)
+			}
+			if !reflect.DeepEqual(got, testcase.wantHost) {
+				t.Errorf("lookup(%v) = %v, want %v", testcase.address, got, testcase.wantHost)
+			}
+		})
+	}
+
+	// The local override is normalized as well
+	inventory.localOverride = localOverride{hostgroup: "New-Host", domain: "", force: false}
+	if got, _ := inventory.GetLocalHost("10.9.9.9"); got.Hostgroup != "new-host" {
+		t.Errorf("Inventory.GetLocalHost() hostgroup = %v, want %v", got.Hostgroup, "new-host")
+	}
+}
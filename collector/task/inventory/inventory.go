@@ -19,6 +19,7 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"net/url"
 	"strings"
 	"sync"
 	"time"
@@ -33,10 +34,38 @@ type task struct {
 	enabled         bool
 	inventoryAddr   string
 	inventoryFormat string
+	inventoryStrict bool
+	// httpHeaders are set on every inventory request
+	httpHeaders http.Header
+	// maxResponseBytes of an inventory response body, unlimited if zero
+	maxResponseBytes int64
 
 	mu         sync.Mutex
 	values     Inventory
 	httpClient *http.Client
+
+	// parseErrorsTotal counts inventory entries that were skipped or failed to parse
+	parseErrorsTotal uint64
+
+	// addrResolver resolves 'srv+' inventory addresses
+	addrResolver *srvAddrResolver
+	// srvErrorsTotal counts inventory SRV address resolution failures
+	srvErrorsTotal uint64
+
+	localOverride localOverride
+	natMapping    natMapping
+	hostgroupCase HostgroupCase
+	// transformRules rewrite the hosts of every collected inventory
+	transformRules []TransformRule
+	// kubernetesNetworks are the synthetic hosts of the Kubernetes pod and service networks
+	kubernetesNetworks []networkHost
+
+	// cacheFile caches the hosts of the latest successful collection, disabled if empty, protected by mu
+	cacheFile   string
+	cacheMaxAge time.Duration
+	// cacheExpiresAt is when the inventory loaded from the cache file gets older than cacheMaxAge,
+	// zero once a collection replaced it, protected by mu
+	cacheExpiresAt time.Time
 }
 
 const (
@@ -68,16 +97,21 @@ func init() {
 			ipAddresses:          make(map[string]Host),
 			networkCIDRAddresses: []networkHost{},
 		},
-		httpClient: &http.Client{ // nolint:exhaustivestruct
-			Timeout: collectTimeout,
-		},
+		// Requests are bound to the collect context deadline (collectTimeout), so they're cancelled promptly on shutdown
+		httpClient:      newHTTPClient(http.ProxyFromEnvironment),
 		inventoryFormat: fmtArrayJSON,
 		inventoryAddr:   "",
+		inventoryStrict: false,
+		addrResolver:    newSRVAddrResolver(net.DefaultResolver, srvCacheTTL),
 	}
 }
 
 // InitTask sets initial states.
-func InitTask(ctx context.Context, enabled bool, inventoryAddr string, inventoryFormat string) {
+// When strict is true, an inventory entry containing unknown fields or missing a required field fails the whole collection,
+// otherwise unknown fields are ignored and malformed entries are skipped.
+// httpHeaders are set on every inventory request, and responses larger than maxResponseBytes (if positive) fail.
+func InitTask(ctx context.Context, enabled bool, inventoryAddr string, inventoryFormat string, strict bool, httpHeaders http.Header,
+	maxResponseBytes int64) {
 	// Validate inventory format
 	if _, ok := supportedInventoryFormats[inventoryFormat]; !ok {
 		log.Warningf("Unsupported inventory format '%v', fallback to the default format", inventoryFormat)
@@ -89,18 +123,70 @@ func InitTask(ctx context.Context, enabled bool, inventoryAddr string, inventory
 		singleton.enabled = enabled
 		singleton.inventoryAddr = inventoryAddr
 		singleton.inventoryFormat = inventoryFormat
+		singleton.inventoryStrict = strict
+		singleton.httpHeaders = httpHeaders
+		singleton.maxResponseBytes = maxResponseBytes
 	})
 }
 
+// SetProxy sets the proxy of inventory requests (see httpproxy.Func), requests don't use a proxy if it's nil.
+func SetProxy(proxy func(*http.Request) (*url.URL, error)) {
+	singleton.mu.Lock()
+	singleton.httpClient = newHTTPClient(proxy)
+	singleton.mu.Unlock()
+}
+
+// newHTTPClient returns an inventory HTTP client whose requests go through proxy.
+func newHTTPClient(proxy func(*http.Request) (*url.URL, error)) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = proxy
+
+	return &http.Client{Transport: transport} // nolint:exhaustivestruct
+}
+
+// SetLocalOverride sets the hostgroup and domain used for the local host when it's missing from the inventory.
+// When force is true, the override takes precedence over the inventory data.
+func SetLocalOverride(hostgroup, domain string, force bool) {
+	singleton.mu.Lock()
+	singleton.localOverride = localOverride{
+		hostgroup: hostgroup,
+		domain:    domain,
+		force:     force,
+	}
+	singleton.mu.Unlock()
+}
+
 // Get returns current inventory data.
 func Get() Inventory {
 	singleton.mu.Lock()
 	hosts := singleton.values
+	hosts.localOverride = singleton.localOverride
+	hosts.natMapping = singleton.natMapping
+	hosts.hostgroupCase = singleton.hostgroupCase
+	hosts.kubernetesNetworks = singleton.kubernetesNetworks
 	singleton.mu.Unlock()
 
 	return hosts
 }
 
+// GetParseErrorsTotal returns the total number of inventory entries that were skipped or failed to parse.
+func GetParseErrorsTotal() uint64 {
+	singleton.mu.Lock()
+	parseErrorsTotal := singleton.parseErrorsTotal
+	singleton.mu.Unlock()
+
+	return parseErrorsTotal
+}
+
+// GetSRVErrorsTotal returns the total number of inventory SRV address resolution failures.
+func GetSRVErrorsTotal() uint64 {
+	singleton.mu.Lock()
+	srvErrorsTotal := singleton.srvErrorsTotal
+	singleton.mu.Unlock()
+
+	return srvErrorsTotal
+}
+
 // ErrEmptyInventoryAddr inventory address is empty.
 var ErrEmptyInventoryAddr = fmt.Errorf("Inventory address is empty")
 
@@ -115,122 +201,281 @@ func Collect(ctx context.Context) error {
 	}
 
 	startTime := time.Now()
+	expireCachedInventory(startTime)
 
 	collectCtx, cancel := context.WithTimeout(ctx, collectTimeout)
 	defer cancel()
 
-	hosts, err := requestHosts(collectCtx, singleton.httpClient, singleton.inventoryFormat, singleton.inventoryAddr)
+	// Keep the previous inventory if the SRV address can't be resolved
+	inventoryAddr, err := singleton.addrResolver.resolve(collectCtx, singleton.inventoryAddr)
 	if err != nil {
+		singleton.mu.Lock()
+		singleton.srvErrorsTotal++
+		singleton.mu.Unlock()
+
 		return err
 	}
-	hosts = append(hosts, Host{
-		IPAddress: "127.0.0.1",
-		Domain:    "localhost",
-		Hostgroup: "localhost",
-	})
-	inventory := parseInventory(hosts)
+
+	hosts, skipped, err := requestHosts(collectCtx, singleton.httpClient, singleton.httpHeaders, singleton.maxResponseBytes,
+		singleton.inventoryFormat, singleton.inventoryStrict, inventoryAddr)
+	singleton.mu.Lock()
+	singleton.parseErrorsTotal += uint64(skipped)
+	transformRules, cacheFile := singleton.transformRules, singleton.cacheFile
+	singleton.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	inventory := buildHostsInventory(hosts, transformRules)
 
 	singleton.mu.Lock()
 	singleton.values = inventory
+	singleton.cacheExpiresAt = time.Time{}
 	singleton.mu.Unlock()
 
+	// A failed cache write only affects the next restart, the collection itself succeeded
+	if cacheFile != "" {
+		if err := writeCacheFile(cacheFile, hosts, time.Now()); err != nil {
+			log.Warnf("Failed to cache inventory: %v", err)
+		}
+	}
+
 	log.Debugf("taskinventory.Collect retrieved %v hosts", len(hosts))
 	log.Debugf("taskinventory.Collect process took %v", time.Since(startTime))
 
 	return nil
 }
 
+// buildHostsInventory returns the inventory of the fetched hosts, after the transform rules, along with localhost.
+func buildHostsInventory(hosts []Host, transformRules []TransformRule) Inventory {
+	hosts = append(transformHosts(hosts, transformRules), Host{
+		IPAddress: "127.0.0.1",
+		Domain:    "localhost",
+		Hostgroup: "localhost",
+	})
+
+	return parseInventory(hosts)
+}
+
 // networkHost represents a mapping of network -> Host info.
 type networkHost struct {
 	network *net.IPNet
 	host    Host
 }
 
+// localOverride contains the local host information provided by the user.
+type localOverride struct {
+	hostgroup string
+	domain    string
+	// force the override to take precedence over the inventory data
+	force bool
+}
+
+// isSet returns true if any of the override values is provided.
+func (o localOverride) isSet() bool {
+	return o.hostgroup != "" || o.domain != ""
+}
+
 // Inventory contains mappings to Host information.
 type Inventory struct {
 	// ipAddresses maps IP -> Host info
 	ipAddresses map[string]Host
 	// networkCIDRAddresses maps network in CIDR notation -> Host info
 	networkCIDRAddresses []networkHost
+
+	// localOverride is used by GetLocalHost
+	localOverride localOverride
+	// natMapping takes precedence over the inventory in GetHost
+	natMapping natMapping
+	// hostgroupCase is applied to every hostgroup returned by GetHost and GetLocalHost
+	hostgroupCase HostgroupCase
+	// kubernetesNetworks collapse remote addresses in GetHost that have no more specific inventory entry
+	kubernetesNetworks []networkHost
 }
 
-// GetHost returns a Host information based on IP or Network address, in that order.
+// GetHost returns a Host information for a remote address based on the NAT mapping, IP, or Network address, in that order.
+// Kubernetes networks are matched along with the inventory networks, the inventory wins on the same prefix length.
 // e.g. address can be "192.168.1.2" or "192.168.0.0/26".
 func (i Inventory) GetHost(address string) (Host, bool) {
+	address = network.NormalizeIP(address)
+
+	// Priority 0: NAT mapping rewrites addresses that hide the real remote hosts
+	host, found := i.natMapping.lookup(address)
+	if !found {
+		host, found = i.getRemoteInventoryHost(address)
+	}
+	host.Hostgroup = i.hostgroupCase.apply(host.Hostgroup)
+
+	return host, found
+}
+
+// GetServiceName returns the name of the service listening on the port of an address, empty if it's unknown.
+func (i Inventory) GetServiceName(address, port string) string {
+	host, found := i.GetHost(address)
+	if !found {
+		return ""
+	}
+
+	return host.Services[port]
+}
+
+// getInventoryHost returns a Host information from the inventory based on IP or Network address, in that order.
+func (i Inventory) getInventoryHost(address string) (Host, bool) {
 	// Priority 1: Check for single IP address match for the address within known IP inventory
 	if host, ok := i.ipAddresses[address]; ok {
 		return host, true
 	}
 
 	// Priority 2: Check for longest-prefix match of targetIP within known network CIDR inventory
-	var matchedHost Host
+	return longestPrefixMatch(i.networkCIDRAddresses, net.ParseIP(address))
+}
+
+// getRemoteInventoryHost returns a Host information for a remote address like getInventoryHost, or the Kubernetes
+// network host when it's more specific than the inventory networks containing the address.
+func (i Inventory) getRemoteInventoryHost(address string) (Host, bool) {
+	if host, ok := i.ipAddresses[address]; ok {
+		return host, true
+	}
+
 	targetIP := net.ParseIP(address)
+	host, prefixLen := longestPrefix(i.networkCIDRAddresses, targetIP)
+	if kubernetesHost, kubernetesPrefixLen := longestPrefix(i.kubernetesNetworks, targetIP); kubernetesPrefixLen > prefixLen {
+		return kubernetesHost, true
+	}
+
+	return host, prefixLen >= 0
+}
+
+// longestPrefixMatch returns the Host of the most specific network containing targetIP.
+func longestPrefixMatch(networkHosts []networkHost, targetIP net.IP) (Host, bool) {
+	matchedHost, matchedPrefixLen := longestPrefix(networkHosts, targetIP)
+
+	// There is a match when it's greater than or equal to 0 (even 0.0.0.0/0)
+	return matchedHost, matchedPrefixLen >= 0
+}
+
+// longestPrefix returns the Host of the most specific network containing targetIP and its prefix length,
+// which is -1 if no network contains targetIP.
+func longestPrefix(networkHosts []networkHost, targetIP net.IP) (Host, int) {
+	var matchedHost Host
 	matchedPrefixLen := -1
-	for _, ipNetHost := range i.networkCIDRAddresses {
+	for _, ipNetHost := range networkHosts {
 		currPrefixLen, _ := ipNetHost.network.Mask.Size()
 		if ipNetHost.network.Contains(targetIP) && currPrefixLen > matchedPrefixLen {
 			matchedPrefixLen = currPrefixLen
 			matchedHost = ipNetHost.host
 		}
 	}
-	// There is a match when it's greater than or equal to 0 (even 0.0.0.0/0)
-	if matchedPrefixLen >= 0 {
-		return matchedHost, true
+
+	return matchedHost, matchedPrefixLen
+}
+
+// GetLocalHost returns a Host information for an address that belongs to the current host.
+// The local override is used when the address is missing from the inventory, or always when it's forced.
+// Empty override values never replace values from the inventory. The NAT mapping only applies to remote addresses.
+func (i Inventory) GetLocalHost(address string) (Host, bool) {
+	address = network.NormalizeIP(address)
+	host, found := i.getInventoryHost(address)
+	if !i.localOverride.isSet() || (found && !i.localOverride.force) {
+		host.Hostgroup = i.hostgroupCase.apply(host.Hostgroup)
+
+		return host, found
+	}
+
+	if !found {
+		host = Host{IPAddress: address} // nolint:exhaustivestruct
+	}
+	if i.localOverride.hostgroup != "" {
+		host.Hostgroup = i.localOverride.hostgroup
+	}
+	if i.localOverride.domain != "" {
+		host.Domain = i.localOverride.domain
+	}
+	host.Hostgroup = i.hostgroupCase.apply(host.Hostgroup)
+
+	return host, true
+}
+
+// localAddress returns the first of the local addresses that the inventory lists by its exact IP address,
+// or the first address (the default route address) if there's none.
+func (i Inventory) localAddress(addresses []net.IP) string {
+	for _, address := range addresses {
+		if _, ok := i.ipAddresses[network.NormalizeIP(address.String())]; ok {
+			return address.String()
+		}
 	}
 
-	return matchedHost, false
+	return addresses[0].String()
 }
 
 // parseInventory parses a list of Host into an Inventory
 // This function supports hosts with IP address containing "/" (CIDR notation).
 func parseInventory(hosts []Host) Inventory {
+	inventory, _ := buildInventory(hosts)
+
+	return inventory
+}
+
+// buildInventory builds an Inventory from a list of Host, returning the reason of every skipped host.
+func buildInventory(hosts []Host) (Inventory, []error) {
+	// Most entries are IPs, so the map is sized for all of them. The network CIDR entries are usually few,
+	// and sizing them for every host would hold much more memory than they use.
 	inventory := Inventory{
-		ipAddresses:          make(map[string]Host),
+		ipAddresses:          make(map[string]Host, len(hosts)),
 		networkCIDRAddresses: []networkHost{},
 	}
+	var skipErrs []error
 
 	for _, host := range hosts {
 		// Skip unknown hosts as they provide zero value for Planet Exporter
 		if host.Domain == "" && host.Hostgroup == "" {
+			skipErrs = append(skipErrs, fmt.Errorf("%w (address=%v)", ErrEmptyHostgroupAndDomain, host.IPAddress))
+
 			continue
 		}
 
 		if strings.Contains(host.IPAddress, "/") {
 			// A network CIDR based inventory
 
-			_, network, err := net.ParseCIDR(host.IPAddress)
+			_, ipNet, err := net.ParseCIDR(host.IPAddress)
 			if err != nil {
 				log.Debugf("Failed to parse CIDR address from an inventory host entry (address=%v): %v", host.IPAddress, err)
+				skipErrs = append(skipErrs, fmt.Errorf("error parsing CIDR address (address=%v): %w", host.IPAddress, err))
 
 				continue
 			}
 			networkCIDRAddress := networkHost{
-				network: network,
+				network: ipNet,
 				host:    host,
 			}
 
 			inventory.networkCIDRAddresses = append(inventory.networkCIDRAddresses, networkCIDRAddress)
 		} else {
-			// An IP based inventory
+			// An IP based inventory, stored in IPv4 form if it's an IPv4-mapped IPv6 address
 
+			host.IPAddress = network.NormalizeIP(host.IPAddress)
 			inventory.ipAddresses[host.IPAddress] = host
 		}
 	}
 
-	return inventory
+	return inventory, skipErrs
 }
 
+// localIPs of the current host, the default route address first, replaced in tests.
+var localIPs = network.LocalIPs
+
 // GetLocalInventory returns an inventory entry for current host.
+// The host is looked up by its first address listed in the inventory (e.g. the VLAN address of a bonded NIC),
+// or by its default route address if none is.
 func GetLocalInventory() Host {
 	var localHost Host
-	currentIP, err := network.LocalIP()
-	if err != nil {
+	currentIPs, err := localIPs()
+	if err != nil || len(currentIPs) == 0 {
 		return localHost
 	}
 
 	inventory := Get()
 
-	if h, ok := inventory.GetHost(currentIP.String()); ok {
+	if h, ok := inventory.GetLocalHost(inventory.localAddress(currentIPs)); ok {
 		localHost.IPAddress = h.IPAddress
 		localHost.Domain = h.Domain
 		localHost.Hostgroup = h.Hostgroup
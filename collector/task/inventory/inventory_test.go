@@ -15,11 +15,19 @@
 package inventory
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"io"
 	"net"
+	"net/http"
+	"net/http/httptest"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
+
+	"planet-exporter/pkg/bodylimit"
 )
 
 // mockHostsResponseData returns an io.Reader simulating inventory JSON data returned from upstream.
@@ -30,14 +38,16 @@ func mockHostsResponseData(raw string) io.ReadCloser {
 func Test_parseHosts(t *testing.T) {
 	type args struct {
 		format string
+		strict bool
 		data   io.Reader
 	}
 
 	tests := []struct {
-		name    string
-		args    args
-		want    []Host
-		wantErr bool
+		name        string
+		args        args
+		want        []Host
+		wantSkipped int
+		wantErr     bool
 	}{
 		// Format: 'ndjson'
 		{
@@ -66,6 +76,76 @@ func Test_parseHosts(t *testing.T) {
 				{IPAddress: "172.16.1.2", Domain: "abc.service.consul", Hostgroup: "abc"},
 			},
 		},
+		{
+			name: "Test mixed good and malformed ndjson inventory entries",
+			args: args{
+				format: "ndjson",
+				data: mockHostsResponseData(`
+					{"ip_address":"10.0.1.2","domain":"xyz.service.consul","hostgroup":"xyz"}
+					{"ip_address":"10.0.1.3","domain":
+					{"ip_address":"172.16.1.2","domain":"abc.service.consul","hostgroup":"abc"}
+				`),
+			},
+			want: []Host{
+				{IPAddress: "10.0.1.2", Domain: "xyz.service.consul", Hostgroup: "xyz"},
+				{IPAddress: "172.16.1.2", Domain: "abc.service.consul", Hostgroup: "abc"},
+			},
+			wantSkipped: 1,
+		},
+		{
+			name: "Test lenient ndjson inventory entry with unknown field",
+			args: args{
+				format: "ndjson",
+				data: mockHostsResponseData(`
+					{"ip_address":"10.0.1.2","domain":"xyz.service.consul","hostgroup":"xyz","owner":"team-xyz"}
+				`),
+			},
+			want: []Host{
+				{IPAddress: "10.0.1.2", Domain: "xyz.service.consul", Hostgroup: "xyz"},
+			},
+		},
+		{
+			name: "Test strict ndjson inventory entry with unknown field fails the inventory",
+			args: args{
+				format: "ndjson",
+				strict: true,
+				data: mockHostsResponseData(`
+					{"ip_address":"172.16.1.2","domain":"abc.service.consul","hostgroup":"abc"}
+					{"ip_address":"10.0.1.2","domain":"xyz.service.consul","hostgroup":"xyz","owner":"team-xyz"}
+				`),
+			},
+			want:        nil,
+			wantSkipped: 1,
+			wantErr:     true,
+		},
+		{
+			name: "Test strict ndjson inventory entry missing ip_address fails the inventory",
+			args: args{
+				format: "ndjson",
+				strict: true,
+				data: mockHostsResponseData(`
+					{"domain":"xyz.service.consul","hostgroup":"xyz"}
+				`),
+			},
+			want:        nil,
+			wantSkipped: 1,
+			wantErr:     true,
+		},
+		{
+			name: "Test strict ndjson inventory entries",
+			args: args{
+				format: "ndjson",
+				strict: true,
+				data: mockHostsResponseData(`
+					{"ip_address":"10.0.1.2","domain":"xyz.service.consul"}
+					{"ip_address":"172.16.1.2","hostgroup":"abc"}
+				`),
+			},
+			want: []Host{
+				{IPAddress: "10.0.1.2", Domain: "xyz.service.consul", Hostgroup: ""},
+				{IPAddress: "172.16.1.2", Domain: "", Hostgroup: "abc"},
+			},
+		},
 
 		// Format: 'arrayjson'
 		{
@@ -98,10 +178,114 @@ func Test_parseHosts(t *testing.T) {
 				{IPAddress: "172.16.1.2", Domain: "abc.service.consul", Hostgroup: "abc"},
 			},
 		},
+		{
+			name: "Test mixed good and bad arrayjson inventory entries",
+			args: args{
+				format: "arrayjson",
+				data: mockHostsResponseData(`
+					[
+						{"ip_address":"10.0.1.2","domain":"xyz.service.consul","hostgroup":"xyz"},
+						{"ip_address":10,"domain":"bad.service.consul","hostgroup":"bad"},
+						"not-a-host",
+						{"ip_address":"172.16.1.2","domain":"abc.service.consul","hostgroup":"abc"}
+					]
+				`),
+			},
+			want: []Host{
+				{IPAddress: "10.0.1.2", Domain: "xyz.service.consul", Hostgroup: "xyz"},
+				{IPAddress: "172.16.1.2", Domain: "abc.service.consul", Hostgroup: "abc"},
+			},
+			wantSkipped: 2,
+		},
+		{
+			name: "Test lenient arrayjson inventory entry with unknown field",
+			args: args{
+				format: "arrayjson",
+				data: mockHostsResponseData(`
+					[
+						{"ip_address":"10.0.1.2","domain":"xyz.service.consul","hostgroup":"xyz","owner":"team-xyz"}
+					]
+				`),
+			},
+			want: []Host{
+				{IPAddress: "10.0.1.2", Domain: "xyz.service.consul", Hostgroup: "xyz"},
+			},
+		},
+		{
+			name: "Test strict arrayjson inventory entry with unknown field fails the inventory",
+			args: args{
+				format: "arrayjson",
+				strict: true,
+				data: mockHostsResponseData(`
+					[
+						{"ip_address":"10.0.1.2","domain":"xyz.service.consul","hostgroup":"xyz","owner":"team-xyz"},
+						{"ip_address":"172.16.1.2","domain":"abc.service.consul","hostgroup":"abc"}
+					]
+				`),
+			},
+			want:        nil,
+			wantSkipped: 1,
+			wantErr:     true,
+		},
+		{
+			name: "Test strict arrayjson inventory entry missing hostgroup and domain fails the inventory",
+			args: args{
+				format: "arrayjson",
+				strict: true,
+				data: mockHostsResponseData(`
+					[
+						{"ip_address":"10.0.1.2"}
+					]
+				`),
+			},
+			want:        nil,
+			wantSkipped: 1,
+			wantErr:     true,
+		},
+		{
+			name: "Test arrayjson inventory entry services keyed by canonical port, invalid ports dropped",
+			args: args{
+				format: "arrayjson",
+				data: mockHostsResponseData(`
+					[
+						{"ip_address":"10.0.1.2","hostgroup":"db","services":{"3306":"mysql","09104":"mysqld-exporter","http":"nginx","0":"none"}}
+					]
+				`),
+			},
+			want: []Host{
+				{IPAddress: "10.0.1.2", Hostgroup: "db", Services: map[string]string{"3306": "mysql", "9104": "mysqld-exporter"}},
+			},
+		},
+		{
+			name: "Test strict ndjson inventory entry with an invalid service port fails the inventory",
+			args: args{
+				format: "ndjson",
+				strict: true,
+				data: mockHostsResponseData(`
+					{"ip_address":"10.0.1.2","hostgroup":"db","services":{"65536":"mysql"}}
+				`),
+			},
+			want:        nil,
+			wantSkipped: 1,
+			wantErr:     true,
+		},
+		{
+			name: "Test malformed arrayjson inventory data",
+			args: args{
+				format: "arrayjson",
+				data: mockHostsResponseData(`
+					[
+						{"ip_address":"10.0.1.2","domain":"xyz.service.consul","hostgroup":"xyz"},
+				`),
+			},
+			want:        nil,
+			wantSkipped: 1,
+			wantErr:     true,
+		},
 	}
 	for _, testcase := range tests {
 		t.Run(testcase.name, func(t *testing.T) {
-			got, err := parseHosts(testcase.args.format, testcase.args.data)
+			got, gotSkipped, err := parseHosts(testcase.args.format, testcase.args.strict, testcase.args.data)
 			if (err != nil) != testcase.wantErr {
 				t.Errorf("parseHosts() error = %v, wantErr %v", err, testcase.wantErr)
 
@@ -110,6 +294,9 @@ func Test_parseHosts(t *testing.T) {
 			if !reflect.DeepEqual(got, testcase.want) {
 				t.Errorf("parseHosts() = %v, want %v", got, testcase.want)
 			}
+			if gotSkipped != testcase.wantSkipped {
+				t.Errorf("parseHosts() skipped = %v, want %v", gotSkipped, testcase.wantSkipped)
+			}
 		})
 	}
 }
@@ -331,3 +518,318 @@ func TestInventory_GetHost(t *testing.T) {
 		})
 	}
 }
+
+func TestInventory_GetLocalHost(t *testing.T) {
+	type fields struct {
+		ipAddresses   map[string]Host
+		localOverride localOverride
+	}
+	type args struct {
+		address string
+	}
+
+	// Prepare test data
+	ipAddresses := map[string]Host{
+		"1.2.3.4": {Hostgroup: "unit-test", IPAddress: "1.2.3.4", Domain: "unit-test.local"},
+	}
+
+	tests := []struct {
+		name   string
+		fields fields
+		args   args
+		want1  Host
+		want2  bool
+	}{
+		{
+			name:   "Inventory match without override",
+			fields: fields{ipAddresses: ipAddresses},
+			args:   args{address: "1.2.3.4"},
+			want1:  Host{Hostgroup: "unit-test", IPAddress: "1.2.3.4", Domain: "unit-test.local"},
+			want2:  true,
+		},
+		{
+			name:   "No inventory match without override",
+			fields: fields{ipAddresses: ipAddresses},
+			args:   args{address: "1.2.3.5"},
+			want1:  Host{}, // nolint:exhaustivestruct
+			want2:  false,
+		},
+		{
+			name: "Override is used when inventory has no match",
+			fields: fields{
+				ipAddresses:   ipAddresses,
+				localOverride: localOverride{hostgroup: "override", domain: "override.local"},
+			},
+			args:  args{address: "1.2.3.5"},
+			want1: Host{Hostgroup: "override", IPAddress: "1.2.3.5", Domain: "override.local"},
+			want2: true,
+		},
+		{
+			name: "Override does not shadow inventory match",
+			fields: fields{
+				ipAddresses:   ipAddresses,
+				localOverride: localOverride{hostgroup: "override", domain: "override.local"},
+			},
+			args:  args{address: "1.2.3.4"},
+			want1: Host{Hostgroup: "unit-test", IPAddress: "1.2.3.4", Domain: "unit-test.local"},
+			want2: true,
+		},
+		{
+			name: "Forced override shadows inventory match",
+			fields: fields{
+				ipAddresses:   ipAddresses,
+				localOverride: localOverride{hostgroup: "override", domain: "override.local", force: true},
+			},
+			args:  args{address: "1.2.3.4"},
+			want1: Host{Hostgroup: "override", IPAddress: "1.2.3.4", Domain: "override.local"},
+			want2: true,
+		},
+		{
+			name: "Forced override without domain keeps inventory domain",
+			fields: fields{
+				ipAddresses:   ipAddresses,
+				localOverride: localOverride{hostgroup: "override", force: true},
+			},
+			args:  args{address: "1.2.3.4"},
+			want1: Host{Hostgroup: "override", IPAddress: "1.2.3.4", Domain: "unit-test.local"},
+			want2: true,
+		},
+	}
+	for _, testcase := range tests {
+		t.Run(testcase.name, func(t *testing.T) {
+			i := Inventory{
+				ipAddresses:          testcase.fields.ipAddresses,
+				networkCIDRAddresses: []networkHost{},
+				localOverride:        testcase.fields.localOverride,
+			}
+			got1, got2 := i.GetLocalHost(testcase.args.address)
+			if !reflect.DeepEqual(got1, testcase.want1) {
+				t.Errorf("Inventory.GetLocalHost() got1 = %v, want %v", got1, testcase.want1)
+			}
+			if got2 != testcase.want2 {
+				t.Errorf("Inventory.GetLocalHost() got2 = %v, want %v", got2, testcase.want2)
+			}
+		})
+	}
+}
+
+func TestGetLocalInventory(t *testing.T) {
+	defer func(ips func() ([]net.IP, error), values Inventory, override localOverride) {
+		localIPs = ips
+		singleton.mu.Lock()
+		singleton.values, singleton.localOverride = values, override
+		singleton.mu.Unlock()
+	}(localIPs, singleton.values, singleton.localOverride)
+
+	// Bonded NIC: the default route goes through the underlay address, the inventory lists the VLAN address
+	underlay := net.ParseIP("10.0.0.1")
+	vlan := net.ParseIP("10.20.0.5")
+	bridge := net.ParseIP("10.30.0.9")
+
+	tests := []struct {
+		name          string
+		localIPs      []net.IP
+		inventory     []Host
+		localOverride localOverride
+		want          Host
+	}{
+		{
+			name:     "Default route address in the inventory",
+			localIPs: []net.IP{underlay, vlan},
+			inventory: []Host{
+				{IPAddress: "10.0.0.1", Hostgroup: "underlay", Domain: "underlay.local"},
+				{IPAddress: "10.20.0.5", Hostgroup: "vlan", Domain: "vlan.local"},
+			},
+			want: Host{IPAddress: "10.0.0.1", Hostgroup: "underlay", Domain: "underlay.local"},
+		},
+		{
+			name:      "Only the VLAN address in the inventory",
+			localIPs:  []net.IP{underlay, vlan, bridge},
+			inventory: []Host{{IPAddress: "10.20.0.5", Hostgroup: "vlan", Domain: "vlan.local"}},
+			want:      Host{IPAddress: "10.20.0.5", Hostgroup: "vlan", Domain: "vlan.local"},
+		},
+		{
+			name:     "First listed address in interface order",
+			localIPs: []net.IP{underlay, vlan, bridge},
+			inventory: []Host{
+				{IPAddress: "10.30.0.9", Hostgroup: "bridge", Domain: "bridge.local"},
+				{IPAddress: "10.20.0.5", Hostgroup: "vlan", Domain: "vlan.local"},
+			},
+			want: Host{IPAddress: "10.20.0.5", Hostgroup: "vlan", Domain: "vlan.local"},
+		},
+		{
+			name:      "Exact IP match is preferred over the default route address network",
+			localIPs:  []net.IP{underlay, vlan},
+			inventory: []Host{{IPAddress: "10.0.0.0/24", Hostgroup: "underlay-net"}, {IPAddress: "10.20.0.5", Hostgroup: "vlan"}},
+			want:      Host{IPAddress: "10.20.0.5", Hostgroup: "vlan"},
+		},
+		{
+			name:      "Default route address network without exact IP match",
+			localIPs:  []net.IP{underlay, vlan},
+			inventory: []Host{{IPAddress: "10.0.0.0/24", Hostgroup: "underlay-net"}},
+			want:      Host{IPAddress: "10.0.0.0/24", Hostgroup: "underlay-net"},
+		},
+		{
+			name:          "Override of the default route address without inventory match",
+			localIPs:      []net.IP{underlay, vlan},
+			inventory:     []Host{},
+			localOverride: localOverride{hostgroup: "override"},
+			want:          Host{IPAddress: "10.0.0.1", Hostgroup: "override"},
+		},
+		{
+			name:      "No local IP address",
+			localIPs:  nil,
+			inventory: []Host{{IPAddress: "10.20.0.5", Hostgroup: "vlan"}},
+			want:      Host{}, // nolint:exhaustivestruct
+		},
+	}
+	for _, testcase := range tests {
+		t.Run(testcase.name, func(t *testing.T) {
+			ips := testcase.localIPs
+			localIPs = func() ([]net.IP, error) { return ips, nil }
+			singleton.mu.Lock()
+			singleton.values, singleton.localOverride = parseInventory(testcase.inventory), testcase.localOverride
+			singleton.mu.Unlock()
+
+			if got := GetLocalInventory(); !reflect.DeepEqual(got, testcase.want) {
+				t.Errorf("GetLocalInventory() = %v, want %v", got, testcase.want)
+			}
+		})
+	}
+}
+
+func Test_requestHosts_cancelledContext(t *testing.T) {
+	requestReceived := make(chan struct{})
+	unblock := make(chan struct{})
+	mockhttpserver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(requestReceived)
+		<-unblock
+	}))
+	defer mockhttpserver.Close()
+	// Unblock the handler before closing the server, which waits for in-flight requests
+	defer close(unblock)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-requestReceived
+		cancel()
+	}()
+
+	errChan := make(chan error, 1)
+	go func() {
+		_, _, err := requestHosts(ctx, singleton.httpClient, nil, 0, fmtArrayJSON, false, mockhttpserver.URL)
+		errChan <- err
+	}()
+
+	select {
+	case err := <-errChan:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("requestHosts() error = %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("requestHosts() is not cancelled by its context")
+	}
+}
+
+func Test_requestHosts_maxResponseBytes(t *testing.T) {
+	const body = `[{"ip_address":"10.0.0.1","hostgroup":"myapp","domain":"myapp.local"}]`
+	mockhttpserver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, body)
+	}))
+	defer mockhttpserver.Close()
+
+	_, _, err := requestHosts(context.Background(), singleton.httpClient, nil, int64(len(body)-1), fmtArrayJSON, false, mockhttpserver.URL)
+	if !errors.Is(err, bodylimit.ErrTooLarge) {
+		t.Errorf("requestHosts() error = %v, want %v", err, bodylimit.ErrTooLarge)
+	}
+
+	hosts, _, err := requestHosts(context.Background(), singleton.httpClient, nil, int64(len(body)), fmtArrayJSON, false, mockhttpserver.URL)
+	if err != nil || len(hosts) != 1 {
+		t.Errorf("requestHosts() = %v, %v, want 1 host", hosts, err)
+	}
+}
+
+func TestInventory_GetHost_ipv4MappedIPv6(t *testing.T) {
+	inventory := parseInventory([]Host{
+		{IPAddress: "10.1.2.3", Hostgroup: "unit-test", Domain: "unit-test.local"},
+		{IPAddress: "::ffff:10.1.2.4", Hostgroup: "unit-test-mapped", Domain: "unit-test-mapped.local"},
+		{IPAddress: "10.2.0.0/16", Hostgroup: "unit-test-cidr", Domain: "unit-test-cidr.local"},
+	})
+
+	tests := []struct {
+		name    string
+		address string
+		want    Host
+	}{
+		{
+			name:    "Mapped address matches IPv4 inventory entry",
+			address: "::ffff:10.1.2.3",
+			want:    Host{IPAddress: "10.1.2.3", Hostgroup: "unit-test", Domain: "unit-test.local"},
+		},
+		{
+			name:    "IPv4 address matches mapped inventory entry",
+			address: "10.1.2.4",
+			want:    Host{IPAddress: "10.1.2.4", Hostgroup: "unit-test-mapped", Domain: "unit-test-mapped.local"},
+		},
+		{
+			name:    "Mapped address matches IPv4 network CIDR",
+			address: "::ffff:10.2.3.4",
+			want:    Host{IPAddress: "10.2.0.0/16", Hostgroup: "unit-test-cidr", Domain: "unit-test-cidr.local"},
+		},
+	}
+	for _, testcase := range tests {
+		t.Run(testcase.name, func(t *testing.T) {
+			got, found := inventory.GetHost(testcase.address)
+			if !found || !reflect.DeepEqual(got, testcase.want) {
+				t.Errorf("Inventory.GetHost() = %v, %v, want %v, true", got, found, testcase.want)
+			}
+		})
+	}
+}
+
+func TestInventory_GetServiceName(t *testing.T) {
+	inventory := parseInventory([]Host{
+		{IPAddress: "10.1.2.3", Hostgroup: "db", Services: map[string]string{"3306": "mysql"}},
+		{IPAddress: "10.2.0.0/16", Hostgroup: "cache", Services: map[string]string{"6379": "redis"}},
+		{IPAddress: "10.3.0.1", Hostgroup: "app"},
+	})
+
+	tests := []struct {
+		name    string
+		address string
+		port    string
+		want    string
+	}{
+		{name: "Known port of an IP entry", address: "10.1.2.3", port: "3306", want: "mysql"},
+		{name: "Known port of a network CIDR entry", address: "10.2.3.4", port: "6379", want: "redis"},
+		{name: "Unknown port", address: "10.1.2.3", port: "9104", want: ""},
+		{name: "Entry without services", address: "10.3.0.1", port: "80", want: ""},
+		{name: "Address missing from the inventory", address: "10.4.0.1", port: "3306", want: ""},
+	}
+	for _, testcase := range tests {
+		t.Run(testcase.name, func(t *testing.T) {
+			if got := inventory.GetServiceName(testcase.address, testcase.port); got != testcase.want {
+				t.Errorf("Inventory.GetServiceName() = %v, want %v", got, testcase.want)
+			}
+		})
+	}
+}
+
+// BenchmarkParseInventory parses an inventory of 100k hosts, 1 in 100 of them network CIDR entries.
+func BenchmarkParseInventory(b *testing.B) {
+	const inventorySize = 100000
+	hosts := make([]Host, 0, inventorySize)
+	for i := 0; i < inventorySize; i++ {
+		address := fmt.Sprintf("10.%v.%v.%v", i/65536%256, i/256%256, i%256)
+		if i%100 == 0 {
+			address = fmt.Sprintf("172.%v.%v.0/24", 16+i/65536%16, i/256%256)
+		}
+		hosts = append(hosts, Host{IPAddress: address, Domain: fmt.Sprintf("host-%v.service.consul", i), Hostgroup: fmt.Sprintf("hostgroup-%v", i%500)}) // nolint:exhaustivestruct
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		parseInventory(hosts)
+	}
+}
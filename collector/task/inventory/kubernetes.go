@@ -0,0 +1,57 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inventory
+
+import (
+	"net"
+)
+
+const (
+	// DefaultKubernetesPodHostgroup is the default hostgroup of remote addresses in the Kubernetes pod networks.
+	DefaultKubernetesPodHostgroup = "k8s-pods"
+	// DefaultKubernetesServiceHostgroup is the default hostgroup of remote addresses in the Kubernetes service networks.
+	DefaultKubernetesServiceHostgroup = "k8s-services"
+)
+
+// newKubernetesNetworks returns the synthetic hosts of Kubernetes networks, a host per network
+// whose domain is the network itself.
+func newKubernetesNetworks(networks []*net.IPNet, hostgroup string) []networkHost {
+	networkHosts := make([]networkHost, 0, len(networks))
+	for _, ipNet := range networks {
+		networkHosts = append(networkHosts, networkHost{
+			network: ipNet,
+			host: Host{ // nolint:exhaustivestruct
+				IPAddress:         ipNet.String(),
+				Domain:            ipNet.String(),
+				Hostgroup:         hostgroup,
+				kubernetesNetwork: true,
+			},
+		})
+	}
+
+	return networkHosts
+}
+
+// SetKubernetesNetworks collapses remote addresses in the Kubernetes pod and service networks, whose IPs churn as
+// pods are rescheduled, to a synthetic host per network with podHostgroup or serviceHostgroup.
+// Inventory entries for the same or a more specific network, or for the exact IP, take precedence.
+func SetKubernetesNetworks(podCIDRs []*net.IPNet, podHostgroup string, serviceCIDRs []*net.IPNet, serviceHostgroup string) {
+	kubernetesNetworks := newKubernetesNetworks(podCIDRs, podHostgroup)
+	kubernetesNetworks = append(kubernetesNetworks, newKubernetesNetworks(serviceCIDRs, serviceHostgroup)...)
+
+	singleton.mu.Lock()
+	singleton.kubernetesNetworks = kubernetesNetworks
+	singleton.mu.Unlock()
+}
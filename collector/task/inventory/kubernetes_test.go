@@ -0,0 +1,130 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inventory
+
+import (
+	"reflect"
+	"testing"
+
+	"planet-exporter/pkg/network"
+)
+
+func TestInventory_GetHost_kubernetesNetworks(t *testing.T) {
+	podCIDRs, err := network.ParseCIDRs("10.244.0.0/16")
+	if err != nil {
+		t.Fatalf("network.ParseCIDRs() error = %v", err)
+	}
+	serviceCIDRs, err := network.ParseCIDRs("10.96.0.0/12")
+	if err != nil {
+		t.Fatalf("network.ParseCIDRs() error = %v", err)
+	}
+	inventory := parseInventory([]Host{
+		{IPAddress: "10.0.0.0/8", Hostgroup: "datacenter", Domain: "dc.local"},
+		{IPAddress: "10.244.5.0/24", Hostgroup: "ingress-nodes", Domain: "ingress.local"},
+		{IPAddress: "10.96.0.0/12", Hostgroup: "inventory-services", Domain: "services.local"},
+		{IPAddress: "10.244.0.10", Hostgroup: "coredns", Domain: "coredns.local"},
+	})
+	inventory.kubernetesNetworks = append(newKubernetesNetworks(podCIDRs, DefaultKubernetesPodHostgroup),
+		newKubernetesNetworks(serviceCIDRs, DefaultKubernetesServiceHostgroup)...)
+
+	tests := []struct {
+		name      string
+		address   string
+		wantHost  Host
+		wantFound bool
+		// wantRemoteIPAddr exported for the address
+		wantRemoteIPAddr string
+	}{
+		{
+			name:             "Pod IP is collapsed over a broader inventory CIDR",
+			address:          "10.244.1.2",
+			wantHost:         Host{IPAddress: "10.244.0.0/16", Hostgroup: "k8s-pods", Domain: "10.244.0.0/16", kubernetesNetwork: true},
+			wantFound:        true,
+			wantRemoteIPAddr: "10.244.0.0/16",
+		},
+		{
+			name:             "Inventory IP wins",
+			address:          "10.244.0.10",
+			wantHost:         Host{IPAddress: "10.244.0.10", Hostgroup: "coredns", Domain: "coredns.local"},
+			wantFound:        true,
+			wantRemoteIPAddr: "10.244.0.10",
+		},
+		{
+			name:             "More specific inventory CIDR wins",
+			address:          "10.244.5.1",
+			wantHost:         Host{IPAddress: "10.244.5.0/24", Hostgroup: "ingress-nodes", Domain: "ingress.local"},
+			wantFound:        true,
+			wantRemoteIPAddr: "10.244.5.1",
+		},
+		{
+			name:             "Inventory CIDR wins on the same prefix length",
+			address:          "10.96.0.1",
+			wantHost:         Host{IPAddress: "10.96.0.0/12", Hostgroup: "inventory-services", Domain: "services.local"},
+			wantFound:        true,
+			wantRemoteIPAddr: "10.96.0.1",
+		},
+		{
+			name:             "Address outside Kubernetes networks",
+			address:          "10.1.0.1",
+			wantHost:         Host{IPAddress: "10.0.0.0/8", Hostgroup: "datacenter", Domain: "dc.local"},
+			wantFound:        true,
+			wantRemoteIPAddr: "10.1.0.1",
+		},
+		{
+			name:             "IPv4-mapped pod IP",
+			address:          "::ffff:10.244.9.9",
+			wantHost:         Host{IPAddress: "10.244.0.0/16", Hostgroup: "k8s-pods", Domain: "10.244.0.0/16", kubernetesNetwork: true},
+			wantFound:        true,
+			wantRemoteIPAddr: "10.244.0.0/16",
+		},
+	}
+	for _, testcase := range tests {
+		t.Run(testcase.name, func(t *testing.T) {
+			got1, got2 := inventory.GetHost(testcase.address)
+			if !reflect.DeepEqual(got1, testcase.wantHost) {
+				t.Errorf("Inventory.GetHost() got1 = %v, want %v", got1, testcase.wantHost)
+			}
+			if got2 != testcase.wantFound {
+				t.Errorf("Inventory.GetHost() got2 = %v, want %v", got2, testcase.wantFound)
+			}
+			if got := got1.RemoteIPAddr(testcase.address); got != testcase.wantRemoteIPAddr {
+				t.Errorf("Host.RemoteIPAddr() = %v, want %v", got, testcase.wantRemoteIPAddr)
+			}
+		})
+	}
+
+	// Kubernetes networks only collapse remote addresses
+	want := Host{IPAddress: "10.0.0.0/8", Hostgroup: "datacenter", Domain: "dc.local"}
+	if got, _ := inventory.GetLocalHost("10.244.1.2"); !reflect.DeepEqual(got, want) {
+		t.Errorf("Inventory.GetLocalHost() = %v, want %v", got, want)
+	}
+}
+
+func TestInventory_GetHost_kubernetesNetworksWithoutInventory(t *testing.T) {
+	serviceCIDRs, err := network.ParseCIDRs("10.96.0.0/12")
+	if err != nil {
+		t.Fatalf("network.ParseCIDRs() error = %v", err)
+	}
+	inventory := parseInventory(nil)
+	inventory.kubernetesNetworks = newKubernetesNetworks(serviceCIDRs, "svc")
+
+	want := Host{IPAddress: "10.96.0.0/12", Hostgroup: "svc", Domain: "10.96.0.0/12", kubernetesNetwork: true} // nolint:exhaustivestruct
+	if got, found := inventory.GetHost("10.100.0.1"); !found || !reflect.DeepEqual(got, want) {
+		t.Errorf("Inventory.GetHost() = %v, %v, want %v, true", got, found, want)
+	}
+	if _, found := inventory.GetHost("192.168.0.1"); found {
+		t.Errorf("Inventory.GetHost() found = true, want false")
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inventory
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+
+	"planet-exporter/pkg/network"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// natMappingEmptyValue marks an empty hostgroup or domain in a NAT mapping entry.
+const natMappingEmptyValue = "-"
+
+// natMapping rewrites remote addresses (e.g. cloud NAT gateways) to a replacement Host.
+// It takes precedence over the inventory for remote lookups.
+type natMapping struct {
+	ipAddresses          map[string]Host
+	networkCIDRAddresses []networkHost
+}
+
+// lookup returns the replacement Host for an address, preferring an exact IP match over the longest-prefix CIDR match.
+func (m natMapping) lookup(address string) (Host, bool) {
+	if host, ok := m.ipAddresses[address]; ok {
+		return host, true
+	}
+
+	return longestPrefixMatch(m.networkCIDRAddresses, net.ParseIP(address))
+}
+
+// parseNATMapping parses a NAT mapping file.
+//
+// Each non-empty line that isn't a '#' comment has the format:
+//
+//	<IP or CIDR> <hostgroup> <domain> [comment...]
+//
+// Fields are whitespace-separated, '-' marks an empty hostgroup or domain, and everything
+// after the domain is a free-form comment. e.g.
+//
+//	10.255.0.0/24 external-nat nat.example cloud NAT gateway
+func parseNATMapping(r io.Reader) (natMapping, error) {
+	const minFields = 3
+
+	mapping := natMapping{
+		ipAddresses:          make(map[string]Host),
+		networkCIDRAddresses: []networkHost{},
+	}
+
+	scanner := bufio.NewScanner(r)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < minFields {
+			return natMapping{}, fmt.Errorf("error parsing NAT mapping line %v: expected '<address> <hostgroup> <domain> [comment]', got %q", lineNum, line)
+		}
+
+		host := Host{
+			IPAddress: fields[0],
+			Hostgroup: natMappingValue(fields[1]),
+			Domain:    natMappingValue(fields[2]),
+		}
+		if host.Hostgroup == "" && host.Domain == "" {
+			return natMapping{}, fmt.Errorf("error parsing NAT mapping line %v: hostgroup and domain are both empty", lineNum)
+		}
+
+		if strings.Contains(host.IPAddress, "/") {
+			_, ipNet, err := net.ParseCIDR(host.IPAddress)
+			if err != nil {
+				return natMapping{}, fmt.Errorf("error parsing NAT mapping line %v: %w", lineNum, err)
+			}
+			mapping.networkCIDRAddresses = append(mapping.networkCIDRAddresses, networkHost{
+				network: ipNet,
+				host:    host,
+			})
+
+			continue
+		}
+
+		host.IPAddress = network.NormalizeIP(host.IPAddress)
+		if net.ParseIP(host.IPAddress) == nil {
+			return natMapping{}, fmt.Errorf("error parsing NAT mapping line %v: invalid IP address %q", lineNum, host.IPAddress)
+		}
+		if _, ok := mapping.ipAddresses[host.IPAddress]; ok {
+			return natMapping{}, fmt.Errorf("error parsing NAT mapping line %v: duplicate address %q", lineNum, host.IPAddress)
+		}
+		mapping.ipAddresses[host.IPAddress] = host
+	}
+	if err := scanner.Err(); err != nil {
+		return natMapping{}, fmt.Errorf("error reading NAT mapping: %w", err)
+	}
+
+	return mapping, nil
+}
+
+// natMappingValue converts the empty value marker to an empty string.
+func natMappingValue(field string) string {
+	if field == natMappingEmptyValue {
+		return ""
+	}
+
+	return field
+}
+
+// LoadNATMapping loads the NAT mapping file used to rewrite remote addresses before the inventory lookup.
+// An empty path clears the mapping. The previous mapping is kept if the file is invalid.
+func LoadNATMapping(path string) error {
+	mapping := natMapping{} // nolint:exhaustivestruct
+	if path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("error opening NAT mapping file: %w", err)
+		}
+		defer f.Close()
+
+		mapping, err = parseNATMapping(f)
+		if err != nil {
+			return err
+		}
+	}
+
+	singleton.mu.Lock()
+	singleton.natMapping = mapping
+	singleton.mu.Unlock()
+
+	log.Infof("Loaded %v NAT mapping entries from '%v'", len(mapping.ipAddresses)+len(mapping.networkCIDRAddresses), path)
+
+	return nil
+}
@@ -0,0 +1,164 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inventory
+
+import (
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_parseNATMapping(t *testing.T) {
+	_, natCIDR, _ := net.ParseCIDR("10.255.0.0/24")
+
+	tests := []struct {
+		name    string
+		input   string
+		want    natMapping
+		wantErr bool
+	}{
+		{
+			name: "IP and CIDR entries with comments",
+			input: `# cloud NAT gateways
+10.255.0.0/24   external-nat   nat.example   cloud NAT gateway (ap-southeast-1)
+
+1.2.3.4 - saas.example *
+`,
+			want: natMapping{
+				ipAddresses: map[string]Host{
+					"1.2.3.4": {IPAddress: "1.2.3.4", Hostgroup: "", Domain: "saas.example"},
+				},
+				networkCIDRAddresses: []networkHost{
+					{network: natCIDR, host: Host{IPAddress: "10.255.0.0/24", Hostgroup: "external-nat", Domain: "nat.example"}},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name:  "Empty file",
+			input: "",
+			want: natMapping{
+				ipAddresses:          map[string]Host{},
+				networkCIDRAddresses: []networkHost{},
+			},
+			wantErr: false,
+		},
+		{
+			name:    "Missing domain field",
+			input:   "10.255.0.0/24 external-nat",
+			wantErr: true,
+		},
+		{
+			name:    "Invalid CIDR",
+			input:   "10.255.0.0/33 external-nat nat.example",
+			wantErr: true,
+		},
+		{
+			name:    "Invalid IP",
+			input:   "10.255.0 external-nat nat.example",
+			wantErr: true,
+		},
+		{
+			name:    "Empty hostgroup and domain",
+			input:   "10.255.0.1 - -",
+			wantErr: true,
+		},
+		{
+			name:    "Duplicate IP",
+			input:   "10.255.0.1 a a.example\n10.255.0.1 b b.example",
+			wantErr: true,
+		},
+	}
+	for _, testcase := range tests {
+		t.Run(testcase.name, func(t *testing.T) {
+			got, err := parseNATMapping(strings.NewReader(testcase.input))
+			if (err != nil) != testcase.wantErr {
+				t.Fatalf("parseNATMapping() error = %v, wantErr %v", err, testcase.wantErr)
+			}
+			if testcase.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(got, testcase.want) {
+				t.Errorf("parseNATMapping() = %v, want %v", got, testcase.want)
+			}
+		})
+	}
+}
+
+func TestInventory_GetHost_natMapping(t *testing.T) {
+	mapping, err := parseNATMapping(strings.NewReader(`
+10.255.0.0/16 external-nat nat.example
+10.255.1.0/24 external-nat-1 nat-1.example
+10.255.0.7 - saas.example
+`))
+	if err != nil {
+		t.Fatalf("parseNATMapping() error = %v", err)
+	}
+	inventory := parseInventory([]Host{
+		{IPAddress: "10.255.0.0/24", Hostgroup: "inventory-nat", Domain: "inventory-nat.local"},
+		{IPAddress: "10.0.0.1", Hostgroup: "unit-test", Domain: "unit-test.local"},
+	})
+	inventory.natMapping = mapping
+
+	tests := []struct {
+		name      string
+		address   string
+		wantHost  Host
+		wantFound bool
+	}{
+		{
+			name:      "NAT mapping takes precedence over inventory CIDR",
+			address:   "10.255.0.1",
+			wantHost:  Host{IPAddress: "10.255.0.0/16", Hostgroup: "external-nat", Domain: "nat.example"},
+			wantFound: true,
+		},
+		{
+			name:      "Longest-prefix NAT mapping match",
+			address:   "10.255.1.1",
+			wantHost:  Host{IPAddress: "10.255.1.0/24", Hostgroup: "external-nat-1", Domain: "nat-1.example"},
+			wantFound: true,
+		},
+		{
+			name:      "NAT mapping IP match",
+			address:   "10.255.0.7",
+			wantHost:  Host{IPAddress: "10.255.0.7", Hostgroup: "", Domain: "saas.example"},
+			wantFound: true,
+		},
+		{
+			name:      "Fallback to inventory",
+			address:   "10.0.0.1",
+			wantHost:  Host{IPAddress: "10.0.0.1", Hostgroup: "unit-test", Domain: "unit-test.local"},
+			wantFound: true,
+		},
+	}
+	for _, testcase := range tests {
+		t.Run(testcase.name, func(t *testing.T) {
+			got1, got2 := inventory.GetHost(testcase.address)
+			if !reflect.DeepEqual(got1, testcase.wantHost) {
+				t.Errorf("Inventory.GetHost() got1 = %v, want %v", got1, testcase.wantHost)
+			}
+			if got2 != testcase.wantFound {
+				t.Errorf("Inventory.GetHost() got2 = %v, want %v", got2, testcase.wantFound)
+			}
+		})
+	}
+
+	// The NAT mapping only rewrites remote addresses
+	want := Host{IPAddress: "10.255.0.0/24", Hostgroup: "inventory-nat", Domain: "inventory-nat.local"}
+	if got, _ := inventory.GetLocalHost("10.255.0.1"); !reflect.DeepEqual(got, want) {
+		t.Errorf("Inventory.GetLocalHost() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inventory
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// srvSchemePrefix marks an inventory address whose host is a DNS SRV record
+	// e.g. 'srv+http://_inventory._tcp.example.internal/path'.
+	srvSchemePrefix = "srv+"
+
+	// srvCacheTTL is how long resolved SRV records are reused.
+	// Go's resolver doesn't expose record TTLs, so this acts as an upper bound of the record TTL.
+	srvCacheTTL = 30 * time.Second
+)
+
+// srvResolver looks up DNS SRV records, implemented by net.Resolver.
+type srvResolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+}
+
+// ErrNoSRVRecords SRV record lookup returned no targets.
+var ErrNoSRVRecords = fmt.Errorf("no SRV records found")
+
+// srvAddrResolver resolves 'srv+' inventory addresses into a URL of one of the SRV targets.
+type srvAddrResolver struct {
+	resolver srvResolver
+	ttl      time.Duration
+	now      func() time.Time
+	// randIntn returns a random number in [0,n) for weighted target selection
+	randIntn func(n int) int
+
+	mu        sync.Mutex
+	name      string
+	records   []*net.SRV
+	expiresAt time.Time
+}
+
+// newSRVAddrResolver returns a srvAddrResolver using the given resolver.
+func newSRVAddrResolver(resolver srvResolver, ttl time.Duration) *srvAddrResolver {
+	return &srvAddrResolver{
+		resolver: resolver,
+		ttl:      ttl,
+		now:      time.Now,
+		randIntn: rand.Intn, // nolint:gosec
+		mu:       sync.Mutex{},
+	}
+}
+
+// isSRVAddr returns true if the inventory address needs SRV resolution.
+func isSRVAddr(inventoryAddr string) bool {
+	return strings.HasPrefix(inventoryAddr, srvSchemePrefix)
+}
+
+// resolve returns the inventory URL with the SRV record replaced by a weighted random target.
+// Addresses without the 'srv+' prefix are returned as is.
+func (r *srvAddrResolver) resolve(ctx context.Context, inventoryAddr string) (string, error) {
+	if !isSRVAddr(inventoryAddr) {
+		return inventoryAddr, nil
+	}
+
+	inventoryURL, err := url.Parse(strings.TrimPrefix(inventoryAddr, srvSchemePrefix))
+	if err != nil {
+		return "", fmt.Errorf("error parsing inventory SRV address: %w", err)
+	}
+
+	records, err := r.lookup(ctx, inventoryURL.Hostname())
+	if err != nil {
+		return "", err
+	}
+
+	target := pickSRVTarget(records, r.randIntn)
+	inventoryURL.Host = net.JoinHostPort(strings.TrimSuffix(target.Target, "."), strconv.Itoa(int(target.Port)))
+
+	return inventoryURL.String(), nil
+}
+
+// lookup returns the SRV records of name, cached for the resolver's ttl.
+func (r *srvAddrResolver) lookup(ctx context.Context, name string) ([]*net.SRV, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.name == name && r.now().Before(r.expiresAt) {
+		return r.records, nil
+	}
+
+	_, records, err := r.resolver.LookupSRV(ctx, "", "", name)
+	if err != nil {
+		return nil, fmt.Errorf("error resolving inventory SRV record %v: %w", name, err)
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("error resolving inventory SRV record %v: %w", name, ErrNoSRVRecords)
+	}
+
+	r.name = name
+	r.records = records
+	r.expiresAt = r.now().Add(r.ttl)
+
+	return records, nil
+}
+
+// pickSRVTarget returns a weighted random target among the records with the lowest priority (RFC 2782).
+func pickSRVTarget(records []*net.SRV, randIntn func(n int) int) *net.SRV {
+	var candidates []*net.SRV
+	for _, record := range records {
+		switch {
+		case len(candidates) == 0 || record.Priority < candidates[0].Priority:
+			candidates = []*net.SRV{record}
+		case record.Priority == candidates[0].Priority:
+			candidates = append(candidates, record)
+		}
+	}
+
+	totalWeight := 0
+	for _, candidate := range candidates {
+		totalWeight += int(candidate.Weight)
+	}
+	if totalWeight == 0 {
+		return candidates[randIntn(len(candidates))]
+	}
+
+	n := randIntn(totalWeight)
+	for _, candidate := range candidates {
+		if n < int(candidate.Weight) {
+			return candidate
+		}
+		n -= int(candidate.Weight)
+	}
+
+	return candidates[len(candidates)-1]
+}
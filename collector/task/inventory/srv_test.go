@@ -0,0 +1,155 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inventory
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+var errFakeResolver = errors.New("fake resolver error")
+
+// fakeSRVResolver returns static SRV records and counts the lookups it received.
+type fakeSRVResolver struct {
+	records []*net.SRV
+	err     error
+	lookups int
+}
+
+func (f *fakeSRVResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	f.lookups++
+
+	return name, f.records, f.err
+}
+
+func Test_srvAddrResolver_resolve(t *testing.T) {
+	tests := []struct {
+		name          string
+		inventoryAddr string
+		records       []*net.SRV
+		resolverErr   error
+		want          string
+		wantErr       bool
+	}{
+		{
+			name:          "Plain address is returned as is",
+			inventoryAddr: "http://inventory.example.internal/hosts",
+			want:          "http://inventory.example.internal/hosts",
+		},
+		{
+			name:          "SRV address is resolved",
+			inventoryAddr: "srv+http://_inventory._tcp.example.internal/path?format=ndjson",
+			records:       []*net.SRV{{Target: "inventory-1.example.internal.", Port: 8080, Priority: 10, Weight: 1}},
+			want:          "http://inventory-1.example.internal:8080/path?format=ndjson",
+		},
+		{
+			name:          "Lowest priority target is preferred",
+			inventoryAddr: "srv+https://_inventory._tcp.example.internal/path",
+			records: []*net.SRV{
+				{Target: "backup.example.internal.", Port: 443, Priority: 20, Weight: 100},
+				{Target: "primary.example.internal.", Port: 8443, Priority: 10, Weight: 1},
+			},
+			want: "https://primary.example.internal:8443/path",
+		},
+		{
+			name:          "Resolver error",
+			inventoryAddr: "srv+http://_inventory._tcp.example.internal/path",
+			resolverErr:   errFakeResolver,
+			wantErr:       true,
+		},
+		{
+			name:          "No records",
+			inventoryAddr: "srv+http://_inventory._tcp.example.internal/path",
+			wantErr:       true,
+		},
+	}
+	for _, testcase := range tests {
+		t.Run(testcase.name, func(t *testing.T) {
+			r := newSRVAddrResolver(&fakeSRVResolver{records: testcase.records, err: testcase.resolverErr}, time.Minute)
+
+			got, err := r.resolve(context.Background(), testcase.inventoryAddr)
+			if (err != nil) != testcase.wantErr {
+				t.Errorf("srvAddrResolver.resolve() error = %v, wantErr %v", err, testcase.wantErr)
+
+				return
+			}
+			if got != testcase.want {
+				t.Errorf("srvAddrResolver.resolve() = %v, want %v", got, testcase.want)
+			}
+		})
+	}
+}
+
+func Test_srvAddrResolver_resolveCache(t *testing.T) {
+	resolver := &fakeSRVResolver{records: []*net.SRV{{Target: "inventory-1.example.internal.", Port: 80}}}
+	now := time.Unix(0, 0)
+	r := newSRVAddrResolver(resolver, time.Minute)
+	r.now = func() time.Time { return now }
+
+	resolve := func() {
+		t.Helper()
+		if _, err := r.resolve(context.Background(), "srv+http://_inventory._tcp.example.internal/"); err != nil {
+			t.Errorf("srvAddrResolver.resolve() error = %v", err)
+		}
+	}
+
+	resolve()
+	now = now.Add(30 * time.Second)
+	resolve()
+	if resolver.lookups != 1 {
+		t.Errorf("srvAddrResolver.resolve() lookups within TTL = %v, want 1", resolver.lookups)
+	}
+
+	now = now.Add(time.Minute)
+	resolve()
+	if resolver.lookups != 2 {
+		t.Errorf("srvAddrResolver.resolve() lookups after TTL = %v, want 2", resolver.lookups)
+	}
+}
+
+func Test_pickSRVTarget(t *testing.T) {
+	records := []*net.SRV{
+		{Target: "a", Priority: 10, Weight: 1},
+		{Target: "b", Priority: 10, Weight: 3},
+		{Target: "c", Priority: 20, Weight: 100},
+	}
+	tests := []struct {
+		name    string
+		records []*net.SRV
+		rand    int
+		want    string
+	}{
+		{name: "First weight slot", records: records, rand: 0, want: "a"},
+		{name: "Second weight slot", records: records, rand: 1, want: "b"},
+		{name: "Last weight slot", records: records, rand: 3, want: "b"},
+		{
+			name:    "Zero weights are picked uniformly",
+			records: []*net.SRV{{Target: "a"}, {Target: "b"}},
+			rand:    1,
+			want:    "b",
+		},
+	}
+	for _, testcase := range tests {
+		t.Run(testcase.name, func(t *testing.T) {
+			got := pickSRVTarget(testcase.records, func(n int) int { return testcase.rand % n })
+			if got.Target != testcase.want {
+				t.Errorf("pickSRVTarget() = %v, want %v", got.Target, testcase.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inventory
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Host fields rewritten by a TransformRule.
+const (
+	TransformFieldDomain    = "domain"
+	TransformFieldHostgroup = "hostgroup"
+)
+
+// ErrInvalidTransformRule inventory transform rule can't be parsed.
+var ErrInvalidTransformRule = errors.New("invalid inventory transform rule")
+
+// TransformRule rewrites a field of every inventory host, replacing the matches of Pattern with Replacement
+// (see regexp.Regexp.ReplaceAllString, e.g. '$1' expands to the first submatch).
+type TransformRule struct {
+	Field       string
+	Pattern     *regexp.Regexp
+	Replacement string
+}
+
+// ParseTransformRule parses a '<field>=<regex>=<replacement>' transform rule, where field is 'domain' or 'hostgroup',
+// and the replacement is everything after the last '='. e.g.
+//
+//	domain=\.corp\.example\.com$=
+//	hostgroup=^legacy-(.+)$=$1
+func ParseTransformRule(s string) (TransformRule, error) {
+	field, rest, ok := strings.Cut(s, "=")
+	separator := strings.LastIndex(rest, "=")
+	if !ok || separator < 0 {
+		return TransformRule{}, fmt.Errorf("%w %q: expected '<field>=<regex>=<replacement>'", ErrInvalidTransformRule, s)
+	}
+
+	field = strings.ToLower(strings.TrimSpace(field))
+	if field != TransformFieldDomain && field != TransformFieldHostgroup {
+		return TransformRule{}, fmt.Errorf("%w %q: field %q isn't '%v' or '%v'", ErrInvalidTransformRule, s, field,
+			TransformFieldDomain, TransformFieldHostgroup)
+	}
+	pattern, err := regexp.Compile(rest[:separator])
+	if err != nil {
+		return TransformRule{}, fmt.Errorf("%w %q: %v", ErrInvalidTransformRule, s, err)
+	}
+
+	return TransformRule{
+		Field:       field,
+		Pattern:     pattern,
+		Replacement: rest[separator+1:],
+	}, nil
+}
+
+// String returns the rule in the format parsed by ParseTransformRule.
+func (r TransformRule) String() string {
+	return r.Field + "=" + r.Pattern.String() + "=" + r.Replacement
+}
+
+// apply returns the host with the rule's field rewritten.
+func (r TransformRule) apply(host Host) Host {
+	switch r.Field {
+	case TransformFieldDomain:
+		host.Domain = r.Pattern.ReplaceAllString(host.Domain, r.Replacement)
+	case TransformFieldHostgroup:
+		host.Hostgroup = r.Pattern.ReplaceAllString(host.Hostgroup, r.Replacement)
+	}
+
+	return host
+}
+
+// TransformRulesFlag is a repeatable '<field>=<regex>=<replacement>' command-line flag that collects transform rules,
+// applied in order.
+type TransformRulesFlag []TransformRule
+
+// Set implements flag.Value.
+func (f *TransformRulesFlag) Set(value string) error {
+	rule, err := ParseTransformRule(value)
+	if err != nil {
+		return err
+	}
+	*f = append(*f, rule)
+
+	return nil
+}
+
+// String implements flag.Value.
+func (f *TransformRulesFlag) String() string {
+	if f == nil {
+		return ""
+	}
+	rules := make([]string, 0, len(*f))
+	for _, rule := range *f {
+		rules = append(rules, rule.String())
+	}
+
+	return strings.Join(rules, ",")
+}
+
+// transformHosts returns the hosts rewritten by every rule, in order. The hosts aren't modified.
+func transformHosts(hosts []Host, rules []TransformRule) []Host {
+	if len(rules) == 0 {
+		return hosts
+	}
+
+	transformed := make([]Host, 0, len(hosts))
+	for _, host := range hosts {
+		for _, rule := range rules {
+			host = rule.apply(host)
+		}
+		transformed = append(transformed, host)
+	}
+
+	return transformed
+}
+
+// SetTransformRules sets the rules rewriting the hosts of the next inventory collections, e.g. to strip a DNS suffix
+// from domains or to rename legacy hostgroups.
+func SetTransformRules(rules []TransformRule) {
+	singleton.mu.Lock()
+	singleton.transformRules = rules
+	singleton.mu.Unlock()
+}
@@ -0,0 +1,106 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inventory
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseTransformRule(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{name: "Domain suffix strip", input: `domain=\.corp\.example\.com$=`, want: `domain=\.corp\.example\.com$=`, wantErr: false},
+		{name: "Hostgroup rename with submatch", input: "Hostgroup=^legacy-(.+)$=$1", want: "hostgroup=^legacy-(.+)$=$1", wantErr: false},
+		{name: "Missing replacement", input: "hostgroup=^legacy-", want: "", wantErr: true},
+		{name: "Unsupported field", input: "address=^10\\.=", want: "", wantErr: true},
+		{name: "Invalid regex", input: "domain=(=", want: "", wantErr: true},
+	}
+	for _, testcase := range tests {
+		t.Run(testcase.name, func(t *testing.T) {
+			got, err := ParseTransformRule(testcase.input)
+			if (err != nil) != testcase.wantErr {
+				t.Fatalf("ParseTransformRule() error = %v, wantErr %v", err, testcase.wantErr)
+			}
+			if testcase.wantErr {
+				if !errors.Is(err, ErrInvalidTransformRule) {
+					t.Errorf("ParseTransformRule() error = %v, want %v", err, ErrInvalidTransformRule)
+				}
+
+				return
+			}
+			if got.String() != testcase.want {
+				t.Errorf("ParseTransformRule() = %v, want %v", got, testcase.want)
+			}
+		})
+	}
+}
+
+func Test_transformHosts(t *testing.T) {
+	hosts := []Host{
+		{IPAddress: "10.0.0.1", Hostgroup: "legacy-payments", Domain: "payments-1.corp.example.com"},
+		{IPAddress: "10.0.0.2", Hostgroup: "search", Domain: "search-1.corp.example.com"},
+		{IPAddress: "10.1.0.0/16", Hostgroup: "legacy-batch", Domain: "batch.example.com"},
+	}
+
+	tests := []struct {
+		name  string
+		rules []string
+		want  map[string]Host
+	}{
+		{
+			name:  "Strip a domain suffix",
+			rules: []string{`domain=\.corp\.example\.com$=`},
+			want: map[string]Host{
+				"10.0.0.1": {IPAddress: "10.0.0.1", Hostgroup: "legacy-payments", Domain: "payments-1"},
+				"10.0.0.2": {IPAddress: "10.0.0.2", Hostgroup: "search", Domain: "search-1"},
+				"10.1.2.3": {IPAddress: "10.1.0.0/16", Hostgroup: "legacy-batch", Domain: "batch.example.com"},
+			},
+		},
+		{
+			name:  "Rename legacy hostgroups",
+			rules: []string{"hostgroup=^legacy-(.+)$=$1", "hostgroup=^payments$=checkout"},
+			want: map[string]Host{
+				"10.0.0.1": {IPAddress: "10.0.0.1", Hostgroup: "checkout", Domain: "payments-1.corp.example.com"},
+				"10.0.0.2": {IPAddress: "10.0.0.2", Hostgroup: "search", Domain: "search-1.corp.example.com"},
+				"10.1.2.3": {IPAddress: "10.1.0.0/16", Hostgroup: "batch", Domain: "batch.example.com"},
+			},
+		},
+	}
+	for _, testcase := range tests {
+		t.Run(testcase.name, func(t *testing.T) {
+			var rules TransformRulesFlag
+			for _, rule := range testcase.rules {
+				if err := rules.Set(rule); err != nil {
+					t.Fatalf("TransformRulesFlag.Set() error = %v", err)
+				}
+			}
+			inventory := parseInventory(transformHosts(hosts, rules))
+			for address, want := range testcase.want {
+				if got, _ := inventory.GetHost(address); !reflect.DeepEqual(got, want) {
+					t.Errorf("GetHost(%v) = %v, want %v", address, got, want)
+				}
+			}
+			if hosts[0].Hostgroup != "legacy-payments" {
+				t.Errorf("transformHosts() modified the hosts, got hostgroup %v", hosts[0].Hostgroup)
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inventory
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+
+	"planet-exporter/pkg/bodylimit"
+	"planet-exporter/pkg/httpheader"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ValidationReport summarizes how an inventory parses.
+type ValidationReport struct {
+	// IPHosts is the number of usable single IP address entries.
+	IPHosts int
+	// CIDRHosts is the number of usable network CIDR entries.
+	CIDRHosts int
+	// Skipped contains the reason of every skipped entry.
+	Skipped []error
+}
+
+// UsableHosts returns the number of inventory entries that can be used for lookups.
+func (r ValidationReport) UsableHosts() int {
+	return r.IPHosts + r.CIDRHosts
+}
+
+// Validate fetches the inventory from inventoryAddr (supports 'srv+' addresses) with httpHeaders and reports how it parses.
+// An error is returned if the inventory can't be fetched, is larger than maxResponseBytes (if positive),
+// or its format can't be parsed at all. The request goes through the proxy set by SetProxy.
+func Validate(ctx context.Context, inventoryAddr string, httpHeaders http.Header, maxResponseBytes int64,
+	inventoryFormat string, strict bool) (ValidationReport, error) {
+	if inventoryAddr == "" {
+		return ValidationReport{}, ErrEmptyInventoryAddr
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, collectTimeout)
+	defer cancel()
+
+	addr, err := newSRVAddrResolver(net.DefaultResolver, srvCacheTTL).resolve(ctx, inventoryAddr)
+	if err != nil {
+		return ValidationReport{}, err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, addr, nil)
+	if err != nil {
+		return ValidationReport{}, fmt.Errorf("error creating inventory request: %w", err)
+	}
+	httpheader.Apply(request, httpHeaders)
+	singleton.mu.Lock()
+	httpClient := singleton.httpClient
+	singleton.mu.Unlock()
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return ValidationReport{}, fmt.Errorf("error requesting inventory: %w", err)
+	}
+	defer func() {
+		if err := response.Body.Close(); err != nil {
+			log.Errorf("error closing hosts response body: %v", err)
+		}
+	}()
+	if response.StatusCode != http.StatusOK {
+		return ValidationReport{}, fmt.Errorf("error requesting inventory: unexpected status %v", response.Status)
+	}
+
+	return validateHosts(inventoryFormat, strict, bodylimit.NewReader(response.Body, maxResponseBytes))
+}
+
+// validateHosts reports how inventory data parses.
+func validateHosts(inventoryFormat string, strict bool, data io.Reader) (ValidationReport, error) {
+	hosts, decodeSkipErrs, err := decodeHosts(inventoryFormat, strict, data)
+	if err != nil {
+		return ValidationReport{Skipped: decodeSkipErrs}, err // nolint:exhaustivestruct
+	}
+	inventory, buildSkipErrs := buildInventory(hosts)
+
+	return ValidationReport{
+		IPHosts:   len(inventory.ipAddresses),
+		CIDRHosts: len(inventory.networkCIDRAddresses),
+		Skipped:   append(decodeSkipErrs, buildSkipErrs...),
+	}, nil
+}
@@ -0,0 +1,139 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inventory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_validateHosts(t *testing.T) {
+	tests := []struct {
+		name        string
+		format      string
+		data        string
+		wantIP      int
+		wantCIDR    int
+		wantSkipped int
+		wantErr     bool
+	}{
+		{
+			name:   "Usable IP and CIDR hosts",
+			format: fmtNDJSON,
+			data: `{"ip_address":"10.0.1.2","domain":"xyz.service.consul","hostgroup":"xyz"}
+{"ip_address":"10.3.0.0/16","domain":"","hostgroup":"network-xyz"}`,
+			wantIP:   1,
+			wantCIDR: 1,
+		},
+		{
+			name:   "Malformed, empty, and invalid CIDR entries are skipped",
+			format: fmtNDJSON,
+			data: `{"ip_address":"10.0.1.2","domain":"xyz.service.consul","hostgroup":"xyz"}
+{"ip_address":
+{"ip_address":"10.0.1.3","domain":"","hostgroup":""}
+{"ip_address":"10.3.0.0/33","domain":"","hostgroup":"network-xyz"}`,
+			wantIP:      1,
+			wantSkipped: 3,
+		},
+		{
+			name:        "No usable hosts",
+			format:      fmtArrayJSON,
+			data:        `[{"ip_address":"10.0.1.3","domain":"","hostgroup":""}]`,
+			wantSkipped: 1,
+		},
+		{
+			name:        "Unparsable payload",
+			format:      fmtArrayJSON,
+			data:        `{`,
+			wantSkipped: 1,
+			wantErr:     true,
+		},
+		{
+			name:    "Unsupported format",
+			format:  "yaml",
+			data:    `[]`,
+			wantErr: true,
+		},
+	}
+	for _, testcase := range tests {
+		t.Run(testcase.name, func(t *testing.T) {
+			got, err := validateHosts(testcase.format, false, strings.NewReader(testcase.data))
+			if (err != nil) != testcase.wantErr {
+				t.Fatalf("validateHosts() error = %v, wantErr %v", err, testcase.wantErr)
+			}
+			if got.IPHosts != testcase.wantIP || got.CIDRHosts != testcase.wantCIDR || len(got.Skipped) != testcase.wantSkipped {
+				t.Errorf("validateHosts() = {IPHosts: %v, CIDRHosts: %v, Skipped: %v}, want {%v, %v, %v skipped}",
+					got.IPHosts, got.CIDRHosts, got.Skipped, testcase.wantIP, testcase.wantCIDR, testcase.wantSkipped)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Tenant-ID") != "tenant-a" {
+			w.WriteHeader(http.StatusForbidden)
+
+			return
+		}
+		fmt.Fprint(w, `[{"ip_address":"10.0.1.2","domain":"xyz.service.consul","hostgroup":"xyz"},{"ip_address":"10.0.1.3"}]`)
+	}))
+	defer server.Close()
+
+	report, err := Validate(context.Background(), server.URL, http.Header{"X-Tenant-Id": {"tenant-a"}}, 0, fmtArrayJSON, false)
+	if err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	if report.UsableHosts() != 1 {
+		t.Errorf("Validate() usable hosts = %v, want 1", report.UsableHosts())
+	}
+	if len(report.Skipped) != 1 || !errors.Is(report.Skipped[0], ErrEmptyHostgroupAndDomain) {
+		t.Errorf("Validate() skipped = %v, want a single %v", report.Skipped, ErrEmptyHostgroupAndDomain)
+	}
+
+	if _, err := Validate(context.Background(), "", nil, 0, fmtArrayJSON, false); !errors.Is(err, ErrEmptyInventoryAddr) {
+		t.Errorf("Validate() error = %v, want %v", err, ErrEmptyInventoryAddr)
+	}
+}
+
+func TestValidate_httpHeadersRequired(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	if _, err := Validate(context.Background(), server.URL, nil, 0, fmtArrayJSON, false); err == nil {
+		t.Errorf("Validate() error = nil, want error on unexpected status")
+	}
+}
+
+func Test_validateHosts_strict(t *testing.T) {
+	data := `{"ip_address":"10.0.1.2","domain":"xyz.service.consul","hostgroup":"xyz","owner":"team-xyz"}`
+	for _, format := range []string{fmtNDJSON, fmtArrayJSON} {
+		raw := data
+		if format == fmtArrayJSON {
+			raw = "[" + data + "]"
+		}
+		_, err := validateHosts(format, true, strings.NewReader(raw))
+		if !errors.Is(err, ErrStrictInventory) {
+			t.Errorf("validateHosts(%v) error = %v, want %v", format, err, ErrStrictInventory)
+		}
+	}
+}
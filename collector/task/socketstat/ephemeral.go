@@ -0,0 +1,152 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package socketstat
+
+import (
+	"container/list"
+	"fmt"
+	"time"
+
+	"planet-exporter/pkg/network"
+)
+
+const (
+	// ephemeralEdgeTTL is how long a short-lived edge is exported since it was last seen,
+	// so scrapes in between collections still see it.
+	ephemeralEdgeTTL = 5 * time.Minute
+	// defaultEphemeralEdgeMaxEntries is the default maximum number of short-lived edges remembered.
+	defaultEphemeralEdgeMaxEntries = 1000
+)
+
+// ephemeralEdge is a dependency edge seen by a frequent poll.
+type ephemeralEdge struct {
+	connKey  connectionKey
+	conn     Connections
+	lastSeen time.Time
+}
+
+// ephemeralEdges remembers dependency edges of short-lived connections, which open and close in between
+// two collections and never appear in a snapshot. They are seen by frequent polls of the peered sockets,
+// classified with the listening ports of the latest collection. It's an LRU bounded by a maximum number of
+// entries, and entries not seen within ephemeralEdgeTTL are dropped. It's not safe for concurrent use,
+// the task's mutex protects it.
+type ephemeralEdges struct {
+	ttl        time.Duration
+	maxEntries int
+
+	// lru orders *ephemeralEdge from the most to the least recently seen
+	lru     *list.List
+	entries map[connectionKey]*list.Element
+
+	// previousSockets are the socket tuples of the previous poll, only new sockets are classified
+	previousSockets map[string]bool
+}
+
+// newEphemeralEdges returns an empty ephemeralEdges.
+func newEphemeralEdges(ttl time.Duration, maxEntries int) *ephemeralEdges {
+	return &ephemeralEdges{
+		ttl:             ttl,
+		maxEntries:      maxEntries,
+		lru:             list.New(),
+		entries:         make(map[connectionKey]*list.Element),
+		previousSockets: make(map[string]bool),
+	}
+}
+
+// newSockets returns the peered sockets that weren't in the previous poll, and remembers this poll's sockets.
+// Sockets that stay open across polls are classified once.
+func (e *ephemeralEdges) newSockets(peeredConns []network.PeeredConnSocket) []network.PeeredConnSocket {
+	var newConns []network.PeeredConnSocket
+	sockets := make(map[string]bool, len(peeredConns))
+	for _, peeredConn := range peeredConns {
+		socket := fmt.Sprintf("%v|%v:%v|%v:%v", peeredConn.Protocol, peeredConn.LocalIP, peeredConn.LocalPort, peeredConn.RemoteIP, peeredConn.RemotePort)
+		sockets[socket] = true
+		if !e.previousSockets[socket] {
+			newConns = append(newConns, peeredConn)
+		}
+	}
+	e.previousSockets = sockets
+
+	return newConns
+}
+
+// observe marks the upstream and downstream edges as seen at now, evicting the least recently seen edges
+// over the maximum number of entries.
+func (e *ephemeralEdges) observe(upstreams, downstreams []Connections, now time.Time) {
+	for _, conn := range upstreams {
+		e.add(upstreamConnectionKey(conn), conn, now)
+	}
+	for _, conn := range downstreams {
+		e.add(downstreamConnectionKey(conn), conn, now)
+	}
+
+	for e.lru.Len() > e.maxEntries {
+		e.remove(e.lru.Back())
+	}
+}
+
+// add marks an edge as seen at now.
+func (e *ephemeralEdges) add(connKey connectionKey, conn Connections, now time.Time) {
+	if elem, found := e.entries[connKey]; found {
+		edge := elem.Value.(*ephemeralEdge)
+		edge.conn = conn
+		edge.lastSeen = now
+		e.lru.MoveToFront(elem)
+
+		return
+	}
+	e.entries[connKey] = e.lru.PushFront(&ephemeralEdge{connKey: connKey, conn: conn, lastSeen: now})
+}
+
+// remove an edge from the LRU.
+func (e *ephemeralEdges) remove(elem *list.Element) {
+	edge := e.lru.Remove(elem).(*ephemeralEdge)
+	delete(e.entries, edge.connKey)
+}
+
+// merge appends the edges seen within the TTL that are missing from the upstreams and downstreams of a collection,
+// marked as ephemeral. Edges not seen within the TTL are dropped.
+func (e *ephemeralEdges) merge(upstreams, downstreams []Connections, now time.Time) ([]Connections, []Connections) {
+	for e.lru.Len() > 0 && now.Sub(e.lru.Back().Value.(*ephemeralEdge).lastSeen) > e.ttl {
+		e.remove(e.lru.Back())
+	}
+
+	collected := make(map[connectionKey]bool, len(upstreams)+len(downstreams))
+	for _, conn := range upstreams {
+		collected[upstreamConnectionKey(conn)] = true
+	}
+	for _, conn := range downstreams {
+		collected[downstreamConnectionKey(conn)] = true
+	}
+
+	// Oldest first, so the merged edges are in the order they were last seen
+	for elem := e.lru.Back(); elem != nil; elem = elem.Prev() {
+		edge := elem.Value.(*ephemeralEdge)
+		if collected[edge.connKey] {
+			continue
+		}
+		// The sockets of ephemeral edges are closed by the collection
+		conn := edge.conn
+		conn.Ephemeral = true
+		conn.Count = 0
+		if edge.connKey.direction == upstreamDirection {
+			upstreams = append(upstreams, conn)
+		} else {
+			downstreams = append(downstreams, conn)
+		}
+	}
+
+	return upstreams, downstreams
+}
@@ -0,0 +1,118 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package socketstat
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"planet-exporter/pkg/network"
+)
+
+func Test_observeEphemeral(t *testing.T) {
+	singleton.ephemeral = newEphemeralEdges(ephemeralEdgeTTL, 10)
+	singleton.localIP = "10.0.0.1"
+	singleton.listeningPortsConns = map[uint32]network.ListeningConnSocket{
+		80: {ProcessPid: 100, LocalPort: 80, LocalIP: "0.0.0.0", ProcessName: "nginx", ProcessStartTime: 500},
+	}
+	defer func() {
+		singleton.ephemeral = nil
+		singleton.localIP = ""
+		singleton.listeningPortsConns = nil
+	}()
+	lookup := mockInventoryLookup(map[string][2]string{
+		"10.0.0.1": {"local.service.consul", "local"},
+		"10.0.0.2": {"healthcheck.service.consul", "healthcheck"},
+		"10.0.0.3": {"db.service.consul", "db"},
+	})
+
+	// A health check and a cron job connect, and close before the next main tick
+	healthCheck := network.PeeredConnSocket{LocalIP: "10.0.0.1", LocalPort: 80, RemoteIP: "10.0.0.2", RemotePort: 41000, Protocol: "tcp", ProcessName: ""}
+	cronJob := network.PeeredConnSocket{LocalIP: "10.0.0.1", LocalPort: 42000, RemoteIP: "10.0.0.3", RemotePort: 5432, Protocol: "tcp", ProcessName: ""}
+	startTime := time.Date(2021, 6, 1, 10, 0, 0, 0, time.UTC)
+	observeEphemeral([]network.PeeredConnSocket{healthCheck}, lookup, lookup, mockServiceLookup(nil), startTime)
+	observeEphemeral([]network.PeeredConnSocket{healthCheck, cronJob}, lookup, lookup, mockServiceLookup(nil), startTime.Add(time.Second))
+	observeEphemeral(nil, lookup, lookup, mockServiceLookup(nil), startTime.Add(2*time.Second))
+
+	wantUpstreams := []Connections{{
+		LocalHostgroup: "local", LocalAddress: "local.service.consul", RemoteHostgroup: "db", RemoteAddress: "db.service.consul",
+		Port: "5432", Protocol: "tcp", ProcessName: "", EdgeScope: "", Ephemeral: true,
+	}}
+	wantDownstreams := []Connections{{
+		LocalHostgroup: "local", LocalAddress: "local.service.consul", RemoteHostgroup: "healthcheck", RemoteAddress: "healthcheck.service.consul",
+		Port: "80", Protocol: "tcp", ProcessName: "nginx", EdgeScope: "", Ephemeral: true,
+	}}
+	gotUpstreams, gotDownstreams := singleton.ephemeral.merge(nil, nil, startTime.Add(7*time.Second))
+	if !reflect.DeepEqual(gotUpstreams, wantUpstreams) {
+		t.Errorf("ephemeralEdges.merge() upstreams = %v, want %v", gotUpstreams, wantUpstreams)
+	}
+	if !reflect.DeepEqual(gotDownstreams, wantDownstreams) {
+		t.Errorf("ephemeralEdges.merge() downstreams = %v, want %v", gotDownstreams, wantDownstreams)
+	}
+
+	// Edges that are also in the main collection aren't duplicated
+	collected := wantUpstreams[0]
+	collected.Ephemeral = false
+	collected.ProcessName = "psql"
+	gotUpstreams, _ = singleton.ephemeral.merge([]Connections{collected}, nil, startTime.Add(14*time.Second))
+	if !reflect.DeepEqual(gotUpstreams, []Connections{collected}) {
+		t.Errorf("ephemeralEdges.merge() upstreams = %v, want %v", gotUpstreams, []Connections{collected})
+	}
+
+	// Edges not seen within the TTL are dropped
+	gotUpstreams, gotDownstreams = singleton.ephemeral.merge(nil, nil, startTime.Add(ephemeralEdgeTTL+2*time.Second))
+	if len(gotUpstreams) != 0 || len(gotDownstreams) != 0 {
+		t.Errorf("ephemeralEdges.merge() after TTL = %v, %v, want none", gotUpstreams, gotDownstreams)
+	}
+}
+
+func Test_ephemeralEdges_maxEntries(t *testing.T) {
+	edges := newEphemeralEdges(ephemeralEdgeTTL, 2)
+	now := time.Date(2021, 6, 1, 10, 0, 0, 0, time.UTC)
+	conn := func(port string) Connections {
+		return Connections{LocalHostgroup: "local", RemoteAddress: "10.0.0.2", Port: port, Protocol: "tcp"} // nolint:exhaustivestruct
+	}
+
+	edges.observe([]Connections{conn("1")}, nil, now)
+	edges.observe([]Connections{conn("2")}, nil, now.Add(time.Second))
+	// Seeing "1" again makes "2" the least recently seen edge
+	edges.observe([]Connections{conn("1")}, nil, now.Add(2*time.Second))
+	edges.observe([]Connections{conn("3")}, nil, now.Add(3*time.Second))
+
+	gotUpstreams, _ := edges.merge(nil, nil, now.Add(4*time.Second))
+	gotPorts := []string{}
+	for _, conn := range gotUpstreams {
+		gotPorts = append(gotPorts, conn.Port)
+	}
+	if want := []string{"1", "3"}; !reflect.DeepEqual(gotPorts, want) {
+		t.Errorf("ephemeralEdges.merge() ports = %v, want %v", gotPorts, want)
+	}
+}
+
+func Test_ephemeralEdges_newSockets(t *testing.T) {
+	edges := newEphemeralEdges(ephemeralEdgeTTL, 10)
+	a := network.PeeredConnSocket{LocalIP: "10.0.0.1", LocalPort: 42000, RemoteIP: "10.0.0.3", RemotePort: 5432, Protocol: "tcp", ProcessName: ""}
+	b := a
+	b.LocalPort = 42001
+
+	if got := edges.newSockets([]network.PeeredConnSocket{a}); !reflect.DeepEqual(got, []network.PeeredConnSocket{a}) {
+		t.Errorf("ephemeralEdges.newSockets() = %v, want %v", got, []network.PeeredConnSocket{a})
+	}
+	// Sockets that stay open aren't classified again
+	if got := edges.newSockets([]network.PeeredConnSocket{a, b}); !reflect.DeepEqual(got, []network.PeeredConnSocket{b}) {
+		t.Errorf("ephemeralEdges.newSockets() = %v, want %v", got, []network.PeeredConnSocket{b})
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package socketstat
+
+import (
+	"time"
+)
+
+// downstreamExpiry suppresses downstream dependencies that haven't had a new connection within the window.
+//
+// A downstream is last seen when one of its sockets shows up for the first time. Sockets that linger
+// across collections (e.g. an idle ESTABLISHED connection from hours ago) don't refresh it, so a client
+// that connected once doesn't keep reappearing as a downstream until its socket is fully gone.
+type downstreamExpiry struct {
+	// window is how long a downstream is kept since it was last seen, disabled if zero
+	window time.Duration
+
+	lastSeen map[connectionKey]time.Time
+	// sockets seen in the previous collection
+	sockets map[string]bool
+}
+
+// newDownstreamExpiry returns a downstreamExpiry with the given window, where 0 means no expiry.
+func newDownstreamExpiry(window time.Duration) *downstreamExpiry {
+	return &downstreamExpiry{
+		window:   window,
+		lastSeen: make(map[connectionKey]time.Time),
+		sockets:  make(map[string]bool),
+	}
+}
+
+// expire returns downstreams that were last seen within the window as of now.
+// The downstreamSockets contains socket IDs backing every downstream in the current collection.
+func (e *downstreamExpiry) expire(downstreams []Connections, downstreamSockets map[connectionKey][]string, now time.Time) []Connections {
+	if e.window <= 0 {
+		return downstreams
+	}
+
+	lastSeen := make(map[connectionKey]time.Time)
+	sockets := make(map[string]bool)
+
+	var active []Connections
+	for _, downstream := range downstreams {
+		connKey := downstreamConnectionKey(downstream)
+
+		seen, found := e.lastSeen[connKey]
+		for _, id := range downstreamSockets[connKey] {
+			sockets[id] = true
+			if !found || !e.sockets[id] {
+				seen = now
+			}
+		}
+		lastSeen[connKey] = seen
+
+		if now.Sub(seen) <= e.window {
+			active = append(active, downstream)
+		}
+	}
+
+	// Downstreams that are gone are forgotten, so they are fresh when a new connection comes again
+	e.lastSeen = lastSeen
+	e.sockets = sockets
+
+	return active
+}
@@ -0,0 +1,98 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package socketstat
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_downstreamExpiry_expire(t *testing.T) {
+	downstream := Connections{
+		LocalHostgroup: "local", LocalAddress: "local.service.consul",
+		RemoteHostgroup: "xyz", RemoteAddress: "xyz.service.consul",
+		Port: "80", Protocol: "tcp", ProcessName: "nginx",
+	}
+	connKey := downstreamConnectionKey(downstream)
+	startTime := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// collection is the downstream's sockets observed at a point in time (minutes since startTime)
+	type collection struct {
+		minute  int
+		sockets []string
+		want    []Connections
+	}
+	tests := []struct {
+		name        string
+		window      time.Duration
+		collections []collection
+	}{
+		{
+			name:   "No expiry",
+			window: 0,
+			collections: []collection{
+				{minute: 0, sockets: []string{"a"}, want: []Connections{downstream}},
+				{minute: 120, sockets: []string{"a"}, want: []Connections{downstream}},
+			},
+		},
+		{
+			name:   "Lingering socket expires after the window",
+			window: 30 * time.Minute,
+			collections: []collection{
+				{minute: 0, sockets: []string{"a"}, want: []Connections{downstream}},
+				{minute: 30, sockets: []string{"a"}, want: []Connections{downstream}},
+				{minute: 31, sockets: []string{"a"}, want: nil},
+			},
+		},
+		{
+			name:   "New socket refreshes the downstream",
+			window: 30 * time.Minute,
+			collections: []collection{
+				{minute: 0, sockets: []string{"a"}, want: []Connections{downstream}},
+				{minute: 20, sockets: []string{"a", "b"}, want: []Connections{downstream}},
+				{minute: 45, sockets: []string{"a", "b"}, want: []Connections{downstream}},
+				{minute: 51, sockets: []string{"b"}, want: nil},
+			},
+		},
+		{
+			name:   "Downstream that reappears after it's gone is fresh",
+			window: 30 * time.Minute,
+			collections: []collection{
+				{minute: 0, sockets: []string{"a"}, want: []Connections{downstream}},
+				{minute: 31, sockets: []string{"a"}, want: nil},
+				{minute: 32, sockets: nil, want: nil},
+				{minute: 33, sockets: []string{"a"}, want: []Connections{downstream}},
+			},
+		},
+	}
+	for _, testcase := range tests {
+		t.Run(testcase.name, func(t *testing.T) {
+			e := newDownstreamExpiry(testcase.window)
+			for _, c := range testcase.collections {
+				var downstreams []Connections
+				if len(c.sockets) > 0 {
+					downstreams = []Connections{downstream}
+				}
+				now := startTime.Add(time.Duration(c.minute) * time.Minute)
+
+				got := e.expire(downstreams, map[connectionKey][]string{connKey: c.sockets}, now)
+				if !reflect.DeepEqual(got, c.want) {
+					t.Errorf("downstreamExpiry.expire() at minute %v = %v, want %v", c.minute, got, c.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package socketstat
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultUpstreamConnectionsBuckets are the upper bounds of the upstream connections histogram buckets.
+const DefaultUpstreamConnectionsBuckets = "1,2,5,10,20,50,100,200,500,1000"
+
+// ErrInvalidBuckets histogram buckets aren't increasing finite numbers.
+var ErrInvalidBuckets = errors.New("invalid histogram buckets")
+
+// ParseBuckets parses comma-separated histogram bucket upper bounds (e.g. "1,5,10"), which must be increasing.
+func ParseBuckets(s string) ([]float64, error) {
+	var buckets []float64
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		bucket, err := strconv.ParseFloat(field, 64)
+		if err != nil || math.IsNaN(bucket) || math.IsInf(bucket, 0) {
+			return nil, fmt.Errorf("%w: %q isn't a finite number", ErrInvalidBuckets, field)
+		}
+		if len(buckets) > 0 && bucket <= buckets[len(buckets)-1] {
+			return nil, fmt.Errorf("%w: %v isn't greater than %v", ErrInvalidBuckets, bucket, buckets[len(buckets)-1])
+		}
+		buckets = append(buckets, bucket)
+	}
+	if len(buckets) == 0 {
+		return nil, fmt.Errorf("%w: no bucket in %q", ErrInvalidBuckets, s)
+	}
+
+	return buckets, nil
+}
+
+// UpstreamConnectionsHistogram is the histogram of an upstream's concurrent sockets, observed once per collection.
+type UpstreamConnectionsHistogram struct {
+	Upstream Connections
+	Count    uint64
+	Sum      float64
+	// Buckets are the cumulative observation counts of each bucket upper bound
+	Buckets map[float64]uint64
+}
+
+// upstreamConnectionsHistograms keeps a cumulative histogram per upstream tuple. It's not safe for concurrent use,
+// the task's mutex protects it.
+type upstreamConnectionsHistograms struct {
+	buckets []float64
+
+	series map[connectionKey]*UpstreamConnectionsHistogram
+}
+
+// newUpstreamConnectionsHistograms returns empty histograms with the bucket upper bounds.
+func newUpstreamConnectionsHistograms(buckets []float64) *upstreamConnectionsHistograms {
+	return &upstreamConnectionsHistograms{
+		buckets: buckets,
+		series:  make(map[connectionKey]*UpstreamConnectionsHistogram),
+	}
+}
+
+// observe the number of sockets of each upstream, skipping ephemeral upstreams which have no open socket anymore.
+// Histograms of upstreams that aren't remembered anymore are removed, so they're bounded like the edge history.
+func (h *upstreamConnectionsHistograms) observe(upstreams []Connections, remembered func(connectionKey) bool) {
+	for _, conn := range upstreams {
+		if conn.Ephemeral {
+			continue
+		}
+
+		connKey := upstreamConnectionKey(conn)
+		histogram, found := h.series[connKey]
+		if !found {
+			histogram = &UpstreamConnectionsHistogram{ // nolint:exhaustivestruct
+				Buckets: make(map[float64]uint64, len(h.buckets)),
+			}
+			for _, bucket := range h.buckets {
+				histogram.Buckets[bucket] = 0
+			}
+			h.series[connKey] = histogram
+		}
+
+		value := float64(conn.Count)
+		histogram.Upstream = conn
+		histogram.Count++
+		histogram.Sum += value
+		for _, bucket := range h.buckets {
+			if value <= bucket {
+				histogram.Buckets[bucket]++
+			}
+		}
+	}
+
+	for connKey := range h.series {
+		if !remembered(connKey) {
+			delete(h.series, connKey)
+		}
+	}
+}
+
+// get returns a copy of every histogram, sorted by upstream.
+func (h *upstreamConnectionsHistograms) get() []UpstreamConnectionsHistogram {
+	histograms := make([]UpstreamConnectionsHistogram, 0, len(h.series))
+	for _, histogram := range h.series {
+		buckets := make(map[float64]uint64, len(histogram.Buckets))
+		for bucket, count := range histogram.Buckets {
+			buckets[bucket] = count
+		}
+		histograms = append(histograms, UpstreamConnectionsHistogram{
+			Upstream: histogram.Upstream,
+			Count:    histogram.Count,
+			Sum:      histogram.Sum,
+			Buckets:  buckets,
+		})
+	}
+	sort.Slice(histograms, func(i, j int) bool {
+		return upstreamSortKey(histograms[i].Upstream) < upstreamSortKey(histograms[j].Upstream)
+	})
+
+	return histograms
+}
+
+// upstreamSortKey orders upstreams by their connection tuple.
+func upstreamSortKey(conn Connections) string {
+	return strings.Join([]string{conn.LocalHostgroup, conn.LocalAddress, conn.RemoteHostgroup, conn.RemoteAddress, conn.Port, conn.Protocol}, "|")
+}
@@ -0,0 +1,86 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package socketstat
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseBuckets(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		want    []float64
+		wantErr bool
+	}{
+		{name: "Increasing buckets", s: "1, 2.5,10", want: []float64{1, 2.5, 10}, wantErr: false},
+		{name: "Empty fields are skipped", s: "1,,5,", want: []float64{1, 5}, wantErr: false},
+		{name: "No bucket", s: "", want: nil, wantErr: true},
+		{name: "Not a number", s: "1,many", want: nil, wantErr: true},
+		{name: "Infinite bucket", s: "1,+Inf", want: nil, wantErr: true},
+		{name: "Decreasing buckets", s: "5,1", want: nil, wantErr: true},
+		{name: "Duplicate buckets", s: "1,1", want: nil, wantErr: true},
+	}
+	for _, testcase := range tests {
+		t.Run(testcase.name, func(t *testing.T) {
+			got, err := ParseBuckets(testcase.s)
+			if (err != nil) != testcase.wantErr {
+				t.Fatalf("ParseBuckets() error = %v, wantErr %v", err, testcase.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidBuckets) {
+				t.Errorf("ParseBuckets() error = %v, want %v", err, ErrInvalidBuckets)
+			}
+			if !reflect.DeepEqual(got, testcase.want) {
+				t.Errorf("ParseBuckets() = %v, want %v", got, testcase.want)
+			}
+		})
+	}
+}
+
+func Test_upstreamConnectionsHistograms(t *testing.T) {
+	db := Connections{LocalHostgroup: "local", RemoteHostgroup: "db", RemoteAddress: "db.service.consul", Port: "5432", Protocol: "tcp"}          // nolint:exhaustivestruct
+	cache := Connections{LocalHostgroup: "local", RemoteHostgroup: "cache", RemoteAddress: "cache.service.consul", Port: "6379", Protocol: "tcp"} // nolint:exhaustivestruct
+	withCount := func(conn Connections, count int) Connections {
+		conn.Count = count
+
+		return conn
+	}
+	rememberAll := func(connectionKey) bool { return true }
+
+	histograms := newUpstreamConnectionsHistograms([]float64{1, 5, 10})
+	histograms.observe([]Connections{withCount(db, 1), withCount(cache, 3)}, rememberAll)
+	histograms.observe([]Connections{withCount(db, 5), withCount(cache, 20)}, rememberAll)
+	histograms.observe([]Connections{withCount(db, 7)}, rememberAll)
+	// Ephemeral upstreams have no open socket, they aren't observed
+	ephemeral := withCount(db, 0)
+	ephemeral.Ephemeral = true
+	histograms.observe([]Connections{ephemeral}, rememberAll)
+
+	want := []UpstreamConnectionsHistogram{
+		{Upstream: withCount(cache, 20), Count: 2, Sum: 23, Buckets: map[float64]uint64{1: 0, 5: 1, 10: 1}},
+		{Upstream: withCount(db, 7), Count: 3, Sum: 13, Buckets: map[float64]uint64{1: 1, 5: 2, 10: 3}},
+	}
+	if got := histograms.get(); !reflect.DeepEqual(got, want) {
+		t.Errorf("upstreamConnectionsHistograms.get() = %+v, want %+v", got, want)
+	}
+
+	// Histograms of upstreams that aren't remembered anymore are removed
+	histograms.observe(nil, func(connKey connectionKey) bool { return connKey == upstreamConnectionKey(db) })
+	if got := histograms.get(); len(got) != 1 || got[0].Upstream.RemoteHostgroup != "db" {
+		t.Errorf("upstreamConnectionsHistograms.get() = %+v, want only the db upstream", got)
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package socketstat
+
+import (
+	"sort"
+	"time"
+)
+
+const (
+	// defaultEdgeHistoryTTL is how long an edge is remembered since it was last seen.
+	defaultEdgeHistoryTTL = 24 * time.Hour
+	// defaultEdgeHistoryMaxEntries is the maximum number of edges remembered.
+	defaultEdgeHistoryMaxEntries = 10000
+)
+
+// edgeTimestamps of a dependency edge.
+type edgeTimestamps struct {
+	firstSeen time.Time
+	lastSeen  time.Time
+}
+
+// edgeHistory remembers when each dependency edge was first and last seen across collections.
+// It's bounded by a TTL since the edge was last seen and a maximum number of entries. It's not safe for
+// concurrent use, the task's mutex protects it.
+type edgeHistory struct {
+	ttl        time.Duration
+	maxEntries int
+
+	entries map[connectionKey]edgeTimestamps
+}
+
+// newEdgeHistory returns an empty edgeHistory.
+func newEdgeHistory(ttl time.Duration, maxEntries int) *edgeHistory {
+	return &edgeHistory{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		entries:    make(map[connectionKey]edgeTimestamps),
+	}
+}
+
+// observe marks the edges as seen at now, then evicts edges not seen within the TTL and the least recently
+// seen edges over the maximum number of entries.
+func (h *edgeHistory) observe(connKeys []connectionKey, now time.Time) {
+	for _, connKey := range connKeys {
+		timestamps, found := h.entries[connKey]
+		if !found {
+			timestamps.firstSeen = now
+		}
+		timestamps.lastSeen = now
+		h.entries[connKey] = timestamps
+	}
+
+	for connKey, timestamps := range h.entries {
+		if now.Sub(timestamps.lastSeen) > h.ttl {
+			delete(h.entries, connKey)
+		}
+	}
+
+	if len(h.entries) <= h.maxEntries {
+		return
+	}
+
+	connKeysByLastSeen := make([]connectionKey, 0, len(h.entries))
+	for connKey := range h.entries {
+		connKeysByLastSeen = append(connKeysByLastSeen, connKey)
+	}
+	sort.Slice(connKeysByLastSeen, func(i, j int) bool {
+		return h.entries[connKeysByLastSeen[i]].lastSeen.Before(h.entries[connKeysByLastSeen[j]].lastSeen)
+	})
+	for _, connKey := range connKeysByLastSeen[:len(h.entries)-h.maxEntries] {
+		delete(h.entries, connKey)
+	}
+}
+
+// get returns the timestamps of an edge.
+func (h *edgeHistory) get(connKey connectionKey) (edgeTimestamps, bool) {
+	timestamps, found := h.entries[connKey]
+
+	return timestamps, found
+}
@@ -0,0 +1,116 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package socketstat
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_edgeHistory_observe(t *testing.T) {
+	edgeA := connectionKey{direction: upstreamDirection, remoteHostgroup: "a", remotePort: "80", protocol: "tcp"}
+	edgeB := connectionKey{direction: upstreamDirection, remoteHostgroup: "b", remotePort: "80", protocol: "tcp"}
+	edgeC := connectionKey{direction: downstreamDirection, remoteHostgroup: "c", localPort: "80", protocol: "tcp"}
+	startTime := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(minute int) time.Time {
+		return startTime.Add(time.Duration(minute) * time.Minute)
+	}
+
+	// observation is the edges seen in a collection at a point in time (minutes since startTime)
+	type observation struct {
+		minute int
+		edges  []connectionKey
+	}
+	tests := []struct {
+		name         string
+		ttl          time.Duration
+		maxEntries   int
+		observations []observation
+		want         map[connectionKey]edgeTimestamps
+	}{
+		{
+			name:       "First seen is kept while last seen is updated",
+			ttl:        time.Hour,
+			maxEntries: 10,
+			observations: []observation{
+				{minute: 0, edges: []connectionKey{edgeA}},
+				{minute: 10, edges: []connectionKey{edgeA, edgeB}},
+				{minute: 20, edges: []connectionKey{edgeA}},
+			},
+			want: map[connectionKey]edgeTimestamps{
+				edgeA: {firstSeen: at(0), lastSeen: at(20)},
+				edgeB: {firstSeen: at(10), lastSeen: at(10)},
+			},
+		},
+		{
+			name:       "Edges not seen within the TTL are evicted",
+			ttl:        30 * time.Minute,
+			maxEntries: 10,
+			observations: []observation{
+				{minute: 0, edges: []connectionKey{edgeA, edgeB}},
+				{minute: 20, edges: []connectionKey{edgeB}},
+				{minute: 31, edges: nil},
+			},
+			want: map[connectionKey]edgeTimestamps{
+				edgeB: {firstSeen: at(0), lastSeen: at(20)},
+			},
+		},
+		{
+			name:       "Evicted edge is first seen again when it comes back",
+			ttl:        30 * time.Minute,
+			maxEntries: 10,
+			observations: []observation{
+				{minute: 0, edges: []connectionKey{edgeA}},
+				{minute: 31, edges: nil},
+				{minute: 40, edges: []connectionKey{edgeA}},
+			},
+			want: map[connectionKey]edgeTimestamps{
+				edgeA: {firstSeen: at(40), lastSeen: at(40)},
+			},
+		},
+		{
+			name:       "Least recently seen edges over the max entries are evicted",
+			ttl:        time.Hour,
+			maxEntries: 2,
+			observations: []observation{
+				{minute: 0, edges: []connectionKey{edgeA}},
+				{minute: 1, edges: []connectionKey{edgeB}},
+				{minute: 2, edges: []connectionKey{edgeC}},
+			},
+			want: map[connectionKey]edgeTimestamps{
+				edgeB: {firstSeen: at(1), lastSeen: at(1)},
+				edgeC: {firstSeen: at(2), lastSeen: at(2)},
+			},
+		},
+	}
+	for _, testcase := range tests {
+		t.Run(testcase.name, func(t *testing.T) {
+			h := newEdgeHistory(testcase.ttl, testcase.maxEntries)
+			for _, o := range testcase.observations {
+				h.observe(o.edges, at(o.minute))
+			}
+
+			if len(h.entries) != len(testcase.want) {
+				t.Errorf("edgeHistory entries = %v, want %v", h.entries, testcase.want)
+			}
+			for connKey, want := range testcase.want {
+				got, found := h.get(connKey)
+				if !found || !got.firstSeen.Equal(want.firstSeen) || !got.lastSeen.Equal(want.lastSeen) {
+					t.Errorf("edgeHistory.get(%v) = %v, %v, want %v", connKey, got, found, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package socketstat
+
+import (
+	"sync"
+
+	"planet-exporter/collector/task/inventory"
+	"planet-exporter/pkg/network"
+)
+
+// defaultLookupWorkers is the default number of goroutines looking up peered connection addresses in the inventory.
+const defaultLookupWorkers = 1
+
+// hostLookupFunc returns the inventory host of the given address, and whether it was found.
+type hostLookupFunc func(address string) (inventory.Host, bool)
+
+// hostLookupResult is the result of a hostLookupFunc.
+type hostLookupResult struct {
+	host  inventory.Host
+	found bool
+}
+
+// prefetchHosts looks up the addresses once across up to workers goroutines, and returns a lookup of the results.
+// Addresses that weren't prefetched are looked up when they're asked for. Every result only depends on its address,
+// so the lookup returns the same results regardless of the number of workers.
+func prefetchHosts(addresses []string, getHost hostLookupFunc, workers int) hostLookupFunc {
+	if len(addresses) == 0 {
+		return getHost
+	}
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > len(addresses) {
+		workers = len(addresses)
+	}
+
+	// Worker w looks up every workers-th address starting at w, and writes to their own results, so they don't need a lock
+	results := make([]hostLookupResult, len(addresses))
+	var waitGroup sync.WaitGroup
+	waitGroup.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func(w int) {
+			defer waitGroup.Done()
+			for i := w; i < len(addresses); i += workers {
+				host, found := getHost(addresses[i])
+				results[i] = hostLookupResult{host: host, found: found}
+			}
+		}(w)
+	}
+	waitGroup.Wait()
+
+	hosts := make(map[string]hostLookupResult, len(addresses))
+	for i, address := range addresses {
+		hosts[address] = results[i]
+	}
+
+	return func(address string) (inventory.Host, bool) {
+		if result, ok := hosts[address]; ok {
+			return result.host, result.found
+		}
+
+		return getHost(address)
+	}
+}
+
+// peeredConnAddresses returns the distinct local and remote addresses of the peered connections,
+// as classifyConnections looks them up.
+func peeredConnAddresses(peeredConns []network.PeeredConnSocket, localIP string) ([]string, []string) {
+	var localAddresses, remoteAddresses []string
+	seenLocal := make(map[string]bool)
+	seenRemote := make(map[string]bool)
+	for _, peeredConn := range peeredConns {
+		local := network.NormalizeIP(peeredConn.LocalIP)
+		if local == "127.0.0.1" {
+			local = localIP
+		}
+		if !seenLocal[local] {
+			seenLocal[local] = true
+			localAddresses = append(localAddresses, local)
+		}
+
+		remote := network.NormalizeIP(peeredConn.RemoteIP)
+		if !seenRemote[remote] {
+			seenRemote[remote] = true
+			remoteAddresses = append(remoteAddresses, remote)
+		}
+	}
+
+	return localAddresses, remoteAddresses
+}
@@ -0,0 +1,125 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package socketstat
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"testing"
+
+	"planet-exporter/collector/task/inventory"
+	"planet-exporter/pkg/network"
+)
+
+// mockCIDRHostLookup returns a hostLookupFunc that linearly scans networks /24 networks of 10.0.0.0/8,
+// like an inventory of many CIDR entries.
+func mockCIDRHostLookup(networks int) hostLookupFunc {
+	cidrs := make([]*net.IPNet, 0, networks)
+	for i := 0; i < networks; i++ {
+		_, cidr, _ := net.ParseCIDR(fmt.Sprintf("10.%v.%v.0/24", i/256%256, i%256))
+		cidrs = append(cidrs, cidr)
+	}
+
+	return func(address string) (inventory.Host, bool) {
+		ip := net.ParseIP(address)
+		for _, cidr := range cidrs {
+			if cidr.Contains(ip) {
+				return inventory.Host{IPAddress: cidr.String(), Domain: cidr.String() + ".example", Hostgroup: "hg-" + cidr.String()}, true // nolint:exhaustivestruct
+			}
+		}
+
+		return inventory.Host{}, false // nolint:exhaustivestruct
+	}
+}
+
+// mockPeeredConns returns n upstream connections to distinct remote addresses of 10.0.0.0/8.
+func mockPeeredConns(n int) []network.PeeredConnSocket {
+	peeredConns := make([]network.PeeredConnSocket, 0, n)
+	for i := 0; i < n; i++ {
+		peeredConns = append(peeredConns, network.PeeredConnSocket{
+			LocalIP: "192.168.0.1", LocalPort: uint32(40000 + i%20000), RemoteIP: fmt.Sprintf("10.%v.%v.%v", i/65536%256, i/256%256, i%256),
+			RemotePort: 443, Protocol: "tcp", ProcessName: "app",
+		})
+	}
+
+	return peeredConns
+}
+
+// classifyWithWorkers classifies the peered connections, looking up their addresses across workers goroutines.
+func classifyWithWorkers(peeredConns []network.PeeredConnSocket, getHost hostLookupFunc, workers int) ([]Connections, []Connections) {
+	localAddresses, remoteAddresses := peeredConnAddresses(peeredConns, "192.168.0.1")
+	getLocalHost := prefetchHosts(localAddresses, getHost, workers)
+	getRemoteHost := prefetchHosts(remoteAddresses, getHost, workers)
+	localLookup := func(targetIP string) (string, string) { return getInventoryAddrAndHostgroup(getLocalHost, targetIP) }
+	remoteLookup := func(targetIP string) (string, string) { return getInventoryAddrAndHostgroup(getRemoteHost, targetIP) }
+	upstreams, downstreams, _ := classifyConnections(peeredConns, nil, "192.168.0.1", nil, localLookup, remoteLookup, mockServiceLookup(nil))
+
+	return upstreams, downstreams
+}
+
+func Test_prefetchHosts(t *testing.T) {
+	getHost := mockCIDRHostLookup(512)
+	peeredConns := mockPeeredConns(2000)
+	// Duplicate addresses and a loopback local address are looked up once
+	peeredConns = append(peeredConns, peeredConns[0])
+	peeredConns[1].LocalIP = "127.0.0.1"
+
+	wantUpstreams, wantDownstreams := classifyWithWorkers(peeredConns, getHost, 1)
+	for _, workers := range []int{2, 8, 5000} {
+		gotUpstreams, gotDownstreams := classifyWithWorkers(peeredConns, getHost, workers)
+		if !reflect.DeepEqual(gotUpstreams, wantUpstreams) {
+			t.Errorf("classifyConnections() with %v workers upstreams differ from a single worker", workers)
+		}
+		if !reflect.DeepEqual(gotDownstreams, wantDownstreams) {
+			t.Errorf("classifyConnections() with %v workers downstreams differ from a single worker", workers)
+		}
+	}
+
+	// Addresses that weren't prefetched are still looked up
+	lookup := prefetchHosts([]string{"10.0.0.1"}, getHost, 4)
+	if got, found := lookup("10.0.1.1"); !found || got.Hostgroup != "hg-10.0.1.0/24" {
+		t.Errorf("prefetchHosts() lookup = %v, %v, want hostgroup %v", got, found, "hg-10.0.1.0/24")
+	}
+}
+
+func Test_peeredConnAddresses(t *testing.T) {
+	peeredConns := []network.PeeredConnSocket{
+		{LocalIP: "10.0.0.1", RemoteIP: "10.0.0.2"},         // nolint:exhaustivestruct
+		{LocalIP: "127.0.0.1", RemoteIP: "::ffff:10.0.0.2"}, // nolint:exhaustivestruct
+		{LocalIP: "10.0.0.1", RemoteIP: "10.0.0.3"},         // nolint:exhaustivestruct
+	}
+	gotLocal, gotRemote := peeredConnAddresses(peeredConns, "10.0.0.9")
+	if want := []string{"10.0.0.1", "10.0.0.9"}; !reflect.DeepEqual(gotLocal, want) {
+		t.Errorf("peeredConnAddresses() local = %v, want %v", gotLocal, want)
+	}
+	if want := []string{"10.0.0.2", "10.0.0.3"}; !reflect.DeepEqual(gotRemote, want) {
+		t.Errorf("peeredConnAddresses() remote = %v, want %v", gotRemote, want)
+	}
+}
+
+// BenchmarkClassifyConnections classifies 5000 connections against an inventory of 4096 CIDR entries.
+func BenchmarkClassifyConnections(b *testing.B) {
+	getHost := mockCIDRHostLookup(4096)
+	peeredConns := mockPeeredConns(5000)
+
+	for _, workers := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("workers=%v", workers), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				classifyWithWorkers(peeredConns, getHost, workers)
+			}
+		})
+	}
+}
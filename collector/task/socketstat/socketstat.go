@@ -16,19 +16,67 @@ package socketstat
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"hash/fnv"
+	"math"
+	"net"
 	"sync"
 	"time"
 
 	"planet-exporter/collector/task/inventory"
 	"planet-exporter/pkg/network"
+	"planet-exporter/pkg/ratelog"
 
 	log "github.com/sirupsen/logrus"
 )
 
+// DefaultProcessScanTimeout is the default timeout of the process table scan of a collection.
+const DefaultProcessScanTimeout = 5 * time.Second
+
+// serverConnections, localIP, and socketCounters of a collection, replaced in tests.
+var (
+	serverConnections = network.ServerConnections
+	localIP           = network.LocalIP
+	socketCounters    = network.ReadTCPSocketCounters
+)
+
+// socketCountersErrLog rate-limits the warnings of socket byte counters that can't be read on every collection.
+var socketCountersErrLog = ratelog.New(ratelog.DefaultInterval)
+
 // task that queries local socket info and aggregates them into usable planet metrics.
 type task struct {
 	enabled bool
+	// processScanTimeout of the process table scan naming the process of each connection
+	processScanTimeout time.Duration
+	// processScanTruncated is whether the process table scan of the latest collection exceeded processScanTimeout,
+	// so the connections of the processes that weren't scanned have no process name, protected by mu
+	processScanTruncated bool
+	// sampleRate is the fraction (0.0-1.0) of dependency connections to keep
+	sampleRate float64
+	// downstreamExpiry suppresses downstreams without a new connection within its window
+	downstreamExpiry *downstreamExpiry
+	// history remembers when dependency edges were first and last seen, protected by mu
+	history *edgeHistory
+	// internalCIDRs decide the edge scope of dependency connections, protected by mu
+	internalCIDRs []*net.IPNet
+	// ephemeral remembers edges of short-lived connections seen by CollectEphemeral, nil if disabled, protected by mu
+	ephemeral *ephemeralEdges
+	// localIP and listeningPortsConns of the latest collection classify the sockets seen by CollectEphemeral, protected by mu
+	localIP             string
+	listeningPortsConns map[uint32]network.ListeningConnSocket
+	// lookupWorkers are the goroutines looking up peered connection addresses in the inventory, protected by mu
+	lookupWorkers int
+	// unixSocketListenersEnabled collects the Unix socket listeners, protected by mu
+	unixSocketListenersEnabled bool
+	// dropTimeWaitOnly drops dependencies whose sockets are all in TIME_WAIT, protected by mu
+	dropTimeWaitOnly bool
+	// upstreamConnections histograms of the sockets per upstream, nil if disabled, protected by mu
+	upstreamConnections *upstreamConnectionsHistograms
+	// traffic sums the byte counters of the sockets on the traffic ports, nil if disabled, protected by mu
+	traffic *socketTraffic
+
+	unixSocketListeners []UnixSocketListener
 
 	serverProcesses []Process
 	upstreams       []Connections
@@ -40,17 +88,157 @@ var singleton task
 
 func init() {
 	singleton = task{
-		serverProcesses: []Process{},
-		upstreams:       []Connections{},
-		downstreams:     []Connections{},
-		enabled:         false,
-		mu:              sync.Mutex{},
+		serverProcesses:      []Process{},
+		upstreams:            []Connections{},
+		downstreams:          []Connections{},
+		enabled:              false,
+		processScanTimeout:   DefaultProcessScanTimeout,
+		processScanTruncated: false,
+		sampleRate:           1,
+		downstreamExpiry:     newDownstreamExpiry(0),
+		history:              newEdgeHistory(defaultEdgeHistoryTTL, defaultEdgeHistoryMaxEntries),
+		internalCIDRs:        nil,
+		ephemeral:            nil,
+		upstreamConnections:  nil,
+		traffic:              nil,
+		dropTimeWaitOnly:     false,
+		lookupWorkers:        defaultLookupWorkers,
+		mu:                   sync.Mutex{},
+
+		unixSocketListenersEnabled: false,
+		unixSocketListeners:        []UnixSocketListener{},
 	}
 }
 
 // InitTask initial states.
-func InitTask(ctx context.Context, enabled bool) {
+// The sampleRate (0.0-1.0) deterministically samples dependency connections, where 1.0 means no sampling.
+// Downstreams without a new connection within the downstreamExpiryWindow are suppressed, where 0 means no expiry.
+// The first and last seen time of dependency edges are remembered for historyTTL since last seen, up to historyMaxEntries edges.
+// A process table scan taking longer than processScanTimeout leaves the connections of the processes that weren't
+// scanned without a process name. It doesn't bound reading the connections themselves.
+func InitTask(ctx context.Context, enabled bool, sampleRate float64, downstreamExpiryWindow time.Duration,
+	historyTTL time.Duration, historyMaxEntries int, processScanTimeout time.Duration) {
+	if sampleRate < 0 || sampleRate > 1 || math.IsNaN(sampleRate) {
+		log.Warningf("Invalid socketstat sample rate '%v', fallback to no sampling", sampleRate)
+		sampleRate = 1
+	}
+
+	if historyTTL <= 0 {
+		log.Warningf("Invalid socketstat edge history TTL '%v', fallback to %v", historyTTL, defaultEdgeHistoryTTL)
+		historyTTL = defaultEdgeHistoryTTL
+	}
+	if historyMaxEntries <= 0 {
+		log.Warningf("Invalid socketstat edge history max entries '%v', fallback to %v", historyMaxEntries, defaultEdgeHistoryMaxEntries)
+		historyMaxEntries = defaultEdgeHistoryMaxEntries
+	}
+
+	if processScanTimeout <= 0 {
+		log.Warningf("Invalid socketstat process scan timeout '%v', fallback to %v", processScanTimeout, DefaultProcessScanTimeout)
+		processScanTimeout = DefaultProcessScanTimeout
+	}
+
 	singleton.enabled = enabled
+	singleton.processScanTimeout = processScanTimeout
+	singleton.sampleRate = sampleRate
+	singleton.downstreamExpiry = newDownstreamExpiry(downstreamExpiryWindow)
+
+	singleton.mu.Lock()
+	singleton.history = newEdgeHistory(historyTTL, historyMaxEntries)
+	singleton.mu.Unlock()
+}
+
+// SetInternalCIDRs sets the networks of remote addresses whose dependency connections are internal edges,
+// the rest are external. The edge scope is left empty when there are no internal networks.
+func SetInternalCIDRs(internalCIDRs []*net.IPNet) {
+	singleton.mu.Lock()
+	singleton.internalCIDRs = internalCIDRs
+	singleton.mu.Unlock()
+}
+
+// SetEphemeralEdges enables remembering edges of short-lived connections seen by CollectEphemeral, up to maxEntries edges.
+func SetEphemeralEdges(maxEntries int) {
+	if maxEntries <= 0 {
+		log.Warningf("Invalid socketstat ephemeral edge max entries '%v', fallback to %v", maxEntries, defaultEphemeralEdgeMaxEntries)
+		maxEntries = defaultEphemeralEdgeMaxEntries
+	}
+
+	singleton.mu.Lock()
+	singleton.ephemeral = newEphemeralEdges(ephemeralEdgeTTL, maxEntries)
+	singleton.mu.Unlock()
+}
+
+// SetLookupWorkers sets the number of goroutines looking up the addresses of peered connections in the inventory,
+// which speeds up collections of many connections against a large inventory.
+func SetLookupWorkers(workers int) {
+	if workers <= 0 {
+		log.Warningf("Invalid socketstat lookup workers '%v', fallback to %v", workers, defaultLookupWorkers)
+		workers = defaultLookupWorkers
+	}
+
+	singleton.mu.Lock()
+	singleton.lookupWorkers = workers
+	singleton.mu.Unlock()
+}
+
+// SetUnixSocketListeners sets whether the processes listening on Unix sockets are collected.
+// They're never part of the dependencies, which only come from IP sockets.
+func SetUnixSocketListeners(enabled bool) {
+	singleton.mu.Lock()
+	singleton.unixSocketListenersEnabled = enabled
+	singleton.mu.Unlock()
+}
+
+// SetDropTimeWaitOnly sets whether dependencies whose sockets are all in TIME_WAIT are dropped. Those sockets linger
+// for a while after their connection is closed, so a one-off connection would otherwise be a dependency for many
+// collections, without its process name.
+func SetDropTimeWaitOnly(drop bool) {
+	singleton.mu.Lock()
+	singleton.dropTimeWaitOnly = drop
+	singleton.mu.Unlock()
+}
+
+// SetUpstreamConnectionsHistogram enables the histogram of the sockets per upstream with the bucket upper bounds,
+// observed once per collection. A nil buckets disables it.
+func SetUpstreamConnectionsHistogram(buckets []float64) {
+	singleton.mu.Lock()
+	defer singleton.mu.Unlock()
+
+	singleton.upstreamConnections = nil
+	if buckets != nil {
+		singleton.upstreamConnections = newUpstreamConnectionsHistograms(buckets)
+	}
+}
+
+// SetTrafficPorts enables summing the kernel byte counters of the TCP sockets on the ports into traffic per remote
+// hostgroup, port, and direction: sockets to the ports of upstreams, and sockets accepted on this machine's listening
+// ports. A nil ports disables it. It needs socket byte counters (Linux 4.1 or later), collections only warn when
+// they can't be read.
+func SetTrafficPorts(ports []uint32) {
+	singleton.mu.Lock()
+	defer singleton.mu.Unlock()
+
+	singleton.traffic = nil
+	if ports != nil {
+		singleton.traffic = newSocketTraffic(ports)
+	}
+}
+
+// Edge scopes of dependency connections, whether the remote address crosses the internal networks boundary.
+const (
+	EdgeScopeInternal = "internal"
+	EdgeScopeExternal = "external"
+)
+
+// edgeScope returns the edge scope of a remote IP, empty if there are no internal networks.
+func edgeScope(internalCIDRs []*net.IPNet, remoteIP string) string {
+	if len(internalCIDRs) == 0 {
+		return ""
+	}
+	if network.ContainsIP(internalCIDRs, remoteIP) {
+		return EdgeScopeInternal
+	}
+
+	return EdgeScopeExternal
 }
 
 // Process that binds on one or more network interfaces.
@@ -60,15 +248,123 @@ type Process struct {
 	Port string // e.g. "9100"
 }
 
+// UnixSocketListener is a process that binds on a Unix socket.
+type UnixSocketListener struct {
+	Path        string // e.g. "/run/docker.sock", or "@name" for an abstract socket
+	ProcessName string // e.g. "dockerd"
+}
+
 // Connections socket connection metrics.
 type Connections struct {
-	LocalHostgroup  string
-	LocalAddress    string
-	RemoteHostgroup string
-	RemoteAddress   string
-	Port            string
-	Protocol        string // tcp/udp
-	ProcessName     string
+	LocalHostgroup    string
+	LocalAddress      string
+	RemoteHostgroup   string
+	RemoteAddress     string
+	Port              string
+	Protocol          string // tcp/udp
+	ProcessName       string
+	EdgeScope         string // internal/external, empty if there are no internal networks
+	Ephemeral         bool   // short-lived connection that was only seen by CollectEphemeral
+	RemoteServiceName string // service of an upstream's remote port from the inventory (e.g. mysql), empty if unknown
+	Count             int    // number of sockets of the connection tuple
+	TimeWaitOnly      bool   // every socket of the connection tuple is in TIME_WAIT, the connections are closed
+}
+
+// Dependency is an upstream/downstream connection along with when it was first and last seen.
+type Dependency struct {
+	Direction         string    `json:"direction"`
+	LocalHostgroup    string    `json:"local_hostgroup"`
+	LocalAddress      string    `json:"local_address"`
+	RemoteHostgroup   string    `json:"remote_hostgroup"`
+	RemoteAddress     string    `json:"remote_address"`
+	Port              string    `json:"port"`
+	Protocol          string    `json:"protocol"`
+	ProcessName       string    `json:"process_name"`
+	EdgeScope         string    `json:"edge_scope,omitempty"`
+	Ephemeral         bool      `json:"ephemeral,omitempty"`
+	RemoteServiceName string    `json:"remote_service_name,omitempty"`
+	FirstSeen         time.Time `json:"first_seen"`
+	LastSeen          time.Time `json:"last_seen"`
+}
+
+// GetDependencies returns latest upstream and downstream connections from singleton, with their first and last seen time.
+func GetDependencies() []Dependency {
+	singleton.mu.Lock()
+	defer singleton.mu.Unlock()
+
+	dependencies := []Dependency{}
+	for _, conn := range singleton.upstreams {
+		dependencies = append(dependencies, newDependency(upstreamDirection, conn, upstreamConnectionKey(conn)))
+	}
+	for _, conn := range singleton.downstreams {
+		dependencies = append(dependencies, newDependency(downstreamDirection, conn, downstreamConnectionKey(conn)))
+	}
+
+	return dependencies
+}
+
+// newDependency returns a Dependency of the connection, caller must hold the singleton lock.
+func newDependency(direction string, conn Connections, connKey connectionKey) Dependency {
+	timestamps, _ := singleton.history.get(connKey)
+
+	return Dependency{
+		Direction:         direction,
+		LocalHostgroup:    conn.LocalHostgroup,
+		LocalAddress:      conn.LocalAddress,
+		RemoteHostgroup:   conn.RemoteHostgroup,
+		RemoteAddress:     conn.RemoteAddress,
+		Port:              conn.Port,
+		Protocol:          conn.Protocol,
+		ProcessName:       conn.ProcessName,
+		EdgeScope:         conn.EdgeScope,
+		Ephemeral:         conn.Ephemeral,
+		RemoteServiceName: conn.RemoteServiceName,
+		FirstSeen:         timestamps.firstSeen,
+		LastSeen:          timestamps.lastSeen,
+	}
+}
+
+// Stats of the latest collection.
+type Stats struct {
+	// ProcessScanTruncated is whether the process table scan exceeded its timeout, so the connections of the processes
+	// that weren't scanned have no process name
+	ProcessScanTruncated bool
+}
+
+// GetStats returns the stats of the latest collection from singleton.
+func GetStats() Stats {
+	singleton.mu.Lock()
+	defer singleton.mu.Unlock()
+
+	return Stats{
+		ProcessScanTruncated: singleton.processScanTruncated,
+	}
+}
+
+// GetUpstreamConnectionsHistograms returns the histograms of the sockets per upstream from singleton,
+// empty unless they're enabled.
+func GetUpstreamConnectionsHistograms() []UpstreamConnectionsHistogram {
+	singleton.mu.Lock()
+	defer singleton.mu.Unlock()
+
+	if singleton.upstreamConnections == nil {
+		return []UpstreamConnectionsHistogram{}
+	}
+
+	return singleton.upstreamConnections.get()
+}
+
+// GetTraffic returns the running traffic totals of the sockets on the traffic ports from singleton,
+// empty unless they're enabled.
+func GetTraffic() []Traffic {
+	singleton.mu.Lock()
+	defer singleton.mu.Unlock()
+
+	if singleton.traffic == nil {
+		return []Traffic{}
+	}
+
+	return singleton.traffic.get()
 }
 
 // Get returns latest metrics from singleton.
@@ -82,8 +378,15 @@ func Get() ([]Process, []Connections, []Connections) {
 	return serverProcesses, up, down
 }
 
+// GetUnixSocketListeners returns the latest Unix socket listeners from singleton, empty unless they're enabled.
+func GetUnixSocketListeners() []UnixSocketListener {
+	singleton.mu.Lock()
+	defer singleton.mu.Unlock()
+
+	return singleton.unixSocketListeners
+}
+
 // Collect will collect fill singleton with latest data.
-// nolint:cyclop
 func Collect(ctx context.Context) error {
 	if !singleton.enabled {
 		return nil
@@ -91,40 +394,296 @@ func Collect(ctx context.Context) error {
 
 	startTime := time.Now()
 
-	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	processScanCtx, cancel := context.WithTimeout(ctx, singleton.processScanTimeout)
 	defer cancel()
 
-	// Get server connection stat
-	serverConnectionStat, err := network.ServerConnections(ctx)
-	if err != nil {
+	// Get server connection stat, the connections are read in full even when the process scan timeout is exceeded
+	serverConnectionStat, err := serverConnections(processScanCtx)
+	processScanTruncated := errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil
+	if err != nil && !processScanTruncated {
 		return fmt.Errorf("error getting server connections: %w", err)
 	}
+	if processScanTruncated {
+		log.Warnf("Socketstat process scan exceeded its %v timeout, keep the connections of the processes that weren't scanned without a process name: %v",
+			singleton.processScanTimeout, err)
+	}
 	serverProcesses, listeningPortsConns := parseProcessesAndListenPortsConns(serverConnectionStat)
 
 	// Find current IP to replace loop-back address
-	currentIP, err := network.LocalIP()
+	currentIP, err := localIP()
 	if err != nil {
 		return fmt.Errorf("error getting local IP address: %w", err)
 	}
 
 	// Upstreams and downstreams from every peered connection sockets (e.g. "ss -pant")
+	singleton.mu.Lock()
+	internalCIDRs, lookupWorkers, dropTimeWaitOnly := singleton.internalCIDRs, singleton.lookupWorkers, singleton.dropTimeWaitOnly
+	trafficEnabled := singleton.traffic != nil
+	singleton.mu.Unlock()
+	localLookup, remoteLookup, serviceLookup := inventoryLookups(serverConnectionStat.PeeredConnSockets, currentIP.String(), lookupWorkers)
+	upstreams, downstreams, downstreamSockets := classifyConnections(serverConnectionStat.PeeredConnSockets, listeningPortsConns,
+		currentIP.String(), internalCIDRs, localLookup, remoteLookup, serviceLookup)
+	if dropTimeWaitOnly {
+		upstreams, downstreams = withoutTimeWaitOnly(upstreams), withoutTimeWaitOnly(downstreams)
+	}
+	downstreams = singleton.downstreamExpiry.expire(downstreams, downstreamSockets, time.Now())
+
+	// Byte counters of the sockets on the traffic ports, the dependencies are still collected without them
+	var trafficSockets []network.TCPSocketCounters
+	trafficRead := false
+	if trafficEnabled {
+		trafficSockets, err = socketCounters(ctx)
+		if err != nil {
+			socketCountersErrLog.Warnf("Failed to read socket byte counters, socketstat traffic isn't updated: %v", err)
+		} else {
+			socketCountersErrLog.Reset()
+			trafficRead = true
+		}
+	}
+
+	singleton.mu.Lock()
+	defer singleton.mu.Unlock()
+
+	singleton.localIP = currentIP.String()
+	singleton.listeningPortsConns = listeningPortsConns
+	if singleton.ephemeral != nil {
+		upstreams, downstreams = singleton.ephemeral.merge(upstreams, downstreams, time.Now())
+	}
+	upstreams = sampleConnections(upstreams, upstreamDirection, singleton.sampleRate)
+	downstreams = sampleConnections(downstreams, downstreamDirection, singleton.sampleRate)
+
+	connKeys := make([]connectionKey, 0, len(upstreams)+len(downstreams))
+	for _, conn := range upstreams {
+		connKeys = append(connKeys, upstreamConnectionKey(conn))
+	}
+	for _, conn := range downstreams {
+		connKeys = append(connKeys, downstreamConnectionKey(conn))
+	}
+
+	singleton.processScanTruncated = processScanTruncated
+	singleton.serverProcesses = serverProcesses
+	singleton.unixSocketListeners = []UnixSocketListener{}
+	if singleton.unixSocketListenersEnabled {
+		singleton.unixSocketListeners = parseUnixSocketListeners(serverConnectionStat)
+	}
+	singleton.upstreams = upstreams
+	singleton.downstreams = downstreams
+	singleton.history.observe(connKeys, time.Now())
+	if singleton.traffic != nil && trafficRead {
+		singleton.traffic.observe(trafficSockets, listeningPortsConns, currentIP.String(), localLookup, remoteLookup)
+	}
+	if singleton.upstreamConnections != nil {
+		singleton.upstreamConnections.observe(upstreams, func(connKey connectionKey) bool {
+			_, found := singleton.history.get(connKey)
+
+			return found
+		})
+	}
+
+	log.Debugf("tasksocketstat.Collect retrieved %v upstreams metrics", len(upstreams))
+	log.Debugf("tasksocketstat.Collect retrieved %v downstreams metrics", len(downstreams))
+	log.Debugf("tasksocketstat.Collect process took %v", time.Since(startTime))
+
+	return nil
+}
+
+// CollectEphemeral polls the peered sockets to remember the edges of short-lived connections, which open and close
+// in between two Collect calls. The next Collect exports them as ephemeral upstreams and downstreams.
+// It's cheap enough to be called frequently: it only reads the kernel socket tables, reuses the listening ports
+// of the latest Collect, and only classifies the sockets that weren't in the previous poll.
+func CollectEphemeral(ctx context.Context) error {
+	singleton.mu.Lock()
+	ready := singleton.enabled && singleton.ephemeral != nil && singleton.listeningPortsConns != nil
+	singleton.mu.Unlock()
+	if !ready {
+		return nil
+	}
+
+	peeredConns, err := network.PeeredConnections(ctx)
+	if err != nil {
+		return fmt.Errorf("error getting peered connections: %w", err)
+	}
+
+	// Polls only classify a few new sockets, they aren't worth looking up in advance
+	localLookup, remoteLookup, serviceLookup := inventoryLookups(nil, "", 1)
+
+	singleton.mu.Lock()
+	defer singleton.mu.Unlock()
+
+	observeEphemeral(peeredConns, localLookup, remoteLookup, serviceLookup, time.Now())
+
+	return nil
+}
+
+// observeEphemeral classifies the new peered sockets since the previous poll and remembers their edges,
+// caller must hold the singleton lock.
+func observeEphemeral(peeredConns []network.PeeredConnSocket, localLookup, remoteLookup inventoryLookupFunc,
+	serviceLookup serviceLookupFunc, now time.Time) {
+	newConns := singleton.ephemeral.newSockets(peeredConns)
+	upstreams, downstreams, _ := classifyConnections(newConns, singleton.listeningPortsConns, singleton.localIP, singleton.internalCIDRs,
+		localLookup, remoteLookup, serviceLookup)
+	if singleton.dropTimeWaitOnly {
+		upstreams, downstreams = withoutTimeWaitOnly(upstreams), withoutTimeWaitOnly(downstreams)
+	}
+	singleton.ephemeral.observe(upstreams, downstreams, now)
+}
+
+// inventoryLookups returns the local and remote address lookups, and the service name lookup of the latest inventory.
+// The addresses of peeredConns are looked up in advance across up to workers goroutines.
+func inventoryLookups(peeredConns []network.PeeredConnSocket, localIP string, workers int) (inventoryLookupFunc, inventoryLookupFunc, serviceLookupFunc) {
+	inventoryHosts := inventory.Get()
+	localAddresses, remoteAddresses := peeredConnAddresses(peeredConns, localIP)
+	getLocalHost := prefetchHosts(localAddresses, inventoryHosts.GetLocalHost, workers)
+	getHost := prefetchHosts(remoteAddresses, inventoryHosts.GetHost, workers)
+
+	return func(targetIP string) (string, string) {
+			return getInventoryAddrAndHostgroup(getLocalHost, targetIP)
+		}, func(targetIP string) (string, string) {
+			return getInventoryAddrAndHostgroup(getHost, targetIP)
+		}, func(targetIP, port string) string {
+			host, found := getHost(targetIP)
+			if !found {
+				return ""
+			}
+
+			return host.Services[port]
+		}
+}
+
+// parseProcessesAndListenPortsConns parses listening server processes and connections' ports that are in LISTEN state
+// Listening server processes are used to know what processes may accept downstream connections.
+// Listening connection ports are used to check whether the local port in a given connection tuple is ephemeral or is owned by a server process.
+func parseProcessesAndListenPortsConns(serverConnectionStat network.ServerConnectionStat) ([]Process, map[uint32]network.ListeningConnSocket) {
+	// Listening server processes
+	processes := []Process{}
+
+	// Listening server ports
+	listeningPortsConns := make(map[uint32]network.ListeningConnSocket)
+
+	// Iterate over connection sockets that are in LISTEN state
+	for _, listeningConn := range serverConnectionStat.ListeningConnSockets {
+		listeningConn.LocalIP = network.NormalizeIP(listeningConn.LocalIP)
+
+		// Build serverProcesses from server LISTEN sockets
+		processes = append(processes, Process{
+			Name: listeningConn.ProcessName,
+			Bind: fmt.Sprintf("%v:%v", listeningConn.LocalIP, listeningConn.LocalPort),
+			Port: fmt.Sprint(listeningConn.LocalPort),
+		})
+
+		// Build list of listening server ports from server LISTEN sockets
+		listeningPortsConns[listeningConn.LocalPort] = listeningConn
+		log.Debugf("Server listening on: %v:%v [process:%v]", listeningConn.LocalIP, listeningConn.LocalPort, listeningConn.ProcessName)
+	}
+
+	return processes, listeningPortsConns
+}
+
+// parseUnixSocketListeners parses the processes listening on Unix sockets.
+func parseUnixSocketListeners(serverConnectionStat network.ServerConnectionStat) []UnixSocketListener {
+	listeners := make([]UnixSocketListener, 0, len(serverConnectionStat.UnixListeningSockets))
+	for _, unixListeningConn := range serverConnectionStat.UnixListeningSockets {
+		listeners = append(listeners, UnixSocketListener{
+			Path:        unixListeningConn.Path,
+			ProcessName: unixListeningConn.ProcessName,
+		})
+	}
+
+	return listeners
+}
+
+const (
+	upstreamDirection   = "upstream"
+	downstreamDirection = "downstream"
+)
+
+// connectionKey is the normalized connection tuple used to deduplicate dependency connections.
+// Ephemeral ports (local port of an upstream, remote port of a downstream) are left empty,
+// because they are not part of a dependency's identity and are not exported as metric labels.
+type connectionKey struct {
+	direction       string
+	localHostgroup  string
+	localAddress    string
+	localPort       string
+	remoteHostgroup string
+	remoteAddress   string
+	remotePort      string
+	protocol        string
+}
+
+// inventoryLookupFunc returns address/domain and hostgroup of the given IP.
+type inventoryLookupFunc func(targetIP string) (string, string)
+
+// serviceLookupFunc returns the service name of the given IP and port, empty if it's unknown.
+type serviceLookupFunc func(targetIP, port string) string
+
+// upstreamConnectionKey returns the connection tuple identifying an upstream dependency.
+func upstreamConnectionKey(conn Connections) connectionKey {
+	return connectionKey{
+		direction:       upstreamDirection,
+		localHostgroup:  conn.LocalHostgroup,
+		localAddress:    conn.LocalAddress,
+		remoteHostgroup: conn.RemoteHostgroup,
+		remoteAddress:   conn.RemoteAddress,
+		remotePort:      conn.Port,
+		protocol:        conn.Protocol,
+	}
+}
+
+// downstreamConnectionKey returns the connection tuple identifying a downstream dependency.
+func downstreamConnectionKey(conn Connections) connectionKey {
+	return connectionKey{
+		direction:       downstreamDirection,
+		localHostgroup:  conn.LocalHostgroup,
+		localAddress:    conn.LocalAddress,
+		localPort:       conn.Port,
+		remoteHostgroup: conn.RemoteHostgroup,
+		remoteAddress:   conn.RemoteAddress,
+		protocol:        conn.Protocol,
+	}
+}
+
+// socketID identifies a peered connection socket of a listening server process across collections.
+// The server process start time is part of the ID, so sockets of a new process that reused the Pid or
+// the same connection tuple aren't mistaken for the sockets of the previous process.
+func socketID(peeredConn network.PeeredConnSocket, listeningConn network.ListeningConnSocket) string {
+	return fmt.Sprintf("%v|%v:%v|%v:%v|%v@%v", peeredConn.Protocol, peeredConn.LocalIP, peeredConn.LocalPort, peeredConn.RemoteIP, peeredConn.RemotePort,
+		listeningConn.ProcessPid, listeningConn.ProcessStartTime)
+}
+
+// classifyConnections splits peered connections into upstream and downstream dependencies.
+// A peered connection whose local port is one of the listening ports is a downstream, otherwise it's an upstream.
+// Connections to a remote address resolved as "localhost" are not considered upstreams.
+// The edge scope of a connection is decided by whether its remote IP is in the internalCIDRs.
+// Upstreams are labeled with the service name of their remote port, when the inventory knows it.
+// Every dependency counts the sockets of its connection tuple, and whether they're all in TIME_WAIT.
+// It also returns the socket IDs backing every downstream dependency.
+func classifyConnections(peeredConns []network.PeeredConnSocket, listeningPortsConns map[uint32]network.ListeningConnSocket,
+	localIP string, internalCIDRs []*net.IPNet, localLookup, remoteLookup inventoryLookupFunc,
+	serviceLookup serviceLookupFunc) ([]Connections, []Connections, map[connectionKey][]string) {
 	var upstreams []Connections
 	var downstreams []Connections
+	downstreamSockets := make(map[connectionKey][]string)
+
+	// Index of every included connection tuple in upstreams or downstreams
+	includedConns := make(map[connectionKey]int)
+	for _, peeredConn := range peeredConns {
+		// Dual-stack listeners report IPv4 peers as IPv4-mapped IPv6 addresses (e.g. "::ffff:10.1.2.3")
+		peeredConn.LocalIP = network.NormalizeIP(peeredConn.LocalIP)
+		peeredConn.RemoteIP = network.NormalizeIP(peeredConn.RemoteIP)
 
-	includedConns := make(map[string]bool)
-	for _, peeredConn := range serverConnectionStat.PeeredConnSockets {
 		// Replace localhost or 127.0.0.1 with a more useful current address
 		if peeredConn.LocalIP == "127.0.0.1" {
-			peeredConn.LocalIP = currentIP.String()
+			peeredConn.LocalIP = localIP
 		}
 
 		// Find local Host inventory
 		// This should be the same most of the time,
 		// but we find LocalIP's inventory for every peeredConn in case there's interface address spoofing.
-		localAddr, localHostgroup := getInventoryAddrAndHostgroup(peeredConn.LocalIP)
+		localAddr, localHostgroup := localLookup(peeredConn.LocalIP)
 
 		// Find remote Host inventory
-		remoteAddr, remoteHostgroup := getInventoryAddrAndHostgroup(peeredConn.RemoteIP)
+		remoteAddr, remoteHostgroup := remoteLookup(peeredConn.RemoteIP)
 
 		// Check whether this is a downstream/upstream connection tuple
 		if listeningConn, foundListeningConn := listeningPortsConns[peeredConn.LocalPort]; foundListeningConn {
@@ -133,21 +692,13 @@ func Collect(ctx context.Context) error {
 			// Since it's a downstream conn, remote port is the listening server port
 			remotePort := fmt.Sprint(peeredConn.LocalPort)
 
-			// To track whether we have considered this connection
-			connString := fmt.Sprintf("down_%s_%s_%v_%s", remoteHostgroup, remoteAddr, peeredConn.LocalPort, peeredConn.Protocol)
-			// Prevents duplicate downstream conn entries
-			if _, ok := includedConns[connString]; ok {
-				continue
-			}
-			includedConns[connString] = true
-
 			// Empty process name on a connection socket usually comes from TIME_WAIT state, they don't have PID anymore.
 			// Since we know it's a conn coming to listening port, we set process name to the server process that's listening on that port.
 			if peeredConn.ProcessName == "" {
 				peeredConn.ProcessName = listeningConn.ProcessName
 			}
 
-			downstreams = append(downstreams, Connections{
+			downstream := Connections{
 				LocalHostgroup:  localHostgroup,
 				RemoteHostgroup: remoteHostgroup,
 				LocalAddress:    localAddr,
@@ -155,78 +706,102 @@ func Collect(ctx context.Context) error {
 				Port:            remotePort,
 				Protocol:        peeredConn.Protocol,
 				ProcessName:     peeredConn.ProcessName,
-			})
+				EdgeScope:       edgeScope(internalCIDRs, peeredConn.RemoteIP),
+				TimeWaitOnly:    peeredConn.State == network.SocketStateTimeWait,
+			}
+
+			// To track whether we have considered this connection
+			connKey := downstreamConnectionKey(downstream)
+			downstreamSockets[connKey] = append(downstreamSockets[connKey], socketID(peeredConn, listeningConn))
+
+			// Prevents duplicate downstream conn entries
+			if i, ok := includedConns[connKey]; ok {
+				downstreams[i].Count++
+				downstreams[i].TimeWaitOnly = downstreams[i].TimeWaitOnly && downstream.TimeWaitOnly
+
+				continue
+			}
+			includedConns[connKey] = len(downstreams)
+
+			downstream.Count = 1
+			downstreams = append(downstreams, downstream)
 		} else if remoteAddr != "localhost" {
 			// It's an upstream connection otherwise.
 
 			remotePort := fmt.Sprint(peeredConn.RemotePort)
 
+			upstream := Connections{
+				LocalHostgroup:    localHostgroup,
+				RemoteHostgroup:   remoteHostgroup,
+				LocalAddress:      localAddr,
+				RemoteAddress:     remoteAddr,
+				Port:              remotePort,
+				Protocol:          peeredConn.Protocol,
+				ProcessName:       peeredConn.ProcessName,
+				EdgeScope:         edgeScope(internalCIDRs, peeredConn.RemoteIP),
+				RemoteServiceName: serviceLookup(peeredConn.RemoteIP, remotePort),
+				TimeWaitOnly:      peeredConn.State == network.SocketStateTimeWait,
+			}
+
 			// To track whether we have considered this connection
-			connString := fmt.Sprintf("up_%s_%s_%s_%s", remoteHostgroup, remoteAddr, remotePort, peeredConn.Protocol)
+			connKey := upstreamConnectionKey(upstream)
+
 			// Prevents duplicate upstream conn entries
-			if _, ok := includedConns[connString]; ok {
+			if i, ok := includedConns[connKey]; ok {
+				upstreams[i].Count++
+				upstreams[i].TimeWaitOnly = upstreams[i].TimeWaitOnly && upstream.TimeWaitOnly
+
 				continue
 			}
-			includedConns[connString] = true
+			includedConns[connKey] = len(upstreams)
 
-			upstreams = append(upstreams, Connections{
-				LocalHostgroup:  localHostgroup,
-				RemoteHostgroup: remoteHostgroup,
-				LocalAddress:    localAddr,
-				RemoteAddress:   remoteAddr,
-				Port:            remotePort,
-				Protocol:        peeredConn.Protocol,
-				ProcessName:     peeredConn.ProcessName,
-			})
+			upstream.Count = 1
+			upstreams = append(upstreams, upstream)
 		}
 	}
 
-	singleton.mu.Lock()
-	singleton.serverProcesses = serverProcesses
-	singleton.upstreams = upstreams
-	singleton.downstreams = downstreams
-	singleton.mu.Unlock()
+	return upstreams, downstreams, downstreamSockets
+}
 
-	log.Debugf("tasksocketstat.Collect retrieved %v upstreams metrics", len(upstreams))
-	log.Debugf("tasksocketstat.Collect retrieved %v downstreams metrics", len(downstreams))
-	log.Debugf("tasksocketstat.Collect process took %v", time.Since(startTime))
+// withoutTimeWaitOnly returns the connections that have a socket other than TIME_WAIT.
+func withoutTimeWaitOnly(connections []Connections) []Connections {
+	var kept []Connections
+	for _, conn := range connections {
+		if !conn.TimeWaitOnly {
+			kept = append(kept, conn)
+		}
+	}
 
-	return nil
+	return kept
 }
 
-// parseProcessesAndListenPortsConns parses listening server processes and connections' ports that are in LISTEN state
-// Listening server processes are used to know what processes may accept downstream connections.
-// Listening connection ports are used to check whether the local port in a given connection tuple is ephemeral or is owned by a server process.
-func parseProcessesAndListenPortsConns(serverConnectionStat network.ServerConnectionStat) ([]Process, map[uint32]network.ListeningConnSocket) {
-	// Listening server processes
-	processes := []Process{}
+// sampleConnections returns a deterministic subset of connections based on the hash of each connection tuple.
+// A given connection tuple is either always or never sampled for the same sampleRate, so edge presence is
+// stable across collections while the number of exported connections is reduced.
+func sampleConnections(connections []Connections, direction string, sampleRate float64) []Connections {
+	if sampleRate >= 1 {
+		return connections
+	}
 
-	// Listening server ports
-	listeningPortsConns := make(map[uint32]network.ListeningConnSocket)
+	threshold := uint64(sampleRate * math.MaxUint64)
 
-	// Iterate over connection sockets that are in LISTEN state
-	for _, listeningConn := range serverConnectionStat.ListeningConnSockets {
-		// Build serverProcesses from server LISTEN sockets
-		processes = append(processes, Process{
-			Name: listeningConn.ProcessName,
-			Bind: fmt.Sprintf("%v:%v", listeningConn.LocalIP, listeningConn.LocalPort),
-			Port: fmt.Sprint(listeningConn.LocalPort),
-		})
-
-		// Build list of listening server ports from server LISTEN sockets
-		listeningPortsConns[listeningConn.LocalPort] = listeningConn
-		log.Debugf("Server listening on: %v:%v [process:%v]", listeningConn.LocalIP, listeningConn.LocalPort, listeningConn.ProcessName)
+	var sampled []Connections
+	for _, conn := range connections {
+		hasher := fnv.New64a()
+		_, _ = fmt.Fprintf(hasher, "%s|%s|%s|%s|%s|%s|%s|%s", direction,
+			conn.LocalHostgroup, conn.LocalAddress, conn.RemoteHostgroup, conn.RemoteAddress, conn.Port, conn.Protocol, conn.ProcessName)
+		if hasher.Sum64() < threshold {
+			sampled = append(sampled, conn)
+		}
 	}
 
-	return processes, listeningPortsConns
+	return sampled
 }
 
 // getInventoryAddrAndHostgroup returns address/domain and hostgroup of the given IP based on inventory data.
-func getInventoryAddrAndHostgroup(targetIP string) (string, string) {
-	inventoryHosts := inventory.Get()
-
+func getInventoryAddrAndHostgroup(getHost func(string) (inventory.Host, bool), targetIP string) (string, string) {
 	var addr, hostgroup string
-	if host, found := inventoryHosts.GetHost(targetIP); found {
+	if host, found := getHost(targetIP); found {
 		addr = host.Domain
 		hostgroup = host.Hostgroup
 	}
@@ -0,0 +1,514 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package socketstat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"planet-exporter/pkg/network"
+)
+
+// mockInventoryLookup returns an inventoryLookupFunc backed by a static IP -> [domain, hostgroup] table.
+func mockInventoryLookup(hosts map[string][2]string) inventoryLookupFunc {
+	return func(targetIP string) (string, string) {
+		if host, ok := hosts[targetIP]; ok {
+			return host[0], host[1]
+		}
+
+		return targetIP, ""
+	}
+}
+
+// mockServiceLookup returns a serviceLookupFunc backed by a static "IP:port" -> service name table.
+func mockServiceLookup(services map[string]string) serviceLookupFunc {
+	return func(targetIP, port string) string {
+		return services[targetIP+":"+port]
+	}
+}
+
+func Test_classifyConnections(t *testing.T) {
+	lookup := mockInventoryLookup(map[string][2]string{
+		"10.0.0.1":  {"local.service.consul", "local"},
+		"10.0.0.2":  {"xyz.service.consul", "xyz"},
+		"10.0.0.3":  {"local-alias.service.consul", "local-alias"},
+		"127.0.0.1": {"localhost", "localhost"},
+	})
+	listeningPortsConns := map[uint32]network.ListeningConnSocket{
+		80: {ProcessPid: 1, LocalPort: 80, LocalIP: "0.0.0.0", ProcessName: "nginx"},
+	}
+
+	type args struct {
+		peeredConns []network.PeeredConnSocket
+	}
+	tests := []struct {
+		name            string
+		args            args
+		wantUpstreams   []Connections
+		wantDownstreams []Connections
+	}{
+		{
+			name: "Local port matches a listening port is a downstream",
+			args: args{
+				peeredConns: []network.PeeredConnSocket{
+					{LocalIP: "10.0.0.1", LocalPort: 80, RemoteIP: "10.0.0.2", RemotePort: 41234, Protocol: "tcp", ProcessName: "nginx"},
+				},
+			},
+			wantDownstreams: []Connections{
+				{
+					LocalHostgroup: "local", LocalAddress: "local.service.consul",
+					RemoteHostgroup: "xyz", RemoteAddress: "xyz.service.consul",
+					Port: "80", Protocol: "tcp", ProcessName: "nginx", Count: 1,
+				},
+			},
+		},
+		{
+			name: "IPv4-mapped IPv6 addresses are looked up in IPv4 form",
+			args: args{
+				peeredConns: []network.PeeredConnSocket{
+					{LocalIP: "::ffff:10.0.0.1", LocalPort: 80, RemoteIP: "::ffff:10.0.0.2", RemotePort: 41234, Protocol: "tcp", ProcessName: "nginx"},
+					{LocalIP: "10.0.0.1", LocalPort: 80, RemoteIP: "10.0.0.2", RemotePort: 41235, Protocol: "tcp", ProcessName: "nginx"},
+				},
+			},
+			wantDownstreams: []Connections{
+				{
+					LocalHostgroup: "local", LocalAddress: "local.service.consul",
+					RemoteHostgroup: "xyz", RemoteAddress: "xyz.service.consul",
+					Port: "80", Protocol: "tcp", ProcessName: "nginx", Count: 2,
+				},
+			},
+		},
+		{
+			name: "Ephemeral local port is an upstream",
+			args: args{
+				peeredConns: []network.PeeredConnSocket{
+					{LocalIP: "10.0.0.1", LocalPort: 41234, RemoteIP: "10.0.0.2", RemotePort: 9000, Protocol: "tcp", ProcessName: "curl"},
+				},
+			},
+			wantUpstreams: []Connections{
+				{
+					LocalHostgroup: "local", LocalAddress: "local.service.consul",
+					RemoteHostgroup: "xyz", RemoteAddress: "xyz.service.consul",
+					Port: "9000", Protocol: "tcp", ProcessName: "curl", Count: 1,
+				},
+			},
+		},
+		{
+			name: "TIME_WAIT downstream with empty process name uses the listening process name",
+			args: args{
+				peeredConns: []network.PeeredConnSocket{
+					{LocalIP: "10.0.0.1", LocalPort: 80, RemoteIP: "10.0.0.2", RemotePort: 41234, Protocol: "tcp", ProcessName: ""},
+				},
+			},
+			wantDownstreams: []Connections{
+				{
+					LocalHostgroup: "local", LocalAddress: "local.service.consul",
+					RemoteHostgroup: "xyz", RemoteAddress: "xyz.service.consul",
+					Port: "80", Protocol: "tcp", ProcessName: "nginx", Count: 1,
+				},
+			},
+		},
+		{
+			name: "Upstream to a loopback remote address is excluded",
+			args: args{
+				peeredConns: []network.PeeredConnSocket{
+					{LocalIP: "127.0.0.1", LocalPort: 41234, RemoteIP: "127.0.0.1", RemotePort: 8500, Protocol: "tcp", ProcessName: "consul-template"},
+				},
+			},
+		},
+		{
+			name: "Loopback local address is replaced with the local IP",
+			args: args{
+				peeredConns: []network.PeeredConnSocket{
+					{LocalIP: "127.0.0.1", LocalPort: 41234, RemoteIP: "10.0.0.2", RemotePort: 9000, Protocol: "tcp", ProcessName: "curl"},
+				},
+			},
+			wantUpstreams: []Connections{
+				{
+					LocalHostgroup: "local", LocalAddress: "local.service.consul",
+					RemoteHostgroup: "xyz", RemoteAddress: "xyz.service.consul",
+					Port: "9000", Protocol: "tcp", ProcessName: "curl", Count: 1,
+				},
+			},
+		},
+		{
+			name: "Duplicate upstream connections are reported once with their count",
+			args: args{
+				peeredConns: []network.PeeredConnSocket{
+					{LocalIP: "10.0.0.1", LocalPort: 41234, RemoteIP: "10.0.0.2", RemotePort: 9000, Protocol: "tcp", ProcessName: "curl"},
+					{LocalIP: "10.0.0.1", LocalPort: 41234, RemoteIP: "10.0.0.2", RemotePort: 9000, Protocol: "tcp", ProcessName: "curl"},
+				},
+			},
+			wantUpstreams: []Connections{
+				{
+					LocalHostgroup: "local", LocalAddress: "local.service.consul",
+					RemoteHostgroup: "xyz", RemoteAddress: "xyz.service.consul",
+					Port: "9000", Protocol: "tcp", ProcessName: "curl", Count: 2,
+				},
+			},
+		},
+		{
+			name: "Upstream connections from distinct local addresses to the same remote are distinct",
+			args: args{
+				peeredConns: []network.PeeredConnSocket{
+					{LocalIP: "10.0.0.1", LocalPort: 41234, RemoteIP: "10.0.0.2", RemotePort: 9000, Protocol: "tcp", ProcessName: "curl"},
+					{LocalIP: "10.0.0.3", LocalPort: 41235, RemoteIP: "10.0.0.2", RemotePort: 9000, Protocol: "tcp", ProcessName: "curl"},
+				},
+			},
+			wantUpstreams: []Connections{
+				{
+					LocalHostgroup: "local", LocalAddress: "local.service.consul",
+					RemoteHostgroup: "xyz", RemoteAddress: "xyz.service.consul",
+					Port: "9000", Protocol: "tcp", ProcessName: "curl", Count: 1,
+				},
+				{
+					LocalHostgroup: "local-alias", LocalAddress: "local-alias.service.consul",
+					RemoteHostgroup: "xyz", RemoteAddress: "xyz.service.consul",
+					Port: "9000", Protocol: "tcp", ProcessName: "curl", Count: 1,
+				},
+			},
+		},
+		{
+			name: "Downstream connections to distinct local addresses on the same port are distinct",
+			args: args{
+				peeredConns: []network.PeeredConnSocket{
+					{LocalIP: "10.0.0.1", LocalPort: 80, RemoteIP: "10.0.0.2", RemotePort: 41234, Protocol: "tcp", ProcessName: "nginx"},
+					{LocalIP: "10.0.0.3", LocalPort: 80, RemoteIP: "10.0.0.2", RemotePort: 41235, Protocol: "tcp", ProcessName: "nginx"},
+				},
+			},
+			wantDownstreams: []Connections{
+				{
+					LocalHostgroup: "local", LocalAddress: "local.service.consul",
+					RemoteHostgroup: "xyz", RemoteAddress: "xyz.service.consul",
+					Port: "80", Protocol: "tcp", ProcessName: "nginx", Count: 1,
+				},
+				{
+					LocalHostgroup: "local-alias", LocalAddress: "local-alias.service.consul",
+					RemoteHostgroup: "xyz", RemoteAddress: "xyz.service.consul",
+					Port: "80", Protocol: "tcp", ProcessName: "nginx", Count: 1,
+				},
+			},
+		},
+		{
+			name: "Connection tuples whose sockets are all in TIME_WAIT are TIME_WAIT-only",
+			args: args{
+				peeredConns: []network.PeeredConnSocket{
+					{LocalIP: "10.0.0.1", LocalPort: 41234, RemoteIP: "10.0.0.2", RemotePort: 9000, Protocol: "tcp", State: network.SocketStateTimeWait},
+					{LocalIP: "10.0.0.1", LocalPort: 41235, RemoteIP: "10.0.0.2", RemotePort: 9000, Protocol: "tcp", State: network.SocketStateTimeWait},
+					{LocalIP: "10.0.0.1", LocalPort: 41236, RemoteIP: "10.0.0.2", RemotePort: 9001, Protocol: "tcp", State: network.SocketStateTimeWait},
+					{LocalIP: "10.0.0.1", LocalPort: 41237, RemoteIP: "10.0.0.2", RemotePort: 9001, Protocol: "tcp", ProcessName: "curl", State: network.SocketStateEstablished},
+				},
+			},
+			wantUpstreams: []Connections{
+				{
+					LocalHostgroup: "local", LocalAddress: "local.service.consul",
+					RemoteHostgroup: "xyz", RemoteAddress: "xyz.service.consul",
+					Port: "9000", Protocol: "tcp", Count: 2, TimeWaitOnly: true,
+				},
+				{
+					LocalHostgroup: "local", LocalAddress: "local.service.consul",
+					RemoteHostgroup: "xyz", RemoteAddress: "xyz.service.consul",
+					Port: "9001", Protocol: "tcp", Count: 2, TimeWaitOnly: false,
+				},
+			},
+		},
+		{
+			name: "Upstream and downstream with the same remote and port are distinct",
+			args: args{
+				peeredConns: []network.PeeredConnSocket{
+					{LocalIP: "10.0.0.1", LocalPort: 80, RemoteIP: "10.0.0.2", RemotePort: 41234, Protocol: "tcp", ProcessName: "nginx"},
+					{LocalIP: "10.0.0.1", LocalPort: 41235, RemoteIP: "10.0.0.2", RemotePort: 80, Protocol: "tcp", ProcessName: "curl"},
+				},
+			},
+			wantUpstreams: []Connections{
+				{
+					LocalHostgroup: "local", LocalAddress: "local.service.consul",
+					RemoteHostgroup: "xyz", RemoteAddress: "xyz.service.consul",
+					Port: "80", Protocol: "tcp", ProcessName: "curl", Count: 1,
+				},
+			},
+			wantDownstreams: []Connections{
+				{
+					LocalHostgroup: "local", LocalAddress: "local.service.consul",
+					RemoteHostgroup: "xyz", RemoteAddress: "xyz.service.consul",
+					Port: "80", Protocol: "tcp", ProcessName: "nginx", Count: 1,
+				},
+			},
+		},
+	}
+	for _, testcase := range tests {
+		t.Run(testcase.name, func(t *testing.T) {
+			gotUpstreams, gotDownstreams, _ := classifyConnections(testcase.args.peeredConns, listeningPortsConns, "10.0.0.1", nil, lookup, lookup, mockServiceLookup(nil))
+			if !reflect.DeepEqual(gotUpstreams, testcase.wantUpstreams) {
+				t.Errorf("classifyConnections() upstreams = %v, want %v", gotUpstreams, testcase.wantUpstreams)
+			}
+			if !reflect.DeepEqual(gotDownstreams, testcase.wantDownstreams) {
+				t.Errorf("classifyConnections() downstreams = %v, want %v", gotDownstreams, testcase.wantDownstreams)
+			}
+		})
+	}
+}
+
+func Test_socketID(t *testing.T) {
+	established := network.PeeredConnSocket{LocalIP: "10.0.0.1", LocalPort: 80, RemoteIP: "10.0.0.2", RemotePort: 41234, Protocol: "tcp", ProcessName: "nginx"}
+	timeWait := established
+	timeWait.ProcessName = ""
+	nginx := network.ListeningConnSocket{ProcessPid: 100, LocalPort: 80, LocalIP: "0.0.0.0", ProcessName: "nginx", ProcessStartTime: 500}
+	restarted := nginx
+	restarted.ProcessStartTime = 900
+
+	if socketID(established, nginx) != socketID(timeWait, nginx) {
+		t.Errorf("socketID() differs between ESTABLISHED and TIME_WAIT states of the same socket")
+	}
+	if socketID(established, nginx) == socketID(established, restarted) {
+		t.Errorf("socketID() = %v for both server processes that reused Pid %v", socketID(established, nginx), nginx.ProcessPid)
+	}
+}
+
+func Test_sampleConnections(t *testing.T) {
+	connections := []Connections{}
+	for i := 0; i < 1000; i++ {
+		connections = append(connections, Connections{
+			LocalHostgroup: "local", LocalAddress: "local.service.consul",
+			RemoteHostgroup: "xyz", RemoteAddress: fmt.Sprintf("10.0.%d.%d", i/256, i%256),
+			Port: "9000", Protocol: "tcp", ProcessName: "curl",
+		})
+	}
+
+	if got := sampleConnections(connections, upstreamDirection, 1); !reflect.DeepEqual(got, connections) {
+		t.Errorf("sampleConnections() with sample rate 1 = %v connections, want %v", len(got), len(connections))
+	}
+	if got := sampleConnections(connections, upstreamDirection, 0); len(got) != 0 {
+		t.Errorf("sampleConnections() with sample rate 0 = %v connections, want 0", len(got))
+	}
+
+	got := sampleConnections(connections, upstreamDirection, 0.5)
+	if len(got) < 400 || len(got) > 600 {
+		t.Errorf("sampleConnections() with sample rate 0.5 = %v connections, want about 500", len(got))
+	}
+	if again := sampleConnections(connections, upstreamDirection, 0.5); !reflect.DeepEqual(got, again) {
+		t.Errorf("sampleConnections() is not deterministic for the same connection tuples")
+	}
+}
+
+func Test_withoutTimeWaitOnly(t *testing.T) {
+	established := Connections{RemoteAddress: "10.0.0.2", Port: "9000", Protocol: "tcp", Count: 1}                      // nolint:exhaustivestruct
+	timeWaitOnly := Connections{RemoteAddress: "10.0.0.3", Port: "9000", Protocol: "tcp", Count: 3, TimeWaitOnly: true} // nolint:exhaustivestruct
+
+	got := withoutTimeWaitOnly([]Connections{timeWaitOnly, established})
+	if want := []Connections{established}; !reflect.DeepEqual(got, want) {
+		t.Errorf("withoutTimeWaitOnly() = %v, want %v", got, want)
+	}
+}
+
+func Test_classifyConnections_edgeScope(t *testing.T) {
+	internalCIDRs, err := network.ParseCIDRs("10.0.0.0/8")
+	if err != nil {
+		t.Fatalf("network.ParseCIDRs() error = %v", err)
+	}
+	peeredConns := []network.PeeredConnSocket{
+		{LocalIP: "10.0.0.1", LocalPort: 41234, RemoteIP: "10.0.0.2", RemotePort: 5432, Protocol: "tcp", ProcessName: "app"},
+		{LocalIP: "10.0.0.1", LocalPort: 41235, RemoteIP: "::ffff:203.0.113.7", RemotePort: 443, Protocol: "tcp", ProcessName: "app"},
+	}
+	// The remote domain from the inventory doesn't hide the remote IP from the edge scope
+	lookup := mockInventoryLookup(map[string][2]string{"203.0.113.7": {"api.partner.example", "partner"}})
+
+	tests := []struct {
+		name          string
+		internalCIDRs []*net.IPNet
+		want          []string
+	}{
+		{name: "Internal networks", internalCIDRs: internalCIDRs, want: []string{EdgeScopeInternal, EdgeScopeExternal}},
+		{name: "No internal networks", internalCIDRs: nil, want: []string{"", ""}},
+	}
+	for _, testcase := range tests {
+		t.Run(testcase.name, func(t *testing.T) {
+			upstreams, _, _ := classifyConnections(peeredConns, nil, "10.0.0.1", testcase.internalCIDRs, lookup, lookup, mockServiceLookup(nil))
+			got := []string{}
+			for _, conn := range upstreams {
+				got = append(got, conn.EdgeScope)
+			}
+			if !reflect.DeepEqual(got, testcase.want) {
+				t.Errorf("classifyConnections() edge scopes = %v, want %v", got, testcase.want)
+			}
+		})
+	}
+}
+
+func Test_classifyConnections_remoteServiceName(t *testing.T) {
+	lookup := mockInventoryLookup(map[string][2]string{"10.0.0.2": {"db.service.consul", "db"}})
+	serviceLookup := mockServiceLookup(map[string]string{"10.0.0.2:3306": "mysql", "10.0.0.3:41000": "ignored"})
+	listeningPortsConns := map[uint32]network.ListeningConnSocket{
+		80: {ProcessPid: 1, LocalPort: 80, LocalIP: "0.0.0.0", ProcessName: "nginx"},
+	}
+	peeredConns := []network.PeeredConnSocket{
+		{LocalIP: "10.0.0.1", LocalPort: 41234, RemoteIP: "10.0.0.2", RemotePort: 3306, Protocol: "tcp", ProcessName: ""},
+		{LocalIP: "10.0.0.1", LocalPort: 41235, RemoteIP: "10.0.0.2", RemotePort: 9104, Protocol: "tcp", ProcessName: "prometheus"},
+		{LocalIP: "10.0.0.1", LocalPort: 80, RemoteIP: "10.0.0.3", RemotePort: 41000, Protocol: "tcp", ProcessName: "nginx"},
+	}
+
+	upstreams, downstreams, _ := classifyConnections(peeredConns, listeningPortsConns, "10.0.0.1", nil, lookup, lookup, serviceLookup)
+	gotUpstreams := []string{}
+	for _, conn := range upstreams {
+		gotUpstreams = append(gotUpstreams, conn.RemoteServiceName)
+	}
+	// The remote process is unknown, the service name comes from the inventory, and unknown ports are left empty
+	if want := []string{"mysql", ""}; !reflect.DeepEqual(gotUpstreams, want) {
+		t.Errorf("classifyConnections() upstream service names = %v, want %v", gotUpstreams, want)
+	}
+	// Downstreams aren't labeled with the remote (ephemeral) port's service
+	if len(downstreams) != 1 || downstreams[0].RemoteServiceName != "" {
+		t.Errorf("classifyConnections() downstreams = %v, want one without a service name", downstreams)
+	}
+}
+
+// errMockServerConnections is returned by a mock serverConnections that fails.
+var errMockServerConnections = errors.New("mock server connections error")
+
+func TestCollect_processScanTimeout(t *testing.T) {
+	previousServerConnections, previousLocalIP := serverConnections, localIP
+	defer func() {
+		serverConnections, localIP = previousServerConnections, previousLocalIP
+		singleton.enabled = false
+	}()
+	localIP = func() (net.IP, error) { return net.ParseIP("10.0.0.1"), nil }
+
+	partialStat := network.ServerConnectionStat{
+		PeeredConnSockets: []network.PeeredConnSocket{
+			{LocalIP: "10.0.0.1", LocalPort: 80, RemoteIP: "10.0.0.2", RemotePort: 41234, Protocol: "tcp", ProcessName: "nginx"},
+			{LocalIP: "10.0.0.1", LocalPort: 41234, RemoteIP: "10.0.0.3", RemotePort: 5432, Protocol: "tcp", ProcessName: ""},
+		},
+		ListeningConnSockets: []network.ListeningConnSocket{
+			{ProcessPid: 1, LocalPort: 80, LocalIP: "0.0.0.0", ProcessName: "nginx", ProcessStartTime: 1},
+		},
+	}
+
+	tests := []struct {
+		name                     string
+		serverConnections        func(ctx context.Context) (network.ServerConnectionStat, error)
+		wantErr                  bool
+		wantProcessScanTruncated bool
+		wantUpstreams            int
+		wantDownstreams          int
+	}{
+		{
+			name: "Within the timeout",
+			serverConnections: func(ctx context.Context) (network.ServerConnectionStat, error) {
+				return partialStat, nil
+			},
+			wantUpstreams:   1,
+			wantDownstreams: 1,
+		},
+		{
+			name: "Slow process scan keeps the connections without a process name",
+			serverConnections: func(ctx context.Context) (network.ServerConnectionStat, error) {
+				<-ctx.Done()
+
+				return partialStat, fmt.Errorf("error getting server process table: %w", ctx.Err())
+			},
+			wantProcessScanTruncated: true,
+			wantUpstreams:            1,
+			wantDownstreams:          1,
+		},
+		{
+			name: "Other errors fail the collection",
+			serverConnections: func(ctx context.Context) (network.ServerConnectionStat, error) {
+				return network.ServerConnectionStat{}, errMockServerConnections
+			},
+			wantErr: true,
+		},
+	}
+	for _, testcase := range tests {
+		t.Run(testcase.name, func(t *testing.T) {
+			InitTask(context.Background(), true, 1, 0, time.Hour, 100, 10*time.Millisecond)
+			serverConnections = testcase.serverConnections
+
+			err := Collect(context.Background())
+			if (err != nil) != testcase.wantErr {
+				t.Fatalf("Collect() error = %v, wantErr %v", err, testcase.wantErr)
+			}
+			if testcase.wantErr {
+				return
+			}
+			if got := GetStats().ProcessScanTruncated; got != testcase.wantProcessScanTruncated {
+				t.Errorf("GetStats().ProcessScanTruncated = %v, want %v", got, testcase.wantProcessScanTruncated)
+			}
+			_, upstreams, downstreams := Get()
+			if len(upstreams) != testcase.wantUpstreams || len(downstreams) != testcase.wantDownstreams {
+				t.Errorf("Get() upstreams = %v, downstreams = %v, want %v upstreams and %v downstreams",
+					upstreams, downstreams, testcase.wantUpstreams, testcase.wantDownstreams)
+			}
+		})
+	}
+}
+
+func TestCollect_unixSocketListeners(t *testing.T) {
+	previousServerConnections, previousLocalIP := serverConnections, localIP
+	defer func() {
+		serverConnections, localIP = previousServerConnections, previousLocalIP
+		singleton.enabled = false
+		SetUnixSocketListeners(false)
+	}()
+	localIP = func() (net.IP, error) { return net.ParseIP("10.0.0.1"), nil }
+	serverConnections = func(ctx context.Context) (network.ServerConnectionStat, error) {
+		return network.ServerConnectionStat{
+			ListeningConnSockets: []network.ListeningConnSocket{
+				{ProcessPid: 1, LocalPort: 80, LocalIP: "0.0.0.0", ProcessName: "nginx", ProcessStartTime: 1},
+			},
+			UnixListeningSockets: []network.UnixListeningSocket{
+				{Path: "/run/docker.sock", ProcessName: "dockerd"},
+			},
+		}, nil
+	}
+
+	tests := []struct {
+		name    string
+		enabled bool
+		want    []UnixSocketListener
+	}{
+		{
+			name:    "Disabled",
+			enabled: false,
+			want:    []UnixSocketListener{},
+		},
+		{
+			name:    "Enabled",
+			enabled: true,
+			want:    []UnixSocketListener{{Path: "/run/docker.sock", ProcessName: "dockerd"}},
+		},
+	}
+	for _, testcase := range tests {
+		t.Run(testcase.name, func(t *testing.T) {
+			InitTask(context.Background(), true, 1, 0, time.Hour, 100, time.Second)
+			SetUnixSocketListeners(testcase.enabled)
+
+			if err := Collect(context.Background()); err != nil {
+				t.Fatalf("Collect() error = %v", err)
+			}
+			if got := GetUnixSocketListeners(); !reflect.DeepEqual(got, testcase.want) {
+				t.Errorf("GetUnixSocketListeners() = %v, want %v", got, testcase.want)
+			}
+			wantServerProcesses := []Process{{Name: "nginx", Bind: "0.0.0.0:80", Port: "80"}}
+			if serverProcesses, _, _ := Get(); !reflect.DeepEqual(serverProcesses, wantServerProcesses) {
+				t.Errorf("Get() server processes = %v, want %v", serverProcesses, wantServerProcesses)
+			}
+		})
+	}
+}
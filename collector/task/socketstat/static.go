@@ -0,0 +1,239 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package socketstat
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+
+	"planet-exporter/collector/task/inventory"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// ErrInvalidStaticDependencies the static dependencies file isn't valid.
+var ErrInvalidStaticDependencies = errors.New("invalid static dependencies")
+
+// StaticDependency is a dependency edge that's declared instead of seen in socket data (e.g. an NFS mount, or a
+// nightly batch push). Port is the remote port of an upstream, and the local port of a downstream, like Connections.
+type StaticDependency struct {
+	// LocalHostgroup is the local inventory hostgroup if empty
+	LocalHostgroup  string `yaml:"local_hostgroup"`
+	LocalAddress    string `yaml:"local_address"`
+	RemoteHostgroup string `yaml:"remote_hostgroup"`
+	RemoteAddress   string `yaml:"remote_address"`
+	Port            string `yaml:"port"`
+	Protocol        string `yaml:"protocol"`
+	ProcessName     string `yaml:"process_name"`
+}
+
+// staticDependencyFields are the keys of a static dependency entry.
+var staticDependencyFields = map[string]bool{
+	"local_hostgroup": true, "local_address": true, "remote_hostgroup": true, "remote_address": true,
+	"port": true, "protocol": true, "process_name": true,
+}
+
+// staticDependencies declared in the static dependencies file, emitted alongside the live socket data.
+var staticDependencies = struct {
+	mu          sync.Mutex
+	upstreams   []StaticDependency
+	downstreams []StaticDependency
+}{
+	mu:          sync.Mutex{},
+	upstreams:   nil,
+	downstreams: nil,
+}
+
+// parseStaticDependencies parses a YAML (or JSON) static dependencies file, e.g.
+//
+//	upstreams:
+//	  - remote_hostgroup: nfs
+//	    remote_address: nfs.example
+//	    port: 2049
+//	    protocol: tcp
+//	    process_name: kernel
+//	downstreams:
+//	  - remote_hostgroup: batch
+//	    port: 443
+//	    protocol: tcp
+//
+// An entry needs a remote hostgroup or address, a port, and a tcp or udp protocol. Errors point at the line and
+// column of the invalid entry or field.
+func parseStaticDependencies(r io.Reader) ([]StaticDependency, []StaticDependency, error) {
+	var file struct {
+		Upstreams   []yaml.Node `yaml:"upstreams"`
+		Downstreams []yaml.Node `yaml:"downstreams"`
+	}
+	decoder := yaml.NewDecoder(r)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&file); err != nil && !errors.Is(err, io.EOF) {
+		return nil, nil, fmt.Errorf("%w: %v", ErrInvalidStaticDependencies, err)
+	}
+
+	upstreams, err := decodeStaticDependencies("upstreams", file.Upstreams)
+	if err != nil {
+		return nil, nil, err
+	}
+	downstreams, err := decodeStaticDependencies("downstreams", file.Downstreams)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return upstreams, downstreams, nil
+}
+
+// decodeStaticDependencies decodes and validates the entries of a list, rejecting duplicate entries.
+func decodeStaticDependencies(list string, nodes []yaml.Node) ([]StaticDependency, error) {
+	dependencies := make([]StaticDependency, 0, len(nodes))
+	seen := map[StaticDependency]int{}
+	for i := range nodes {
+		node := &nodes[i]
+		invalid := func(format string, args ...interface{}) error {
+			return fmt.Errorf("%w: %v entry %d at line %d, column %d: %v", ErrInvalidStaticDependencies,
+				list, i+1, node.Line, node.Column, fmt.Sprintf(format, args...))
+		}
+
+		if node.Kind != yaml.MappingNode {
+			return nil, invalid("expected a mapping of fields")
+		}
+		// Unknown fields are rejected at their own position, yaml.Node.Decode doesn't check them
+		for j := 0; j+1 < len(node.Content); j += 2 {
+			if key := node.Content[j]; !staticDependencyFields[key.Value] {
+				return nil, fmt.Errorf("%w: %v entry %d at line %d, column %d: unknown field %q", ErrInvalidStaticDependencies,
+					list, i+1, key.Line, key.Column, key.Value)
+			}
+		}
+
+		var dependency StaticDependency
+		if err := node.Decode(&dependency); err != nil {
+			return nil, invalid("%v", err)
+		}
+		if dependency.RemoteHostgroup == "" && dependency.RemoteAddress == "" {
+			return nil, invalid("remote_hostgroup and remote_address are both empty")
+		}
+		port, err := strconv.ParseUint(dependency.Port, 10, 16)
+		if err != nil || port == 0 {
+			return nil, invalid("invalid port %q", dependency.Port)
+		}
+		dependency.Port = strconv.FormatUint(port, 10)
+		if dependency.Protocol != "tcp" && dependency.Protocol != "udp" {
+			return nil, invalid("protocol %q isn't tcp or udp", dependency.Protocol)
+		}
+		if previous, found := seen[dependency]; found {
+			return nil, invalid("duplicate of entry %d", previous)
+		}
+		seen[dependency] = i + 1
+
+		dependencies = append(dependencies, dependency)
+	}
+
+	return dependencies, nil
+}
+
+// LoadStaticDependencies loads the static dependencies file, whose edges are emitted alongside the live socket data.
+// An empty path clears the static dependencies. The previous ones are kept if the file is invalid.
+func LoadStaticDependencies(path string) error {
+	var upstreams, downstreams []StaticDependency
+	if path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("error opening static dependencies file: %w", err)
+		}
+		defer f.Close()
+
+		upstreams, downstreams, err = parseStaticDependencies(f)
+		if err != nil {
+			return err
+		}
+	}
+
+	staticDependencies.mu.Lock()
+	staticDependencies.upstreams = upstreams
+	staticDependencies.downstreams = downstreams
+	staticDependencies.mu.Unlock()
+
+	log.Infof("Loaded %v upstream and %v downstream static dependencies from '%v'", len(upstreams), len(downstreams), path)
+
+	return nil
+}
+
+// GetStaticDependencies returns the static upstreams and downstreams that aren't live upstreams or downstreams,
+// so live data is preferred. Edges conflict when they have the same local hostgroup, remote hostgroup (or address
+// without a hostgroup), port, and protocol.
+func GetStaticDependencies(liveUpstreams, liveDownstreams []Connections) ([]Connections, []Connections) {
+	staticDependencies.mu.Lock()
+	upstreams, downstreams := staticDependencies.upstreams, staticDependencies.downstreams
+	staticDependencies.mu.Unlock()
+	if len(upstreams) == 0 && len(downstreams) == 0 {
+		return nil, nil
+	}
+
+	localHostgroup := inventory.GetLocalInventory().Hostgroup
+
+	return staticConnections(upstreams, liveUpstreams, localHostgroup), staticConnections(downstreams, liveDownstreams, localHostgroup)
+}
+
+// staticEdgeKey identifies an edge when comparing static and live edges.
+type staticEdgeKey struct {
+	localHostgroup string
+	remote         string
+	port           string
+	protocol       string
+}
+
+// newStaticEdgeKey returns the key of a connection, whose remote is its hostgroup, or its address without one.
+func newStaticEdgeKey(conn Connections) staticEdgeKey {
+	remote := conn.RemoteHostgroup
+	if remote == "" {
+		remote = conn.RemoteAddress
+	}
+
+	return staticEdgeKey{localHostgroup: conn.LocalHostgroup, remote: remote, port: conn.Port, protocol: conn.Protocol}
+}
+
+// staticConnections returns the static dependencies as connections, skipping those conflicting with a live one.
+func staticConnections(dependencies []StaticDependency, live []Connections, localHostgroup string) []Connections {
+	liveKeys := make(map[staticEdgeKey]bool, len(live))
+	for _, conn := range live {
+		liveKeys[newStaticEdgeKey(conn)] = true
+	}
+
+	connections := []Connections{}
+	for _, dependency := range dependencies {
+		conn := Connections{ // nolint:exhaustivestruct
+			LocalHostgroup:  dependency.LocalHostgroup,
+			LocalAddress:    dependency.LocalAddress,
+			RemoteHostgroup: dependency.RemoteHostgroup,
+			RemoteAddress:   dependency.RemoteAddress,
+			Port:            dependency.Port,
+			Protocol:        dependency.Protocol,
+			ProcessName:     dependency.ProcessName,
+		}
+		if conn.LocalHostgroup == "" {
+			conn.LocalHostgroup = localHostgroup
+		}
+		if liveKeys[newStaticEdgeKey(conn)] {
+			continue
+		}
+		connections = append(connections, conn)
+	}
+
+	return connections
+}
@@ -0,0 +1,228 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package socketstat
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_parseStaticDependencies(t *testing.T) {
+	tests := []struct {
+		name            string
+		file            string
+		wantUpstreams   []StaticDependency
+		wantDownstreams []StaticDependency
+		wantErr         string
+	}{
+		{
+			name: "YAML",
+			file: `upstreams:
+  - remote_hostgroup: nfs
+    remote_address: nfs.example
+    port: 2049
+    protocol: tcp
+    process_name: kernel
+downstreams:
+  - local_hostgroup: app
+    remote_hostgroup: batch
+    port: "0443"
+    protocol: tcp
+`,
+			wantUpstreams: []StaticDependency{
+				{RemoteHostgroup: "nfs", RemoteAddress: "nfs.example", Port: "2049", Protocol: "tcp", ProcessName: "kernel"}, // nolint:exhaustivestruct
+			},
+			wantDownstreams: []StaticDependency{
+				{LocalHostgroup: "app", RemoteHostgroup: "batch", Port: "443", Protocol: "tcp"}, // nolint:exhaustivestruct
+			},
+			wantErr: "",
+		},
+		{
+			name:            "JSON",
+			file:            `{"upstreams": [{"remote_address": "10.0.0.1", "port": 53, "protocol": "udp"}]}`,
+			wantUpstreams:   []StaticDependency{{RemoteAddress: "10.0.0.1", Port: "53", Protocol: "udp"}}, // nolint:exhaustivestruct
+			wantDownstreams: []StaticDependency{},
+			wantErr:         "",
+		},
+		{
+			name:            "Empty file",
+			file:            "",
+			wantUpstreams:   []StaticDependency{},
+			wantDownstreams: []StaticDependency{},
+			wantErr:         "",
+		},
+		{
+			name:    "Unknown top-level field",
+			file:    "upstream:\n  - remote_hostgroup: nfs\n",
+			wantErr: "line 1: field upstream not found",
+		},
+		{
+			name:    "Unknown entry field",
+			file:    "upstreams:\n  - remote_hostgroup: nfs\n    port: 2049\n    protocol: tcp\n    proto: tcp\n",
+			wantErr: `upstreams entry 1 at line 5, column 5: unknown field "proto"`,
+		},
+		{
+			name:    "Entry isn't a mapping",
+			file:    "downstreams:\n  - nfs\n",
+			wantErr: "downstreams entry 1 at line 2, column 5: expected a mapping of fields",
+		},
+		{
+			name:    "Missing remote",
+			file:    "upstreams:\n  - port: 2049\n    protocol: tcp\n",
+			wantErr: "upstreams entry 1 at line 2, column 5: remote_hostgroup and remote_address are both empty",
+		},
+		{
+			name:    "Missing port",
+			file:    "upstreams:\n  - remote_hostgroup: nfs\n    protocol: tcp\n",
+			wantErr: `upstreams entry 1 at line 2, column 5: invalid port ""`,
+		},
+		{
+			name:    "Port out of range",
+			file:    "upstreams:\n  - remote_hostgroup: nfs\n    port: 70000\n    protocol: tcp\n",
+			wantErr: `upstreams entry 1 at line 2, column 5: invalid port "70000"`,
+		},
+		{
+			name:    "Invalid protocol",
+			file:    "upstreams:\n  - remote_hostgroup: nfs\n    port: 2049\n    protocol: sctp\n",
+			wantErr: `upstreams entry 1 at line 2, column 5: protocol "sctp" isn't tcp or udp`,
+		},
+		{
+			name: "Duplicate entry",
+			file: `downstreams:
+  - remote_hostgroup: batch
+    port: 443
+    protocol: tcp
+  - remote_hostgroup: batch
+    port: "443"
+    protocol: tcp
+`,
+			wantErr: "downstreams entry 2 at line 5, column 5: duplicate of entry 1",
+		},
+	}
+	for _, testcase := range tests {
+		t.Run(testcase.name, func(t *testing.T) {
+			upstreams, downstreams, err := parseStaticDependencies(strings.NewReader(testcase.file))
+			if testcase.wantErr != "" {
+				if !errors.Is(err, ErrInvalidStaticDependencies) || !strings.Contains(err.Error(), testcase.wantErr) {
+					t.Fatalf("parseStaticDependencies() error = %v, want %v", err, testcase.wantErr)
+				}
+
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseStaticDependencies() error = %v", err)
+			}
+			if !reflect.DeepEqual(upstreams, testcase.wantUpstreams) {
+				t.Errorf("parseStaticDependencies() upstreams = %+v, want %+v", upstreams, testcase.wantUpstreams)
+			}
+			if !reflect.DeepEqual(downstreams, testcase.wantDownstreams) {
+				t.Errorf("parseStaticDependencies() downstreams = %+v, want %+v", downstreams, testcase.wantDownstreams)
+			}
+		})
+	}
+}
+
+func TestLoadStaticDependencies(t *testing.T) {
+	defer LoadStaticDependencies("") // nolint:errcheck
+
+	dir := t.TempDir()
+	valid := filepath.Join(dir, "valid.yaml")
+	if err := os.WriteFile(valid, []byte("upstreams:\n  - remote_hostgroup: nfs\n    port: 2049\n    protocol: tcp\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	invalid := filepath.Join(dir, "invalid.yaml")
+	if err := os.WriteFile(invalid, []byte("upstreams:\n  - remote_hostgroup: nfs\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := LoadStaticDependencies(valid); err != nil {
+		t.Fatalf("LoadStaticDependencies() error = %v", err)
+	}
+	if err := LoadStaticDependencies(invalid); err == nil {
+		t.Fatalf("LoadStaticDependencies() of an invalid file error = nil, want an error")
+	}
+	// The previous static dependencies are kept
+	if upstreams, _ := GetStaticDependencies(nil, nil); len(upstreams) != 1 || upstreams[0].RemoteHostgroup != "nfs" {
+		t.Errorf("GetStaticDependencies() after an invalid reload = %+v, want the nfs upstream", upstreams)
+	}
+
+	if err := LoadStaticDependencies(""); err != nil {
+		t.Fatalf("LoadStaticDependencies() of an empty path error = %v", err)
+	}
+	if upstreams, downstreams := GetStaticDependencies(nil, nil); upstreams != nil || downstreams != nil {
+		t.Errorf("GetStaticDependencies() after clearing = %+v, %+v, want none", upstreams, downstreams)
+	}
+}
+
+func Test_staticConnections(t *testing.T) {
+	dependencies := []StaticDependency{
+		{RemoteHostgroup: "nfs", RemoteAddress: "nfs.example", Port: "2049", Protocol: "tcp", ProcessName: "kernel"}, // nolint:exhaustivestruct
+		{RemoteAddress: "10.0.0.1", Port: "53", Protocol: "udp"},                                                     // nolint:exhaustivestruct
+		{LocalHostgroup: "other", RemoteHostgroup: "batch", Port: "443", Protocol: "tcp"},                            // nolint:exhaustivestruct
+	}
+	nfs := Connections{ // nolint:exhaustivestruct
+		LocalHostgroup: "app", RemoteHostgroup: "nfs", RemoteAddress: "nfs.example", Port: "2049", Protocol: "tcp", ProcessName: "kernel",
+	}
+	dns := Connections{LocalHostgroup: "app", RemoteAddress: "10.0.0.1", Port: "53", Protocol: "udp"}     // nolint:exhaustivestruct
+	batch := Connections{LocalHostgroup: "other", RemoteHostgroup: "batch", Port: "443", Protocol: "tcp"} // nolint:exhaustivestruct
+
+	tests := []struct {
+		name string
+		live []Connections
+		want []Connections
+	}{
+		{
+			name: "No live edges",
+			live: nil,
+			want: []Connections{nfs, dns, batch},
+		},
+		{
+			name: "Live edge to the same hostgroup wins",
+			live: []Connections{
+				{LocalHostgroup: "app", RemoteHostgroup: "nfs", RemoteAddress: "10.1.1.1", Port: "2049", Protocol: "tcp", ProcessName: "mount"}, // nolint:exhaustivestruct
+			},
+			want: []Connections{dns, batch},
+		},
+		{
+			name: "Live edge to the same address without a hostgroup wins",
+			live: []Connections{{LocalHostgroup: "app", RemoteAddress: "10.0.0.1", Port: "53", Protocol: "udp"}}, // nolint:exhaustivestruct
+			want: []Connections{nfs, batch},
+		},
+		{
+			name: "Live edge with another port or protocol doesn't conflict",
+			live: []Connections{
+				{LocalHostgroup: "app", RemoteHostgroup: "nfs", Port: "111", Protocol: "tcp"},   // nolint:exhaustivestruct
+				{LocalHostgroup: "app", RemoteAddress: "10.0.0.1", Port: "53", Protocol: "tcp"}, // nolint:exhaustivestruct
+			},
+			want: []Connections{nfs, dns, batch},
+		},
+		{
+			name: "Live edge of another local hostgroup doesn't conflict",
+			live: []Connections{{LocalHostgroup: "app", RemoteHostgroup: "batch", Port: "443", Protocol: "tcp"}}, // nolint:exhaustivestruct
+			want: []Connections{nfs, dns, batch},
+		},
+	}
+	for _, testcase := range tests {
+		t.Run(testcase.name, func(t *testing.T) {
+			if got := staticConnections(dependencies, testcase.live, "app"); !reflect.DeepEqual(got, testcase.want) {
+				t.Errorf("staticConnections() = %+v, want %+v", got, testcase.want)
+			}
+		})
+	}
+}
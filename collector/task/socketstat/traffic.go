@@ -0,0 +1,209 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package socketstat
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"planet-exporter/pkg/network"
+)
+
+// Traffic directions of the byte counters, from this machine's point of view.
+const (
+	trafficDirectionIngress = "ingress"
+	trafficDirectionEgress  = "egress"
+)
+
+// ErrInvalidPorts traffic ports aren't a comma-separated list of ports.
+var ErrInvalidPorts = errors.New("invalid ports")
+
+// ParsePorts parses comma-separated ports (e.g. "5432,6379"), ignoring duplicates.
+func ParsePorts(s string) ([]uint32, error) {
+	var ports []uint32
+	seen := make(map[uint32]bool)
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		port, err := strconv.ParseUint(field, 10, 16)
+		if err != nil || port == 0 {
+			return nil, fmt.Errorf("%w: %q isn't a port", ErrInvalidPorts, field)
+		}
+		if !seen[uint32(port)] {
+			seen[uint32(port)] = true
+			ports = append(ports, uint32(port))
+		}
+	}
+	if len(ports) == 0 {
+		return nil, fmt.Errorf("%w: no port in %q", ErrInvalidPorts, s)
+	}
+
+	return ports, nil
+}
+
+// Traffic is the TCP traffic with a remote hostgroup on a service port, summed from the kernel per-socket byte
+// counters. Upstream traffic has the RemotePort of the upstream service, and downstream traffic the LocalPort of
+// this machine's listening service.
+type Traffic struct {
+	LocalHostgroup  string
+	Direction       string // ingress/egress
+	RemoteHostgroup string
+	LocalPort       string
+	RemotePort      string
+	Bytes           float64
+}
+
+// trafficKey identifies the traffic series of a socket.
+type trafficKey struct {
+	localHostgroup  string
+	direction       string
+	remoteHostgroup string
+	localPort       string
+	remotePort      string
+}
+
+// socketBytes are the byte counters of a socket in the previous collection.
+type socketBytes struct {
+	acked    uint64
+	received uint64
+}
+
+// socketTraffic sums the byte counters of the sockets on the traffic ports into running totals per traffic series.
+// Sockets are identified by their cookie, and only their byte counter deltas since the previous collection are
+// added, so the totals don't drop when sockets close. It's not safe for concurrent use, the task's mutex protects it.
+type socketTraffic struct {
+	ports map[uint32]bool
+
+	// sockets are the byte counters of the counted sockets in the previous collection, by cookie
+	sockets map[uint64]socketBytes
+	// seeded is whether a collection has recorded the byte counters of the sockets open at startup
+	seeded bool
+	totals map[trafficKey]float64
+}
+
+// newSocketTraffic returns a socketTraffic of the sockets on the ports, without any traffic yet.
+func newSocketTraffic(ports []uint32) *socketTraffic {
+	portSet := make(map[uint32]bool, len(ports))
+	for _, port := range ports {
+		portSet[port] = true
+	}
+
+	return &socketTraffic{
+		ports:   portSet,
+		sockets: make(map[uint64]socketBytes),
+		seeded:  false,
+		totals:  make(map[trafficKey]float64),
+	}
+}
+
+// observe adds the byte counter deltas of the sockets on the traffic ports since the previous collection.
+// A socket whose local port is a listening port is downstream traffic, otherwise it's upstream traffic, like
+// classifyConnections. A socket seen for the first time adds its whole byte counters, except in the first
+// collection, which only records them so the traffic from before the exporter started isn't counted at once.
+// The bytes of a socket after the previous collection are lost if it closes before the next one.
+func (t *socketTraffic) observe(sockets []network.TCPSocketCounters, listeningPortsConns map[uint32]network.ListeningConnSocket,
+	localIP string, localLookup, remoteLookup inventoryLookupFunc) {
+	observed := make(map[uint64]socketBytes, len(t.sockets))
+	for _, socket := range sockets {
+		var localPort, remotePort string
+		if _, listening := listeningPortsConns[socket.LocalPort]; listening {
+			if !t.ports[socket.LocalPort] {
+				continue
+			}
+			localPort = fmt.Sprint(socket.LocalPort)
+		} else {
+			if !t.ports[socket.RemotePort] {
+				continue
+			}
+			remotePort = fmt.Sprint(socket.RemotePort)
+		}
+
+		socketLocalIP, socketRemoteIP := network.NormalizeIP(socket.LocalIP), network.NormalizeIP(socket.RemoteIP)
+		if socketLocalIP == "127.0.0.1" {
+			socketLocalIP = localIP
+		}
+		_, localHostgroup := localLookup(socketLocalIP)
+		remoteAddr, remoteHostgroup := remoteLookup(socketRemoteIP)
+		if remotePort != "" && remoteAddr == "localhost" {
+			continue
+		}
+
+		current := socketBytes{acked: socket.BytesAcked, received: socket.BytesReceived}
+		observed[socket.Cookie] = current
+
+		var delta socketBytes
+		previous, found := t.sockets[socket.Cookie]
+		switch {
+		case found && current.acked >= previous.acked && current.received >= previous.received:
+			delta = socketBytes{acked: current.acked - previous.acked, received: current.received - previous.received}
+		case found || t.seeded:
+			// A new socket, or a reused cookie whose counters restarted
+			delta = current
+		}
+
+		key := trafficKey{
+			localHostgroup:  localHostgroup,
+			remoteHostgroup: remoteHostgroup,
+			localPort:       localPort,
+			remotePort:      remotePort,
+		}
+		key.direction = trafficDirectionEgress
+		t.totals[key] += float64(delta.acked)
+		key.direction = trafficDirectionIngress
+		t.totals[key] += float64(delta.received)
+	}
+
+	t.sockets = observed
+	t.seeded = true
+}
+
+// get returns the running traffic totals, sorted by their labels.
+func (t *socketTraffic) get() []Traffic {
+	traffic := make([]Traffic, 0, len(t.totals))
+	for key, bytes := range t.totals {
+		traffic = append(traffic, Traffic{
+			LocalHostgroup:  key.localHostgroup,
+			Direction:       key.direction,
+			RemoteHostgroup: key.remoteHostgroup,
+			LocalPort:       key.localPort,
+			RemotePort:      key.remotePort,
+			Bytes:           bytes,
+		})
+	}
+	sort.Slice(traffic, func(i, j int) bool {
+		a, b := traffic[i], traffic[j]
+		if a.RemoteHostgroup != b.RemoteHostgroup {
+			return a.RemoteHostgroup < b.RemoteHostgroup
+		}
+		if a.LocalPort != b.LocalPort {
+			return a.LocalPort < b.LocalPort
+		}
+		if a.RemotePort != b.RemotePort {
+			return a.RemotePort < b.RemotePort
+		}
+		if a.Direction != b.Direction {
+			return a.Direction < b.Direction
+		}
+
+		return a.LocalHostgroup < b.LocalHostgroup
+	})
+
+	return traffic
+}
@@ -0,0 +1,201 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package socketstat
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"planet-exporter/pkg/network"
+)
+
+var errMockSocketCounters = errors.New("mock socket counters error")
+
+func TestParsePorts(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		want    []uint32
+		wantErr bool
+	}{
+		{name: "Ports", s: "5432, 6379", want: []uint32{5432, 6379}, wantErr: false},
+		{name: "Duplicates and empty fields are skipped", s: "80,,80,", want: []uint32{80}, wantErr: false},
+		{name: "No port", s: "", want: nil, wantErr: true},
+		{name: "Not a number", s: "http", want: nil, wantErr: true},
+		{name: "Port zero", s: "0", want: nil, wantErr: true},
+		{name: "Out of range", s: "65536", want: nil, wantErr: true},
+	}
+	for _, testcase := range tests {
+		t.Run(testcase.name, func(t *testing.T) {
+			got, err := ParsePorts(testcase.s)
+			if (err != nil) != testcase.wantErr {
+				t.Fatalf("ParsePorts() error = %v, wantErr %v", err, testcase.wantErr)
+			}
+			if testcase.wantErr && !errors.Is(err, ErrInvalidPorts) {
+				t.Errorf("ParsePorts() error = %v, want %v", err, ErrInvalidPorts)
+			}
+			if !reflect.DeepEqual(got, testcase.want) {
+				t.Errorf("ParsePorts() = %v, want %v", got, testcase.want)
+			}
+		})
+	}
+}
+
+// trafficSocket returns the byte counters of a socket from 10.0.0.1.
+func trafficSocket(cookie uint64, localPort uint32, remoteIP string, remotePort uint32, acked, received uint64) network.TCPSocketCounters {
+	return network.TCPSocketCounters{
+		LocalIP: "10.0.0.1", LocalPort: localPort, RemoteIP: remoteIP, RemotePort: remotePort,
+		Cookie: cookie, BytesAcked: acked, BytesReceived: received,
+	}
+}
+
+func Test_socketTraffic_observe(t *testing.T) {
+	lookup := mockInventoryLookup(map[string][2]string{
+		"10.0.0.1":  {"app.service.consul", "app"},
+		"10.0.0.2":  {"db.service.consul", "db"},
+		"10.0.0.3":  {"web.service.consul", "web"},
+		"127.0.0.1": {"localhost", ""},
+	})
+	listeningPortsConns := map[uint32]network.ListeningConnSocket{
+		80: {ProcessPid: 1, LocalPort: 80, LocalIP: "0.0.0.0", ProcessName: "nginx", ProcessStartTime: 1},
+	}
+	traffic := newSocketTraffic([]uint32{5432, 80})
+
+	collections := []struct {
+		name    string
+		sockets []network.TCPSocketCounters
+		want    []Traffic
+	}{
+		{
+			name: "Sockets open at startup are only recorded",
+			sockets: []network.TCPSocketCounters{
+				trafficSocket(1, 41000, "10.0.0.2", 5432, 100, 1000),
+				trafficSocket(2, 80, "10.0.0.3", 50000, 50, 5),
+				// Neither a traffic port nor a listening traffic port
+				trafficSocket(3, 41001, "10.0.0.4", 6379, 10, 10),
+			},
+			want: []Traffic{
+				{LocalHostgroup: "app", Direction: "egress", RemoteHostgroup: "db", RemotePort: "5432", Bytes: 0},
+				{LocalHostgroup: "app", Direction: "ingress", RemoteHostgroup: "db", RemotePort: "5432", Bytes: 0},
+				{LocalHostgroup: "app", Direction: "egress", RemoteHostgroup: "web", LocalPort: "80", Bytes: 0},
+				{LocalHostgroup: "app", Direction: "ingress", RemoteHostgroup: "web", LocalPort: "80", Bytes: 0},
+			},
+		},
+		{
+			name: "Open sockets add their deltas, new sockets their whole counters, and closed sockets keep their totals",
+			sockets: []network.TCPSocketCounters{
+				trafficSocket(1, 41000, "10.0.0.2", 5432, 300, 1500),
+				trafficSocket(4, 41002, "10.0.0.2", 5432, 10, 20),
+				// Loopback upstreams aren't counted, like their dependencies
+				{LocalIP: "127.0.0.1", LocalPort: 41003, RemoteIP: "127.0.0.1", RemotePort: 5432, Cookie: 6, BytesAcked: 99, BytesReceived: 99},
+			},
+			want: []Traffic{
+				{LocalHostgroup: "app", Direction: "egress", RemoteHostgroup: "db", RemotePort: "5432", Bytes: 210},
+				{LocalHostgroup: "app", Direction: "ingress", RemoteHostgroup: "db", RemotePort: "5432", Bytes: 520},
+				{LocalHostgroup: "app", Direction: "egress", RemoteHostgroup: "web", LocalPort: "80", Bytes: 0},
+				{LocalHostgroup: "app", Direction: "ingress", RemoteHostgroup: "web", LocalPort: "80", Bytes: 0},
+			},
+		},
+		{
+			name: "A socket accepted after a closed one",
+			sockets: []network.TCPSocketCounters{
+				trafficSocket(4, 41002, "10.0.0.2", 5432, 40, 20),
+				trafficSocket(5, 80, "10.0.0.3", 50001, 7, 3),
+			},
+			want: []Traffic{
+				{LocalHostgroup: "app", Direction: "egress", RemoteHostgroup: "db", RemotePort: "5432", Bytes: 240},
+				{LocalHostgroup: "app", Direction: "ingress", RemoteHostgroup: "db", RemotePort: "5432", Bytes: 520},
+				{LocalHostgroup: "app", Direction: "egress", RemoteHostgroup: "web", LocalPort: "80", Bytes: 7},
+				{LocalHostgroup: "app", Direction: "ingress", RemoteHostgroup: "web", LocalPort: "80", Bytes: 3},
+			},
+		},
+		{
+			name: "Counters of a reused cookie restart",
+			sockets: []network.TCPSocketCounters{
+				trafficSocket(4, 41004, "10.0.0.2", 5432, 5, 1),
+			},
+			want: []Traffic{
+				{LocalHostgroup: "app", Direction: "egress", RemoteHostgroup: "db", RemotePort: "5432", Bytes: 245},
+				{LocalHostgroup: "app", Direction: "ingress", RemoteHostgroup: "db", RemotePort: "5432", Bytes: 521},
+				{LocalHostgroup: "app", Direction: "egress", RemoteHostgroup: "web", LocalPort: "80", Bytes: 7},
+				{LocalHostgroup: "app", Direction: "ingress", RemoteHostgroup: "web", LocalPort: "80", Bytes: 3},
+			},
+		},
+	}
+	for _, collection := range collections {
+		traffic.observe(collection.sockets, listeningPortsConns, "10.0.0.1", lookup, lookup)
+		if got := traffic.get(); !reflect.DeepEqual(got, collection.want) {
+			t.Fatalf("%v: socketTraffic.get() = %+v, want %+v", collection.name, got, collection.want)
+		}
+	}
+}
+
+func TestCollect_traffic(t *testing.T) {
+	previousServerConnections, previousLocalIP, previousSocketCounters := serverConnections, localIP, socketCounters
+	defer func() {
+		serverConnections, localIP, socketCounters = previousServerConnections, previousLocalIP, previousSocketCounters
+		singleton.enabled = false
+		SetTrafficPorts(nil)
+	}()
+	localIP = func() (net.IP, error) { return net.ParseIP("10.0.0.1"), nil }
+	serverConnections = func(ctx context.Context) (network.ServerConnectionStat, error) {
+		return network.ServerConnectionStat{
+			PeeredConnSockets: []network.PeeredConnSocket{
+				{LocalIP: "10.0.0.1", LocalPort: 41000, RemoteIP: "10.0.0.2", RemotePort: 5432, Protocol: "tcp", ProcessName: "app"},
+			},
+		}, nil
+	}
+	InitTask(context.Background(), true, 1, 0, time.Hour, 100, time.Second)
+	SetTrafficPorts([]uint32{5432})
+
+	collections := []struct {
+		name      string
+		sockets   []network.TCPSocketCounters
+		err       error
+		wantBytes []float64 // egress and ingress
+	}{
+		{name: "Unreadable counters don't fail the collection", sockets: nil, err: errMockSocketCounters, wantBytes: []float64{}},
+		{name: "First collection", sockets: []network.TCPSocketCounters{trafficSocket(1, 41000, "10.0.0.2", 5432, 100, 200)}, err: nil, wantBytes: []float64{0, 0}},
+		{name: "Unreadable counters keep the previous counters", sockets: nil, err: errMockSocketCounters, wantBytes: []float64{0, 0}},
+		{name: "Next collection", sockets: []network.TCPSocketCounters{trafficSocket(1, 41000, "10.0.0.2", 5432, 150, 210)}, err: nil, wantBytes: []float64{50, 10}},
+	}
+	for _, collection := range collections {
+		socketCounters = func(ctx context.Context) ([]network.TCPSocketCounters, error) {
+			return collection.sockets, collection.err
+		}
+		if err := Collect(context.Background()); err != nil {
+			t.Fatalf("%v: Collect() error = %v", collection.name, err)
+		}
+		if _, upstreams, _ := Get(); len(upstreams) != 1 {
+			t.Errorf("%v: Get() upstreams = %v, want 1 upstream", collection.name, upstreams)
+		}
+		gotBytes := []float64{}
+		for _, traffic := range GetTraffic() {
+			gotBytes = append(gotBytes, traffic.Bytes)
+		}
+		if !reflect.DeepEqual(gotBytes, collection.wantBytes) {
+			t.Errorf("%v: GetTraffic() bytes = %v, want %v", collection.name, gotBytes, collection.wantBytes)
+		}
+	}
+
+	SetTrafficPorts(nil)
+	if got := GetTraffic(); len(got) != 0 {
+		t.Errorf("GetTraffic() = %v, want no traffic once disabled", got)
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package bigquery contains helpers shared by the BigQuery writers of pre-processed planet-exporter data.
+package bigquery
+
+import (
+	"encoding/json"
+
+	"cloud.google.com/go/bigquery"
+)
+
+const (
+	// DefaultMaxChunkRows is the default maximum rows per streaming insert request.
+	DefaultMaxChunkRows = 2000
+	// DefaultMaxChunkBytes is the default estimated size budget per streaming insert request,
+	// with headroom under the 10MB request size limit of BigQuery streaming inserts.
+	DefaultMaxChunkBytes = 9 * 1024 * 1024
+
+	// rowOverheadBytes is the estimated size of the request envelope of a row (e.g. {"insertId":"...","json":...}).
+	rowOverheadBytes = 64
+)
+
+// Chunk is the [Start, End) index range of rows inserted with a single request.
+type Chunk struct {
+	Start int
+	End   int
+}
+
+// Chunker splits rows into chunks that stay under a maximum number of rows and estimated request size.
+type Chunker struct {
+	// MaxRows per chunk, unlimited if zero
+	MaxRows int
+	// MaxBytes of estimated row sizes per chunk, unlimited if zero
+	MaxBytes int
+}
+
+// NewChunker returns a Chunker with the default limits.
+func NewChunker() Chunker {
+	return Chunker{
+		MaxRows:  DefaultMaxChunkRows,
+		MaxBytes: DefaultMaxChunkBytes,
+	}
+}
+
+// Chunks splits n rows into consecutive chunks, where rowSize returns the estimated size of the i-th row.
+// A row larger than MaxBytes on its own is put in its own chunk.
+func (c Chunker) Chunks(n int, rowSize func(i int) int) []Chunk {
+	chunks := []Chunk{}
+	start, chunkBytes := 0, 0
+	for i := 0; i < n; i++ {
+		size := rowSize(i)
+		full := (c.MaxRows > 0 && i-start >= c.MaxRows) || (c.MaxBytes > 0 && chunkBytes+size > c.MaxBytes)
+		if full && i > start {
+			chunks = append(chunks, Chunk{Start: start, End: i})
+			start, chunkBytes = i, 0
+		}
+		chunkBytes += size
+	}
+	if start < n {
+		chunks = append(chunks, Chunk{Start: start, End: n})
+	}
+
+	return chunks
+}
+
+// EstimateRowSize returns the estimated encoded size of a row in a streaming insert request.
+// Rows implementing bigquery.ValueSaver are estimated from their saved values, other rows from their JSON encoding.
+func EstimateRowSize(row interface{}) int {
+	if saver, ok := row.(bigquery.ValueSaver); ok {
+		values, _, err := saver.Save()
+		if err == nil {
+			row = values
+		}
+	}
+
+	encoded, err := json.Marshal(row)
+	if err != nil {
+		return rowOverheadBytes
+	}
+
+	return len(encoded) + rowOverheadBytes
+}
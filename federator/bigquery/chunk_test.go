@@ -0,0 +1,102 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bigquery
+
+import (
+	"reflect"
+	"testing"
+
+	"cloud.google.com/go/bigquery"
+)
+
+func TestChunker_Chunks(t *testing.T) {
+	tests := []struct {
+		name    string
+		chunker Chunker
+		sizes   []int
+		want    []Chunk
+	}{
+		{
+			name:    "No rows",
+			chunker: Chunker{MaxRows: 2, MaxBytes: 100},
+			sizes:   []int{},
+			want:    []Chunk{},
+		},
+		{
+			name:    "Row limit",
+			chunker: Chunker{MaxRows: 2, MaxBytes: 0},
+			sizes:   []int{10, 10, 10, 10, 10},
+			want:    []Chunk{{Start: 0, End: 2}, {Start: 2, End: 4}, {Start: 4, End: 5}},
+		},
+		{
+			name:    "Byte limit with a chunk of exactly the budget",
+			chunker: Chunker{MaxRows: 0, MaxBytes: 30},
+			sizes:   []int{10, 20, 10, 25},
+			want:    []Chunk{{Start: 0, End: 2}, {Start: 2, End: 3}, {Start: 3, End: 4}},
+		},
+		{
+			name:    "Row larger than the byte limit",
+			chunker: Chunker{MaxRows: 10, MaxBytes: 30},
+			sizes:   []int{10, 50, 10},
+			want:    []Chunk{{Start: 0, End: 1}, {Start: 1, End: 2}, {Start: 2, End: 3}},
+		},
+		{
+			name:    "Unlimited",
+			chunker: Chunker{MaxRows: 0, MaxBytes: 0},
+			sizes:   []int{10, 50, 10},
+			want:    []Chunk{{Start: 0, End: 3}},
+		},
+	}
+	for _, testcase := range tests {
+		t.Run(testcase.name, func(t *testing.T) {
+			got := testcase.chunker.Chunks(len(testcase.sizes), func(i int) int { return testcase.sizes[i] })
+			if !reflect.DeepEqual(got, testcase.want) {
+				t.Errorf("Chunker.Chunks() = %v, want %v", got, testcase.want)
+			}
+		})
+	}
+}
+
+// mockSaver is a row saved with a different column name than its JSON encoding.
+type mockSaver struct {
+	Value string
+}
+
+func (m mockSaver) Save() (map[string]bigquery.Value, string, error) {
+	return map[string]bigquery.Value{"a_much_longer_column_name": m.Value}, "", nil
+}
+
+func TestEstimateRowSize(t *testing.T) {
+	type row struct {
+		Hostgroup string `bigquery:"hostgroup"`
+	}
+
+	tests := []struct {
+		name string
+		row  interface{}
+		want int
+	}{
+		{name: "Struct", row: row{Hostgroup: "myapp"}, want: len(`{"Hostgroup":"myapp"}`) + rowOverheadBytes},
+		{name: "ValueSaver", row: mockSaver{Value: "myapp"}, want: len(`{"a_much_longer_column_name":"myapp"}`) + rowOverheadBytes},
+		{name: "Unencodable", row: func() {}, want: rowOverheadBytes},
+	}
+	for _, testcase := range tests {
+		t.Run(testcase.name, func(t *testing.T) {
+			if got := EstimateRowSize(testcase.row); got != testcase.want {
+				t.Errorf("EstimateRowSize() = %v, want %v", got, testcase.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bigquery
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+)
+
+// DeadLetter records rows rejected by BigQuery streaming inserts as NDJSON, for later inspection or reprocessing.
+// A nil DeadLetter is disabled. It's safe for concurrent use.
+type DeadLetter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// deadLetterRecord is a line of the dead letter.
+type deadLetterRecord struct {
+	Table      string      `json:"table"`
+	RejectedAt time.Time   `json:"rejected_at"`
+	Errors     []string    `json:"errors"`
+	Row        interface{} `json:"row"`
+}
+
+// NewDeadLetter returns a DeadLetter writing to w.
+func NewDeadLetter(w io.Writer) *DeadLetter {
+	return &DeadLetter{mu: sync.Mutex{}, w: w}
+}
+
+// OpenDeadLetterFile returns a DeadLetter appending to the file at path, created if missing.
+// The caller closes the returned file.
+func OpenDeadLetterFile(path string) (*DeadLetter, *os.File, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error opening dead letter file: %w", err)
+	}
+
+	return NewDeadLetter(file), file, nil
+}
+
+// Write records the rows of a streaming insert request rejected in putErr, where row returns the i-th row of the
+// request. Rows implementing bigquery.ValueSaver are recorded as their saved values.
+// It returns the number of rows written.
+func (d *DeadLetter) Write(table string, putErr bigquery.PutMultiError, row func(i int) interface{}, now time.Time) (int, error) {
+	if d == nil {
+		return 0, nil
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	encoder := json.NewEncoder(d.w)
+	written := 0
+	for _, rowErr := range putErr {
+		record := deadLetterRecord{
+			Table:      table,
+			RejectedAt: now,
+			Errors:     []string{},
+			Row:        deadLetterRow(row(rowErr.RowIndex)),
+		}
+		for _, err := range rowErr.Errors {
+			record.Errors = append(record.Errors, err.Error())
+		}
+		if err := encoder.Encode(record); err != nil {
+			return written, fmt.Errorf("error writing dead letter row %d: %w", rowErr.RowIndex, err)
+		}
+		written++
+	}
+
+	return written, nil
+}
+
+// deadLetterRow returns the saved values of a bigquery.ValueSaver row, so the record matches the inserted columns.
+func deadLetterRow(row interface{}) interface{} {
+	if saver, ok := row.(bigquery.ValueSaver); ok {
+		if values, _, err := saver.Save(); err == nil {
+			return values
+		}
+	}
+
+	return row
+}
@@ -0,0 +1,87 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bigquery
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+)
+
+func TestDeadLetter_Write(t *testing.T) {
+	rows := []mockSaver{{Value: "a"}, {Value: "b"}, {Value: "c"}}
+	putErr := bigquery.PutMultiError{
+		{InsertID: "", RowIndex: 0, Errors: bigquery.MultiError{errors.New("invalid value")}},
+		{InsertID: "", RowIndex: 2, Errors: bigquery.MultiError{errors.New("no such field"), errors.New("stopped")}},
+	}
+	now := time.Date(2021, 6, 1, 10, 0, 0, 0, time.UTC)
+
+	var buf bytes.Buffer
+	got, err := NewDeadLetter(&buf).Write("project.dataset.traffic", putErr, func(i int) interface{} { return rows[i] }, now)
+	if err != nil {
+		t.Fatalf("DeadLetter.Write() error = %v", err)
+	}
+	if got != 2 {
+		t.Errorf("DeadLetter.Write() = %v, want %v", got, 2)
+	}
+
+	want := `{"table":"project.dataset.traffic","rejected_at":"2021-06-01T10:00:00Z","errors":["invalid value"],"row":{"a_much_longer_column_name":"a"}}
+{"table":"project.dataset.traffic","rejected_at":"2021-06-01T10:00:00Z","errors":["no such field","stopped"],"row":{"a_much_longer_column_name":"c"}}
+`
+	if buf.String() != want {
+		t.Errorf("DeadLetter.Write() wrote %v, want %v", buf.String(), want)
+	}
+}
+
+func TestDeadLetter_disabled(t *testing.T) {
+	var deadLetter *DeadLetter
+	putErr := bigquery.PutMultiError{{InsertID: "", RowIndex: 0, Errors: nil}}
+
+	got, err := deadLetter.Write("traffic", putErr, func(i int) interface{} { return nil }, time.Now())
+	if got != 0 || err != nil {
+		t.Errorf("DeadLetter.Write() = %v, %v, want 0, nil", got, err)
+	}
+}
+
+func TestOpenDeadLetterFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dead-letter.ndjson")
+	putErr := bigquery.PutMultiError{{InsertID: "", RowIndex: 0, Errors: nil}}
+
+	// The file is appended to across runs
+	for i := 0; i < 2; i++ {
+		deadLetter, file, err := OpenDeadLetterFile(path)
+		if err != nil {
+			t.Fatalf("OpenDeadLetterFile() error = %v", err)
+		}
+		if _, err := deadLetter.Write("traffic", putErr, func(i int) interface{} { return mockSaver{Value: "a"} }, time.Now()); err != nil {
+			t.Fatalf("DeadLetter.Write() error = %v", err)
+		}
+		file.Close()
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("os.ReadFile() error = %v", err)
+	}
+	if got := strings.Count(string(content), "\n"); got != 2 {
+		t.Errorf("dead letter lines = %v, want %v", got, 2)
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bigquery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrInsertInterrupted the context was done before every chunk was inserted.
+var ErrInsertInterrupted = errors.New("insert interrupted")
+
+// Progress of a chunked insert.
+type Progress struct {
+	Chunks        int
+	ChunksWritten int
+	Rows          int
+	RowsWritten   int
+}
+
+// ChunksRemaining returns the number of chunks not inserted yet.
+func (p Progress) ChunksRemaining() int {
+	return p.Chunks - p.ChunksWritten
+}
+
+// RowOffset returns the index of the first row not inserted yet, from which an interrupted insert can be resumed.
+func (p Progress) RowOffset() int {
+	return p.RowsWritten
+}
+
+// InsertChunks calls put with every chunk in order, and stops at the first error. The context is checked between
+// chunks, so a canceled insert stops early with ErrInsertInterrupted instead of going through the remaining chunks.
+// It returns the progress of the insert, including the chunks written before an error.
+func InsertChunks(ctx context.Context, chunks []Chunk, put func(ctx context.Context, chunk Chunk) error) (Progress, error) {
+	progress := Progress{Chunks: len(chunks), ChunksWritten: 0, Rows: 0, RowsWritten: 0}
+	for _, chunk := range chunks {
+		progress.Rows += chunk.End - chunk.Start
+	}
+
+	for _, chunk := range chunks {
+		if err := ctx.Err(); err != nil {
+			return progress, fmt.Errorf("%w after %d/%d chunks (%d remaining), resume from row offset %d: %v",
+				ErrInsertInterrupted, progress.ChunksWritten, progress.Chunks, progress.ChunksRemaining(), progress.RowOffset(), err)
+		}
+		if err := put(ctx, chunk); err != nil {
+			return progress, err
+		}
+		progress.ChunksWritten++
+		progress.RowsWritten += chunk.End - chunk.Start
+	}
+
+	return progress, nil
+}
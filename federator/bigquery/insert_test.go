@@ -0,0 +1,120 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bigquery
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var errMockPut = errors.New("mock put error")
+
+// fakeInserter records the rows put, and cancels the insert after cancelAfter puts (never if zero).
+type fakeInserter struct {
+	rows        []int
+	puts        int
+	cancelAfter int
+	cancel      context.CancelFunc
+	putErr      error
+}
+
+func (f *fakeInserter) Put(ctx context.Context, src interface{}) error {
+	if f.putErr != nil {
+		return f.putErr
+	}
+	f.rows = append(f.rows, src.([]int)...)
+	f.puts++
+	if f.puts == f.cancelAfter {
+		f.cancel()
+	}
+
+	return nil
+}
+
+func TestInsertChunks(t *testing.T) {
+	rows := []int{0, 1, 2, 3, 4, 5, 6}
+	chunks := Chunker{MaxRows: 2}.Chunks(len(rows), func(i int) int { return 1 }) // nolint:exhaustivestruct
+
+	tests := []struct {
+		name         string
+		inserter     *fakeInserter
+		wantRows     []int
+		wantProgress Progress
+		wantErr      error
+	}{
+		{
+			name:         "Every chunk",
+			inserter:     &fakeInserter{}, // nolint:exhaustivestruct
+			wantRows:     rows,
+			wantProgress: Progress{Chunks: 4, ChunksWritten: 4, Rows: 7, RowsWritten: 7},
+			wantErr:      nil,
+		},
+		{
+			name:         "Canceled mid-loop",
+			inserter:     &fakeInserter{cancelAfter: 2}, // nolint:exhaustivestruct
+			wantRows:     []int{0, 1, 2, 3},
+			wantProgress: Progress{Chunks: 4, ChunksWritten: 2, Rows: 7, RowsWritten: 4},
+			wantErr:      ErrInsertInterrupted,
+		},
+		{
+			name:         "Put error",
+			inserter:     &fakeInserter{putErr: errMockPut}, // nolint:exhaustivestruct
+			wantRows:     nil,
+			wantProgress: Progress{Chunks: 4, ChunksWritten: 0, Rows: 7, RowsWritten: 0},
+			wantErr:      errMockPut,
+		},
+	}
+	for _, testcase := range tests {
+		t.Run(testcase.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			testcase.inserter.cancel = cancel
+
+			got, err := InsertChunks(ctx, chunks, func(ctx context.Context, chunk Chunk) error {
+				return testcase.inserter.Put(ctx, rows[chunk.Start:chunk.End])
+			})
+			if !errors.Is(err, testcase.wantErr) {
+				t.Fatalf("InsertChunks() error = %v, want %v", err, testcase.wantErr)
+			}
+			if !reflect.DeepEqual(got, testcase.wantProgress) {
+				t.Errorf("InsertChunks() = %+v, want %+v", got, testcase.wantProgress)
+			}
+			if !reflect.DeepEqual(testcase.inserter.rows, testcase.wantRows) {
+				t.Errorf("InsertChunks() put rows %v, want %v", testcase.inserter.rows, testcase.wantRows)
+			}
+		})
+	}
+}
+
+func TestInsertChunks_interruptedSummary(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	inserter := &fakeInserter{cancelAfter: 1, cancel: cancel} // nolint:exhaustivestruct
+	chunks := []Chunk{{Start: 0, End: 2}, {Start: 2, End: 3}, {Start: 3, End: 5}}
+
+	progress, err := InsertChunks(ctx, chunks, func(ctx context.Context, chunk Chunk) error {
+		return inserter.Put(ctx, make([]int, chunk.End-chunk.Start))
+	})
+	if progress.ChunksRemaining() != 2 || progress.RowOffset() != 2 {
+		t.Errorf("InsertChunks() progress = %+v, want 2 chunks remaining from row offset 2", progress)
+	}
+	for _, want := range []string{"after 1/3 chunks (2 remaining)", "resume from row offset 2", context.Canceled.Error()} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("InsertChunks() error = %v, want it to contain %q", err, want)
+		}
+	}
+}
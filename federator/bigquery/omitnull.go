@@ -0,0 +1,73 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bigquery
+
+import (
+	"fmt"
+	"reflect"
+
+	"cloud.google.com/go/bigquery"
+)
+
+// OmitNullValues returns a row that saves the values of row without its NULL values, so they're left out of the
+// streaming insert request instead of being sent as JSON nulls. BigQuery stores a missing NULLABLE column as NULL,
+// so the inserted data is the same with a smaller request. Only top-level values are omitted, not the NULL fields
+// of a RECORD column. Rows not implementing bigquery.ValueSaver are saved with their inferred schema.
+func OmitNullValues(row interface{}) bigquery.ValueSaver {
+	return nullOmittingRow{row: row}
+}
+
+// nullOmittingRow is a row saved without its NULL values.
+type nullOmittingRow struct {
+	row interface{}
+}
+
+// Save implements bigquery.ValueSaver.
+func (r nullOmittingRow) Save() (map[string]bigquery.Value, string, error) {
+	saver, ok := r.row.(bigquery.ValueSaver)
+	if !ok {
+		schema, err := bigquery.InferSchema(r.row)
+		if err != nil {
+			return nil, "", fmt.Errorf("error inferring the schema of %T: %w", r.row, err)
+		}
+		saver = &bigquery.StructSaver{Schema: schema, InsertID: "", Struct: r.row}
+	}
+
+	values, insertID, err := saver.Save()
+	if err != nil {
+		return nil, "", err
+	}
+	for name, value := range values {
+		if isNullValue(value) {
+			delete(values, name)
+		}
+	}
+
+	return values, insertID, nil
+}
+
+// isNullValue returns whether a saved value is encoded as a JSON null, i.e. nil or an invalid bigquery.NullXxx.
+func isNullValue(value bigquery.Value) bool {
+	if value == nil {
+		return true
+	}
+	v := reflect.ValueOf(value)
+	if v.Kind() != reflect.Struct {
+		return false
+	}
+	valid := v.FieldByName("Valid")
+
+	return valid.IsValid() && valid.Kind() == reflect.Bool && !valid.Bool()
+}
@@ -0,0 +1,94 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bigquery
+
+import (
+	"reflect"
+	"testing"
+
+	"cloud.google.com/go/bigquery"
+)
+
+type omitNullStructRow struct {
+	Name    string              `bigquery:"name"`
+	Address bigquery.NullString `bigquery:"address"`
+	Port    bigquery.NullInt64  `bigquery:"port"`
+}
+
+type omitNullSaverRow map[string]bigquery.Value
+
+func (r omitNullSaverRow) Save() (map[string]bigquery.Value, string, error) {
+	values := map[string]bigquery.Value{}
+	for name, value := range r {
+		values[name] = value
+	}
+
+	return values, "id", nil
+}
+
+func TestOmitNullValues(t *testing.T) {
+	tests := []struct {
+		name         string
+		row          interface{}
+		want         map[string]bigquery.Value
+		wantInsertID string
+	}{
+		{
+			name: "Struct row",
+			row: omitNullStructRow{
+				Name:    "svc-a",
+				Address: bigquery.NullString{StringVal: "", Valid: false},
+				Port:    bigquery.NullInt64{Int64: 443, Valid: true},
+			},
+			want:         map[string]bigquery.Value{"name": "svc-a", "port": bigquery.NullInt64{Int64: 443, Valid: true}},
+			wantInsertID: "",
+		},
+		{
+			name: "ValueSaver row",
+			row: omitNullSaverRow{
+				"name":    "svc-a",
+				"address": bigquery.NullString{StringVal: "", Valid: false},
+				"port":    nil,
+				"bytes":   int64(0),
+				"empty":   "",
+			},
+			want:         map[string]bigquery.Value{"name": "svc-a", "bytes": int64(0), "empty": ""},
+			wantInsertID: "id",
+		},
+	}
+	for _, testcase := range tests {
+		t.Run(testcase.name, func(t *testing.T) {
+			got, insertID, err := OmitNullValues(testcase.row).Save()
+			if err != nil {
+				t.Fatalf("OmitNullValues().Save() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, testcase.want) {
+				t.Errorf("OmitNullValues().Save() = %v, want %v", got, testcase.want)
+			}
+			if insertID != testcase.wantInsertID {
+				t.Errorf("OmitNullValues().Save() insertID = %q, want %q", insertID, testcase.wantInsertID)
+			}
+		})
+	}
+}
+
+func TestOmitNullValues_estimatedSize(t *testing.T) {
+	row := omitNullStructRow{ // nolint:exhaustivestruct
+		Name: "svc-a",
+	}
+	if got, full := EstimateRowSize(OmitNullValues(row)), EstimateRowSize(row); got >= full {
+		t.Errorf("EstimateRowSize(OmitNullValues()) = %v, want less than %v", got, full)
+	}
+}
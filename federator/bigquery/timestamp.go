@@ -0,0 +1,60 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bigquery
+
+import (
+	"fmt"
+	"time"
+)
+
+// TimestampTruncation is the granularity the inventory_date of inserted rows is truncated to, so daily rollups
+// see fewer distinct timestamps.
+type TimestampTruncation string
+
+// Timestamp truncations.
+const (
+	TruncateNone   TimestampTruncation = "none"
+	TruncateMinute TimestampTruncation = "minute"
+	TruncateHour   TimestampTruncation = "hour"
+	TruncateDay    TimestampTruncation = "day"
+)
+
+// ParseTimestampTruncation parses a timestamp truncation, one of none, minute, hour, or day.
+func ParseTimestampTruncation(truncation string) (TimestampTruncation, error) {
+	switch t := TimestampTruncation(truncation); t {
+	case TruncateNone, TruncateMinute, TruncateHour, TruncateDay:
+		return t, nil
+	}
+
+	return "", fmt.Errorf("invalid timestamp truncation %q, expected one of %v, %v, %v, or %v",
+		truncation, TruncateNone, TruncateMinute, TruncateHour, TruncateDay)
+}
+
+// Truncate returns t truncated to the start of its minute, hour, or day in t's location.
+// Unknown truncations keep t as it is.
+func (tr TimestampTruncation) Truncate(t time.Time) time.Time {
+	year, month, day := t.Date()
+	switch tr {
+	case TruncateMinute:
+		return time.Date(year, month, day, t.Hour(), t.Minute(), 0, 0, t.Location())
+	case TruncateHour:
+		return time.Date(year, month, day, t.Hour(), 0, 0, 0, t.Location())
+	case TruncateDay:
+		return time.Date(year, month, day, 0, 0, 0, 0, t.Location())
+	case TruncateNone:
+	}
+
+	return t
+}
@@ -0,0 +1,70 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bigquery
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTimestampTruncation(t *testing.T) {
+	tests := []struct {
+		truncation string
+		want       TimestampTruncation
+		wantErr    bool
+	}{
+		{truncation: "none", want: TruncateNone},
+		{truncation: "minute", want: TruncateMinute},
+		{truncation: "hour", want: TruncateHour},
+		{truncation: "day", want: TruncateDay},
+		{truncation: "week", wantErr: true},
+		{truncation: "", wantErr: true},
+	}
+	for _, testcase := range tests {
+		t.Run(testcase.truncation, func(t *testing.T) {
+			got, err := ParseTimestampTruncation(testcase.truncation)
+			if (err != nil) != testcase.wantErr {
+				t.Fatalf("ParseTimestampTruncation() error = %v, wantErr %v", err, testcase.wantErr)
+			}
+			if got != testcase.want {
+				t.Errorf("ParseTimestampTruncation() = %v, want %v", got, testcase.want)
+			}
+		})
+	}
+}
+
+func TestTimestampTruncation_Truncate(t *testing.T) {
+	jakarta := time.FixedZone("WIB", 7*60*60)
+	timestamp := time.Date(2021, 6, 1, 2, 34, 56, 789000000, jakarta)
+
+	tests := []struct {
+		truncation TimestampTruncation
+		want       time.Time
+	}{
+		{truncation: TruncateNone, want: timestamp},
+		{truncation: TruncateMinute, want: time.Date(2021, 6, 1, 2, 34, 0, 0, jakarta)},
+		{truncation: TruncateHour, want: time.Date(2021, 6, 1, 2, 0, 0, 0, jakarta)},
+		// The day starts in the timestamp's location, not in UTC
+		{truncation: TruncateDay, want: time.Date(2021, 6, 1, 0, 0, 0, 0, jakarta)},
+		{truncation: "", want: timestamp},
+	}
+	for _, testcase := range tests {
+		t.Run(string(testcase.truncation), func(t *testing.T) {
+			if got := testcase.truncation.Truncate(timestamp); !got.Equal(testcase.want) {
+				t.Errorf("TimestampTruncation.Truncate() = %v, want %v", got, testcase.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,197 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package federator
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// circuitState is the state of a circuit breaker.
+type circuitState int
+
+const (
+	// circuitClosed lets every call through to the backend.
+	circuitClosed circuitState = iota
+	// circuitOpen fails every call fast until the cooldown period is over.
+	circuitOpen
+	// circuitHalfOpen lets a single probe call through to decide whether to close or re-open the circuit.
+	circuitHalfOpen
+)
+
+func (c circuitState) String() string {
+	switch c {
+	case circuitClosed:
+		return "closed"
+	case circuitOpen:
+		return "open"
+	case circuitHalfOpen:
+		return "half-open"
+	}
+
+	return "unknown"
+}
+
+// ErrCircuitOpen is returned when the backend call is rejected by an open circuit breaker.
+var ErrCircuitOpen = errors.New("backend circuit breaker is open")
+
+// CircuitBreakerBackend wraps a Backend with a circuit breaker.
+// After failureThreshold consecutive failures, the circuit opens and every call fails fast for the cooldown period.
+// Afterwards, a single probe call is let through: the circuit closes if it succeeds, or re-opens otherwise.
+type CircuitBreakerBackend struct {
+	backend          Backend
+	failureThreshold int
+	cooldown         time.Duration
+	now              func() time.Time
+
+	mu                  sync.Mutex
+	state               circuitState
+	consecutiveFailures int
+	openedAt            time.Time
+	probing             bool
+}
+
+// NewCircuitBreakerBackend returns a Backend guarded by a circuit breaker.
+func NewCircuitBreakerBackend(b Backend, failureThreshold int, cooldown time.Duration) *CircuitBreakerBackend {
+	circuitBreakerState.Set(float64(circuitClosed))
+
+	return &CircuitBreakerBackend{
+		backend:          b,
+		failureThreshold: failureThreshold,
+		cooldown:         cooldown,
+		now:              time.Now,
+		mu:               sync.Mutex{},
+		state:            circuitClosed,
+	}
+}
+
+// setState transitions the circuit breaker state, caller must hold the lock.
+func (c *CircuitBreakerBackend) setState(state circuitState) {
+	if c.state == state {
+		return
+	}
+
+	log.Warnf("Federator backend circuit breaker state changed from %v to %v", c.state, state)
+	c.state = state
+	circuitBreakerState.Set(float64(state))
+}
+
+// allow returns nil if the call may proceed to the backend.
+func (c *CircuitBreakerBackend) allow() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch c.state {
+	case circuitClosed:
+		return nil
+
+	case circuitOpen:
+		if c.now().Sub(c.openedAt) < c.cooldown {
+			break
+		}
+		c.setState(circuitHalfOpen)
+		c.probing = true
+
+		return nil
+
+	case circuitHalfOpen:
+		// Only a single probe is allowed at a time
+		if !c.probing {
+			c.probing = true
+
+			return nil
+		}
+	}
+
+	circuitBreakerRejectedTotal.Inc()
+
+	return ErrCircuitOpen
+}
+
+// record updates the circuit breaker state with the result of a backend call.
+func (c *CircuitBreakerBackend) record(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.probing = false
+
+	if err == nil {
+		c.consecutiveFailures = 0
+		c.setState(circuitClosed)
+
+		return
+	}
+
+	c.consecutiveFailures++
+	if c.state == circuitHalfOpen || c.consecutiveFailures >= c.failureThreshold {
+		c.openedAt = c.now()
+		c.setState(circuitOpen)
+	}
+}
+
+// do calls f through the circuit breaker.
+func (c *CircuitBreakerBackend) do(f func() error) error {
+	if err := c.allow(); err != nil {
+		return err
+	}
+
+	err := f()
+	if errors.Is(err, ErrUnknownTrafficDirection) {
+		// A rejected row isn't a backend failure
+		c.record(nil)
+
+		return err
+	}
+	c.record(err)
+
+	return err
+}
+
+// AddTrafficBandwidthData implements Backend interface.
+func (c *CircuitBreakerBackend) AddTrafficBandwidthData(ctx context.Context, trafficBandwidth TrafficBandwidth, t time.Time) error {
+	return c.do(func() error {
+		return c.backend.AddTrafficBandwidthData(ctx, trafficBandwidth, t)
+	})
+}
+
+// AddUpstreamService implements Backend interface.
+func (c *CircuitBreakerBackend) AddUpstreamService(ctx context.Context, upstreamService UpstreamService, t time.Time) error {
+	return c.do(func() error {
+		return c.backend.AddUpstreamService(ctx, upstreamService, t)
+	})
+}
+
+// AddDownstreamService implements Backend interface.
+func (c *CircuitBreakerBackend) AddDownstreamService(ctx context.Context, downstreamService DownstreamService, t time.Time) error {
+	return c.do(func() error {
+		return c.backend.AddDownstreamService(ctx, downstreamService, t)
+	})
+}
+
+// AddCollectorHealth implements Backend interface.
+func (c *CircuitBreakerBackend) AddCollectorHealth(ctx context.Context, collectorHealth CollectorHealth, t time.Time) error {
+	return c.do(func() error {
+		return c.backend.AddCollectorHealth(ctx, collectorHealth, t)
+	})
+}
+
+// Flush implements Backend interface.
+func (c *CircuitBreakerBackend) Flush() {
+	c.backend.Flush()
+}
@@ -0,0 +1,156 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package federator
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+var errMockBackend = errors.New("mock backend error")
+
+// mockBackend is a Backend that fails while err is set, and counts the calls it received.
+type mockBackend struct {
+	err   error
+	calls int
+}
+
+func (m *mockBackend) AddTrafficBandwidthData(context.Context, TrafficBandwidth, time.Time) error {
+	m.calls++
+
+	return m.err
+}
+
+func (m *mockBackend) AddUpstreamService(context.Context, UpstreamService, time.Time) error {
+	m.calls++
+
+	return m.err
+}
+
+func (m *mockBackend) AddDownstreamService(context.Context, DownstreamService, time.Time) error {
+	m.calls++
+
+	return m.err
+}
+
+func (m *mockBackend) AddCollectorHealth(context.Context, CollectorHealth, time.Time) error {
+	m.calls++
+
+	return m.err
+}
+
+func (m *mockBackend) Flush() {}
+
+func TestCircuitBreakerBackend(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(0, 0)
+	backend := &mockBackend{err: errMockBackend}
+
+	circuitBreaker := NewCircuitBreakerBackend(backend, 3, time.Minute)
+	circuitBreaker.now = func() time.Time { return now }
+
+	addUpstream := func() error {
+		return circuitBreaker.AddUpstreamService(ctx, UpstreamService{}, now) // nolint:exhaustivestruct
+	}
+	assertState := func(want circuitState, wantCalls int) {
+		t.Helper()
+		if circuitBreaker.state != want {
+			t.Errorf("CircuitBreakerBackend state = %v, want %v", circuitBreaker.state, want)
+		}
+		if backend.calls != wantCalls {
+			t.Errorf("CircuitBreakerBackend backend calls = %v, want %v", backend.calls, wantCalls)
+		}
+	}
+
+	// Closed: failures below the threshold are passed through
+	for i := 0; i < 2; i++ {
+		if err := addUpstream(); !errors.Is(err, errMockBackend) {
+			t.Errorf("CircuitBreakerBackend error = %v, want %v", err, errMockBackend)
+		}
+	}
+	assertState(circuitClosed, 2)
+
+	// Closed -> Open: threshold reached
+	_ = addUpstream()
+	assertState(circuitOpen, 3)
+
+	// Open: fail fast during cooldown
+	now = now.Add(30 * time.Second)
+	if err := addUpstream(); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("CircuitBreakerBackend error = %v, want %v", err, ErrCircuitOpen)
+	}
+	assertState(circuitOpen, 3)
+
+	// Open -> Half-open -> Open: probe after cooldown fails
+	now = now.Add(time.Minute)
+	if err := addUpstream(); !errors.Is(err, errMockBackend) {
+		t.Errorf("CircuitBreakerBackend error = %v, want %v", err, errMockBackend)
+	}
+	assertState(circuitOpen, 4)
+	if err := addUpstream(); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("CircuitBreakerBackend error = %v, want %v", err, ErrCircuitOpen)
+	}
+	assertState(circuitOpen, 4)
+
+	// Open -> Half-open -> Closed: probe after cooldown succeeds
+	now = now.Add(time.Minute)
+	backend.err = nil
+	if err := addUpstream(); err != nil {
+		t.Errorf("CircuitBreakerBackend error = %v, want nil", err)
+	}
+	assertState(circuitClosed, 5)
+
+	// Closed: a success resets consecutive failures
+	backend.err = errMockBackend
+	_ = addUpstream()
+	_ = addUpstream()
+	backend.err = nil
+	_ = addUpstream()
+	backend.err = errMockBackend
+	_ = addUpstream()
+	assertState(circuitClosed, 9)
+}
+
+func TestCircuitBreakerBackend_halfOpenSingleProbe(t *testing.T) {
+	now := time.Unix(0, 0)
+	circuitBreaker := NewCircuitBreakerBackend(&mockBackend{err: errMockBackend}, 1, time.Minute)
+	circuitBreaker.now = func() time.Time { return now }
+	circuitBreaker.record(errMockBackend)
+
+	now = now.Add(time.Minute)
+	if err := circuitBreaker.allow(); err != nil {
+		t.Errorf("CircuitBreakerBackend.allow() first probe error = %v, want nil", err)
+	}
+	if err := circuitBreaker.allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("CircuitBreakerBackend.allow() concurrent probe error = %v, want %v", err, ErrCircuitOpen)
+	}
+}
+
+func TestCircuitBreakerBackend_rejectedRowIsNotAFailure(t *testing.T) {
+	backend := &mockBackend{err: ErrUnknownTrafficDirection}
+	circuitBreaker := NewCircuitBreakerBackend(backend, 1, time.Minute)
+
+	for i := 0; i < 3; i++ {
+		err := circuitBreaker.AddTrafficBandwidthData(context.Background(), TrafficBandwidth{}, time.Now()) // nolint:exhaustivestruct
+		if !errors.Is(err, ErrUnknownTrafficDirection) {
+			t.Errorf("CircuitBreakerBackend error = %v, want %v", err, ErrUnknownTrafficDirection)
+		}
+	}
+	if circuitBreaker.state != circuitClosed {
+		t.Errorf("CircuitBreakerBackend state = %v, want %v", circuitBreaker.state, circuitClosed)
+	}
+}
@@ -0,0 +1,124 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package federator
+
+import (
+	"sync"
+	"time"
+)
+
+// DependencyDelta remembers the dependency edges written by the previous job run, so a run only writes the edges
+// that are new or changed since then. Every resyncInterval, a run writes all edges again so the backend
+// catches up with edges it missed (e.g. written before a federator restart).
+// A nil DependencyDelta disables delta mode: every run writes every edge.
+type DependencyDelta struct {
+	resyncInterval time.Duration
+
+	mu sync.Mutex
+	// written edges of the previous run, an edge is any comparable value (e.g. UpstreamService)
+	written map[interface{}]bool
+	// lastResync is when the last full resync run was done, zero forces the next run to resync
+	lastResync time.Time
+}
+
+// NewDependencyDelta returns a DependencyDelta that resyncs every resyncInterval, its first run is a full resync.
+func NewDependencyDelta(resyncInterval time.Duration) *DependencyDelta {
+	return &DependencyDelta{
+		resyncInterval: resyncInterval,
+		mu:             sync.Mutex{},
+		written:        make(map[interface{}]bool),
+		lastResync:     time.Time{},
+	}
+}
+
+// Begin starts a job run at now.
+func (d *DependencyDelta) Begin(now time.Time) *DependencyDeltaRun {
+	if d == nil {
+		return &DependencyDeltaRun{delta: nil, now: now, resync: true, previous: nil, current: nil}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return &DependencyDeltaRun{
+		delta:    d,
+		now:      now,
+		resync:   d.lastResync.IsZero() || now.Sub(d.lastResync) >= d.resyncInterval,
+		previous: d.written,
+		current:  make(map[interface{}]bool),
+	}
+}
+
+// DependencyDeltaRun decides which dependency edges a single job run writes. It's not safe for concurrent use.
+type DependencyDeltaRun struct {
+	delta  *DependencyDelta
+	now    time.Time
+	resync bool
+
+	previous map[interface{}]bool
+	current  map[interface{}]bool
+}
+
+// Resync returns whether this run writes every edge.
+func (r *DependencyDeltaRun) Resync() bool {
+	return r.resync
+}
+
+// ShouldWrite marks the edge as present in this run, and returns whether it has to be written:
+// on a resync, or when it's new or changed (i.e. it wasn't written by the previous run).
+// The edge must be comparable (e.g. UpstreamService).
+func (r *DependencyDeltaRun) ShouldWrite(edge interface{}) bool {
+	if r.delta == nil {
+		return true
+	}
+
+	r.current[edge] = true
+
+	return r.resync || !r.previous[edge]
+}
+
+// Unwritten forgets an edge that failed to be written (or was dropped) in this run, so the next run writes it again.
+func (r *DependencyDeltaRun) Unwritten(edge interface{}) {
+	if r.delta == nil {
+		return
+	}
+
+	delete(r.current, edge)
+}
+
+// Done ends the run, its present edges become the written edges of the previous run.
+// It returns the number of edges written by the previous run that are gone from this run.
+// A run that isn't done (e.g. its query failed) doesn't change the written edges.
+func (r *DependencyDeltaRun) Done() int {
+	if r.delta == nil {
+		return 0
+	}
+
+	removed := 0
+	for edge := range r.previous {
+		if !r.current[edge] {
+			removed++
+		}
+	}
+
+	r.delta.mu.Lock()
+	r.delta.written = r.current
+	if r.resync {
+		r.delta.lastResync = r.now
+	}
+	r.delta.mu.Unlock()
+
+	return removed
+}
@@ -0,0 +1,147 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package federator
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// runDelta runs a job over the edges, returning the written edges and the number of removed edges.
+// Edges in failed fail to be written.
+func runDelta(delta *DependencyDelta, now time.Time, edges []UpstreamService, failed map[UpstreamService]bool) ([]UpstreamService, int) {
+	run := delta.Begin(now)
+	written := []UpstreamService{}
+	for _, edge := range edges {
+		if !run.ShouldWrite(edge) {
+			continue
+		}
+		if failed[edge] {
+			run.Unwritten(edge)
+
+			continue
+		}
+		written = append(written, edge)
+	}
+
+	return written, run.Done()
+}
+
+func TestDependencyDelta(t *testing.T) {
+	a := UpstreamService{LocalHostgroup: "app", UpstreamHostgroup: "db", UpstreamPort: "5432"}    // nolint:exhaustivestruct
+	b := UpstreamService{LocalHostgroup: "app", UpstreamHostgroup: "cache", UpstreamPort: "6379"} // nolint:exhaustivestruct
+	changedB := b
+	changedB.LocalProcessName = "worker"
+
+	delta := NewDependencyDelta(time.Hour)
+	startTime := time.Date(2021, 6, 1, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name        string
+		now         time.Time
+		edges       []UpstreamService
+		failed      map[UpstreamService]bool
+		wantWritten []UpstreamService
+		wantRemoved int
+	}{
+		{
+			name:        "First run writes every edge",
+			now:         startTime,
+			edges:       []UpstreamService{a, b},
+			wantWritten: []UpstreamService{a, b},
+		},
+		{
+			name:        "Unchanged edges aren't written",
+			now:         startTime.Add(30 * time.Second),
+			edges:       []UpstreamService{a, b},
+			wantWritten: []UpstreamService{},
+		},
+		{
+			name:        "Changed edge is written, and its previous version is removed",
+			now:         startTime.Add(time.Minute),
+			edges:       []UpstreamService{a, changedB},
+			failed:      map[UpstreamService]bool{changedB: true},
+			wantWritten: []UpstreamService{},
+			wantRemoved: 1,
+		},
+		{
+			name:        "Failed edge is written again",
+			now:         startTime.Add(90 * time.Second),
+			edges:       []UpstreamService{a, changedB},
+			wantWritten: []UpstreamService{changedB},
+		},
+		{
+			name:        "Removed edge isn't written",
+			now:         startTime.Add(2 * time.Minute),
+			edges:       []UpstreamService{changedB},
+			wantWritten: []UpstreamService{},
+			wantRemoved: 1,
+		},
+		{
+			name:        "Returning edge is written",
+			now:         startTime.Add(150 * time.Second),
+			edges:       []UpstreamService{a, changedB},
+			wantWritten: []UpstreamService{a},
+		},
+		{
+			name:        "Resync writes every edge",
+			now:         startTime.Add(time.Hour),
+			edges:       []UpstreamService{a, changedB},
+			wantWritten: []UpstreamService{a, changedB},
+		},
+		{
+			name:        "Unchanged edges aren't written after a resync",
+			now:         startTime.Add(time.Hour + 30*time.Second),
+			edges:       []UpstreamService{a, changedB},
+			wantWritten: []UpstreamService{},
+		},
+	}
+	for _, testcase := range tests {
+		// Runs depend on the previous ones, so they aren't subtests
+		gotWritten, gotRemoved := runDelta(delta, testcase.now, testcase.edges, testcase.failed)
+		if !reflect.DeepEqual(gotWritten, testcase.wantWritten) {
+			t.Errorf("%v: written = %v, want %v", testcase.name, gotWritten, testcase.wantWritten)
+		}
+		if gotRemoved != testcase.wantRemoved {
+			t.Errorf("%v: removed = %v, want %v", testcase.name, gotRemoved, testcase.wantRemoved)
+		}
+	}
+}
+
+func TestDependencyDelta_disabled(t *testing.T) {
+	a := UpstreamService{LocalHostgroup: "app", UpstreamHostgroup: "db", UpstreamPort: "5432"} // nolint:exhaustivestruct
+	var delta *DependencyDelta
+
+	for i := 0; i < 2; i++ {
+		gotWritten, _ := runDelta(delta, time.Now(), []UpstreamService{a}, nil)
+		if !reflect.DeepEqual(gotWritten, []UpstreamService{a}) {
+			t.Errorf("run %v written = %v, want %v", i, gotWritten, []UpstreamService{a})
+		}
+	}
+}
+
+func TestDependencyDelta_unfinishedRun(t *testing.T) {
+	a := UpstreamService{LocalHostgroup: "app", UpstreamHostgroup: "db", UpstreamPort: "5432"} // nolint:exhaustivestruct
+	delta := NewDependencyDelta(time.Hour)
+	startTime := time.Date(2021, 6, 1, 10, 0, 0, 0, time.UTC)
+	runDelta(delta, startTime, []UpstreamService{a}, nil)
+
+	// A run that failed to query isn't done, so it doesn't forget the written edges
+	delta.Begin(startTime.Add(30 * time.Second))
+	if gotWritten, _ := runDelta(delta, startTime.Add(time.Minute), []UpstreamService{a}, nil); len(gotWritten) != 0 {
+		t.Errorf("written = %v, want none", gotWritten)
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package federator
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Traffic directions.
+const (
+	IngressDirection = "ingress"
+	EgressDirection  = "egress"
+	UnknownDirection = "unknown"
+)
+
+// unknownDirectionWarnEvery logs only one in every this many unknown traffic direction warnings.
+const unknownDirectionWarnEvery = 100
+
+// ErrUnknownTrafficDirection is returned when a traffic row is rejected for having an unknown direction.
+var ErrUnknownTrafficDirection = errors.New("unknown traffic direction")
+
+// unknownDirectionCount is the number of traffic rows with unknown direction, used for sampling warnings.
+var unknownDirectionCount uint64
+
+// NormalizeTrafficDirection returns the traffic direction to store for a traffic row.
+//
+// Anything other than ingress/egress is counted and mapped to unknown, with a sampled warning logged.
+// When strict is true, such rows are rejected with ErrUnknownTrafficDirection instead.
+func NormalizeTrafficDirection(direction, localHostgroup, remoteHostgroup string, strict bool) (string, error) {
+	switch direction {
+	case IngressDirection, EgressDirection:
+		return direction, nil
+	}
+
+	unknownTrafficDirectionTotal.Inc()
+	if count := atomic.AddUint64(&unknownDirectionCount, 1); count%unknownDirectionWarnEvery == 1 {
+		log.Warnf("Traffic row with unknown direction %q (local_hostgroup: %v, remote_hostgroup: %v), seen %v so far",
+			direction, localHostgroup, remoteHostgroup, count)
+	}
+
+	if strict {
+		return "", fmt.Errorf("%w %q (local_hostgroup: %v, remote_hostgroup: %v)", ErrUnknownTrafficDirection, direction, localHostgroup, remoteHostgroup)
+	}
+
+	return UnknownDirection, nil
+}
+
+// ParseTrafficDirections parses a comma-separated list of traffic directions (e.g. "ingress,egress").
+// Duplicates are ignored and at least one of ingress/egress is required.
+func ParseTrafficDirections(directions string) ([]string, error) {
+	parsed := []string{}
+	seen := make(map[string]bool)
+	for _, direction := range strings.Split(directions, ",") {
+		direction = strings.TrimSpace(direction)
+		if direction == "" {
+			continue
+		}
+		if direction != IngressDirection && direction != EgressDirection {
+			return nil, fmt.Errorf("invalid traffic direction %q, expected %v or %v", direction, IngressDirection, EgressDirection)
+		}
+		if seen[direction] {
+			continue
+		}
+		seen[direction] = true
+		parsed = append(parsed, direction)
+	}
+	if len(parsed) == 0 {
+		return nil, fmt.Errorf("no traffic direction in %q, expected %v and/or %v", directions, IngressDirection, EgressDirection)
+	}
+
+	return parsed, nil
+}
@@ -0,0 +1,82 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package federator
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestNormalizeTrafficDirection(t *testing.T) {
+	tests := []struct {
+		name        string
+		direction   string
+		strict      bool
+		want        string
+		wantErr     error
+		wantCounted bool
+	}{
+		{name: "Ingress", direction: "ingress", want: IngressDirection},
+		{name: "Egress", direction: "egress", strict: true, want: EgressDirection},
+		{name: "Unknown is mapped", direction: "sideways", want: UnknownDirection, wantCounted: true},
+		{name: "Empty is mapped", direction: "", want: UnknownDirection, wantCounted: true},
+		{name: "Unknown is rejected in strict mode", direction: "INGRESS", strict: true, wantErr: ErrUnknownTrafficDirection, wantCounted: true},
+	}
+	for _, testcase := range tests {
+		t.Run(testcase.name, func(t *testing.T) {
+			before := testutil.ToFloat64(unknownTrafficDirectionTotal)
+
+			got, err := NormalizeTrafficDirection(testcase.direction, "local", "remote", testcase.strict)
+			if !errors.Is(err, testcase.wantErr) {
+				t.Errorf("NormalizeTrafficDirection() error = %v, wantErr %v", err, testcase.wantErr)
+			}
+			if got != testcase.want {
+				t.Errorf("NormalizeTrafficDirection() = %v, want %v", got, testcase.want)
+			}
+			if counted := testutil.ToFloat64(unknownTrafficDirectionTotal) > before; counted != testcase.wantCounted {
+				t.Errorf("NormalizeTrafficDirection() counted = %v, want %v", counted, testcase.wantCounted)
+			}
+		})
+	}
+}
+
+func TestParseTrafficDirections(t *testing.T) {
+	tests := []struct {
+		name       string
+		directions string
+		want       []string
+		wantErr    bool
+	}{
+		{name: "Both directions", directions: "ingress,egress", want: []string{IngressDirection, EgressDirection}},
+		{name: "Egress only with spaces", directions: " egress ", want: []string{EgressDirection}},
+		{name: "Duplicates are ignored", directions: "egress,egress,ingress", want: []string{EgressDirection, IngressDirection}},
+		{name: "Unknown direction", directions: "ingress,sideways", wantErr: true},
+		{name: "Empty", directions: " , ", wantErr: true},
+	}
+	for _, testcase := range tests {
+		t.Run(testcase.name, func(t *testing.T) {
+			got, err := ParseTrafficDirections(testcase.directions)
+			if (err != nil) != testcase.wantErr {
+				t.Fatalf("ParseTrafficDirections() error = %v, wantErr %v", err, testcase.wantErr)
+			}
+			if !testcase.wantErr && !reflect.DeepEqual(got, testcase.want) {
+				t.Errorf("ParseTrafficDirections() = %v, want %v", got, testcase.want)
+			}
+		})
+	}
+}
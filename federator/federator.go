@@ -32,6 +32,13 @@ type TrafficBandwidth struct {
 	RemoteDomain    string
 	BitsPerSecond   float64
 	Direction       string
+
+	// LocalInstance is the planet-exporter instance of per-instance traffic, empty for the hostgroup's traffic
+	LocalInstance string
+
+	// WindowStart is the query window start of the job run that wrote the row, nil if the run doesn't have one.
+	// Backends writing self-describing records (e.g. the stdout backend) include it along with the data point time.
+	WindowStart *time.Time `json:"-"`
 }
 
 // UpstreamService represents a target upstream service dependency of a local service process
@@ -46,6 +53,11 @@ type UpstreamService struct {
 	UpstreamHostgroup string
 	UpstreamAddress   string
 	Protocol          string
+
+	// FirstSeen is the earliest time the edge was observed at, nil unless first-seen tracking is enabled
+	FirstSeen *time.Time `json:",omitempty"`
+	// WindowStart is the query window start of the job run that wrote the row, nil if the run doesn't have one
+	WindowStart *time.Time `json:"-"`
 }
 
 // DownstreamService represents a target downstream service that depends on local service process
@@ -60,6 +72,24 @@ type DownstreamService struct {
 	DownstreamHostgroup string
 	DownstreamAddress   string
 	Protocol            string
+
+	// FirstSeen is the earliest time the edge was observed at, nil unless first-seen tracking is enabled
+	FirstSeen *time.Time `json:",omitempty"`
+	// WindowStart is the query window start of the job run that wrote the row, nil if the run doesn't have one
+	WindowStart *time.Time `json:"-"`
+}
+
+// CollectorHealth represents the health summary of a planet-exporter collector across a hostgroup's instances
+// e.g. LocalHostgroup testapp has 1 out of 10 instances failing the socketstat Collector.
+type CollectorHealth struct {
+	LocalHostgroup     string
+	Collector          string
+	Instances          int
+	FailingInstances   int
+	AvgDurationSeconds float64
+
+	// WindowStart is the query time of the job run that wrote the row, nil if the run doesn't have one
+	WindowStart *time.Time `json:"-"`
 }
 
 // Backend interface for a time-series DB that is handling pre-processed planet-exporter data
@@ -68,23 +98,60 @@ type Backend interface {
 	AddTrafficBandwidthData(context.Context, TrafficBandwidth, time.Time) error
 	AddUpstreamService(context.Context, UpstreamService, time.Time) error
 	AddDownstreamService(context.Context, DownstreamService, time.Time) error
+	AddCollectorHealth(context.Context, CollectorHealth, time.Time) error
 	Flush()
 }
 
+// Config contains federator service config options.
+type Config struct {
+	// WriteRateLimit is the maximum number of backend writes per second, zero means unlimited.
+	WriteRateLimit float64
+	// WriteRateLimitBurst is the maximum number of backend writes allowed at once.
+	WriteRateLimitBurst int
+}
+
 // Service represents a federator service.
 type Service struct {
 	backend Backend
+
+	// writeRateLimiter is shared by all copies of the Service
+	writeRateLimiter *rateLimiter
 }
 
 // New returns new federator service.
-func New(b Backend) Service {
+func New(config Config, b Backend) Service {
+	var writeRateLimiter *rateLimiter
+	if config.WriteRateLimit > 0 {
+		writeRateLimiter = newRateLimiter(config.WriteRateLimit, config.WriteRateLimitBurst)
+	}
+
 	return Service{
-		backend: b,
+		backend:          b,
+		writeRateLimiter: writeRateLimiter,
 	}
 }
 
+// waitWriteRateLimit blocks until the backend write is allowed by the rate limiter, or ctx is done.
+func (s Service) waitWriteRateLimit(ctx context.Context) error {
+	if s.writeRateLimiter == nil {
+		return nil
+	}
+
+	throttled, err := s.writeRateLimiter.Wait(ctx)
+	writeThrottledSecondsTotal.Add(throttled.Seconds())
+	if err != nil {
+		return fmt.Errorf("error on backend write rate limit: %w", err)
+	}
+
+	return nil
+}
+
 // AddTrafficBandwidthData adds an ingress bytes data point.
 func (s Service) AddTrafficBandwidthData(ctx context.Context, trafficBandwidth TrafficBandwidth, t time.Time) error {
+	if err := s.waitWriteRateLimit(ctx); err != nil {
+		return err
+	}
+
 	err := s.backend.AddTrafficBandwidthData(ctx, trafficBandwidth, t)
 	if err != nil {
 		return fmt.Errorf("error on adding traffic bandwidth data: %w", err)
@@ -95,6 +162,10 @@ func (s Service) AddTrafficBandwidthData(ctx context.Context, trafficBandwidth T
 
 // AddUpstreamService adds an upstream of a local service.
 func (s Service) AddUpstreamService(ctx context.Context, upstreamService UpstreamService, t time.Time) error {
+	if err := s.waitWriteRateLimit(ctx); err != nil {
+		return err
+	}
+
 	err := s.backend.AddUpstreamService(ctx, upstreamService, t)
 	if err != nil {
 		return fmt.Errorf("error on adding upstream service: %w", err)
@@ -105,6 +176,10 @@ func (s Service) AddUpstreamService(ctx context.Context, upstreamService Upstrea
 
 // AddDownstreamService adds a downstream of a local service.
 func (s Service) AddDownstreamService(ctx context.Context, downstreamService DownstreamService, t time.Time) error {
+	if err := s.waitWriteRateLimit(ctx); err != nil {
+		return err
+	}
+
 	err := s.backend.AddDownstreamService(ctx, downstreamService, t)
 	if err != nil {
 		return fmt.Errorf("error on adding downstream service: %w", err)
@@ -113,6 +188,20 @@ func (s Service) AddDownstreamService(ctx context.Context, downstreamService Dow
 	return nil
 }
 
+// AddCollectorHealth adds a planet-exporter collector health summary of a local service.
+func (s Service) AddCollectorHealth(ctx context.Context, collectorHealth CollectorHealth, t time.Time) error {
+	if err := s.waitWriteRateLimit(ctx); err != nil {
+		return err
+	}
+
+	err := s.backend.AddCollectorHealth(ctx, collectorHealth, t)
+	if err != nil {
+		return fmt.Errorf("error on adding collector health: %w", err)
+	}
+
+	return nil
+}
+
 // Flush any buffers related to backend.
 func (s Service) Flush() {
 	s.backend.Flush()
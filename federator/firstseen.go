@@ -0,0 +1,185 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package federator
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultFirstSeenMaxIdleRuns is the default number of job runs an edge can be missing from before its first-seen
+// time is forgotten, a day of the default 30s cron job schedule.
+const DefaultFirstSeenMaxIdleRuns = 2880
+
+// EdgeFirstSeen remembers the earliest data point time each dependency edge was observed at since the federator
+// started, so backends can tell when a hostgroup started depending on another.
+// An edge is identified by its direction, local and remote hostgroups, port, and protocol. An edge missing from
+// maxIdleRuns job runs of its direction is forgotten, so memory stays bounded; if it comes back, it's first seen again.
+// It's in memory, seed it from the first-seen times written to a backend (see Seed) so a restart doesn't first see
+// every edge again at the first run.
+// A nil EdgeFirstSeen disables first-seen tracking. It's safe for concurrent use.
+type EdgeFirstSeen struct {
+	maxIdleRuns int
+
+	mu    sync.Mutex
+	edges map[edgeKey]edgeSeen
+	// runs ended per direction
+	runs map[string]int
+}
+
+// edgeKey identifies a dependency edge for first-seen tracking.
+type edgeKey struct {
+	direction       string
+	localHostgroup  string
+	remoteHostgroup string
+	port            string
+	protocol        string
+}
+
+// edgeSeen is when an edge was first seen at, and the run of its direction it was last seen in.
+type edgeSeen struct {
+	firstSeen time.Time
+	lastRun   int
+}
+
+// Edge directions of first-seen tracking.
+const (
+	upstreamEdge   = "upstream"
+	downstreamEdge = "downstream"
+)
+
+// NewEdgeFirstSeen returns an EdgeFirstSeen that hasn't observed any edge yet, and forgets edges missing from
+// maxIdleRuns runs (never if zero).
+func NewEdgeFirstSeen(maxIdleRuns int) *EdgeFirstSeen {
+	return &EdgeFirstSeen{
+		maxIdleRuns: maxIdleRuns,
+		mu:          sync.Mutex{},
+		edges:       make(map[edgeKey]edgeSeen),
+		runs:        make(map[string]int),
+	}
+}
+
+// Upstream observes the upstream edge at t, and returns a copy of the edge with its first-seen time.
+// The edge is returned unchanged if tracking is disabled.
+func (f *EdgeFirstSeen) Upstream(edge UpstreamService, t time.Time) UpstreamService {
+	if f == nil {
+		return edge
+	}
+
+	firstSeen := f.observe(edgeKey{
+		direction:       upstreamEdge,
+		localHostgroup:  edge.LocalHostgroup,
+		remoteHostgroup: edge.UpstreamHostgroup,
+		port:            edge.UpstreamPort,
+		protocol:        edge.Protocol,
+	}, t)
+	edge.FirstSeen = &firstSeen
+
+	return edge
+}
+
+// Downstream observes the downstream edge at t, and returns a copy of the edge with its first-seen time.
+// The edge is returned unchanged if tracking is disabled.
+func (f *EdgeFirstSeen) Downstream(edge DownstreamService, t time.Time) DownstreamService {
+	if f == nil {
+		return edge
+	}
+
+	firstSeen := f.observe(edgeKey{
+		direction:       downstreamEdge,
+		localHostgroup:  edge.LocalHostgroup,
+		remoteHostgroup: edge.DownstreamHostgroup,
+		port:            edge.LocalPort,
+		protocol:        edge.Protocol,
+	}, t)
+	edge.FirstSeen = &firstSeen
+
+	return edge
+}
+
+// Seed observes the edges at their first-seen time, e.g. the edges written to a backend before a restart, so they keep
+// their first-seen time. Edges without a first-seen time are skipped. It returns the number of seeded edges.
+func (f *EdgeFirstSeen) Seed(upstreams []UpstreamService, downstreams []DownstreamService) int {
+	if f == nil {
+		return 0
+	}
+
+	seeded := 0
+	for _, edge := range upstreams {
+		if edge.FirstSeen != nil {
+			f.Upstream(edge, *edge.FirstSeen)
+			seeded++
+		}
+	}
+	for _, edge := range downstreams {
+		if edge.FirstSeen != nil {
+			f.Downstream(edge, *edge.FirstSeen)
+			seeded++
+		}
+	}
+
+	return seeded
+}
+
+// EndUpstreamRun ends a job run of upstream edges, and forgets the upstream edges missing from the last maxIdleRuns
+// runs. It returns the number of forgotten edges. A run that isn't ended (e.g. its query failed) doesn't count.
+func (f *EdgeFirstSeen) EndUpstreamRun() int {
+	return f.endRun(upstreamEdge)
+}
+
+// EndDownstreamRun is EndUpstreamRun of downstream edges.
+func (f *EdgeFirstSeen) EndDownstreamRun() int {
+	return f.endRun(downstreamEdge)
+}
+
+// observe returns the earliest time the edge was observed at, including t, and marks it as seen in the current run.
+func (f *EdgeFirstSeen) observe(key edgeKey, t time.Time) time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	seen, found := f.edges[key]
+	if !found || t.Before(seen.firstSeen) {
+		seen.firstSeen = t
+	}
+	seen.lastRun = f.runs[key.direction]
+	f.edges[key] = seen
+
+	return seen.firstSeen
+}
+
+// endRun ends a run of the direction, and forgets its edges missing from the last maxIdleRuns runs.
+func (f *EdgeFirstSeen) endRun(direction string) int {
+	if f == nil {
+		return 0
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.runs[direction]++
+	if f.maxIdleRuns <= 0 {
+		return 0
+	}
+
+	forgotten := 0
+	for key, seen := range f.edges {
+		if key.direction == direction && f.runs[direction]-seen.lastRun > f.maxIdleRuns {
+			delete(f.edges, key)
+			forgotten++
+		}
+	}
+
+	return forgotten
+}
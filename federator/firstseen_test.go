@@ -0,0 +1,121 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package federator
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEdgeFirstSeen(t *testing.T) {
+	firstSeen := NewEdgeFirstSeen(0)
+	startTime := time.Date(2021, 6, 1, 10, 0, 0, 0, time.UTC)
+	upstream := UpstreamService{LocalHostgroup: "app", UpstreamHostgroup: "db", UpstreamPort: "5432", Protocol: "tcp"} // nolint:exhaustivestruct
+	// The downstream edge of the same hostgroups, port, and protocol is another edge
+	downstream := DownstreamService{LocalHostgroup: "app", DownstreamHostgroup: "db", LocalPort: "5432", Protocol: "tcp"} // nolint:exhaustivestruct
+	movedUpstream := upstream
+	movedUpstream.UpstreamAddress = "db-2.local"
+
+	tests := []struct {
+		name string
+		got  func() *time.Time
+		want time.Time
+	}{
+		{name: "New upstream edge", got: func() *time.Time { return firstSeen.Upstream(upstream, startTime).FirstSeen }, want: startTime},
+		{name: "Later observation", got: func() *time.Time { return firstSeen.Upstream(upstream, startTime.Add(time.Hour)).FirstSeen }, want: startTime},
+		{name: "Same edge at another address", got: func() *time.Time { return firstSeen.Upstream(movedUpstream, startTime.Add(2*time.Hour)).FirstSeen }, want: startTime},
+		{name: "Earlier observation", got: func() *time.Time { return firstSeen.Upstream(upstream, startTime.Add(-time.Hour)).FirstSeen }, want: startTime.Add(-time.Hour)},
+		{name: "New downstream edge", got: func() *time.Time { return firstSeen.Downstream(downstream, startTime.Add(time.Hour)).FirstSeen }, want: startTime.Add(time.Hour)},
+	}
+	for _, testcase := range tests {
+		t.Run(testcase.name, func(t *testing.T) {
+			got := testcase.got()
+			if got == nil || !got.Equal(testcase.want) {
+				t.Errorf("EdgeFirstSeen first seen = %v, want %v", got, testcase.want)
+			}
+		})
+	}
+
+	var disabled *EdgeFirstSeen
+	if got := disabled.Upstream(upstream, startTime); got != upstream {
+		t.Errorf("disabled EdgeFirstSeen.Upstream() = %+v, want the edge unchanged", got)
+	}
+}
+
+func TestEdgeFirstSeen_endRun(t *testing.T) {
+	firstSeen := NewEdgeFirstSeen(2)
+	startTime := time.Date(2021, 6, 1, 10, 0, 0, 0, time.UTC)
+	upstream := UpstreamService{LocalHostgroup: "app", UpstreamHostgroup: "db", UpstreamPort: "5432", Protocol: "tcp"}    // nolint:exhaustivestruct
+	downstream := DownstreamService{LocalHostgroup: "app", DownstreamHostgroup: "web", LocalPort: "443", Protocol: "tcp"} // nolint:exhaustivestruct
+
+	firstSeen.Upstream(upstream, startTime)
+	firstSeen.Downstream(downstream, startTime)
+	// The upstream edge is seen in the first run only, downstream runs don't count against it
+	for run := 1; run <= 2; run++ {
+		if forgotten := firstSeen.EndUpstreamRun(); forgotten != 0 {
+			t.Fatalf("EndUpstreamRun() of run %v = %v, want 0 forgotten", run, forgotten)
+		}
+		firstSeen.Downstream(downstream, startTime.Add(time.Duration(run)*time.Hour))
+		firstSeen.EndDownstreamRun()
+	}
+	if forgotten := firstSeen.EndUpstreamRun(); forgotten != 1 {
+		t.Fatalf("EndUpstreamRun() after 2 idle runs = %v, want 1 forgotten", forgotten)
+	}
+
+	later := startTime.Add(5 * time.Hour)
+	if got := firstSeen.Upstream(upstream, later).FirstSeen; got == nil || !got.Equal(later) {
+		t.Errorf("EdgeFirstSeen first seen of a forgotten edge = %v, want %v", got, later)
+	}
+	if got := firstSeen.Downstream(downstream, later).FirstSeen; got == nil || !got.Equal(startTime) {
+		t.Errorf("EdgeFirstSeen first seen of a present edge = %v, want %v", got, startTime)
+	}
+
+	var disabled *EdgeFirstSeen
+	if forgotten := disabled.EndUpstreamRun(); forgotten != 0 {
+		t.Errorf("disabled EdgeFirstSeen.EndUpstreamRun() = %v, want 0", forgotten)
+	}
+}
+
+func TestEdgeFirstSeen_Seed(t *testing.T) {
+	firstSeen := NewEdgeFirstSeen(0)
+	startTime := time.Date(2021, 6, 1, 10, 0, 0, 0, time.UTC)
+	seedTime := startTime.Add(-72 * time.Hour)
+	upstream := UpstreamService{LocalHostgroup: "app", UpstreamHostgroup: "db", UpstreamPort: "5432", Protocol: "tcp"}    // nolint:exhaustivestruct
+	downstream := DownstreamService{LocalHostgroup: "app", DownstreamHostgroup: "web", LocalPort: "443", Protocol: "tcp"} // nolint:exhaustivestruct
+	seededUpstream, seededDownstream := upstream, downstream
+	seededUpstream.FirstSeen, seededDownstream.FirstSeen = &seedTime, &seedTime
+	// An edge written without first-seen tracking isn't seeded
+	unknownUpstream := UpstreamService{LocalHostgroup: "app", UpstreamHostgroup: "cache", UpstreamPort: "6379", Protocol: "tcp"} // nolint:exhaustivestruct
+
+	if seeded := firstSeen.Seed([]UpstreamService{seededUpstream, unknownUpstream}, []DownstreamService{seededDownstream}); seeded != 2 {
+		t.Errorf("EdgeFirstSeen.Seed() = %v, want 2 seeded", seeded)
+	}
+
+	// Seeded edges keep their first-seen time after a restart
+	if got := firstSeen.Upstream(upstream, startTime).FirstSeen; got == nil || !got.Equal(seedTime) {
+		t.Errorf("EdgeFirstSeen first seen of a seeded upstream edge = %v, want %v", got, seedTime)
+	}
+	if got := firstSeen.Downstream(downstream, startTime).FirstSeen; got == nil || !got.Equal(seedTime) {
+		t.Errorf("EdgeFirstSeen first seen of a seeded downstream edge = %v, want %v", got, seedTime)
+	}
+	if got := firstSeen.Upstream(unknownUpstream, startTime).FirstSeen; got == nil || !got.Equal(startTime) {
+		t.Errorf("EdgeFirstSeen first seen of an edge that wasn't seeded = %v, want %v", got, startTime)
+	}
+
+	var disabled *EdgeFirstSeen
+	if seeded := disabled.Seed([]UpstreamService{seededUpstream}, nil); seeded != 0 {
+		t.Errorf("disabled EdgeFirstSeen.Seed() = %v, want 0", seeded)
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package federator
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// HostgroupFilter decides which hostgroups are federated, from include and exclude lists of exact names or globs
+// (e.g. "payment-*"). A hostgroup passes when it matches an include pattern (or there are none), and no exclude pattern.
+type HostgroupFilter struct {
+	include []string
+	exclude []string
+}
+
+// ParseHostgroupFilter parses comma-separated include and exclude hostgroup patterns, empty if there are none.
+// Patterns use path.Match syntax.
+func ParseHostgroupFilter(include, exclude string) (HostgroupFilter, error) {
+	includePatterns, err := parseHostgroupPatterns(include)
+	if err != nil {
+		return HostgroupFilter{}, fmt.Errorf("error parsing include hostgroups: %w", err)
+	}
+	excludePatterns, err := parseHostgroupPatterns(exclude)
+	if err != nil {
+		return HostgroupFilter{}, fmt.Errorf("error parsing exclude hostgroups: %w", err)
+	}
+
+	return HostgroupFilter{include: includePatterns, exclude: excludePatterns}, nil
+}
+
+// parseHostgroupPatterns parses a comma-separated list of hostgroup patterns.
+func parseHostgroupPatterns(patterns string) ([]string, error) {
+	parsed := []string{}
+	for _, pattern := range strings.Split(patterns, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid hostgroup pattern %q: %w", pattern, err)
+		}
+		parsed = append(parsed, pattern)
+	}
+
+	return parsed, nil
+}
+
+// IsSet returns whether the filter has any include or exclude pattern.
+func (f HostgroupFilter) IsSet() bool {
+	return len(f.include) > 0 || len(f.exclude) > 0
+}
+
+// String returns the include and exclude patterns of the filter, e.g. "include=payment-* exclude=payment-test".
+func (f HostgroupFilter) String() string {
+	return "include=" + strings.Join(f.include, ",") + " exclude=" + strings.Join(f.exclude, ",")
+}
+
+// Match returns whether a hostgroup passes the filter.
+func (f HostgroupFilter) Match(hostgroup string) bool {
+	if matchHostgroup(f.exclude, hostgroup) {
+		return false
+	}
+
+	return len(f.include) == 0 || matchHostgroup(f.include, hostgroup)
+}
+
+// AllowRow returns whether every hostgroup of a row (e.g. its local and remote hostgroups) passes the filter.
+// Rows that don't are counted as filtered.
+func (f HostgroupFilter) AllowRow(hostgroups ...string) bool {
+	if !f.IsSet() {
+		return true
+	}
+
+	for _, hostgroup := range hostgroups {
+		if !f.Match(hostgroup) {
+			rowsFilteredTotal.Inc()
+
+			return false
+		}
+	}
+
+	return true
+}
+
+// matchHostgroup returns whether a hostgroup matches any of the patterns.
+func matchHostgroup(patterns []string, hostgroup string) bool {
+	for _, pattern := range patterns {
+		// Patterns are validated by ParseHostgroupFilter
+		if matched, _ := path.Match(pattern, hostgroup); matched {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,87 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package federator
+
+import (
+	"testing"
+)
+
+func TestHostgroupFilter_Match(t *testing.T) {
+	tests := []struct {
+		name      string
+		include   string
+		exclude   string
+		hostgroup string
+		want      bool
+	}{
+		{name: "No patterns", include: "", exclude: "", hostgroup: "app", want: true},
+		{name: "Included exact name", include: "app, db", exclude: "", hostgroup: "db", want: true},
+		{name: "Not included", include: "app,db", exclude: "", hostgroup: "cache", want: false},
+		{name: "Included glob", include: "payment-*", exclude: "", hostgroup: "payment-api", want: true},
+		{name: "Glob matches the whole name", include: "payment-*", exclude: "", hostgroup: "legacy-payment-api", want: false},
+		{name: "Excluded exact name", include: "", exclude: "debugapp", hostgroup: "debugapp", want: false},
+		{name: "Exclude takes precedence", include: "payment-*", exclude: "payment-canary", hostgroup: "payment-canary", want: false},
+		{name: "Character class", include: "db-[0-9]", exclude: "", hostgroup: "db-1", want: true},
+		{name: "Empty hostgroup isn't included", include: "app", exclude: "", hostgroup: "", want: false},
+	}
+	for _, testcase := range tests {
+		t.Run(testcase.name, func(t *testing.T) {
+			filter, err := ParseHostgroupFilter(testcase.include, testcase.exclude)
+			if err != nil {
+				t.Fatalf("ParseHostgroupFilter() error = %v", err)
+			}
+			if got := filter.Match(testcase.hostgroup); got != testcase.want {
+				t.Errorf("HostgroupFilter.Match(%q) = %v, want %v", testcase.hostgroup, got, testcase.want)
+			}
+		})
+	}
+}
+
+func TestHostgroupFilter_AllowRow(t *testing.T) {
+	filter, err := ParseHostgroupFilter("app,db-*", "")
+	if err != nil {
+		t.Fatalf("ParseHostgroupFilter() error = %v", err)
+	}
+
+	if !filter.AllowRow("app", "db-main") {
+		t.Errorf("HostgroupFilter.AllowRow() = false, want true when both hostgroups are included")
+	}
+	if filter.AllowRow("app", "cache") {
+		t.Errorf("HostgroupFilter.AllowRow() = true, want false when the remote hostgroup isn't included")
+	}
+	if !(HostgroupFilter{}).AllowRow("app", "") { // nolint:exhaustivestruct
+		t.Errorf("HostgroupFilter.AllowRow() = false, want true without patterns")
+	}
+}
+
+func TestParseHostgroupFilter_invalid(t *testing.T) {
+	if _, err := ParseHostgroupFilter("app,db-[", ""); err == nil {
+		t.Errorf("ParseHostgroupFilter() error = nil, want an invalid pattern error")
+	}
+	if _, err := ParseHostgroupFilter("", "[a-"); err == nil {
+		t.Errorf("ParseHostgroupFilter() error = nil, want an invalid pattern error")
+	}
+}
+
+func TestHostgroupFilter_String(t *testing.T) {
+	filter, err := ParseHostgroupFilter("app, db-*", "db-test")
+	if err != nil {
+		t.Fatalf("ParseHostgroupFilter() error = %v", err)
+	}
+
+	if got, want := filter.String(), "include=app,db-* exclude=db-test"; got != want {
+		t.Errorf("HostgroupFilter.String() = %q, want %q", got, want)
+	}
+}
@@ -31,10 +31,15 @@ type Backend struct {
 	writeAPI influxdb2api.WriteAPI
 	org      string
 	bucket   string
+
+	// strictTrafficDirection rejects traffic data with unknown direction instead of storing it as unknown
+	strictTrafficDirection bool
 }
 
 // New returns new influxdb federator backend.
-func New(influxdbClient influxdb2.Client, org, bucket string) Backend {
+// Points are written with timestamps of the precision set on the client write options (see ParsePrecision).
+// When strictTrafficDirection is true, traffic data with a direction other than ingress/egress is rejected.
+func New(influxdbClient influxdb2.Client, org, bucket string, strictTrafficDirection bool) Backend {
 	writeAPI := influxdbClient.WriteAPI(org, bucket)
 
 	errChan := writeAPI.Errors()
@@ -49,44 +54,11 @@ func New(influxdbClient influxdb2.Client, org, bucket string) Backend {
 		writeAPI: writeAPI,
 		org:      org,
 		bucket:   bucket,
+
+		strictTrafficDirection: strictTrafficDirection,
 	}
 }
 
-const (
-	// Measurements.
-
-	upstreamServiceMeasurement   = "upstream"
-	downstreamServiceMeasurement = "downstream"
-
-	ingressDirectionMeasurement = "ingress"
-	egressDirectionMeasurement  = "egress"
-	unknownDirectionMeasurement = "unknown"
-
-	// Tags.
-
-	localServiceHostgroupTag   = "service"
-	localServiceAddressTag     = "address"
-	localServicePortTag        = "port"
-	localServiceProcessNameTag = "process_name"
-
-	remoteServiceHostgroupTag = "remote_service"
-	remoteServiceAddressTag   = "remote_address"
-
-	upstreamServiceHostgroupTag = "upstream_service"
-	upstreamServiceAddressTag   = "upstream_address"
-	upstreamServicePortTag      = "upstream_port"
-
-	downstreamServiceHostgroupTag = "downstream_service"
-	downstreamServiceAddressTag   = "downstream_address"
-
-	protocolTag = "protocol"
-
-	// Fields.
-
-	bandwidthBpsField      = "bandwidth_bps"
-	serviceDependencyField = "service_dependency"
-)
-
 // AddTrafficBandwidthData adds a service's ingress bytes data point
 // Example InfluxQL: Produces time series data showing traffic bandwidth for service = $service
 //   SELECT
@@ -99,27 +71,27 @@ const (
 //     time($__interval), "service", "remote_service", "remote_address"
 //
 func (b Backend) AddTrafficBandwidthData(ctx context.Context, trafficBandwidth federator.TrafficBandwidth, timeOfDataPoint time.Time) error {
-	var measurement string
-	switch trafficBandwidth.Direction {
-	case "ingress":
-		measurement = ingressDirectionMeasurement
-	case "egress":
-		measurement = egressDirectionMeasurement
-	default:
-		measurement = unknownDirectionMeasurement
+	direction, err := federator.NormalizeTrafficDirection(trafficBandwidth.Direction,
+		trafficBandwidth.LocalHostgroup, trafficBandwidth.RemoteHostgroup, b.strictTrafficDirection)
+	if err != nil {
+		return err
 	}
 
-	return b.addBytesMeasurement(ctx, measurement, trafficBandwidth, timeOfDataPoint)
+	return b.addBytesMeasurement(ctx, TrafficMeasurement(direction), trafficBandwidth, timeOfDataPoint)
 }
 
 func (b Backend) addBytesMeasurement(ctx context.Context, measurement string, trafficBandwidth federator.TrafficBandwidth, timeOfDataPoint time.Time) error { // nolint:unparam
 	dataPoint := influxdb2.NewPointWithMeasurement(measurement).
-		AddTag(localServiceHostgroupTag, trafficBandwidth.LocalHostgroup).
-		AddTag(localServiceAddressTag, trafficBandwidth.LocalAddress).
-		AddTag(remoteServiceHostgroupTag, trafficBandwidth.RemoteHostgroup).
-		AddTag(remoteServiceAddressTag, trafficBandwidth.RemoteDomain).
-		AddField(bandwidthBpsField, trafficBandwidth.BitsPerSecond).
+		AddTag(LocalServiceHostgroupTag, trafficBandwidth.LocalHostgroup).
+		AddTag(LocalServiceAddressTag, trafficBandwidth.LocalAddress).
+		AddTag(RemoteServiceHostgroupTag, trafficBandwidth.RemoteHostgroup).
+		AddTag(RemoteServiceAddressTag, trafficBandwidth.RemoteDomain).
+		AddTag(SchemaVersionTag, SchemaVersionTagValue).
+		AddField(BandwidthBpsField, trafficBandwidth.BitsPerSecond).
 		SetTime(timeOfDataPoint)
+	if trafficBandwidth.LocalInstance != "" {
+		dataPoint.AddTag(LocalInstanceTag, trafficBandwidth.LocalInstance)
+	}
 	b.writeAPI.WritePoint(dataPoint)
 
 	return nil
@@ -135,16 +107,19 @@ func (b Backend) addBytesMeasurement(ctx context.Context, measurement string, tr
 //   GROUP BY
 //       "upstream_service", "upstream_address", "process_name", "upstream_port", "protocol", time(10000d)
 func (b Backend) AddUpstreamService(ctx context.Context, upstreamService federator.UpstreamService, timeOfDataPoint time.Time) error {
-	dataPoint := influxdb2.NewPointWithMeasurement(upstreamServiceMeasurement).
-		AddTag(localServiceHostgroupTag, upstreamService.LocalHostgroup).
-		AddTag(localServiceAddressTag, upstreamService.LocalAddress).
-		AddTag(upstreamServiceHostgroupTag, upstreamService.UpstreamHostgroup).
-		AddTag(upstreamServiceAddressTag, upstreamService.UpstreamAddress).
-		AddTag(upstreamServicePortTag, upstreamService.UpstreamPort).
-		AddTag(localServiceProcessNameTag, upstreamService.LocalProcessName).
-		AddTag(protocolTag, upstreamService.Protocol).
-		AddField(serviceDependencyField, 1).
+	dataPoint := influxdb2.NewPointWithMeasurement(UpstreamServiceMeasurement).
+		AddTag(LocalServiceHostgroupTag, upstreamService.LocalHostgroup).
+		AddTag(LocalServiceAddressTag, upstreamService.LocalAddress).
+		AddTag(UpstreamServiceHostgroupTag, upstreamService.UpstreamHostgroup).
+		AddTag(UpstreamServiceAddressTag, upstreamService.UpstreamAddress).
+		AddTag(UpstreamServicePortTag, upstreamService.UpstreamPort).
+		AddTag(LocalServiceProcessNameTag, upstreamService.LocalProcessName).
+		AddTag(ProtocolTag, upstreamService.Protocol).
+		AddTag(SchemaVersionTag, SchemaVersionTagValue).
 		SetTime(timeOfDataPoint)
+	for field, value := range DependencyFields(upstreamService.FirstSeen) {
+		dataPoint.AddField(field, value)
+	}
 	b.writeAPI.WritePoint(dataPoint)
 
 	return nil
@@ -160,15 +135,42 @@ func (b Backend) AddUpstreamService(ctx context.Context, upstreamService federat
 //   GROUP BY
 //       "downstream_service", "downstream_address", "process_name", "port", "protocol", time(10000d)
 func (b Backend) AddDownstreamService(ctx context.Context, downstreamService federator.DownstreamService, timeOfDataPoint time.Time) error {
-	dataPoint := influxdb2.NewPointWithMeasurement(downstreamServiceMeasurement).
-		AddTag(localServiceHostgroupTag, downstreamService.LocalHostgroup).
-		AddTag(localServiceAddressTag, downstreamService.LocalAddress).
-		AddTag(localServicePortTag, downstreamService.LocalPort).
-		AddTag(localServiceProcessNameTag, downstreamService.LocalProcessName).
-		AddTag(downstreamServiceHostgroupTag, downstreamService.DownstreamHostgroup).
-		AddTag(downstreamServiceAddressTag, downstreamService.DownstreamAddress).
-		AddTag(protocolTag, downstreamService.Protocol).
-		AddField(serviceDependencyField, 1).
+	dataPoint := influxdb2.NewPointWithMeasurement(DownstreamServiceMeasurement).
+		AddTag(LocalServiceHostgroupTag, downstreamService.LocalHostgroup).
+		AddTag(LocalServiceAddressTag, downstreamService.LocalAddress).
+		AddTag(LocalServicePortTag, downstreamService.LocalPort).
+		AddTag(LocalServiceProcessNameTag, downstreamService.LocalProcessName).
+		AddTag(DownstreamServiceHostgroupTag, downstreamService.DownstreamHostgroup).
+		AddTag(DownstreamServiceAddressTag, downstreamService.DownstreamAddress).
+		AddTag(ProtocolTag, downstreamService.Protocol).
+		AddTag(SchemaVersionTag, SchemaVersionTagValue).
+		SetTime(timeOfDataPoint)
+	for field, value := range DependencyFields(downstreamService.FirstSeen) {
+		dataPoint.AddField(field, value)
+	}
+	b.writeAPI.WritePoint(dataPoint)
+
+	return nil
+}
+
+// AddCollectorHealth adds a planet-exporter collector health summary of a service
+// Example InfluxQL: Produces time series data showing failing instances per collector for service = $service
+//   SELECT
+//       MAX("failing_instances")
+//   FROM
+//       "collector_health"
+//   WHERE
+//       ("service" = '$service') AND $timeFilter
+//   GROUP BY
+//       time($__interval), "collector"
+func (b Backend) AddCollectorHealth(ctx context.Context, collectorHealth federator.CollectorHealth, timeOfDataPoint time.Time) error {
+	dataPoint := influxdb2.NewPointWithMeasurement(CollectorHealthMeasurement).
+		AddTag(LocalServiceHostgroupTag, collectorHealth.LocalHostgroup).
+		AddTag(CollectorTag, collectorHealth.Collector).
+		AddTag(SchemaVersionTag, SchemaVersionTagValue).
+		AddField(InstancesField, collectorHealth.Instances).
+		AddField(FailingInstancesField, collectorHealth.FailingInstances).
+		AddField(AvgDurationSecondsField, collectorHealth.AvgDurationSeconds).
 		SetTime(timeOfDataPoint)
 	b.writeAPI.WritePoint(dataPoint)
 
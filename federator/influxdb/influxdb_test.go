@@ -0,0 +1,108 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package influxdb
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"planet-exporter/federator"
+
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+)
+
+// mockWriteAPI records the measurement and time of every written point.
+type mockWriteAPI struct {
+	measurements []string
+	times        []time.Time
+}
+
+func (m *mockWriteAPI) WriteRecord(string) {}
+
+func (m *mockWriteAPI) WritePoint(point *write.Point) {
+	m.measurements = append(m.measurements, point.Name())
+	m.times = append(m.times, point.Time())
+}
+
+func (m *mockWriteAPI) Flush() {}
+
+func (m *mockWriteAPI) Errors() <-chan error {
+	return nil
+}
+
+func TestBackend_AddTrafficBandwidthData(t *testing.T) {
+	tests := []struct {
+		name                   string
+		direction              string
+		strictTrafficDirection bool
+		wantMeasurements       []string
+		wantErr                error
+	}{
+		{name: "Ingress", direction: "ingress", wantMeasurements: []string{IngressDirectionMeasurement}},
+		{name: "Egress", direction: "egress", strictTrafficDirection: true, wantMeasurements: []string{EgressDirectionMeasurement}},
+		{name: "Unknown direction is stored as unknown", direction: "sideways", wantMeasurements: []string{UnknownDirectionMeasurement}},
+		{name: "Unknown direction is rejected in strict mode", direction: "sideways", strictTrafficDirection: true, wantErr: federator.ErrUnknownTrafficDirection},
+	}
+	for _, testcase := range tests {
+		t.Run(testcase.name, func(t *testing.T) {
+			writeAPI := &mockWriteAPI{}
+			b := Backend{writeAPI: writeAPI, strictTrafficDirection: testcase.strictTrafficDirection} // nolint:exhaustivestruct
+
+			err := b.AddTrafficBandwidthData(context.Background(), federator.TrafficBandwidth{ // nolint:exhaustivestruct
+				LocalHostgroup:  "local",
+				RemoteHostgroup: "remote",
+				Direction:       testcase.direction,
+			}, time.Now())
+			if !errors.Is(err, testcase.wantErr) {
+				t.Errorf("Backend.AddTrafficBandwidthData() error = %v, wantErr %v", err, testcase.wantErr)
+			}
+			if !reflect.DeepEqual(writeAPI.measurements, testcase.wantMeasurements) {
+				t.Errorf("Backend.AddTrafficBandwidthData() measurements = %v, want %v", writeAPI.measurements, testcase.wantMeasurements)
+			}
+		})
+	}
+}
+
+func TestBackend_dataPointTime(t *testing.T) {
+	timeOfDataPoint := time.Date(2021, 6, 1, 9, 59, 50, 0, time.UTC)
+	writeAPI := &mockWriteAPI{}
+	b := Backend{writeAPI: writeAPI} // nolint:exhaustivestruct
+	ctx := context.Background()
+
+	if err := b.AddTrafficBandwidthData(ctx, federator.TrafficBandwidth{Direction: "egress"}, timeOfDataPoint); err != nil { // nolint:exhaustivestruct
+		t.Errorf("Backend.AddTrafficBandwidthData() error = %v", err)
+	}
+	if err := b.AddUpstreamService(ctx, federator.UpstreamService{}, timeOfDataPoint); err != nil { // nolint:exhaustivestruct
+		t.Errorf("Backend.AddUpstreamService() error = %v", err)
+	}
+	if err := b.AddDownstreamService(ctx, federator.DownstreamService{}, timeOfDataPoint); err != nil { // nolint:exhaustivestruct
+		t.Errorf("Backend.AddDownstreamService() error = %v", err)
+	}
+	if err := b.AddCollectorHealth(ctx, federator.CollectorHealth{}, timeOfDataPoint); err != nil { // nolint:exhaustivestruct
+		t.Errorf("Backend.AddCollectorHealth() error = %v", err)
+	}
+
+	if len(writeAPI.times) != 4 {
+		t.Fatalf("Backend wrote %v points, want 4", len(writeAPI.times))
+	}
+	for i, got := range writeAPI.times {
+		if !got.Equal(timeOfDataPoint) {
+			t.Errorf("Backend point %v (%v) time = %v, want %v", i, writeAPI.measurements[i], got, timeOfDataPoint)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package influxdb
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// DefaultPrecision is the default timestamp precision of written points.
+const DefaultPrecision = "ns"
+
+// ErrInvalidPrecision write precision isn't one of s, ms, us, or ns.
+var ErrInvalidPrecision = errors.New("invalid write precision")
+
+// ParsePrecision parses a write precision, one of s, ms, us, or ns, to the timestamp unit it truncates points to.
+func ParsePrecision(precision string) (time.Duration, error) {
+	switch precision {
+	case "s":
+		return time.Second, nil
+	case "ms":
+		return time.Millisecond, nil
+	case "us":
+		return time.Microsecond, nil
+	case "ns":
+		return time.Nanosecond, nil
+	}
+
+	return 0, fmt.Errorf("%w %q, expected one of s, ms, us, or ns", ErrInvalidPrecision, precision)
+}
@@ -0,0 +1,51 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package influxdb
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParsePrecision(t *testing.T) {
+	tests := []struct {
+		precision string
+		want      time.Duration
+		wantErr   bool
+	}{
+		{precision: "s", want: time.Second},
+		{precision: "ms", want: time.Millisecond},
+		{precision: "us", want: time.Microsecond},
+		{precision: "ns", want: time.Nanosecond},
+		{precision: "", wantErr: true},
+		{precision: "m", wantErr: true},
+		{precision: "NS", wantErr: true},
+	}
+	for _, testcase := range tests {
+		got, err := ParsePrecision(testcase.precision)
+		if (err != nil) != testcase.wantErr {
+			t.Errorf("ParsePrecision(%q) error = %v, wantErr %v", testcase.precision, err, testcase.wantErr)
+
+			continue
+		}
+		if err != nil && !errors.Is(err, ErrInvalidPrecision) {
+			t.Errorf("ParsePrecision(%q) error = %v, want %v", testcase.precision, err, ErrInvalidPrecision)
+		}
+		if got != testcase.want {
+			t.Errorf("ParsePrecision(%q) = %v, want %v", testcase.precision, got, testcase.want)
+		}
+	}
+}
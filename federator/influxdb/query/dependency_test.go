@@ -0,0 +1,200 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"planet-exporter/federator"
+	federatorinfluxdb1 "planet-exporter/federator/influxdb1"
+
+	"github.com/influxdata/influxdb1-client/models"
+	influxdb1 "github.com/influxdata/influxdb1-client/v2"
+)
+
+// mockStoreClient is an InfluxDB that stores written points, and answers a query with a series per point of the
+// queried measurement, like the dependency queries selecting the last point and schema_version of each tag set, or
+// the MIN first_seen of the points with one.
+type mockStoreClient struct {
+	points []*influxdb1.Point
+	// queries received
+	queries []influxdb1.Query
+}
+
+func (m *mockStoreClient) Ping(time.Duration) (time.Duration, string, error) { return 0, "", nil }
+
+func (m *mockStoreClient) Write(bp influxdb1.BatchPoints) error {
+	m.points = append(m.points, bp.Points()...)
+
+	return nil
+}
+
+func (m *mockStoreClient) Query(q influxdb1.Query) (*influxdb1.Response, error) {
+	m.queries = append(m.queries, q)
+	fields := strings.Fields(q.Command)
+	measurement := ""
+	for i, field := range fields {
+		if field == "FROM" && i+1 < len(fields) {
+			measurement = fields[i+1]
+		}
+	}
+
+	series := []models.Row{}
+	for _, point := range m.points {
+		if point.Name() != measurement {
+			continue
+		}
+		fields, err := point.Fields()
+		if err != nil {
+			return nil, err
+		}
+		if strings.Contains(q.Command, `MIN("first_seen")`) {
+			tags := point.Tags()
+			delete(tags, "schema_version")
+			if firstSeen, found := fields["first_seen"]; found {
+				series = append(series, models.Row{ // nolint:exhaustivestruct
+					Name:    point.Name(),
+					Tags:    tags,
+					Columns: []string{"time", "min"},
+					Values:  [][]interface{}{{json.Number("0"), json.Number(fmt.Sprint(firstSeen))}},
+				})
+			}
+
+			continue
+		}
+		tags := point.Tags()
+		schemaVersion := tags["schema_version"]
+		delete(tags, "schema_version")
+		series = append(series, models.Row{ // nolint:exhaustivestruct
+			Name:    point.Name(),
+			Tags:    tags,
+			Columns: []string{"time", "last", "schema_version"},
+			Values:  [][]interface{}{{json.Number("0"), json.Number("1"), schemaVersion}},
+		})
+	}
+
+	return &influxdb1.Response{Results: []influxdb1.Result{{Series: series}}}, nil // nolint:exhaustivestruct
+}
+
+func (m *mockStoreClient) QueryAsChunk(influxdb1.Query) (*influxdb1.ChunkedResponse, error) {
+	return nil, nil // nolint:nilnil
+}
+
+func (m *mockStoreClient) Close() error { return nil }
+
+// TestClient_QueryFederatorDependencyLast7d locks in the contract between the backend writing dependencies and
+// the query reading them: each direction reads its remote hostgroup, address, and port from its own tags.
+func TestClient_QueryFederatorDependencyLast7d(t *testing.T) {
+	client := &mockStoreClient{} // nolint:exhaustivestruct
+	backend := federatorinfluxdb1.New(client, "mothership", "", 1, false)
+	ctx := context.Background()
+	now := time.Date(2021, 6, 1, 10, 0, 0, 0, time.UTC)
+	firstSeen := now.Add(-24 * time.Hour)
+
+	if err := backend.AddUpstreamService(ctx, federator.UpstreamService{
+		LocalProcessName:  "app",
+		LocalHostgroup:    "svc-a",
+		LocalAddress:      "a.local",
+		UpstreamHostgroup: "db",
+		UpstreamAddress:   "db.local",
+		UpstreamPort:      "5432",
+		Protocol:          "tcp",
+		FirstSeen:         &firstSeen,
+	}, now); err != nil {
+		t.Fatalf("Backend.AddUpstreamService() error = %v", err)
+	}
+	if err := backend.AddDownstreamService(ctx, federator.DownstreamService{
+		LocalProcessName:    "app",
+		LocalHostgroup:      "svc-a",
+		LocalAddress:        "a.local",
+		DownstreamHostgroup: "web",
+		DownstreamAddress:   "web.local",
+		LocalPort:           "8080",
+		Protocol:            "tcp",
+	}, now); err != nil {
+		t.Fatalf("Backend.AddDownstreamService() error = %v", err)
+	}
+
+	want := []Dependency{
+		{
+			Direction:                  "downstream",
+			Protocol:                   "tcp",
+			LocalHostgroupProcessName:  "app",
+			LocalHostgroup:             "svc-a",
+			LocalHostgroupAddress:      "a.local",
+			LocalHostgroupAddressPort:  "8080",
+			RemoteHostgroup:            "web",
+			RemoteHostgroupAddress:     "web.local",
+			RemoteHostgroupAddressPort: "",
+			SchemaVersion:              federator.SchemaVersion,
+		},
+		{
+			Direction:                  "upstream",
+			Protocol:                   "tcp",
+			LocalHostgroupProcessName:  "app",
+			LocalHostgroup:             "svc-a",
+			LocalHostgroupAddress:      "a.local",
+			LocalHostgroupAddressPort:  "",
+			RemoteHostgroup:            "db",
+			RemoteHostgroupAddress:     "db.local",
+			RemoteHostgroupAddressPort: "5432",
+			SchemaVersion:              federator.SchemaVersion,
+			FirstSeen:                  &firstSeen,
+		},
+	}
+	got, err := New(client, "mothership", "").QueryFederatorDependencyLast7d(ctx, Filter{}) // nolint:exhaustivestruct
+	if err != nil {
+		t.Fatalf("Client.QueryFederatorDependencyLast7d() error = %v", err)
+	}
+	sort.Slice(got, func(i, j int) bool { return got[i].Direction < got[j].Direction })
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Client.QueryFederatorDependencyLast7d() = %+v, want %+v", got, want)
+	}
+}
+
+func TestClient_retentionPolicy(t *testing.T) {
+	tests := []struct {
+		name            string
+		retentionPolicy string
+	}{
+		{name: "Database default", retentionPolicy: ""},
+		{name: "Retention policy", retentionPolicy: "one_year"},
+	}
+	for _, testcase := range tests {
+		t.Run(testcase.name, func(t *testing.T) {
+			client := &mockStoreClient{} // nolint:exhaustivestruct
+			c := New(client, "mothership", testcase.retentionPolicy)
+			_, _ = c.QueryFederatorDependencyLast7d(context.Background(), Filter{}) // nolint:exhaustivestruct
+			_, _ = c.QueryFederatorTraffic(context.Background(), Filter{}, false)   // nolint:exhaustivestruct
+
+			if len(client.queries) == 0 {
+				t.Fatalf("Client queries = 0, want > 0")
+			}
+			for _, query := range client.queries {
+				if query.Database != "mothership" || query.RetentionPolicy != testcase.retentionPolicy {
+					t.Errorf("Client query database = %v, retention policy = %v, want mothership, %v",
+						query.Database, query.RetentionPolicy, testcase.retentionPolicy)
+				}
+			}
+		})
+	}
+}
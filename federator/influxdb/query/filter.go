@@ -0,0 +1,84 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// identifierPattern is the set of identifiers (e.g. tag keys) we allow to be rendered into InfluxQL.
+var identifierPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Filter narrows down the federator data returned by queries.
+type Filter struct {
+	// Hostgroups limits results to rows where the local hostgroup is one of these.
+	// Empty means no hostgroup filtering.
+	Hostgroups []string
+
+	// Directions limits traffic queries to these traffic directions (measurements).
+	// Empty means both ingress and egress.
+	Directions []string
+}
+
+// trafficDirections returns the traffic directions to query.
+func (f Filter) trafficDirections() []string {
+	if len(f.Directions) == 0 {
+		return []string{"ingress", "egress"}
+	}
+
+	return f.Directions
+}
+
+// whereClause renders the filter as an InfluxQL condition, always including a non-empty
+// local hostgroup condition.
+func (f Filter) whereClause() (string, error) {
+	serviceIdent, err := quoteIdentifier("service")
+	if err != nil {
+		return "", err
+	}
+
+	clause := "(" + serviceIdent + " != '')"
+	if len(f.Hostgroups) == 0 {
+		return clause, nil
+	}
+
+	conditions := make([]string, 0, len(f.Hostgroups))
+	for _, hostgroup := range f.Hostgroups {
+		conditions = append(conditions, serviceIdent+" = "+quoteStringLiteral(hostgroup))
+	}
+
+	return clause + " AND (" + strings.Join(conditions, " OR ") + ")", nil
+}
+
+// quoteIdentifier returns a double-quoted InfluxQL identifier after validating it only contains safe characters.
+func quoteIdentifier(name string) (string, error) {
+	if !identifierPattern.MatchString(name) {
+		return "", errors.Errorf("invalid identifier %q", name)
+	}
+
+	return `"` + name + `"`, nil
+}
+
+// quoteStringLiteral returns a single-quoted InfluxQL string literal.
+// InfluxQL escapes quotes in string literals with a backslash, so backslashes are escaped first.
+func quoteStringLiteral(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `'`, `\'`)
+
+	return "'" + s + "'"
+}
@@ -0,0 +1,99 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import (
+	"testing"
+)
+
+func TestFilter_whereClause(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter Filter
+		want   string
+	}{
+		{
+			name:   "No hostgroups",
+			filter: Filter{},
+			want:   `("service" != '')`,
+		},
+		{
+			name:   "Single hostgroup",
+			filter: Filter{Hostgroups: []string{"svc-a"}},
+			want:   `("service" != '') AND ("service" = 'svc-a')`,
+		},
+		{
+			name:   "Multiple hostgroups",
+			filter: Filter{Hostgroups: []string{"svc-a", "svc-b"}},
+			want:   `("service" != '') AND ("service" = 'svc-a' OR "service" = 'svc-b')`,
+		},
+		{
+			name:   "Hostgroup with spaces",
+			filter: Filter{Hostgroups: []string{"my service"}},
+			want:   `("service" != '') AND ("service" = 'my service')`,
+		},
+		{
+			name:   "Hostgroup with single quotes",
+			filter: Filter{Hostgroups: []string{"x' OR ''='"}},
+			want:   `("service" != '') AND ("service" = 'x\' OR \'\'=\'')`,
+		},
+		{
+			name:   "Hostgroup with a trailing backslash cannot escape the closing quote",
+			filter: Filter{Hostgroups: []string{`svc\`}},
+			want:   `("service" != '') AND ("service" = 'svc\\')`,
+		},
+	}
+	for _, testcase := range tests {
+		t.Run(testcase.name, func(t *testing.T) {
+			got, err := testcase.filter.whereClause()
+			if err != nil {
+				t.Errorf("Filter.whereClause() error = %v", err)
+
+				return
+			}
+			if got != testcase.want {
+				t.Errorf("Filter.whereClause() = %v, want %v", got, testcase.want)
+			}
+		})
+	}
+}
+
+func Test_quoteIdentifier(t *testing.T) {
+	tests := []struct {
+		name    string
+		ident   string
+		want    string
+		wantErr bool
+	}{
+		{name: "Tag key", ident: "remote_service", want: `"remote_service"`},
+		{name: "Identifier with spaces", ident: "remote service", wantErr: true},
+		{name: "Identifier with quotes", ident: `service" OR "1`, wantErr: true},
+		{name: "Identifier starting with a digit", ident: "1service", wantErr: true},
+		{name: "Empty identifier", ident: "", wantErr: true},
+	}
+	for _, testcase := range tests {
+		t.Run(testcase.name, func(t *testing.T) {
+			got, err := quoteIdentifier(testcase.ident)
+			if (err != nil) != testcase.wantErr {
+				t.Errorf("quoteIdentifier() error = %v, wantErr %v", err, testcase.wantErr)
+
+				return
+			}
+			if got != testcase.want {
+				t.Errorf("quoteIdentifier() = %v, want %v", got, testcase.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,384 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"planet-exporter/federator/influxdb"
+
+	"github.com/influxdata/influxdb-client-go/v2/api"
+	influxdb2query "github.com/influxdata/influxdb-client-go/v2/api/query"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// Query languages of the federator data queries.
+const (
+	// InfluxQL queries an InfluxDB 1.x database, or the v1 compatibility API of InfluxDB 2.x (see New).
+	InfluxQL = "influxql"
+	// Flux queries an InfluxDB 2.x bucket (see NewFlux).
+	Flux = "flux"
+)
+
+// FluxQueryAPI executes Flux queries (e.g. the QueryAPI of an InfluxDB 2.x client).
+type FluxQueryAPI interface {
+	Query(ctx context.Context, query string) (*api.QueryTableResult, error)
+}
+
+// NewFlux client for querying the planet-federator data of an InfluxDB 2.x bucket with Flux, for servers without InfluxQL.
+// It returns the same data as the InfluxQL client returned by New.
+func NewFlux(queryAPI FluxQueryAPI, bucket string) *Client {
+	return &Client{
+		client:          nil,
+		database:        "",
+		retentionPolicy: "",
+		flux:            queryAPI,
+		bucket:          bucket,
+		nullValue:       0,
+	}
+}
+
+// Tags the federator data is grouped by, like the GROUP BY of the InfluxQL queries. The schema version isn't grouped by,
+// it's read from the last point of each group.
+var (
+	trafficGroupTags = []string{
+		influxdb.LocalServiceHostgroupTag, influxdb.LocalServiceAddressTag, influxdb.RemoteServiceHostgroupTag,
+		influxdb.RemoteServiceAddressTag, influxdb.LocalInstanceTag,
+	}
+	upstreamGroupTags = []string{
+		influxdb.LocalServiceHostgroupTag, influxdb.LocalServiceAddressTag, influxdb.UpstreamServiceHostgroupTag,
+		influxdb.UpstreamServiceAddressTag, influxdb.LocalServiceProcessNameTag, influxdb.UpstreamServicePortTag,
+		influxdb.ProtocolTag,
+	}
+	downstreamGroupTags = []string{
+		influxdb.LocalServiceHostgroupTag, influxdb.LocalServiceAddressTag, influxdb.DownstreamServiceHostgroupTag,
+		influxdb.DownstreamServiceAddressTag, influxdb.LocalServiceProcessNameTag, influxdb.LocalServicePortTag,
+		influxdb.ProtocolTag,
+	}
+)
+
+// trafficStatistics are the results yielded by the traffic Flux query, in the order of the TrafficBandwidth values
+// (i.e. MIN, MAX, MEAN[, PERCENTILE 95, PERCENTILE 99]).
+var trafficStatistics = []string{"min", "max", "mean", "p95", "p99"}
+
+// queryFederatorTrafficFlux is QueryFederatorTraffic with Flux.
+func (c *Client) queryFederatorTrafficFlux(ctx context.Context, filter Filter, withPercentiles bool) ([]TrafficBandwidth, error) {
+	trafficData := []TrafficBandwidth{}
+
+	predicate := filter.fluxPredicate()
+
+	const queryParamTimeRange = "1h"
+	for _, queryParamDirection := range filter.trafficDirections() {
+		log.Debugf("queryParam direction=%v, timerange=%v", queryParamDirection, queryParamTimeRange)
+
+		// Measurements are validated like the InfluxQL identifiers
+		if _, err := quoteIdentifier(queryParamDirection); err != nil {
+			return []TrafficBandwidth{}, errors.Wrap(err, "failed to render traffic direction")
+		}
+
+		yieldPercentiles := ""
+		if withPercentiles {
+			// The exact_selector method returns a point's value, like the InfluxQL PERCENTILE
+			yieldPercentiles = `
+			data |> quantile(q: 0.95, method: "exact_selector") |> yield(name: "p95")
+			data |> quantile(q: 0.99, method: "exact_selector") |> yield(name: "p99")`
+		}
+
+		q := `
+			data = from(bucket: %v)
+				|> range(start: -%v)
+				|> filter(fn: (r) => r._measurement == %v and r._field == %v)
+				|> filter(fn: (r) => %v)
+				|> group(columns: %v)
+			data |> min() |> yield(name: "min")
+			data |> max() |> yield(name: "max")
+			data |> mean() |> yield(name: "mean")
+			data |> last() |> yield(name: "last")%v
+		`
+		renderedQuery := fmt.Sprintf(q, quoteFluxString(c.bucket), queryParamTimeRange, quoteFluxString(queryParamDirection),
+			quoteFluxString(influxdb.BandwidthBpsField), predicate, fluxGroupColumns(trafficGroupTags), yieldPercentiles)
+
+		results, err := c.queryFederatorTrafficDataFlux(ctx, renderedQuery, withPercentiles)
+		if err != nil {
+			return []TrafficBandwidth{}, errors.Wrapf(err, "failed to query %v traffic data for time range %v", queryParamDirection, queryParamTimeRange)
+		}
+
+		trafficData = append(trafficData, results...)
+	}
+
+	return trafficData, nil
+}
+
+// queryFederatorTrafficDataFlux executes the traffic Flux query and merges the statistics of each tag set into a row.
+func (c *Client) queryFederatorTrafficDataFlux(ctx context.Context, fluxQuery string, withPercentiles bool) ([]TrafficBandwidth, error) {
+	statistics := trafficStatistics[:3]
+	if withPercentiles {
+		statistics = trafficStatistics
+	}
+
+	type trafficSeries struct {
+		measurement   string
+		tags          map[string]string
+		values        []int64
+		schemaVersion string
+	}
+	series := map[string]*trafficSeries{}
+
+	err := c.queryFlux(ctx, fluxQuery, func(record *influxdb2query.FluxRecord) error {
+		statistic := fluxString(record.ValueByKey("result"))
+		index := -1
+		for i, s := range statistics {
+			if s == statistic {
+				index = i
+			}
+		}
+		if index < 0 && statistic != "last" {
+			return nil
+		}
+
+		tags := fluxTags(record, trafficGroupTags)
+		key := seriesKey(record.Measurement(), tags, trafficGroupTags)
+		if _, found := series[key]; !found {
+			values := make([]int64, len(statistics))
+			for i := range values {
+				values[i] = c.nullValue
+			}
+			series[key] = &trafficSeries{measurement: record.Measurement(), tags: tags, values: values, schemaVersion: ""}
+		}
+
+		// The last point has the schema version the edge was last written with
+		if statistic == "last" {
+			series[key].schemaVersion = fluxString(record.ValueByKey(influxdb.SchemaVersionTag))
+
+			return nil
+		}
+
+		value, err := fluxValueToInteger(record.Value(), c.nullValue)
+		if err != nil {
+			return errors.Wrapf(err, "failed to convert %v value", statistic)
+		}
+		series[key].values[index] = value
+
+		return nil
+	})
+	if err != nil {
+		return []TrafficBandwidth{}, err
+	}
+	if len(series) == 0 {
+		return []TrafficBandwidth{}, errors.New("received empty data")
+	}
+
+	// Rows are returned in the series order of InfluxQL
+	keys := make([]string, 0, len(series))
+	for key := range series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	trafficData := make([]TrafficBandwidth, 0, len(keys))
+	for _, key := range keys {
+		tags := withSchemaVersion(series[key].tags, series[key].schemaVersion)
+		trafficData = append(trafficData, newTrafficBandwidth(series[key].measurement, tags, series[key].values, withPercentiles))
+	}
+
+	return trafficData, nil
+}
+
+// queryFederatorDependencyLast7dFlux is QueryFederatorDependencyLast7d with Flux.
+func (c *Client) queryFederatorDependencyLast7dFlux(ctx context.Context, filter Filter) ([]Dependency, error) {
+	dependencyData := []Dependency{}
+
+	upstreamData, err := c.queryFederatorDependencyDataFlux(ctx, influxdb.UpstreamServiceMeasurement, upstreamGroupTags, filter)
+	if err != nil {
+		return []Dependency{}, errors.Wrap(err, "failed to query upstream dependency data")
+	}
+
+	downstreamData, err := c.queryFederatorDependencyDataFlux(ctx, influxdb.DownstreamServiceMeasurement, downstreamGroupTags, filter)
+	if err != nil {
+		return []Dependency{}, errors.Wrap(err, "failed to query downstream dependency data")
+	}
+
+	dependencyData = append(dependencyData, upstreamData...)
+	dependencyData = append(dependencyData, downstreamData...)
+
+	return dependencyData, nil
+}
+
+// queryFederatorDependencyDataFlux executes the dependency Flux query of a measurement, which selects the last point of
+// each tag set so every dependency is returned once with its latest schema version, like the InfluxQL LAST, and the
+// MIN of its first_seen field.
+func (c *Client) queryFederatorDependencyDataFlux(ctx context.Context, measurement string, groupTags []string, filter Filter) ([]Dependency, error) {
+	q := `
+		data = from(bucket: %v)
+			|> range(start: -7d)
+			|> filter(fn: (r) => r._measurement == %v and (r._field == %v or r._field == %v))
+			|> filter(fn: (r) => %v)
+			|> group(columns: %v)
+		data |> filter(fn: (r) => r._field == %v) |> last() |> yield(name: "last")
+		data |> filter(fn: (r) => r._field == %v) |> min() |> yield(name: "first_seen")
+	`
+	dependencyField, firstSeenField := quoteFluxString(influxdb.ServiceDependencyField), quoteFluxString(influxdb.FirstSeenField)
+	renderedQuery := fmt.Sprintf(q, quoteFluxString(c.bucket), quoteFluxString(measurement), dependencyField, firstSeenField,
+		filter.fluxPredicate(), fluxGroupColumns(groupTags), dependencyField, firstSeenField)
+
+	series := map[string]Dependency{}
+	firstSeen := map[string]time.Time{}
+	err := c.queryFlux(ctx, renderedQuery, func(record *influxdb2query.FluxRecord) error {
+		tags := fluxTags(record, groupTags)
+		key := seriesKey(record.Measurement(), tags, groupTags)
+		if fluxString(record.ValueByKey("result")) == "first_seen" {
+			seconds, err := fluxValueToInteger(record.Value(), 0)
+			if err != nil {
+				return errors.Wrap(err, "failed to convert first_seen value")
+			}
+			firstSeen[key] = time.Unix(seconds, 0).UTC()
+
+			return nil
+		}
+		series[key] = newDependency(record.Measurement(), withSchemaVersion(tags, fluxString(record.ValueByKey(influxdb.SchemaVersionTag))))
+
+		return nil
+	})
+	if err != nil {
+		return []Dependency{}, err
+	}
+	if len(series) == 0 {
+		return []Dependency{}, errors.New("received empty data")
+	}
+
+	keys := make([]string, 0, len(series))
+	for key := range series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	dependencyData := make([]Dependency, 0, len(keys))
+	for _, key := range keys {
+		dependency := series[key]
+		if t, found := firstSeen[key]; found {
+			dependency.FirstSeen = &t
+		}
+		dependencyData = append(dependencyData, dependency)
+	}
+
+	return dependencyData, nil
+}
+
+// queryFlux executes the Flux query and calls f with every record of its results.
+func (c *Client) queryFlux(ctx context.Context, fluxQuery string, f func(record *influxdb2query.FluxRecord) error) error {
+	result, err := c.flux.Query(ctx, fluxQuery)
+	if err != nil {
+		return errors.Wrap(err, "failed to query Flux")
+	}
+	defer result.Close()
+
+	for result.Next() {
+		if err := f(result.Record()); err != nil {
+			return err
+		}
+	}
+	if result.Err() != nil {
+		return errors.Wrap(result.Err(), "received invalid response")
+	}
+
+	return nil
+}
+
+// fluxPredicate renders the filter as a Flux predicate of a record r, always including a non-empty local hostgroup
+// condition like the InfluxQL whereClause.
+func (f Filter) fluxPredicate() string {
+	predicate := `r.service != ""`
+	if len(f.Hostgroups) == 0 {
+		return predicate
+	}
+
+	conditions := make([]string, 0, len(f.Hostgroups))
+	for _, hostgroup := range f.Hostgroups {
+		conditions = append(conditions, "r.service == "+quoteFluxString(hostgroup))
+	}
+
+	return predicate + " and (" + strings.Join(conditions, " or ") + ")"
+}
+
+// quoteFluxString returns a double-quoted Flux string literal.
+// Flux escapes backslashes, double quotes, and the dollar sign of string interpolation with a backslash.
+func quoteFluxString(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	s = strings.ReplaceAll(s, `$`, `\$`)
+
+	return `"` + s + `"`
+}
+
+// fluxGroupColumns renders the group key of a measurement and its tags as a Flux array.
+func fluxGroupColumns(tags []string) string {
+	columns := []string{quoteFluxString("_measurement")}
+	for _, tag := range tags {
+		columns = append(columns, quoteFluxString(tag))
+	}
+
+	return "[" + strings.Join(columns, ", ") + "]"
+}
+
+// fluxTags returns the tags of a record, a tag is missing if its column is null.
+func fluxTags(record *influxdb2query.FluxRecord, tags []string) map[string]string {
+	values := map[string]string{}
+	for _, tag := range tags {
+		if value := fluxString(record.ValueByKey(tag)); value != "" {
+			values[tag] = value
+		}
+	}
+
+	return values
+}
+
+// fluxString returns the string of a Flux column value, empty if it's null or not a string.
+func fluxString(value interface{}) string {
+	s, _ := value.(string)
+
+	return s
+}
+
+// seriesKey identifies a series by its measurement and tags, it also orders series like InfluxQL.
+func seriesKey(measurement string, tags map[string]string, tagKeys []string) string {
+	key := measurement
+	for _, tag := range tagKeys {
+		key += "\x00" + tags[tag]
+	}
+
+	return key
+}
+
+// fluxValueToInteger converts a Flux value to int64, rounding decimals half-up like InfluxQL values.
+// Nil values (e.g. the mean of no point) are nullValue.
+func fluxValueToInteger(value interface{}, nullValue int64) (int64, error) {
+	switch v := value.(type) {
+	case nil:
+		return nullValue, nil
+	case int64:
+		return v, nil
+	case uint64:
+		return roundToInteger(float64(v))
+	case float64:
+		return roundToInteger(v)
+	default:
+		return -1, errors.Errorf("unexpected value type %T", value)
+	}
+}
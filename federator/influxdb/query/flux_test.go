@@ -0,0 +1,269 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+)
+
+// Recorded annotated CSV responses of the Flux queries. The svc-a traffic has two result tables per statistic, one
+// of them per-instance, and its schema version changed from 2 to 3 within the time range. The older svc-c points have
+// no schema_version. The schema_version isn't in the group key, so only the selectors (min, max, last) return it.
+const (
+	recordedEgressTrafficCSV = `#datatype,string,long,string,string,string,string,string,string,string,double
+#group,false,false,true,true,true,true,true,true,false,false
+#default,_result,,,,,,,,,
+,result,table,_measurement,service,address,remote_service,remote_address,local_instance,schema_version,_value
+,min,0,egress,svc-a,a.local,svc-b,b.local,,2,1000
+,min,1,egress,svc-a,a.local,svc-b,b.local,10.0.0.1:19100,2,400
+,min,2,egress,svc-c,c.local,svc-b,b.local,,,10
+
+#datatype,string,long,string,string,string,string,string,string,string,double
+#group,false,false,true,true,true,true,true,true,false,false
+#default,_result,,,,,,,,,
+,result,table,_measurement,service,address,remote_service,remote_address,local_instance,schema_version,_value
+,max,0,egress,svc-a,a.local,svc-b,b.local,,3,3000
+,max,1,egress,svc-a,a.local,svc-b,b.local,10.0.0.1:19100,3,600
+,max,2,egress,svc-c,c.local,svc-b,b.local,,,30
+
+#datatype,string,long,string,string,string,string,string,string,double
+#group,false,false,true,true,true,true,true,true,false
+#default,_result,,,,,,,,
+,result,table,_measurement,service,address,remote_service,remote_address,local_instance,_value
+,mean,0,egress,svc-a,a.local,svc-b,b.local,,1999.5
+,mean,1,egress,svc-a,a.local,svc-b,b.local,10.0.0.1:19100,500.4
+,mean,2,egress,svc-c,c.local,svc-b,b.local,,20
+
+#datatype,string,long,string,string,string,string,string,string,string,double
+#group,false,false,true,true,true,true,true,true,false,false
+#default,_result,,,,,,,,,
+,result,table,_measurement,service,address,remote_service,remote_address,local_instance,schema_version,_value
+,last,0,egress,svc-a,a.local,svc-b,b.local,,3,2000
+,last,1,egress,svc-a,a.local,svc-b,b.local,10.0.0.1:19100,3,500
+,last,2,egress,svc-c,c.local,svc-b,b.local,,,20
+
+#datatype,string,long,string,string,string,string,string,string,string,double
+#group,false,false,true,true,true,true,true,true,false,false
+#default,_result,,,,,,,,,
+,result,table,_measurement,service,address,remote_service,remote_address,local_instance,schema_version,_value
+,p95,0,egress,svc-a,a.local,svc-b,b.local,,3,2900
+,p95,1,egress,svc-a,a.local,svc-b,b.local,10.0.0.1:19100,3,590
+,p95,2,egress,svc-c,c.local,svc-b,b.local,,,29
+,p99,3,egress,svc-a,a.local,svc-b,b.local,,3,2990
+,p99,4,egress,svc-a,a.local,svc-b,b.local,10.0.0.1:19100,3,599
+,p99,5,egress,svc-c,c.local,svc-b,b.local,,,30
+`
+	recordedUpstreamCSV = `#datatype,string,long,string,string,string,string,string,string,string,string,string,long
+#group,false,false,true,true,true,true,true,true,true,true,false,false
+#default,_result,,,,,,,,,,,
+,result,table,_measurement,service,address,upstream_service,upstream_address,process_name,upstream_port,protocol,schema_version,_value
+,last,0,upstream,svc-a,a.local,svc-db,db.local,app,5432,tcp,2,1
+,last,1,upstream,svc-a,a.local,svc-cache,cache.local,app,6379,tcp,,1
+
+#datatype,string,long,string,string,string,string,string,string,string,string,string,long
+#group,false,false,true,true,true,true,true,true,true,true,false,false
+#default,_result,,,,,,,,,,,
+,result,table,_measurement,service,address,upstream_service,upstream_address,process_name,upstream_port,protocol,schema_version,_value
+,first_seen,2,upstream,svc-a,a.local,svc-db,db.local,app,5432,tcp,2,1622455200
+`
+	recordedDownstreamCSV = `#datatype,string,long,string,string,string,string,string,string,string,string,string,long
+#group,false,false,true,true,true,true,true,true,true,true,false,false
+#default,_result,,,,,,,,,,,
+,result,table,_measurement,service,address,downstream_service,downstream_address,process_name,port,protocol,schema_version,_value
+,last,0,downstream,svc-a,a.local,svc-web,web.local,app,80,tcp,2,1
+`
+)
+
+// mockFluxServer returns an InfluxDB 2.x server responding to a Flux query with the CSV of the first measurement
+// filtered by the query, and records the queries.
+func mockFluxServer(t *testing.T, responses map[string]string, queries *[]string) *httptest.Server {
+	t.Helper()
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Query string `json:"query"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+
+			return
+		}
+		*queries = append(*queries, body.Query)
+
+		w.Header().Set("Content-Type", "text/csv")
+		for measurement, csv := range responses {
+			if strings.Contains(body.Query, fmt.Sprintf("r._measurement == %q", measurement)) {
+				fmt.Fprint(w, csv)
+
+				return
+			}
+		}
+	}))
+}
+
+func TestClient_QueryFederatorTraffic_flux(t *testing.T) {
+	var queries []string
+	server := mockFluxServer(t, map[string]string{"egress": recordedEgressTrafficCSV}, &queries)
+	defer server.Close()
+	influxdbClient := influxdb2.NewClient(server.URL, "token")
+	defer influxdbClient.Close()
+
+	c := NewFlux(influxdbClient.QueryAPI("org"), "planet")
+	got, err := c.QueryFederatorTraffic(context.Background(), Filter{Hostgroups: []string{"svc-a", "svc-c"}, Directions: []string{"egress"}}, true)
+	if err != nil {
+		t.Fatalf("Client.QueryFederatorTraffic() error = %v", err)
+	}
+
+	traffic := func(localHostgroup, localInstance string, min, max, avg, p95, p99, schemaVersion int64) TrafficBandwidth {
+		return TrafficBandwidth{
+			TrafficDirection: "egress", LocalHostgroup: localHostgroup, LocalHostgroupAddress: strings.TrimPrefix(localHostgroup, "svc-") + ".local",
+			RemoteHostgroup: "svc-b", RemoteHostgroupAddress: "b.local", TrafficBandwidthBitsMin1h: min, TrafficBandwidthBitsMax1h: max,
+			TrafficBandwidthBitsAvg1h: avg, TrafficBandwidthBitsP95: p95, TrafficBandwidthBitsP99: p99, LocalInstance: localInstance,
+			SchemaVersion: schemaVersion,
+		}
+	}
+	want := []TrafficBandwidth{
+		traffic("svc-a", "", 1000, 3000, 2000, 2900, 2990, 3),
+		traffic("svc-a", "10.0.0.1:19100", 400, 600, 500, 590, 599, 3),
+		traffic("svc-c", "", 10, 30, 20, 29, 30, 0),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Client.QueryFederatorTraffic() = %+v, want %+v", got, want)
+	}
+
+	if len(queries) != 1 {
+		t.Fatalf("Client.QueryFederatorTraffic() sent %v queries, want 1", len(queries))
+	}
+	for _, part := range []string{
+		`from(bucket: "planet")`, `range(start: -1h)`, `r._field == "bandwidth_bps"`,
+		`r.service != "" and (r.service == "svc-a" or r.service == "svc-c")`,
+		`group(columns: ["_measurement", "service", "address", "remote_service", "remote_address", "local_instance"])`,
+		`yield(name: "min")`, `yield(name: "max")`, `yield(name: "mean")`, `yield(name: "last")`, `quantile(q: 0.95, method: "exact_selector")`,
+	} {
+		if !strings.Contains(queries[0], part) {
+			t.Errorf("Client.QueryFederatorTraffic() query = %v, want it to contain %v", queries[0], part)
+		}
+	}
+
+	if _, err := c.QueryFederatorTraffic(context.Background(), Filter{Directions: []string{"ingress"}}, false); err == nil {
+		t.Errorf("Client.QueryFederatorTraffic() of no data error = nil, want an error")
+	}
+}
+
+func TestClient_QueryFederatorDependencyLast7d_flux(t *testing.T) {
+	var queries []string
+	server := mockFluxServer(t, map[string]string{"upstream": recordedUpstreamCSV, "downstream": recordedDownstreamCSV}, &queries)
+	defer server.Close()
+	influxdbClient := influxdb2.NewClient(server.URL, "token")
+	defer influxdbClient.Close()
+
+	c := NewFlux(influxdbClient.QueryAPI("org"), "planet")
+	got, err := c.QueryFederatorDependencyLast7d(context.Background(), Filter{})
+	if err != nil {
+		t.Fatalf("Client.QueryFederatorDependencyLast7d() error = %v", err)
+	}
+
+	// The last point of a tag set is a single dependency, with the schema version it was last written with, and the
+	// first-seen time of the dependencies written with one
+	firstSeen := time.Date(2021, 5, 31, 10, 0, 0, 0, time.UTC)
+	want := []Dependency{
+		{
+			Direction: "upstream", Protocol: "tcp", LocalHostgroupProcessName: "app", LocalHostgroup: "svc-a", LocalHostgroupAddress: "a.local",
+			RemoteHostgroup: "svc-cache", RemoteHostgroupAddress: "cache.local", RemoteHostgroupAddressPort: "6379",
+		},
+		{
+			Direction: "upstream", Protocol: "tcp", LocalHostgroupProcessName: "app", LocalHostgroup: "svc-a", LocalHostgroupAddress: "a.local",
+			RemoteHostgroup: "svc-db", RemoteHostgroupAddress: "db.local", RemoteHostgroupAddressPort: "5432", SchemaVersion: 2,
+			FirstSeen: &firstSeen,
+		},
+		{
+			Direction: "downstream", Protocol: "tcp", LocalHostgroupProcessName: "app", LocalHostgroup: "svc-a", LocalHostgroupAddress: "a.local",
+			LocalHostgroupAddressPort: "80", RemoteHostgroup: "svc-web", RemoteHostgroupAddress: "web.local", SchemaVersion: 2,
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Client.QueryFederatorDependencyLast7d() = %+v, want %+v", got, want)
+	}
+
+	if len(queries) != 2 || !strings.Contains(queries[0], "range(start: -7d)") || !strings.Contains(queries[0], "last()") ||
+		!strings.Contains(queries[0], `r._field == "first_seen") |> min()`) ||
+		strings.Contains(queries[0], `"schema_version"`) {
+		t.Errorf("Client.QueryFederatorDependencyLast7d() queries = %v, want a 7d last query per measurement not grouped by schema_version", queries)
+	}
+}
+
+func TestFilter_fluxPredicate(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter Filter
+		want   string
+	}{
+		{name: "No hostgroups", filter: Filter{}, want: `r.service != ""`},
+		{name: "Multiple hostgroups", filter: Filter{Hostgroups: []string{"svc-a", "svc-b"}}, want: `r.service != "" and (r.service == "svc-a" or r.service == "svc-b")`},
+		{name: "Hostgroup with double quotes", filter: Filter{Hostgroups: []string{`x" or "1`}}, want: `r.service != "" and (r.service == "x\" or \"1")`},
+		{name: "Hostgroup with interpolation", filter: Filter{Hostgroups: []string{`${x}`}}, want: `r.service != "" and (r.service == "\${x}")`},
+		{name: "Hostgroup with a trailing backslash", filter: Filter{Hostgroups: []string{`svc\`}}, want: `r.service != "" and (r.service == "svc\\")`},
+	}
+	for _, testcase := range tests {
+		t.Run(testcase.name, func(t *testing.T) {
+			if got := testcase.filter.fluxPredicate(); got != testcase.want {
+				t.Errorf("Filter.fluxPredicate() = %v, want %v", got, testcase.want)
+			}
+		})
+	}
+}
+
+func TestClient_QueryFederatorTraffic_fluxNullValue(t *testing.T) {
+	// The svc-a MIN is null, and its MEAN is missing
+	const recordedCSV = `#datatype,string,long,string,string,string,string,string,string,string,double
+#group,false,false,true,true,true,true,true,true,false,false
+#default,_result,,,,,,,,,
+,result,table,_measurement,service,address,remote_service,remote_address,local_instance,schema_version,_value
+,min,0,egress,svc-a,a.local,svc-b,b.local,,2,
+,max,1,egress,svc-a,a.local,svc-b,b.local,,2,3000
+,last,2,egress,svc-a,a.local,svc-b,b.local,,2,3000
+`
+	var queries []string
+	server := mockFluxServer(t, map[string]string{"egress": recordedCSV}, &queries)
+	defer server.Close()
+	influxdbClient := influxdb2.NewClient(server.URL, "token")
+	defer influxdbClient.Close()
+
+	c := NewFlux(influxdbClient.QueryAPI("org"), "planet")
+	c.SetNullValue(-1)
+	got, err := c.QueryFederatorTraffic(context.Background(), Filter{Directions: []string{"egress"}}, false)
+	if err != nil {
+		t.Fatalf("Client.QueryFederatorTraffic() error = %v", err)
+	}
+
+	want := []TrafficBandwidth{{
+		TrafficDirection: "egress", LocalHostgroup: "svc-a", LocalHostgroupAddress: "a.local", RemoteHostgroup: "svc-b",
+		RemoteHostgroupAddress: "b.local", TrafficBandwidthBitsMin1h: -1, TrafficBandwidthBitsMax1h: 3000,
+		TrafficBandwidthBitsAvg1h: -1, SchemaVersion: 2,
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Client.QueryFederatorTraffic() = %+v, want %+v", got, want)
+	}
+}
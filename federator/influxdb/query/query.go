@@ -18,11 +18,15 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"math"
 	"strconv"
-	"strings"
+	"time"
+
+	"planet-exporter/federator/influxdb"
 
 	"github.com/pkg/errors"
 
+	"github.com/influxdata/influxdb1-client/models"
 	influxdb1 "github.com/influxdata/influxdb1-client/v2"
 	log "github.com/sirupsen/logrus"
 )
@@ -31,16 +35,41 @@ import (
 type Client struct {
 	client   influxdb1.Client
 	database string
+	// retentionPolicy queried, the database default if empty
+	retentionPolicy string
+
+	// flux queries the bucket with Flux instead of InfluxQL, if it's set (see NewFlux)
+	flux   FluxQueryAPI
+	bucket string
+
+	// nullValue of a null traffic statistic (e.g. no data in a group), zero by default
+	nullValue int64
 }
 
 // New client for querying InfluxDB client compatible with planet-federator (currently using v1).
-func New(client influxdb1.Client, database string) *Client {
+// The federator data is queried from the retentionPolicy of the database, or its default retention policy if empty.
+func New(client influxdb1.Client, database string, retentionPolicy string) *Client {
 	return &Client{
-		client:   client,
-		database: database,
+		client:          client,
+		database:        database,
+		retentionPolicy: retentionPolicy,
+		flux:            nil,
+		bucket:          "",
+		nullValue:       0,
 	}
 }
 
+// SetNullValue sets the value of null traffic statistics (e.g. the MIN of a group without data), instead of zero.
+// A sentinel (e.g. -1) tells them apart from zero traffic, while the other statistics of the row are kept.
+func (c *Client) SetNullValue(nullValue int64) {
+	c.nullValue = nullValue
+}
+
+// newQuery returns the query of command on the client's database and retention policy.
+func (c *Client) newQuery(command string) influxdb1.Query {
+	return influxdb1.NewQueryWithRP(command, c.database, c.retentionPolicy, "")
+}
+
 // TrafficBandwidth represents federator traffic bandwidth data.
 type TrafficBandwidth struct {
 	TrafficDirection          string `json:"traffic_direction"`
@@ -51,35 +80,78 @@ type TrafficBandwidth struct {
 	TrafficBandwidthBitsMin1h int64  `json:"traffic_bandwidth_bits_min_1h"`
 	TrafficBandwidthBitsMax1h int64  `json:"traffic_bandwidth_bits_max_1h"`
 	TrafficBandwidthBitsAvg1h int64  `json:"traffic_bandwidth_bits_avg_1h"`
+
+	// TrafficBandwidthBitsP95 and TrafficBandwidthBitsP99 are the 1h percentiles, only queried when requested.
+	TrafficBandwidthBitsP95 int64 `json:"traffic_bandwidth_bits_p95_1h,omitempty"`
+	TrafficBandwidthBitsP99 int64 `json:"traffic_bandwidth_bits_p99_1h,omitempty"`
+
+	// LocalInstance is the planet-exporter instance of per-instance traffic, empty for hostgroup traffic
+	LocalInstance string `json:"local_instance,omitempty"`
+
+	// SchemaVersion the data was written with, zero if it was written before schema versions
+	SchemaVersion int64 `json:"schema_version,omitempty"`
+
+	// FirstSeen is the earliest first_seen field of the dependency, nil if it was written without first-seen tracking
+	FirstSeen *time.Time `json:"first_seen,omitempty"`
 }
 
-// QueryFederatorTraffic returns ingress & egress federator traffic data from InfluxDB.
-func (c *Client) QueryFederatorTraffic(ctx context.Context) ([]TrafficBandwidth, error) {
+// QueryFederatorTraffic returns federator traffic data from InfluxDB for the filter's traffic directions (ingress & egress by default).
+// The p95 and p99 bandwidth are also queried when withPercentiles is true.
+// Per-instance traffic is returned apart from the hostgroup traffic, with its LocalInstance.
+func (c *Client) QueryFederatorTraffic(ctx context.Context, filter Filter, withPercentiles bool) ([]TrafficBandwidth, error) {
+	if c.flux != nil {
+		return c.queryFederatorTrafficFlux(ctx, filter, withPercentiles)
+	}
+
 	trafficData := []TrafficBandwidth{}
 
-	queryParamMatrix := [][]string{
-		{"ingress", "1h"},
-		{"egress", "1h"},
+	whereClause, err := filter.whereClause()
+	if err != nil {
+		return []TrafficBandwidth{}, errors.Wrap(err, "failed to render query filter")
 	}
-	for _, v := range queryParamMatrix {
-		queryParamDirection := v[0]
-		queryParamTimeRange := v[1]
-		log.Debugf("queryParamMatrix direction=%v, timerange=%v", queryParamDirection, queryParamTimeRange)
+
+	const queryParamTimeRange = "1h"
+	for _, queryParamDirection := range filter.trafficDirections() {
+		log.Debugf("queryParam direction=%v, timerange=%v", queryParamDirection, queryParamTimeRange)
+
+		measurement, err := quoteIdentifier(queryParamDirection)
+		if err != nil {
+			return []TrafficBandwidth{}, errors.Wrap(err, "failed to render traffic direction")
+		}
+
+		selectPercentiles := ""
+		if withPercentiles {
+			selectPercentiles = `, PERCENTILE("bandwidth_bps", 95), PERCENTILE("bandwidth_bps", 99)`
+		}
 
 		q := `
 			SELECT
-				MIN("bandwidth_bps"), MAX("bandwidth_bps"), MEAN("bandwidth_bps")
+				MIN("bandwidth_bps"), MAX("bandwidth_bps"), MEAN("bandwidth_bps")%v
+			FROM
+				%v
+			WHERE
+				%v AND time > now() - %v
+			GROUP BY
+				service, address, remote_service, remote_address, local_instance
+		`
+		renderedQuery := fmt.Sprintf(q, selectPercentiles, measurement, whereClause, queryParamTimeRange)
+
+		// The schema version is a tag, so it's selected with the last point of each edge instead of being grouped by,
+		// which would split the statistics of an edge whose schema version changed within the time range
+		qSchemaVersion := `
+			SELECT
+				LAST("bandwidth_bps"), "schema_version"
 			FROM
 				%v
 			WHERE
-				("service" != '') AND time > now() - %v
+				%v AND time > now() - %v
 			GROUP BY
-				service, address, remote_service, remote_address
+				service, address, remote_service, remote_address, local_instance
 		`
-		renderedQuery := fmt.Sprintf(q, queryParamDirection, queryParamTimeRange)
+		renderedSchemaVersionQuery := fmt.Sprintf(qSchemaVersion, measurement, whereClause, queryParamTimeRange)
 
-		query := influxdb1.NewQuery(renderedQuery, c.database, "")
-		results, err := c.queryFederatorTrafficData(ctx, query)
+		query := c.newQuery(renderedQuery)
+		results, err := c.queryFederatorTrafficData(ctx, query, c.newQuery(renderedSchemaVersionQuery), withPercentiles)
 		if err != nil {
 			return []TrafficBandwidth{}, errors.Wrapf(err, "failed to query %v traffic data for time range %v", queryParamDirection, queryParamTimeRange)
 		}
@@ -90,8 +162,10 @@ func (c *Client) QueryFederatorTraffic(ctx context.Context) ([]TrafficBandwidth,
 	return trafficData, nil
 }
 
-// queryFederatorTrafficData executes the traffic query on InfluxDB and stores the result.
-func (c *Client) queryFederatorTrafficData(ctx context.Context, query influxdb1.Query) ([]TrafficBandwidth, error) {
+// queryFederatorTrafficData executes the traffic query on InfluxDB and stores the result, with the schema version of
+// each edge from the schemaVersionQuery.
+func (c *Client) queryFederatorTrafficData(ctx context.Context, query influxdb1.Query, schemaVersionQuery influxdb1.Query,
+	withPercentiles bool) ([]TrafficBandwidth, error) {
 	resp, err := c.client.Query(query)
 	if err != nil {
 		return []TrafficBandwidth{}, errors.Wrap(err, "failed to query QueryFederatorTraffic")
@@ -103,59 +177,167 @@ func (c *Client) queryFederatorTrafficData(ctx context.Context, query influxdb1.
 		return []TrafficBandwidth{}, errors.New("received empty data")
 	}
 
+	schemaVersions, err := c.querySchemaVersions(schemaVersionQuery)
+	if err != nil {
+		return []TrafficBandwidth{}, errors.Wrap(err, "failed to query schema versions")
+	}
+
 	trafficData := []TrafficBandwidth{}
 
 	for _, series := range resp.Results[0].Series {
+		series.Tags = withSchemaVersion(series.Tags, schemaVersions[seriesKey(series.Name, series.Tags, trafficGroupTags)])
 		for _, row := range series.Values {
-			TrafficBandwidthBitsMin1h, err := transformJSONNumberToInteger(row[1])
-			if err != nil {
-				log.Warnf("error transformJSONNumberToInteger for %v: %v", row[1], err)
-				continue
-			}
-			TrafficBandwidthBitsMax1h, err := transformJSONNumberToInteger(row[2])
+			traffic, err := parseTrafficRow(series, row, withPercentiles, c.nullValue)
 			if err != nil {
-				log.Warnf("error transformJSONNumberToInteger for %v: %v", row[2], err)
+				log.Warnf("error parsing traffic row %v: %v", row, err)
 				continue
 			}
-			TrafficBandwidthBitsAvg1h, err := transformJSONNumberToInteger(row[3])
-			if err != nil {
-				log.Warnf("error transformJSONNumberToInteger for %v: %v", row[3], err)
-				continue
-			}
-
-			traffic := TrafficBandwidth{
-				TrafficDirection:          series.Name,
-				LocalHostgroup:            series.Tags["service"],
-				LocalHostgroupAddress:     series.Tags["address"],
-				RemoteHostgroup:           series.Tags["remote_service"],
-				RemoteHostgroupAddress:    series.Tags["remote_address"],
-				TrafficBandwidthBitsMin1h: TrafficBandwidthBitsMin1h,
-				TrafficBandwidthBitsMax1h: TrafficBandwidthBitsMax1h,
-				TrafficBandwidthBitsAvg1h: TrafficBandwidthBitsAvg1h,
-			}
 			trafficData = append(trafficData, traffic)
-
-			// log.Debugf("queryFederatorTrafficData new entry: %+v", traffic)
 		}
 	}
 	return trafficData, nil
 }
 
-func transformJSONNumberToInteger(i interface{}) (int64, error) {
-	jsonNumber, ok := i.(json.Number)
-	if !ok {
-		return -1, fmt.Errorf("error on type assertion")
+// parseTrafficRow parses a traffic query row (time, min, max, mean[, p95, p99]) of a series.
+// Null values are nullValue, so a null statistic doesn't discard the valid ones of the row.
+func parseTrafficRow(series models.Row, row []interface{}, withPercentiles bool, nullValue int64) (TrafficBandwidth, error) {
+	columns := 4
+	if withPercentiles {
+		columns = 6
+	}
+	if len(row) < columns {
+		return TrafficBandwidth{}, errors.Errorf("expected %v columns, got %v", columns, len(row))
+	}
+
+	values := make([]int64, 0, columns-1)
+	for _, column := range row[1:columns] {
+		value, err := transformJSONNumberToInteger(column, nullValue)
+		if err != nil {
+			return TrafficBandwidth{}, errors.Wrapf(err, "error transformJSONNumberToInteger for %v", column)
+		}
+		values = append(values, value)
+	}
+
+	return newTrafficBandwidth(series.Name, series.Tags, values, withPercentiles), nil
+}
+
+// newTrafficBandwidth returns the traffic of a series from its measurement, tags, and its min, max, mean[, p95, p99]
+// bandwidth values.
+func newTrafficBandwidth(measurement string, tags map[string]string, values []int64, withPercentiles bool) TrafficBandwidth {
+	traffic := TrafficBandwidth{
+		TrafficDirection:          measurement,
+		LocalHostgroup:            tags["service"],
+		LocalHostgroupAddress:     tags["address"],
+		RemoteHostgroup:           tags["remote_service"],
+		RemoteHostgroupAddress:    tags["remote_address"],
+		LocalInstance:             tags[influxdb.LocalInstanceTag],
+		TrafficBandwidthBitsMin1h: values[0],
+		TrafficBandwidthBitsMax1h: values[1],
+		TrafficBandwidthBitsAvg1h: values[2],
+		SchemaVersion:             parseSchemaVersion(tags),
+	}
+	if withPercentiles {
+		traffic.TrafficBandwidthBitsP95 = values[3]
+		traffic.TrafficBandwidthBitsP99 = values[4]
+	}
+
+	return traffic
+}
+
+// querySchemaVersions executes a query selecting the "schema_version" tag with the last point of each series, and
+// returns the schema versions by the series key of the traffic tags.
+func (c *Client) querySchemaVersions(query influxdb1.Query) (map[string]string, error) {
+	resp, err := c.client.Query(query)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query schema versions")
+	}
+	if resp.Error() != nil {
+		return nil, errors.Wrap(resp.Error(), "received invalid response")
+	}
+
+	schemaVersions := map[string]string{}
+	if len(resp.Results) == 0 {
+		return schemaVersions, nil
+	}
+	for _, series := range resp.Results[0].Series {
+		schemaVersions[seriesKey(series.Name, series.Tags, trafficGroupTags)] = schemaVersionColumn(series)
+	}
+
+	return schemaVersions, nil
+}
+
+// schemaVersionColumn returns the "schema_version" column of the first row of a series, empty if it's missing or null.
+func schemaVersionColumn(series models.Row) string {
+	for i, column := range series.Columns {
+		if column != influxdb.SchemaVersionTag || len(series.Values) == 0 || i >= len(series.Values[0]) {
+			continue
+		}
+		schemaVersion, _ := series.Values[0][i].(string)
+
+		return schemaVersion
 	}
 
-	// Throw away decimals
-	integerString := strings.Split(jsonNumber.String(), ".")[0]
-	result, err := strconv.ParseInt(integerString, 10, 64)
+	return ""
+}
+
+// withSchemaVersion returns a copy of the tags of an edge with its schema version tag, which isn't grouped by.
+func withSchemaVersion(tags map[string]string, schemaVersion string) map[string]string {
+	edgeTags := make(map[string]string, len(tags)+1)
+	for tag, value := range tags {
+		edgeTags[tag] = value
+	}
+	if schemaVersion != "" {
+		edgeTags[influxdb.SchemaVersionTag] = schemaVersion
+	}
+
+	return edgeTags
+}
+
+// parseSchemaVersion returns the schema version tag of a series, zero if it's missing or invalid.
+func parseSchemaVersion(tags map[string]string) int64 {
+	version, err := strconv.ParseInt(tags[influxdb.SchemaVersionTag], 10, 64)
+	if err != nil || version < 0 {
+		return 0
+	}
+
+	return version
+}
+
+// transformJSONNumberToInteger converts an InfluxDB row value to int64, rounding half-up.
+// A nil value (series with no data in the window) is nullValue. Besides json.Number, float64 and int64 values
+// (e.g. decoded without UseNumber) are converted too.
+func transformJSONNumberToInteger(i interface{}, nullValue int64) (int64, error) {
+	var jsonNumber json.Number
+	switch v := i.(type) {
+	case nil:
+		return nullValue, nil
+	case json.Number:
+		jsonNumber = v
+	case float64:
+		return roundToInteger(v)
+	case int64:
+		return v, nil
+	default:
+		return -1, fmt.Errorf("error on type assertion of %T", i)
+	}
+
+	// Float64 also handles values in scientific notation (e.g. 1.2e+07)
+	result, err := jsonNumber.Float64()
 	if err != nil {
-		return -1, errors.Wrapf(err, "error converting %v to int", integerString)
+		return -1, errors.Wrapf(err, "error converting %v to float", jsonNumber)
 	}
 
-	return result, nil
+	return roundToInteger(result)
+}
 
+// roundToInteger converts a float to int64, rounding half-up.
+func roundToInteger(f float64) (int64, error) {
+	rounded := math.Floor(f + 0.5)
+	if math.IsNaN(rounded) || rounded >= math.MaxInt64 || rounded < math.MinInt64 {
+		return -1, errors.Errorf("error converting %v to int: out of range", f)
+	}
+
+	return int64(rounded), nil
 }
 
 // Dependency represents a dependency data.
@@ -178,42 +360,84 @@ type Dependency struct {
 	// RemoteHostgroupPort is only relevant for dependencyDirection=upstream
 	// This signifies the upstream port.
 	RemoteHostgroupAddressPort string `json:"remote_hostgroup_address_port"`
+
+	// SchemaVersion the data was written with, zero if it was written before schema versions
+	SchemaVersion int64 `json:"schema_version,omitempty"`
+
+	// FirstSeen is the earliest first_seen field of the dependency, nil if it was written without first-seen tracking
+	FirstSeen *time.Time `json:"first_seen,omitempty"`
 }
 
 // QueryFederatorDependencyLast7d returns last 7d federator upstream & downstream data.
-func (c *Client) QueryFederatorDependencyLast7d(ctx context.Context) ([]Dependency, error) {
+func (c *Client) QueryFederatorDependencyLast7d(ctx context.Context, filter Filter) ([]Dependency, error) {
+	if c.flux != nil {
+		return c.queryFederatorDependencyLast7dFlux(ctx, filter)
+	}
+
 	dependencyData := []Dependency{}
 
+	whereClause, err := filter.whereClause()
+	if err != nil {
+		return []Dependency{}, errors.Wrap(err, "failed to render query filter")
+	}
+
+	// The last point of each dependency is selected with its schema version, which isn't grouped by so a dependency
+	// is returned once even if its schema version changed within the time range
 	qUpstream := `
 		SELECT
-			COUNT(*)
+			LAST("service_dependency"), "schema_version"
 		FROM
 			upstream
 		WHERE
-			("service" != '') AND time > now() - 7d
+			%v AND time > now() - 7d
 		GROUP BY
-			service, address, upstream_service, upstream_address, process_name, upstream_port, protocol, time(1000d)
+			service, address, upstream_service, upstream_address, process_name, upstream_port, protocol
 	`
 
-	query := influxdb1.NewQuery(qUpstream, c.database, "")
-	upstreamData, err := c.queryFederatorDependencyData(ctx, query)
+	// The first_seen field is a separate query, as InfluxQL doesn't mix its MIN with the LAST selecting the tag
+	qUpstreamFirstSeen := `
+		SELECT
+			MIN("first_seen")
+		FROM
+			upstream
+		WHERE
+			%v AND time > now() - 7d
+		GROUP BY
+			service, address, upstream_service, upstream_address, process_name, upstream_port, protocol
+	`
+
+	query := c.newQuery(fmt.Sprintf(qUpstream, whereClause))
+	firstSeenQuery := c.newQuery(fmt.Sprintf(qUpstreamFirstSeen, whereClause))
+	upstreamData, err := c.queryFederatorDependencyData(ctx, query, firstSeenQuery, upstreamGroupTags)
 	if err != nil {
 		return []Dependency{}, errors.Wrap(err, "failed to query ingress traffic data")
 	}
 
 	qDownstream := `
 		SELECT
-			COUNT(*)
+			LAST("service_dependency"), "schema_version"
 		FROM
 			downstream
 		WHERE
-			("service" != '') AND time > now() - 7d
+			%v AND time > now() - 7d
 		GROUP BY
-			service, address, downstream_service, downstream_address, process_name, port, protocol, time(1000d)
+			service, address, downstream_service, downstream_address, process_name, port, protocol
 	`
 
-	query = influxdb1.NewQuery(qDownstream, c.database, "")
-	downstreamData, err := c.queryFederatorDependencyData(ctx, query)
+	qDownstreamFirstSeen := `
+		SELECT
+			MIN("first_seen")
+		FROM
+			downstream
+		WHERE
+			%v AND time > now() - 7d
+		GROUP BY
+			service, address, downstream_service, downstream_address, process_name, port, protocol
+	`
+
+	query = c.newQuery(fmt.Sprintf(qDownstream, whereClause))
+	firstSeenQuery = c.newQuery(fmt.Sprintf(qDownstreamFirstSeen, whereClause))
+	downstreamData, err := c.queryFederatorDependencyData(ctx, query, firstSeenQuery, downstreamGroupTags)
 	if err != nil {
 		return []Dependency{}, errors.Wrap(err, "failed to query egress traffic data")
 	}
@@ -224,8 +448,10 @@ func (c *Client) QueryFederatorDependencyLast7d(ctx context.Context) ([]Dependen
 	return dependencyData, nil
 }
 
-// queryFederatorDependencyData executes the dependency data query on InfluxDB and stores the result.
-func (c *Client) queryFederatorDependencyData(ctx context.Context, query influxdb1.Query) ([]Dependency, error) {
+// queryFederatorDependencyData executes the dependency data query on InfluxDB and stores the result, with the
+// first-seen time of each dependency from the firstSeenQuery of the groupTags.
+func (c *Client) queryFederatorDependencyData(ctx context.Context, query influxdb1.Query, firstSeenQuery influxdb1.Query,
+	groupTags []string) ([]Dependency, error) {
 	resp, err := c.client.Query(query)
 	if err != nil {
 		return []Dependency{}, errors.Wrap(err, "failed to query QueryFederatorTraffic")
@@ -237,31 +463,77 @@ func (c *Client) queryFederatorDependencyData(ctx context.Context, query influxd
 		return []Dependency{}, errors.New("received empty data")
 	}
 
+	firstSeen, err := c.queryFirstSeen(firstSeenQuery, groupTags)
+	if err != nil {
+		return []Dependency{}, errors.Wrap(err, "failed to query first seen")
+	}
+
 	dependencyData := []Dependency{}
 
 	for _, series := range resp.Results[0].Series {
-		remoteHostgroup := series.Tags["downstream_service"]
-		if series.Name == "upstream" {
-			remoteHostgroup = series.Tags["upstream_service"]
+		dependency := newDependency(series.Name, withSchemaVersion(series.Tags, schemaVersionColumn(series)))
+		if t, found := firstSeen[seriesKey(series.Name, series.Tags, groupTags)]; found {
+			dependency.FirstSeen = &t
 		}
+		dependencyData = append(dependencyData, dependency)
+	}
+	return dependencyData, nil
+}
+
+// queryFirstSeen executes a query of the MIN "first_seen" field (unix seconds) of each series, and returns the
+// first-seen times by the series key of the groupTags. Series written without first-seen tracking have none.
+func (c *Client) queryFirstSeen(query influxdb1.Query, groupTags []string) (map[string]time.Time, error) {
+	resp, err := c.client.Query(query)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query first seen")
+	}
+	if resp.Error() != nil {
+		return nil, errors.Wrap(resp.Error(), "received invalid response")
+	}
 
-		remoteAddress := series.Tags["downstream_address"]
-		if series.Name == "upstream" {
-			remoteAddress = series.Tags["upstream_address"]
+	firstSeen := map[string]time.Time{}
+	if len(resp.Results) == 0 {
+		return firstSeen, nil
+	}
+	for _, series := range resp.Results[0].Series {
+		if len(series.Values) == 0 || len(series.Values[0]) < 2 || series.Values[0][1] == nil {
+			continue
 		}
+		seconds, err := transformJSONNumberToInteger(series.Values[0][1], 0)
+		if err != nil {
+			log.Warnf("error parsing first seen %v: %v", series.Values[0][1], err)
 
-		dependency := Dependency{
-			Direction:                  series.Name,
-			Protocol:                   series.Tags["protocol"],
-			LocalHostgroupProcessName:  series.Tags["process_name"],
-			LocalHostgroup:             series.Tags["service"],
-			LocalHostgroupAddress:      series.Tags["address"],
-			LocalHostgroupAddressPort:  series.Tags["port"],
-			RemoteHostgroup:            remoteHostgroup,
-			RemoteHostgroupAddress:     remoteAddress,
-			RemoteHostgroupAddressPort: series.Tags["upstream_port"],
+			continue
 		}
-		dependencyData = append(dependencyData, dependency)
+		firstSeen[seriesKey(series.Name, series.Tags, groupTags)] = time.Unix(seconds, 0).UTC()
+	}
+
+	return firstSeen, nil
+}
+
+// newDependency returns the dependency of a series from its measurement (upstream or downstream) and tags.
+func newDependency(measurement string, tags map[string]string) Dependency {
+	// Tags are read with the schema the backends write them with
+	remoteHostgroup := tags[influxdb.DownstreamServiceHostgroupTag]
+	if measurement == influxdb.UpstreamServiceMeasurement {
+		remoteHostgroup = tags[influxdb.UpstreamServiceHostgroupTag]
+	}
+
+	remoteAddress := tags[influxdb.DownstreamServiceAddressTag]
+	if measurement == influxdb.UpstreamServiceMeasurement {
+		remoteAddress = tags[influxdb.UpstreamServiceAddressTag]
+	}
+
+	return Dependency{
+		Direction:                  measurement,
+		Protocol:                   tags[influxdb.ProtocolTag],
+		LocalHostgroupProcessName:  tags[influxdb.LocalServiceProcessNameTag],
+		LocalHostgroup:             tags[influxdb.LocalServiceHostgroupTag],
+		LocalHostgroupAddress:      tags[influxdb.LocalServiceAddressTag],
+		LocalHostgroupAddressPort:  tags[influxdb.LocalServicePortTag],
+		RemoteHostgroup:            remoteHostgroup,
+		RemoteHostgroupAddress:     remoteAddress,
+		RemoteHostgroupAddressPort: tags[influxdb.UpstreamServicePortTag],
+		SchemaVersion:              parseSchemaVersion(tags),
 	}
-	return dependencyData, nil
 }
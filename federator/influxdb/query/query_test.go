@@ -0,0 +1,267 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/influxdata/influxdb1-client/models"
+	influxdb1 "github.com/influxdata/influxdb1-client/v2"
+)
+
+func Test_transformJSONNumberToInteger(t *testing.T) {
+	tests := []struct {
+		name      string
+		value     interface{}
+		nullValue int64
+		want      int64
+		wantErr   bool
+	}{
+		{name: "Integer", value: json.Number("1000"), want: 1000},
+		{name: "Decimal rounded down", value: json.Number("999.4"), want: 999},
+		{name: "Decimal rounded up", value: json.Number("999.9"), want: 1000},
+		{name: "Half rounded up", value: json.Number("999.5"), want: 1000},
+		{name: "Scientific notation", value: json.Number("1.2e+07"), want: 12000000},
+		{name: "Scientific notation with decimals", value: json.Number("1.23456789e+03"), want: 1235},
+		{name: "Negative", value: json.Number("-42"), want: -42},
+		{name: "Negative decimal", value: json.Number("-42.7"), want: -43},
+		{name: "Negative half rounded up", value: json.Number("-42.5"), want: -42},
+		{name: "Nil is zero", value: nil, want: 0},
+		{name: "Nil is the null value", value: nil, nullValue: -1, want: -1},
+		{name: "Float", value: 999.5, want: 1000},
+		{name: "Int", value: int64(42), want: 42},
+		{name: "NaN float", value: math.NaN(), want: -1, wantErr: true},
+		{name: "Out of range", value: json.Number("1e+30"), want: -1, wantErr: true},
+		{name: "Not a number", value: json.Number("abc"), want: -1, wantErr: true},
+		{name: "Not a json.Number", value: "1000", want: -1, wantErr: true},
+	}
+	for _, testcase := range tests {
+		t.Run(testcase.name, func(t *testing.T) {
+			got, err := transformJSONNumberToInteger(testcase.value, testcase.nullValue)
+			if (err != nil) != testcase.wantErr {
+				t.Errorf("transformJSONNumberToInteger() error = %v, wantErr %v", err, testcase.wantErr)
+
+				return
+			}
+			if got != testcase.want {
+				t.Errorf("transformJSONNumberToInteger() = %v, want %v", got, testcase.want)
+			}
+		})
+	}
+}
+
+func Test_parseTrafficRow(t *testing.T) {
+	series := models.Row{ // nolint:exhaustivestruct
+		Name: "egress",
+		Tags: map[string]string{"service": "svc-a", "address": "a.local", "remote_service": "svc-b", "remote_address": "b.local"},
+	}
+	base := TrafficBandwidth{
+		TrafficDirection:          "egress",
+		LocalHostgroup:            "svc-a",
+		LocalHostgroupAddress:     "a.local",
+		RemoteHostgroup:           "svc-b",
+		RemoteHostgroupAddress:    "b.local",
+		TrafficBandwidthBitsMin1h: 1000,
+		TrafficBandwidthBitsMax1h: 3000,
+		TrafficBandwidthBitsAvg1h: 2000,
+	}
+	withPercentiles := base
+	withPercentiles.TrafficBandwidthBitsP95 = 2900
+	withPercentiles.TrafficBandwidthBitsP99 = 2990
+	perInstance := base
+	perInstance.LocalInstance = "10.0.0.1:19100"
+	nullMin := base
+	nullMin.TrafficBandwidthBitsMin1h = 0
+	nullMinSentinel := base
+	nullMinSentinel.TrafficBandwidthBitsMin1h = -1
+
+	tests := []struct {
+		name            string
+		localInstance   string
+		row             []interface{}
+		withPercentiles bool
+		nullValue       int64
+		want            TrafficBandwidth
+		wantErr         bool
+	}{
+		{
+			name: "Without percentiles",
+			row:  []interface{}{json.Number("0"), json.Number("1000"), json.Number("3000"), json.Number("2000")},
+			want: base,
+		},
+		{
+			name: "With percentiles",
+			row: []interface{}{json.Number("0"), json.Number("1000"), json.Number("3000"), json.Number("2000"),
+				json.Number("2900.2"), json.Number("2990")},
+			withPercentiles: true,
+			want:            withPercentiles,
+		},
+		{
+			name:            "Missing percentile columns",
+			row:             []interface{}{json.Number("0"), json.Number("1000"), json.Number("3000"), json.Number("2000")},
+			withPercentiles: true,
+			wantErr:         true,
+		},
+		{
+			name:          "Per-instance traffic",
+			localInstance: "10.0.0.1:19100",
+			row:           []interface{}{json.Number("0"), json.Number("1000"), json.Number("3000"), json.Number("2000")},
+			want:          perInstance,
+		},
+		{
+			name: "Null MIN with valid MAX and MEAN",
+			row:  []interface{}{json.Number("0"), nil, json.Number("3000"), json.Number("2000")},
+			want: nullMin,
+		},
+		{
+			name:      "Null MIN as the null value",
+			row:       []interface{}{json.Number("0"), nil, json.Number("3000"), json.Number("2000")},
+			nullValue: -1,
+			want:      nullMinSentinel,
+		},
+		{
+			name:            "Null percentiles",
+			row:             []interface{}{json.Number("0"), json.Number("1000"), json.Number("3000"), json.Number("2000"), nil, nil},
+			withPercentiles: true,
+			want:            base,
+		},
+		{
+			name:    "Invalid value",
+			row:     []interface{}{json.Number("0"), "1000", json.Number("3000"), json.Number("2000")},
+			wantErr: true,
+		},
+	}
+	for _, testcase := range tests {
+		t.Run(testcase.name, func(t *testing.T) {
+			series := series
+			if testcase.localInstance != "" {
+				tags := map[string]string{"local_instance": testcase.localInstance}
+				for tag, value := range series.Tags {
+					tags[tag] = value
+				}
+				series.Tags = tags
+			}
+			got, err := parseTrafficRow(series, testcase.row, testcase.withPercentiles, testcase.nullValue)
+			if (err != nil) != testcase.wantErr {
+				t.Fatalf("parseTrafficRow() error = %v, wantErr %v", err, testcase.wantErr)
+			}
+			if !testcase.wantErr && !reflect.DeepEqual(got, testcase.want) {
+				t.Errorf("parseTrafficRow() = %v, want %v", got, testcase.want)
+			}
+		})
+	}
+}
+
+func Test_parseSchemaVersion(t *testing.T) {
+	tests := []struct {
+		name string
+		tags map[string]string
+		want int64
+	}{
+		{name: "Schema version", tags: map[string]string{"schema_version": "3"}, want: 3},
+		{name: "Written before schema versions", tags: map[string]string{"service": "svc-a"}, want: 0},
+		{name: "Invalid", tags: map[string]string{"schema_version": "v1"}, want: 0},
+		{name: "Negative", tags: map[string]string{"schema_version": "-1"}, want: 0},
+	}
+	for _, testcase := range tests {
+		t.Run(testcase.name, func(t *testing.T) {
+			if got := parseSchemaVersion(testcase.tags); got != testcase.want {
+				t.Errorf("parseSchemaVersion() = %v, want %v", got, testcase.want)
+			}
+		})
+	}
+}
+
+// mockTrafficClient is an InfluxDB answering the traffic statistics and schema version queries with recorded series.
+type mockTrafficClient struct {
+	statistics     []models.Row
+	schemaVersions []models.Row
+	// queries received
+	queries []string
+}
+
+func (m *mockTrafficClient) Ping(time.Duration) (time.Duration, string, error) { return 0, "", nil }
+
+func (m *mockTrafficClient) Write(influxdb1.BatchPoints) error { return nil }
+
+func (m *mockTrafficClient) Query(q influxdb1.Query) (*influxdb1.Response, error) {
+	m.queries = append(m.queries, q.Command)
+	series := m.statistics
+	if strings.Contains(q.Command, "LAST(") {
+		series = m.schemaVersions
+	}
+
+	return &influxdb1.Response{Results: []influxdb1.Result{{Series: series}}}, nil // nolint:exhaustivestruct
+}
+
+func (m *mockTrafficClient) QueryAsChunk(influxdb1.Query) (*influxdb1.ChunkedResponse, error) {
+	return nil, nil // nolint:nilnil
+}
+
+func (m *mockTrafficClient) Close() error { return nil }
+
+// TestClient_QueryFederatorTraffic checks that an edge whose schema version changed within the time range is a
+// single row, with the statistics of the whole time range and the schema version of its last point.
+func TestClient_QueryFederatorTraffic(t *testing.T) {
+	edge := map[string]string{"service": "svc-a", "address": "a.local", "remote_service": "svc-b", "remote_address": "b.local"}
+	oldEdge := map[string]string{"service": "svc-c", "address": "c.local", "remote_service": "svc-b", "remote_address": "b.local"}
+	client := &mockTrafficClient{ // nolint:exhaustivestruct
+		statistics: []models.Row{
+			{Name: "egress", Tags: edge, Columns: []string{"time", "min", "max", "mean"}, // nolint:exhaustivestruct
+				Values: [][]interface{}{{json.Number("0"), json.Number("1000"), json.Number("3000"), json.Number("2000")}}},
+			{Name: "egress", Tags: oldEdge, Columns: []string{"time", "min", "max", "mean"}, // nolint:exhaustivestruct
+				Values: [][]interface{}{{json.Number("0"), json.Number("10"), json.Number("30"), json.Number("20")}}},
+		},
+		schemaVersions: []models.Row{
+			{Name: "egress", Tags: edge, Columns: []string{"time", "last", "schema_version"}, // nolint:exhaustivestruct
+				Values: [][]interface{}{{json.Number("0"), json.Number("2000"), "3"}}},
+			{Name: "egress", Tags: oldEdge, Columns: []string{"time", "last", "schema_version"}, // nolint:exhaustivestruct
+				Values: [][]interface{}{{json.Number("0"), json.Number("20"), nil}}},
+		},
+	}
+
+	got, err := New(client, "mothership", "").QueryFederatorTraffic(context.Background(), Filter{Directions: []string{"egress"}}, false) // nolint:exhaustivestruct
+	if err != nil {
+		t.Fatalf("Client.QueryFederatorTraffic() error = %v", err)
+	}
+
+	want := []TrafficBandwidth{
+		{
+			TrafficDirection: "egress", LocalHostgroup: "svc-a", LocalHostgroupAddress: "a.local", RemoteHostgroup: "svc-b",
+			RemoteHostgroupAddress: "b.local", TrafficBandwidthBitsMin1h: 1000, TrafficBandwidthBitsMax1h: 3000,
+			TrafficBandwidthBitsAvg1h: 2000, SchemaVersion: 3,
+		},
+		{
+			TrafficDirection: "egress", LocalHostgroup: "svc-c", LocalHostgroupAddress: "c.local", RemoteHostgroup: "svc-b",
+			RemoteHostgroupAddress: "b.local", TrafficBandwidthBitsMin1h: 10, TrafficBandwidthBitsMax1h: 30,
+			TrafficBandwidthBitsAvg1h: 20,
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Client.QueryFederatorTraffic() = %+v, want %+v", got, want)
+	}
+
+	for _, query := range client.queries {
+		if groupBy := query[strings.Index(query, "GROUP BY"):]; strings.Contains(groupBy, "schema_version") {
+			t.Errorf("Client.QueryFederatorTraffic() query = %v, want it not grouped by schema_version", query)
+		}
+	}
+}
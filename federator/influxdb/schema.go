@@ -0,0 +1,101 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package influxdb
+
+import (
+	"strconv"
+	"time"
+
+	"planet-exporter/federator"
+)
+
+// The federator InfluxDB schema, shared by every InfluxDB backend so the query package works against any of them.
+const (
+	// Measurements.
+
+	UpstreamServiceMeasurement   = "upstream"
+	DownstreamServiceMeasurement = "downstream"
+
+	CollectorHealthMeasurement = "collector_health"
+
+	IngressDirectionMeasurement = "ingress"
+	EgressDirectionMeasurement  = "egress"
+	UnknownDirectionMeasurement = "unknown"
+
+	// Tags.
+
+	LocalServiceHostgroupTag   = "service"
+	LocalServiceAddressTag     = "address"
+	LocalServicePortTag        = "port"
+	LocalServiceProcessNameTag = "process_name"
+
+	RemoteServiceHostgroupTag = "remote_service"
+	RemoteServiceAddressTag   = "remote_address"
+
+	// LocalInstanceTag is the planet-exporter instance of per-instance traffic, missing from hostgroup traffic.
+	LocalInstanceTag = "local_instance"
+
+	UpstreamServiceHostgroupTag = "upstream_service"
+	UpstreamServiceAddressTag   = "upstream_address"
+	UpstreamServicePortTag      = "upstream_port"
+
+	DownstreamServiceHostgroupTag = "downstream_service"
+	DownstreamServiceAddressTag   = "downstream_address"
+
+	ProtocolTag = "protocol"
+
+	CollectorTag = "collector"
+
+	// SchemaVersionTag is the federator.SchemaVersion a point was written with, missing from older points.
+	SchemaVersionTag = "schema_version"
+
+	// Fields.
+
+	BandwidthBpsField      = "bandwidth_bps"
+	ServiceDependencyField = "service_dependency"
+	// FirstSeenField is the unix time in seconds a dependency edge was first seen at, missing unless tracked.
+	FirstSeenField = "first_seen"
+
+	InstancesField          = "instances"
+	FailingInstancesField   = "failing_instances"
+	AvgDurationSecondsField = "avg_duration_seconds"
+)
+
+// TrafficMeasurement returns the measurement of traffic data in a normalized direction.
+func TrafficMeasurement(direction string) string {
+	switch direction {
+	case federator.IngressDirection:
+		return IngressDirectionMeasurement
+	case federator.EgressDirection:
+		return EgressDirectionMeasurement
+	default:
+		return UnknownDirectionMeasurement
+	}
+}
+
+// SchemaVersionTagValue is the SchemaVersionTag value of the points written by the federator.
+var SchemaVersionTagValue = strconv.Itoa(federator.SchemaVersion)
+
+// DependencyFields returns the fields of a dependency point, with the first-seen time of its edge if it's tracked.
+func DependencyFields(firstSeen *time.Time) map[string]interface{} {
+	fields := map[string]interface{}{
+		ServiceDependencyField: 1,
+	}
+	if firstSeen != nil {
+		fields[FirstSeenField] = firstSeen.Unix()
+	}
+
+	return fields
+}
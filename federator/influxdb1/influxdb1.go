@@ -0,0 +1,184 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package influxdb1 is a federator backend for InfluxDB 1.x, without the v2 compatibility API.
+// It writes the same schema as the influxdb package so the query package works against its data.
+package influxdb1
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"planet-exporter/federator"
+	"planet-exporter/federator/influxdb"
+
+	influxdb1 "github.com/influxdata/influxdb1-client/v2"
+	log "github.com/sirupsen/logrus"
+)
+
+// Backend interface for a time-series DB handling pre-processed planet-exporter data.
+// Points are buffered and written in batches of batchSize line-protocol points.
+type Backend struct {
+	client          influxdb1.Client
+	database        string
+	retentionPolicy string
+	batchSize       int
+
+	// strictTrafficDirection rejects traffic data with unknown direction instead of storing it as unknown
+	strictTrafficDirection bool
+
+	mu     sync.Mutex
+	points []*influxdb1.Point
+}
+
+// New returns new InfluxDB 1.x federator backend writing to database and retentionPolicy (default policy if empty).
+// When strictTrafficDirection is true, traffic data with a direction other than ingress/egress is rejected.
+func New(influxdbClient influxdb1.Client, database, retentionPolicy string, batchSize int, strictTrafficDirection bool) *Backend {
+	if batchSize < 1 {
+		batchSize = 1
+	}
+
+	return &Backend{
+		client:          influxdbClient,
+		database:        database,
+		retentionPolicy: retentionPolicy,
+		batchSize:       batchSize,
+
+		strictTrafficDirection: strictTrafficDirection,
+
+		mu:     sync.Mutex{},
+		points: []*influxdb1.Point{},
+	}
+}
+
+// AddTrafficBandwidthData adds a service's ingress or egress bandwidth data point.
+func (b *Backend) AddTrafficBandwidthData(ctx context.Context, trafficBandwidth federator.TrafficBandwidth, timeOfDataPoint time.Time) error {
+	direction, err := federator.NormalizeTrafficDirection(trafficBandwidth.Direction,
+		trafficBandwidth.LocalHostgroup, trafficBandwidth.RemoteHostgroup, b.strictTrafficDirection)
+	if err != nil {
+		return err
+	}
+
+	tags := map[string]string{
+		influxdb.LocalServiceHostgroupTag:  trafficBandwidth.LocalHostgroup,
+		influxdb.LocalServiceAddressTag:    trafficBandwidth.LocalAddress,
+		influxdb.RemoteServiceHostgroupTag: trafficBandwidth.RemoteHostgroup,
+		influxdb.RemoteServiceAddressTag:   trafficBandwidth.RemoteDomain,
+		influxdb.SchemaVersionTag:          influxdb.SchemaVersionTagValue,
+	}
+	if trafficBandwidth.LocalInstance != "" {
+		tags[influxdb.LocalInstanceTag] = trafficBandwidth.LocalInstance
+	}
+
+	return b.addPoint(influxdb.TrafficMeasurement(direction), tags, map[string]interface{}{
+		influxdb.BandwidthBpsField: trafficBandwidth.BitsPerSecond,
+	}, timeOfDataPoint)
+}
+
+// AddUpstreamService adds an upstream service dependency of a service.
+func (b *Backend) AddUpstreamService(ctx context.Context, upstreamService federator.UpstreamService, timeOfDataPoint time.Time) error {
+	return b.addPoint(influxdb.UpstreamServiceMeasurement, map[string]string{
+		influxdb.LocalServiceHostgroupTag:    upstreamService.LocalHostgroup,
+		influxdb.LocalServiceAddressTag:      upstreamService.LocalAddress,
+		influxdb.UpstreamServiceHostgroupTag: upstreamService.UpstreamHostgroup,
+		influxdb.UpstreamServiceAddressTag:   upstreamService.UpstreamAddress,
+		influxdb.UpstreamServicePortTag:      upstreamService.UpstreamPort,
+		influxdb.LocalServiceProcessNameTag:  upstreamService.LocalProcessName,
+		influxdb.ProtocolTag:                 upstreamService.Protocol,
+		influxdb.SchemaVersionTag:            influxdb.SchemaVersionTagValue,
+	}, influxdb.DependencyFields(upstreamService.FirstSeen), timeOfDataPoint)
+}
+
+// AddDownstreamService adds a downstream service dependency of a service.
+func (b *Backend) AddDownstreamService(ctx context.Context, downstreamService federator.DownstreamService, timeOfDataPoint time.Time) error {
+	return b.addPoint(influxdb.DownstreamServiceMeasurement, map[string]string{
+		influxdb.LocalServiceHostgroupTag:      downstreamService.LocalHostgroup,
+		influxdb.LocalServiceAddressTag:        downstreamService.LocalAddress,
+		influxdb.LocalServicePortTag:           downstreamService.LocalPort,
+		influxdb.LocalServiceProcessNameTag:    downstreamService.LocalProcessName,
+		influxdb.DownstreamServiceHostgroupTag: downstreamService.DownstreamHostgroup,
+		influxdb.DownstreamServiceAddressTag:   downstreamService.DownstreamAddress,
+		influxdb.ProtocolTag:                   downstreamService.Protocol,
+		influxdb.SchemaVersionTag:              influxdb.SchemaVersionTagValue,
+	}, influxdb.DependencyFields(downstreamService.FirstSeen), timeOfDataPoint)
+}
+
+// AddCollectorHealth adds a planet-exporter collector health summary of a service.
+func (b *Backend) AddCollectorHealth(ctx context.Context, collectorHealth federator.CollectorHealth, timeOfDataPoint time.Time) error {
+	return b.addPoint(influxdb.CollectorHealthMeasurement, map[string]string{
+		influxdb.LocalServiceHostgroupTag: collectorHealth.LocalHostgroup,
+		influxdb.CollectorTag:             collectorHealth.Collector,
+		influxdb.SchemaVersionTag:         influxdb.SchemaVersionTagValue,
+	}, map[string]interface{}{
+		influxdb.InstancesField:          collectorHealth.Instances,
+		influxdb.FailingInstancesField:   collectorHealth.FailingInstances,
+		influxdb.AvgDurationSecondsField: collectorHealth.AvgDurationSeconds,
+	}, timeOfDataPoint)
+}
+
+// addPoint buffers a data point, and writes the buffered points once there's a full batch.
+func (b *Backend) addPoint(measurement string, tags map[string]string, fields map[string]interface{}, timeOfDataPoint time.Time) error {
+	point, err := influxdb1.NewPoint(measurement, tags, fields, timeOfDataPoint)
+	if err != nil {
+		return fmt.Errorf("error creating influxdb point: %w", err)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.points = append(b.points, point)
+	if len(b.points) < b.batchSize {
+		return nil
+	}
+
+	return b.write()
+}
+
+// write writes every buffered point, the caller must hold the lock.
+// Points are dropped when the write fails, so a down InfluxDB doesn't grow the buffer indefinitely.
+func (b *Backend) write() error {
+	if len(b.points) == 0 {
+		return nil
+	}
+
+	points := b.points
+	b.points = make([]*influxdb1.Point, 0, b.batchSize)
+
+	batchPoints, err := influxdb1.NewBatchPoints(influxdb1.BatchPointsConfig{ // nolint:exhaustivestruct
+		Database:        b.database,
+		RetentionPolicy: b.retentionPolicy,
+	})
+	if err != nil {
+		return fmt.Errorf("error creating influxdb batch points: %w", err)
+	}
+	batchPoints.AddPoints(points)
+
+	if err := b.client.Write(batchPoints); err != nil {
+		return fmt.Errorf("error writing %v points to influxdb: %w", len(points), err)
+	}
+
+	return nil
+}
+
+// Flush writes all buffered points.
+func (b *Backend) Flush() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.write(); err != nil {
+		log.Errorf("Failed to flush influxdb writes: %v", err)
+	}
+}
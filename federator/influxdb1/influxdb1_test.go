@@ -0,0 +1,193 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package influxdb1
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"planet-exporter/federator"
+	"planet-exporter/federator/influxdb"
+
+	influxdb1 "github.com/influxdata/influxdb1-client/v2"
+)
+
+var errMockWrite = errors.New("mock write error")
+
+// mockClient records every written batch.
+type mockClient struct {
+	batches  []influxdb1.BatchPoints
+	writeErr error
+}
+
+func (m *mockClient) Ping(time.Duration) (time.Duration, string, error) { return 0, "", nil }
+
+func (m *mockClient) Write(bp influxdb1.BatchPoints) error {
+	m.batches = append(m.batches, bp)
+
+	return m.writeErr
+}
+
+func (m *mockClient) Query(influxdb1.Query) (*influxdb1.Response, error) { return nil, nil } // nolint:nilnil
+
+func (m *mockClient) QueryAsChunk(influxdb1.Query) (*influxdb1.ChunkedResponse, error) {
+	return nil, nil // nolint:nilnil
+}
+
+func (m *mockClient) Close() error { return nil }
+
+func TestBackend_batchesAndFlush(t *testing.T) {
+	client := &mockClient{} // nolint:exhaustivestruct
+	b := New(client, "mothership", "autogen", 2, false)
+	ctx := context.Background()
+	timeOfDataPoint := time.Date(2021, 6, 1, 9, 59, 50, 0, time.UTC)
+
+	if err := b.AddTrafficBandwidthData(ctx, federator.TrafficBandwidth{LocalHostgroup: "local", RemoteHostgroup: "remote", Direction: "egress"}, timeOfDataPoint); err != nil { // nolint:exhaustivestruct
+		t.Fatalf("Backend.AddTrafficBandwidthData() error = %v", err)
+	}
+	if len(client.batches) != 0 {
+		t.Fatalf("Backend wrote %v batches before a full batch, want 0", len(client.batches))
+	}
+	if err := b.AddUpstreamService(ctx, federator.UpstreamService{LocalHostgroup: "local", UpstreamHostgroup: "remote"}, timeOfDataPoint); err != nil { // nolint:exhaustivestruct
+		t.Fatalf("Backend.AddUpstreamService() error = %v", err)
+	}
+	if err := b.AddCollectorHealth(ctx, federator.CollectorHealth{LocalHostgroup: "local", Collector: "socketstat", Instances: 2}, timeOfDataPoint); err != nil { // nolint:exhaustivestruct
+		t.Fatalf("Backend.AddCollectorHealth() error = %v", err)
+	}
+	if len(client.batches) != 1 {
+		t.Fatalf("Backend wrote %v batches, want 1", len(client.batches))
+	}
+
+	b.Flush()
+	if len(client.batches) != 2 {
+		t.Fatalf("Backend wrote %v batches after Flush(), want 2", len(client.batches))
+	}
+	b.Flush()
+	if len(client.batches) != 2 {
+		t.Errorf("Backend wrote %v batches after an empty Flush(), want 2", len(client.batches))
+	}
+
+	gotMeasurements := []string{}
+	for _, batch := range client.batches {
+		if batch.Database() != "mothership" || batch.RetentionPolicy() != "autogen" {
+			t.Errorf("Backend batch database = %v, retention policy = %v, want mothership, autogen", batch.Database(), batch.RetentionPolicy())
+		}
+		for _, point := range batch.Points() {
+			gotMeasurements = append(gotMeasurements, point.Name())
+			if !point.Time().Equal(timeOfDataPoint) {
+				t.Errorf("Backend point %v time = %v, want %v", point.Name(), point.Time(), timeOfDataPoint)
+			}
+		}
+	}
+	wantMeasurements := []string{influxdb.EgressDirectionMeasurement, influxdb.UpstreamServiceMeasurement, influxdb.CollectorHealthMeasurement}
+	if !reflect.DeepEqual(gotMeasurements, wantMeasurements) {
+		t.Errorf("Backend measurements = %v, want %v", gotMeasurements, wantMeasurements)
+	}
+
+	// Empty tag values are omitted from line protocol
+	wantTags := map[string]string{
+		influxdb.LocalServiceHostgroupTag:  "local",
+		influxdb.RemoteServiceHostgroupTag: "remote",
+		influxdb.SchemaVersionTag:          influxdb.SchemaVersionTagValue,
+	}
+	if got := client.batches[0].Points()[0].Tags(); !reflect.DeepEqual(got, wantTags) {
+		t.Errorf("Backend traffic point tags = %v, want %v", got, wantTags)
+	}
+}
+
+func TestBackend_localInstance(t *testing.T) {
+	client := &mockClient{} // nolint:exhaustivestruct
+	b := New(client, "mothership", "", 1, false)
+
+	trafficBandwidth := federator.TrafficBandwidth{LocalHostgroup: "local", RemoteHostgroup: "remote", Direction: "egress", LocalInstance: "10.0.0.1:19100"} // nolint:exhaustivestruct
+	if err := b.AddTrafficBandwidthData(context.Background(), trafficBandwidth, time.Now()); err != nil {
+		t.Fatalf("Backend.AddTrafficBandwidthData() error = %v", err)
+	}
+
+	wantTags := map[string]string{
+		influxdb.LocalServiceHostgroupTag:  "local",
+		influxdb.RemoteServiceHostgroupTag: "remote",
+		influxdb.LocalInstanceTag:          "10.0.0.1:19100",
+		influxdb.SchemaVersionTag:          influxdb.SchemaVersionTagValue,
+	}
+	if len(client.batches) != 1 {
+		t.Fatalf("Backend wrote %v batches, want 1", len(client.batches))
+	}
+	if got := client.batches[0].Points()[0].Tags(); !reflect.DeepEqual(got, wantTags) {
+		t.Errorf("Backend traffic point tags = %v, want %v", got, wantTags)
+	}
+}
+
+func TestBackend_firstSeen(t *testing.T) {
+	client := &mockClient{} // nolint:exhaustivestruct
+	b := New(client, "mothership", "", 2, false)
+	ctx := context.Background()
+	firstSeen := time.Date(2021, 6, 1, 10, 0, 0, 0, time.UTC)
+
+	upstream := federator.UpstreamService{LocalHostgroup: "local", UpstreamHostgroup: "remote", FirstSeen: &firstSeen} // nolint:exhaustivestruct
+	if err := b.AddUpstreamService(ctx, upstream, time.Now()); err != nil {
+		t.Fatalf("Backend.AddUpstreamService() error = %v", err)
+	}
+	if err := b.AddDownstreamService(ctx, federator.DownstreamService{LocalHostgroup: "local"}, time.Now()); err != nil { // nolint:exhaustivestruct
+		t.Fatalf("Backend.AddDownstreamService() error = %v", err)
+	}
+	if len(client.batches) != 1 {
+		t.Fatalf("Backend wrote %v batches, want 1", len(client.batches))
+	}
+
+	wantFields := []map[string]interface{}{
+		{influxdb.ServiceDependencyField: int64(1), influxdb.FirstSeenField: firstSeen.Unix()},
+		{influxdb.ServiceDependencyField: int64(1)},
+	}
+	for i, point := range client.batches[0].Points() {
+		got, err := point.Fields()
+		if err != nil {
+			t.Fatalf("Point.Fields() error = %v", err)
+		}
+		if !reflect.DeepEqual(got, wantFields[i]) {
+			t.Errorf("Backend dependency point %v fields = %v, want %v", i, got, wantFields[i])
+		}
+	}
+}
+
+func TestBackend_writeError(t *testing.T) {
+	client := &mockClient{writeErr: errMockWrite} // nolint:exhaustivestruct
+	b := New(client, "mothership", "", 1, false)
+
+	err := b.AddDownstreamService(context.Background(), federator.DownstreamService{LocalHostgroup: "local"}, time.Now()) // nolint:exhaustivestruct
+	if !errors.Is(err, errMockWrite) {
+		t.Errorf("Backend.AddDownstreamService() error = %v, want %v", err, errMockWrite)
+	}
+
+	// Failed points are dropped instead of being retried on the next write
+	client.writeErr = nil
+	b.Flush()
+	if len(client.batches) != 1 {
+		t.Errorf("Backend wrote %v batches, want 1", len(client.batches))
+	}
+}
+
+func TestBackend_strictTrafficDirection(t *testing.T) {
+	client := &mockClient{} // nolint:exhaustivestruct
+	b := New(client, "mothership", "", 1, true)
+
+	err := b.AddTrafficBandwidthData(context.Background(), federator.TrafficBandwidth{Direction: "sideways"}, time.Now()) // nolint:exhaustivestruct
+	if !errors.Is(err, federator.ErrUnknownTrafficDirection) {
+		t.Errorf("Backend.AddTrafficBandwidthData() error = %v, want %v", err, federator.ErrUnknownTrafficDirection)
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package federator
+
+import (
+	"sync"
+	"time"
+
+	"planet-exporter/pkg/clock"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// JobWindow computes the query windows of the consecutive runs of a job. The window ends at the job start time,
+// which follows the wall clock. When the wall clock steps backwards (e.g. an NTP correction), the window is clamped
+// to start at the end of the previous window, so the run doesn't query and write data again that the previous
+// run already did. It's safe for concurrent use.
+type JobWindow struct {
+	job    string
+	length time.Duration
+
+	clockSteps *clock.StepDetector
+
+	mu sync.Mutex
+	// previousEnd is the latest end of the previous windows, zero before the first run
+	previousEnd time.Time
+}
+
+// NewJobWindow returns a JobWindow of the job, whose windows are length long.
+// Wall clock steps larger than stepThreshold between runs are logged and counted.
+func NewJobWindow(job string, length time.Duration, stepThreshold time.Duration) *JobWindow {
+	return &JobWindow{
+		job:         job,
+		length:      length,
+		clockSteps:  clock.NewStepDetector(stepThreshold),
+		mu:          sync.Mutex{},
+		previousEnd: time.Time{},
+	}
+}
+
+// Next returns the [start, end] query window of a run started at now (from time.Now), offset by offset.
+// The start is always before the end.
+func (w *JobWindow) Next(now time.Time, offset time.Duration) (time.Time, time.Time) {
+	step := w.clockSteps.Observe(now)
+	if step != 0 {
+		clockStepsTotal.WithLabelValues(w.job).Inc()
+		log.Warnf("Wall clock stepped by %v since the previous %v job run", step, w.job)
+	}
+
+	return w.window(now.Add(offset), step)
+}
+
+// window returns the query window ending at end, after a wall clock step since the previous run.
+func (w *JobWindow) window(end time.Time, step time.Duration) (time.Time, time.Time) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	start := end.Add(-w.length)
+	if step < 0 && !w.previousEnd.IsZero() {
+		switch {
+		case !w.previousEnd.Before(end):
+			// The whole window was already queried, but it's still a valid window and clamping would make it empty
+			log.Warnf("%v job query window %v to %v was already queried by a previous run before the wall clock stepped back",
+				w.job, start.Format(time.RFC3339), end.Format(time.RFC3339))
+		case w.previousEnd.After(start):
+			log.Warnf("Clamp %v job query window start from %v to the previous window end %v",
+				w.job, start.Format(time.RFC3339), w.previousEnd.Format(time.RFC3339))
+			start = w.previousEnd
+		}
+	}
+	if end.After(w.previousEnd) {
+		w.previousEnd = end
+	}
+
+	return start, end
+}
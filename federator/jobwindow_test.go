@@ -0,0 +1,92 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package federator
+
+import (
+	"testing"
+	"time"
+)
+
+func TestJobWindow(t *testing.T) {
+	window := NewJobWindow("test", 15*time.Second, time.Second)
+	startTime := time.Date(2021, 6, 1, 10, 0, 0, 0, time.UTC)
+
+	// Runs share the window state, so they're not independent subtests
+	tests := []struct {
+		name      string
+		end       time.Time
+		step      time.Duration
+		wantStart time.Time
+	}{
+		{
+			name:      "First run",
+			end:       startTime,
+			step:      0,
+			wantStart: startTime.Add(-15 * time.Second),
+		},
+		{
+			name:      "Next run",
+			end:       startTime.Add(10 * time.Second),
+			step:      0,
+			wantStart: startTime.Add(-5 * time.Second),
+		},
+		{
+			name:      "Forward step isn't clamped",
+			end:       startTime.Add(time.Hour),
+			step:      time.Hour,
+			wantStart: startTime.Add(time.Hour - 15*time.Second),
+		},
+		{
+			name:      "Backward step overlapping the previous window is clamped",
+			end:       startTime.Add(time.Hour + 5*time.Second),
+			step:      -5 * time.Second,
+			wantStart: startTime.Add(time.Hour),
+		},
+		{
+			name:      "Backward step into an already queried window isn't clamped",
+			end:       startTime.Add(30 * time.Minute),
+			step:      -30 * time.Minute,
+			wantStart: startTime.Add(30*time.Minute - 15*time.Second),
+		},
+		{
+			name:      "Backward step after the already queried windows is clamped to the latest end",
+			end:       startTime.Add(time.Hour + 12*time.Second),
+			step:      -2 * time.Second,
+			wantStart: startTime.Add(time.Hour + 5*time.Second),
+		},
+	}
+	for _, testcase := range tests {
+		start, end := window.window(testcase.end, testcase.step)
+		if !start.Equal(testcase.wantStart) || !end.Equal(testcase.end) {
+			t.Errorf("%v: JobWindow.window() = %v to %v, want %v to %v", testcase.name, start, end, testcase.wantStart, testcase.end)
+		}
+		if !start.Before(end) {
+			t.Errorf("%v: JobWindow.window() start %v isn't before end %v", testcase.name, start, end)
+		}
+	}
+}
+
+func TestJobWindow_Next(t *testing.T) {
+	window := NewJobWindow("test", 15*time.Second, time.Second)
+	now := time.Now()
+
+	start, end := window.Next(now, -time.Minute)
+	if want := now.Add(-time.Minute); !end.Equal(want) {
+		t.Errorf("JobWindow.Next() end = %v, want %v", end, want)
+	}
+	if want := now.Add(-time.Minute - 15*time.Second); !start.Equal(want) {
+		t.Errorf("JobWindow.Next() start = %v, want %v", start, want)
+	}
+}
@@ -0,0 +1,495 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kusto
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Authentication methods of the data management endpoint.
+const (
+	AuthManagedIdentity = "managed-identity"
+	AuthClientSecret    = "client-secret"
+)
+
+const (
+	// managedIdentityTokenURL is the Azure Instance Metadata Service endpoint of managed identity tokens
+	managedIdentityTokenURL = "http://169.254.169.254/metadata/identity/oauth2/token"
+	// clientSecretTokenURL is the Azure AD token endpoint of a tenant
+	clientSecretTokenURL = "https://login.microsoftonline.com/%v/oauth2/v2.0/token"
+
+	// tokenExpiryMargin renews a token before it expires, so it doesn't expire during a request
+	tokenExpiryMargin = 5 * time.Minute
+
+	maxErrorBodyBytes = 4096
+)
+
+var (
+	// ErrInvalidAuth authentication method isn't supported, or its credentials are missing.
+	ErrInvalidAuth = errors.New("invalid kusto authentication")
+	// ErrRequestFailed management command, storage, or token request got a non-2xx response.
+	ErrRequestFailed = errors.New("kusto request failed")
+)
+
+// TokenSource returns an Azure AD access token of the cluster.
+type TokenSource interface {
+	Token(ctx context.Context) (string, error)
+}
+
+// AuthConfig selects and configures the authentication of the ingestion endpoint.
+type AuthConfig struct {
+	// Method is AuthManagedIdentity or AuthClientSecret
+	Method string
+	// ClientID of the user-assigned managed identity (the system-assigned one if empty), or of the app registration
+	ClientID string
+	// TenantID and ClientSecret of the app registration, only for AuthClientSecret
+	TenantID     string
+	ClientSecret string
+}
+
+// NewTokenSource returns the token source of the auth config, for the cluster at clusterURL.
+func NewTokenSource(httpClient *http.Client, clusterURL string, config AuthConfig) (TokenSource, error) {
+	resource := strings.TrimSuffix(clusterURL, "/")
+
+	switch config.Method {
+	case AuthManagedIdentity:
+		query := url.Values{"api-version": {"2018-02-01"}, "resource": {resource}}
+		if config.ClientID != "" {
+			query.Set("client_id", config.ClientID)
+		}
+
+		return &cachedToken{ // nolint:exhaustivestruct
+			request: func(ctx context.Context) (*http.Request, error) {
+				req, err := http.NewRequestWithContext(ctx, http.MethodGet, managedIdentityTokenURL+"?"+query.Encode(), nil)
+				if err != nil {
+					return nil, err
+				}
+				req.Header.Set("Metadata", "true")
+
+				return req, nil
+			},
+			httpClient: httpClient,
+		}, nil
+	case AuthClientSecret:
+		if config.TenantID == "" || config.ClientID == "" || config.ClientSecret == "" {
+			return nil, fmt.Errorf("%w: %v requires a tenant ID, client ID, and client secret", ErrInvalidAuth, config.Method)
+		}
+		form := url.Values{
+			"grant_type":    {"client_credentials"},
+			"client_id":     {config.ClientID},
+			"client_secret": {config.ClientSecret},
+			"scope":         {resource + "/.default"},
+		}
+
+		return &cachedToken{ // nolint:exhaustivestruct
+			request: func(ctx context.Context) (*http.Request, error) {
+				req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf(clientSecretTokenURL, url.PathEscape(config.TenantID)),
+					strings.NewReader(form.Encode()))
+				if err != nil {
+					return nil, err
+				}
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+				return req, nil
+			},
+			httpClient: httpClient,
+		}, nil
+	}
+
+	return nil, fmt.Errorf("%w %q, expected '%v' or '%v'", ErrInvalidAuth, config.Method, AuthManagedIdentity, AuthClientSecret)
+}
+
+// cachedToken requests an access token, and reuses it until it's about to expire.
+type cachedToken struct {
+	request    func(ctx context.Context) (*http.Request, error)
+	httpClient *http.Client
+
+	mu        sync.Mutex
+	token     string
+	expiresAt time.Time
+}
+
+// tokenResponse is the token response of both Azure AD and the Instance Metadata Service,
+// whose expires_in is a number or a string respectively.
+type tokenResponse struct {
+	AccessToken string          `json:"access_token"`
+	ExpiresIn   json.RawMessage `json:"expires_in"`
+}
+
+// Token implements TokenSource.
+func (c *cachedToken) Token(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.token != "" && time.Now().Before(c.expiresAt) {
+		return c.token, nil
+	}
+
+	req, err := c.request(ctx)
+	if err != nil {
+		return "", fmt.Errorf("error creating token request: %w", err)
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("error requesting token: %w", err)
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp); err != nil {
+		return "", fmt.Errorf("error requesting token: %w", err)
+	}
+
+	var token tokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("error decoding token response: %w", err)
+	}
+	expiresIn, err := strconv.Atoi(strings.Trim(string(token.ExpiresIn), `"`))
+	if err != nil {
+		return "", fmt.Errorf("error parsing token expires_in %s: %w", token.ExpiresIn, err)
+	}
+	c.token = token.AccessToken
+	c.expiresAt = time.Now().Add(time.Duration(expiresIn)*time.Second - tokenExpiryMargin)
+
+	return c.token, nil
+}
+
+// QueuedIngestor ingests records with the queued ingestion of a database, like the Kusto SDKs: a batch is uploaded
+// as a gzipped blob to a temporary storage container of the cluster's data management endpoint, and an ingestion
+// message referencing the blob is posted to one of its ingestion queues. The cluster then ingests the blob
+// asynchronously, per the ingestion batching policy of the table (within 5 minutes by default).
+type QueuedIngestor struct {
+	httpClient  *http.Client
+	ingestURL   string
+	database    string
+	tokenSource TokenSource
+
+	mu sync.Mutex
+	// resources of the data management endpoint, fetched again after resourcesRefreshInterval
+	resources ingestionResources
+	fetchedAt time.Time
+	// next queue and container, to spread the ingestions across them
+	next int
+}
+
+// ingestionResources are the temporary storage containers and ingestion queues (URLs with SAS tokens) of the data
+// management endpoint, and the identity token that authorizes the cluster to ingest the uploaded blobs.
+type ingestionResources struct {
+	queues               []string
+	containers           []string
+	authorizationContext string
+}
+
+// ingestionMessage is the message of an ingestion queue referencing an uploaded blob.
+type ingestionMessage struct {
+	ID                   string            `json:"Id"`
+	BlobPath             string            `json:"BlobPath"`
+	RawDataSize          int               `json:"RawDataSize"`
+	DatabaseName         string            `json:"DatabaseName"`
+	TableName            string            `json:"TableName"`
+	RetainBlobOnSuccess  bool              `json:"RetainBlobOnSuccess"`
+	FlushImmediately     bool              `json:"FlushImmediately"`
+	ReportLevel          int               `json:"ReportLevel"`
+	ReportMethod         int               `json:"ReportMethod"`
+	AdditionalProperties map[string]string `json:"AdditionalProperties"`
+}
+
+const (
+	// resourcesRefreshInterval of the ingestion resources, whose SAS tokens are rotated by the cluster
+	resourcesRefreshInterval = time.Hour
+	// storageAPIVersion of the blob and queue requests
+	storageAPIVersion = "2019-12-12"
+	// reportLevelNone doesn't report the ingestion results, failures are still listed by '.show ingestion failures'
+	reportLevelNone = 1
+)
+
+// ErrNoIngestionResources the data management endpoint returned no ingestion queue or temporary storage.
+var ErrNoIngestionResources = errors.New("no kusto ingestion queue or temporary storage")
+
+// IngestionURL returns the data management endpoint of the cluster at clusterURL, whose host has an 'ingest-' prefix
+// (e.g. https://ingest-mycluster.westeurope.kusto.windows.net).
+func IngestionURL(clusterURL string) (string, error) {
+	u, err := url.Parse(strings.TrimSuffix(clusterURL, "/"))
+	if err != nil {
+		return "", fmt.Errorf("error parsing kusto cluster URL: %w", err)
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("error parsing kusto cluster URL %q: missing host", clusterURL)
+	}
+	if !strings.HasPrefix(u.Host, "ingest-") {
+		u.Host = "ingest-" + u.Host
+	}
+
+	return u.String(), nil
+}
+
+// NewQueuedIngestor returns a QueuedIngestor of a database, with the data management endpoint at ingestURL
+// (see IngestionURL).
+func NewQueuedIngestor(httpClient *http.Client, ingestURL, database string, tokenSource TokenSource) *QueuedIngestor {
+	return &QueuedIngestor{ // nolint:exhaustivestruct
+		httpClient:  httpClient,
+		ingestURL:   strings.TrimSuffix(ingestURL, "/"),
+		database:    database,
+		tokenSource: tokenSource,
+	}
+}
+
+// Ingest implements Ingestor. The JSON properties of the records are mapped to the table columns of the same name.
+// It returns once the ingestion is queued, before the records are ingested.
+func (q *QueuedIngestor) Ingest(ctx context.Context, table string, records io.Reader) error {
+	data, err := io.ReadAll(records)
+	if err != nil {
+		return fmt.Errorf("error reading records: %w", err)
+	}
+	var blob bytes.Buffer
+	gz := gzip.NewWriter(&blob)
+	if _, err := gz.Write(data); err != nil {
+		return fmt.Errorf("error compressing records: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("error compressing records: %w", err)
+	}
+
+	resources, next, err := q.ingestionResources(ctx)
+	if err != nil {
+		return err
+	}
+	id := uuid.New().String()
+	blobURL, err := resourceURL(resources.containers[next%len(resources.containers)],
+		fmt.Sprintf("%v__%v__%v.multijson.gz", q.database, table, id))
+	if err != nil {
+		return err
+	}
+	if err := q.storageRequest(ctx, http.MethodPut, blobURL, blob.Bytes(), map[string]string{"x-ms-blob-type": "BlockBlob"}); err != nil {
+		return fmt.Errorf("error uploading records blob: %w", err)
+	}
+
+	message, err := json.Marshal(ingestionMessage{
+		ID:                  id,
+		BlobPath:            blobURL,
+		RawDataSize:         len(data),
+		DatabaseName:        q.database,
+		TableName:           table,
+		RetainBlobOnSuccess: true,
+		FlushImmediately:    false,
+		ReportLevel:         reportLevelNone,
+		ReportMethod:        0,
+		AdditionalProperties: map[string]string{
+			"authorizationContext": resources.authorizationContext,
+			"format":               "multijson",
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error encoding ingestion message: %w", err)
+	}
+	queueURL, err := resourceURL(resources.queues[next%len(resources.queues)], "messages")
+	if err != nil {
+		return err
+	}
+	body := "<QueueMessage><MessageText>" + base64.StdEncoding.EncodeToString(message) + "</MessageText></QueueMessage>"
+	if err := q.storageRequest(ctx, http.MethodPost, queueURL, []byte(body), nil); err != nil {
+		return fmt.Errorf("error queueing ingestion: %w", err)
+	}
+
+	return nil
+}
+
+// ingestionResources returns the ingestion resources, fetched again once they're older than resourcesRefreshInterval,
+// and the index of the queue and container to use.
+func (q *QueuedIngestor) ingestionResources(ctx context.Context) (ingestionResources, int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.fetchedAt.IsZero() || time.Since(q.fetchedAt) > resourcesRefreshInterval {
+		resources, err := q.fetchIngestionResources(ctx)
+		if err != nil {
+			return ingestionResources{}, 0, err
+		}
+		q.resources, q.fetchedAt = resources, time.Now()
+	}
+	q.next++
+
+	return q.resources, q.next, nil
+}
+
+// fetchIngestionResources gets the ingestion queues, temporary storage containers, and identity token of the data
+// management endpoint.
+func (q *QueuedIngestor) fetchIngestionResources(ctx context.Context) (ingestionResources, error) {
+	var resources ingestionResources
+
+	rows, err := q.managementCommand(ctx, ".get ingestion resources", "ResourceTypeName", "StorageRoot")
+	if err != nil {
+		return resources, err
+	}
+	for _, row := range rows {
+		switch row[0] {
+		case "SecuredReadyForAggregationQueue":
+			resources.queues = append(resources.queues, row[1])
+		case "TempStorage":
+			resources.containers = append(resources.containers, row[1])
+		}
+	}
+	if len(resources.queues) == 0 || len(resources.containers) == 0 {
+		return resources, ErrNoIngestionResources
+	}
+
+	rows, err = q.managementCommand(ctx, ".get kusto identity token", "AuthorizationContext")
+	if err != nil {
+		return resources, err
+	}
+	if len(rows) == 0 || rows[0][0] == "" {
+		return resources, fmt.Errorf("%w: empty kusto identity token", ErrRequestFailed)
+	}
+	resources.authorizationContext = rows[0][0]
+
+	return resources, nil
+}
+
+// managementResponse is the response of a management command, in the v1 format.
+type managementResponse struct {
+	Tables []struct {
+		Columns []struct {
+			ColumnName string `json:"ColumnName"`
+		} `json:"Columns"`
+		Rows [][]interface{} `json:"Rows"`
+	} `json:"Tables"`
+}
+
+// managementCommand runs a management command on the data management endpoint, and returns the given string columns
+// of the rows of its first table.
+func (q *QueuedIngestor) managementCommand(ctx context.Context, command string, columns ...string) ([][]string, error) {
+	token, err := q.tokenSource.Token(ctx)
+	if err != nil {
+		return nil, err
+	}
+	body, err := json.Marshal(map[string]string{"db": q.database, "csl": command})
+	if err != nil {
+		return nil, fmt.Errorf("error encoding management command: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, q.ingestURL+"/v1/rest/mgmt", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("error creating management command request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := q.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error sending management command %q: %w", command, err)
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp); err != nil {
+		return nil, fmt.Errorf("error running management command %q: %w", command, err)
+	}
+
+	var response managementResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("error decoding management command %q response: %w", command, err)
+	}
+	if len(response.Tables) == 0 {
+		return nil, fmt.Errorf("%w: management command %q returned no table", ErrRequestFailed, command)
+	}
+	table := response.Tables[0]
+	indexes := make([]int, len(columns))
+	for i, column := range columns {
+		indexes[i] = -1
+		for j, tableColumn := range table.Columns {
+			if tableColumn.ColumnName == column {
+				indexes[i] = j
+			}
+		}
+		if indexes[i] < 0 {
+			return nil, fmt.Errorf("%w: management command %q returned no %v column", ErrRequestFailed, command, column)
+		}
+	}
+
+	rows := make([][]string, 0, len(table.Rows))
+	for _, tableRow := range table.Rows {
+		row := make([]string, len(columns))
+		for i, index := range indexes {
+			if index < len(tableRow) {
+				row[i], _ = tableRow[index].(string)
+			}
+		}
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+// storageRequest sends a blob or queue request to a storage URL authorized by its SAS token.
+func (q *QueuedIngestor) storageRequest(ctx context.Context, method, storageURL string, body []byte, headers map[string]string) error {
+	req, err := http.NewRequestWithContext(ctx, method, storageURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error creating storage request: %w", err)
+	}
+	req.Header.Set("x-ms-version", storageAPIVersion)
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := q.httpClient.Do(req)
+	if err != nil {
+		// The URL has a SAS token, which mustn't be logged
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+
+		return fmt.Errorf("error sending storage request: %w", err)
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp); err != nil {
+		return err
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	return nil
+}
+
+// resourceURL returns the URL of name in the container or queue at resource, keeping the resource's SAS token.
+func resourceURL(resource, name string) (string, error) {
+	u, err := url.Parse(resource)
+	if err != nil {
+		return "", fmt.Errorf("%w: invalid ingestion resource URL: %v", ErrRequestFailed, err)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + name
+
+	return u.String(), nil
+}
+
+// checkResponse returns an error with the start of the response body if the response isn't 2xx.
+func checkResponse(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodyBytes))
+
+	return fmt.Errorf("%w with status %v: %s", ErrRequestFailed, resp.Status, strings.TrimSpace(string(body)))
+}
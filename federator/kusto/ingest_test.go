@@ -0,0 +1,246 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kusto
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// staticToken is a TokenSource of a fixed token.
+type staticToken string
+
+func (s staticToken) Token(ctx context.Context) (string, error) { return string(s), nil }
+
+// mockIngestionServer serves the management commands, blob uploads, and queue messages of queued ingestion,
+// with a failing queue if failQueue.
+type mockIngestionServer struct {
+	mu            sync.Mutex
+	commands      []string
+	auth          []string
+	blobs         map[string][]byte
+	messages      []ingestionMessage
+	storageErrors int
+	failQueue     bool
+}
+
+func (m *mockIngestionServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch {
+	case r.URL.Path == "/v1/rest/mgmt":
+		var command map[string]string
+		_ = json.NewDecoder(r.Body).Decode(&command)
+		m.commands = append(m.commands, command["db"]+" "+command["csl"])
+		m.auth = append(m.auth, r.Header.Get("Authorization"))
+		storage := "http://" + r.Host
+		switch command["csl"] {
+		case ".get ingestion resources":
+			fmt.Fprintf(w, `{"Tables": [{"TableName": "Table_0",
+				"Columns": [{"ColumnName": "ResourceTypeName"}, {"ColumnName": "StorageRoot"}],
+				"Rows": [["SecuredReadyForAggregationQueue", "%[1]v/queue?sig=q"], ["TempStorage", "%[1]v/container?sig=c"],
+					["FailedIngestionsQueue", "%[1]v/failed?sig=f"]]}]}`, storage)
+		case ".get kusto identity token":
+			_, _ = io.WriteString(w, `{"Tables": [{"Columns": [{"ColumnName": "AuthorizationContext"}], "Rows": [["identity"]]}]}`)
+		}
+	case r.Method == http.MethodPut && strings.HasPrefix(r.URL.Path, "/container/"):
+		if r.URL.Query().Get("sig") != "c" || r.Header.Get("x-ms-blob-type") != "BlockBlob" {
+			m.storageErrors++
+		}
+		body, _ := io.ReadAll(r.Body)
+		m.blobs[r.URL.Path] = body
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodPost && r.URL.Path == "/queue/messages":
+		if r.URL.Query().Get("sig") != "q" {
+			m.storageErrors++
+		}
+		if m.failQueue {
+			http.Error(w, "queue is disabled", http.StatusForbidden)
+
+			return
+		}
+		var queueMessage struct {
+			MessageText string `xml:"MessageText"`
+		}
+		_ = xml.NewDecoder(r.Body).Decode(&queueMessage)
+		data, _ := base64.StdEncoding.DecodeString(queueMessage.MessageText)
+		var message ingestionMessage
+		_ = json.Unmarshal(data, &message)
+		m.messages = append(m.messages, message)
+		w.WriteHeader(http.StatusCreated)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestQueuedIngestor_Ingest(t *testing.T) {
+	mock := &mockIngestionServer{blobs: map[string][]byte{}} // nolint:exhaustivestruct
+	server := httptest.NewServer(mock)
+	defer server.Close()
+
+	ingestor := NewQueuedIngestor(server.Client(), server.URL+"/", "planet", staticToken("secret"))
+	for _, records := range []string{"{}\n{}\n", "{}\n"} {
+		if err := ingestor.Ingest(context.Background(), "traffic", strings.NewReader(records)); err != nil {
+			t.Fatalf("QueuedIngestor.Ingest() error = %v", err)
+		}
+	}
+
+	mock.mu.Lock()
+	defer mock.mu.Unlock()
+	// The ingestion resources are fetched once
+	if want := []string{"planet .get ingestion resources", "planet .get kusto identity token"}; !reflect.DeepEqual(mock.commands, want) {
+		t.Errorf("QueuedIngestor.Ingest() commands = %v, want %v", mock.commands, want)
+	}
+	if mock.auth[0] != "Bearer secret" {
+		t.Errorf("QueuedIngestor.Ingest() Authorization = %v, want Bearer secret", mock.auth[0])
+	}
+	if mock.storageErrors != 0 {
+		t.Errorf("QueuedIngestor.Ingest() sent %v storage requests without their SAS token or blob type", mock.storageErrors)
+	}
+	if len(mock.messages) != 2 {
+		t.Fatalf("QueuedIngestor.Ingest() queued %v messages, want 2", len(mock.messages))
+	}
+
+	message := mock.messages[0]
+	if message.DatabaseName != "planet" || message.TableName != "traffic" || message.RawDataSize != 6 ||
+		message.AdditionalProperties["format"] != "multijson" || message.AdditionalProperties["authorizationContext"] != "identity" {
+		t.Errorf("QueuedIngestor.Ingest() message = %+v, want the multijson records of planet.traffic", message)
+	}
+	blobURL, err := url.Parse(message.BlobPath)
+	if err != nil {
+		t.Fatalf("url.Parse() error = %v", err)
+	}
+	if blobURL.Query().Get("sig") != "c" {
+		t.Errorf("QueuedIngestor.Ingest() blob path = %v, want the container SAS token", message.BlobPath)
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(mock.blobs[blobURL.Path]))
+	if err != nil {
+		t.Fatalf("gzip.NewReader() of the blob %v error = %v", blobURL.Path, err)
+	}
+	if records, _ := io.ReadAll(gz); string(records) != "{}\n{}\n" {
+		t.Errorf("QueuedIngestor.Ingest() blob = %q, want the records", records)
+	}
+}
+
+func TestQueuedIngestor_Ingest_errors(t *testing.T) {
+	mock := &mockIngestionServer{blobs: map[string][]byte{}, failQueue: true} // nolint:exhaustivestruct
+	server := httptest.NewServer(mock)
+	defer server.Close()
+
+	ingestor := NewQueuedIngestor(server.Client(), server.URL, "planet", staticToken("secret"))
+	err := ingestor.Ingest(context.Background(), "traffic", strings.NewReader("{}\n"))
+	if !errors.Is(err, ErrRequestFailed) || !strings.Contains(err.Error(), "queue is disabled") {
+		t.Errorf("QueuedIngestor.Ingest() error = %v, want %v with the response body", err, ErrRequestFailed)
+	}
+
+	// A data management endpoint without ingestion resources
+	ingestor = NewQueuedIngestor(server.Client(), server.URL+"/missing", "planet", staticToken("secret"))
+	err = ingestor.Ingest(context.Background(), "traffic", strings.NewReader("{}\n"))
+	if !errors.Is(err, ErrRequestFailed) {
+		t.Errorf("QueuedIngestor.Ingest() error = %v, want %v", err, ErrRequestFailed)
+	}
+}
+
+func TestIngestionURL(t *testing.T) {
+	tests := []struct {
+		name       string
+		clusterURL string
+		want       string
+		wantErr    bool
+	}{
+		{name: "Cluster", clusterURL: "https://mycluster.westeurope.kusto.windows.net/", want: "https://ingest-mycluster.westeurope.kusto.windows.net"},
+		{name: "Data management endpoint", clusterURL: "https://ingest-mycluster.westeurope.kusto.windows.net", want: "https://ingest-mycluster.westeurope.kusto.windows.net"},
+		{name: "Without host", clusterURL: "mycluster", wantErr: true},
+	}
+	for _, testcase := range tests {
+		t.Run(testcase.name, func(t *testing.T) {
+			got, err := IngestionURL(testcase.clusterURL)
+			if (err != nil) != testcase.wantErr {
+				t.Fatalf("IngestionURL() error = %v, wantErr %v", err, testcase.wantErr)
+			}
+			if got != testcase.want {
+				t.Errorf("IngestionURL() = %v, want %v", got, testcase.want)
+			}
+		})
+	}
+}
+
+func Test_cachedToken(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		// The Instance Metadata Service returns expires_in as a string
+		_, _ = io.WriteString(w, `{"access_token": "token", "expires_in": "3599"}`)
+	}))
+	defer server.Close()
+
+	token := &cachedToken{ // nolint:exhaustivestruct
+		request: func(ctx context.Context) (*http.Request, error) {
+			return http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+		},
+		httpClient: server.Client(),
+	}
+	for i := 0; i < 2; i++ {
+		got, err := token.Token(context.Background())
+		if err != nil {
+			t.Fatalf("cachedToken.Token() error = %v", err)
+		}
+		if got != "token" {
+			t.Errorf("cachedToken.Token() = %v, want token", got)
+		}
+	}
+	if requests != 1 {
+		t.Errorf("cachedToken.Token() requested %v tokens, want 1", requests)
+	}
+}
+
+func TestNewTokenSource(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  AuthConfig
+		wantErr bool
+	}{
+		{name: "Managed identity", config: AuthConfig{Method: AuthManagedIdentity}, wantErr: false}, // nolint:exhaustivestruct
+		{name: "Client secret", config: AuthConfig{Method: AuthClientSecret, TenantID: "t", ClientID: "c", ClientSecret: "s"}, wantErr: false},
+		{name: "Client secret without secret", config: AuthConfig{Method: AuthClientSecret, TenantID: "t", ClientID: "c"}, wantErr: true}, // nolint:exhaustivestruct
+		{name: "Unknown method", config: AuthConfig{Method: "password"}, wantErr: true},                                                   // nolint:exhaustivestruct
+	}
+	for _, testcase := range tests {
+		t.Run(testcase.name, func(t *testing.T) {
+			_, err := NewTokenSource(http.DefaultClient, "https://cluster.kusto.windows.net", testcase.config)
+			if (err != nil) != testcase.wantErr {
+				t.Errorf("NewTokenSource() error = %v, wantErr %v", err, testcase.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidAuth) {
+				t.Errorf("NewTokenSource() error = %v, want %v", err, ErrInvalidAuth)
+			}
+		})
+	}
+}
@@ -0,0 +1,317 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package kusto is a federator backend for Azure Data Explorer (Kusto). It ingests traffic and dependency records
+// as multi-line JSON into a traffic and a dependency table, whose columns are named after the BigQuery tables written
+// by planet-federator-influxdb-to-bq. The traffic records are per job run rather than the hourly statistics of BigQuery.
+package kusto
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"planet-exporter/federator"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Dependency directions of the dependency table.
+const (
+	UpstreamDependencyDirection   = "upstream"
+	DownstreamDependencyDirection = "downstream"
+)
+
+// Default table names.
+const (
+	DefaultTrafficTable    = "traffic"
+	DefaultDependencyTable = "dependency"
+)
+
+// Ingestor hands a batch of multi-line JSON records to the Kusto ingestion endpoint of a table.
+type Ingestor interface {
+	Ingest(ctx context.Context, table string, records io.Reader) error
+}
+
+// TrafficRecord is a row of the traffic table.
+//
+//	.create table traffic (inventory_date: datetime, traffic_direction: string, local_hostgroup: string,
+//	    local_hostgroup_address: string, remote_hostgroup: string, remote_hostgroup_address: string,
+//	    traffic_bandwidth_bits: long, local_instance: string, schema_version: long)
+type TrafficRecord struct {
+	InventoryDate          time.Time `json:"inventory_date"`
+	TrafficDirection       string    `json:"traffic_direction"`
+	LocalHostgroup         string    `json:"local_hostgroup"`
+	LocalHostgroupAddress  string    `json:"local_hostgroup_address,omitempty"`
+	RemoteHostgroup        string    `json:"remote_hostgroup"`
+	RemoteHostgroupAddress string    `json:"remote_hostgroup_address,omitempty"`
+	// TrafficBandwidthBits of the job run, aggregated over time in queries (e.g. into the 1h min/max/avg of BigQuery)
+	TrafficBandwidthBits int64 `json:"traffic_bandwidth_bits"`
+	// LocalInstance of per-instance traffic, empty for the traffic of the whole local hostgroup
+	LocalInstance string `json:"local_instance,omitempty"`
+	SchemaVersion int64  `json:"schema_version"`
+}
+
+// DependencyRecord is a row of the dependency table.
+//
+//	.create table dependency (inventory_date: datetime, dependency_direction: string, protocol: string,
+//	    local_hostgroup_process_name: string, local_hostgroup: string, local_hostgroup_address: string,
+//	    local_hostgroup_address_port: string, remote_hostgroup: string, remote_hostgroup_address: string,
+//	    remote_hostgroup_address_port: string, schema_version: long)
+type DependencyRecord struct {
+	InventoryDate             time.Time `json:"inventory_date"`
+	DependencyDirection       string    `json:"dependency_direction"`
+	Protocol                  string    `json:"protocol"`
+	LocalHostgroupProcessName string    `json:"local_hostgroup_process_name,omitempty"`
+	LocalHostgroup            string    `json:"local_hostgroup"`
+	LocalHostgroupAddress     string    `json:"local_hostgroup_address,omitempty"`
+	// LocalHostgroupAddressPort is only set for downstream dependencies
+	LocalHostgroupAddressPort string `json:"local_hostgroup_address_port,omitempty"`
+	RemoteHostgroup           string `json:"remote_hostgroup"`
+	RemoteHostgroupAddress    string `json:"remote_hostgroup_address,omitempty"`
+	// RemoteHostgroupAddressPort is only set for upstream dependencies
+	RemoteHostgroupAddressPort string `json:"remote_hostgroup_address_port,omitempty"`
+	SchemaVersion              int64  `json:"schema_version"`
+	// FirstSeen is the earliest time the dependency edge was observed at, only set if it's tracked
+	FirstSeen *time.Time `json:"first_seen,omitempty"`
+}
+
+// Config of a Backend.
+type Config struct {
+	TrafficTable    string
+	DependencyTable string
+
+	// BatchSize is the maximum records of a table ingested at once
+	BatchSize int
+	// FlushInterval between ingestions of batches that aren't full yet, only on Flush if zero
+	FlushInterval time.Duration
+	// IngestTimeout of a batch ingestion, no timeout if zero
+	IngestTimeout time.Duration
+
+	// StrictTrafficDirection rejects traffic data with unknown direction instead of storing it as unknown
+	StrictTrafficDirection bool
+}
+
+// batch is the buffered records of a table.
+type batch struct {
+	table   string
+	buf     bytes.Buffer
+	records int
+}
+
+// Backend buffers the records of each table, and ingests a table's records once there's a full batch,
+// every flush interval, or on Flush. Collector health summaries aren't written, as in BigQuery.
+type Backend struct {
+	ingestor Ingestor
+	config   Config
+
+	mu         sync.Mutex
+	traffic    *batch
+	dependency *batch
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// New returns a Kusto federator backend ingesting with ingestor. Call Close to stop its flush interval.
+func New(ingestor Ingestor, config Config) *Backend {
+	if config.BatchSize < 1 {
+		config.BatchSize = 1
+	}
+
+	b := &Backend{
+		ingestor: ingestor,
+		config:   config,
+
+		mu:         sync.Mutex{},
+		traffic:    &batch{table: config.TrafficTable},    // nolint:exhaustivestruct
+		dependency: &batch{table: config.DependencyTable}, // nolint:exhaustivestruct
+
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	go b.flushEvery(config.FlushInterval)
+
+	return b
+}
+
+// flushEvery flushes the buffered records every interval until the backend is closed.
+func (b *Backend) flushEvery(interval time.Duration) {
+	defer close(b.done)
+	if interval <= 0 {
+		<-b.stop
+
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			b.Flush()
+		case <-b.stop:
+			return
+		}
+	}
+}
+
+// AddTrafficBandwidthData adds a service's ingress or egress bandwidth record.
+func (b *Backend) AddTrafficBandwidthData(ctx context.Context, trafficBandwidth federator.TrafficBandwidth, timeOfDataPoint time.Time) error {
+	direction, err := federator.NormalizeTrafficDirection(trafficBandwidth.Direction,
+		trafficBandwidth.LocalHostgroup, trafficBandwidth.RemoteHostgroup, b.config.StrictTrafficDirection)
+	if err != nil {
+		return err
+	}
+
+	return b.add(ctx, b.traffic, TrafficRecord{
+		InventoryDate:          timeOfDataPoint.UTC(),
+		TrafficDirection:       direction,
+		LocalHostgroup:         trafficBandwidth.LocalHostgroup,
+		LocalHostgroupAddress:  trafficBandwidth.LocalAddress,
+		RemoteHostgroup:        trafficBandwidth.RemoteHostgroup,
+		RemoteHostgroupAddress: trafficBandwidth.RemoteDomain,
+		TrafficBandwidthBits:   int64(trafficBandwidth.BitsPerSecond),
+		LocalInstance:          trafficBandwidth.LocalInstance,
+		SchemaVersion:          federator.SchemaVersion,
+	})
+}
+
+// AddUpstreamService adds an upstream service dependency record of a service.
+func (b *Backend) AddUpstreamService(ctx context.Context, upstreamService federator.UpstreamService, timeOfDataPoint time.Time) error {
+	return b.add(ctx, b.dependency, DependencyRecord{ // nolint:exhaustivestruct
+		InventoryDate:              timeOfDataPoint.UTC(),
+		DependencyDirection:        UpstreamDependencyDirection,
+		Protocol:                   upstreamService.Protocol,
+		LocalHostgroupProcessName:  upstreamService.LocalProcessName,
+		LocalHostgroup:             upstreamService.LocalHostgroup,
+		LocalHostgroupAddress:      upstreamService.LocalAddress,
+		RemoteHostgroup:            upstreamService.UpstreamHostgroup,
+		RemoteHostgroupAddress:     upstreamService.UpstreamAddress,
+		RemoteHostgroupAddressPort: upstreamService.UpstreamPort,
+		SchemaVersion:              federator.SchemaVersion,
+		FirstSeen:                  utcTime(upstreamService.FirstSeen),
+	})
+}
+
+// AddDownstreamService adds a downstream service dependency record of a service.
+func (b *Backend) AddDownstreamService(ctx context.Context, downstreamService federator.DownstreamService, timeOfDataPoint time.Time) error {
+	return b.add(ctx, b.dependency, DependencyRecord{ // nolint:exhaustivestruct
+		InventoryDate:             timeOfDataPoint.UTC(),
+		DependencyDirection:       DownstreamDependencyDirection,
+		Protocol:                  downstreamService.Protocol,
+		LocalHostgroupProcessName: downstreamService.LocalProcessName,
+		LocalHostgroup:            downstreamService.LocalHostgroup,
+		LocalHostgroupAddress:     downstreamService.LocalAddress,
+		LocalHostgroupAddressPort: downstreamService.LocalPort,
+		RemoteHostgroup:           downstreamService.DownstreamHostgroup,
+		RemoteHostgroupAddress:    downstreamService.DownstreamAddress,
+		SchemaVersion:             federator.SchemaVersion,
+		FirstSeen:                 utcTime(downstreamService.FirstSeen),
+	})
+}
+
+// utcTime returns t in UTC like the inventory date, nil if t is nil.
+func utcTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	utc := t.UTC()
+
+	return &utc
+}
+
+// AddCollectorHealth does nothing, there's no collector health table.
+func (b *Backend) AddCollectorHealth(ctx context.Context, collectorHealth federator.CollectorHealth, timeOfDataPoint time.Time) error {
+	return nil
+}
+
+// maxBufferedBatches bounds the records of a table kept buffered while their ingestion fails, in batches,
+// so a down cluster doesn't grow the buffer indefinitely.
+const maxBufferedBatches = 10
+
+// add buffers a record of a table, and ingests the table's buffered records once there's another full batch.
+func (b *Backend) add(ctx context.Context, batch *batch, record interface{}) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("error encoding %v record: %w", batch.table, err)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	batch.buf.Write(line)
+	batch.buf.WriteByte('\n')
+	batch.records++
+	if batch.records%b.config.BatchSize != 0 {
+		return nil
+	}
+
+	return b.ingest(ctx, batch)
+}
+
+// ingest hands every buffered record of a table to the ingestor, the caller must hold the lock.
+// Records are kept buffered when the ingestion fails, and ingested again along with the next batch (or flush),
+// unless there are maxBufferedBatches batches of them already, which are dropped.
+func (b *Backend) ingest(ctx context.Context, batch *batch) error {
+	if batch.records == 0 {
+		return nil
+	}
+
+	records := batch.records
+	payload := bytes.NewReader(append([]byte(nil), batch.buf.Bytes()...))
+
+	if b.config.IngestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, b.config.IngestTimeout)
+		defer cancel()
+	}
+	if err := b.ingestor.Ingest(ctx, batch.table, payload); err != nil {
+		if records < maxBufferedBatches*b.config.BatchSize {
+			return fmt.Errorf("error ingesting %v records into kusto table %v, kept for the next ingestion: %w", records, batch.table, err)
+		}
+		batch.buf.Reset()
+		batch.records = 0
+
+		return fmt.Errorf("error ingesting %v records into kusto table %v, dropped after %v failed batches: %w",
+			records, batch.table, maxBufferedBatches, err)
+	}
+	batch.buf.Reset()
+	batch.records = 0
+
+	return nil
+}
+
+// Flush ingests all buffered records, and returns once their ingestion is queued.
+func (b *Backend) Flush() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, batch := range []*batch{b.traffic, b.dependency} {
+		if err := b.ingest(context.Background(), batch); err != nil {
+			log.Errorf("Failed to flush kusto ingestion: %v", err)
+		}
+	}
+}
+
+// Close stops the flush interval and flushes the buffered records.
+func (b *Backend) Close() {
+	close(b.stop)
+	<-b.done
+	b.Flush()
+}
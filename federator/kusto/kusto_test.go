@@ -0,0 +1,240 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kusto
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"planet-exporter/federator"
+)
+
+var errMockIngest = errors.New("mock ingest error")
+
+// fakeIngestor records the lines of every ingested batch per table.
+type fakeIngestor struct {
+	mu        sync.Mutex
+	batches   map[string][][]string
+	ingestErr error
+}
+
+func (f *fakeIngestor) Ingest(ctx context.Context, table string, records io.Reader) error {
+	lines := []string{}
+	scanner := bufio.NewScanner(records)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.batches == nil {
+		f.batches = map[string][][]string{}
+	}
+	f.batches[table] = append(f.batches[table], lines)
+
+	return f.ingestErr
+}
+
+func (f *fakeIngestor) batchCount(table string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return len(f.batches[table])
+}
+
+func TestBackend_batchesAndFlush(t *testing.T) {
+	ingestor := &fakeIngestor{}                                                                      // nolint:exhaustivestruct
+	b := New(ingestor, Config{TrafficTable: "traffic", DependencyTable: "dependency", BatchSize: 2}) // nolint:exhaustivestruct
+	defer b.Close()
+	ctx := context.Background()
+	timeOfDataPoint := time.Date(2021, 6, 1, 9, 59, 50, 0, time.UTC)
+
+	traffic := federator.TrafficBandwidth{LocalHostgroup: "local", RemoteHostgroup: "remote", Direction: "egress", BitsPerSecond: 1000.5} // nolint:exhaustivestruct
+	for i := 0; i < 3; i++ {
+		if err := b.AddTrafficBandwidthData(ctx, traffic, timeOfDataPoint); err != nil {
+			t.Fatalf("Backend.AddTrafficBandwidthData() error = %v", err)
+		}
+	}
+	if err := b.AddUpstreamService(ctx, federator.UpstreamService{LocalHostgroup: "local", UpstreamHostgroup: "remote"}, timeOfDataPoint); err != nil { // nolint:exhaustivestruct
+		t.Fatalf("Backend.AddUpstreamService() error = %v", err)
+	}
+	if err := b.AddCollectorHealth(ctx, federator.CollectorHealth{LocalHostgroup: "local", Collector: "socketstat"}, timeOfDataPoint); err != nil { // nolint:exhaustivestruct
+		t.Fatalf("Backend.AddCollectorHealth() error = %v", err)
+	}
+	if got := ingestor.batchCount("traffic"); got != 1 {
+		t.Fatalf("Backend ingested %v traffic batches, want 1", got)
+	}
+	if got := ingestor.batchCount("dependency"); got != 0 {
+		t.Fatalf("Backend ingested %v dependency batches before a full batch, want 0", got)
+	}
+
+	b.Flush()
+	if got := ingestor.batchCount("traffic"); got != 2 {
+		t.Errorf("Backend ingested %v traffic batches after Flush(), want 2", got)
+	}
+	if got := ingestor.batchCount("dependency"); got != 1 {
+		t.Errorf("Backend ingested %v dependency batches after Flush(), want 1", got)
+	}
+	if got := len(ingestor.batches["traffic"][0]); got != 2 {
+		t.Errorf("Backend ingested %v records in a full batch, want 2", got)
+	}
+
+	b.Flush()
+	if got := ingestor.batchCount("traffic") + ingestor.batchCount("dependency"); got != 3 {
+		t.Errorf("Backend ingested %v batches after an empty Flush(), want 3", got)
+	}
+}
+
+func TestBackend_flushInterval(t *testing.T) {
+	ingestor := &fakeIngestor{}                                                                                                              // nolint:exhaustivestruct
+	b := New(ingestor, Config{TrafficTable: "traffic", DependencyTable: "dependency", BatchSize: 100, FlushInterval: 10 * time.Millisecond}) // nolint:exhaustivestruct
+	defer b.Close()
+
+	if err := b.AddDownstreamService(context.Background(), federator.DownstreamService{LocalHostgroup: "local", DownstreamHostgroup: "remote"}, time.Now()); err != nil { // nolint:exhaustivestruct
+		t.Fatalf("Backend.AddDownstreamService() error = %v", err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for ingestor.batchCount("dependency") == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Backend didn't ingest the batch within the flush interval")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestBackend_records(t *testing.T) {
+	ingestor := &fakeIngestor{}                                                                       // nolint:exhaustivestruct
+	b := New(ingestor, Config{TrafficTable: "traffic", DependencyTable: "dependency", BatchSize: 10}) // nolint:exhaustivestruct
+	defer b.Close()
+	ctx := context.Background()
+	timeOfDataPoint := time.Date(2021, 6, 1, 9, 59, 50, 0, time.FixedZone("UTC+7", 7*60*60))
+
+	_ = b.AddTrafficBandwidthData(ctx, federator.TrafficBandwidth{
+		LocalHostgroup: "local", LocalAddress: "local.domain", RemoteHostgroup: "remote", RemoteDomain: "remote.domain",
+		BitsPerSecond: 2048.9, Direction: "sideways", LocalInstance: "10.0.0.1:19100",
+	}, timeOfDataPoint)
+	_ = b.AddUpstreamService(ctx, federator.UpstreamService{
+		LocalHostgroup: "local", LocalAddress: "local.domain", LocalProcessName: "app", UpstreamPort: "5432",
+		UpstreamHostgroup: "db", UpstreamAddress: "db.domain", Protocol: "tcp",
+	}, timeOfDataPoint)
+	_ = b.AddDownstreamService(ctx, federator.DownstreamService{
+		LocalHostgroup: "local", LocalAddress: "local.domain", LocalProcessName: "app", LocalPort: "80",
+		DownstreamHostgroup: "web", DownstreamAddress: "web.domain", Protocol: "tcp",
+	}, timeOfDataPoint)
+	b.Flush()
+
+	inventoryDate := timeOfDataPoint.UTC()
+	wantTraffic := TrafficRecord{
+		InventoryDate: inventoryDate, TrafficDirection: federator.UnknownDirection,
+		LocalHostgroup: "local", LocalHostgroupAddress: "local.domain", RemoteHostgroup: "remote", RemoteHostgroupAddress: "remote.domain",
+		TrafficBandwidthBits: 2048, LocalInstance: "10.0.0.1:19100", SchemaVersion: federator.SchemaVersion,
+	}
+	wantDependencies := []DependencyRecord{
+		{
+			InventoryDate: inventoryDate, DependencyDirection: UpstreamDependencyDirection, Protocol: "tcp", LocalHostgroupProcessName: "app",
+			LocalHostgroup: "local", LocalHostgroupAddress: "local.domain", RemoteHostgroup: "db", RemoteHostgroupAddress: "db.domain",
+			RemoteHostgroupAddressPort: "5432", SchemaVersion: federator.SchemaVersion,
+		},
+		{
+			InventoryDate: inventoryDate, DependencyDirection: DownstreamDependencyDirection, Protocol: "tcp", LocalHostgroupProcessName: "app",
+			LocalHostgroup: "local", LocalHostgroupAddress: "local.domain", LocalHostgroupAddressPort: "80", RemoteHostgroup: "web",
+			RemoteHostgroupAddress: "web.domain", SchemaVersion: federator.SchemaVersion,
+		},
+	}
+
+	var gotTraffic TrafficRecord
+	if err := json.Unmarshal([]byte(ingestor.batches["traffic"][0][0]), &gotTraffic); err != nil {
+		t.Fatalf("Unmarshal traffic record error = %v", err)
+	}
+	if !reflect.DeepEqual(gotTraffic, wantTraffic) {
+		t.Errorf("Traffic record = %+v, want %+v", gotTraffic, wantTraffic)
+	}
+	for i, line := range ingestor.batches["dependency"][0] {
+		var gotDependency DependencyRecord
+		if err := json.Unmarshal([]byte(line), &gotDependency); err != nil {
+			t.Fatalf("Unmarshal dependency record error = %v", err)
+		}
+		if !reflect.DeepEqual(gotDependency, wantDependencies[i]) {
+			t.Errorf("Dependency record %v = %+v, want %+v", i, gotDependency, wantDependencies[i])
+		}
+	}
+}
+
+func TestBackend_errors(t *testing.T) {
+	ingestor := &fakeIngestor{ingestErr: errMockIngest}                                                                            // nolint:exhaustivestruct
+	b := New(ingestor, Config{TrafficTable: "traffic", DependencyTable: "dependency", BatchSize: 1, StrictTrafficDirection: true}) // nolint:exhaustivestruct
+	defer b.Close()
+	ctx := context.Background()
+
+	err := b.AddTrafficBandwidthData(ctx, federator.TrafficBandwidth{Direction: "sideways"}, time.Now()) // nolint:exhaustivestruct
+	if !errors.Is(err, federator.ErrUnknownTrafficDirection) {
+		t.Errorf("Backend.AddTrafficBandwidthData() error = %v, want %v", err, federator.ErrUnknownTrafficDirection)
+	}
+	err = b.AddTrafficBandwidthData(ctx, federator.TrafficBandwidth{Direction: "ingress"}, time.Now()) // nolint:exhaustivestruct
+	if !errors.Is(err, errMockIngest) {
+		t.Errorf("Backend.AddTrafficBandwidthData() error = %v, want %v", err, errMockIngest)
+	}
+	if got := ingestor.batchCount("traffic"); got != 1 {
+		t.Errorf("Backend ingested %v traffic batches, want 1", got)
+	}
+
+	// The failed batch is kept, and ingested again on Flush
+	ingestor.mu.Lock()
+	ingestor.ingestErr = nil
+	ingestor.mu.Unlock()
+	b.Flush()
+	if got := ingestor.batchCount("traffic"); got != 2 {
+		t.Fatalf("Backend ingested %v traffic batches after Flush(), want 2", got)
+	}
+	if got := len(ingestor.batches["traffic"][1]); got != 1 {
+		t.Errorf("Backend ingested %v records again after Flush(), want 1", got)
+	}
+	b.Flush()
+	if got := ingestor.batchCount("traffic"); got != 2 {
+		t.Errorf("Backend ingested %v traffic batches after an empty Flush(), want 2", got)
+	}
+}
+
+func TestBackend_maxBufferedBatches(t *testing.T) {
+	ingestor := &fakeIngestor{ingestErr: errMockIngest}                                              // nolint:exhaustivestruct
+	b := New(ingestor, Config{TrafficTable: "traffic", DependencyTable: "dependency", BatchSize: 2}) // nolint:exhaustivestruct
+	defer b.Close()
+	traffic := federator.TrafficBandwidth{Direction: "ingress"} // nolint:exhaustivestruct
+
+	for i := 1; i <= maxBufferedBatches*2; i++ {
+		err := b.AddTrafficBandwidthData(context.Background(), traffic, time.Now())
+		if wantErr := i%2 == 0; (err != nil) != wantErr {
+			t.Fatalf("Backend.AddTrafficBandwidthData() %v error = %v, wantErr %v", i, err, wantErr)
+		}
+	}
+	// Every full batch retried the failed ones, until maxBufferedBatches batches were dropped
+	if got := len(ingestor.batches["traffic"][maxBufferedBatches-1]); got != maxBufferedBatches*2 {
+		t.Errorf("Backend ingested %v records in the last attempt, want %v", got, maxBufferedBatches*2)
+	}
+	b.mu.Lock()
+	records := b.traffic.records
+	b.mu.Unlock()
+	if records != 0 {
+		t.Errorf("Backend kept %v records after %v failed batches, want 0", records, maxBufferedBatches)
+	}
+}
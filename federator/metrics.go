@@ -0,0 +1,104 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package federator
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "planet_federator"
+
+var writeThrottledSecondsTotal = prometheus.NewCounter(prometheus.CounterOpts{ // nolint:exhaustivestruct
+	Namespace: namespace,
+	Subsystem: "backend",
+	Name:      "write_throttled_seconds_total",
+	Help:      "Total time spent waiting for the backend write rate limiter.",
+})
+
+var circuitBreakerState = prometheus.NewGauge(prometheus.GaugeOpts{ // nolint:exhaustivestruct
+	Namespace: namespace,
+	Subsystem: "backend",
+	Name:      "circuit_breaker_state",
+	Help:      "State of the backend circuit breaker (0: closed, 1: open, 2: half-open).",
+})
+
+var circuitBreakerRejectedTotal = prometheus.NewCounter(prometheus.CounterOpts{ // nolint:exhaustivestruct
+	Namespace: namespace,
+	Subsystem: "backend",
+	Name:      "circuit_breaker_rejected_total",
+	Help:      "Total backend writes rejected by the open circuit breaker.",
+})
+
+var rowsDroppedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{ // nolint:exhaustivestruct
+	Namespace: namespace,
+	Name:      "rows_dropped_total",
+	Help:      "Total rows dropped for exceeding the per-hostgroup row limit of a federator run.",
+}, []string{"local_hostgroup"})
+
+var rowsFilteredTotal = prometheus.NewCounter(prometheus.CounterOpts{ // nolint:exhaustivestruct
+	Namespace: namespace,
+	Name:      "rows_filtered_total",
+	Help:      "Total rows skipped for a local or remote hostgroup that fails the include/exclude hostgroups.",
+})
+
+var unknownTrafficDirectionTotal = prometheus.NewCounter(prometheus.CounterOpts{ // nolint:exhaustivestruct
+	Namespace: namespace,
+	Name:      "unknown_traffic_direction_total",
+	Help:      "Total traffic rows with a direction other than ingress/egress.",
+})
+
+var jobQueryWindowStartSeconds = prometheus.NewGaugeVec(prometheus.GaugeOpts{ // nolint:exhaustivestruct
+	Namespace: namespace,
+	Subsystem: "job",
+	Name:      "query_window_start_timestamp_seconds",
+	Help:      "Start of the query window of the last job run, in seconds since the epoch.",
+}, []string{"job"})
+
+var jobQueryWindowEndSeconds = prometheus.NewGaugeVec(prometheus.GaugeOpts{ // nolint:exhaustivestruct
+	Namespace: namespace,
+	Subsystem: "job",
+	Name:      "query_window_end_timestamp_seconds",
+	Help:      "End of the query window of the last job run, in seconds since the epoch.",
+}, []string{"job"})
+
+var clockStepsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{ // nolint:exhaustivestruct
+	Namespace: namespace,
+	Name:      "clock_steps_total",
+	Help:      "Total wall clock steps (e.g. NTP corrections) detected between job runs.",
+}, []string{"job"})
+
+// ObserveJobQueryWindow records the [start, end] query window of a job run, so a wrong time offset shows up
+// as a window far from the current time.
+func ObserveJobQueryWindow(job string, start, end time.Time) {
+	jobQueryWindowStartSeconds.WithLabelValues(job).Set(float64(start.UnixNano()) / float64(time.Second))
+	jobQueryWindowEndSeconds.WithLabelValues(job).Set(float64(end.UnixNano()) / float64(time.Second))
+}
+
+// Collectors returns federator's Prometheus collectors.
+func Collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		writeThrottledSecondsTotal,
+		circuitBreakerState,
+		circuitBreakerRejectedTotal,
+		rowsDroppedTotal,
+		rowsFilteredTotal,
+		unknownTrafficDirectionTotal,
+		jobQueryWindowStartSeconds,
+		jobQueryWindowEndSeconds,
+		clockStepsTotal,
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package federator
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// MultiBackend writes every data point to all of its backends.
+// A failing backend doesn't stop the data point from being written to the other backends.
+type MultiBackend struct {
+	backends []Backend
+}
+
+// NewMultiBackend returns a Backend writing to all of the backends.
+func NewMultiBackend(backends ...Backend) *MultiBackend {
+	return &MultiBackend{
+		backends: backends,
+	}
+}
+
+// AddTrafficBandwidthData adds a service's ingress or egress bandwidth data point to all backends.
+func (m *MultiBackend) AddTrafficBandwidthData(ctx context.Context, trafficBandwidth TrafficBandwidth, timeOfDataPoint time.Time) error {
+	return m.each(func(b Backend) error {
+		return b.AddTrafficBandwidthData(ctx, trafficBandwidth, timeOfDataPoint)
+	})
+}
+
+// AddUpstreamService adds an upstream service dependency of a service to all backends.
+func (m *MultiBackend) AddUpstreamService(ctx context.Context, upstreamService UpstreamService, timeOfDataPoint time.Time) error {
+	return m.each(func(b Backend) error {
+		return b.AddUpstreamService(ctx, upstreamService, timeOfDataPoint)
+	})
+}
+
+// AddDownstreamService adds a downstream service dependency of a service to all backends.
+func (m *MultiBackend) AddDownstreamService(ctx context.Context, downstreamService DownstreamService, timeOfDataPoint time.Time) error {
+	return m.each(func(b Backend) error {
+		return b.AddDownstreamService(ctx, downstreamService, timeOfDataPoint)
+	})
+}
+
+// AddCollectorHealth adds a planet-exporter collector health summary of a service to all backends.
+func (m *MultiBackend) AddCollectorHealth(ctx context.Context, collectorHealth CollectorHealth, timeOfDataPoint time.Time) error {
+	return m.each(func(b Backend) error {
+		return b.AddCollectorHealth(ctx, collectorHealth, timeOfDataPoint)
+	})
+}
+
+// Flush flushes all backends.
+func (m *MultiBackend) Flush() {
+	for _, b := range m.backends {
+		b.Flush()
+	}
+}
+
+// each calls fn on every backend and joins their errors.
+func (m *MultiBackend) each(fn func(Backend) error) error {
+	var errs []error
+	for _, b := range m.backends {
+		if err := fn(b); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,43 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package federator
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMultiBackend(t *testing.T) {
+	ctx := context.Background()
+	failing := &mockBackend{err: errMockBackend, calls: 0}
+	healthy := &mockBackend{err: nil, calls: 0}
+	b := NewMultiBackend(failing, healthy)
+
+	err := b.AddUpstreamService(ctx, UpstreamService{}, time.Now()) // nolint:exhaustivestruct
+	if !errors.Is(err, errMockBackend) {
+		t.Errorf("MultiBackend.AddUpstreamService() error = %v, want %v", err, errMockBackend)
+	}
+	// A failing backend doesn't stop the write to the other backends
+	if failing.calls != 1 || healthy.calls != 1 {
+		t.Errorf("MultiBackend backend calls = %v, %v, want 1, 1", failing.calls, healthy.calls)
+	}
+
+	failing.err = nil
+	if err := b.AddCollectorHealth(ctx, CollectorHealth{}, time.Now()); err != nil { // nolint:exhaustivestruct
+		t.Errorf("MultiBackend.AddCollectorHealth() error = %v, want nil", err)
+	}
+}
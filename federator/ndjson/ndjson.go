@@ -0,0 +1,186 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package ndjson writes federator data points as NDJSON records, one JSON object per line, and imports them back
+// into a federator backend (e.g. to develop a backend without Prometheus, or to restore exported data).
+package ndjson
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"planet-exporter/federator"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Record types.
+const (
+	TrafficBandwidthRecord  = "traffic_bandwidth"
+	UpstreamServiceRecord   = "upstream_service"
+	DownstreamServiceRecord = "downstream_service"
+	CollectorHealthRecord   = "collector_health"
+)
+
+// maxRecordBytes is the maximum size of an imported record line.
+const maxRecordBytes = 1 << 20
+
+// ErrUnknownRecordType record type isn't one of the record types.
+var ErrUnknownRecordType = errors.New("unknown record type")
+
+// Record is a data point of a federator backend, with the data of its type and the time of the data point.
+// WindowStart is the WindowStart of its data, the query window start of the job run that wrote it, omitted if the
+// run didn't have one.
+type Record struct {
+	Type        string     `json:"type"`
+	Time        time.Time  `json:"time"`
+	WindowStart *time.Time `json:"window_start,omitempty"`
+
+	TrafficBandwidth  *federator.TrafficBandwidth  `json:"traffic_bandwidth,omitempty"`
+	UpstreamService   *federator.UpstreamService   `json:"upstream_service,omitempty"`
+	DownstreamService *federator.DownstreamService `json:"downstream_service,omitempty"`
+	CollectorHealth   *federator.CollectorHealth   `json:"collector_health,omitempty"`
+}
+
+// Backend writes every data point as a record line to a writer (e.g. stdout). It's safe for concurrent use.
+type Backend struct {
+	mu      sync.Mutex
+	encoder *json.Encoder
+}
+
+// New returns a Backend writing records to w.
+func New(w io.Writer) *Backend {
+	return &Backend{
+		mu:      sync.Mutex{},
+		encoder: json.NewEncoder(w),
+	}
+}
+
+// write writes a record line.
+func (b *Backend) write(record Record) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.encoder.Encode(record); err != nil {
+		return fmt.Errorf("error writing %v record: %w", record.Type, err)
+	}
+
+	return nil
+}
+
+// AddTrafficBandwidthData writes a traffic bandwidth record.
+func (b *Backend) AddTrafficBandwidthData(ctx context.Context, trafficBandwidth federator.TrafficBandwidth, timeOfDataPoint time.Time) error {
+	return b.write(Record{Type: TrafficBandwidthRecord, Time: timeOfDataPoint, WindowStart: trafficBandwidth.WindowStart, TrafficBandwidth: &trafficBandwidth}) // nolint:exhaustivestruct
+}
+
+// AddUpstreamService writes an upstream service record.
+func (b *Backend) AddUpstreamService(ctx context.Context, upstreamService federator.UpstreamService, timeOfDataPoint time.Time) error {
+	return b.write(Record{Type: UpstreamServiceRecord, Time: timeOfDataPoint, WindowStart: upstreamService.WindowStart, UpstreamService: &upstreamService}) // nolint:exhaustivestruct
+}
+
+// AddDownstreamService writes a downstream service record.
+func (b *Backend) AddDownstreamService(ctx context.Context, downstreamService federator.DownstreamService, timeOfDataPoint time.Time) error {
+	return b.write(Record{Type: DownstreamServiceRecord, Time: timeOfDataPoint, WindowStart: downstreamService.WindowStart, DownstreamService: &downstreamService}) // nolint:exhaustivestruct
+}
+
+// AddCollectorHealth writes a collector health record.
+func (b *Backend) AddCollectorHealth(ctx context.Context, collectorHealth federator.CollectorHealth, timeOfDataPoint time.Time) error {
+	return b.write(Record{Type: CollectorHealthRecord, Time: timeOfDataPoint, WindowStart: collectorHealth.WindowStart, CollectorHealth: &collectorHealth}) // nolint:exhaustivestruct
+}
+
+// Flush does nothing, records are written right away.
+func (b *Backend) Flush() {}
+
+// ImportStats counts the records of an import.
+type ImportStats struct {
+	// Imported records written to the backend
+	Imported int
+	// Skipped records that couldn't be parsed
+	Skipped int
+	// Failed records whose backend write failed
+	Failed int
+}
+
+// Import reads record lines from r and writes them to b (e.g. a federator.Service) with their time, until r's end
+// or ctx is done. Records that can't be parsed, or fail to be written, are logged and counted, and the import goes on.
+// The backend isn't flushed.
+func Import(ctx context.Context, r io.Reader, b federator.Backend) (ImportStats, error) {
+	stats := ImportStats{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxRecordBytes)
+	for line := 1; scanner.Scan(); line++ {
+		if err := ctx.Err(); err != nil {
+			return stats, fmt.Errorf("error importing records: %w", err)
+		}
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var record Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			log.Warnf("Skip record on line %v: %v", line, err)
+			stats.Skipped++
+
+			continue
+		}
+		if err := addRecord(ctx, b, record); err != nil {
+			if errors.Is(err, ErrUnknownRecordType) {
+				log.Warnf("Skip record on line %v: %v", line, err)
+				stats.Skipped++
+
+				continue
+			}
+			log.Errorf("Failed to import record on line %v: %v", line, err)
+			stats.Failed++
+
+			continue
+		}
+		stats.Imported++
+	}
+	if err := scanner.Err(); err != nil {
+		return stats, fmt.Errorf("error reading records: %w", err)
+	}
+
+	return stats, nil
+}
+
+// addRecord writes the data of a record to b, with its window start.
+func addRecord(ctx context.Context, b federator.Backend, record Record) error {
+	switch {
+	case record.Type == TrafficBandwidthRecord && record.TrafficBandwidth != nil:
+		record.TrafficBandwidth.WindowStart = record.WindowStart
+
+		return b.AddTrafficBandwidthData(ctx, *record.TrafficBandwidth, record.Time)
+	case record.Type == UpstreamServiceRecord && record.UpstreamService != nil:
+		record.UpstreamService.WindowStart = record.WindowStart
+
+		return b.AddUpstreamService(ctx, *record.UpstreamService, record.Time)
+	case record.Type == DownstreamServiceRecord && record.DownstreamService != nil:
+		record.DownstreamService.WindowStart = record.WindowStart
+
+		return b.AddDownstreamService(ctx, *record.DownstreamService, record.Time)
+	case record.Type == CollectorHealthRecord && record.CollectorHealth != nil:
+		record.CollectorHealth.WindowStart = record.WindowStart
+
+		return b.AddCollectorHealth(ctx, *record.CollectorHealth, record.Time)
+	}
+
+	return fmt.Errorf("%w %q (or its data is missing)", ErrUnknownRecordType, record.Type)
+}
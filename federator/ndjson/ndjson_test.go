@@ -0,0 +1,183 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ndjson
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"planet-exporter/federator"
+)
+
+// recordingBackend records the data points written to it as records.
+type recordingBackend struct {
+	records []Record
+}
+
+func (b *recordingBackend) AddTrafficBandwidthData(ctx context.Context, trafficBandwidth federator.TrafficBandwidth, t time.Time) error {
+	b.records = append(b.records, Record{Type: TrafficBandwidthRecord, Time: t, WindowStart: trafficBandwidth.WindowStart, TrafficBandwidth: &trafficBandwidth}) // nolint:exhaustivestruct
+
+	return nil
+}
+
+func (b *recordingBackend) AddUpstreamService(ctx context.Context, upstreamService federator.UpstreamService, t time.Time) error {
+	b.records = append(b.records, Record{Type: UpstreamServiceRecord, Time: t, WindowStart: upstreamService.WindowStart, UpstreamService: &upstreamService}) // nolint:exhaustivestruct
+
+	return nil
+}
+
+func (b *recordingBackend) AddDownstreamService(ctx context.Context, downstreamService federator.DownstreamService, t time.Time) error {
+	b.records = append(b.records, Record{Type: DownstreamServiceRecord, Time: t, WindowStart: downstreamService.WindowStart, DownstreamService: &downstreamService}) // nolint:exhaustivestruct
+
+	return nil
+}
+
+func (b *recordingBackend) AddCollectorHealth(ctx context.Context, collectorHealth federator.CollectorHealth, t time.Time) error {
+	b.records = append(b.records, Record{Type: CollectorHealthRecord, Time: t, WindowStart: collectorHealth.WindowStart, CollectorHealth: &collectorHealth}) // nolint:exhaustivestruct
+
+	return nil
+}
+
+func (b *recordingBackend) Flush() {}
+
+func TestImport_roundTrip(t *testing.T) {
+	ctx := context.Background()
+	trafficTime := time.Date(2021, 5, 1, 10, 0, 15, 123456789, time.UTC)
+	dependencyTime := time.Date(2021, 5, 1, 10, 1, 0, 0, time.FixedZone("WIB", 7*60*60))
+
+	// Export through the backend
+	var exported bytes.Buffer
+	b := New(&exported)
+	want := &recordingBackend{} // nolint:exhaustivestruct
+	for _, backend := range []federator.Backend{b, want} {
+		if err := backend.AddTrafficBandwidthData(ctx, federator.TrafficBandwidth{ // nolint:exhaustivestruct
+			LocalHostgroup: "app", RemoteHostgroup: "db", BitsPerSecond: 1500.5, Direction: "egress", LocalInstance: "10.0.0.1:19100",
+		}, trafficTime); err != nil {
+			t.Fatalf("AddTrafficBandwidthData() error = %v", err)
+		}
+		if err := backend.AddUpstreamService(ctx, federator.UpstreamService{ // nolint:exhaustivestruct
+			LocalHostgroup: "app", UpstreamHostgroup: "db", UpstreamPort: "5432", Protocol: "tcp",
+		}, dependencyTime); err != nil {
+			t.Fatalf("AddUpstreamService() error = %v", err)
+		}
+		if err := backend.AddDownstreamService(ctx, federator.DownstreamService{ // nolint:exhaustivestruct
+			LocalHostgroup: "db", LocalPort: "5432", DownstreamHostgroup: "app", Protocol: "tcp",
+		}, dependencyTime); err != nil {
+			t.Fatalf("AddDownstreamService() error = %v", err)
+		}
+		if err := backend.AddCollectorHealth(ctx, federator.CollectorHealth{ // nolint:exhaustivestruct
+			LocalHostgroup: "app", Collector: "socketstat", Instances: 3, FailingInstances: 1, AvgDurationSeconds: 0.25,
+		}, dependencyTime); err != nil {
+			t.Fatalf("AddCollectorHealth() error = %v", err)
+		}
+	}
+	if lines := strings.Count(exported.String(), "\n"); lines != 4 {
+		t.Fatalf("Backend wrote %v lines, want 4", lines)
+	}
+
+	// Import through the federator service
+	got := &recordingBackend{} // nolint:exhaustivestruct
+	svc := federator.New(federator.Config{WriteRateLimit: 0, WriteRateLimitBurst: 0}, got)
+	stats, err := Import(ctx, &exported, svc)
+	if err != nil {
+		t.Fatalf("Import() error = %v", err)
+	}
+	if wantStats := (ImportStats{Imported: 4}); stats != wantStats { // nolint:exhaustivestruct
+		t.Errorf("Import() stats = %+v, want %+v", stats, wantStats)
+	}
+	if len(got.records) != len(want.records) {
+		t.Fatalf("Import() imported %v records, want %v", len(got.records), len(want.records))
+	}
+	for i := range want.records {
+		// Times are compared as instants, the location of a time isn't kept
+		if !got.records[i].Time.Equal(want.records[i].Time) {
+			t.Errorf("Import() record %v time = %v, want %v", i, got.records[i].Time, want.records[i].Time)
+		}
+		got.records[i].Time = want.records[i].Time
+		if !reflect.DeepEqual(got.records[i], want.records[i]) {
+			t.Errorf("Import() record %v = %+v, want %+v", i, got.records[i], want.records[i])
+		}
+	}
+}
+
+func TestImport_invalidRecords(t *testing.T) {
+	input := strings.Join([]string{
+		`{"type":"upstream_service","time":"2021-05-01T10:00:00Z","upstream_service":{"LocalHostgroup":"app"}}`,
+		``,
+		`not a record`,
+		`{"type":"kafka_offset","time":"2021-05-01T10:00:00Z"}`,
+		`{"type":"traffic_bandwidth","time":"2021-05-01T10:00:00Z"}`,
+		`{"type":"collector_health","time":"2021-05-01T10:00:00Z","collector_health":{"LocalHostgroup":"app"}}`,
+	}, "\n")
+
+	got := &recordingBackend{} // nolint:exhaustivestruct
+	stats, err := Import(context.Background(), strings.NewReader(input), got)
+	if err != nil {
+		t.Fatalf("Import() error = %v", err)
+	}
+	if want := (ImportStats{Imported: 2, Skipped: 3}); stats != want { // nolint:exhaustivestruct
+		t.Errorf("Import() stats = %+v, want %+v", stats, want)
+	}
+}
+
+func TestImport_cancelledContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	input := `{"type":"upstream_service","time":"2021-05-01T10:00:00Z","upstream_service":{"LocalHostgroup":"app"}}`
+	got := &recordingBackend{} // nolint:exhaustivestruct
+	if _, err := Import(ctx, strings.NewReader(input), got); err == nil {
+		t.Errorf("Import() error = nil, want an error on a cancelled context")
+	}
+	if len(got.records) != 0 {
+		t.Errorf("Import() imported %v records, want 0", len(got.records))
+	}
+}
+
+func TestBackend_windowStart(t *testing.T) {
+	windowStart := time.Date(2021, 5, 1, 10, 0, 0, 0, time.UTC)
+	dataPointTime := windowStart.Add(15 * time.Second)
+	upstream := federator.UpstreamService{LocalHostgroup: "app", UpstreamHostgroup: "db", UpstreamPort: "5432", Protocol: "tcp"} // nolint:exhaustivestruct
+	upstreamOfRun := upstream
+	upstreamOfRun.WindowStart = &windowStart
+
+	var exported bytes.Buffer
+	b := New(&exported)
+	if err := b.AddUpstreamService(context.Background(), upstreamOfRun, dataPointTime); err != nil {
+		t.Fatalf("AddUpstreamService() error = %v", err)
+	}
+	if err := b.AddUpstreamService(context.Background(), upstream, dataPointTime); err != nil {
+		t.Fatalf("AddUpstreamService() error = %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(exported.String()), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], `"window_start":"2021-05-01T10:00:00Z"`) || strings.Contains(lines[1], "window_start") {
+		t.Fatalf("Backend records = %v, want the window start of the first one only", lines)
+	}
+
+	// The window start is imported along with the record
+	got := &recordingBackend{} // nolint:exhaustivestruct
+	if _, err := Import(context.Background(), &exported, got); err != nil {
+		t.Fatalf("Import() error = %v", err)
+	}
+	if len(got.records) != 2 || got.records[0].WindowStart == nil || !got.records[0].WindowStart.Equal(windowStart) || got.records[1].WindowStart != nil ||
+		got.records[0].UpstreamService.WindowStart != got.records[0].WindowStart {
+		t.Errorf("Import() records = %+v, want the window start of the first one only", got.records)
+	}
+}
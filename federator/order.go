@@ -0,0 +1,134 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package federator
+
+import (
+	"sort"
+	"strings"
+)
+
+// Rows of a job run are written in a stable order, so the output of two runs (e.g. of the stdout backend) can be
+// diffed regardless of the order Prometheus returned them in.
+
+// SortTrafficBandwidth sorts traffic by hostgroups, direction, and instance.
+func SortTrafficBandwidth(rows []TrafficBandwidth) {
+	sort.SliceStable(rows, func(i, j int) bool {
+		a, b := &rows[i], &rows[j]
+		switch {
+		case a.LocalHostgroup != b.LocalHostgroup:
+			return a.LocalHostgroup < b.LocalHostgroup
+		case a.RemoteHostgroup != b.RemoteHostgroup:
+			return a.RemoteHostgroup < b.RemoteHostgroup
+		case a.Direction != b.Direction:
+			return a.Direction < b.Direction
+		case a.LocalInstance != b.LocalInstance:
+			return a.LocalInstance < b.LocalInstance
+		case a.LocalAddress != b.LocalAddress:
+			return a.LocalAddress < b.LocalAddress
+		default:
+			return a.RemoteDomain < b.RemoteDomain
+		}
+	})
+}
+
+// SortUpstreamServices sorts upstreams by hostgroups, upstream port, and protocol.
+func SortUpstreamServices(rows []UpstreamService) {
+	sort.SliceStable(rows, func(i, j int) bool {
+		a, b := &rows[i], &rows[j]
+		switch {
+		case a.LocalHostgroup != b.LocalHostgroup:
+			return a.LocalHostgroup < b.LocalHostgroup
+		case a.UpstreamHostgroup != b.UpstreamHostgroup:
+			return a.UpstreamHostgroup < b.UpstreamHostgroup
+		case a.UpstreamPort != b.UpstreamPort:
+			return lessPort(a.UpstreamPort, b.UpstreamPort)
+		case a.Protocol != b.Protocol:
+			return a.Protocol < b.Protocol
+		case a.LocalProcessName != b.LocalProcessName:
+			return a.LocalProcessName < b.LocalProcessName
+		case a.LocalAddress != b.LocalAddress:
+			return a.LocalAddress < b.LocalAddress
+		default:
+			return a.UpstreamAddress < b.UpstreamAddress
+		}
+	})
+}
+
+// SortDownstreamServices sorts downstreams by hostgroups, local port, and protocol.
+func SortDownstreamServices(rows []DownstreamService) {
+	sort.SliceStable(rows, func(i, j int) bool {
+		a, b := &rows[i], &rows[j]
+		switch {
+		case a.LocalHostgroup != b.LocalHostgroup:
+			return a.LocalHostgroup < b.LocalHostgroup
+		case a.DownstreamHostgroup != b.DownstreamHostgroup:
+			return a.DownstreamHostgroup < b.DownstreamHostgroup
+		case a.LocalPort != b.LocalPort:
+			return lessPort(a.LocalPort, b.LocalPort)
+		case a.Protocol != b.Protocol:
+			return a.Protocol < b.Protocol
+		case a.LocalProcessName != b.LocalProcessName:
+			return a.LocalProcessName < b.LocalProcessName
+		case a.LocalAddress != b.LocalAddress:
+			return a.LocalAddress < b.LocalAddress
+		default:
+			return a.DownstreamAddress < b.DownstreamAddress
+		}
+	})
+}
+
+// SortCollectorHealth sorts collector health by hostgroup and collector.
+func SortCollectorHealth(rows []CollectorHealth) {
+	sort.SliceStable(rows, func(i, j int) bool {
+		a, b := &rows[i], &rows[j]
+		if a.LocalHostgroup != b.LocalHostgroup {
+			return a.LocalHostgroup < b.LocalHostgroup
+		}
+
+		return a.Collector < b.Collector
+	})
+}
+
+// lessPort compares ports numerically (e.g. "9" < "10"), non-numeric ports are sorted as strings after them.
+func lessPort(a, b string) bool {
+	aNumeric, bNumeric := isDigits(a), isDigits(b)
+	switch {
+	case aNumeric && bNumeric:
+		a, b = strings.TrimLeft(a, "0"), strings.TrimLeft(b, "0")
+		if len(a) != len(b) {
+			return len(a) < len(b)
+		}
+
+		return a < b
+	case aNumeric != bNumeric:
+		return aNumeric
+	default:
+		return a < b
+	}
+}
+
+// isDigits returns whether s is a non-empty string of decimal digits.
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+
+	return true
+}
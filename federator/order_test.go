@@ -0,0 +1,126 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package federator
+
+import (
+	"math/rand"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestSortUpstreamServices(t *testing.T) {
+	want := []UpstreamService{
+		{LocalHostgroup: "app", UpstreamHostgroup: "cache", UpstreamPort: "6379", Protocol: "tcp"},                            // nolint:exhaustivestruct
+		{LocalHostgroup: "app", UpstreamHostgroup: "db", UpstreamPort: "5432", Protocol: "tcp", LocalProcessName: "api"},      // nolint:exhaustivestruct
+		{LocalHostgroup: "app", UpstreamHostgroup: "db", UpstreamPort: "5432", Protocol: "tcp", LocalProcessName: "worker"},   // nolint:exhaustivestruct
+		{LocalHostgroup: "app", UpstreamHostgroup: "dns", UpstreamPort: "53", Protocol: "tcp"},                                // nolint:exhaustivestruct
+		{LocalHostgroup: "app", UpstreamHostgroup: "dns", UpstreamPort: "53", Protocol: "udp"},                                // nolint:exhaustivestruct
+		{LocalHostgroup: "app", UpstreamHostgroup: "dns", UpstreamPort: "853", Protocol: "tcp"},                               // nolint:exhaustivestruct
+		{LocalHostgroup: "web", UpstreamHostgroup: "app", UpstreamPort: "8080", Protocol: "tcp", UpstreamAddress: "10.0.0.1"}, // nolint:exhaustivestruct
+	}
+
+	// Every order of the rows sorts into the same order
+	for i := 0; i < 10; i++ {
+		rows := make([]UpstreamService, len(want))
+		for j, k := range rand.Perm(len(want)) {
+			rows[j] = want[k]
+		}
+		SortUpstreamServices(rows)
+		if !reflect.DeepEqual(rows, want) {
+			t.Fatalf("SortUpstreamServices() = %+v, want %+v", rows, want)
+		}
+	}
+}
+
+func TestSortDownstreamServices(t *testing.T) {
+	want := []DownstreamService{
+		{LocalHostgroup: "db", DownstreamHostgroup: "app", LocalPort: "5432", Protocol: "tcp"},    // nolint:exhaustivestruct
+		{LocalHostgroup: "db", DownstreamHostgroup: "batch", LocalPort: "5432", Protocol: "tcp"},  // nolint:exhaustivestruct
+		{LocalHostgroup: "db", DownstreamHostgroup: "batch", LocalPort: "15432", Protocol: "tcp"}, // nolint:exhaustivestruct
+		{LocalHostgroup: "dns", DownstreamHostgroup: "app", LocalPort: "53", Protocol: "tcp"},     // nolint:exhaustivestruct
+		{LocalHostgroup: "dns", DownstreamHostgroup: "app", LocalPort: "53", Protocol: "udp"},     // nolint:exhaustivestruct
+	}
+
+	rows := []DownstreamService{want[4], want[2], want[1], want[0], want[3]}
+	SortDownstreamServices(rows)
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("SortDownstreamServices() = %+v, want %+v", rows, want)
+	}
+}
+
+func TestSortTrafficBandwidth(t *testing.T) {
+	want := []TrafficBandwidth{
+		{LocalHostgroup: "app", RemoteHostgroup: "db", Direction: "egress", BitsPerSecond: 2},                                  // nolint:exhaustivestruct
+		{LocalHostgroup: "app", RemoteHostgroup: "db", Direction: "egress", LocalInstance: "10.0.0.1:19100", BitsPerSecond: 1}, // nolint:exhaustivestruct
+		{LocalHostgroup: "app", RemoteHostgroup: "db", Direction: "ingress", BitsPerSecond: 3},                                 // nolint:exhaustivestruct
+		{LocalHostgroup: "db", RemoteHostgroup: "app", Direction: "egress", BitsPerSecond: 4},                                  // nolint:exhaustivestruct
+	}
+
+	rows := []TrafficBandwidth{want[2], want[3], want[1], want[0]}
+	SortTrafficBandwidth(rows)
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("SortTrafficBandwidth() = %+v, want %+v", rows, want)
+	}
+}
+
+func TestSortCollectorHealth(t *testing.T) {
+	want := []CollectorHealth{
+		{LocalHostgroup: "app", Collector: "darkstat"},   // nolint:exhaustivestruct
+		{LocalHostgroup: "app", Collector: "socketstat"}, // nolint:exhaustivestruct
+		{LocalHostgroup: "db", Collector: "darkstat"},    // nolint:exhaustivestruct
+	}
+
+	rows := []CollectorHealth{want[2], want[1], want[0]}
+	SortCollectorHealth(rows)
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("SortCollectorHealth() = %+v, want %+v", rows, want)
+	}
+}
+
+func Test_lessPort(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{a: "9", b: "10", want: true},
+		{a: "10", b: "9", want: false},
+		{a: "443", b: "80", want: false},
+		{a: "80", b: "http", want: true},
+		{a: "http", b: "80", want: false},
+		{a: "", b: "80", want: false},
+		{a: "ftp", b: "http", want: true},
+	}
+	for _, testcase := range tests {
+		if got := lessPort(testcase.a, testcase.b); got != testcase.want {
+			t.Errorf("lessPort(%q, %q) = %v, want %v", testcase.a, testcase.b, got, testcase.want)
+		}
+	}
+}
+
+func BenchmarkSortUpstreamServices(b *testing.B) {
+	rows := make([]UpstreamService, 1000)
+	for i := range rows {
+		rows[i] = UpstreamService{LocalHostgroup: "app", UpstreamHostgroup: "db", UpstreamPort: strconv.Itoa(rand.Intn(65536))} // nolint:exhaustivestruct
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		rand.Shuffle(len(rows), func(i, j int) { rows[i], rows[j] = rows[j], rows[i] })
+		b.StartTimer()
+		SortUpstreamServices(rows)
+	}
+}
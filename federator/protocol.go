@@ -0,0 +1,27 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package federator
+
+// ProtocolOrDefault returns the protocol of a dependency, or defaultProtocol if it has none.
+//
+// Socketstat dependencies have their L4 protocol (tcp/udp), but darkstat/ebpf-derived dependencies don't,
+// so they'd otherwise be stored with an empty protocol (e.g. violating a REQUIRED BigQuery column).
+func ProtocolOrDefault(protocol, defaultProtocol string) string {
+	if protocol == "" {
+		return defaultProtocol
+	}
+
+	return protocol
+}
@@ -0,0 +1,37 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package federator
+
+import "testing"
+
+func TestProtocolOrDefault(t *testing.T) {
+	tests := []struct {
+		name            string
+		protocol        string
+		defaultProtocol string
+		want            string
+	}{
+		{name: "Socketstat protocol is kept", protocol: "tcp", defaultProtocol: "ip", want: "tcp"},
+		{name: "Empty protocol gets the default", protocol: "", defaultProtocol: "ip", want: "ip"},
+		{name: "No default keeps it empty", protocol: "", defaultProtocol: "", want: ""},
+	}
+	for _, testcase := range tests {
+		t.Run(testcase.name, func(t *testing.T) {
+			if got := ProtocolOrDefault(testcase.protocol, testcase.defaultProtocol); got != testcase.want {
+				t.Errorf("ProtocolOrDefault() = %v, want %v", got, testcase.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package federator
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket rate limiter that is safe for concurrent use.
+type rateLimiter struct {
+	mu sync.Mutex
+
+	// ratePerSecond is the number of tokens added to the bucket every second
+	ratePerSecond float64
+	// burst is the bucket size
+	burst float64
+
+	tokens     float64
+	lastRefill time.Time
+}
+
+// newRateLimiter returns a token bucket rate limiter with a full bucket.
+// A burst lower than 1 is treated as 1.
+func newRateLimiter(ratePerSecond float64, burst int) *rateLimiter {
+	bucketSize := math.Max(1, float64(burst))
+
+	return &rateLimiter{
+		mu:            sync.Mutex{},
+		ratePerSecond: ratePerSecond,
+		burst:         bucketSize,
+		tokens:        bucketSize,
+		lastRefill:    time.Now(),
+	}
+}
+
+// Wait blocks until a token is available or ctx is done. It returns the time spent waiting.
+// A token is reserved before waiting, so concurrent callers are served in order.
+func (r *rateLimiter) Wait(ctx context.Context) (time.Duration, error) {
+	r.mu.Lock()
+	now := time.Now()
+	r.tokens = math.Min(r.burst, r.tokens+now.Sub(r.lastRefill).Seconds()*r.ratePerSecond)
+	r.lastRefill = now
+	r.tokens--
+	tokens := r.tokens
+	r.mu.Unlock()
+
+	if tokens >= 0 {
+		return 0, nil
+	}
+
+	delay := time.Duration(-tokens / r.ratePerSecond * float64(time.Second))
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return delay, nil
+
+	case <-ctx.Done():
+		// Return the reserved token since it won't be used
+		r.mu.Lock()
+		r.tokens = math.Min(r.burst, r.tokens+1)
+		r.mu.Unlock()
+
+		return time.Since(now), fmt.Errorf("error waiting for rate limiter: %w", ctx.Err())
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package federator
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func Test_rateLimiter_Wait(t *testing.T) {
+	t.Run("Burst is allowed without waiting", func(t *testing.T) {
+		limiter := newRateLimiter(1, 3)
+		for i := 0; i < 3; i++ {
+			waited, err := limiter.Wait(context.Background())
+			if err != nil || waited != 0 {
+				t.Errorf("rateLimiter.Wait() = %v, %v, want 0, nil", waited, err)
+			}
+		}
+	})
+
+	t.Run("Wait when the bucket is empty", func(t *testing.T) {
+		limiter := newRateLimiter(100, 1)
+		_, _ = limiter.Wait(context.Background())
+
+		waited, err := limiter.Wait(context.Background())
+		if err != nil {
+			t.Errorf("rateLimiter.Wait() error = %v", err)
+		}
+		if waited <= 0 {
+			t.Errorf("rateLimiter.Wait() waited = %v, want > 0", waited)
+		}
+	})
+
+	t.Run("Cancelled context is not blocked by an empty bucket", func(t *testing.T) {
+		limiter := newRateLimiter(0.001, 1)
+		_, _ = limiter.Wait(context.Background())
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		start := time.Now()
+		_, err := limiter.Wait(ctx)
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("rateLimiter.Wait() error = %v, want %v", err, context.DeadlineExceeded)
+		}
+		if time.Since(start) > time.Second {
+			t.Errorf("rateLimiter.Wait() blocked for %v after context is done", time.Since(start))
+		}
+	})
+}
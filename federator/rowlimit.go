@@ -0,0 +1,68 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package federator
+
+import (
+	log "github.com/sirupsen/logrus"
+)
+
+// HostgroupRowLimit caps the number of rows written per local hostgroup within a single federator run,
+// so a hostgroup with exploded cardinality can't dominate the run. It's not safe for concurrent use.
+type HostgroupRowLimit struct {
+	// maxRows per local hostgroup, unlimited if zero
+	maxRows int
+
+	rows    map[string]int
+	dropped map[string]int
+}
+
+// NewHostgroupRowLimit returns a row limit for a single run. A maxRows lower than 1 means unlimited.
+func NewHostgroupRowLimit(maxRows int) *HostgroupRowLimit {
+	return &HostgroupRowLimit{
+		maxRows: maxRows,
+		rows:    make(map[string]int),
+		dropped: make(map[string]int),
+	}
+}
+
+// Allow returns whether another row of the local hostgroup can be written in this run.
+// Rows over the limit are counted as dropped.
+func (l *HostgroupRowLimit) Allow(localHostgroup string) bool {
+	if l.maxRows < 1 {
+		return true
+	}
+
+	if l.rows[localHostgroup] >= l.maxRows {
+		l.dropped[localHostgroup]++
+		rowsDroppedTotal.WithLabelValues(localHostgroup).Inc()
+
+		return false
+	}
+	l.rows[localHostgroup]++
+
+	return true
+}
+
+// Dropped returns the number of dropped rows per local hostgroup.
+func (l *HostgroupRowLimit) Dropped() map[string]int {
+	return l.dropped
+}
+
+// WarnDropped logs a warning for every local hostgroup that had rows dropped in this run.
+func (l *HostgroupRowLimit) WarnDropped(jobName string) {
+	for localHostgroup, dropped := range l.dropped {
+		log.Warnf("%v dropped %v rows of hostgroup %v over the limit of %v rows per run", jobName, dropped, localHostgroup, l.maxRows)
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package federator
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestHostgroupRowLimit_Allow(t *testing.T) {
+	tests := []struct {
+		name        string
+		maxRows     int
+		hostgroups  []string
+		wantAllowed int
+		wantDropped map[string]int
+	}{
+		{
+			name:        "Unlimited",
+			maxRows:     0,
+			hostgroups:  []string{"a", "a", "a", "b"},
+			wantAllowed: 4,
+			wantDropped: map[string]int{},
+		},
+		{
+			name:        "Overflow is dropped per hostgroup",
+			maxRows:     2,
+			hostgroups:  []string{"a", "a", "a", "a", "b", "b"},
+			wantAllowed: 4,
+			wantDropped: map[string]int{"a": 2},
+		},
+	}
+	for _, testcase := range tests {
+		t.Run(testcase.name, func(t *testing.T) {
+			l := NewHostgroupRowLimit(testcase.maxRows)
+
+			allowed := 0
+			for _, hostgroup := range testcase.hostgroups {
+				if l.Allow(hostgroup) {
+					allowed++
+				}
+			}
+			if allowed != testcase.wantAllowed {
+				t.Errorf("HostgroupRowLimit.Allow() allowed = %v, want %v", allowed, testcase.wantAllowed)
+			}
+			if got := l.Dropped(); !reflect.DeepEqual(got, testcase.wantDropped) {
+				t.Errorf("HostgroupRowLimit.Dropped() = %v, want %v", got, testcase.wantDropped)
+			}
+		})
+	}
+}
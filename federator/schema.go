@@ -0,0 +1,27 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package federator
+
+// SchemaVersion of the records written by the federator backends, written with every record so consumers know
+// which semantics a record was written with. Bump it whenever the semantics of a written field change
+// (e.g. a new stats field, or a field counting something else), and document the change below.
+//
+// Versions:
+//   - 1: first versioned schema. Records written before don't have a schema version.
+//   - 2: traffic records of the per-instance hostgroups are also written per planet-exporter instance,
+//     with a local_instance tag. Hostgroup traffic records don't have the tag.
+//   - 3: upstream/downstream records have a first_seen field/column with -federator-first-seen, missing or null
+//     without it.
+const SchemaVersion = 3
@@ -0,0 +1,51 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package federator
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// timeOffsetSyntax describes the expected syntax of a time offset.
+const timeOffsetSyntax = "a signed duration with unit suffixes (ns, us, ms, s, m, h), e.g. '-5m', '-1h30m', or '0s'"
+
+var (
+	// ErrInvalidTimeOffset time offset is not a valid duration.
+	ErrInvalidTimeOffset = errors.New("invalid time offset")
+	// ErrFutureTimeOffset time offset is positive, which queries data from the future.
+	ErrFutureTimeOffset = errors.New("positive time offset queries data from the future")
+)
+
+// ParseTimeOffset parses the time offset of cron job start times (e.g. '-1h5m' queries data from 1 hour 5 minutes ago).
+// A positive offset is rejected unless allowFuture is true.
+func ParseTimeOffset(offset string, allowFuture bool) (time.Duration, error) {
+	d, err := time.ParseDuration(offset)
+	if err != nil {
+		return 0, fmt.Errorf("%w %q, expected %v: %v", ErrInvalidTimeOffset, offset, timeOffsetSyntax, err)
+	}
+	if d > 0 && !allowFuture {
+		return 0, fmt.Errorf("%w %q, use a negative offset to query past data (e.g. '-%v')", ErrFutureTimeOffset, offset, d)
+	}
+
+	return d, nil
+}
+
+// TimeOffsetExceedsRetention returns whether a job run querying the queryWindow before now+offset reaches data older
+// than the retention of the queried data source. It's false if retention is zero (unknown).
+func TimeOffsetExceedsRetention(offset, queryWindow, retention time.Duration) bool {
+	return retention > 0 && queryWindow-offset > retention
+}
@@ -0,0 +1,74 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package federator
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseTimeOffset(t *testing.T) {
+	tests := []struct {
+		name        string
+		offset      string
+		allowFuture bool
+		want        time.Duration
+		wantErr     error
+	}{
+		{name: "Zero", offset: "0s", want: 0},
+		{name: "Past", offset: "-10h30m", want: -10*time.Hour - 30*time.Minute},
+		{name: "Missing unit", offset: "-10h30", wantErr: ErrInvalidTimeOffset},
+		{name: "Empty", offset: "", wantErr: ErrInvalidTimeOffset},
+		{name: "Future", offset: "5m", wantErr: ErrFutureTimeOffset},
+		{name: "Allowed future", offset: "5m", allowFuture: true, want: 5 * time.Minute},
+	}
+	for _, testcase := range tests {
+		t.Run(testcase.name, func(t *testing.T) {
+			got, err := ParseTimeOffset(testcase.offset, testcase.allowFuture)
+			if !errors.Is(err, testcase.wantErr) {
+				t.Fatalf("ParseTimeOffset() error = %v, wantErr %v", err, testcase.wantErr)
+			}
+			if got != testcase.want {
+				t.Errorf("ParseTimeOffset() = %v, want %v", got, testcase.want)
+			}
+		})
+	}
+}
+
+func TestTimeOffsetExceedsRetention(t *testing.T) {
+	const retention = 15 * 24 * time.Hour
+
+	tests := []struct {
+		name        string
+		offset      time.Duration
+		queryWindow time.Duration
+		retention   time.Duration
+		want        bool
+	}{
+		{name: "No offset", offset: 0, queryWindow: 15 * time.Second, retention: retention, want: false},
+		{name: "Within retention", offset: -14 * 24 * time.Hour, queryWindow: time.Hour, retention: retention, want: false},
+		{name: "Query window reaches past retention", offset: -15 * 24 * time.Hour, queryWindow: time.Hour, retention: retention, want: true},
+		{name: "Offset past retention", offset: -30 * 24 * time.Hour, queryWindow: 0, retention: retention, want: true},
+		{name: "Unknown retention", offset: -30 * 24 * time.Hour, queryWindow: time.Hour, retention: 0, want: false},
+	}
+	for _, testcase := range tests {
+		t.Run(testcase.name, func(t *testing.T) {
+			if got := TimeOffsetExceedsRetention(testcase.offset, testcase.queryWindow, testcase.retention); got != testcase.want {
+				t.Errorf("TimeOffsetExceedsRetention() = %v, want %v", got, testcase.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package federator
+
+import (
+	"fmt"
+	"time"
+)
+
+// TimestampAlignment decides which point of a query window the data points are stamped with.
+type TimestampAlignment string
+
+// Timestamp alignments.
+const (
+	WindowEndAlignment      TimestampAlignment = "end"
+	WindowStartAlignment    TimestampAlignment = "start"
+	WindowMidpointAlignment TimestampAlignment = "midpoint"
+)
+
+// ParseTimestampAlignment parses a timestamp alignment, one of end, start, or midpoint.
+func ParseTimestampAlignment(alignment string) (TimestampAlignment, error) {
+	switch a := TimestampAlignment(alignment); a {
+	case WindowEndAlignment, WindowStartAlignment, WindowMidpointAlignment:
+		return a, nil
+	}
+
+	return "", fmt.Errorf("invalid timestamp alignment %q, expected one of %v, %v, or %v",
+		alignment, WindowEndAlignment, WindowStartAlignment, WindowMidpointAlignment)
+}
+
+// Align returns the timestamp of data points describing the [windowStart, windowEnd] query window.
+// Unknown alignments fall back to the window end.
+func (a TimestampAlignment) Align(windowStart, windowEnd time.Time) time.Time {
+	switch a {
+	case WindowStartAlignment:
+		return windowStart
+	case WindowMidpointAlignment:
+		return windowStart.Add(windowEnd.Sub(windowStart) / 2)
+	case WindowEndAlignment:
+	}
+
+	return windowEnd
+}
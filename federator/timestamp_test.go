@@ -0,0 +1,67 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package federator
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTimestampAlignment(t *testing.T) {
+	tests := []struct {
+		alignment string
+		want      TimestampAlignment
+		wantErr   bool
+	}{
+		{alignment: "end", want: WindowEndAlignment},
+		{alignment: "start", want: WindowStartAlignment},
+		{alignment: "midpoint", want: WindowMidpointAlignment},
+		{alignment: "now", wantErr: true},
+		{alignment: "", wantErr: true},
+	}
+	for _, testcase := range tests {
+		t.Run(testcase.alignment, func(t *testing.T) {
+			got, err := ParseTimestampAlignment(testcase.alignment)
+			if (err != nil) != testcase.wantErr {
+				t.Fatalf("ParseTimestampAlignment() error = %v, wantErr %v", err, testcase.wantErr)
+			}
+			if got != testcase.want {
+				t.Errorf("ParseTimestampAlignment() = %v, want %v", got, testcase.want)
+			}
+		})
+	}
+}
+
+func TestTimestampAlignment_Align(t *testing.T) {
+	windowEnd := time.Date(2021, 6, 1, 10, 0, 5, 0, time.UTC)
+	windowStart := windowEnd.Add(-15 * time.Second)
+
+	tests := []struct {
+		alignment TimestampAlignment
+		want      time.Time
+	}{
+		{alignment: WindowEndAlignment, want: windowEnd},
+		{alignment: WindowStartAlignment, want: windowStart},
+		{alignment: WindowMidpointAlignment, want: time.Date(2021, 6, 1, 9, 59, 57, 500000000, time.UTC)},
+		{alignment: "", want: windowEnd},
+	}
+	for _, testcase := range tests {
+		t.Run(string(testcase.alignment), func(t *testing.T) {
+			if got := testcase.alignment.Align(windowStart, windowEnd); !got.Equal(testcase.want) {
+				t.Errorf("TimestampAlignment.Align() = %v, want %v", got, testcase.want)
+			}
+		})
+	}
+}
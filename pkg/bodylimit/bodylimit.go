@@ -0,0 +1,76 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package bodylimit limits the size of HTTP response bodies read from upstreams (e.g. inventory and scrapes),
+// so a pathological upstream can't run the exporter out of memory.
+package bodylimit
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// DefaultMaxBytes is the default maximum response body size.
+const DefaultMaxBytes = 64 * 1024 * 1024
+
+// ErrTooLarge response body exceeds the maximum size.
+var ErrTooLarge = errors.New("response body exceeds the maximum size")
+
+// Reader reads up to maxBytes from a response body.
+// Reading more than maxBytes fails with ErrTooLarge instead of silently truncating the data.
+type Reader struct {
+	limited  io.Reader
+	maxBytes int64
+	read     int64
+	// exceeded once a read went past maxBytes, so every later read fails too
+	exceeded bool
+}
+
+// NewReader returns a Reader of r limited to maxBytes, or r itself when maxBytes isn't positive (unlimited).
+func NewReader(r io.Reader, maxBytes int64) io.Reader {
+	if maxBytes <= 0 {
+		return r
+	}
+
+	// One extra byte tells a body of exactly maxBytes apart from a larger one
+	return &Reader{
+		limited:  io.LimitReader(r, maxBytes+1),
+		maxBytes: maxBytes,
+		read:     0,
+		exceeded: false,
+	}
+}
+
+// Read implements io.Reader.
+func (r *Reader) Read(p []byte) (int, error) {
+	if r.exceeded {
+		return 0, fmt.Errorf("%w of %v bytes", ErrTooLarge, r.maxBytes)
+	}
+
+	n, err := r.limited.Read(p)
+	r.read += int64(n)
+	if r.read > r.maxBytes {
+		r.exceeded = true
+		// Only the bytes under the limit are returned, never a negative count
+		n -= int(r.read - r.maxBytes)
+		if n < 0 {
+			n = 0
+		}
+
+		return n, fmt.Errorf("%w of %v bytes", ErrTooLarge, r.maxBytes)
+	}
+
+	return n, err
+}
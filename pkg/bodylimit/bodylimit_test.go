@@ -0,0 +1,66 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bodylimit
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestNewReader(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		maxBytes int64
+		want     string
+		wantErr  bool
+	}{
+		{name: "Under the limit", data: "hello", maxBytes: 10, want: "hello", wantErr: false},
+		{name: "Exactly the limit", data: "hello", maxBytes: 5, want: "hello", wantErr: false},
+		{name: "Over the limit", data: "hello world", maxBytes: 5, want: "hello", wantErr: true},
+		{name: "Unlimited", data: "hello world", maxBytes: 0, want: "hello world", wantErr: false},
+	}
+	for _, testcase := range tests {
+		t.Run(testcase.name, func(t *testing.T) {
+			got, err := io.ReadAll(NewReader(strings.NewReader(testcase.data), testcase.maxBytes))
+			if (err != nil) != testcase.wantErr {
+				t.Fatalf("NewReader() error = %v, wantErr %v", err, testcase.wantErr)
+			}
+			if testcase.wantErr && !errors.Is(err, ErrTooLarge) {
+				t.Errorf("NewReader() error = %v, want %v", err, ErrTooLarge)
+			}
+			if string(got) != testcase.want {
+				t.Errorf("NewReader() = %q, want %q", got, testcase.want)
+			}
+		})
+	}
+}
+
+func TestReader_readPastLimit(t *testing.T) {
+	reader := NewReader(strings.NewReader("hello world"), 5)
+	buf := make([]byte, 8)
+
+	n, err := reader.Read(buf)
+	if n != 5 || !errors.Is(err, ErrTooLarge) || string(buf[:n]) != "hello" {
+		t.Fatalf("Reader.Read() = %v, %v (%q), want 5, %v (\"hello\")", n, err, buf[:n], ErrTooLarge)
+	}
+	for i := 0; i < 2; i++ {
+		if n, err := reader.Read(buf); n != 0 || !errors.Is(err, ErrTooLarge) {
+			t.Errorf("Reader.Read() past the limit = %v, %v, want 0, %v", n, err, ErrTooLarge)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package clock detects steps of the wall clock (e.g. an NTP correction), which durations measured with the
+// monotonic clock don't see, but timestamps and time windows do. It also tracks the drift of ticker ticks.
+package clock
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultStepThreshold is the default minimum wall clock step that's detected.
+const DefaultStepThreshold = time.Second
+
+// StepDetector detects wall clock steps between consecutive observations, by comparing the wall time elapsed
+// between them to the monotonic time elapsed. It's safe for concurrent use.
+type StepDetector struct {
+	threshold time.Duration
+
+	mu sync.Mutex
+	// previous observation, with its monotonic clock reading, zero before the first observation
+	previous time.Time
+	steps    atomic.Uint64
+}
+
+// NewStepDetector returns a StepDetector of wall clock steps larger than threshold.
+func NewStepDetector(threshold time.Duration) *StepDetector {
+	if threshold <= 0 {
+		threshold = DefaultStepThreshold
+	}
+
+	return &StepDetector{
+		threshold: threshold,
+		mu:        sync.Mutex{},
+		previous:  time.Time{},
+		steps:     atomic.Uint64{},
+	}
+}
+
+// Observe records now (from time.Now), and returns the wall clock step since the previous observation:
+// positive if the wall clock jumped forward, negative if it stepped backwards, and zero if it's within the threshold.
+func (d *StepDetector) Observe(now time.Time) time.Duration {
+	d.mu.Lock()
+	previous := d.previous
+	d.previous = now
+	d.mu.Unlock()
+
+	if previous.IsZero() {
+		return 0
+	}
+
+	// Sub uses the monotonic clock readings, Round(0) strips them to compare the wall clock readings
+	step := wallStep(now.Round(0).Sub(previous.Round(0)), now.Sub(previous), d.threshold)
+	if step != 0 {
+		d.steps.Add(1)
+	}
+
+	return step
+}
+
+// Steps returns the number of wall clock steps detected.
+func (d *StepDetector) Steps() uint64 {
+	return d.steps.Load()
+}
+
+// wallStep returns the difference between the wall and monotonic elapsed time, zero if it's within the threshold.
+func wallStep(wallElapsed, monotonicElapsed, threshold time.Duration) time.Duration {
+	step := wallElapsed - monotonicElapsed
+	if step > -threshold && step < threshold {
+		return 0
+	}
+
+	return step
+}
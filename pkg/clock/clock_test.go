@@ -0,0 +1,97 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_wallStep(t *testing.T) {
+	tests := []struct {
+		name             string
+		wallElapsed      time.Duration
+		monotonicElapsed time.Duration
+		want             time.Duration
+	}{
+		{name: "No step", wallElapsed: 7 * time.Second, monotonicElapsed: 7 * time.Second, want: 0},
+		{name: "Slew within the threshold", wallElapsed: 7*time.Second + 300*time.Millisecond, monotonicElapsed: 7 * time.Second, want: 0},
+		{name: "Backwards step", wallElapsed: -53 * time.Second, monotonicElapsed: 7 * time.Second, want: -time.Minute},
+		{name: "Forward step", wallElapsed: time.Hour + 7*time.Second, monotonicElapsed: 7 * time.Second, want: time.Hour},
+		{name: "Step of the threshold", wallElapsed: 8 * time.Second, monotonicElapsed: 7 * time.Second, want: time.Second},
+	}
+	for _, testcase := range tests {
+		t.Run(testcase.name, func(t *testing.T) {
+			if got := wallStep(testcase.wallElapsed, testcase.monotonicElapsed, time.Second); got != testcase.want {
+				t.Errorf("wallStep() = %v, want %v", got, testcase.want)
+			}
+		})
+	}
+}
+
+func TestStepDetector_Observe(t *testing.T) {
+	detector := NewStepDetector(time.Second)
+	if got := detector.Observe(time.Now()); got != 0 {
+		t.Errorf("StepDetector.Observe() first = %v, want 0", got)
+	}
+	if got := detector.Observe(time.Now()); got != 0 {
+		t.Errorf("StepDetector.Observe() = %v, want 0", got)
+	}
+	if got := detector.Steps(); got != 0 {
+		t.Errorf("StepDetector.Steps() = %v, want 0", got)
+	}
+}
+
+func Test_skippedTicks(t *testing.T) {
+	tests := []struct {
+		name    string
+		elapsed time.Duration
+		want    uint64
+	}{
+		{name: "Next tick", elapsed: 7 * time.Second, want: 0},
+		{name: "Next tick scheduled late", elapsed: 7*time.Second + 900*time.Millisecond, want: 0},
+		{name: "One skipped tick", elapsed: 14 * time.Second, want: 1},
+		{name: "Several skipped ticks", elapsed: 35*time.Second + 200*time.Millisecond, want: 4},
+		{name: "Same tick", elapsed: 0, want: 0},
+	}
+	for _, testcase := range tests {
+		t.Run(testcase.name, func(t *testing.T) {
+			if got := skippedTicks(testcase.elapsed, 7*time.Second); got != testcase.want {
+				t.Errorf("skippedTicks() = %v, want %v", got, testcase.want)
+			}
+		})
+	}
+}
+
+func TestTickDrift_Observe(t *testing.T) {
+	tickDrift := NewTickDrift(7 * time.Second)
+	start := time.Now()
+
+	drift, skipped := tickDrift.Observe(start, start)
+	if drift != 0 || skipped != 0 {
+		t.Errorf("TickDrift.Observe() first = %v, %v, want 0, 0", drift, skipped)
+	}
+	// A slow tick made the ticker drop the next two ticks
+	drift, skipped = tickDrift.Observe(start.Add(21*time.Second), start.Add(23*time.Second))
+	if drift != 2*time.Second || skipped != 2 {
+		t.Errorf("TickDrift.Observe() = %v, %v, want 2s, 2", drift, skipped)
+	}
+	if got := tickDrift.Drift(); got != 2*time.Second {
+		t.Errorf("TickDrift.Drift() = %v, want 2s", got)
+	}
+	if got := tickDrift.Skipped(); got != 2 {
+		t.Errorf("TickDrift.Skipped() = %v, want 2", got)
+	}
+}
@@ -0,0 +1,35 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clock
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Jitter returns a random duration in [0, fraction of d), to spread periodic work started at the same time (e.g. a
+// fleet of exporters started by the same orchestrator). It's zero if fraction isn't positive (or NaN).
+func Jitter(d time.Duration, fraction float64) time.Duration {
+	if math.IsNaN(fraction) {
+		return 0
+	}
+	max := time.Duration(float64(d) * fraction)
+	if max <= 0 {
+		return 0
+	}
+
+	return time.Duration(rand.Int63n(int64(max))) // nolint:gosec
+}
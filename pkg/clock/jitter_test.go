@@ -0,0 +1,51 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clock
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestJitter(t *testing.T) {
+	tests := []struct {
+		name     string
+		d        time.Duration
+		fraction float64
+		max      time.Duration
+	}{
+		{name: "Disabled", d: 7 * time.Second, fraction: 0, max: 0},
+		{name: "Negative fraction", d: 7 * time.Second, fraction: -0.1, max: 0},
+		{name: "NaN fraction", d: 7 * time.Second, fraction: math.NaN(), max: 0},
+		{name: "Tenth of the interval", d: 7 * time.Second, fraction: 0.1, max: 700 * time.Millisecond},
+		{name: "Whole interval", d: time.Minute, fraction: 1, max: time.Minute},
+	}
+	for _, testcase := range tests {
+		t.Run(testcase.name, func(t *testing.T) {
+			distinct := map[time.Duration]bool{}
+			for i := 0; i < 100; i++ {
+				got := Jitter(testcase.d, testcase.fraction)
+				if got < 0 || (got >= testcase.max && testcase.max > 0) || (testcase.max == 0 && got != 0) {
+					t.Fatalf("Jitter() = %v, want it in [0, %v)", got, testcase.max)
+				}
+				distinct[got] = true
+			}
+			if testcase.max > 0 && len(distinct) < 2 {
+				t.Errorf("Jitter() returned %v for every call, want random durations", distinct)
+			}
+		})
+	}
+}
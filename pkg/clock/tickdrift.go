@@ -0,0 +1,88 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clock
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// TickDrift tracks the drift between the scheduled and the actual start of a ticker's ticks, and the ticks skipped
+// since a time.Ticker drops the ticks of a slow receiver. It's safe for concurrent use.
+type TickDrift struct {
+	interval time.Duration
+
+	mu sync.Mutex
+	// previous scheduled tick, zero before the first observation
+	previous time.Time
+	drift    atomic.Int64
+	skipped  atomic.Uint64
+}
+
+// NewTickDrift returns a TickDrift of a ticker ticking every interval.
+func NewTickDrift(interval time.Duration) *TickDrift {
+	return &TickDrift{
+		interval: interval,
+		mu:       sync.Mutex{},
+		previous: time.Time{},
+		drift:    atomic.Int64{},
+		skipped:  atomic.Uint64{},
+	}
+}
+
+// Observe records a tick scheduled at scheduled (the time received from the ticker) that started at started,
+// and returns its drift and the ticks skipped since the previous observation.
+func (d *TickDrift) Observe(scheduled, started time.Time) (time.Duration, uint64) {
+	drift := started.Sub(scheduled)
+	if drift < 0 {
+		drift = 0
+	}
+	d.drift.Store(int64(drift))
+
+	d.mu.Lock()
+	previous := d.previous
+	d.previous = scheduled
+	d.mu.Unlock()
+
+	if previous.IsZero() || d.interval <= 0 {
+		return drift, 0
+	}
+	skipped := skippedTicks(scheduled.Sub(previous), d.interval)
+	d.skipped.Add(skipped)
+
+	return drift, skipped
+}
+
+// Drift returns the drift of the latest tick.
+func (d *TickDrift) Drift() time.Duration {
+	return time.Duration(d.drift.Load())
+}
+
+// Skipped returns the total ticks skipped.
+func (d *TickDrift) Skipped() uint64 {
+	return d.skipped.Load()
+}
+
+// skippedTicks returns the ticks skipped between two ticks elapsed apart, rounding elapsed to the interval
+// since ticks are scheduled slightly late.
+func skippedTicks(elapsed, interval time.Duration) uint64 {
+	ticks := (elapsed + interval/2) / interval
+	if ticks <= 1 {
+		return 0
+	}
+
+	return uint64(ticks - 1)
+}
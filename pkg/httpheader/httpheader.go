@@ -0,0 +1,113 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package httpheader configures custom headers of outgoing HTTP requests (e.g. gateway routing headers).
+package httpheader
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// redactedValue replaces header values that look like secrets in logs.
+const redactedValue = "<redacted>"
+
+// secretNameHints are substrings of header names whose values are treated as secrets.
+var secretNameHints = []string{"auth", "token", "secret", "password", "key", "cookie", "signature", "credential"}
+
+// Flag is a repeatable 'key=value' command-line flag that collects HTTP headers.
+type Flag http.Header
+
+// Set implements flag.Value.
+func (f Flag) Set(value string) error {
+	key, headerValue, ok := strings.Cut(value, "=")
+	key = strings.TrimSpace(key)
+	if !ok || key == "" {
+		return fmt.Errorf("error parsing HTTP header %q: expected 'key=value'", value)
+	}
+	http.Header(f).Add(key, strings.TrimSpace(headerValue))
+
+	return nil
+}
+
+// String implements flag.Value. Values that look like secrets are redacted.
+func (f Flag) String() string {
+	return Redacted(http.Header(f))
+}
+
+// Apply sets the headers on an outgoing request, replacing any existing values.
+func Apply(request *http.Request, headers http.Header) {
+	for key, values := range headers {
+		if http.CanonicalHeaderKey(key) == "Host" {
+			if len(values) > 0 {
+				request.Host = values[0]
+			}
+
+			continue
+		}
+		request.Header[http.CanonicalHeaderKey(key)] = values
+	}
+}
+
+// Transport is an http.RoundTripper that sets the Headers on every request (see Apply), and sends it with Next.
+type Transport struct {
+	Next    http.RoundTripper
+	Headers http.Header
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t Transport) RoundTrip(request *http.Request) (*http.Response, error) {
+	// A RoundTripper must not modify the caller's request
+	request = request.Clone(request.Context())
+	Apply(request, t.Headers)
+
+	return t.Next.RoundTrip(request) // nolint:wrapcheck
+}
+
+// Redacted formats headers for logs, sorted by key, with values that look like secrets redacted.
+func Redacted(headers http.Header) string {
+	keys := make([]string, 0, len(headers))
+	for key := range headers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := []string{}
+	for _, key := range keys {
+		for _, value := range headers[key] {
+			if isSecret(key, value) {
+				value = redactedValue
+			}
+			pairs = append(pairs, key+"="+value)
+		}
+	}
+
+	return strings.Join(pairs, ",")
+}
+
+// isSecret returns true if a header value looks like a secret, based on its name or an auth scheme prefix.
+func isSecret(key, value string) bool {
+	lowerKey := strings.ToLower(key)
+	for _, hint := range secretNameHints {
+		if strings.Contains(lowerKey, hint) {
+			return true
+		}
+	}
+
+	lowerValue := strings.ToLower(value)
+
+	return strings.HasPrefix(lowerValue, "bearer ") || strings.HasPrefix(lowerValue, "basic ")
+}
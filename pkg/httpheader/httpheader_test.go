@@ -0,0 +1,167 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpheader
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestFlag_Set(t *testing.T) {
+	tests := []struct {
+		name    string
+		values  []string
+		want    http.Header
+		wantErr bool
+	}{
+		{
+			name:   "Repeated flags",
+			values: []string{"X-Tenant-ID=tenant-a", "x-route = blue", "X-Tenant-ID=tenant-b"},
+			want: http.Header{
+				"X-Tenant-Id": {"tenant-a", "tenant-b"},
+				"X-Route":     {"blue"},
+			},
+			wantErr: false,
+		},
+		{
+			name:    "Value containing separator",
+			values:  []string{"X-Query=a=b"},
+			want:    http.Header{"X-Query": {"a=b"}},
+			wantErr: false,
+		},
+		{
+			name:    "Empty value",
+			values:  []string{"X-Empty="},
+			want:    http.Header{"X-Empty": {""}},
+			wantErr: false,
+		},
+		{
+			name:    "Missing separator",
+			values:  []string{"X-Tenant-ID"},
+			wantErr: true,
+		},
+		{
+			name:    "Empty key",
+			values:  []string{"=tenant-a"},
+			wantErr: true,
+		},
+	}
+	for _, testcase := range tests {
+		t.Run(testcase.name, func(t *testing.T) {
+			got := Flag{}
+			var err error
+			for _, value := range testcase.values {
+				if err = got.Set(value); err != nil {
+					break
+				}
+			}
+			if (err != nil) != testcase.wantErr {
+				t.Fatalf("Flag.Set() error = %v, wantErr %v", err, testcase.wantErr)
+			}
+			if testcase.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(http.Header(got), testcase.want) {
+				t.Errorf("Flag.Set() = %v, want %v", got, testcase.want)
+			}
+		})
+	}
+}
+
+func TestRedacted(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers http.Header
+		want    string
+	}{
+		{
+			name:    "Empty",
+			headers: http.Header{},
+			want:    "",
+		},
+		{
+			name: "Secrets by name and auth scheme",
+			headers: http.Header{
+				"X-Tenant-Id":   {"tenant-a"},
+				"X-Api-Key":     {"abc"},
+				"Authorization": {"Bearer abc"},
+				"X-Forward":     {"Basic abc", "plain"},
+			},
+			want: "Authorization=<redacted>,X-Api-Key=<redacted>,X-Forward=<redacted>,X-Forward=plain,X-Tenant-Id=tenant-a",
+		},
+	}
+	for _, testcase := range tests {
+		t.Run(testcase.name, func(t *testing.T) {
+			if got := Redacted(testcase.headers); got != testcase.want {
+				t.Errorf("Redacted() = %v, want %v", got, testcase.want)
+			}
+		})
+	}
+}
+
+func TestApply(t *testing.T) {
+	request, err := http.NewRequest(http.MethodGet, "http://localhost/metrics", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest() error = %v", err)
+	}
+	request.Header.Set("X-Tenant-ID", "default")
+
+	Apply(request, http.Header{
+		"X-Tenant-Id": {"tenant-a"},
+		"Host":        {"metrics.example"},
+	})
+
+	if got, want := request.Header.Get("X-Tenant-ID"), "tenant-a"; got != want {
+		t.Errorf("Apply() X-Tenant-ID = %v, want %v", got, want)
+	}
+	if got, want := request.Host, "metrics.example"; got != want {
+		t.Errorf("Apply() Host = %v, want %v", got, want)
+	}
+	if got := request.Header.Get("Host"); got != "" {
+		t.Errorf("Apply() Host header = %v, want empty", got)
+	}
+}
+
+func TestTransport_RoundTrip(t *testing.T) {
+	var gotHeaders http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotHeaders = r.Header
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: Transport{ // nolint:exhaustivestruct
+		Next:    http.DefaultTransport,
+		Headers: http.Header{"X-Tenant-Id": {"tenant-a"}},
+	}}
+	request, err := http.NewRequest(http.MethodGet, server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.Header.Set("X-Request-Id", "1")
+	response, err := client.Do(request)
+	if err != nil {
+		t.Fatalf("Transport.RoundTrip() error = %v", err)
+	}
+	response.Body.Close()
+
+	if gotHeaders.Get("X-Tenant-Id") != "tenant-a" || gotHeaders.Get("X-Request-Id") != "1" {
+		t.Errorf("Transport.RoundTrip() headers = %v, want X-Tenant-Id and X-Request-Id", gotHeaders)
+	}
+	if request.Header.Get("X-Tenant-Id") != "" {
+		t.Errorf("Transport.RoundTrip() modified the request headers: %v", request.Header)
+	}
+}
@@ -0,0 +1,51 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package httpproxy configures the proxy of outgoing HTTP requests.
+package httpproxy
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// Direct is the proxy URL value that disables the proxy, even when proxy environment variables are set.
+const Direct = "direct"
+
+// ErrInvalidProxyURL proxy URL has no scheme or host.
+var ErrInvalidProxyURL = errors.New("invalid proxy URL, expected e.g. 'http://proxy.internal:3128'")
+
+// Func returns the Proxy of an http.Transport for proxyURL.
+// An empty proxyURL uses the HTTP_PROXY, HTTPS_PROXY, and NO_PROXY environment variables,
+// Direct doesn't use any proxy, and any other value sends every request through that proxy.
+func Func(proxyURL string) (func(*http.Request) (*url.URL, error), error) {
+	switch proxyURL {
+	case "":
+		return http.ProxyFromEnvironment, nil
+	case Direct:
+		return nil, nil
+	}
+
+	parsedURL, err := url.Parse(proxyURL)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing proxy URL: %w", err)
+	}
+	if parsedURL.Scheme == "" || parsedURL.Host == "" {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidProxyURL, proxyURL)
+	}
+
+	return http.ProxyURL(parsedURL), nil
+}
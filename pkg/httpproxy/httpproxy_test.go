@@ -0,0 +1,66 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpproxy
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestFunc(t *testing.T) {
+	request, err := http.NewRequest(http.MethodGet, "http://darkstat.internal:51666/metrics", nil) // nolint:noctx
+	if err != nil {
+		t.Fatalf("http.NewRequest() error = %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		proxyURL  string
+		wantNil   bool
+		wantProxy string
+		wantErr   error
+	}{
+		{name: "Direct", proxyURL: Direct, wantNil: true, wantProxy: "", wantErr: nil},
+		{name: "Explicit proxy", proxyURL: "http://proxy.internal:3128", wantNil: false, wantProxy: "http://proxy.internal:3128", wantErr: nil},
+		{name: "Missing scheme", proxyURL: "proxy.internal:3128", wantNil: true, wantProxy: "", wantErr: ErrInvalidProxyURL},
+	}
+	for _, testcase := range tests {
+		t.Run(testcase.name, func(t *testing.T) {
+			proxy, err := Func(testcase.proxyURL)
+			if !errors.Is(err, testcase.wantErr) {
+				t.Fatalf("Func() error = %v, want %v", err, testcase.wantErr)
+			}
+			if (proxy == nil) != testcase.wantNil {
+				t.Fatalf("Func() = nil %v, want nil %v", proxy == nil, testcase.wantNil)
+			}
+			if proxy == nil {
+				return
+			}
+			got, err := proxy(request)
+			if err != nil {
+				t.Fatalf("Func() proxy error = %v", err)
+			}
+			if got.String() != testcase.wantProxy {
+				t.Errorf("Func() proxy = %v, want %v", got, testcase.wantProxy)
+			}
+		})
+	}
+
+	// The environment variables are read once per process, so only check that the environment is used
+	if proxy, err := Func(""); err != nil || proxy == nil {
+		t.Errorf("Func() error = %v, want the environment proxy", err)
+	}
+}
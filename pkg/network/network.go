@@ -16,8 +16,10 @@ package network
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
+	"strings"
 	"syscall"
 
 	"planet-exporter/pkg/process"
@@ -34,28 +36,54 @@ type PeeredConnSocket struct {
 	RemoteIP    string
 	Protocol    string
 	ProcessName string
+	State       string // SocketStateEstablished or SocketStateTimeWait
 }
 
+// States of peered connection sockets.
+const (
+	SocketStateEstablished = "ESTABLISHED"
+	// SocketStateTimeWait sockets linger after their connection is closed, without a process
+	SocketStateTimeWait = "TIME_WAIT"
+)
+
 // ListeningConnSocket represents a connection socket from a listening server process (sockets in LISTEN state).
 type ListeningConnSocket struct {
 	ProcessPid  int32
 	LocalPort   uint32
 	LocalIP     string
 	ProcessName string
+	// ProcessStartTime tells apart server processes that reused the same ProcessPid
+	ProcessStartTime uint64
+}
+
+// UnixListeningSocket represents a Unix domain socket bound to a path by a process.
+type UnixListeningSocket struct {
+	Path        string // e.g. "/run/docker.sock", or "@name" for an abstract socket
+	ProcessName string
 }
 
 // ServerConnectionStat represents a connection status, similar to netstat or "ss -pant" and "ss -pantl".
+// PeeredConnSockets and ListeningConnSockets are limited to AF_INET and AF_INET6 sockets.
 type ServerConnectionStat struct {
 	PeeredConnSockets    []PeeredConnSocket
 	ListeningConnSockets []ListeningConnSocket
+	UnixListeningSockets []UnixListeningSocket
+}
+
+// isContextDone returns whether err is from a cancelled context or an exceeded context deadline.
+func isContextDone(err error) bool {
+	return errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled)
 }
 
 // ServerConnections returns LISTENING ports and peer connection tuples that are in ESTABLISHED or TIME_WAIT state
 // Limited to 4096 connections per running process.
+// When ctx is done before the process table is read, it still returns every connection, without the process name
+// of the processes that weren't read, along with an error wrapping ctx.Err().
 func ServerConnections(ctx context.Context) (ServerConnectionStat, error) {
-	processTable, err := process.GetProcessTable(ctx)
-	if err != nil {
-		return ServerConnectionStat{}, fmt.Errorf("error getting server process table: %w", err)
+	// Connections of the processes that weren't read before ctx is done have no process name
+	processes, processesErr := process.GetProcesses(ctx)
+	if processesErr != nil && !isContextDone(processesErr) {
+		return ServerConnectionStat{}, fmt.Errorf("error getting server process table: %w", processesErr)
 	}
 
 	// "01": "ESTABLISHED",
@@ -66,12 +94,42 @@ func ServerConnections(ctx context.Context) (ServerConnectionStat, error) {
 		return ServerConnectionStat{}, fmt.Errorf("error getting server connections: %w", err)
 	}
 
+	stat := parseServerConnections(allConns, processes)
+	if processesErr != nil {
+		return stat, fmt.Errorf("error getting server process table: %w", processesErr)
+	}
+
+	return stat, nil
+}
+
+// parseServerConnections returns the listening and peered AF_INET/AF_INET6 sockets of the connections, and their
+// Unix sockets bound to a path, along with the name of their process.
+// psutil's "all" connection kind includes Unix sockets, which have no IP address and port.
+func parseServerConnections(conns []psutilnet.ConnectionStat, processes map[int]process.Process) ServerConnectionStat {
 	// Listening connection sockets
 	listeningConns := []ListeningConnSocket{}
 	// Peered connection tuples
 	peeredConns := []PeeredConnSocket{}
 
-	for _, conn := range allConns {
+	// Unix listening sockets, deduplicated as accepted connections share the path of their listening socket
+	unixListeningConns := []UnixListeningSocket{}
+	seenUnixListeningConns := make(map[UnixListeningSocket]bool)
+
+	for _, conn := range conns {
+		if conn.Family == syscall.AF_UNIX {
+			// psutil puts the path in the IP address, client sockets aren't bound to a path
+			unixListeningConn := UnixListeningSocket{Path: conn.Laddr.IP, ProcessName: processes[int(conn.Pid)].Name}
+			if unixListeningConn.Path != "" && !seenUnixListeningConns[unixListeningConn] {
+				seenUnixListeningConns[unixListeningConn] = true
+				unixListeningConns = append(unixListeningConns, unixListeningConn)
+			}
+
+			continue
+		}
+		if conn.Family != syscall.AF_INET && conn.Family != syscall.AF_INET6 {
+			continue
+		}
+
 		var proto string
 		switch conn.Type {
 		case syscall.SOCK_STREAM:
@@ -85,20 +143,22 @@ func ServerConnections(ctx context.Context) (ServerConnectionStat, error) {
 		switch conn.Status {
 		case "LISTEN":
 			listeningConns = append(listeningConns, ListeningConnSocket{
-				LocalIP:     conn.Laddr.IP,
-				LocalPort:   conn.Laddr.Port,
-				ProcessName: processTable[int(conn.Pid)],
-				ProcessPid:  conn.Pid,
+				LocalIP:          conn.Laddr.IP,
+				LocalPort:        conn.Laddr.Port,
+				ProcessName:      processes[int(conn.Pid)].Name,
+				ProcessPid:       conn.Pid,
+				ProcessStartTime: processes[int(conn.Pid)].StartTime,
 			})
 
-		case "TIME_WAIT", "ESTABLISHED":
+		case SocketStateTimeWait, SocketStateEstablished:
 			peeredConns = append(peeredConns, PeeredConnSocket{
 				LocalIP:     conn.Laddr.IP,
 				LocalPort:   conn.Laddr.Port,
 				RemoteIP:    conn.Raddr.IP,
 				RemotePort:  conn.Raddr.Port,
 				Protocol:    proto,
-				ProcessName: processTable[int(conn.Pid)],
+				ProcessName: processes[int(conn.Pid)].Name,
+				State:       conn.Status,
 			})
 		}
 	}
@@ -106,15 +166,105 @@ func ServerConnections(ctx context.Context) (ServerConnectionStat, error) {
 	return ServerConnectionStat{
 		PeeredConnSockets:    peeredConns,
 		ListeningConnSockets: listeningConns,
-	}, nil
+		UnixListeningSockets: unixListeningConns,
+	}
+}
+
+// NormalizeIP collapses an IPv4-mapped IPv6 address (e.g. "::ffff:10.1.2.3") into its IPv4 form.
+// Any other address is returned as is.
+func NormalizeIP(address string) string {
+	if !strings.Contains(address, ":") {
+		return address
+	}
+	ip := net.ParseIP(address)
+	if ip == nil || ip.To4() == nil {
+		return address
+	}
+
+	return ip.To4().String()
 }
 
 // ErrLocalIPNotFound failed to retrieve local IP address.
 var ErrLocalIPNotFound = fmt.Errorf("failed to retrieve local IP address")
 
-// LocalIP returns default local IP address
-// Note the "udp" protocol. The net.Dial() call won't actually establish any connection.
+// Local IP address sources, replaced in tests.
+var (
+	defaultRouteIP       = defaultRouteLocalIP
+	interfaceAddrs       = net.InterfaceAddrs
+	interfaceAddrsByName = func(name string) ([]net.Addr, error) {
+		iface, err := net.InterfaceByName(name)
+		if err != nil {
+			return nil, err
+		}
+
+		return iface.Addrs()
+	}
+)
+
+// LocalIP returns default local IP address.
+// On hosts without a default route (e.g. isolated management networks), it falls back
+// to the first global unicast address of the network interfaces.
 func LocalIP() (net.IP, error) {
+	ip, err := defaultRouteIP()
+	if err == nil {
+		return ip, nil
+	}
+
+	ip, ifaceErr := firstGlobalUnicastIP()
+	if ifaceErr != nil {
+		return nil, fmt.Errorf("%w (interface addresses: %v)", err, ifaceErr)
+	}
+	log.Debugf("Using interface address %v as local IP address: %v", ip, err)
+
+	return ip, nil
+}
+
+// LocalIPs returns the local IP addresses: LocalIP first, followed by the other global unicast addresses of the
+// network interfaces (e.g. VLAN sub-interfaces of a bond, or bridges), IPv4 first.
+func LocalIPs() ([]net.IP, error) {
+	localIP, err := LocalIP()
+	if err != nil {
+		return nil, err
+	}
+
+	ips := []net.IP{localIP}
+	addrs, err := interfaceAddrs()
+	if err != nil {
+		log.Debugf("Using local IP address %v only: error getting interface addresses: %v", localIP, err)
+
+		return ips, nil
+	}
+
+	var ipv6s []net.IP
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || !ipNet.IP.IsGlobalUnicast() || hasIP(ips, ipNet.IP) || hasIP(ipv6s, ipNet.IP) {
+			continue
+		}
+		if ipNet.IP.To4() != nil {
+			ips = append(ips, ipNet.IP.To4())
+		} else {
+			ipv6s = append(ipv6s, ipNet.IP)
+		}
+	}
+
+	return append(ips, ipv6s...), nil
+}
+
+// hasIP returns whether ip is one of ips.
+func hasIP(ips []net.IP, ip net.IP) bool {
+	for _, i := range ips {
+		if i.Equal(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// defaultRouteLocalIP returns the local IP address of the default route.
+// Note the "udp" protocol. The net.Dial() call won't actually establish any connection.
+func defaultRouteLocalIP() (net.IP, error) {
 	conn, err := net.Dial("udp", "8.8.8.8:53")
 	if err != nil {
 		return nil, fmt.Errorf("error creating UDP dial connection: %w", err)
@@ -132,3 +282,79 @@ func LocalIP() (net.IP, error) {
 
 	return localAddr.IP, nil
 }
+
+// firstGlobalUnicastIP returns the first global unicast address of the network interfaces, preferring IPv4.
+func firstGlobalUnicastIP() (net.IP, error) {
+	addrs, err := interfaceAddrs()
+	if err != nil {
+		return nil, fmt.Errorf("error getting interface addresses: %w", err)
+	}
+
+	return firstGlobalUnicast(addrs)
+}
+
+// InterfaceIP returns the first global unicast address of the named network interface (e.g. "eth1"), preferring IPv4.
+func InterfaceIP(name string) (net.IP, error) {
+	addrs, err := interfaceAddrsByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("error getting addresses of interface %v: %w", name, err)
+	}
+
+	return firstGlobalUnicast(addrs)
+}
+
+// firstGlobalUnicast returns the first global unicast address of addrs, preferring IPv4.
+func firstGlobalUnicast(addrs []net.Addr) (net.IP, error) {
+	var firstIPv6 net.IP
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || !ipNet.IP.IsGlobalUnicast() {
+			continue
+		}
+		if ipNet.IP.To4() != nil {
+			return ipNet.IP.To4(), nil
+		}
+		if firstIPv6 == nil {
+			firstIPv6 = ipNet.IP
+		}
+	}
+	if firstIPv6 != nil {
+		return firstIPv6, nil
+	}
+
+	return nil, ErrLocalIPNotFound
+}
+
+// ParseCIDRs parses comma-separated networks in CIDR notation (e.g. "10.0.0.0/8,fd00::/8"), empty entries are ignored.
+func ParseCIDRs(s string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, cidr := range strings.Split(s, ",") {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing CIDR: %w", err)
+		}
+		networks = append(networks, ipNet)
+	}
+
+	return networks, nil
+}
+
+// ContainsIP returns true if any of the networks contains the IP address.
+// An IPv4-mapped IPv6 address matches IPv4 networks.
+func ContainsIP(networks []*net.IPNet, address string) bool {
+	ip := net.ParseIP(NormalizeIP(address))
+	if ip == nil {
+		return false
+	}
+	for _, ipNet := range networks {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
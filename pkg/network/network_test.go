@@ -0,0 +1,299 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package network
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"syscall"
+	"testing"
+
+	"planet-exporter/pkg/process"
+
+	psutilnet "github.com/shirou/gopsutil/net"
+)
+
+func TestNormalizeIP(t *testing.T) {
+	tests := []struct {
+		name    string
+		address string
+		want    string
+	}{
+		{name: "IPv4-mapped IPv6", address: "::ffff:10.1.2.3", want: "10.1.2.3"},
+		{name: "IPv4-mapped IPv6 in hex", address: "::ffff:a01:203", want: "10.1.2.3"},
+		{name: "IPv4", address: "10.1.2.3", want: "10.1.2.3"},
+		{name: "IPv6", address: "2001:db8::1", want: "2001:db8::1"},
+		{name: "Not an IP", address: "localhost", want: "localhost"},
+	}
+	for _, testcase := range tests {
+		t.Run(testcase.name, func(t *testing.T) {
+			if got := NormalizeIP(testcase.address); got != testcase.want {
+				t.Errorf("NormalizeIP() = %v, want %v", got, testcase.want)
+			}
+		})
+	}
+}
+
+var errNoDefaultRoute = errors.New("network is unreachable")
+
+func TestLocalIP(t *testing.T) {
+	defer func(defaultRoute func() (net.IP, error), addrs func() ([]net.Addr, error)) {
+		defaultRouteIP, interfaceAddrs = defaultRoute, addrs
+	}(defaultRouteIP, interfaceAddrs)
+
+	ipNet := func(cidr string) net.Addr {
+		ip, ipNet, _ := net.ParseCIDR(cidr)
+		ipNet.IP = ip
+
+		return ipNet
+	}
+
+	tests := []struct {
+		name           string
+		defaultRouteIP func() (net.IP, error)
+		interfaceAddrs []net.Addr
+		want           string
+		wantErr        bool
+	}{
+		{
+			name:           "Default route",
+			defaultRouteIP: func() (net.IP, error) { return net.ParseIP("10.0.0.1"), nil },
+			interfaceAddrs: []net.Addr{ipNet("10.0.0.2/24")},
+			want:           "10.0.0.1",
+			wantErr:        false,
+		},
+		{
+			name:           "No default route prefers the first IPv4 global unicast address",
+			defaultRouteIP: func() (net.IP, error) { return nil, errNoDefaultRoute },
+			interfaceAddrs: []net.Addr{ipNet("127.0.0.1/8"), ipNet("fe80::1/64"), ipNet("2001:db8::1/64"), ipNet("10.0.0.2/24"), ipNet("10.0.0.3/24")},
+			want:           "10.0.0.2",
+			wantErr:        false,
+		},
+		{
+			name:           "No default route with IPv6 only",
+			defaultRouteIP: func() (net.IP, error) { return nil, errNoDefaultRoute },
+			interfaceAddrs: []net.Addr{ipNet("::1/128"), ipNet("2001:db8::1/64")},
+			want:           "2001:db8::1",
+			wantErr:        false,
+		},
+		{
+			name:           "No default route and no global unicast address",
+			defaultRouteIP: func() (net.IP, error) { return nil, errNoDefaultRoute },
+			interfaceAddrs: []net.Addr{ipNet("127.0.0.1/8")},
+			want:           "<nil>",
+			wantErr:        true,
+		},
+	}
+	for _, testcase := range tests {
+		t.Run(testcase.name, func(t *testing.T) {
+			addrs := testcase.interfaceAddrs
+			defaultRouteIP = testcase.defaultRouteIP
+			interfaceAddrs = func() ([]net.Addr, error) { return addrs, nil }
+
+			got, err := LocalIP()
+			if (err != nil) != testcase.wantErr {
+				t.Fatalf("LocalIP() error = %v, wantErr %v", err, testcase.wantErr)
+			}
+			if testcase.wantErr && !errors.Is(err, errNoDefaultRoute) {
+				t.Errorf("LocalIP() error = %v, want %v", err, errNoDefaultRoute)
+			}
+			if got.String() != testcase.want {
+				t.Errorf("LocalIP() = %v, want %v", got, testcase.want)
+			}
+		})
+	}
+}
+
+func TestLocalIPs(t *testing.T) {
+	defer func(defaultRoute func() (net.IP, error), addrs func() ([]net.Addr, error)) {
+		defaultRouteIP, interfaceAddrs = defaultRoute, addrs
+	}(defaultRouteIP, interfaceAddrs)
+
+	ipNet := func(cidr string) net.Addr {
+		ip, ipNet, _ := net.ParseCIDR(cidr)
+		ipNet.IP = ip
+
+		return ipNet
+	}
+	errInterfaces := errors.New("interfaces unavailable")
+
+	tests := []struct {
+		name           string
+		defaultRouteIP func() (net.IP, error)
+		interfaceAddrs []net.Addr
+		interfaceErr   error
+		want           []string
+		wantErr        bool
+	}{
+		{
+			name:           "Default route first, then the other interface addresses with IPv4 first",
+			defaultRouteIP: func() (net.IP, error) { return net.ParseIP("10.0.0.1"), nil },
+			interfaceAddrs: []net.Addr{
+				ipNet("127.0.0.1/8"), ipNet("2001:db8::1/64"), ipNet("10.0.0.1/24"), ipNet("fe80::1/64"), ipNet("10.20.0.5/24"),
+			},
+			want: []string{"10.0.0.1", "10.20.0.5", "2001:db8::1"},
+		},
+		{
+			name:           "No default route",
+			defaultRouteIP: func() (net.IP, error) { return nil, errNoDefaultRoute },
+			interfaceAddrs: []net.Addr{ipNet("10.0.0.2/24"), ipNet("10.20.0.5/24")},
+			want:           []string{"10.0.0.2", "10.20.0.5"},
+		},
+		{
+			name:           "Default route only when interface addresses are unavailable",
+			defaultRouteIP: func() (net.IP, error) { return net.ParseIP("10.0.0.1"), nil },
+			interfaceErr:   errInterfaces,
+			want:           []string{"10.0.0.1"},
+		},
+		{
+			name:           "No local IP address",
+			defaultRouteIP: func() (net.IP, error) { return nil, errNoDefaultRoute },
+			interfaceAddrs: []net.Addr{ipNet("127.0.0.1/8")},
+			wantErr:        true,
+		},
+	}
+	for _, testcase := range tests {
+		t.Run(testcase.name, func(t *testing.T) {
+			addrs, addrsErr := testcase.interfaceAddrs, testcase.interfaceErr
+			defaultRouteIP = testcase.defaultRouteIP
+			interfaceAddrs = func() ([]net.Addr, error) { return addrs, addrsErr }
+
+			ips, err := LocalIPs()
+			if (err != nil) != testcase.wantErr {
+				t.Fatalf("LocalIPs() error = %v, wantErr %v", err, testcase.wantErr)
+			}
+			got := []string{}
+			for _, ip := range ips {
+				got = append(got, ip.String())
+			}
+			if !testcase.wantErr && !reflect.DeepEqual(got, testcase.want) {
+				t.Errorf("LocalIPs() = %v, want %v", got, testcase.want)
+			}
+		})
+	}
+}
+
+func TestInterfaceIP(t *testing.T) {
+	defer func(addrsByName func(string) ([]net.Addr, error)) {
+		interfaceAddrsByName = addrsByName
+	}(interfaceAddrsByName)
+
+	ipNet := func(cidr string) net.Addr {
+		ip, ipNet, _ := net.ParseCIDR(cidr)
+		ipNet.IP = ip
+
+		return ipNet
+	}
+	interfaceAddrsByName = func(name string) ([]net.Addr, error) {
+		switch name {
+		case "eth1":
+			return []net.Addr{ipNet("fe80::1/64"), ipNet("2001:db8::5/64"), ipNet("10.20.0.5/24")}, nil
+		case "lo":
+			return []net.Addr{ipNet("127.0.0.1/8")}, nil
+		}
+
+		return nil, errors.New("no such network interface")
+	}
+
+	tests := []struct {
+		name    string
+		iface   string
+		want    string
+		wantErr bool
+	}{
+		{name: "First IPv4 global unicast address", iface: "eth1", want: "10.20.0.5", wantErr: false},
+		{name: "No global unicast address", iface: "lo", want: "<nil>", wantErr: true},
+		{name: "Unknown interface", iface: "eth9", want: "<nil>", wantErr: true},
+	}
+	for _, testcase := range tests {
+		t.Run(testcase.name, func(t *testing.T) {
+			got, err := InterfaceIP(testcase.iface)
+			if (err != nil) != testcase.wantErr {
+				t.Fatalf("InterfaceIP() error = %v, wantErr %v", err, testcase.wantErr)
+			}
+			if got.String() != testcase.want {
+				t.Errorf("InterfaceIP() = %v, want %v", got, testcase.want)
+			}
+		})
+	}
+}
+
+func TestContainsIP(t *testing.T) {
+	networks, err := ParseCIDRs(" 10.0.0.0/8, ,fd00::/8")
+	if err != nil {
+		t.Fatalf("ParseCIDRs() error = %v", err)
+	}
+	if _, err := ParseCIDRs("10.0.0.0"); err == nil {
+		t.Errorf("ParseCIDRs() error = nil, want error on an address without prefix length")
+	}
+
+	tests := []struct {
+		name    string
+		address string
+		want    bool
+	}{
+		{name: "IPv4 inside", address: "10.1.2.3", want: true},
+		{name: "IPv4-mapped IPv6 inside", address: "::ffff:10.1.2.3", want: true},
+		{name: "IPv4 outside", address: "8.8.8.8", want: false},
+		{name: "IPv6 inside", address: "fd00::1", want: true},
+		{name: "Not an IP", address: "localhost", want: false},
+	}
+	for _, testcase := range tests {
+		t.Run(testcase.name, func(t *testing.T) {
+			if got := ContainsIP(networks, testcase.address); got != testcase.want {
+				t.Errorf("ContainsIP() = %v, want %v", got, testcase.want)
+			}
+		})
+	}
+}
+
+func Test_parseServerConnections(t *testing.T) {
+	processes := map[int]process.Process{
+		1: {Pid: 1, Name: "nginx", StartTime: 10},
+		2: {Pid: 2, Name: "dockerd", StartTime: 20},
+	}
+	conns := []psutilnet.ConnectionStat{
+		{Family: syscall.AF_INET, Type: syscall.SOCK_STREAM, Laddr: psutilnet.Addr{IP: "0.0.0.0", Port: 80}, Status: "LISTEN", Pid: 1},
+		{Family: syscall.AF_INET6, Type: syscall.SOCK_STREAM, Laddr: psutilnet.Addr{IP: "::", Port: 443}, Status: "LISTEN", Pid: 1},
+		{
+			Family: syscall.AF_INET, Type: syscall.SOCK_STREAM, Status: "ESTABLISHED", Pid: 1,
+			Laddr: psutilnet.Addr{IP: "10.0.0.1", Port: 80}, Raddr: psutilnet.Addr{IP: "10.0.0.2", Port: 41234},
+		},
+		// Unix sockets, including the ones that look like IP sockets
+		{Family: syscall.AF_UNIX, Type: syscall.SOCK_STREAM, Laddr: psutilnet.Addr{IP: "/run/docker.sock"}, Status: "LISTEN", Pid: 2},
+		{Family: syscall.AF_UNIX, Type: syscall.SOCK_STREAM, Laddr: psutilnet.Addr{IP: "/run/docker.sock"}, Status: "NONE", Pid: 2},
+		{Family: syscall.AF_UNIX, Type: syscall.SOCK_STREAM, Laddr: psutilnet.Addr{IP: "@/containerd-shim"}, Status: "NONE", Pid: 2},
+		{Family: syscall.AF_UNIX, Type: syscall.SOCK_STREAM, Laddr: psutilnet.Addr{IP: ""}, Status: "ESTABLISHED", Pid: 1},
+		{Family: syscall.AF_UNIX, Type: syscall.SOCK_DGRAM, Laddr: psutilnet.Addr{IP: ""}, Status: "NONE", Pid: 1},
+	}
+
+	want := ServerConnectionStat{
+		PeeredConnSockets: []PeeredConnSocket{
+			{LocalIP: "10.0.0.1", LocalPort: 80, RemoteIP: "10.0.0.2", RemotePort: 41234, Protocol: "tcp", ProcessName: "nginx", State: SocketStateEstablished},
+		},
+		ListeningConnSockets: []ListeningConnSocket{
+			{ProcessPid: 1, LocalPort: 80, LocalIP: "0.0.0.0", ProcessName: "nginx", ProcessStartTime: 10},
+			{ProcessPid: 1, LocalPort: 443, LocalIP: "::", ProcessName: "nginx", ProcessStartTime: 10},
+		},
+		UnixListeningSockets: []UnixListeningSocket{
+			{Path: "/run/docker.sock", ProcessName: "dockerd"},
+			{Path: "@/containerd-shim", ProcessName: "dockerd"},
+		},
+	}
+	if got := parseServerConnections(conns, processes); !reflect.DeepEqual(got, want) {
+		t.Errorf("parseServerConnections() = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package network
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// procNetRoot is where the kernel socket tables are read from, replaced in tests.
+var procNetRoot = "/proc/net"
+
+// Socket states in the kernel socket tables.
+const (
+	procNetStateEstablished = "01"
+	procNetStateTimeWait    = "06"
+)
+
+// procNetTables are the kernel socket tables and their protocol.
+var procNetTables = []struct {
+	file     string
+	protocol string
+}{
+	{file: "tcp", protocol: "tcp"},
+	{file: "tcp6", protocol: "tcp"},
+	{file: "udp", protocol: "udp"},
+	{file: "udp6", protocol: "udp"},
+}
+
+// ErrInvalidProcNetAddress address in a kernel socket table can't be parsed.
+var ErrInvalidProcNetAddress = errors.New("invalid socket table address")
+
+// PeeredConnections returns peer connection tuples that are in ESTABLISHED or TIME_WAIT state, without their process name.
+// Unlike ServerConnections, it only reads the kernel socket tables and doesn't walk every process' file descriptors,
+// so it's cheap enough to be polled frequently.
+func PeeredConnections(ctx context.Context) ([]PeeredConnSocket, error) {
+	peeredConns := []PeeredConnSocket{}
+	for _, table := range procNetTables {
+		if err := ctx.Err(); err != nil {
+			return nil, fmt.Errorf("error reading socket tables: %w", err)
+		}
+
+		f, err := os.Open(filepath.Join(procNetRoot, table.file))
+		if errors.Is(err, os.ErrNotExist) {
+			// e.g. IPv6 is disabled
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error opening socket table: %w", err)
+		}
+		conns, err := parseProcNet(f, table.protocol)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("error parsing socket table %v: %w", table.file, err)
+		}
+		peeredConns = append(peeredConns, conns...)
+	}
+
+	return peeredConns, nil
+}
+
+// parseProcNet parses peered connections from a kernel socket table (e.g. /proc/net/tcp).
+func parseProcNet(r io.Reader, protocol string) ([]PeeredConnSocket, error) {
+	var peeredConns []PeeredConnSocket
+
+	scanner := bufio.NewScanner(r)
+	// Skip the header
+	scanner.Scan()
+	for scanner.Scan() {
+		// e.g. "0: 0100007F:BC8F 0200000A:0050 01 ..."
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 {
+			continue
+		}
+		var state string
+		switch fields[3] {
+		case procNetStateEstablished:
+			state = SocketStateEstablished
+		case procNetStateTimeWait:
+			state = SocketStateTimeWait
+		default:
+			continue
+		}
+
+		localIP, localPort, err := parseProcNetAddress(fields[1])
+		if err != nil {
+			return nil, err
+		}
+		remoteIP, remotePort, err := parseProcNetAddress(fields[2])
+		if err != nil {
+			return nil, err
+		}
+		peeredConns = append(peeredConns, PeeredConnSocket{
+			LocalIP:     localIP,
+			LocalPort:   localPort,
+			RemoteIP:    remoteIP,
+			RemotePort:  remotePort,
+			Protocol:    protocol,
+			ProcessName: "",
+			State:       state,
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading socket table: %w", err)
+	}
+
+	return peeredConns, nil
+}
+
+// parseProcNetAddress parses a kernel socket table address (e.g. "0100007F:0050" is 127.0.0.1:80).
+// The IP is hex encoded in 32-bit words of host byte order, which is little-endian on supported platforms.
+func parseProcNetAddress(address string) (string, uint32, error) {
+	hexIP, hexPort, found := strings.Cut(address, ":")
+	if !found {
+		return "", 0, fmt.Errorf("%w %q", ErrInvalidProcNetAddress, address)
+	}
+
+	ip, err := hex.DecodeString(hexIP)
+	if err != nil || (len(ip) != net.IPv4len && len(ip) != net.IPv6len) {
+		return "", 0, fmt.Errorf("%w %q", ErrInvalidProcNetAddress, address)
+	}
+	for i := 0; i < len(ip); i += 4 {
+		ip[i], ip[i+1], ip[i+2], ip[i+3] = ip[i+3], ip[i+2], ip[i+1], ip[i]
+	}
+
+	port, err := strconv.ParseUint(hexPort, 16, 16)
+	if err != nil {
+		return "", 0, fmt.Errorf("%w %q", ErrInvalidProcNetAddress, address)
+	}
+
+	return net.IP(ip).String(), uint32(port), nil
+}
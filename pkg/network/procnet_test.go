@@ -0,0 +1,88 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package network
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// nolint:lll
+const (
+	mockProcNetTCP = `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 0100007F:BC8F 00000000:0000 0A 00000000:00000000 00:00000000 00000000 65534        0 1037 1 000000009607e380 100 0 0 10 0
+   1: 0100000A:0050 0200000A:A0D2 01 00000000:00000000 00:00000000 00000000     0        0 663 1 00000000da19626d 20 4 30 10 -1
+   2: 0100000A:9C40 0300000A:1F90 06 00000000:00000000 03:00001770 00000000     0        0 0 3 00000000bf37a1e4
+`
+	mockProcNetTCP6 = `  sl  local_address                         remote_address                        st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 0000000000000000FFFF00000100000A:1F90 0000000000000000FFFF00000400000A:D431 01 00000000:00000000 00:00000000 00000000     0        0 1234 1 0000000000000000 20 4 30 10 -1
+   1: B80D0120000000000000000001000000:01BB B80D0120000000000000000002000000:C350 01 00000000:00000000 00:00000000 00000000     0        0 1235 1 0000000000000000 20 4 30 10 -1
+`
+)
+
+func TestPeeredConnections(t *testing.T) {
+	root := t.TempDir()
+	for file, content := range map[string]string{"tcp": mockProcNetTCP, "tcp6": mockProcNetTCP6} {
+		if err := os.WriteFile(filepath.Join(root, file), []byte(content), 0o600); err != nil {
+			t.Fatalf("os.WriteFile() error = %v", err)
+		}
+	}
+	defer func(previous string) { procNetRoot = previous }(procNetRoot)
+	procNetRoot = root
+
+	// LISTEN sockets are skipped, and the missing udp tables are ignored
+	want := []PeeredConnSocket{
+		{LocalIP: "10.0.0.1", LocalPort: 80, RemoteIP: "10.0.0.2", RemotePort: 41170, Protocol: "tcp", ProcessName: "", State: SocketStateEstablished},
+		{LocalIP: "10.0.0.1", LocalPort: 40000, RemoteIP: "10.0.0.3", RemotePort: 8080, Protocol: "tcp", ProcessName: "", State: SocketStateTimeWait},
+		{LocalIP: "10.0.0.1", LocalPort: 8080, RemoteIP: "10.0.0.4", RemotePort: 54321, Protocol: "tcp", ProcessName: "", State: SocketStateEstablished},
+		{LocalIP: "2001:db8::1", LocalPort: 443, RemoteIP: "2001:db8::2", RemotePort: 50000, Protocol: "tcp", ProcessName: "", State: SocketStateEstablished},
+	}
+	got, err := PeeredConnections(context.Background())
+	if err != nil {
+		t.Fatalf("PeeredConnections() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("PeeredConnections() = %v, want %v", got, want)
+	}
+}
+
+func Test_parseProcNetAddress(t *testing.T) {
+	tests := []struct {
+		name     string
+		address  string
+		wantIP   string
+		wantPort uint32
+		wantErr  bool
+	}{
+		{name: "IPv4", address: "0100007F:0050", wantIP: "127.0.0.1", wantPort: 80, wantErr: false},
+		{name: "IPv6", address: "00000000000000000000000001000000:1F90", wantIP: "::1", wantPort: 8080, wantErr: false},
+		{name: "Missing port", address: "0100007F", wantIP: "", wantPort: 0, wantErr: true},
+		{name: "Invalid length", address: "01007F:0050", wantIP: "", wantPort: 0, wantErr: true},
+	}
+	for _, testcase := range tests {
+		t.Run(testcase.name, func(t *testing.T) {
+			gotIP, gotPort, err := parseProcNetAddress(testcase.address)
+			if (err != nil) != testcase.wantErr {
+				t.Fatalf("parseProcNetAddress() error = %v, wantErr %v", err, testcase.wantErr)
+			}
+			if gotIP != testcase.wantIP || gotPort != testcase.wantPort {
+				t.Errorf("parseProcNetAddress() = %v, %v, want %v, %v", gotIP, gotPort, testcase.wantIP, testcase.wantPort)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package network
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+
+	"golang.org/x/sys/cpu"
+)
+
+// TCPSocketCounters are the kernel byte counters of a connected TCP socket (from tcp_info), similar to "ss -tin".
+type TCPSocketCounters struct {
+	LocalIP    string
+	LocalPort  uint32
+	RemoteIP   string
+	RemotePort uint32
+	// Cookie identifies the socket for its whole lifetime, unlike its connection tuple which can be reused
+	Cookie uint64
+	// BytesAcked were sent and acknowledged by the peer, and BytesReceived were received from the peer
+	BytesAcked    uint64
+	BytesReceived uint64
+}
+
+var (
+	// ErrSocketDiagUnsupported the kernel socket byte counters can't be read on this platform.
+	ErrSocketDiagUnsupported = errors.New("socket byte counters aren't supported on this platform")
+	// ErrInvalidSocketDiagMessage a socket diag message from the kernel can't be parsed.
+	ErrInvalidSocketDiagMessage = errors.New("invalid socket diag message")
+)
+
+// hostByteOrder of the netlink messages, whose fields other than ports and addresses are in the byte order of the host.
+var hostByteOrder binary.ByteOrder = binary.LittleEndian
+
+func init() {
+	if cpu.IsBigEndian {
+		hostByteOrder = binary.BigEndian
+	}
+}
+
+const (
+	// inetDiagMsgLen is the size of struct inet_diag_msg, followed by its attributes
+	inetDiagMsgLen = 72
+	// inetDiagInfo is the INET_DIAG_INFO attribute, a struct tcp_info
+	inetDiagInfo = 2
+	// rtaHeaderLen is the size of struct rtattr, attributes are aligned to rtaAlignTo bytes
+	rtaHeaderLen = 4
+	rtaAlignTo   = 4
+
+	// tcpInfoBytesAckedOffset and tcpInfoBytesReceivedOffset of the counters in struct tcp_info,
+	// which kernels before 4.1 don't have
+	tcpInfoBytesAckedOffset    = 120
+	tcpInfoBytesReceivedOffset = 128
+	tcpInfoMinLen              = 136
+)
+
+// parseInetDiagMessage parses the socket and byte counters of an inet_diag_msg and its attributes.
+// It returns false if the message has no byte counters (e.g. an older kernel).
+// Ports and addresses are in network byte order, the rest is in host byte order.
+func parseInetDiagMessage(data []byte) (TCPSocketCounters, bool, error) {
+	if len(data) < inetDiagMsgLen {
+		return TCPSocketCounters{}, false, fmt.Errorf("%w: %v bytes is shorter than inet_diag_msg", ErrInvalidSocketDiagMessage, len(data))
+	}
+
+	var ipLen int
+	switch data[0] {
+	case syscall.AF_INET:
+		ipLen = net.IPv4len
+	case syscall.AF_INET6:
+		ipLen = net.IPv6len
+	default:
+		return TCPSocketCounters{}, false, fmt.Errorf("%w: unknown address family %v", ErrInvalidSocketDiagMessage, data[0])
+	}
+
+	// struct inet_diag_sockid starts after family, state, timer, and retrans
+	socket := TCPSocketCounters{
+		LocalPort:     uint32(binary.BigEndian.Uint16(data[4:6])),
+		RemotePort:    uint32(binary.BigEndian.Uint16(data[6:8])),
+		LocalIP:       net.IP(append([]byte(nil), data[8:8+ipLen]...)).String(),
+		RemoteIP:      net.IP(append([]byte(nil), data[24:24+ipLen]...)).String(),
+		Cookie:        uint64(hostByteOrder.Uint32(data[44:48])) | uint64(hostByteOrder.Uint32(data[48:52]))<<32,
+		BytesAcked:    0,
+		BytesReceived: 0,
+	}
+
+	for attrs := data[inetDiagMsgLen:]; len(attrs) >= rtaHeaderLen; {
+		attrLen := int(hostByteOrder.Uint16(attrs[0:2]))
+		attrType := hostByteOrder.Uint16(attrs[2:4])
+		if attrLen < rtaHeaderLen || attrLen > len(attrs) {
+			return TCPSocketCounters{}, false, fmt.Errorf("%w: attribute length %v", ErrInvalidSocketDiagMessage, attrLen)
+		}
+		if attrType == inetDiagInfo {
+			tcpInfo := attrs[rtaHeaderLen:attrLen]
+			if len(tcpInfo) < tcpInfoMinLen {
+				return socket, false, nil
+			}
+			socket.BytesAcked = hostByteOrder.Uint64(tcpInfo[tcpInfoBytesAckedOffset:])
+			socket.BytesReceived = hostByteOrder.Uint64(tcpInfo[tcpInfoBytesReceivedOffset:])
+
+			return socket, true, nil
+		}
+
+		alignedLen := (attrLen + rtaAlignTo - 1) &^ (rtaAlignTo - 1)
+		if alignedLen > len(attrs) {
+			break
+		}
+		attrs = attrs[alignedLen:]
+	}
+
+	return socket, false, nil
+}
@@ -0,0 +1,128 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+
+package network
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+)
+
+const (
+	// netlinkInetDiag is the NETLINK_INET_DIAG (NETLINK_SOCK_DIAG) protocol
+	netlinkInetDiag = 4
+	// sockDiagByFamily is the SOCK_DIAG_BY_FAMILY message type
+	sockDiagByFamily = 20
+	// inetDiagReqV2Len is the size of struct inet_diag_req_v2
+	inetDiagReqV2Len = 56
+
+	// tcpConnectedStates are the TCP states of connected sockets, which have byte counters:
+	// ESTABLISHED, FIN_WAIT1, FIN_WAIT2, CLOSE_WAIT, LAST_ACK, and CLOSING
+	tcpConnectedStates = 1<<1 | 1<<4 | 1<<5 | 1<<8 | 1<<9 | 1<<11
+
+	// receiveTimeout bounds each receive from the kernel, so that a cancelled context is noticed while waiting
+	receiveTimeout = 100 * time.Millisecond
+)
+
+// ReadTCPSocketCounters returns the byte counters of every connected TCP socket, from the kernel over netlink
+// (inet_diag with tcp_info, like "ss -tin"). Sockets without byte counters (kernels before 4.1) are skipped.
+// Sockets that closed before the call aren't returned, so their bytes since the previous call are unknown.
+func ReadTCPSocketCounters(ctx context.Context) ([]TCPSocketCounters, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC, netlinkInetDiag)
+	if err != nil {
+		return nil, fmt.Errorf("error opening socket diag netlink socket: %w", err)
+	}
+	defer syscall.Close(fd)
+	timeout := syscall.NsecToTimeval(receiveTimeout.Nanoseconds())
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &timeout); err != nil {
+		return nil, fmt.Errorf("error setting socket diag receive timeout: %w", err)
+	}
+
+	sockets := []TCPSocketCounters{}
+	for _, family := range []uint8{syscall.AF_INET, syscall.AF_INET6} {
+		familySockets, err := dumpTCPSocketCounters(ctx, fd, family)
+		if err != nil {
+			return nil, err
+		}
+		sockets = append(sockets, familySockets...)
+	}
+
+	return sockets, nil
+}
+
+// dumpTCPSocketCounters requests and reads the byte counters of the connected TCP sockets of an address family.
+// It stops with the context's error once it's done, checked in between receives.
+func dumpTCPSocketCounters(ctx context.Context, fd int, family uint8) ([]TCPSocketCounters, error) {
+	request := make([]byte, syscall.NLMSG_HDRLEN+inetDiagReqV2Len)
+	hostByteOrder.PutUint32(request[0:4], uint32(len(request)))
+	hostByteOrder.PutUint16(request[4:6], sockDiagByFamily)
+	hostByteOrder.PutUint16(request[6:8], syscall.NLM_F_REQUEST|syscall.NLM_F_DUMP)
+	// struct inet_diag_req_v2, the zero socket ID matches every socket in the states
+	req := request[syscall.NLMSG_HDRLEN:]
+	req[0] = family
+	req[1] = syscall.IPPROTO_TCP
+	req[2] = 1 << (inetDiagInfo - 1)
+	hostByteOrder.PutUint32(req[4:8], tcpConnectedStates)
+
+	if err := syscall.Sendto(fd, request, 0, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil { // nolint:exhaustivestruct
+		return nil, fmt.Errorf("error sending socket diag request: %w", err)
+	}
+
+	sockets := []TCPSocketCounters{}
+	buf := make([]byte, 8*os.Getpagesize()) // nolint:gomnd
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, fmt.Errorf("error reading socket byte counters: %w", err)
+		}
+		n, _, err := syscall.Recvfrom(fd, buf, 0)
+		if errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.EINTR) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error receiving socket diag messages: %w", err)
+		}
+		messages, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidSocketDiagMessage, err)
+		}
+		for _, message := range messages {
+			switch message.Header.Type {
+			case syscall.NLMSG_DONE:
+				return sockets, nil
+			case syscall.NLMSG_ERROR:
+				if len(message.Data) >= 4 { // nolint:gomnd
+					if errno := int32(hostByteOrder.Uint32(message.Data[0:4])); errno < 0 {
+						return nil, fmt.Errorf("error dumping sockets: %w", syscall.Errno(-errno))
+					}
+				}
+
+				return nil, fmt.Errorf("%w: error message without errno", ErrInvalidSocketDiagMessage)
+			}
+
+			socket, found, err := parseInetDiagMessage(message.Data)
+			if err != nil {
+				return nil, err
+			}
+			if found {
+				sockets = append(sockets, socket)
+			}
+		}
+	}
+}
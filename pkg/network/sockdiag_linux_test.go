@@ -0,0 +1,87 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+
+package network
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestReadTCPSocketCounters(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen() error = %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = io.Copy(io.Discard, conn)
+	}()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("net.Dial() error = %v", err)
+	}
+	defer conn.Close()
+	const written = 4096
+	if _, err := conn.Write(make([]byte, written)); err != nil {
+		t.Fatalf("conn.Write() error = %v", err)
+	}
+	localPort := uint32(conn.LocalAddr().(*net.TCPAddr).Port)
+	remotePort := uint32(listener.Addr().(*net.TCPAddr).Port)
+
+	// The bytes are acked asynchronously
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		sockets, err := ReadTCPSocketCounters(context.Background())
+		if err != nil {
+			// e.g. a sandbox without NETLINK_SOCK_DIAG
+			t.Skipf("ReadTCPSocketCounters() error = %v", err)
+		}
+		for _, socket := range sockets {
+			if socket.LocalPort == localPort && socket.RemotePort == remotePort && socket.BytesAcked >= written {
+				return
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("ReadTCPSocketCounters() = %+v, want a socket %v->%v with at least %v bytes acked", sockets, localPort, remotePort, written)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestReadTCPSocketCounters_cancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := ReadTCPSocketCounters(ctx)
+	if err != nil && !errors.Is(err, context.Canceled) {
+		// e.g. a sandbox without NETLINK_SOCK_DIAG
+		t.Skipf("ReadTCPSocketCounters() error = %v", err)
+	}
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("ReadTCPSocketCounters() error = %v, want %v", err, context.Canceled)
+	}
+}
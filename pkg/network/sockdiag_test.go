@@ -0,0 +1,96 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package network
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"syscall"
+	"testing"
+)
+
+// inetDiagMessage returns an inet_diag_msg of a socket followed by a padding attribute, and an INET_DIAG_INFO
+// attribute with tcpInfoLen bytes of tcp_info if it's positive.
+func inetDiagMessage(family uint8, socket TCPSocketCounters, tcpInfoLen int) []byte {
+	msg := make([]byte, inetDiagMsgLen)
+	msg[0] = family
+	binary.BigEndian.PutUint16(msg[4:6], uint16(socket.LocalPort))
+	binary.BigEndian.PutUint16(msg[6:8], uint16(socket.RemotePort))
+	localIP, remoteIP := net.ParseIP(socket.LocalIP), net.ParseIP(socket.RemoteIP)
+	if family == syscall.AF_INET {
+		localIP, remoteIP = localIP.To4(), remoteIP.To4()
+	}
+	copy(msg[8:24], localIP)
+	copy(msg[24:40], remoteIP)
+	hostByteOrder.PutUint32(msg[44:48], uint32(socket.Cookie))
+	hostByteOrder.PutUint32(msg[48:52], uint32(socket.Cookie>>32))
+
+	// An unaligned attribute of another type (e.g. INET_DIAG_CONG) is skipped
+	msg = append(msg, 7, 0, 4, 0, 'c', 'u', 'b', 0)
+	if tcpInfoLen > 0 {
+		attr := make([]byte, rtaHeaderLen+tcpInfoLen)
+		hostByteOrder.PutUint16(attr[0:2], uint16(len(attr)))
+		hostByteOrder.PutUint16(attr[2:4], inetDiagInfo)
+		if tcpInfoLen >= tcpInfoMinLen {
+			hostByteOrder.PutUint64(attr[rtaHeaderLen+tcpInfoBytesAckedOffset:], socket.BytesAcked)
+			hostByteOrder.PutUint64(attr[rtaHeaderLen+tcpInfoBytesReceivedOffset:], socket.BytesReceived)
+		}
+		msg = append(msg, attr...)
+	}
+
+	return msg
+}
+
+func Test_parseInetDiagMessage(t *testing.T) {
+	ipv4Socket := TCPSocketCounters{
+		LocalIP: "10.0.0.1", LocalPort: 41170, RemoteIP: "10.0.0.2", RemotePort: 5432,
+		Cookie: 1<<32 + 7, BytesAcked: 1 << 40, BytesReceived: 2048,
+	}
+	ipv6Socket := TCPSocketCounters{
+		LocalIP: "2001:db8::1", LocalPort: 443, RemoteIP: "2001:db8::2", RemotePort: 50000,
+		Cookie: 9, BytesAcked: 100, BytesReceived: 200,
+	}
+
+	tests := []struct {
+		name      string
+		data      []byte
+		want      TCPSocketCounters
+		wantFound bool
+		wantErr   error
+	}{
+		{name: "IPv4", data: inetDiagMessage(syscall.AF_INET, ipv4Socket, 232), want: ipv4Socket, wantFound: true, wantErr: nil},
+		{name: "IPv6", data: inetDiagMessage(syscall.AF_INET6, ipv6Socket, tcpInfoMinLen), want: ipv6Socket, wantFound: true, wantErr: nil},
+		{name: "No tcp_info", data: inetDiagMessage(syscall.AF_INET6, ipv6Socket, 0), want: TCPSocketCounters{}, wantFound: false, wantErr: nil},
+		{name: "tcp_info without byte counters", data: inetDiagMessage(syscall.AF_INET6, ipv6Socket, 104), want: TCPSocketCounters{}, wantFound: false, wantErr: nil},
+		{name: "Short message", data: make([]byte, 20), want: TCPSocketCounters{}, wantFound: false, wantErr: ErrInvalidSocketDiagMessage},
+		{name: "Unknown family", data: inetDiagMessage(syscall.AF_UNIX, ipv6Socket, 0), want: TCPSocketCounters{}, wantFound: false, wantErr: ErrInvalidSocketDiagMessage},
+		{name: "Truncated attribute", data: inetDiagMessage(syscall.AF_INET6, ipv6Socket, tcpInfoMinLen)[:inetDiagMsgLen+20], want: TCPSocketCounters{}, wantFound: false, wantErr: ErrInvalidSocketDiagMessage},
+	}
+	for _, testcase := range tests {
+		t.Run(testcase.name, func(t *testing.T) {
+			got, found, err := parseInetDiagMessage(testcase.data)
+			if !errors.Is(err, testcase.wantErr) {
+				t.Fatalf("parseInetDiagMessage() error = %v, want %v", err, testcase.wantErr)
+			}
+			if found != testcase.wantFound {
+				t.Fatalf("parseInetDiagMessage() found = %v, want %v", found, testcase.wantFound)
+			}
+			if found && got != testcase.want {
+				t.Errorf("parseInetDiagMessage() = %+v, want %+v", got, testcase.want)
+			}
+		})
+	}
+}
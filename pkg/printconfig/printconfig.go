@@ -0,0 +1,214 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package printconfig prints the effective configuration of a binary (e.g. for -print-config), with secrets redacted.
+package printconfig
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"planet-exporter/pkg/httpheader"
+	"planet-exporter/pkg/prometheus"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config formats.
+const (
+	FormatYAML = "yaml"
+	FormatJSON = "json"
+)
+
+// ErrUnknownFormat the config format isn't FormatYAML or FormatJSON.
+var ErrUnknownFormat = errors.New("unknown config format")
+
+// redactedValue replaces the values of secret fields.
+const redactedValue = "<redacted>"
+
+// secretFieldSuffixes are suffixes of field names whose values are secrets (e.g. InfluxdbToken or ClientSecret).
+var secretFieldSuffixes = []string{"Password", "Token", "Secret"}
+
+var (
+	headerType   = reflect.TypeOf(http.Header{})
+	stringerType = reflect.TypeOf((*fmt.Stringer)(nil)).Elem()
+)
+
+// Print writes the config struct in the format, with its fields in declaration order. Values with a String method
+// (e.g. durations) are printed as strings, and the values of secret fields and secret-looking HTTP headers are
+// redacted, as are the passwords of URLs. Empty secrets are printed as is, to tell whether they are set.
+func Print(w io.Writer, format string, config interface{}) error {
+	value := toValue(reflect.ValueOf(config))
+
+	switch format {
+	case FormatYAML:
+		encoder := yaml.NewEncoder(w)
+		encoder.SetIndent(2) // nolint:gomnd
+		if err := encoder.Encode(value); err != nil {
+			return fmt.Errorf("error encoding config as YAML: %w", err)
+		}
+
+		return encoder.Close() // nolint:wrapcheck
+	case FormatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetEscapeHTML(false)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(value); err != nil {
+			return fmt.Errorf("error encoding config as JSON: %w", err)
+		}
+
+		return nil
+	default:
+		return fmt.Errorf("%w %q, expected %v or %v", ErrUnknownFormat, format, FormatYAML, FormatJSON)
+	}
+}
+
+// field of a struct, printed in declaration order.
+type field struct {
+	name  string
+	value interface{}
+}
+
+// object is a struct printed as a mapping of its exported fields.
+type object []field
+
+// MarshalJSON implements json.Marshaler.
+func (o object) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, f := range o {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		// The encoder appends a newline to both, which is valid JSON whitespace
+		encoder := json.NewEncoder(&buf)
+		encoder.SetEscapeHTML(false)
+		if err := encoder.Encode(f.name); err != nil {
+			return nil, err // nolint:wrapcheck
+		}
+		buf.WriteByte(':')
+		if err := encoder.Encode(f.value); err != nil {
+			return nil, err // nolint:wrapcheck
+		}
+	}
+	buf.WriteByte('}')
+
+	return buf.Bytes(), nil
+}
+
+// MarshalYAML implements yaml.Marshaler.
+func (o object) MarshalYAML() (interface{}, error) {
+	node := &yaml.Node{Kind: yaml.MappingNode} // nolint:exhaustivestruct
+	for _, f := range o {
+		var value yaml.Node
+		if err := value.Encode(f.value); err != nil {
+			return nil, err // nolint:wrapcheck
+		}
+		node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: f.name}, &value) // nolint:exhaustivestruct
+	}
+
+	return node, nil
+}
+
+// toValue returns a value to print.
+func toValue(v reflect.Value) interface{} {
+	if !v.IsValid() {
+		return nil
+	}
+
+	switch {
+	case v.Type() == headerType:
+		return httpheader.Redacted(v.Interface().(http.Header))
+	case v.Type().Implements(stringerType):
+		if v.Kind() == reflect.Ptr && v.IsNil() {
+			return nil
+		}
+
+		return v.Interface().(fmt.Stringer).String()
+	case reflect.PtrTo(v.Type()).Implements(stringerType):
+		ptr := reflect.New(v.Type())
+		ptr.Elem().Set(v)
+
+		return ptr.Interface().(fmt.Stringer).String()
+	}
+
+	switch v.Kind() { // nolint:exhaustive
+	case reflect.Struct:
+		fields := object{}
+		for i := 0; i < v.NumField(); i++ {
+			structField := v.Type().Field(i)
+			if structField.PkgPath != "" {
+				continue
+			}
+			value := toValue(v.Field(i))
+			if isSecret(structField.Name) && !v.Field(i).IsZero() {
+				value = redactedValue
+			}
+			fields = append(fields, field{name: structField.Name, value: value})
+		}
+
+		return fields
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+
+		return toValue(v.Elem())
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return nil
+		}
+		values := make([]interface{}, 0, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			values = append(values, toValue(v.Index(i)))
+		}
+
+		return values
+	case reflect.Map:
+		if v.IsNil() {
+			return nil
+		}
+		values := make(map[string]interface{}, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			values[fmt.Sprint(iter.Key().Interface())] = toValue(iter.Value())
+		}
+
+		return values
+	case reflect.Func, reflect.Chan, reflect.UnsafePointer:
+		return nil
+	case reflect.String:
+		// URLs of any field (e.g. a proxy URL or an address) may carry a password
+		return prometheus.RedactURL(v.String())
+	default:
+		return v.Interface()
+	}
+}
+
+// isSecret returns true if the field name looks like a secret.
+func isSecret(name string) bool {
+	for _, suffix := range secretFieldSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -15,26 +15,297 @@
 package process
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"os"
+	"strconv"
+	"sync"
 
 	"github.com/mitchellh/go-ps"
 )
 
+// procRoot is the mount point of the proc filesystem.
+const procRoot = "/proc"
+
+// Process is a running process.
+type Process struct {
+	Pid  int
+	Name string
+	// StartTime in clock ticks since boot, which tells apart processes that reused the same Pid
+	StartTime uint64
+	// UID is the real user ID of the process
+	UID int
+}
+
 // Table maps between Pid and Process name.
 type Table map[int]string
 
+// ErrMalformedProcFile a /proc/<pid> file can't be parsed.
+var ErrMalformedProcFile = errors.New("malformed proc file")
+
+var (
+	defaultScannerMu sync.Mutex
+	defaultScanner   = newScanner(procRoot)
+)
+
+// GetProcesses returns current processes by Pid, read directly from /proc.
+// On systems without /proc, it falls back to a process list where StartTime and UID are always zero.
+// When ctx is done before every process is read, it returns the processes read so far along with the error.
+func GetProcesses(ctx context.Context) (map[int]Process, error) {
+	defaultScannerMu.Lock()
+	processes, err := defaultScanner.scan(ctx)
+	defaultScannerMu.Unlock()
+	if errors.Is(err, fs.ErrNotExist) {
+		return goPSProcesses()
+	}
+
+	return processes, err
+}
+
 // GetProcessTable returns map of current processes Pid to its executable name.
 func GetProcessTable(ctx context.Context) (Table, error) {
-	processes, err := ps.Processes()
+	processes, err := GetProcesses(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("error retrieving process list: %w", err)
+		return nil, err
 	}
 
-	processTable := make(Table)
-	for _, v := range processes {
-		processTable[v.Pid()] = v.Executable()
+	processTable := make(Table, len(processes))
+	for pid, p := range processes {
+		processTable[pid] = p.Name
 	}
 
 	return processTable, nil
 }
+
+// goPSProcesses returns current processes by Pid using go-ps, which only knows their names.
+func goPSProcesses() (map[int]Process, error) {
+	psProcesses, err := ps.Processes()
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving process list: %w", err)
+	}
+
+	processes := make(map[int]Process, len(psProcesses))
+	for _, v := range psProcesses {
+		processes[v.Pid()] = Process{Pid: v.Pid(), Name: v.Executable(), StartTime: 0, UID: 0}
+	}
+
+	return processes, nil
+}
+
+// scanner reads processes from a proc filesystem root.
+// The read buffer and the previous scan are reused to keep allocations per scan low.
+type scanner struct {
+	root string
+	buf  []byte
+
+	previous map[int]Process
+}
+
+// newScanner returns a scanner of a proc filesystem root.
+func newScanner(root string) *scanner {
+	const readBufferSize = 4096
+
+	return &scanner{
+		root:     root,
+		buf:      make([]byte, 0, readBufferSize),
+		previous: map[int]Process{},
+	}
+}
+
+// scan reads every process under the proc filesystem root.
+// Processes that exit while being scanned are skipped.
+// When ctx is done, it returns the processes read so far along with an error wrapping ctx.Err().
+func (s *scanner) scan(ctx context.Context) (map[int]Process, error) {
+	dir, err := os.Open(s.root)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving process list: %w", err)
+	}
+	names, err := dir.Readdirnames(-1)
+	dir.Close()
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving process list: %w", err)
+	}
+
+	processes := make(map[int]Process, len(names))
+	for _, name := range names {
+		if err := ctx.Err(); err != nil {
+			return processes, fmt.Errorf("error retrieving process list: %w", err)
+		}
+
+		pid, err := strconv.Atoi(name)
+		if err != nil {
+			// Not a process directory (e.g. /proc/net)
+			continue
+		}
+
+		p, err := s.readProcess(s.root+"/"+name, pid)
+		if err != nil {
+			continue
+		}
+		processes[pid] = p
+	}
+	s.previous = processes
+
+	return processes, nil
+}
+
+// readProcess reads a process from its /proc/<pid> directory.
+func (s *scanner) readProcess(dir string, pid int) (Process, error) {
+	var err error
+
+	s.buf, err = readFile(dir+"/stat", s.buf)
+	if err != nil {
+		return Process{}, err
+	}
+	nameBytes, startTime, err := parseStat(s.buf)
+	if err != nil {
+		return Process{}, err
+	}
+
+	// The same Pid and StartTime is the same process, so its name is reused unless it changed (e.g. exec)
+	name := ""
+	if previous, ok := s.previous[pid]; ok && previous.StartTime == startTime && previous.Name == string(nameBytes) {
+		name = previous.Name
+	} else {
+		name = string(nameBytes)
+	}
+
+	s.buf, err = readFile(dir+"/status", s.buf)
+	if err != nil {
+		return Process{}, err
+	}
+	uid, err := parseStatusUID(s.buf)
+	if err != nil {
+		return Process{}, err
+	}
+
+	return Process{
+		Pid:       pid,
+		Name:      name,
+		StartTime: startTime,
+		UID:       uid,
+	}, nil
+}
+
+// readFile reads a whole file into buf, growing it if needed.
+func readFile(path string, buf []byte) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return buf[:0], err
+	}
+	defer f.Close()
+
+	buf = buf[:0]
+	for {
+		if len(buf) == cap(buf) {
+			buf = append(buf, 0)[:len(buf)]
+		}
+		n, err := f.Read(buf[len(buf):cap(buf)])
+		buf = buf[:len(buf)+n]
+		if errors.Is(err, io.EOF) {
+			return buf, nil
+		}
+		if err != nil {
+			return buf, err
+		}
+	}
+}
+
+// parseStat parses the executable name and start time from a /proc/<pid>/stat file.
+// The name is enclosed in parentheses and may contain spaces or parentheses itself, so it ends at the last ')'.
+// The returned name refers to data.
+func parseStat(data []byte) ([]byte, uint64, error) {
+	// starttime is the 22nd field, the 20th after the name (pid and name are the first two fields)
+	const startTimeField = 19
+
+	nameStart := bytes.IndexByte(data, '(')
+	nameEnd := bytes.LastIndexByte(data, ')')
+	if nameStart < 0 || nameEnd < nameStart {
+		return nil, 0, fmt.Errorf("error parsing stat: %w", ErrMalformedProcFile)
+	}
+
+	field, ok := nthField(data[nameEnd+1:], startTimeField)
+	if !ok {
+		return nil, 0, fmt.Errorf("error parsing stat: %w", ErrMalformedProcFile)
+	}
+	startTime, ok := parseUint(field)
+	if !ok {
+		return nil, 0, fmt.Errorf("error parsing stat starttime %q: %w", field, ErrMalformedProcFile)
+	}
+
+	return data[nameStart+1 : nameEnd], startTime, nil
+}
+
+// parseStatusUID parses the real user ID from a /proc/<pid>/status file.
+func parseStatusUID(data []byte) (int, error) {
+	uidPrefix := []byte("Uid:")
+	for len(data) > 0 {
+		line := data
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			line, data = data[:i], data[i+1:]
+		} else {
+			data = nil
+		}
+		if !bytes.HasPrefix(line, uidPrefix) {
+			continue
+		}
+
+		// Uid: real, effective, saved set, and filesystem UIDs
+		field, ok := nthField(line[len(uidPrefix):], 0)
+		if !ok {
+			break
+		}
+		uid, ok := parseUint(field)
+		if !ok {
+			return 0, fmt.Errorf("error parsing status Uid %q: %w", field, ErrMalformedProcFile)
+		}
+
+		return int(uid), nil
+	}
+
+	return 0, fmt.Errorf("error parsing status Uid: %w", ErrMalformedProcFile)
+}
+
+// nthField returns the nth (0-indexed) whitespace-separated field of data without allocating.
+func nthField(data []byte, n int) ([]byte, bool) {
+	for i := 0; ; i++ {
+		data = bytes.TrimLeft(data, " \t\n")
+		if len(data) == 0 {
+			return nil, false
+		}
+		end := bytes.IndexAny(data, " \t\n")
+		if end < 0 {
+			end = len(data)
+		}
+		if i == n {
+			return data[:end], true
+		}
+		data = data[end:]
+	}
+}
+
+// parseUint parses a decimal unsigned integer without allocating.
+func parseUint(data []byte) (uint64, bool) {
+	if len(data) == 0 {
+		return 0, false
+	}
+
+	var n uint64
+	for _, c := range data {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+		digit := uint64(c - '0')
+		if n > (math.MaxUint64-digit)/10 {
+			return 0, false
+		}
+		n = n*10 + digit
+	}
+
+	return n, true
+}
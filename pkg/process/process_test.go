@@ -0,0 +1,260 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package process
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// mockStat returns a /proc/<pid>/stat content with the given name and starttime.
+func mockStat(pid, name, startTime string) string {
+	return pid + " (" + name + ") S 1 " + pid + " " + pid + " 0 -1 4194560 1234 0 0 0 5 3 0 0 20 0 1 0 " +
+		startTime + " 12345678 900 18446744073709551615 1 1 0 0 0 0 0 4096 0 0 0 0 17 0 0 0 0 0 0\n"
+}
+
+// mockStatus returns a /proc/<pid>/status content with the given real UID.
+func mockStatus(name, uid string) string {
+	return "Name:\t" + name + "\nUmask:\t0022\nState:\tS (sleeping)\nUid:\t" + uid + "\t" + uid + "\t" + uid + "\t" + uid + "\nGid:\t0\t0\t0\t0\n"
+}
+
+// mockProcRoot creates a proc filesystem root with the given pid -> files.
+func mockProcRoot(t *testing.T, processes map[string]map[string]string) string {
+	t.Helper()
+
+	root := t.TempDir()
+	for pid, files := range processes {
+		dir := filepath.Join(root, pid)
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatalf("os.MkdirAll() error = %v", err)
+		}
+		for name, content := range files {
+			if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+				t.Fatalf("os.WriteFile() error = %v", err)
+			}
+		}
+	}
+
+	return root
+}
+
+func Test_scanner_scan(t *testing.T) {
+	root := mockProcRoot(t, map[string]map[string]string{
+		"1": {
+			"stat":   mockStat("1", "systemd", "1"),
+			"status": mockStatus("systemd", "0"),
+		},
+		"4242": {
+			"stat":   mockStat("4242", "tmux: server (1)", "987654"),
+			"status": mockStatus("tmux: server (1)", "1000"),
+		},
+		// Exited before its status is read
+		"5000": {
+			"stat": mockStat("5000", "short-lived", "1000"),
+		},
+		"net": {
+			"dev": "",
+		},
+	})
+
+	got, err := newScanner(root).scan(context.Background())
+	if err != nil {
+		t.Fatalf("scanner.scan() error = %v", err)
+	}
+	want := map[int]Process{
+		1:    {Pid: 1, Name: "systemd", StartTime: 1, UID: 0},
+		4242: {Pid: 4242, Name: "tmux: server (1)", StartTime: 987654, UID: 1000},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("scanner.scan() = %v, want %v", got, want)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	partial, err := newScanner(root).scan(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("scanner.scan() error = %v, want %v", err, context.Canceled)
+	}
+	if partial == nil || len(partial) != 0 {
+		t.Errorf("scanner.scan() = %v, want the empty processes read so far", partial)
+	}
+}
+
+func Test_scanner_scan_pidReuse(t *testing.T) {
+	root := mockProcRoot(t, map[string]map[string]string{
+		"100": {
+			"stat":   mockStat("100", "nginx", "500"),
+			"status": mockStatus("nginx", "33"),
+		},
+	})
+	s := newScanner(root)
+	if _, err := s.scan(context.Background()); err != nil {
+		t.Fatalf("scanner.scan() error = %v", err)
+	}
+
+	// Pid 100 exited and was reused by another process
+	files := map[string]string{
+		"stat":   mockStat("100", "redis-server", "900"),
+		"status": mockStatus("redis-server", "999"),
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(root, "100", name), []byte(content), 0o600); err != nil {
+			t.Fatalf("os.WriteFile() error = %v", err)
+		}
+	}
+
+	got, err := s.scan(context.Background())
+	if err != nil {
+		t.Fatalf("scanner.scan() error = %v", err)
+	}
+	want := Process{Pid: 100, Name: "redis-server", StartTime: 900, UID: 999}
+	if !reflect.DeepEqual(got[100], want) {
+		t.Errorf("scanner.scan() = %v, want %v", got[100], want)
+	}
+}
+
+func Test_parseStat(t *testing.T) {
+	tests := []struct {
+		name          string
+		data          string
+		wantName      string
+		wantStartTime uint64
+		wantErr       bool
+	}{
+		{
+			name:          "Simple name",
+			data:          mockStat("80", "nginx", "5566"),
+			wantName:      "nginx",
+			wantStartTime: 5566,
+			wantErr:       false,
+		},
+		{
+			name:          "Name with spaces and parentheses",
+			data:          mockStat("81", ") (x y) (", "7788"),
+			wantName:      ") (x y) (",
+			wantStartTime: 7788,
+			wantErr:       false,
+		},
+		{
+			name:    "Missing name",
+			data:    "80 nginx S 1",
+			wantErr: true,
+		},
+		{
+			name:    "Truncated fields",
+			data:    "80 (nginx) S 1 80 80",
+			wantErr: true,
+		},
+		{
+			name:    "Invalid starttime",
+			data:    mockStat("80", "nginx", "abc"),
+			wantErr: true,
+		},
+	}
+	for _, testcase := range tests {
+		t.Run(testcase.name, func(t *testing.T) {
+			gotName, gotStartTime, err := parseStat([]byte(testcase.data))
+			if (err != nil) != testcase.wantErr {
+				t.Fatalf("parseStat() error = %v, wantErr %v", err, testcase.wantErr)
+			}
+			if string(gotName) != testcase.wantName {
+				t.Errorf("parseStat() name = %v, want %v", gotName, testcase.wantName)
+			}
+			if gotStartTime != testcase.wantStartTime {
+				t.Errorf("parseStat() startTime = %v, want %v", gotStartTime, testcase.wantStartTime)
+			}
+		})
+	}
+}
+
+func Test_parseStatusUID(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    int
+		wantErr bool
+	}{
+		{
+			name:    "Real UID",
+			data:    "Name:\tsshd\nUid:\t1000\t0\t0\t0\nGid:\t0\t0\t0\t0\n",
+			want:    1000,
+			wantErr: false,
+		},
+		{
+			name:    "Last line without newline",
+			data:    "Name:\tsshd\nUid:\t33\t33\t33\t33",
+			want:    33,
+			wantErr: false,
+		},
+		{
+			name:    "Missing Uid",
+			data:    "Name:\tsshd\n",
+			wantErr: true,
+		},
+		{
+			name:    "Invalid Uid",
+			data:    "Uid:\troot\n",
+			wantErr: true,
+		},
+	}
+	for _, testcase := range tests {
+		t.Run(testcase.name, func(t *testing.T) {
+			got, err := parseStatusUID([]byte(testcase.data))
+			if (err != nil) != testcase.wantErr {
+				t.Fatalf("parseStatusUID() error = %v, wantErr %v", err, testcase.wantErr)
+			}
+			if got != testcase.want {
+				t.Errorf("parseStatusUID() = %v, want %v", got, testcase.want)
+			}
+		})
+	}
+}
+
+func TestGetProcessTable(t *testing.T) {
+	if _, err := os.Stat(procRoot); err != nil {
+		t.Skipf("%v is not available: %v", procRoot, err)
+	}
+
+	got, err := GetProcessTable(context.Background())
+	if err != nil {
+		t.Fatalf("GetProcessTable() error = %v", err)
+	}
+	if _, ok := got[os.Getpid()]; !ok {
+		t.Errorf("GetProcessTable() is missing the current process %v", os.Getpid())
+	}
+}
+
+func BenchmarkGetProcesses(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := GetProcesses(context.Background()); err != nil {
+			b.Fatalf("GetProcesses() error = %v", err)
+		}
+	}
+}
+
+// BenchmarkGoPSProcesses is the previous go-ps based implementation, kept for comparison.
+func BenchmarkGoPSProcesses(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := goPSProcesses(); err != nil {
+			b.Fatalf("goPSProcesses() error = %v", err)
+		}
+	}
+}
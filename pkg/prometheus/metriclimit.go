@@ -0,0 +1,88 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus
+
+import (
+	"io"
+	"mime"
+)
+
+// DefaultMaxMetrics is the default maximum number of metrics processed per scrape.
+// It's generous for darkstat/ebpf exporters, while bounding the memory of pathological endpoints.
+const DefaultMaxMetrics = 1000000
+
+// isProtobufResponse returns whether a response content type is the delimited protobuf exposition format,
+// the same way prom2json decides it. Other responses are parsed as the text format.
+func isProtobufResponse(contentType string) bool {
+	mediatype, params, err := mime.ParseMediaType(contentType)
+
+	return err == nil && mediatype == "application/vnd.google.protobuf" &&
+		params["encoding"] == "delimited" && params["proto"] == "io.prometheus.client.MetricFamily"
+}
+
+// sampleLineLimiter reads a text exposition up to maxSamples sample lines, then ends it with io.EOF right before the
+// next sample line, so the truncated exposition still parses. The text parser holds the whole exposition in memory
+// before any metric family is returned, so it must be truncated before parsing.
+type sampleLineLimiter struct {
+	reader     io.Reader
+	maxSamples int
+	// onTruncate is called once when the exposition is truncated
+	onTruncate func()
+
+	samples     int
+	atLineStart bool
+	truncated   bool
+}
+
+// newSampleLineLimiter returns a reader of at most maxSamples sample lines of a text exposition, unlimited if zero.
+func newSampleLineLimiter(reader io.Reader, maxSamples int, onTruncate func()) io.Reader {
+	if maxSamples <= 0 {
+		return reader
+	}
+
+	return &sampleLineLimiter{reader: reader, maxSamples: maxSamples, onTruncate: onTruncate, samples: 0, atLineStart: true, truncated: false}
+}
+
+func (l *sampleLineLimiter) Read(p []byte) (int, error) {
+	if l.truncated {
+		return 0, io.EOF
+	}
+
+	n, err := l.reader.Read(p)
+	for i := 0; i < n; i++ {
+		switch {
+		case p[i] == '\n':
+			l.atLineStart = true
+
+			continue
+		case !l.atLineStart:
+			continue
+		}
+		l.atLineStart = false
+		// Comments (HELP/TYPE) and blank lines aren't samples
+		if p[i] == '#' {
+			continue
+		}
+		if l.samples >= l.maxSamples {
+			l.truncated = true
+			l.onTruncate()
+
+			return i, io.EOF
+		}
+		l.samples++
+	}
+
+	return n, err
+}
@@ -0,0 +1,139 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+func Test_sampleLineLimiter(t *testing.T) {
+	const exposition = "# HELP a A metric.\n# TYPE a gauge\na{id=\"1\"} 1\n\na{id=\"2\"} 1\n# TYPE b gauge\nb 1\n"
+
+	tests := []struct {
+		name          string
+		maxSamples    int
+		want          string
+		wantTruncated bool
+	}{
+		{name: "Unlimited", maxSamples: 0, want: exposition, wantTruncated: false},
+		{name: "Under the limit", maxSamples: 3, want: exposition, wantTruncated: false},
+		{name: "Truncated before the next sample line", maxSamples: 2, want: "# HELP a A metric.\n# TYPE a gauge\na{id=\"1\"} 1\n\na{id=\"2\"} 1\n# TYPE b gauge\n", wantTruncated: true},
+		{name: "Truncated after the first sample line", maxSamples: 1, want: "# HELP a A metric.\n# TYPE a gauge\na{id=\"1\"} 1\n\n", wantTruncated: true},
+	}
+	for _, testcase := range tests {
+		t.Run(testcase.name, func(t *testing.T) {
+			gotTruncated := false
+			// A small buffer splits lines across reads
+			reader := newSampleLineLimiter(bufio.NewReaderSize(strings.NewReader(exposition), 16), testcase.maxSamples, func() { gotTruncated = true })
+			got, err := io.ReadAll(reader)
+			if err != nil {
+				t.Fatalf("sampleLineLimiter.Read() error = %v", err)
+			}
+			if string(got) != testcase.want {
+				t.Errorf("sampleLineLimiter.Read() = %q, want %q", got, testcase.want)
+			}
+			if gotTruncated != testcase.wantTruncated {
+				t.Errorf("sampleLineLimiter truncated = %v, want %v", gotTruncated, testcase.wantTruncated)
+			}
+		})
+	}
+}
+
+func TestClient_Scrape_maxMetricsText(t *testing.T) {
+	// Lines are generated while they're written, the whole exposition would take hundreds of MiB
+	const totalLines = 10000000
+	var linesWritten int64
+	mockhttpserver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", string(expfmt.FmtText))
+		fmt.Fprint(w, "# TYPE synthetic_total counter\n")
+		for i := 0; i < totalLines; i++ {
+			if _, err := fmt.Fprintf(w, "synthetic_total{id=\"%v\",padding=\"xxxxxxxxxxxxxxxxxxxx\"} 1\n", i); err != nil {
+				return
+			}
+			atomic.AddInt64(&linesWritten, 1)
+		}
+	}))
+	defer mockhttpserver.Close()
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+
+	c := New(&http.Transport{}, nil) // nolint:exhaustivestruct
+	c.SetMaxResponseBytes(0)
+	c.SetMaxMetrics(1000)
+	got, err := c.ScrapeMetricFamilies(context.Background(), mockhttpserver.URL, "synthetic_total")
+	if err != nil {
+		t.Fatalf("Client.ScrapeMetricFamilies() error = %v", err)
+	}
+	runtime.ReadMemStats(&after)
+
+	if len(got) != 1 || len(got[0].Metrics) != 1000 {
+		t.Fatalf("Client.ScrapeMetricFamilies() = %v families, want 1 family of 1000 metrics", len(got))
+	}
+	// The scrape stops reading, instead of buffering the whole exposition
+	if written := atomic.LoadInt64(&linesWritten); written >= totalLines {
+		t.Errorf("Client.ScrapeMetricFamilies() read %v lines, want the scrape to stop reading", written)
+	}
+	const maxAllocBytes = 128 * 1024 * 1024
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > maxAllocBytes {
+		t.Errorf("Client.ScrapeMetricFamilies() allocated %v bytes, want at most %v", allocated, maxAllocBytes)
+	}
+}
+
+func TestClient_Scrape_maxMetricsProtobuf(t *testing.T) {
+	mockhttpserver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", string(expfmt.FmtProtoDelim))
+		encoder := expfmt.NewEncoder(w, expfmt.FmtProtoDelim)
+		value := float64(1)
+		for family := 0; family < 10; family++ {
+			name := fmt.Sprintf("synthetic_%v", family)
+			mf := &dto.MetricFamily{Name: &name, Type: dto.MetricType_GAUGE.Enum()} // nolint:exhaustivestruct
+			for i := 0; i < 100; i++ {
+				mf.Metric = append(mf.Metric, &dto.Metric{Gauge: &dto.Gauge{Value: &value}}) // nolint:exhaustivestruct
+			}
+			if err := encoder.Encode(mf); err != nil {
+				return
+			}
+		}
+	}))
+	defer mockhttpserver.Close()
+
+	c := New(&http.Transport{}, nil) // nolint:exhaustivestruct
+	c.SetMaxMetrics(250)
+	got, err := c.Scrape(context.Background(), mockhttpserver.URL)
+	if err != nil {
+		t.Fatalf("Client.Scrape() error = %v", err)
+	}
+
+	gotMetrics := []int{}
+	for _, family := range got {
+		gotMetrics = append(gotMetrics, len(family.Metrics))
+	}
+	if want := []int{100, 100, 50}; fmt.Sprint(gotMetrics) != fmt.Sprint(want) {
+		t.Errorf("Client.Scrape() metrics per family = %v, want %v", gotMetrics, want)
+	}
+}
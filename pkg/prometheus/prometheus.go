@@ -17,13 +17,22 @@ package prometheus
 import (
 	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
+	"net/url"
+	"sync"
 	"time"
 
+	"planet-exporter/pkg/bodylimit"
+	"planet-exporter/pkg/httpheader"
+	"planet-exporter/pkg/ratelog"
+
 	dto "github.com/prometheus/client_model/go"
 	"github.com/prometheus/prom2json"
+	log "github.com/sirupsen/logrus"
 )
 
 // TODO: Complete package
@@ -32,13 +41,38 @@ import (
 // Client for Prometheus endpoints.
 type Client struct {
 	httpTransport *http.Transport
+
+	// maxRetries of a scrape that failed with a network-level error, and the backoff before the first retry
+	maxRetries int
+	backoff    time.Duration
+
+	// headers set on every scrape request (e.g. gateway routing headers)
+	headers http.Header
+
+	// maxResponseBytes of a scrape response body, unlimited if zero
+	maxResponseBytes int64
+
+	// maxMetrics processed per scrape, the rest of the scrape is skipped, unlimited if zero
+	maxMetrics int
+	// truncatedLog rate-limits the warnings of truncated scrapes, which repeat on every scrape
+	truncatedLog *ratelog.Limiter
 }
 
+const (
+	// DefaultScrapeMaxRetries is the number of retries of a scrape that failed with a network-level error.
+	DefaultScrapeMaxRetries = 2
+	// DefaultScrapeRetryBackoff is the backoff before the first retry, doubled on every retry.
+	DefaultScrapeRetryBackoff = 100 * time.Millisecond
+)
+
 // New Prometheus client used to consume Prometheus metrics endpoints.
-func New(httpTransport *http.Transport) *Client {
+// When tlsConfig is set (see NewTLSConfig), it replaces the TLS config of httpTransport.
+// Server certificates are verified unless the TLS config explicitly skips verification.
+func New(httpTransport *http.Transport, tlsConfig *tls.Config) *Client {
 	if httpTransport == nil {
 		// Use sane defaults from http.DefaultTransport
 		httpTransport = &http.Transport{ // nolint:exhaustivestruct
+			Proxy: http.ProxyFromEnvironment,
 			DialContext: (&net.Dialer{ // nolint:exhaustivestruct
 				Timeout:   30 * time.Second,
 				KeepAlive: 30 * time.Second,
@@ -47,32 +81,290 @@ func New(httpTransport *http.Transport) *Client {
 			MaxIdleConns:          100,
 			IdleConnTimeout:       90 * time.Second,
 			TLSHandshakeTimeout:   10 * time.Second,
-			TLSClientConfig:       &tls.Config{InsecureSkipVerify: true}, // nolint:gosec,exhaustivestruct
 			ExpectContinueTimeout: 1 * time.Second,
 		}
 	}
+	if tlsConfig != nil {
+		httpTransport = httpTransport.Clone()
+		httpTransport.TLSClientConfig = tlsConfig
+	}
 
 	return &Client{
-		httpTransport: httpTransport,
+		httpTransport:    httpTransport,
+		maxRetries:       DefaultScrapeMaxRetries,
+		backoff:          DefaultScrapeRetryBackoff,
+		maxResponseBytes: bodylimit.DefaultMaxBytes,
+		maxMetrics:       DefaultMaxMetrics,
+		truncatedLog:     ratelog.New(ratelog.DefaultInterval),
 	}
 }
 
+// SetRetry configures how many times a scrape that failed with a network-level error (e.g. connection reset)
+// is retried, and the backoff before the first retry which is doubled on every retry.
+// Set maxRetries to zero to disable retries.
+func (c *Client) SetRetry(maxRetries int, backoff time.Duration) {
+	c.maxRetries = maxRetries
+	c.backoff = backoff
+}
+
+// SetProxy configures the proxy of scrape requests (see httpproxy.Func), scrapes don't use a proxy if it's nil.
+func (c *Client) SetProxy(proxy func(*http.Request) (*url.URL, error)) {
+	c.httpTransport = c.httpTransport.Clone()
+	c.httpTransport.Proxy = proxy
+}
+
+// SetHeaders configures custom headers that are set on every scrape request.
+func (c *Client) SetHeaders(headers http.Header) {
+	c.headers = headers
+}
+
+// SetMaxResponseBytes configures the maximum size of a scrape response body, larger responses fail the scrape.
+// Set maxBytes to zero for no limit.
+func (c *Client) SetMaxResponseBytes(maxBytes int64) {
+	c.maxResponseBytes = maxBytes
+}
+
+// SetMaxMetrics configures the maximum number of metrics processed per scrape, including the metrics of discarded
+// metric families. A scrape over the limit stops reading the response, and returns the metrics processed so far.
+// Set maxMetrics to zero for no limit.
+func (c *Client) SetMaxMetrics(maxMetrics int) {
+	c.maxMetrics = maxMetrics
+}
+
 // Scrape metrics from a Prometheus HTTP endpoint.
 func (c *Client) Scrape(ctx context.Context, url string) ([]*prom2json.Family, error) {
-	var err error
+	return c.scrape(ctx, url, func(string) bool { return true })
+}
+
+// ScrapeMetricFamilies scrapes only the wanted metric families from a Prometheus HTTP endpoint.
+// Other metric families are discarded as they're parsed, without being converted into prom2json.Family,
+// so only the wanted metric families are held in memory.
+func (c *Client) ScrapeMetricFamilies(ctx context.Context, url string, wantedNames ...string) ([]*prom2json.Family, error) {
+	wanted := make(map[string]bool, len(wantedNames))
+	for _, name := range wantedNames {
+		wanted[name] = true
+	}
+
+	return c.scrape(ctx, url, func(name string) bool { return wanted[name] })
+}
+
+// scrape metrics from a Prometheus HTTP endpoint, keeping metric families whose name is accepted by the filter.
+// Scrapes that failed with a network-level error are retried with backoff, discarding their partial results.
+// The health of the scrape is recorded for GetTargetHealth.
+func (c *Client) scrape(ctx context.Context, url string, filter func(name string) bool) ([]*prom2json.Family, error) {
+	startTime := time.Now()
+	result, err := c.scrapeWithRetries(ctx, url, filter)
+	recordTargetHealth(url, time.Since(startTime), err == nil)
+
+	return result, err
+}
+
+// scrapeWithRetries scrapes metrics from a Prometheus HTTP endpoint, retrying network-level errors with backoff.
+func (c *Client) scrapeWithRetries(ctx context.Context, url string, filter func(name string) bool) ([]*prom2json.Family, error) {
+	backoff := c.backoff
+	for retry := 0; ; retry++ {
+		result, err := c.scrapeOnce(ctx, url, filter)
+		if err == nil {
+			return result, nil
+		}
+		var netErr networkError
+		if !errors.As(err, &netErr) || retry >= c.maxRetries {
+			return nil, err
+		}
+
+		log.Debugf("Retry scrape of %v in %v after a network error: %v", url, backoff, netErr.cause)
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+
+			return nil, err
+		case <-timer.C:
+		}
+		backoff *= 2
+	}
+}
+
+// networkError is a scrape error caused by a network-level error, which is worth retrying.
+type networkError struct {
+	error
+	cause error
+}
 
+func (e networkError) Unwrap() error {
+	return e.error
+}
+
+// scrapeOnce scrapes metrics from a Prometheus HTTP endpoint once.
+// The error is a networkError if the scrape failed due to a network-level error.
+func (c *Client) scrapeOnce(ctx context.Context, url string, filter func(name string) bool) ([]*prom2json.Family, error) {
 	const metricsFamiliesCapacity = 1024
 
-	mfChan := make(chan *dto.MetricFamily, metricsFamiliesCapacity)
-	err = prom2json.FetchMetricFamilies(url, mfChan, c.httpTransport)
-	if err != nil {
-		return nil, fmt.Errorf("error fetching metric families: %w", err)
+	// Cancelling stops reading the response once the scrape is truncated
+	fetchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	transport := &networkErrorRecorder{ // nolint:exhaustivestruct
+		ctx:              fetchCtx,
+		headers:          c.headers,
+		maxResponseBytes: c.maxResponseBytes,
+		maxMetrics:       c.maxMetrics,
+		next:             c.httpTransport,
 	}
 
+	// FetchMetricFamilies closes the channel when it's done, so metric families are consumed while they're parsed
+	mfChan := make(chan *dto.MetricFamily, metricsFamiliesCapacity)
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- prom2json.FetchMetricFamilies(url, mfChan, transport)
+	}()
+
 	result := []*prom2json.Family{}
+	processed := 0
+	truncated := false
 	for mf := range mfChan {
-		result = append(result, prom2json.NewFamily(mf))
+		// Drain the metric families parsed before the fetch stopped
+		if truncated {
+			continue
+		}
+		// Protobuf responses are streamed one metric family at a time, so they're truncated here
+		if c.maxMetrics > 0 && processed+len(mf.Metric) > c.maxMetrics {
+			mf.Metric = mf.Metric[:c.maxMetrics-processed]
+			truncated = true
+			cancel()
+		}
+		processed += len(mf.Metric)
+		if len(mf.Metric) > 0 && filter(mf.GetName()) {
+			result = append(result, prom2json.NewFamily(mf))
+		}
+	}
+
+	err := <-errChan
+	if truncated || transport.Truncated() {
+		c.truncatedLog.Warnf("Truncated scrape of %v after %v metrics, over the maximum metrics per scrape", url, processed)
+
+		return result, nil
+	}
+	if err != nil {
+		// prom2json doesn't wrap the body read error
+		if transport.TooLarge() {
+			return nil, fmt.Errorf("error fetching metric families: %w", bodylimit.ErrTooLarge)
+		}
+		// Nor the context error, so a scrape that timed out can be told apart
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, fmt.Errorf("error fetching metric families: %w", ctxErr)
+		}
+		err = fmt.Errorf("error fetching metric families: %w", err)
+		// An untrusted certificate won't be trusted on retry either
+		var certErr *tls.CertificateVerificationError
+		if errors.As(transport.Err(), &certErr) {
+			return nil, fmt.Errorf("%w: %v", ErrUntrustedCertificate, certErr)
+		}
+		if cause := transport.Err(); cause != nil {
+			return nil, networkError{error: err, cause: cause}
+		}
+
+		return nil, err
 	}
 
 	return result, nil
 }
+
+// networkErrorRecorder is an http.RoundTripper that binds requests to a context and custom headers, and records
+// the network-level error of a request or of reading its response body, since prom2json doesn't expose them.
+// Response bodies are limited to maxResponseBytes, and text exposition bodies to maxMetrics sample lines, if positive.
+type networkErrorRecorder struct {
+	ctx              context.Context // nolint:containedctx
+	headers          http.Header
+	maxResponseBytes int64
+	maxMetrics       int
+	next             http.RoundTripper
+
+	mu        sync.Mutex
+	err       error
+	tooLarge  bool
+	truncated bool
+}
+
+// RoundTrip implements http.RoundTripper.
+func (r *networkErrorRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(r.ctx)
+	httpheader.Apply(req, r.headers)
+
+	resp, err := r.next.RoundTrip(req)
+	if err != nil {
+		r.record(err)
+
+		return nil, err
+	}
+	limited := bodylimit.NewReader(resp.Body, r.maxResponseBytes)
+	if !isProtobufResponse(resp.Header.Get("Content-Type")) {
+		limited = newSampleLineLimiter(limited, r.maxMetrics, r.markTruncated)
+	}
+	resp.Body = &networkErrorRecorderBody{
+		ReadCloser: resp.Body,
+		limited:    limited,
+		recorder:   r,
+	}
+
+	return resp, nil
+}
+
+// Err returns the recorded network-level error.
+func (r *networkErrorRecorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.err
+}
+
+// TooLarge returns whether a response body exceeded maxResponseBytes.
+func (r *networkErrorRecorder) TooLarge() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.tooLarge
+}
+
+// Truncated returns whether a text exposition body was truncated at maxMetrics sample lines.
+func (r *networkErrorRecorder) Truncated() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.truncated
+}
+
+func (r *networkErrorRecorder) markTruncated() {
+	r.mu.Lock()
+	r.truncated = true
+	r.mu.Unlock()
+}
+
+func (r *networkErrorRecorder) record(err error) {
+	r.mu.Lock()
+	if r.err == nil {
+		r.err = err
+	}
+	r.mu.Unlock()
+}
+
+// networkErrorRecorderBody records errors reading a response body, other than io.EOF.
+// Exceeding the body limit isn't a network-level error, so it's recorded separately.
+type networkErrorRecorderBody struct {
+	io.ReadCloser
+	limited  io.Reader
+	recorder *networkErrorRecorder
+}
+
+func (b *networkErrorRecorderBody) Read(p []byte) (int, error) {
+	n, err := b.limited.Read(p)
+	switch {
+	case errors.Is(err, bodylimit.ErrTooLarge):
+		b.recorder.mu.Lock()
+		b.recorder.tooLarge = true
+		b.recorder.mu.Unlock()
+	case err != nil && !errors.Is(err, io.EOF):
+		b.recorder.record(err)
+	}
+
+	return n, err
+}
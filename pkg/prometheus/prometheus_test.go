@@ -16,18 +16,23 @@ package prometheus
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"planet-exporter/pkg/bodylimit"
 
 	"github.com/prometheus/prom2json"
 	"github.com/stretchr/testify/assert"
 )
 
-func TestClient_Scrape(t *testing.T) {
-	// nolint:lll
-	mockScrapeResponse := `
+// nolint:lll
+const mockScrapeResponse = `
 # HELP test_metric A metric for unit-test.
 # TYPE test_metric gauge
 test_metric{label_a="a",label_b="b"} 1
@@ -56,8 +61,9 @@ request_duration_bucket{le="10.0",} 3.0
 request_duration_bucket{le="+Inf",} 3.0
 request_duration_count 3.0
 request_duration_sum 22.978489699999997
-	`
+`
 
+func TestClient_Scrape(t *testing.T) {
 	mockhttpserver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		fmt.Fprint(w, mockScrapeResponse)
 	}))
@@ -163,7 +169,7 @@ request_duration_sum 22.978489699999997
 
 	for _, testcase := range tests {
 		t.Run(testcase.name, func(t *testing.T) {
-			c := New(testcase.fields.httpTransport)
+			c := New(testcase.fields.httpTransport, nil)
 			got, err := c.Scrape(testcase.args.ctx, testcase.args.url)
 			if (err != nil) != testcase.wantErr {
 				t.Errorf("Client.Scrape() error = %v, wantErr %v", err, testcase.wantErr)
@@ -174,3 +180,211 @@ request_duration_sum 22.978489699999997
 		})
 	}
 }
+
+func TestClient_ScrapeMetricFamilies(t *testing.T) {
+	mockhttpserver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, mockScrapeResponse)
+	}))
+	defer mockhttpserver.Close()
+
+	tests := []struct {
+		name        string
+		wantedNames []string
+		want        []string
+	}{
+		{
+			name:        "Scrape a single metric family",
+			wantedNames: []string{"planet_upstream"},
+			want:        []string{"planet_upstream"},
+		},
+		{
+			name:        "Scrape multiple metric families",
+			wantedNames: []string{"test_metric", "request_duration"},
+			want:        []string{"test_metric", "request_duration"},
+		},
+		{
+			name:        "Scrape a missing metric family",
+			wantedNames: []string{"host_bytes_total"},
+			want:        []string{},
+		},
+	}
+
+	assert := assert.New(t)
+
+	for _, testcase := range tests {
+		t.Run(testcase.name, func(t *testing.T) {
+			c := New(&http.Transport{}, nil) // nolint:exhaustivestruct
+			got, err := c.ScrapeMetricFamilies(context.Background(), mockhttpserver.URL, testcase.wantedNames...)
+			if err != nil {
+				t.Errorf("Client.ScrapeMetricFamilies() error = %v", err)
+
+				return
+			}
+			gotNames := []string{}
+			for _, family := range got {
+				gotNames = append(gotNames, family.Name)
+			}
+			assert.ElementsMatch(gotNames, testcase.want)
+		})
+	}
+}
+
+func TestClient_ScrapeMetricFamilies_error(t *testing.T) {
+	mockhttpserver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer mockhttpserver.Close()
+
+	c := New(&http.Transport{}, nil) // nolint:exhaustivestruct
+	if _, err := c.ScrapeMetricFamilies(context.Background(), mockhttpserver.URL, "test_metric"); err == nil {
+		t.Errorf("Client.ScrapeMetricFamilies() error = nil, want error")
+	}
+}
+
+func TestClient_Scrape_headers(t *testing.T) {
+	var gotTenant, gotAccept string
+	mockhttpserver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotTenant = r.Header.Get("X-Tenant-ID")
+		gotAccept = r.Header.Get("Accept")
+		fmt.Fprint(w, mockScrapeResponse)
+	}))
+	defer mockhttpserver.Close()
+
+	c := New(&http.Transport{}, nil) // nolint:exhaustivestruct
+	c.SetHeaders(http.Header{"X-Tenant-Id": {"tenant-a"}})
+	if _, err := c.Scrape(context.Background(), mockhttpserver.URL); err != nil {
+		t.Fatalf("Client.Scrape() error = %v", err)
+	}
+	if gotTenant != "tenant-a" {
+		t.Errorf("Client.Scrape() X-Tenant-ID header = %v, want %v", gotTenant, "tenant-a")
+	}
+	if gotAccept == "" {
+		t.Errorf("Client.Scrape() Accept header is empty, want prom2json's Accept header")
+	}
+}
+
+func TestClient_Scrape_proxy(t *testing.T) {
+	var gotRequestURI string
+	mockproxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotRequestURI = r.RequestURI
+		fmt.Fprint(w, mockScrapeResponse)
+	}))
+	defer mockproxy.Close()
+	proxyURL, err := url.Parse(mockproxy.URL)
+	if err != nil {
+		t.Fatalf("url.Parse() error = %v", err)
+	}
+
+	c := New(&http.Transport{}, nil) // nolint:exhaustivestruct
+	c.SetProxy(http.ProxyURL(proxyURL))
+	if _, err := c.Scrape(context.Background(), "http://darkstat.internal:51666/metrics"); err != nil {
+		t.Fatalf("Client.Scrape() error = %v", err)
+	}
+	// Requests through an HTTP proxy carry the absolute target URL
+	if want := "http://darkstat.internal:51666/metrics"; gotRequestURI != want {
+		t.Errorf("Client.Scrape() proxied request URI = %v, want %v", gotRequestURI, want)
+	}
+}
+
+func TestClient_Scrape_maxResponseBytes(t *testing.T) {
+	var requests int32
+	mockhttpserver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		fmt.Fprint(w, mockScrapeResponse)
+	}))
+	defer mockhttpserver.Close()
+
+	c := New(&http.Transport{}, nil) // nolint:exhaustivestruct
+	c.SetMaxResponseBytes(int64(len(mockScrapeResponse) / 2))
+	_, err := c.Scrape(context.Background(), mockhttpserver.URL)
+	if !errors.Is(err, bodylimit.ErrTooLarge) {
+		t.Errorf("Client.Scrape() error = %v, want %v", err, bodylimit.ErrTooLarge)
+	}
+	// An oversized response isn't a network-level error worth retrying
+	if got := atomic.LoadInt32(&requests); got != 1 {
+		t.Errorf("Client.Scrape() requests = %v, want 1", got)
+	}
+
+	c.SetMaxResponseBytes(int64(len(mockScrapeResponse)))
+	if _, err := c.Scrape(context.Background(), mockhttpserver.URL); err != nil {
+		t.Errorf("Client.Scrape() error = %v", err)
+	}
+}
+
+// mockFlakyServer returns a server that drops the connection of the first failures requests, then serves
+// the mock scrape response with the given status code.
+func mockFlakyServer(t *testing.T, failures int, statusCode int, requests *int32) *httptest.Server {
+	t.Helper()
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if int(atomic.AddInt32(requests, 1)) <= failures {
+			hijacker, ok := w.(http.Hijacker)
+			if !ok {
+				t.Fatalf("http.ResponseWriter is not an http.Hijacker")
+			}
+			conn, _, err := hijacker.Hijack()
+			if err != nil {
+				t.Fatalf("Hijack() error = %v", err)
+			}
+			conn.Close()
+
+			return
+		}
+		w.WriteHeader(statusCode)
+		fmt.Fprint(w, mockScrapeResponse)
+	}))
+}
+
+func TestClient_Scrape_retry(t *testing.T) {
+	tests := []struct {
+		name         string
+		failures     int
+		statusCode   int
+		maxRetries   int
+		wantRequests int32
+		wantErr      bool
+	}{
+		{name: "Succeeds after network errors", failures: 2, statusCode: http.StatusOK, maxRetries: 2, wantRequests: 3},
+		{name: "Gives up after max retries", failures: 3, statusCode: http.StatusOK, maxRetries: 2, wantRequests: 3, wantErr: true},
+		{name: "Retries disabled", failures: 1, statusCode: http.StatusOK, maxRetries: 0, wantRequests: 1, wantErr: true},
+		{name: "HTTP errors are not retried", failures: 0, statusCode: http.StatusInternalServerError, maxRetries: 2, wantRequests: 1, wantErr: true},
+	}
+	for _, testcase := range tests {
+		t.Run(testcase.name, func(t *testing.T) {
+			var requests int32
+			server := mockFlakyServer(t, testcase.failures, testcase.statusCode, &requests)
+			defer server.Close()
+
+			c := New(&http.Transport{}, nil) // nolint:exhaustivestruct
+			c.SetRetry(testcase.maxRetries, time.Millisecond)
+			got, err := c.Scrape(context.Background(), server.URL)
+			if (err != nil) != testcase.wantErr {
+				t.Fatalf("Client.Scrape() error = %v, wantErr %v", err, testcase.wantErr)
+			}
+			if !testcase.wantErr && len(got) != 3 {
+				t.Errorf("Client.Scrape() got %v families, want 3", len(got))
+			}
+			if got := atomic.LoadInt32(&requests); got != testcase.wantRequests {
+				t.Errorf("Client.Scrape() requests = %v, want %v", got, testcase.wantRequests)
+			}
+		})
+	}
+}
+
+func TestClient_Scrape_retryCancelled(t *testing.T) {
+	var requests int32
+	server := mockFlakyServer(t, 10, http.StatusOK, &requests)
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	c := New(&http.Transport{}, nil) // nolint:exhaustivestruct
+	c.SetRetry(10, time.Hour)
+	if _, err := c.Scrape(ctx, server.URL); err == nil {
+		t.Errorf("Client.Scrape() error = nil, want error")
+	}
+	if got := atomic.LoadInt32(&requests); got != 1 {
+		t.Errorf("Client.Scrape() requests = %v, want 1 before the context is done", got)
+	}
+}
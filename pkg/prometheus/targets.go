@@ -0,0 +1,84 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus
+
+import (
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+)
+
+// TargetHealth of the latest scrape of a target.
+type TargetHealth struct {
+	// Target is the scraped URL, without its password
+	Target string
+	// Duration of the scrape, including its retries
+	Duration time.Duration
+	// Up is whether the scrape succeeded, a truncated scrape still succeeds
+	Up bool
+}
+
+// targetHealth of the latest scrape of every target scraped by any Client.
+var targetHealth = struct {
+	mu      sync.Mutex
+	targets map[string]TargetHealth
+}{
+	mu:      sync.Mutex{},
+	targets: make(map[string]TargetHealth),
+}
+
+// recordTargetHealth records the latest scrape of a target.
+func recordTargetHealth(target string, duration time.Duration, up bool) {
+	target = RedactURL(target)
+
+	targetHealth.mu.Lock()
+	targetHealth.targets[target] = TargetHealth{
+		Target:   target,
+		Duration: duration,
+		Up:       up,
+	}
+	targetHealth.mu.Unlock()
+}
+
+// GetTargetHealth returns the health of the latest scrape of every target scraped so far, sorted by target.
+func GetTargetHealth() []TargetHealth {
+	targetHealth.mu.Lock()
+	targets := make([]TargetHealth, 0, len(targetHealth.targets))
+	for _, health := range targetHealth.targets {
+		targets = append(targets, health)
+	}
+	targetHealth.mu.Unlock()
+
+	sort.Slice(targets, func(i, j int) bool {
+		return targets[i].Target < targets[j].Target
+	})
+
+	return targets
+}
+
+// RedactURL replaces the password of a URL (e.g. a target or proxy URL), so it isn't exposed in metric labels or
+// printed configs. Values that aren't URLs with a password are returned as is.
+func RedactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.User == nil {
+		return rawURL
+	}
+	if _, ok := u.User.Password(); !ok {
+		return rawURL
+	}
+
+	return u.Redacted()
+}
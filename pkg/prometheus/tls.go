@@ -0,0 +1,111 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// TLSOptions of scrapes over HTTPS.
+type TLSOptions struct {
+	// CAFile is a PEM bundle of CAs trusted in addition to the system roots
+	CAFile string
+	// CertFile and KeyFile are a PEM client certificate and key, both or neither must be set
+	CertFile string
+	KeyFile  string
+	// InsecureSkipVerify explicitly opts in to skip verifying server certificates
+	InsecureSkipVerify bool
+}
+
+var (
+	// ErrIncompleteClientCert only one of the client certificate and key is set.
+	ErrIncompleteClientCert = errors.New("client certificate and key must be set together")
+	// ErrNoCACerts CA file doesn't contain any PEM certificate.
+	ErrNoCACerts = errors.New("no PEM certificate found in CA file")
+	// ErrUntrustedCertificate scrape target's certificate can't be verified.
+	ErrUntrustedCertificate = errors.New("scrape target certificate can't be verified, provide the CA that signed it or explicitly opt in to skip verification")
+)
+
+// NewTLSConfig returns the TLS config of scrapes over HTTPS.
+func NewTLSConfig(opts TLSOptions) (*tls.Config, error) {
+	tlsConfig := &tls.Config{ // nolint:exhaustivestruct
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: opts.InsecureSkipVerify, // nolint:gosec
+	}
+
+	if opts.CAFile != "" {
+		rootCAs, err := x509.SystemCertPool()
+		if err != nil {
+			rootCAs = x509.NewCertPool()
+		}
+		caPEM, err := os.ReadFile(opts.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading CA file: %w", err)
+		}
+		if !rootCAs.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("%w %v", ErrNoCACerts, opts.CAFile)
+		}
+		tlsConfig.RootCAs = rootCAs
+	}
+
+	certificates, err := loadClientCert(opts.CertFile, opts.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig.Certificates = certificates
+
+	return tlsConfig, nil
+}
+
+// WithClientCert returns a copy of tlsConfig presenting the PEM client certificate and key instead of its own (e.g. a
+// scrape target requiring another certificate than the other targets), or tlsConfig itself if both are empty.
+func WithClientCert(tlsConfig *tls.Config, certFile, keyFile string) (*tls.Config, error) {
+	if certFile == "" && keyFile == "" {
+		return tlsConfig, nil
+	}
+	certificates, err := loadClientCert(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{MinVersion: tls.VersionTLS12} // nolint:exhaustivestruct
+	} else {
+		tlsConfig = tlsConfig.Clone()
+	}
+	tlsConfig.Certificates = certificates
+
+	return tlsConfig, nil
+}
+
+// loadClientCert loads a PEM client certificate and key, none if both are empty.
+func loadClientCert(certFile, keyFile string) ([]tls.Certificate, error) {
+	if (certFile == "") != (keyFile == "") {
+		return nil, ErrIncompleteClientCert
+	}
+	if certFile == "" {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("error loading client certificate: %w", err)
+	}
+
+	return []tls.Certificate{cert}, nil
+}
@@ -0,0 +1,242 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// writeClientCert writes a self-signed PEM client certificate and key of commonName into dir, and returns their paths.
+func writeClientCert(t *testing.T, dir, commonName string) (string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("ecdsa.GenerateKey() error = %v", err)
+	}
+	template := &x509.Certificate{ // nolint:exhaustivestruct
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName}, // nolint:exhaustivestruct
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("x509.CreateCertificate() error = %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("x509.MarshalECPrivateKey() error = %v", err)
+	}
+
+	certFile := filepath.Join(dir, commonName+".pem")
+	keyFile := filepath.Join(dir, commonName+"-key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil { // nolint:exhaustivestruct
+		t.Fatalf("os.WriteFile() error = %v", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil { // nolint:exhaustivestruct
+		t.Fatalf("os.WriteFile() error = %v", err)
+	}
+
+	return certFile, keyFile
+}
+
+func TestClient_Scrape_tls(t *testing.T) {
+	var requests int32
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		fmt.Fprint(w, mockScrapeResponse)
+	}))
+	defer server.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}) // nolint:exhaustivestruct
+	if err := os.WriteFile(caFile, caPEM, 0o600); err != nil {
+		t.Fatalf("os.WriteFile() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		opts    TLSOptions
+		wantErr error
+	}{
+		{name: "Untrusted certificate", opts: TLSOptions{}, wantErr: ErrUntrustedCertificate},            // nolint:exhaustivestruct
+		{name: "Trusted with CA file", opts: TLSOptions{CAFile: caFile}, wantErr: nil},                   // nolint:exhaustivestruct
+		{name: "Explicitly skip verification", opts: TLSOptions{InsecureSkipVerify: true}, wantErr: nil}, // nolint:exhaustivestruct
+	}
+	for _, testcase := range tests {
+		t.Run(testcase.name, func(t *testing.T) {
+			tlsConfig, err := NewTLSConfig(testcase.opts)
+			if err != nil {
+				t.Fatalf("NewTLSConfig() error = %v", err)
+			}
+			c := New(&http.Transport{}, tlsConfig) // nolint:exhaustivestruct
+			c.SetRetry(3, time.Millisecond)
+
+			atomic.StoreInt32(&requests, 0)
+			_, err = c.Scrape(context.Background(), server.URL)
+			if !errors.Is(err, testcase.wantErr) {
+				t.Fatalf("Client.Scrape() error = %v, want %v", err, testcase.wantErr)
+			}
+			// An untrusted certificate fails the handshake without being retried
+			if testcase.wantErr != nil && atomic.LoadInt32(&requests) != 0 {
+				t.Errorf("Client.Scrape() requests = %v, want 0", atomic.LoadInt32(&requests))
+			}
+		})
+	}
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	emptyFile := filepath.Join(t.TempDir(), "empty.pem")
+	if err := os.WriteFile(emptyFile, nil, 0o600); err != nil {
+		t.Fatalf("os.WriteFile() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		opts    TLSOptions
+		wantErr error
+	}{
+		{name: "Default verifies with system CAs", opts: TLSOptions{}, wantErr: nil},                                      // nolint:exhaustivestruct
+		{name: "CA file without certificates", opts: TLSOptions{CAFile: emptyFile}, wantErr: ErrNoCACerts},                // nolint:exhaustivestruct
+		{name: "Client certificate without key", opts: TLSOptions{CertFile: emptyFile}, wantErr: ErrIncompleteClientCert}, // nolint:exhaustivestruct
+	}
+	for _, testcase := range tests {
+		t.Run(testcase.name, func(t *testing.T) {
+			got, err := NewTLSConfig(testcase.opts)
+			if !errors.Is(err, testcase.wantErr) {
+				t.Fatalf("NewTLSConfig() error = %v, want %v", err, testcase.wantErr)
+			}
+			if err == nil && got.InsecureSkipVerify {
+				t.Errorf("NewTLSConfig() InsecureSkipVerify = %v, want false", got.InsecureSkipVerify)
+			}
+		})
+	}
+}
+
+func TestWithClientCert(t *testing.T) {
+	dir := t.TempDir()
+	sharedCert, sharedKey := writeClientCert(t, dir, "shared")
+	darkstatCert, darkstatKey := writeClientCert(t, dir, "darkstat")
+
+	shared, err := NewTLSConfig(TLSOptions{CertFile: sharedCert, KeyFile: sharedKey}) // nolint:exhaustivestruct
+	if err != nil {
+		t.Fatalf("NewTLSConfig() error = %v", err)
+	}
+
+	tests := []struct {
+		name           string
+		tlsConfig      *tls.Config
+		certFile       string
+		keyFile        string
+		wantCommonName string
+		wantErr        error
+	}{
+		{name: "Shared certificate", tlsConfig: shared, wantCommonName: "shared", wantErr: nil},
+		{name: "Task certificate", tlsConfig: shared, certFile: darkstatCert, keyFile: darkstatKey, wantCommonName: "darkstat", wantErr: nil},
+		{name: "Task certificate without a TLS config", tlsConfig: nil, certFile: darkstatCert, keyFile: darkstatKey, wantCommonName: "darkstat", wantErr: nil},
+		{name: "Certificate without key", tlsConfig: shared, certFile: darkstatCert, wantErr: ErrIncompleteClientCert},
+	}
+	for _, testcase := range tests {
+		t.Run(testcase.name, func(t *testing.T) {
+			tlsConfig, err := WithClientCert(testcase.tlsConfig, testcase.certFile, testcase.keyFile)
+			if !errors.Is(err, testcase.wantErr) {
+				t.Fatalf("WithClientCert() error = %v, want %v", err, testcase.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			// The certificate is loaded into the transport of the scrapes
+			certificates := New(nil, tlsConfig).httpTransport.TLSClientConfig.Certificates
+			if len(certificates) != 1 {
+				t.Fatalf("WithClientCert() transport certificates = %v, want 1", len(certificates))
+			}
+			if got := commonName(t, certificates[0]); got != testcase.wantCommonName {
+				t.Errorf("WithClientCert() transport certificate = %v, want %v", got, testcase.wantCommonName)
+			}
+		})
+	}
+	if got := commonName(t, shared.Certificates[0]); got != "shared" {
+		t.Errorf("WithClientCert() modified the shared TLS config certificate to %v", got)
+	}
+}
+
+// commonName returns the subject common name of a certificate.
+func commonName(t *testing.T, certificate tls.Certificate) string {
+	t.Helper()
+
+	cert, err := x509.ParseCertificate(certificate.Certificate[0])
+	if err != nil {
+		t.Fatalf("x509.ParseCertificate() error = %v", err)
+	}
+
+	return cert.Subject.CommonName
+}
+
+func TestClient_Scrape_mTLS(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, mockScrapeResponse)
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert} // nolint:exhaustivestruct,gosec
+	server.StartTLS()
+	defer server.Close()
+
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.pem")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}) // nolint:exhaustivestruct
+	if err := os.WriteFile(caFile, caPEM, 0o600); err != nil {
+		t.Fatalf("os.WriteFile() error = %v", err)
+	}
+	certFile, keyFile := writeClientCert(t, dir, "darkstat")
+
+	serverVerified, err := NewTLSConfig(TLSOptions{CAFile: caFile}) // nolint:exhaustivestruct
+	if err != nil {
+		t.Fatalf("NewTLSConfig() error = %v", err)
+	}
+	c := New(&http.Transport{}, serverVerified) // nolint:exhaustivestruct
+	c.SetRetry(1, time.Millisecond)
+	if _, err := c.Scrape(context.Background(), server.URL); err == nil {
+		t.Errorf("Client.Scrape() without a client certificate error = nil, want an error")
+	}
+
+	mTLS, err := WithClientCert(serverVerified, certFile, keyFile)
+	if err != nil {
+		t.Fatalf("WithClientCert() error = %v", err)
+	}
+	c = New(&http.Transport{}, mTLS) // nolint:exhaustivestruct
+	c.SetRetry(1, time.Millisecond)
+	if _, err := c.Scrape(context.Background(), server.URL); err != nil {
+		t.Errorf("Client.Scrape() with a client certificate error = %v", err)
+	}
+}
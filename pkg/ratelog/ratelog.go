@@ -0,0 +1,117 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package ratelog rate-limits recurring log messages, e.g. a task that fails on every collect tick.
+package ratelog
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultInterval is the minimum time between two logged occurrences of a recurring message.
+const DefaultInterval = 5 * time.Minute
+
+// Limiter logs the first occurrence of a recurring message, then at most once per interval
+// with the number of occurrences suppressed in between.
+type Limiter struct {
+	mu         sync.Mutex
+	interval   time.Duration
+	lastLogged time.Time
+	suppressed int
+	occurring  bool
+
+	// now is replaced in tests
+	now func() time.Time
+}
+
+// New returns a Limiter that logs at most once per interval.
+func New(interval time.Duration) *Limiter {
+	return &Limiter{
+		mu:         sync.Mutex{},
+		interval:   interval,
+		lastLogged: time.Time{},
+		suppressed: 0,
+		occurring:  false,
+		now:        time.Now,
+	}
+}
+
+// Errorf logs an error-level message unless it's suppressed.
+func (l *Limiter) Errorf(format string, args ...interface{}) {
+	if msg, ok := l.message(format, args...); ok {
+		log.Error(msg)
+	}
+}
+
+// Warnf logs a warning-level message unless it's suppressed.
+func (l *Limiter) Warnf(format string, args ...interface{}) {
+	if msg, ok := l.message(format, args...); ok {
+		log.Warn(msg)
+	}
+}
+
+// Reset marks the recurring condition as recovered so its next occurrence is logged immediately.
+// It returns true if an occurrence was seen since the last Reset.
+func (l *Limiter) Reset() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	occurring := l.occurring
+	l.lastLogged = time.Time{}
+	l.suppressed = 0
+	l.occurring = false
+
+	return occurring
+}
+
+// message formats the message of an occurrence, and whether it should be logged.
+func (l *Limiter) message(format string, args ...interface{}) (string, bool) {
+	logged, suppressed := l.allow()
+	if !logged {
+		return "", false
+	}
+
+	msg := fmt.Sprintf(format, args...)
+	if suppressed > 0 {
+		msg = fmt.Sprintf("%v (suppressed %v similar messages in the last %v)", msg, suppressed, l.interval)
+	}
+
+	return msg, true
+}
+
+// allow records an occurrence and returns whether it should be logged along with the number
+// of occurrences suppressed since the last logged one.
+func (l *Limiter) allow() (bool, int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.occurring = true
+
+	now := l.now()
+	if !l.lastLogged.IsZero() && now.Sub(l.lastLogged) < l.interval {
+		l.suppressed++
+
+		return false, 0
+	}
+
+	suppressed := l.suppressed
+	l.lastLogged = now
+	l.suppressed = 0
+
+	return true, suppressed
+}
@@ -0,0 +1,127 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ratelog
+
+import (
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+func TestLimiter_suppression(t *testing.T) {
+	hook := test.NewGlobal()
+	defer hook.Reset()
+
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	limiter := New(time.Minute)
+	limiter.now = func() time.Time { return now }
+
+	tests := []struct {
+		name    string
+		advance time.Duration
+		wantMsg string
+	}{
+		{
+			name:    "First occurrence is logged",
+			advance: 0,
+			wantMsg: "darkstat down",
+		},
+		{
+			name:    "Suppressed within interval",
+			advance: 20 * time.Second,
+			wantMsg: "",
+		},
+		{
+			name:    "Suppressed until interval elapsed",
+			advance: 20 * time.Second,
+			wantMsg: "",
+		},
+		{
+			name:    "Logged with suppressed count after interval",
+			advance: 20 * time.Second,
+			wantMsg: "darkstat down (suppressed 2 similar messages in the last 1m0s)",
+		},
+		{
+			name:    "Suppressed again after logging",
+			advance: 59 * time.Second,
+			wantMsg: "",
+		},
+		{
+			name:    "Logged once per interval",
+			advance: time.Second,
+			wantMsg: "darkstat down (suppressed 1 similar messages in the last 1m0s)",
+		},
+	}
+	for _, testcase := range tests {
+		t.Run(testcase.name, func(t *testing.T) {
+			hook.Reset()
+			now = now.Add(testcase.advance)
+
+			limiter.Errorf("darkstat %v", "down")
+
+			gotMsg := ""
+			if entry := hook.LastEntry(); entry != nil {
+				gotMsg = entry.Message
+				if entry.Level != log.ErrorLevel {
+					t.Errorf("Limiter.Errorf() level = %v, want %v", entry.Level, log.ErrorLevel)
+				}
+			}
+			if gotMsg != testcase.wantMsg {
+				t.Errorf("Limiter.Errorf() message = %q, want %q", gotMsg, testcase.wantMsg)
+			}
+		})
+	}
+}
+
+func TestLimiter_Reset(t *testing.T) {
+	hook := test.NewGlobal()
+	defer hook.Reset()
+
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	limiter := New(time.Minute)
+	limiter.now = func() time.Time { return now }
+
+	if got := limiter.Reset(); got {
+		t.Errorf("Limiter.Reset() = %v, want %v", got, false)
+	}
+
+	limiter.Warnf("inventory down")
+	now = now.Add(time.Second)
+	limiter.Warnf("inventory down")
+	limiter.Warnf("inventory down")
+	if got := len(hook.AllEntries()); got != 1 {
+		t.Fatalf("Limiter.Warnf() logged %v entries, want %v", got, 1)
+	}
+
+	if got := limiter.Reset(); !got {
+		t.Errorf("Limiter.Reset() = %v, want %v", got, true)
+	}
+
+	// The next occurrence after recovery is logged immediately without the previous suppressed count
+	now = now.Add(time.Second)
+	limiter.Warnf("inventory down")
+	entries := hook.AllEntries()
+	if len(entries) != 2 {
+		t.Fatalf("Limiter.Warnf() logged %v entries, want %v", len(entries), 2)
+	}
+	if got, want := entries[1].Message, "inventory down"; got != want {
+		t.Errorf("Limiter.Warnf() message = %q, want %q", got, want)
+	}
+	if got, want := entries[1].Level, log.WarnLevel; got != want {
+		t.Errorf("Limiter.Warnf() level = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,32 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package shutdown relays the signals requesting a graceful shutdown, which differ per platform (e.g. SIGTERM is
+// only sent by process managers on unix).
+package shutdown
+
+import (
+	"os"
+	"os/signal"
+)
+
+// Signals returns the signals requesting a graceful shutdown on this platform, os.Interrupt (Ctrl+C) on every platform.
+func Signals() []os.Signal {
+	return append([]os.Signal{os.Interrupt}, platformSignals...)
+}
+
+// Notify relays the shutdown signals to c, like signal.Notify.
+func Notify(c chan<- os.Signal) {
+	signal.Notify(c, Signals()...)
+}
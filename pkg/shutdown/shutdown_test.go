@@ -0,0 +1,35 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shutdown
+
+import (
+	"os"
+	"testing"
+)
+
+func TestSignals(t *testing.T) {
+	signals := Signals()
+	if len(signals) == 0 || signals[0] != os.Interrupt {
+		t.Fatalf("Signals() = %v, want os.Interrupt first", signals)
+	}
+
+	seen := map[os.Signal]bool{}
+	for _, s := range signals {
+		if seen[s] {
+			t.Errorf("Signals() = %v, want no duplicate %v", signals, s)
+		}
+		seen[s] = true
+	}
+}
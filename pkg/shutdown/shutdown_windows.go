@@ -0,0 +1,26 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build windows
+
+package shutdown
+
+import (
+	"os"
+	"syscall"
+)
+
+// platformSignals are the console close, logoff, and system shutdown events, which the Go runtime delivers as
+// SIGTERM on Windows. Ctrl+C and Ctrl+Break are delivered as os.Interrupt.
+var platformSignals = []os.Signal{syscall.SIGTERM}
@@ -0,0 +1,85 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/prometheus/common/model"
+)
+
+// Traffic aggregations reduce the bandwidth samples of a traffic over a query window into a single value.
+const (
+	// TrafficAggregationMax takes the window max, so transient spikes dominate
+	TrafficAggregationMax = "max"
+	// TrafficAggregationEMA takes the exponential moving average of the window, smoothing transient spikes
+	TrafficAggregationEMA = "ema"
+
+	// DefaultEMAAlpha is the default smoothing factor of TrafficAggregationEMA, the weight of the newest sample
+	DefaultEMAAlpha = 0.3
+
+	// DefaultTrafficMinBitsPerSecond is the default minimum bandwidth of queried traffic, lower traffic is noise
+	DefaultTrafficMinBitsPerSecond = 1000
+)
+
+var (
+	// ErrInvalidTrafficAggregation traffic aggregation is unknown.
+	ErrInvalidTrafficAggregation = errors.New("invalid traffic aggregation, expected 'max' or 'ema'")
+	// ErrInvalidEMAAlpha EMA smoothing factor isn't within (0, 1].
+	ErrInvalidEMAAlpha = errors.New("invalid EMA alpha, expected a value within (0, 1]")
+	// ErrInvalidTrafficMinBitsPerSecond minimum traffic bandwidth is negative.
+	ErrInvalidTrafficMinBitsPerSecond = errors.New("invalid minimum traffic bandwidth, expected a non-negative value")
+)
+
+// WithTrafficAggregation returns a copy of the service that reduces the traffic bandwidth samples of a query window
+// with aggregation (TrafficAggregationMax by default). The alpha smoothing factor is only used by TrafficAggregationEMA.
+func (s Service) WithTrafficAggregation(aggregation string, alpha float64) (Service, error) {
+	switch aggregation {
+	case TrafficAggregationMax:
+	case TrafficAggregationEMA:
+		if alpha <= 0 || alpha > 1 {
+			return s, fmt.Errorf("%w: %v", ErrInvalidEMAAlpha, alpha)
+		}
+	default:
+		return s, fmt.Errorf("%w: %q", ErrInvalidTrafficAggregation, aggregation)
+	}
+
+	s.trafficAggregation = aggregation
+	s.emaAlpha = alpha
+
+	return s, nil
+}
+
+// aggregateSamplePairs reduces the bandwidth samples of a traffic with the service's traffic aggregation.
+func (s Service) aggregateSamplePairs(samplePairs []model.SamplePair) float64 {
+	if s.trafficAggregation == TrafficAggregationEMA {
+		return s.getEMAValueFromSamplePairs(samplePairs, s.emaAlpha)
+	}
+
+	return s.getMaxValueFromSamplePairs(samplePairs)
+}
+
+// WithTrafficMinBitsPerSecond returns a copy of the service that only queries traffic above minBitsPerSecond
+// (DefaultTrafficMinBitsPerSecond by default). Zero disables the filter, so all traffic is queried.
+func (s Service) WithTrafficMinBitsPerSecond(minBitsPerSecond float64) (Service, error) {
+	if minBitsPerSecond < 0 || math.IsNaN(minBitsPerSecond) {
+		return s, fmt.Errorf("%w: %v", ErrInvalidTrafficMinBitsPerSecond, minBitsPerSecond)
+	}
+	s.trafficMinBitsPerSecond = minBitsPerSecond
+
+	return s, nil
+}
@@ -0,0 +1,113 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/prometheus/common/model"
+)
+
+// mockSamplePairs returns one sample pair per value, a second apart.
+func mockSamplePairs(values ...float64) []model.SamplePair {
+	samplePairs := []model.SamplePair{}
+	for i, value := range values {
+		samplePairs = append(samplePairs, model.SamplePair{Timestamp: model.Time(i * 1000), Value: model.SampleValue(value)})
+	}
+
+	return samplePairs
+}
+
+func TestService_getEMAValueFromSamplePairs(t *testing.T) {
+	tests := []struct {
+		name        string
+		samplePairs []model.SamplePair
+		alpha       float64
+		want        float64
+	}{
+		{name: "Empty samples", samplePairs: mockSamplePairs(), alpha: 0.5, want: -1},
+		{name: "Single sample", samplePairs: mockSamplePairs(1000), alpha: 0.5, want: 1000},
+		// 1000 -> 0.5*3000 + 0.5*1000 = 2000 -> 0.5*1000 + 0.5*2000 = 1500
+		{name: "Half alpha", samplePairs: mockSamplePairs(1000, 3000, 1000), alpha: 0.5, want: 1500},
+		// A spike is smoothed: 1000 -> 0.2*11000 + 0.8*1000 = 3000 -> 0.2*1000 + 0.8*3000 = 2600 -> 0.2*1000 + 0.8*2600 = 2280
+		{name: "Transient spike", samplePairs: mockSamplePairs(1000, 11000, 1000, 1000), alpha: 0.2, want: 2280},
+		{name: "Alpha one is the last sample", samplePairs: mockSamplePairs(1000, 11000, 4000), alpha: 1, want: 4000},
+		{name: "Constant samples", samplePairs: mockSamplePairs(2000, 2000, 2000), alpha: 0.3, want: 2000},
+	}
+	for _, testcase := range tests {
+		t.Run(testcase.name, func(t *testing.T) {
+			got := Service{}.getEMAValueFromSamplePairs(testcase.samplePairs, testcase.alpha) // nolint:exhaustivestruct
+			if math.Abs(got-testcase.want) > 1e-9 {
+				t.Errorf("Service.getEMAValueFromSamplePairs() = %v, want %v", got, testcase.want)
+			}
+		})
+	}
+}
+
+func TestService_WithTrafficAggregation(t *testing.T) {
+	samplePairs := mockSamplePairs(1000, 11000, 1000, 1000)
+
+	tests := []struct {
+		name        string
+		aggregation string
+		alpha       float64
+		want        float64
+		wantErr     error
+	}{
+		{name: "Max", aggregation: TrafficAggregationMax, alpha: 0, want: 11000, wantErr: nil},
+		{name: "EMA", aggregation: TrafficAggregationEMA, alpha: 0.2, want: 2280, wantErr: nil},
+		{name: "EMA with zero alpha", aggregation: TrafficAggregationEMA, alpha: 0, want: 11000, wantErr: ErrInvalidEMAAlpha},
+		{name: "EMA with alpha over one", aggregation: TrafficAggregationEMA, alpha: 1.5, want: 11000, wantErr: ErrInvalidEMAAlpha},
+		{name: "Unknown aggregation", aggregation: "avg", alpha: 0.2, want: 11000, wantErr: ErrInvalidTrafficAggregation},
+	}
+	for _, testcase := range tests {
+		t.Run(testcase.name, func(t *testing.T) {
+			s, err := New().WithTrafficAggregation(testcase.aggregation, testcase.alpha)
+			if !errors.Is(err, testcase.wantErr) {
+				t.Fatalf("Service.WithTrafficAggregation() error = %v, want %v", err, testcase.wantErr)
+			}
+			// An invalid aggregation keeps the default window max
+			if got := s.aggregateSamplePairs(samplePairs); math.Abs(got-testcase.want) > 1e-9 {
+				t.Errorf("Service.aggregateSamplePairs() = %v, want %v", got, testcase.want)
+			}
+		})
+	}
+}
+
+func TestService_WithTrafficMinBitsPerSecond(t *testing.T) {
+	tests := []struct {
+		name             string
+		minBitsPerSecond float64
+		want             float64
+		wantErr          error
+	}{
+		{name: "Custom", minBitsPerSecond: 100, want: 100, wantErr: nil},
+		{name: "Disabled", minBitsPerSecond: 0, want: 0, wantErr: nil},
+		{name: "Negative keeps the default", minBitsPerSecond: -1, want: DefaultTrafficMinBitsPerSecond, wantErr: ErrInvalidTrafficMinBitsPerSecond},
+	}
+	for _, testcase := range tests {
+		t.Run(testcase.name, func(t *testing.T) {
+			s, err := New().WithTrafficMinBitsPerSecond(testcase.minBitsPerSecond)
+			if !errors.Is(err, testcase.wantErr) {
+				t.Fatalf("Service.WithTrafficMinBitsPerSecond() error = %v, want %v", err, testcase.wantErr)
+			}
+			if s.trafficMinBitsPerSecond != testcase.want {
+				t.Errorf("Service.WithTrafficMinBitsPerSecond() = %v, want %v", s.trafficMinBitsPerSecond, testcase.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/common/model"
+	log "github.com/sirupsen/logrus"
+)
+
+// queryCacheKey identifies a query result by its query string and time window.
+type queryCacheKey struct {
+	query string
+	start time.Time
+	end   time.Time
+}
+
+// newQueryCacheKey returns the cache key of a query. The window is truncated to the second so jobs
+// started by the same cron tick share results.
+func newQueryCacheKey(query string, start, end time.Time) queryCacheKey {
+	return queryCacheKey{
+		query: query,
+		start: start.Truncate(time.Second),
+		end:   end.Truncate(time.Second),
+	}
+}
+
+// queryCacheEntry is a cached query result.
+type queryCacheEntry struct {
+	value     model.Value
+	expiresAt time.Time
+}
+
+// queryCache is a size-bounded query result cache shared by the federator jobs.
+// Cached values are shared between callers and must not be modified.
+type queryCache struct {
+	mu         sync.Mutex
+	ttl        time.Duration
+	maxEntries int
+	entries    map[queryCacheKey]queryCacheEntry
+
+	// now is replaced in tests
+	now func() time.Time
+}
+
+// newQueryCache returns a cache that keeps at most maxEntries results for ttl each.
+func newQueryCache(ttl time.Duration, maxEntries int) *queryCache {
+	return &queryCache{
+		mu:         sync.Mutex{},
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		entries:    make(map[queryCacheKey]queryCacheEntry),
+		now:        time.Now,
+	}
+}
+
+// get returns the cached result of a query, if it's not expired yet.
+func (c *queryCache) get(key queryCacheKey) (model.Value, bool) {
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	if ok && !c.now().Before(entry.expiresAt) {
+		delete(c.entries, key)
+		queryCacheEntries.Set(float64(len(c.entries)))
+		ok = false
+	}
+	c.mu.Unlock()
+
+	if !ok {
+		queryCacheTotal.WithLabelValues("miss").Inc()
+		log.Debugf("Query cache miss: %v", key.query)
+
+		return nil, false
+	}
+
+	queryCacheTotal.WithLabelValues("hit").Inc()
+	log.Debugf("Query cache hit: %v", key.query)
+
+	return entry.value, true
+}
+
+// set stores a query result, evicting expired entries first and then the entries closest to expiry when full.
+func (c *queryCache) set(key queryCacheKey, value model.Value) {
+	if c.maxEntries <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= c.maxEntries {
+		for k, entry := range c.entries {
+			if !now.Before(entry.expiresAt) {
+				delete(c.entries, k)
+			}
+		}
+		for len(c.entries) >= c.maxEntries {
+			var oldestKey queryCacheKey
+			var oldestExpiresAt time.Time
+			for k, entry := range c.entries {
+				if oldestExpiresAt.IsZero() || entry.expiresAt.Before(oldestExpiresAt) {
+					oldestKey = k
+					oldestExpiresAt = entry.expiresAt
+				}
+			}
+			delete(c.entries, oldestKey)
+		}
+	}
+
+	c.entries[key] = queryCacheEntry{
+		value:     value,
+		expiresAt: now.Add(c.ttl),
+	}
+	queryCacheEntries.Set(float64(len(c.entries)))
+}
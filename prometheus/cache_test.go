@@ -0,0 +1,78 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+func Test_queryCache(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	cache := newQueryCache(time.Minute, 2)
+	cache.now = func() time.Time { return now }
+
+	keyA := newQueryCacheKey("a", now.Add(-time.Minute), now)
+	keyB := newQueryCacheKey("b", now.Add(-time.Minute), now)
+	keyC := newQueryCacheKey("c", now.Add(-time.Minute), now)
+
+	cache.set(keyA, model.Matrix{})
+	now = now.Add(time.Second)
+	cache.set(keyB, model.Matrix{})
+
+	// Same query and window within the same second is a hit
+	if _, ok := cache.get(newQueryCacheKey("a", now.Add(-time.Minute-time.Second+time.Millisecond), now.Add(-time.Second+time.Millisecond))); !ok {
+		t.Errorf("queryCache.get() = miss, want hit for the same query and window")
+	}
+
+	// The entry closest to expiry is evicted when full
+	cache.set(keyC, model.Matrix{})
+	if _, ok := cache.get(keyA); ok {
+		t.Errorf("queryCache.get() = hit, want keyA evicted")
+	}
+	if _, ok := cache.get(keyB); !ok {
+		t.Errorf("queryCache.get() = miss, want keyB cached")
+	}
+
+	// Entries expire after the TTL
+	now = now.Add(time.Minute)
+	if _, ok := cache.get(keyC); ok {
+		t.Errorf("queryCache.get() = hit, want keyC expired")
+	}
+}
+
+func Test_queryCache_concurrent(t *testing.T) {
+	cache := newQueryCache(time.Minute, 5)
+	now := time.Now()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			key := newQueryCacheKey(string(rune('a'+i)), now, now)
+			cache.set(key, model.Matrix{})
+			cache.get(key)
+		}(i)
+	}
+	wg.Wait()
+
+	if len(cache.entries) > 5 {
+		t.Errorf("queryCache entries = %v, want at most 5", len(cache.entries))
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus
+
+import (
+	promclient "github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "planet_federator"
+
+var queriesTotal = promclient.NewCounterVec(promclient.CounterOpts{ // nolint:exhaustivestruct
+	Namespace: namespace,
+	Subsystem: "prometheus",
+	Name:      "queries_total",
+	Help:      "Total Prometheus queries by the endpoint that served them.",
+}, []string{"endpoint", "result"})
+
+var queryCacheTotal = promclient.NewCounterVec(promclient.CounterOpts{ // nolint:exhaustivestruct
+	Namespace: namespace,
+	Subsystem: "prometheus",
+	Name:      "query_cache_total",
+	Help:      "Total Prometheus query cache lookups by result (hit or miss).",
+}, []string{"result"})
+
+var queryCacheEntries = promclient.NewGauge(promclient.GaugeOpts{ // nolint:exhaustivestruct
+	Namespace: namespace,
+	Subsystem: "prometheus",
+	Name:      "query_cache_entries",
+	Help:      "Number of Prometheus query results in the query cache.",
+})
+
+var querySlotWaitSecondsTotal = promclient.NewCounter(promclient.CounterOpts{ // nolint:exhaustivestruct
+	Namespace: namespace,
+	Subsystem: "prometheus",
+	Name:      "query_slot_wait_seconds_total",
+	Help:      "Total time spent waiting for a free Prometheus query slot of the query concurrency limit.",
+})
+
+var jobQueryDurationSeconds = promclient.NewGaugeVec(promclient.GaugeOpts{ // nolint:exhaustivestruct
+	Namespace: namespace,
+	Subsystem: "prometheus",
+	Name:      "job_query_duration_seconds",
+	Help:      "Duration of the Prometheus queries of the last job run.",
+}, []string{"job"})
+
+var jobQuerySeries = promclient.NewGaugeVec(promclient.GaugeOpts{ // nolint:exhaustivestruct
+	Namespace: namespace,
+	Subsystem: "prometheus",
+	Name:      "job_query_series",
+	Help:      "Number of series returned by the Prometheus queries of the last job run.",
+}, []string{"job"})
+
+var queryWarningsTotal = promclient.NewCounterVec(promclient.CounterOpts{ // nolint:exhaustivestruct
+	Namespace: namespace,
+	Subsystem: "prometheus",
+	Name:      "query_warnings_total",
+	Help:      "Total warnings returned by the Prometheus queries of a job.",
+}, []string{"job"})
+
+// ObserveJobQueryStats records the query stats of a job run.
+func ObserveJobQueryStats(job string, stats QueryStats) {
+	jobQueryDurationSeconds.WithLabelValues(job).Set(stats.Duration.Seconds())
+	jobQuerySeries.WithLabelValues(job).Set(float64(stats.Series))
+	queryWarningsTotal.WithLabelValues(job).Add(float64(stats.Warnings))
+}
+
+// Collectors returns Prometheus service's collectors.
+func Collectors() []promclient.Collector {
+	return []promclient.Collector{
+		queriesTotal,
+		queryCacheTotal,
+		queryCacheEntries,
+		querySlotWaitSecondsTotal,
+		jobQueryDurationSeconds,
+		jobQuerySeries,
+		queryWarningsTotal,
+	}
+}
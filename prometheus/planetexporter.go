@@ -17,6 +17,9 @@ package prometheus
 import (
 	"context"
 	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/prometheus/common/model"
@@ -28,6 +31,34 @@ const (
 	regexExcludedAddresses = "(100.([6-9]|1[0-2]).*|52.*|192.168.*|.*prometheus.*|203.*|163.18.*|130.211.*|f.*|169.254.*|111.*)"
 )
 
+// Series selectors of the upstream/downstream dependencies, shared by the range queries and VictoriaMetrics exports.
+const (
+	upstreamSelector = `planet_upstream{local_hostgroup!="", port!~"` + regexExcludedPorts + `", remote_port!~"` + regexExcludedPorts +
+		`", remote_address!~"` + regexExcludedAddresses + `", remote_address!="localhost", process_name!="", remote_address!~"\\d.*"}`
+	downstreamSelector = `planet_downstream{local_hostgroup!="", port!~"` + regexExcludedPorts + `", local_port!~"` + regexExcludedPorts +
+		`", remote_address!~"` + regexExcludedAddresses + `", remote_address!="localhost", process_name!="", remote_address!~"\\d.*"}`
+)
+
+// Labels of the upstream/downstream dependencies, the series of a dependency are aggregated by them.
+var (
+	upstreamLabels = []model.LabelName{
+		"local_hostgroup", "local_address", "remote_address", "remote_hostgroup", "port", "remote_port", "process_name", "protocol",
+	}
+	downstreamLabels = []model.LabelName{
+		"local_hostgroup", "local_address", "remote_address", "remote_hostgroup", "port", "local_port", "process_name", "protocol",
+	}
+)
+
+// labelList returns the comma-separated labels of a PromQL aggregation.
+func labelList(labels []model.LabelName) string {
+	names := make([]string, 0, len(labels))
+	for _, label := range labels {
+		names = append(names, string(label))
+	}
+
+	return strings.Join(names, ", ")
+}
+
 // PlanetExporterTrafficBandwidth represents a single traffic between local and remote hostgroup.
 type PlanetExporterTrafficBandwidth struct {
 	LocalHostgroup         string  `json:"local_hostgroup"` // e.g. hostgroup
@@ -36,20 +67,31 @@ type PlanetExporterTrafficBandwidth struct {
 	RemoteDomain           string  `json:"remote_domain"`
 	BandwidthBitsPerSecond float64 `json:"bandwidth_bits_per_second"`
 	Direction              string  `json:"direction"`
+
+	// LocalInstance is the planet-exporter instance of per-instance traffic, empty for hostgroup traffic
+	LocalInstance string `json:"local_instance,omitempty"`
 }
 
 // QueryPlanetExporterTrafficBandwidth returns list traffic bandwidth data.
-func (s Service) QueryPlanetExporterTrafficBandwidth(ctx context.Context, startTime time.Time, endTime time.Time) ([]PlanetExporterTrafficBandwidth, error) {
-	// query data as bits per second and only those higher than 1Kbps to reduce noise
+// The data is limited to the given traffic directions (e.g. "egress"), or all directions if empty.
+// The traffic of the per-instance hostgroups (see WithTrafficPerInstanceHostgroups) is also returned per instance,
+// in addition to their hostgroup traffic.
+func (s Service) QueryPlanetExporterTrafficBandwidth(ctx context.Context, startTime time.Time, endTime time.Time, directions []string) ([]PlanetExporterTrafficBandwidth, error) {
+	if s.source == SourceVMExport {
+		return s.exportPlanetExporterTrafficBandwidth(ctx, startTime, endTime, directions)
+	}
+
+	// query data as bits per second and only those higher than the minimum bandwidth (1Kbps by default) to reduce noise
 	// include remote services (hostgroup and domain) in the result
 	qrWithRemoteServices := fmt.Sprintf(`
 			sum (
 				sum (
-					irate (planet_traffic_bytes_total{local_hostgroup!="", remote_ip!~"%v", remote_domain!~"%v", remote_hostgroup!=""}[30s])
+					irate (planet_traffic_bytes_total{local_hostgroup!="", remote_ip!~"%v", remote_domain!~"%v", remote_hostgroup!=""%v%v}[30s])
 				) by (direction, local_hostgroup, local_domain, remote_hostgroup, remote_domain, instance) * 8
 			)
-			by (direction, local_hostgroup, local_domain, remote_hostgroup, remote_domain) > 1000`,
-		regexExcludedAddresses, regexExcludedAddresses)
+			by (direction, local_hostgroup, local_domain, remote_hostgroup, remote_domain)%v`,
+		regexExcludedAddresses, regexExcludedAddresses, directionMatcher(directions), s.trafficSourceMatcher(),
+		bandwidthFilter(s.trafficMinBitsPerSecond))
 	withRemoteServices, err := s.queryPlanetExporterTrafficBandwidth(ctx, qrWithRemoteServices, startTime, endTime)
 	if err != nil {
 		return nil, err
@@ -58,9 +100,65 @@ func (s Service) QueryPlanetExporterTrafficBandwidth(ctx context.Context, startT
 	trafficBandwidthData := []PlanetExporterTrafficBandwidth{}
 	trafficBandwidthData = append(trafficBandwidthData, withRemoteServices...)
 
+	if len(s.trafficPerInstanceHostgroups) == 0 {
+		return trafficBandwidthData, nil
+	}
+
+	// same query keeping the instance label, only for the per-instance hostgroups
+	qrPerInstance := fmt.Sprintf(`
+			sum (
+				irate (planet_traffic_bytes_total{local_hostgroup=~"%v", remote_ip!~"%v", remote_domain!~"%v", remote_hostgroup!=""%v%v}[30s])
+			) by (direction, local_hostgroup, local_domain, remote_hostgroup, remote_domain, instance) * 8%v`,
+		hostgroupRegex(s.trafficPerInstanceHostgroups), regexExcludedAddresses, regexExcludedAddresses,
+		directionMatcher(directions), s.trafficSourceMatcher(), bandwidthFilter(s.trafficMinBitsPerSecond))
+	perInstance, err := s.queryPlanetExporterTrafficBandwidth(ctx, qrPerInstance, startTime, endTime)
+	if err != nil {
+		return nil, err
+	}
+	trafficBandwidthData = append(trafficBandwidthData, perInstance...)
+
 	return trafficBandwidthData, nil
 }
 
+// hostgroupRegex returns a PromQL regex string matching exactly the given hostgroups.
+func hostgroupRegex(hostgroups []string) string {
+	quoted := make([]string, 0, len(hostgroups))
+	for _, hostgroup := range hostgroups {
+		// backslashes of the regex are escaped again in the PromQL string
+		quoted = append(quoted, strings.ReplaceAll(regexp.QuoteMeta(hostgroup), `\`, `\\`))
+	}
+
+	return strings.Join(quoted, "|")
+}
+
+// directionMatcher returns an additional label matcher limiting traffic to the given directions, or empty for all directions.
+// The directions are expected to be validated already (see federator.ParseTrafficDirections).
+func directionMatcher(directions []string) string {
+	if len(directions) == 0 {
+		return ""
+	}
+
+	return fmt.Sprintf(`, direction=~"%v"`, strings.Join(directions, "|"))
+}
+
+// trafficSourceMatcher returns an additional label matcher excluding the socketstat traffic, or empty if it's included.
+func (s Service) trafficSourceMatcher() string {
+	if s.trafficSocketstat {
+		return ""
+	}
+
+	return `, source!="socketstat"`
+}
+
+// bandwidthFilter returns a comparison keeping traffic above minBitsPerSecond, or empty to keep all traffic if it's zero.
+func bandwidthFilter(minBitsPerSecond float64) string {
+	if minBitsPerSecond <= 0 {
+		return ""
+	}
+
+	return " > " + strconv.FormatFloat(minBitsPerSecond, 'f', -1, 64)
+}
+
 func (s Service) queryPlanetExporterTrafficBandwidth(ctx context.Context, query string, startTime time.Time, endTime time.Time) ([]PlanetExporterTrafficBandwidth, error) {
 	qrTrafficPeers, err := s.queryRange(ctx, query, startTime, endTime)
 	if err != nil {
@@ -79,8 +177,9 @@ func (s Service) queryPlanetExporterTrafficBandwidth(ctx context.Context, query
 		remoteHostgroup := matrix.Metric["remote_hostgroup"]
 		remoteDomain := matrix.Metric["remote_domain"]
 		direction := matrix.Metric["direction"]
+		localInstance := matrix.Metric["instance"]
 
-		bandwidthBitsPerSecond := s.getMaxValueFromSamplePairs(matrix.Values)
+		bandwidthBitsPerSecond := s.aggregateSamplePairs(matrix.Values)
 
 		trafficBandwidthData = append(trafficBandwidthData, PlanetExporterTrafficBandwidth{
 			Direction:              string(direction),
@@ -89,6 +188,7 @@ func (s Service) queryPlanetExporterTrafficBandwidth(ctx context.Context, query
 			LocalDomain:            string(localDomain),
 			RemoteDomain:           string(remoteDomain),
 			BandwidthBitsPerSecond: bandwidthBitsPerSecond,
+			LocalInstance:          string(localInstance),
 		})
 	}
 
@@ -116,22 +216,19 @@ type PlanetExporterDependencyService struct {
 
 // QueryPlanetExporterUpstreamServices returns all upstream service dependencies.
 func (s Service) QueryPlanetExporterUpstreamServices(ctx context.Context, startTime time.Time, endTime time.Time) ([]PlanetExporterDependencyService, error) {
+	if s.source == SourceVMExport {
+		return s.exportPlanetExporterDependencyServices(ctx, upstreamSelector, upstreamLabels, "remote_port", startTime, endTime)
+	}
+
 	query := fmt.Sprintf(`
 			max(
 				max_over_time(
-					planet_upstream{
-						local_hostgroup!="",
-						port!~"%v",
-						remote_address!~"%v",
-						remote_address!="localhost",
-						process_name!="",
-						remote_address!~"\\d.*"
-					}[15s]
+					%v[15s]
 				)
-			) by (local_hostgroup, local_address, remote_address, remote_hostgroup, port, process_name, protocol)`,
-		regexExcludedPorts, regexExcludedAddresses)
+			) by (%v)`,
+		upstreamSelector, labelList(upstreamLabels))
 
-	dependencyServices, err := s.queryPlanetExporterDependencyServices(ctx, query, startTime, endTime)
+	dependencyServices, err := s.queryPlanetExporterDependencyServices(ctx, query, "remote_port", startTime, endTime)
 	if err != nil {
 		return nil, err
 	}
@@ -141,22 +238,19 @@ func (s Service) QueryPlanetExporterUpstreamServices(ctx context.Context, startT
 
 // QueryPlanetExporterDownstreamServices returns all downstream service dependencies.
 func (s Service) QueryPlanetExporterDownstreamServices(ctx context.Context, startTime time.Time, endTime time.Time) ([]PlanetExporterDependencyService, error) {
+	if s.source == SourceVMExport {
+		return s.exportPlanetExporterDependencyServices(ctx, downstreamSelector, downstreamLabels, "local_port", startTime, endTime)
+	}
+
 	query := fmt.Sprintf(`
 			max(
 				max_over_time(
-					planet_downstream{
-						local_hostgroup!="",
-						port!~"%v",
-						remote_address!~"%v",
-						remote_address!="localhost",
-						process_name!="",
-						remote_address!~"\\d.*"
-					}[15s]
+					%v[15s]
 				)
-			) by (local_hostgroup, local_address, remote_address, remote_hostgroup, port, process_name, protocol)`,
-		regexExcludedPorts, regexExcludedAddresses)
+			) by (%v)`,
+		downstreamSelector, labelList(downstreamLabels))
 
-	downstreamServices, err := s.queryPlanetExporterDependencyServices(ctx, query, startTime, endTime)
+	downstreamServices, err := s.queryPlanetExporterDependencyServices(ctx, query, "local_port", startTime, endTime)
 	if err != nil {
 		return nil, err
 	}
@@ -164,14 +258,25 @@ func (s Service) QueryPlanetExporterDownstreamServices(ctx context.Context, star
 	return downstreamServices, nil
 }
 
-func (s Service) queryPlanetExporterDependencyServices(ctx context.Context, query string, startTime, endTime time.Time) ([]PlanetExporterDependencyService, error) {
+// queryPlanetExporterDependencyServices returns the dependency services of a query, whose port is in portLabel
+// (remote_port or local_port), or in the legacy port label of exporters that predate it.
+func (s Service) queryPlanetExporterDependencyServices(ctx context.Context, query string, portLabel model.LabelName,
+	startTime, endTime time.Time) ([]PlanetExporterDependencyService, error) {
 	resultDependencyServices, err := s.queryRange(ctx, query, startTime, endTime)
 	if err != nil {
 		return nil, err
 	}
 
+	return parseDependencyServices(resultDependencyServices.(model.Matrix), portLabel), nil
+}
+
+// parseDependencyServices parses dependency services from a query result, preferring the port in portLabel over the
+// legacy port label. While exporters are upgraded, old and new exporters report the same dependency with different
+// labels, so it's deduplicated.
+func parseDependencyServices(result model.Matrix, portLabel model.LabelName) []PlanetExporterDependencyService {
 	dependencyServices := []PlanetExporterDependencyService{}
-	for _, matrix := range resultDependencyServices.(model.Matrix) {
+	seen := make(map[PlanetExporterDependencyService]bool)
+	for _, matrix := range result {
 		localHostgroup, ok := matrix.Metric["local_hostgroup"]
 		if !ok {
 			log.Warnf("Found empty local_hostgroup: %v", matrix.Metric.String())
@@ -180,21 +285,134 @@ func (s Service) queryPlanetExporterDependencyServices(ctx context.Context, quer
 		}
 		localAddress := matrix.Metric["local_address"]
 		localProcessName := matrix.Metric["process_name"]
-		remotePort := matrix.Metric["port"]
+		port := matrix.Metric[portLabel]
+		if port == "" {
+			port = matrix.Metric["port"]
+		}
 		remoteHostgroup := matrix.Metric["remote_hostgroup"]
 		remoteAddress := matrix.Metric["remote_address"]
 		protocol := matrix.Metric["protocol"]
 
-		dependencyServices = append(dependencyServices, PlanetExporterDependencyService{
+		dependencyService := PlanetExporterDependencyService{
 			LocalHostgroup:   string(localHostgroup),
 			LocalAddress:     string(localAddress),
 			LocalProcessName: string(localProcessName),
-			Port:             string(remotePort),
+			Port:             string(port),
 			RemoteHostgroup:  string(remoteHostgroup),
 			RemoteAddress:    string(remoteAddress),
 			Protocol:         string(protocol),
-		})
+		}
+		if seen[dependencyService] {
+			continue
+		}
+		seen[dependencyService] = true
+		dependencyServices = append(dependencyServices, dependencyService)
 	}
 
-	return dependencyServices, nil
+	return dependencyServices
+}
+
+// hostgroupByInstance is a PromQL expression with a single local_hostgroup label per planet-exporter instance,
+// since planet-exporter's own metrics aren't labelled with the hostgroup.
+const hostgroupByInstance = `
+			topk by (instance) (1,
+				group by (instance, local_hostgroup) (
+					{__name__=~"planet_upstream|planet_downstream|planet_traffic_bytes_total", local_hostgroup!=""}
+				)
+			)`
+
+// PlanetExporterCollectorHealth represents the health of a planet-exporter collector across a hostgroup's instances.
+type PlanetExporterCollectorHealth struct {
+	LocalHostgroup string
+	Collector      string
+
+	// Instances is the number of instances reporting the collector.
+	Instances int
+	// FailingInstances is the number of instances whose last collector scrape failed.
+	FailingInstances int
+	// AvgDurationSeconds is the average collector scrape duration across instances.
+	AvgDurationSeconds float64
+}
+
+// QueryPlanetExporterCollectorHealth returns planet-exporter collector health summary per hostgroup.
+func (s Service) QueryPlanetExporterCollectorHealth(ctx context.Context, queryTime time.Time) ([]PlanetExporterCollectorHealth, error) {
+	qSuccess := fmt.Sprintf(`
+			max by (instance, collector) (planet_scrape_collector_success)
+			* on (instance) group_left(local_hostgroup) %v`, hostgroupByInstance)
+	success, err := s.query(ctx, qSuccess, queryTime)
+	if err != nil {
+		return nil, err
+	}
+
+	qDuration := fmt.Sprintf(`
+			max by (instance, collector) (planet_scrape_collector_duration_seconds)
+			* on (instance) group_left(local_hostgroup) %v`, hostgroupByInstance)
+	duration, err := s.query(ctx, qDuration, queryTime)
+	if err != nil {
+		return nil, err
+	}
+
+	successVector, ok := success.(model.Vector)
+	if !ok {
+		return nil, fmt.Errorf("unexpected collector success query result type: %v", success.Type())
+	}
+	durationVector, ok := duration.(model.Vector)
+	if !ok {
+		return nil, fmt.Errorf("unexpected collector duration query result type: %v", duration.Type())
+	}
+
+	return summarizeCollectorHealth(successVector, durationVector), nil
+}
+
+// summarizeCollectorHealth aggregates per-instance collector success and duration samples per hostgroup and collector.
+func summarizeCollectorHealth(success, duration model.Vector) []PlanetExporterCollectorHealth {
+	type healthKey struct {
+		localHostgroup string
+		collector      string
+	}
+
+	healthByKey := make(map[healthKey]*PlanetExporterCollectorHealth)
+	var keys []healthKey
+	for _, sample := range success {
+		key := healthKey{
+			localHostgroup: string(sample.Metric["local_hostgroup"]),
+			collector:      string(sample.Metric["collector"]),
+		}
+		health, found := healthByKey[key]
+		if !found {
+			health = &PlanetExporterCollectorHealth{
+				LocalHostgroup: key.localHostgroup,
+				Collector:      key.collector,
+			}
+			healthByKey[key] = health
+			keys = append(keys, key)
+		}
+
+		health.Instances++
+		if sample.Value == 0 {
+			health.FailingInstances++
+		}
+	}
+
+	durationSums := make(map[healthKey]float64)
+	durationCounts := make(map[healthKey]int)
+	for _, sample := range duration {
+		key := healthKey{
+			localHostgroup: string(sample.Metric["local_hostgroup"]),
+			collector:      string(sample.Metric["collector"]),
+		}
+		durationSums[key] += float64(sample.Value)
+		durationCounts[key]++
+	}
+
+	collectorHealth := []PlanetExporterCollectorHealth{}
+	for _, key := range keys {
+		health := healthByKey[key]
+		if durationCounts[key] > 0 {
+			health.AvgDurationSeconds = durationSums[key] / float64(durationCounts[key])
+		}
+		collectorHealth = append(collectorHealth, *health)
+	}
+
+	return collectorHealth
 }
@@ -0,0 +1,258 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+// mockCollectorSample returns a per-instance collector sample.
+func mockCollectorSample(instance, localHostgroup, collector string, value float64) *model.Sample {
+	return &model.Sample{ // nolint:exhaustivestruct
+		Metric: model.Metric{
+			"instance":        model.LabelValue(instance),
+			"local_hostgroup": model.LabelValue(localHostgroup),
+			"collector":       model.LabelValue(collector),
+		},
+		Value: model.SampleValue(value),
+	}
+}
+
+func Test_summarizeCollectorHealth(t *testing.T) {
+	tests := []struct {
+		name     string
+		success  model.Vector
+		duration model.Vector
+		want     []PlanetExporterCollectorHealth
+	}{
+		{
+			name:     "No planet-exporter instances",
+			success:  model.Vector{},
+			duration: model.Vector{},
+			want:     []PlanetExporterCollectorHealth{},
+		},
+		{
+			name: "Summary per hostgroup and collector",
+			success: model.Vector{
+				mockCollectorSample("a-1:19100", "a", "socketstat", 1),
+				mockCollectorSample("a-2:19100", "a", "socketstat", 0),
+				mockCollectorSample("a-1:19100", "a", "inventory", 1),
+				mockCollectorSample("b-1:19100", "b", "socketstat", 1),
+			},
+			duration: model.Vector{
+				mockCollectorSample("a-1:19100", "a", "socketstat", 0.1),
+				mockCollectorSample("a-2:19100", "a", "socketstat", 0.3),
+				mockCollectorSample("a-1:19100", "a", "inventory", 0.5),
+			},
+			want: []PlanetExporterCollectorHealth{
+				{LocalHostgroup: "a", Collector: "socketstat", Instances: 2, FailingInstances: 1, AvgDurationSeconds: 0.2},
+				{LocalHostgroup: "a", Collector: "inventory", Instances: 1, FailingInstances: 0, AvgDurationSeconds: 0.5},
+				{LocalHostgroup: "b", Collector: "socketstat", Instances: 1, FailingInstances: 0, AvgDurationSeconds: 0},
+			},
+		},
+	}
+	for _, testcase := range tests {
+		t.Run(testcase.name, func(t *testing.T) {
+			if got := summarizeCollectorHealth(testcase.success, testcase.duration); !reflect.DeepEqual(got, testcase.want) {
+				t.Errorf("summarizeCollectorHealth() = %v, want %v", got, testcase.want)
+			}
+		})
+	}
+}
+
+func Test_directionMatcher(t *testing.T) {
+	tests := []struct {
+		name       string
+		directions []string
+		want       string
+	}{
+		{name: "All directions", directions: nil, want: ""},
+		{name: "Egress only", directions: []string{"egress"}, want: `, direction=~"egress"`},
+		{name: "Both directions", directions: []string{"ingress", "egress"}, want: `, direction=~"ingress|egress"`},
+	}
+	for _, testcase := range tests {
+		t.Run(testcase.name, func(t *testing.T) {
+			if got := directionMatcher(testcase.directions); got != testcase.want {
+				t.Errorf("directionMatcher() = %v, want %v", got, testcase.want)
+			}
+		})
+	}
+}
+
+func Test_bandwidthFilter(t *testing.T) {
+	tests := []struct {
+		name             string
+		minBitsPerSecond float64
+		want             string
+	}{
+		{name: "Default", minBitsPerSecond: DefaultTrafficMinBitsPerSecond, want: " > 1000"},
+		{name: "Fractional", minBitsPerSecond: 0.5, want: " > 0.5"},
+		{name: "Large", minBitsPerSecond: 25000000, want: " > 25000000"},
+		{name: "Disabled", minBitsPerSecond: 0, want: ""},
+	}
+	for _, testcase := range tests {
+		t.Run(testcase.name, func(t *testing.T) {
+			if got := bandwidthFilter(testcase.minBitsPerSecond); got != testcase.want {
+				t.Errorf("bandwidthFilter() = %v, want %v", got, testcase.want)
+			}
+		})
+	}
+}
+
+func TestService_trafficSourceMatcher(t *testing.T) {
+	tests := []struct {
+		name    string
+		service Service
+		want    string
+	}{
+		{name: "Socketstat excluded by default", service: New(), want: `, source!="socketstat"`},
+		{name: "Socketstat included", service: New().WithSocketstatTraffic(), want: ""},
+	}
+	for _, testcase := range tests {
+		t.Run(testcase.name, func(t *testing.T) {
+			if got := testcase.service.trafficSourceMatcher(); got != testcase.want {
+				t.Errorf("Service.trafficSourceMatcher() = %v, want %v", got, testcase.want)
+			}
+		})
+	}
+}
+
+func Test_hostgroupRegex(t *testing.T) {
+	tests := []struct {
+		name       string
+		hostgroups []string
+		want       string
+	}{
+		{name: "Single hostgroup", hostgroups: []string{"payment"}, want: "payment"},
+		{name: "Multiple hostgroups", hostgroups: []string{"payment", "checkout"}, want: "payment|checkout"},
+		{name: "Regex metacharacters", hostgroups: []string{"app.v2"}, want: `app\\.v2`},
+	}
+	for _, testcase := range tests {
+		t.Run(testcase.name, func(t *testing.T) {
+			if got := hostgroupRegex(testcase.hostgroups); got != testcase.want {
+				t.Errorf("hostgroupRegex() = %v, want %v", got, testcase.want)
+			}
+		})
+	}
+}
+
+func TestService_QueryPlanetExporterTrafficBandwidth_perInstance(t *testing.T) {
+	tests := []struct {
+		name         string
+		hostgroups   []string
+		wantRequests int
+	}{
+		{name: "Hostgroup traffic only", hostgroups: nil, wantRequests: 1},
+		{name: "Per-instance hostgroups", hostgroups: []string{"payment"}, wantRequests: 2},
+	}
+	for _, testcase := range tests {
+		t.Run(testcase.name, func(t *testing.T) {
+			var requests int
+			server := mockPrometheusServer(true, &requests)
+			defer server.Close()
+
+			s := New(mockEndpoint(t, server)).WithTrafficPerInstanceHostgroups(testcase.hostgroups)
+			_, err := s.QueryPlanetExporterTrafficBandwidth(context.Background(), time.Now().Add(-time.Minute), time.Now(), nil)
+			if err != nil {
+				t.Errorf("Service.QueryPlanetExporterTrafficBandwidth() error = %v", err)
+			}
+			if requests != testcase.wantRequests {
+				t.Errorf("Service.QueryPlanetExporterTrafficBandwidth() requests = %v, want %v", requests, testcase.wantRequests)
+			}
+		})
+	}
+}
+
+// mockDependencySeries returns a dependency series of an exporter, with the legacy port and the new port labels.
+func mockDependencySeries(remoteHostgroup, legacyPort, portLabel, port string) *model.SampleStream {
+	metric := model.Metric{
+		"local_hostgroup":  "app",
+		"local_address":    "app.local",
+		"remote_hostgroup": model.LabelValue(remoteHostgroup),
+		"remote_address":   model.LabelValue(remoteHostgroup + ".local"),
+		"process_name":     "app",
+		"protocol":         "tcp",
+	}
+	if legacyPort != "" {
+		metric["port"] = model.LabelValue(legacyPort)
+	}
+	if port != "" {
+		metric[model.LabelName(portLabel)] = model.LabelValue(port)
+	}
+
+	return &model.SampleStream{Metric: metric} // nolint:exhaustivestruct
+}
+
+func Test_parseDependencyServices(t *testing.T) {
+	dependencyService := func(remoteHostgroup, port string) PlanetExporterDependencyService {
+		return PlanetExporterDependencyService{
+			LocalHostgroup:   "app",
+			LocalAddress:     "app.local",
+			LocalProcessName: "app",
+			Port:             port,
+			RemoteHostgroup:  remoteHostgroup,
+			RemoteAddress:    remoteHostgroup + ".local",
+			Protocol:         "tcp",
+		}
+	}
+
+	tests := []struct {
+		name      string
+		result    model.Matrix
+		portLabel model.LabelName
+		want      []PlanetExporterDependencyService
+	}{
+		{
+			name:      "Old exporter with the legacy port only",
+			result:    model.Matrix{mockDependencySeries("db", "5432", "remote_port", "")},
+			portLabel: "remote_port",
+			want:      []PlanetExporterDependencyService{dependencyService("db", "5432")},
+		},
+		{
+			name:      "New exporter without the legacy port",
+			result:    model.Matrix{mockDependencySeries("db", "", "remote_port", "5432")},
+			portLabel: "remote_port",
+			want:      []PlanetExporterDependencyService{dependencyService("db", "5432")},
+		},
+		{
+			name:      "New label is preferred over the legacy port",
+			result:    model.Matrix{mockDependencySeries("web", "1234", "local_port", "8080")},
+			portLabel: "local_port",
+			want:      []PlanetExporterDependencyService{dependencyService("web", "8080")},
+		},
+		{
+			name: "Mixed old and new exporters during rollout",
+			result: model.Matrix{
+				mockDependencySeries("db", "5432", "remote_port", ""),
+				mockDependencySeries("db", "5432", "remote_port", "5432"),
+				mockDependencySeries("cache", "6379", "remote_port", "6379"),
+			},
+			portLabel: "remote_port",
+			want:      []PlanetExporterDependencyService{dependencyService("db", "5432"), dependencyService("cache", "6379")},
+		},
+	}
+	for _, testcase := range tests {
+		t.Run(testcase.name, func(t *testing.T) {
+			if got := parseDependencyServices(testcase.result, testcase.portLabel); !reflect.DeepEqual(got, testcase.want) {
+				t.Errorf("parseDependencyServices() = %v, want %v", got, testcase.want)
+			}
+		})
+	}
+}
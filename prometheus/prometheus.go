@@ -16,9 +16,11 @@ package prometheus
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"math"
 	"strings"
+	"sync"
 	"time"
 
 	api "github.com/prometheus/client_golang/api"
@@ -29,29 +31,229 @@ import (
 
 // https://prometheus.io/docs/prometheus/latest/querying/api/
 
+// Endpoint is a Prometheus API endpoint.
+type Endpoint struct {
+	Address string
+	Client  api.Client
+}
+
+// endpoint is a Prometheus API endpoint with its health state.
+type endpoint struct {
+	Endpoint
+
+	// unhealthyUntil is the time until which the endpoint is skipped after an error
+	unhealthyUntil time.Time
+}
+
+// unhealthyEndpointCooldown is the duration an endpoint is skipped after an error.
+const unhealthyEndpointCooldown = 30 * time.Second
+
+// endpointPool round-robins queries across Prometheus endpoints, skipping unhealthy ones.
+type endpointPool struct {
+	mu        sync.Mutex
+	endpoints []endpoint
+	next      int
+}
+
+// order returns the endpoints in the order they should be tried for the next query.
+// Healthy endpoints are tried first in round-robin order, followed by unhealthy ones as a last resort.
+func (p *endpointPool) order() []Endpoint {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	healthy := []Endpoint{}
+	unhealthy := []Endpoint{}
+	for i := range p.endpoints {
+		e := p.endpoints[(p.next+i)%len(p.endpoints)]
+		if now.Before(e.unhealthyUntil) {
+			unhealthy = append(unhealthy, e.Endpoint)
+		} else {
+			healthy = append(healthy, e.Endpoint)
+		}
+	}
+	p.next = (p.next + 1) % len(p.endpoints)
+
+	return append(healthy, unhealthy...)
+}
+
+// markUnhealthy skips the endpoint for the next queries until the cooldown is over.
+func (p *endpointPool) markUnhealthy(address string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for i := range p.endpoints {
+		if p.endpoints[i].Address == address {
+			p.endpoints[i].unhealthyUntil = time.Now().Add(unhealthyEndpointCooldown)
+		}
+	}
+}
+
 // Service is prometheus service.
 type Service struct {
-	promapiClient api.Client
+	endpoints *endpointPool
+
+	// cache of query results, disabled if nil
+	cache *queryCache
+	// querySlots limits the concurrent queries across the jobs sharing the service, unlimited if nil
+	querySlots querySemaphore
+
+	// trafficAggregation of the bandwidth samples of a query window, and the smoothing factor of TrafficAggregationEMA
+	trafficAggregation string
+	emaAlpha           float64
+	// trafficMinBitsPerSecond filters out lower traffic, disabled if zero
+	trafficMinBitsPerSecond float64
+	// trafficPerInstanceHostgroups traffic is also queried per planet-exporter instance
+	trafficPerInstanceHostgroups []string
+	// trafficSocketstat includes the planet_traffic_bytes_total{source="socketstat"} series, excluded by default
+	trafficSocketstat bool
+
+	// source of the traffic and dependency series, SourceQuery or SourceVMExport
+	source string
 }
 
-// New returns a prometheus client service.
-func New(promapiClient api.Client) Service {
+// New returns a prometheus client service that fails over across the given endpoints.
+func New(endpoints ...Endpoint) Service {
+	pool := &endpointPool{
+		mu:        sync.Mutex{},
+		endpoints: []endpoint{},
+		next:      0,
+	}
+	for _, e := range endpoints {
+		pool.endpoints = append(pool.endpoints, endpoint{Endpoint: e, unhealthyUntil: time.Time{}})
+	}
+
 	return Service{
-		promapiClient: promapiClient,
+		endpoints:               pool,
+		cache:                   nil,
+		querySlots:              nil,
+		trafficAggregation:      TrafficAggregationMax,
+		emaAlpha:                DefaultEMAAlpha,
+		trafficMinBitsPerSecond: DefaultTrafficMinBitsPerSecond,
+
+		trafficPerInstanceHostgroups: nil,
+		trafficSocketstat:            false,
+
+		source: SourceQuery,
 	}
 }
 
+// WithQueryCache returns a copy of the service that caches up to maxEntries query results for ttl.
+// Identical queries over the same time window (e.g. job retries) are served from the cache.
+func (s Service) WithQueryCache(ttl time.Duration, maxEntries int) Service {
+	s.cache = newQueryCache(ttl, maxEntries)
+
+	return s
+}
+
+// WithQueryConcurrency returns a copy of the service that runs up to concurrency queries at once,
+// across the copies made afterwards (e.g. shared by the jobs). Other queries wait for a free slot.
+func (s Service) WithQueryConcurrency(concurrency int) Service {
+	s.querySlots = newQuerySemaphore(concurrency)
+
+	return s
+}
+
+// WithTrafficPerInstanceHostgroups returns a copy of the service that also queries the traffic of the given hostgroups
+// per planet-exporter instance, with an additional query. Other hostgroups are only queried per hostgroup.
+func (s Service) WithTrafficPerInstanceHostgroups(hostgroups []string) Service {
+	s.trafficPerInstanceHostgroups = hostgroups
+
+	return s
+}
+
+// WithSocketstatTraffic returns a copy of the service that includes the socketstat traffic series in the traffic bandwidth.
+// They're excluded by default, since summed with the darkstat/ebpf traffic of the same hosts they'd count it twice.
+func (s Service) WithSocketstatTraffic() Service {
+	s.trafficSocketstat = true
+
+	return s
+}
+
+// cached returns the cached result of a query, or runs f and caches its result.
+func (s Service) cached(key queryCacheKey, f func() (model.Value, error)) (model.Value, error) {
+	if s.cache == nil {
+		return f()
+	}
+
+	if results, ok := s.cache.get(key); ok {
+		return results, nil
+	}
+
+	results, err := f()
+	if err != nil {
+		return nil, err
+	}
+	s.cache.set(key, results)
+
+	return results, nil
+}
+
+// ErrNoEndpoints no Prometheus endpoint is configured.
+var ErrNoEndpoints = errors.New("no prometheus endpoint is configured")
+
+// withFailover calls f with every endpoint's API until one succeeds, marking failed endpoints unhealthy.
+func (s Service) withFailover(ctx context.Context, f func(context.Context, v1.API) (model.Value, v1.Warnings, error)) (model.Value, v1.Warnings, error) {
+	return s.withEndpointFailover(ctx, func(ctx context.Context, e Endpoint) (model.Value, v1.Warnings, error) {
+		return f(ctx, v1.NewAPI(e.Client))
+	})
+}
+
+// withEndpointFailover calls f with every endpoint until one succeeds, marking failed endpoints unhealthy.
+func (s Service) withEndpointFailover(ctx context.Context, f func(context.Context, Endpoint) (model.Value, v1.Warnings, error)) (model.Value, v1.Warnings, error) {
+	const contextTimeoutSeconds = 120
+
+	endpoints := s.endpoints.order()
+	if len(endpoints) == 0 {
+		return nil, nil, ErrNoEndpoints
+	}
+
+	waited, err := s.querySlots.acquire(ctx)
+	querySlotWaitSecondsTotal.Add(waited.Seconds())
+	if err != nil {
+		return nil, nil, err
+	}
+	defer s.querySlots.release()
+
+	for _, e := range endpoints {
+		attemptCtx, cancel := context.WithTimeout(ctx, contextTimeoutSeconds*time.Second)
+		var results model.Value
+		var warnings v1.Warnings
+		results, warnings, err = f(attemptCtx, e)
+		cancel()
+		if err == nil {
+			queriesTotal.WithLabelValues(e.Address, "success").Inc()
+
+			return results, warnings, nil
+		}
+
+		queriesTotal.WithLabelValues(e.Address, "error").Inc()
+		if ctx.Err() != nil {
+			// Parent context is done, there's no point in trying other endpoints
+			break
+		}
+		log.Warnf("Query on Prometheus endpoint %v failed, marking it unhealthy: %v", e.Address, err)
+		s.endpoints.markUnhealthy(e.Address)
+	}
+
+	return nil, nil, err
+}
+
 // TODO: Return explicit vector
 // nolint:unused
 func (s Service) query(ctx context.Context, query string, qTime time.Time) (model.Value, error) {
-	const contextTimeoutSeconds = 120
-	ctx, cancel := context.WithTimeout(ctx, contextTimeoutSeconds*time.Second)
-	defer cancel()
-
-	v1api := v1.NewAPI(s.promapiClient)
+	return s.cached(newQueryCacheKey(query, qTime, qTime), func() (model.Value, error) {
+		return s.queryUncached(ctx, query, qTime)
+	})
+}
 
+func (s Service) queryUncached(ctx context.Context, query string, qTime time.Time) (model.Value, error) {
 	log.Debugf("Query %v", query)
-	results, warnings, err := v1api.Query(ctx, query, qTime)
+	startTime := time.Now()
+	results, warnings, err := s.withFailover(ctx, func(ctx context.Context, v1api v1.API) (model.Value, v1.Warnings, error) {
+		return v1api.Query(ctx, query, qTime)
+	})
+	recordQueryStats(ctx, time.Since(startTime), results, len(warnings))
 	if err != nil {
 		return nil, fmt.Errorf("error on query: %w", err)
 	}
@@ -64,21 +266,29 @@ func (s Service) query(ctx context.Context, query string, qTime time.Time) (mode
 	return results, nil
 }
 
+// queryRangeStep is the resolution of range queries.
+const queryRangeStep = time.Minute
+
 // TODO: Return explicit matrix.
 func (s Service) queryRange(ctx context.Context, query string,
 	qStartTime time.Time, qEndTime time.Time) (model.Value, error) {
-	const contextTimeoutSeconds = 120
-	ctx, cancel := context.WithTimeout(ctx, contextTimeoutSeconds*time.Second)
-	defer cancel()
-
-	v1api := v1.NewAPI(s.promapiClient)
+	return s.cached(newQueryCacheKey(query, qStartTime, qEndTime), func() (model.Value, error) {
+		return s.queryRangeUncached(ctx, query, qStartTime, qEndTime)
+	})
+}
 
+func (s Service) queryRangeUncached(ctx context.Context, query string,
+	qStartTime time.Time, qEndTime time.Time) (model.Value, error) {
 	log.Debugf("Query %v", query)
-	results, warnings, err := v1api.QueryRange(ctx, query, v1.Range{
-		Start: qStartTime,
-		End:   qEndTime,
-		Step:  1 * time.Minute,
+	startTime := time.Now()
+	results, warnings, err := s.withFailover(ctx, func(ctx context.Context, v1api v1.API) (model.Value, v1.Warnings, error) {
+		return v1api.QueryRange(ctx, query, v1.Range{
+			Start: qStartTime,
+			End:   qEndTime,
+			Step:  queryRangeStep,
+		})
 	})
+	recordQueryStats(ctx, time.Since(startTime), results, len(warnings))
 	if err != nil {
 		return nil, fmt.Errorf("error on queryRange: %w", err)
 	}
@@ -130,3 +340,18 @@ func (s Service) getMaxValueFromSamplePairs(samplePairs []model.SamplePair) floa
 
 	return maxi
 }
+
+// getEMAValueFromSamplePairs returns the exponential moving average of the samples in time order,
+// seeded with the first sample. The newest sample weighs alpha, and each older sample (1 - alpha) times less.
+func (s Service) getEMAValueFromSamplePairs(samplePairs []model.SamplePair, alpha float64) float64 {
+	if len(samplePairs) == 0 {
+		return -1
+	}
+
+	ema := float64(samplePairs[0].Value)
+	for _, v := range samplePairs[1:] {
+		ema = alpha*float64(v.Value) + (1-alpha)*ema
+	}
+
+	return ema
+}
@@ -0,0 +1,114 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	api "github.com/prometheus/client_golang/api"
+)
+
+// mockPrometheusServer returns a Prometheus API server that responds with an empty matrix or an error.
+func mockPrometheusServer(healthy bool, requests *int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*requests++
+		if !healthy {
+			w.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"status":"success","data":{"resultType":"matrix","result":[]}}`)
+	}))
+}
+
+// mockEndpoint returns an Endpoint for the given mock server.
+func mockEndpoint(t *testing.T, server *httptest.Server) Endpoint {
+	t.Helper()
+
+	client, err := api.NewClient(api.Config{Address: server.URL}) // nolint:exhaustivestruct
+	if err != nil {
+		t.Fatalf("api.NewClient() error = %v", err)
+	}
+
+	return Endpoint{Address: server.URL, Client: client}
+}
+
+func TestService_queryRange_failover(t *testing.T) {
+	var unhealthyRequests, healthyRequests int
+	unhealthyServer := mockPrometheusServer(false, &unhealthyRequests)
+	defer unhealthyServer.Close()
+	healthyServer := mockPrometheusServer(true, &healthyRequests)
+	defer healthyServer.Close()
+
+	s := New(mockEndpoint(t, unhealthyServer), mockEndpoint(t, healthyServer))
+
+	for i := 0; i < 4; i++ {
+		if _, err := s.queryRange(context.Background(), "up", time.Now().Add(-time.Minute), time.Now()); err != nil {
+			t.Errorf("Service.queryRange() error = %v", err)
+		}
+	}
+	if healthyRequests != 4 {
+		t.Errorf("Service.queryRange() healthy endpoint requests = %v, want 4", healthyRequests)
+	}
+	if unhealthyRequests != 1 {
+		t.Errorf("Service.queryRange() unhealthy endpoint requests = %v, want 1 before it's marked unhealthy", unhealthyRequests)
+	}
+}
+
+func TestService_queryRange_allEndpointsFail(t *testing.T) {
+	var requests int
+	unhealthyServer := mockPrometheusServer(false, &requests)
+	defer unhealthyServer.Close()
+
+	s := New(mockEndpoint(t, unhealthyServer))
+
+	if _, err := s.queryRange(context.Background(), "up", time.Now().Add(-time.Minute), time.Now()); err == nil {
+		t.Errorf("Service.queryRange() error = nil, want error")
+	}
+	// The only endpoint is still tried as a last resort even though it's unhealthy
+	if _, err := s.queryRange(context.Background(), "up", time.Now().Add(-time.Minute), time.Now()); err == nil {
+		t.Errorf("Service.queryRange() error = nil, want error")
+	}
+	if requests != 2 {
+		t.Errorf("Service.queryRange() requests = %v, want 2", requests)
+	}
+}
+
+func TestService_queryRange_cache(t *testing.T) {
+	var requests int
+	server := mockPrometheusServer(true, &requests)
+	defer server.Close()
+
+	s := New(mockEndpoint(t, server)).WithQueryCache(time.Minute, 10)
+
+	endTime := time.Now()
+	for i := 0; i < 3; i++ {
+		if _, err := s.queryRange(context.Background(), "up", endTime.Add(-time.Minute), endTime); err != nil {
+			t.Errorf("Service.queryRange() error = %v", err)
+		}
+	}
+	if _, err := s.queryRange(context.Background(), "down", endTime.Add(-time.Minute), endTime); err != nil {
+		t.Errorf("Service.queryRange() error = %v", err)
+	}
+	if requests != 2 {
+		t.Errorf("Service.queryRange() requests = %v, want 2 with identical queries served from the cache", requests)
+	}
+}
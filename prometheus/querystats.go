@@ -0,0 +1,91 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+// QueryStats of the Prometheus queries of a job run. Queries served from the query cache aren't counted,
+// since they don't cost Prometheus anything.
+type QueryStats struct {
+	// Queries sent to Prometheus, including the ones that failed
+	Queries int
+	// Duration of the queries, including the failover to other endpoints and the wait for a query slot
+	Duration time.Duration
+	// Series returned by the queries
+	Series int
+	// Warnings returned by the queries
+	Warnings int
+}
+
+// QueryStatsRecorder accumulates the QueryStats of the queries made with its context. It's safe for concurrent use.
+type QueryStatsRecorder struct {
+	mu    sync.Mutex
+	stats QueryStats
+}
+
+// queryStatsContextKey is the context key of a QueryStatsRecorder.
+type queryStatsContextKey struct{}
+
+// WithQueryStats returns a copy of ctx whose queries are recorded by the returned recorder.
+func WithQueryStats(ctx context.Context) (context.Context, *QueryStatsRecorder) {
+	recorder := &QueryStatsRecorder{} // nolint:exhaustivestruct
+
+	return context.WithValue(ctx, queryStatsContextKey{}, recorder), recorder
+}
+
+// Stats returns the stats recorded so far.
+func (r *QueryStatsRecorder) Stats() QueryStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.stats
+}
+
+// recordQueryStats records a query in the recorder of ctx, if any.
+func recordQueryStats(ctx context.Context, duration time.Duration, results model.Value, warnings int) {
+	recorder, ok := ctx.Value(queryStatsContextKey{}).(*QueryStatsRecorder)
+	if !ok {
+		return
+	}
+
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+
+	recorder.stats.Queries++
+	recorder.stats.Duration += duration
+	recorder.stats.Series += seriesCount(results)
+	recorder.stats.Warnings += warnings
+}
+
+// seriesCount returns the number of series in a query result.
+func seriesCount(results model.Value) int {
+	switch v := results.(type) {
+	case model.Matrix:
+		return len(v)
+	case model.Vector:
+		return len(v)
+	case nil:
+		return 0
+	}
+
+	// Scalar and string results are a single value
+	return 1
+}
@@ -0,0 +1,54 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWithQueryStats(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"status":"success","warnings":["results truncated"],"data":{"resultType":"matrix","result":[
+			{"metric":{"job":"a"},"values":[[1622541600,"1"]]},
+			{"metric":{"job":"b"},"values":[[1622541600,"1"]]}
+		]}}`)
+	}))
+	defer server.Close()
+
+	s := New(mockEndpoint(t, server)).WithQueryCache(time.Minute, 10)
+	ctx, recorder := WithQueryStats(context.Background())
+	endTime := time.Now()
+	for _, query := range []string{"up", "up", "down"} {
+		if _, err := s.queryRange(ctx, query, endTime.Add(-time.Minute), endTime); err != nil {
+			t.Fatalf("Service.queryRange() error = %v", err)
+		}
+	}
+	// Queries without a recorder aren't recorded
+	if _, err := s.queryRange(context.Background(), "other", endTime.Add(-time.Minute), endTime); err != nil {
+		t.Fatalf("Service.queryRange() error = %v", err)
+	}
+
+	// The cached query doesn't count
+	got := recorder.Stats()
+	if got.Queries != 2 || got.Series != 4 || got.Warnings != 2 || got.Duration <= 0 {
+		t.Errorf("QueryStatsRecorder.Stats() = %+v, want 2 queries of 4 series and 2 warnings", got)
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// querySemaphore limits the number of concurrent Prometheus queries, a nil querySemaphore doesn't limit them.
+// Copies of a querySemaphore share the same slots.
+type querySemaphore chan struct{}
+
+// newQuerySemaphore returns a querySemaphore of concurrency slots, nil if concurrency isn't positive.
+func newQuerySemaphore(concurrency int) querySemaphore {
+	if concurrency <= 0 {
+		return nil
+	}
+
+	return make(querySemaphore, concurrency)
+}
+
+// acquire blocks until a query slot is free or ctx is done. It returns the time spent waiting.
+// A successful acquire must be followed by a release.
+func (s querySemaphore) acquire(ctx context.Context) (time.Duration, error) {
+	if s == nil {
+		return 0, nil
+	}
+
+	select {
+	case s <- struct{}{}:
+		return 0, nil
+	default:
+	}
+
+	start := time.Now()
+	select {
+	case s <- struct{}{}:
+		return time.Since(start), nil
+
+	case <-ctx.Done():
+		return time.Since(start), fmt.Errorf("error waiting for a Prometheus query slot: %w", ctx.Err())
+	}
+}
+
+// release frees the query slot taken by acquire.
+func (s querySemaphore) release() {
+	if s == nil {
+		return
+	}
+
+	<-s
+}
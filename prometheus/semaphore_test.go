@@ -0,0 +1,92 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_querySemaphore(t *testing.T) {
+	const concurrency = 2
+	semaphore := newQuerySemaphore(concurrency)
+
+	var running, maxRunning atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := semaphore.acquire(context.Background()); err != nil {
+				t.Errorf("querySemaphore.acquire() error = %v, want nil", err)
+
+				return
+			}
+			defer semaphore.release()
+
+			current := running.Add(1)
+			for {
+				previous := maxRunning.Load()
+				if current <= previous || maxRunning.CompareAndSwap(previous, current) {
+					break
+				}
+			}
+			time.Sleep(time.Millisecond)
+			running.Add(-1)
+		}()
+	}
+	wg.Wait()
+
+	if got := maxRunning.Load(); got > concurrency {
+		t.Errorf("querySemaphore concurrent queries = %v, want at most %v", got, concurrency)
+	}
+}
+
+func Test_querySemaphore_contextDone(t *testing.T) {
+	semaphore := newQuerySemaphore(1)
+	if _, err := semaphore.acquire(context.Background()); err != nil {
+		t.Fatalf("querySemaphore.acquire() error = %v, want nil", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	waited, err := semaphore.acquire(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("querySemaphore.acquire() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if waited <= 0 {
+		t.Errorf("querySemaphore.acquire() waited = %v, want > 0", waited)
+	}
+
+	// The slot is free again once released
+	semaphore.release()
+	if _, err := semaphore.acquire(context.Background()); err != nil {
+		t.Errorf("querySemaphore.acquire() after release error = %v, want nil", err)
+	}
+}
+
+func Test_querySemaphore_unlimited(t *testing.T) {
+	semaphore := newQuerySemaphore(0)
+	for i := 0; i < 100; i++ {
+		if waited, err := semaphore.acquire(context.Background()); waited != 0 || err != nil {
+			t.Errorf("querySemaphore.acquire() = %v, %v, want 0, nil", waited, err)
+		}
+	}
+	semaphore.release()
+}
@@ -0,0 +1,61 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus
+
+import (
+	"net"
+	"net/http"
+	"time"
+)
+
+// TransportConfig contains HTTP transport options for the Prometheus API client.
+type TransportConfig struct {
+	DialTimeout           time.Duration
+	TLSHandshakeTimeout   time.Duration
+	IdleConnTimeout       time.Duration
+	ResponseHeaderTimeout time.Duration
+	// ProxyFromEnvironment uses HTTP_PROXY, HTTPS_PROXY, and NO_PROXY environment variables
+	ProxyFromEnvironment bool
+}
+
+// NewTransport returns an HTTP transport tuned for repeated queries against a single Prometheus.
+// Idle connections are kept per host so consecutive range queries reuse connections, and HTTP/2 is
+// attempted where the server supports it.
+func NewTransport(config TransportConfig) *http.Transport {
+	const (
+		maxIdleConns        = 100
+		maxIdleConnsPerHost = 32
+		keepAlive           = 30 * time.Second
+	)
+
+	transport := &http.Transport{ // nolint:exhaustivestruct
+		DialContext: (&net.Dialer{ // nolint:exhaustivestruct
+			Timeout:   config.DialTimeout,
+			KeepAlive: keepAlive,
+		}).DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          maxIdleConns,
+		MaxIdleConnsPerHost:   maxIdleConnsPerHost,
+		IdleConnTimeout:       config.IdleConnTimeout,
+		TLSHandshakeTimeout:   config.TLSHandshakeTimeout,
+		ResponseHeaderTimeout: config.ResponseHeaderTimeout,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+	if config.ProxyFromEnvironment {
+		transport.Proxy = http.ProxyFromEnvironment
+	}
+
+	return transport
+}
@@ -0,0 +1,373 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	api "github.com/prometheus/client_golang/api"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+	log "github.com/sirupsen/logrus"
+)
+
+// https://docs.victoriametrics.com/#how-to-export-data-in-json-line-format
+
+// Sources of the traffic and dependency series.
+const (
+	// SourceQuery runs PromQL range queries
+	SourceQuery = "query"
+	// SourceVMExport exports the raw series from VictoriaMetrics, and evaluates the queries locally
+	SourceVMExport = "vm-export"
+)
+
+const (
+	vmExportEndpoint = "/api/v1/export"
+
+	// trafficRateWindow and dependencyLookback are the range selectors of the traffic and dependency range queries
+	trafficRateWindow  = 30 * time.Second
+	dependencyLookback = 15 * time.Second
+
+	maxExportErrorBodyBytes = 4096
+)
+
+var (
+	// ErrInvalidSource source of the series is unknown.
+	ErrInvalidSource = errors.New("invalid prometheus source, expected 'query' or 'vm-export'")
+	// ErrExportFailed export request got a non-2xx response.
+	ErrExportFailed = errors.New("victoriametrics export failed")
+	// ErrInvalidExport exported series don't have a timestamp for every value.
+	ErrInvalidExport = errors.New("invalid victoriametrics export")
+)
+
+// Labels the traffic bandwidth is summed by, per hostgroup and per planet-exporter instance.
+var (
+	trafficLabels            = []model.LabelName{"direction", "local_hostgroup", "local_domain", "remote_hostgroup", "remote_domain"}
+	perInstanceTrafficLabels = []model.LabelName{"direction", "local_hostgroup", "local_domain", "remote_hostgroup", "remote_domain", "instance"}
+)
+
+// WithSource returns a copy of the service that gets the traffic and dependency series from source (SourceQuery by default).
+// With SourceVMExport, the series of a window are exported from VictoriaMetrics' /api/v1/export, and their rate and
+// max are computed locally, which is much cheaper than range queries for backfills. Other queries are unchanged.
+func (s Service) WithSource(source string) (Service, error) {
+	switch source {
+	case SourceQuery, SourceVMExport:
+	default:
+		return s, fmt.Errorf("%w: %q", ErrInvalidSource, source)
+	}
+	s.source = source
+
+	return s, nil
+}
+
+// exportPlanetExporterTrafficBandwidth is QueryPlanetExporterTrafficBandwidth evaluated on exported series.
+// The per-instance traffic is computed from the same export, without an additional request.
+func (s Service) exportPlanetExporterTrafficBandwidth(ctx context.Context, startTime, endTime time.Time, directions []string) ([]PlanetExporterTrafficBandwidth, error) {
+	selector := fmt.Sprintf(`planet_traffic_bytes_total{local_hostgroup!="", remote_ip!~"%v", remote_domain!~"%v", remote_hostgroup!=""%v%v}`,
+		regexExcludedAddresses, regexExcludedAddresses, directionMatcher(directions), s.trafficSourceMatcher())
+	series, err := s.export(ctx, selector, startTime.Add(-trafficRateWindow), endTime)
+	if err != nil {
+		return nil, err
+	}
+
+	trafficBandwidthData := s.trafficBandwidthOfSeries(series, trafficLabels, startTime, endTime)
+	if len(s.trafficPerInstanceHostgroups) == 0 {
+		return trafficBandwidthData, nil
+	}
+
+	perInstanceHostgroups := make(map[model.LabelValue]bool, len(s.trafficPerInstanceHostgroups))
+	for _, hostgroup := range s.trafficPerInstanceHostgroups {
+		perInstanceHostgroups[model.LabelValue(hostgroup)] = true
+	}
+	perInstanceSeries := model.Matrix{}
+	for _, stream := range series {
+		if perInstanceHostgroups[stream.Metric["local_hostgroup"]] {
+			perInstanceSeries = append(perInstanceSeries, stream)
+		}
+	}
+
+	return append(trafficBandwidthData, s.trafficBandwidthOfSeries(perInstanceSeries, perInstanceTrafficLabels, startTime, endTime)...), nil
+}
+
+// trafficBandwidthOfSeries evaluates the traffic range query: the irate of the series in bits per second at every
+// step of the window, summed by labels and filtered by the minimum bandwidth, and then reduced by the traffic aggregation.
+func (s Service) trafficBandwidthOfSeries(series model.Matrix, labels []model.LabelName, startTime, endTime time.Time) []PlanetExporterTrafficBandwidth {
+	steps := rangeSteps(startTime, endTime)
+
+	type trafficSum struct {
+		metric  model.Metric
+		sums    []float64
+		present []bool
+	}
+	sums := make(map[model.Fingerprint]*trafficSum)
+	for _, stream := range series {
+		metric := metricOfLabels(stream.Metric, labels)
+		sum, ok := sums[metric.Fingerprint()]
+		if !ok {
+			sum = &trafficSum{metric: metric, sums: make([]float64, len(steps)), present: make([]bool, len(steps))}
+			sums[metric.Fingerprint()] = sum
+		}
+		for i, step := range steps {
+			if rate, ok := irate(stream.Values, step, trafficRateWindow); ok {
+				sum.sums[i] += rate
+				sum.present[i] = true
+			}
+		}
+	}
+
+	traffic := model.Matrix{}
+	for _, sum := range sums {
+		stream := &model.SampleStream{Metric: sum.metric} // nolint:exhaustivestruct
+		for i, step := range steps {
+			bitsPerSecond := sum.sums[i] * 8
+			if !sum.present[i] || (s.trafficMinBitsPerSecond > 0 && bitsPerSecond <= s.trafficMinBitsPerSecond) {
+				continue
+			}
+			stream.Values = append(stream.Values, model.SamplePair{Timestamp: step, Value: model.SampleValue(bitsPerSecond)})
+		}
+		if len(stream.Values) > 0 {
+			traffic = append(traffic, stream)
+		}
+	}
+	sort.Sort(traffic)
+
+	trafficBandwidthData := []PlanetExporterTrafficBandwidth{}
+	for _, stream := range traffic {
+		trafficBandwidthData = append(trafficBandwidthData, PlanetExporterTrafficBandwidth{
+			Direction:              string(stream.Metric["direction"]),
+			LocalHostgroup:         string(stream.Metric["local_hostgroup"]),
+			RemoteHostgroup:        string(stream.Metric["remote_hostgroup"]),
+			LocalDomain:            string(stream.Metric["local_domain"]),
+			RemoteDomain:           string(stream.Metric["remote_domain"]),
+			BandwidthBitsPerSecond: s.aggregateSamplePairs(stream.Values),
+			LocalInstance:          string(stream.Metric["instance"]),
+		})
+	}
+
+	return trafficBandwidthData
+}
+
+// exportPlanetExporterDependencyServices is queryPlanetExporterDependencyServices evaluated on exported series:
+// a dependency is present if one of its series has a sample within the lookback of a step of the window.
+func (s Service) exportPlanetExporterDependencyServices(ctx context.Context, selector string, labels []model.LabelName,
+	portLabel model.LabelName, startTime, endTime time.Time) ([]PlanetExporterDependencyService, error) {
+	series, err := s.export(ctx, selector, startTime.Add(-dependencyLookback), endTime)
+	if err != nil {
+		return nil, err
+	}
+
+	steps := rangeSteps(startTime, endTime)
+	dependencies := model.Matrix{}
+	seen := make(map[model.Fingerprint]bool)
+	for _, stream := range series {
+		if !sampledAtAnyStep(stream.Values, steps, dependencyLookback) {
+			continue
+		}
+		metric := metricOfLabels(stream.Metric, labels)
+		if seen[metric.Fingerprint()] {
+			continue
+		}
+		seen[metric.Fingerprint()] = true
+		dependencies = append(dependencies, &model.SampleStream{Metric: metric}) // nolint:exhaustivestruct
+	}
+	sort.Sort(dependencies)
+
+	return parseDependencyServices(dependencies, portLabel), nil
+}
+
+// rangeSteps returns the evaluation times of a range query over the window.
+func rangeSteps(startTime, endTime time.Time) []model.Time {
+	steps := []model.Time{}
+	for step := startTime; !step.After(endTime); step = step.Add(queryRangeStep) {
+		steps = append(steps, model.TimeFromUnixNano(step.UnixNano()))
+	}
+
+	return steps
+}
+
+// irate returns the per-second rate of a counter between its last two samples within the window before at,
+// as PromQL's irate.
+func irate(samples []model.SamplePair, at model.Time, window time.Duration) (float64, bool) {
+	end := sort.Search(len(samples), func(i int) bool { return samples[i].Timestamp.After(at) })
+	if end < 2 {
+		return 0, false
+	}
+	last, previous := samples[end-1], samples[end-2]
+	if !previous.Timestamp.After(at.Add(-window)) {
+		return 0, false
+	}
+
+	interval := last.Timestamp.Sub(previous.Timestamp).Seconds()
+	if interval <= 0 {
+		return 0, false
+	}
+	delta := float64(last.Value - previous.Value)
+	if delta < 0 {
+		// Counter reset
+		delta = float64(last.Value)
+	}
+
+	return delta / interval, true
+}
+
+// sampledAtAnyStep returns whether there's a sample within the lookback before any of the steps.
+func sampledAtAnyStep(samples []model.SamplePair, steps []model.Time, lookback time.Duration) bool {
+	for _, step := range steps {
+		from := step.Add(-lookback)
+		i := sort.Search(len(samples), func(i int) bool { return samples[i].Timestamp.After(from) })
+		if i < len(samples) && !samples[i].Timestamp.After(step) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// metricOfLabels returns the labels of a metric that a query aggregates by.
+func metricOfLabels(metric model.Metric, labels []model.LabelName) model.Metric {
+	aggregated := make(model.Metric, len(labels))
+	for _, label := range labels {
+		if value := metric[label]; value != "" {
+			aggregated[label] = value
+		}
+	}
+
+	return aggregated
+}
+
+// export returns the raw series matching selector with samples within the window.
+func (s Service) export(ctx context.Context, selector string, startTime, endTime time.Time) (model.Matrix, error) {
+	results, err := s.cached(newQueryCacheKey(SourceVMExport+" "+selector, startTime, endTime), func() (model.Value, error) {
+		return s.exportUncached(ctx, selector, startTime, endTime)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return results.(model.Matrix), nil
+}
+
+func (s Service) exportUncached(ctx context.Context, selector string, startTime, endTime time.Time) (model.Value, error) {
+	log.Debugf("Export %v", selector)
+	queryStartTime := time.Now()
+	results, _, err := s.withEndpointFailover(ctx, func(ctx context.Context, e Endpoint) (model.Value, v1.Warnings, error) {
+		series, err := exportSeries(ctx, e.Client, selector, startTime, endTime)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return series, nil, nil
+	})
+	recordQueryStats(ctx, time.Since(queryStartTime), results, 0)
+	if err != nil {
+		return nil, fmt.Errorf("error on export: %w", err)
+	}
+
+	return results, nil
+}
+
+// exportSeries requests the series matching selector within the window from a VictoriaMetrics endpoint.
+func exportSeries(ctx context.Context, client api.Client, selector string, startTime, endTime time.Time) (model.Matrix, error) {
+	u := client.URL(vmExportEndpoint, nil)
+	u.RawQuery = url.Values{
+		"match[]": {selector},
+		"start":   {formatExportTime(startTime)},
+		"end":     {formatExportTime(endTime)},
+	}.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating export request: %w", err)
+	}
+
+	resp, body, err := client.Do(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		if len(body) > maxExportErrorBodyBytes {
+			body = body[:maxExportErrorBodyBytes]
+		}
+
+		return nil, fmt.Errorf("%w with status %v: %s", ErrExportFailed, resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	return parseExport(body)
+}
+
+// formatExportTime formats a time as the fractional unix seconds of the export API.
+func formatExportTime(t time.Time) string {
+	return strconv.FormatFloat(float64(t.UnixNano())/float64(time.Second), 'f', 3, 64)
+}
+
+// exportedSeries is a JSON line of an export.
+type exportedSeries struct {
+	Metric     map[string]string `json:"metric"`
+	Values     []json.RawMessage `json:"values"`
+	Timestamps []int64           `json:"timestamps"`
+}
+
+// parseExport parses the JSON lines of an export into series with samples in time order.
+// A series may be exported in several lines, which are merged.
+func parseExport(body []byte) (model.Matrix, error) {
+	series := model.Matrix{}
+	streams := make(map[model.Fingerprint]*model.SampleStream)
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	for {
+		var line exportedSeries
+		if err := decoder.Decode(&line); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("error decoding export: %w", err)
+		}
+		if len(line.Values) != len(line.Timestamps) {
+			return nil, fmt.Errorf("%w: %v values with %v timestamps", ErrInvalidExport, len(line.Values), len(line.Timestamps))
+		}
+
+		metric := make(model.Metric, len(line.Metric))
+		for name, value := range line.Metric {
+			metric[model.LabelName(name)] = model.LabelValue(value)
+		}
+		stream, ok := streams[metric.Fingerprint()]
+		if !ok {
+			stream = &model.SampleStream{Metric: metric} // nolint:exhaustivestruct
+			streams[metric.Fingerprint()] = stream
+			series = append(series, stream)
+		}
+		for i, rawValue := range line.Values {
+			value, err := strconv.ParseFloat(strings.Trim(string(rawValue), `"`), 64)
+			if err != nil {
+				// e.g. a null staleness marker
+				continue
+			}
+			stream.Values = append(stream.Values, model.SamplePair{Timestamp: model.Time(line.Timestamps[i]), Value: model.SampleValue(value)})
+		}
+	}
+	for _, stream := range series {
+		sort.Slice(stream.Values, func(i, j int) bool { return stream.Values[i].Timestamp < stream.Values[j].Timestamp })
+	}
+
+	return series, nil
+}
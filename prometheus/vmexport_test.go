@@ -0,0 +1,189 @@
+// Copyright 2021 - williamchanrico@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Recorded /api/v1/export payloads of a 1m window starting at 1622541600 (2021-06-01T10:00:00Z), scraped every 15s.
+// The app-2 traffic series is exported in two lines, and its counter resets.
+const (
+	recordedTrafficExport = `{"metric":{"__name__":"planet_traffic_bytes_total","direction":"egress","instance":"app-1:19100","local_hostgroup":"app","local_domain":"app.local","remote_hostgroup":"db","remote_domain":"db.local","remote_ip":"10.0.0.5"},"values":[0,15000,30000,45000,60000,75000,90000],"timestamps":[1622541570000,1622541585000,1622541600000,1622541615000,1622541630000,1622541645000,1622541660000]}
+{"metric":{"__name__":"planet_traffic_bytes_total","direction":"egress","instance":"app-2:19100","local_hostgroup":"app","local_domain":"app.local","remote_hostgroup":"db","remote_domain":"db.local","remote_ip":"10.0.0.5"},"values":[0,30000,60000],"timestamps":[1622541570000,1622541585000,1622541600000]}
+{"metric":{"__name__":"planet_traffic_bytes_total","direction":"egress","instance":"app-2:19100","local_hostgroup":"app","local_domain":"app.local","remote_hostgroup":"db","remote_domain":"db.local","remote_ip":"10.0.0.5"},"values":[90000,120000,15000,45000],"timestamps":[1622541615000,1622541630000,1622541645000,1622541660000]}
+{"metric":{"__name__":"planet_traffic_bytes_total","direction":"egress","instance":"web-1:19100","local_hostgroup":"web","local_domain":"web.local","remote_hostgroup":"app","remote_domain":"app.local","remote_ip":"10.0.0.1"},"values":[0,15,30],"timestamps":[1622541600000,1622541615000,1622541630000]}
+`
+	recordedUpstreamExport = `{"metric":{"__name__":"planet_upstream","instance":"app-1:19100","local_hostgroup":"app","local_address":"app.local","remote_hostgroup":"db","remote_address":"db.local","port":"5432","process_name":"app","protocol":"tcp"},"values":[1,1],"timestamps":[1622541645000,1622541660000]}
+{"metric":{"__name__":"planet_upstream","instance":"app-2:19100","local_hostgroup":"app","local_address":"app.local","remote_hostgroup":"db","remote_address":"db.local","remote_port":"5432","process_name":"app","protocol":"tcp"},"values":[1],"timestamps":[1622541660000]}
+{"metric":{"__name__":"planet_upstream","instance":"app-1:19100","local_hostgroup":"app","local_address":"app.local","remote_hostgroup":"cache","remote_address":"cache.local","remote_port":"6379","process_name":"app","protocol":"tcp"},"values":[1],"timestamps":[1622541620000]}
+`
+)
+
+// mockVMExportServer returns a VictoriaMetrics export server responding with the payload of each metric name.
+func mockVMExportServer(t *testing.T, payloads map[string]string, requests *[]string) *httptest.Server {
+	t.Helper()
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != vmExportEndpoint {
+			http.NotFound(w, r)
+
+			return
+		}
+		selector := r.URL.Query().Get("match[]")
+		*requests = append(*requests, fmt.Sprintf("%v %v-%v", selector, r.URL.Query().Get("start"), r.URL.Query().Get("end")))
+		for name, payload := range payloads {
+			if strings.HasPrefix(selector, name+"{") {
+				fmt.Fprint(w, payload)
+
+				return
+			}
+		}
+	}))
+}
+
+func TestService_exportPlanetExporterTrafficBandwidth(t *testing.T) {
+	var requests []string
+	server := mockVMExportServer(t, map[string]string{"planet_traffic_bytes_total": recordedTrafficExport}, &requests)
+	defer server.Close()
+
+	s, err := New(mockEndpoint(t, server)).WithTrafficPerInstanceHostgroups([]string{"app"}).WithSource(SourceVMExport)
+	if err != nil {
+		t.Fatalf("Service.WithSource() error = %v", err)
+	}
+	startTime := time.Unix(1622541600, 0)
+	got, err := s.QueryPlanetExporterTrafficBandwidth(context.Background(), startTime, startTime.Add(time.Minute), []string{"egress"})
+	if err != nil {
+		t.Fatalf("Service.QueryPlanetExporterTrafficBandwidth() error = %v", err)
+	}
+
+	// app-1 sends 1000 B/s, app-2 sends 2000 B/s until its counter resets to 15000 B over 15s.
+	// The web traffic of 1 B/s is below the minimum bandwidth.
+	trafficBandwidth := func(instance string, bitsPerSecond float64) PlanetExporterTrafficBandwidth {
+		return PlanetExporterTrafficBandwidth{
+			LocalHostgroup: "app", LocalDomain: "app.local", RemoteHostgroup: "db", RemoteDomain: "db.local",
+			Direction: "egress", BandwidthBitsPerSecond: bitsPerSecond, LocalInstance: instance,
+		}
+	}
+	want := []PlanetExporterTrafficBandwidth{
+		trafficBandwidth("", 24000),
+		trafficBandwidth("app-1:19100", 8000),
+		trafficBandwidth("app-2:19100", 16000),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Service.QueryPlanetExporterTrafficBandwidth() = %+v, want %+v", got, want)
+	}
+
+	wantRequests := []string{
+		`planet_traffic_bytes_total{local_hostgroup!="", remote_ip!~"` + regexExcludedAddresses + `", remote_domain!~"` + regexExcludedAddresses +
+			`", remote_hostgroup!="", direction=~"egress", source!="socketstat"} 1622541570.000-1622541660.000`,
+	}
+	if !reflect.DeepEqual(requests, wantRequests) {
+		t.Errorf("Service.QueryPlanetExporterTrafficBandwidth() requests = %v, want a single export %v", requests, wantRequests)
+	}
+}
+
+func TestService_exportPlanetExporterUpstreamServices(t *testing.T) {
+	var requests []string
+	server := mockVMExportServer(t, map[string]string{"planet_upstream": recordedUpstreamExport}, &requests)
+	defer server.Close()
+
+	s, err := New(mockEndpoint(t, server)).WithSource(SourceVMExport)
+	if err != nil {
+		t.Fatalf("Service.WithSource() error = %v", err)
+	}
+	startTime := time.Unix(1622541600, 0)
+	got, err := s.QueryPlanetExporterUpstreamServices(context.Background(), startTime, startTime.Add(time.Minute))
+	if err != nil {
+		t.Fatalf("Service.QueryPlanetExporterUpstreamServices() error = %v", err)
+	}
+
+	// The old and new exporter series of the db dependency are deduplicated, and the cache dependency
+	// isn't sampled within 15s of a step.
+	want := []PlanetExporterDependencyService{
+		{
+			LocalHostgroup: "app", LocalAddress: "app.local", LocalProcessName: "app", Port: "5432",
+			RemoteHostgroup: "db", RemoteAddress: "db.local", Protocol: "tcp",
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Service.QueryPlanetExporterUpstreamServices() = %+v, want %+v", got, want)
+	}
+	if len(requests) != 1 || !strings.HasPrefix(requests[0], upstreamSelector) {
+		t.Errorf("Service.QueryPlanetExporterUpstreamServices() requests = %v, want a single export of %v", requests, upstreamSelector)
+	}
+}
+
+func TestService_export_errors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "cannot parse match[]", http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	s, _ := New(mockEndpoint(t, server)).WithSource(SourceVMExport)
+	_, err := s.QueryPlanetExporterDownstreamServices(context.Background(), time.Now().Add(-time.Minute), time.Now())
+	if !errors.Is(err, ErrExportFailed) || !strings.Contains(err.Error(), "cannot parse match[]") {
+		t.Errorf("Service.QueryPlanetExporterDownstreamServices() error = %v, want %v with the response body", err, ErrExportFailed)
+	}
+
+	if _, err := New().WithSource("remote-read"); !errors.Is(err, ErrInvalidSource) {
+		t.Errorf("Service.WithSource() error = %v, want %v", err, ErrInvalidSource)
+	}
+}
+
+func Test_parseExport(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        string
+		wantSamples []int
+		wantErr     error
+	}{
+		{name: "Empty export", body: "", wantSamples: []int{}, wantErr: nil},
+		{name: "Series in several lines are merged", body: recordedTrafficExport, wantSamples: []int{7, 7, 3}, wantErr: nil},
+		{name: "Null values are skipped", body: `{"metric":{"__name__":"up"},"values":[1,null],"timestamps":[1,2]}`, wantSamples: []int{1}, wantErr: nil},
+		{name: "Missing timestamps", body: `{"metric":{"__name__":"up"},"values":[1,2],"timestamps":[1]}`, wantSamples: nil, wantErr: ErrInvalidExport},
+	}
+	for _, testcase := range tests {
+		t.Run(testcase.name, func(t *testing.T) {
+			got, err := parseExport([]byte(testcase.body))
+			if !errors.Is(err, testcase.wantErr) {
+				t.Fatalf("parseExport() error = %v, want %v", err, testcase.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			gotSamples := []int{}
+			for _, stream := range got {
+				gotSamples = append(gotSamples, len(stream.Values))
+				for i := 1; i < len(stream.Values); i++ {
+					if stream.Values[i].Timestamp < stream.Values[i-1].Timestamp {
+						t.Errorf("parseExport() samples of %v aren't in time order", stream.Metric)
+					}
+				}
+			}
+			if !reflect.DeepEqual(gotSamples, testcase.wantSamples) {
+				t.Errorf("parseExport() samples per series = %v, want %v", gotSamples, testcase.wantSamples)
+			}
+		})
+	}
+}